@@ -1490,6 +1490,10 @@ class BackgroundCommandWorker implements CommandWorkerInterface {
     return await doCancelForward(namespace, service, k8sPort);
   }
 
+  async getPortForwardingProblems() {
+    return await k8smanager.getPortForwardingProblems();
+  }
+
   /**
    * Execute the preference update for services that don't require a backend restart.
    */
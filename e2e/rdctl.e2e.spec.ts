@@ -1347,6 +1347,12 @@ test.describe('Command server', () => {
           expect(stderr).toEqual('');
           expect(stdout).toMatch(/\w+/);
         });
+        test('it lists the port forwarding problems', async() => {
+          const { stdout, stderr } = await rdctl(['api', '/v1/port_forwarding/problems']);
+
+          expect(stderr).toEqual('');
+          expect(JSON.parse(stdout)).toEqual(expect.any(Array));
+        });
       });
     });
 
@@ -48,6 +48,20 @@ export interface FailureDetails {
   lastLogLines:       string[],
 }
 
+/**
+ * PortForwardingProblem describes a published port that the host failed to
+ * listen on, typically because another process already uses it.
+ */
+export interface PortForwardingProblem {
+  /** The container port and protocol, e.g. "80/tcp". */
+  port:     string;
+  hostIP:   string;
+  hostPort: string;
+  error:    string;
+  /** When the problem was first reported, as an ISO 8601 timestamp. */
+  since:    string;
+}
+
 /**
  * KubernetesBackendEvents describes the events that may be emitted by a
  * Kubernetes backend (as an EventEmitter).  Each property name is the name of
@@ -174,6 +188,13 @@ export interface VMBackend extends EventEmitter<BackendEvents> {
    */
   getFailureDetails(exception: any): Promise<FailureDetails>;
 
+  /**
+   * Get the published ports that could not be forwarded to the host, as
+   * reported by the guest agent.  Backends that don't forward ports through
+   * the guest agent report none.
+   */
+  getPortForwardingProblems(): Promise<PortForwardingProblem[]>;
+
   /**
    * If true, the backend cannot invoke any dialog boxes and needs to find an alternative.
    */
@@ -335,8 +335,14 @@ export default class WSLKubernetesBackend extends events.EventEmitter implements
       oldConfig,
       newConfig,
       {
-        'kubernetes.ingress.localhostOnly': undefined,
-        'WSL.integrations':                 undefined,
+        'kubernetes.ingress.localhostOnly':        undefined,
+        'kubernetes.options.iptablesScanInterval': undefined,
+        'kubernetes.options.serviceForwarding':    undefined,
+        'portForwarding.relayIPv6Loopback':        undefined,
+        'WSL.integrations':                        undefined,
+        'WSL.subnet':                              undefined,
+        'WSL.mtu':                                 undefined,
+        'WSL.mssClamping':                         undefined,
       },
       extras,
     ));
@@ -25,6 +25,7 @@ import {
   BackendSettings,
   execOptions,
   FailureDetails,
+  PortForwardingProblem,
   RestartReasons,
   State,
   VMBackend,
@@ -2284,6 +2285,12 @@ CREDFWD_URL='http://${ SLIRP.HOST_GATEWAY }:${ stateInfo.port }'
     };
   }
 
+  getPortForwardingProblems(): Promise<PortForwardingProblem[]> {
+    // Lima forwards ports through its host agent, which doesn't report
+    // failures to listen.
+    return Promise.resolve([]);
+  }
+
   // #region Events
   eventNames(): (keyof BackendEvents)[] {
     return super.eventNames() as (keyof BackendEvents)[];
@@ -103,6 +103,10 @@ export default class MockBackend extends events.EventEmitter implements VMExecut
     });
   }
 
+  getPortForwardingProblems() {
+    return Promise.resolve([]);
+  }
+
   lastCommandComment = '';
 
   noModalDialogs = true;
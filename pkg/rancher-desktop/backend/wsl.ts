@@ -20,6 +20,7 @@ import {
   BackendSettings,
   execOptions,
   FailureDetails,
+  PortForwardingProblem,
   RestartReasons,
   State,
   VMBackend,
@@ -48,7 +49,7 @@ import SERVICE_SCRIPT_DOCKERD from '@pkg/assets/scripts/service-wsl-dockerd.init
 import SCRIPT_DATA_WSL_CONF from '@pkg/assets/scripts/wsl-data.conf';
 import WSL_EXEC from '@pkg/assets/scripts/wsl-exec';
 import WSL_INIT_SCRIPT from '@pkg/assets/scripts/wsl-init';
-import { ContainerEngine } from '@pkg/config/settings';
+import { ContainerEngine, defaultSettings } from '@pkg/config/settings';
 import { getServerCredentialsPath, ServerState } from '@pkg/main/credentialServer/httpCredentialHelperServer';
 import { t } from '@pkg/main/i18n';
 import mainEvents from '@pkg/main/mainEvents';
@@ -56,7 +57,7 @@ import BackgroundProcess from '@pkg/utils/backgroundProcess';
 import * as childProcess from '@pkg/utils/childProcess';
 import clone from '@pkg/utils/clone';
 import Logging from '@pkg/utils/logging';
-import { stripNoproxyPrefix } from '@pkg/utils/networks';
+import { stripNoproxyPrefix, virtualNetworkAddresses } from '@pkg/utils/networks';
 import paths from '@pkg/utils/paths';
 import { executable } from '@pkg/utils/resources';
 import { jsonStringifyWithWhiteSpace } from '@pkg/utils/stringify';
@@ -67,6 +68,8 @@ import type { KubernetesBackend } from './k8s';
 /* eslint @typescript-eslint/switch-exhaustiveness-check: "error" */
 
 const console = Logging.wsl;
+/** The socket the guest agent serves its health endpoint on. */
+const GUEST_AGENT_HEALTH_SOCKET = '/run/rancher-desktop-guestagent.sock';
 const INSTANCE_NAME = 'rancher-desktop';
 const DATA_INSTANCE_NAME = 'rancher-desktop-data';
 
@@ -118,12 +121,16 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
       spawn: async() => {
         const exe = path.join(paths.resources, 'win32', 'internal', 'host-switch.exe');
         const stream = await Logging['host-switch'].fdStream;
-        const args: string[] = [];
+        const network = this.virtualNetwork;
+        const args = [
+          '--subnet', network.subnet,
+          '--mtu', `${ this.cfg?.WSL.mtu ?? defaultSettings.WSL.mtu }`,
+          '--lease-file', path.join(paths.appHome, 'dhcp-leases.json'),
+        ];
 
         if (this.cfg?.kubernetes.enabled) {
           const k8sPort = 6443;
-          const eth0IP = '192.168.127.2';
-          const k8sPortForwarding = `127.0.0.1:${ k8sPort }=${ eth0IP }:${ k8sPort }`;
+          const k8sPortForwarding = `127.0.0.1:${ k8sPort }=${ network.vm }:${ k8sPort }`;
 
           args.push('--port-forward', k8sPortForwarding);
         }
@@ -146,6 +153,16 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
   /** The current config state. */
   protected cfg: BackendSettings | undefined;
 
+  /**
+   * The addresses on the virtual network between Windows and the VM, derived
+   * from the configured subnet.  The settings validator rejects invalid
+   * subnets, so this only falls back to the default for hand-edited settings.
+   */
+  protected get virtualNetwork() {
+    return virtualNetworkAddresses(this.cfg?.WSL.subnet ?? defaultSettings.WSL.subnet) ??
+      virtualNetworkAddresses(defaultSettings.WSL.subnet)!;
+  }
+
   /** Indicates whether the current installation is an Admin Install. */
   #isAdminInstall: Promise<boolean> | undefined;
 
@@ -469,8 +486,7 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
    * contents from the data distribution.
    */
   protected async writeHostsFile(config: BackendSettings) {
-    const virtualNetworkStaticAddr = '192.168.127.254';
-    const virtualNetworkGatewayAddr = '192.168.127.1';
+    const { host: virtualNetworkStaticAddr, gateway: virtualNetworkGatewayAddr } = this.virtualNetwork;
 
     await this.progressTracker.action(t('progress.updatingEtcHosts'), 50, async() => {
       const contents = await fs.promises.readFile(`\\\\wsl$\\${ DATA_INSTANCE_NAME }\\etc\\hosts`, 'utf-8');
@@ -824,13 +840,18 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
     const isAdminInstall = await this.getIsAdminInstall();
 
     const guestAgentConfig: Record<string, string> = {
-      LOG_DIR:                  await this.wslify(paths.logs),
-      GUESTAGENT_ADMIN_INSTALL: isAdminInstall ? 'true' : 'false',
-      GUESTAGENT_KUBERNETES:    enableKubernetes ? 'true' : 'false',
-      GUESTAGENT_CONTAINERD:    cfg?.containerEngine.name === ContainerEngine.CONTAINERD ? 'true' : 'false',
-      GUESTAGENT_DOCKER:        cfg?.containerEngine.name === ContainerEngine.MOBY ? 'true' : 'false',
-      GUESTAGENT_DEBUG:         this.debug ? 'true' : 'false',
-      GUESTAGENT_K8S_SVC_ADDR:  isAdminInstall && !cfg?.kubernetes.ingress.localhostOnly ? '0.0.0.0' : '127.0.0.1',
+      LOG_DIR:                       await this.wslify(paths.logs),
+      GUESTAGENT_ADMIN_INSTALL:      isAdminInstall ? 'true' : 'false',
+      GUESTAGENT_KUBERNETES:         enableKubernetes ? 'true' : 'false',
+      GUESTAGENT_CONTAINERD:         cfg?.containerEngine.name === ContainerEngine.CONTAINERD ? 'true' : 'false',
+      GUESTAGENT_DOCKER:             cfg?.containerEngine.name === ContainerEngine.MOBY ? 'true' : 'false',
+      GUESTAGENT_LOG_LEVEL:          this.debug ? 'debug' : 'info',
+      GUESTAGENT_K8S_SVC_ADDR:       isAdminInstall && !cfg?.kubernetes.ingress.localhostOnly ? '0.0.0.0' : '127.0.0.1',
+      GUESTAGENT_K8S_SVC_FORWARDING: cfg?.kubernetes.options.serviceForwarding === false ? 'false' : 'true',
+      GUESTAGENT_IPTABLES_INTERVAL:  `${ cfg?.kubernetes.options.iptablesScanInterval ?? 3 }s`,
+      GUESTAGENT_IPV6_LOOPBACK:      cfg?.portForwarding.relayIPv6Loopback ? 'true' : 'false',
+      GUESTAGENT_HEALTH_ADDR:        `unix://${ GUEST_AGENT_HEALTH_SOCKET }`,
+      GUESTAGENT_TAP_INTERFACE_IP:   this.virtualNetwork.vm,
     };
 
     await Promise.all([
@@ -1084,11 +1105,17 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
     this.process?.kill('SIGTERM');
     const env: Record<string, string> = {
       ...process.env,
-      WSLENV:           `${ process.env.WSLENV }:DISTRO_DATA_DIRS:LOG_DIR/p:RD_DEBUG:RD_VMSWITCH_TRACE`,
+      WSLENV:           `${ process.env.WSLENV }:DISTRO_DATA_DIRS:LOG_DIR/p:RD_DEBUG:RD_VMSWITCH_TRACE:RD_SUBNET:RD_MTU:RD_MSS_CLAMPING`,
       DISTRO_DATA_DIRS: DISTRO_DATA_DIRS.join(':'),
       LOG_DIR:          paths.logs,
+      RD_SUBNET:        this.virtualNetwork.subnet,
+      RD_MTU:           `${ this.cfg?.WSL.mtu ?? defaultSettings.WSL.mtu }`,
     };
 
+    if (this.cfg?.WSL.mssClamping) {
+      env.RD_MSS_CLAMPING = '1';
+    }
+
     if (this.debug) {
       env.RD_DEBUG = '1';
     }
@@ -1766,6 +1793,17 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
     };
   }
 
+  async getPortForwardingProblems(): Promise<PortForwardingProblem[]> {
+    if (this.state !== State.STARTED) {
+      return [];
+    }
+    const output = await this.execCommand({ capture: true },
+      'curl', '--silent', '--fail', '--unix-socket', GUEST_AGENT_HEALTH_SOCKET, 'http://localhost/healthz');
+    const status: { forwardingProblems?: PortForwardingProblem[] | null } = JSON.parse(output);
+
+    return status.forwardingProblems ?? [];
+  }
+
   // #region Events
   eventNames(): (keyof BackendEvents)[] {
     return super.eventNames() as (keyof BackendEvents)[];
@@ -111,16 +111,38 @@ export const defaultSettings = {
       type: process.platform === 'darwin' && parseInt(os.release(), 10) >= 23 ? MountType.VIRTIOFS : MountType.REVERSE_SSHFS,
     },
   },
-  WSL:        { integrations: {} as Record<string, boolean> },
+  WSL:        {
+    integrations: {} as Record<string, boolean>,
+    /** Subnet of the virtual network between Windows and the VM. */
+    subnet:       '192.168.127.0/24',
+    /** MTU of the virtual network between Windows and the VM. */
+    mtu:          1500,
+    /**
+     * Whether to clamp the MSS of TCP connections forwarded through the
+     * virtual network to its MTU, for networks that filter ICMP.
+     */
+    mssClamping:  false,
+  },
   kubernetes: {
     /** The version of Kubernetes to launch, as a semver (without v prefix). */
     version: '',
     port:    6443,
     enabled: true,
-    options: { traefik: true, flannel: true },
+    options: {
+      traefik: true,
+      flannel: true,
+      /** Forward NodePort and LoadBalancer services to the host (WSL only). */
+      serviceForwarding:    true,
+      /** Seconds between scans of iptables for forwarded ports (WSL only). */
+      iptablesScanInterval: 3,
+    },
     ingress: { localhostOnly: false },
   },
-  portForwarding: { includeKubernetesServices: false },
+  portForwarding: {
+    includeKubernetesServices: false,
+    /** Forward listeners that only bind [::1] inside the VM (WSL only). */
+    relayIPv6Loopback:         false,
+  },
   images:         {
     showAll:   true,
     namespace: 'default',
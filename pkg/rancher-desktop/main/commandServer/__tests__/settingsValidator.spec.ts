@@ -15,8 +15,9 @@ import { RecursivePartial } from '@pkg/utils/typeUtils';
 
 const modules = mockModules({
   os: {
-    arch:     jest.spyOn(os, 'arch'),
-    platform: jest.spyOn(os, 'platform'),
+    arch:              jest.spyOn(os, 'arch'),
+    networkInterfaces: jest.spyOn(os, 'networkInterfaces'),
+    platform:          jest.spyOn(os, 'platform'),
   },
   '@pkg/utils/osVersion': {
     getMacOsVersion: jest.fn<() => SemVer>(() => new SemVer('13.5.0')),
@@ -96,6 +97,7 @@ describe('SettingsValidator', () => {
       ['virtualMachine', 'type'],
       ['virtualMachine', 'useRosetta'],
       ['WSL', 'integrations'],
+      ['WSL', 'subnet'],
     ];
 
     // Fields that can only be set on specific platforms.
@@ -108,8 +110,13 @@ describe('SettingsValidator', () => {
       'experimental.virtualMachine.proxy.username':   'win32',
       'experimental.virtualMachine.sshPortForwarder': 'darwin',
       'kubernetes.ingress.localhostOnly':             'win32',
+      'kubernetes.options.iptablesScanInterval':      'win32',
+      'kubernetes.options.serviceForwarding':         'win32',
+      'portForwarding.relayIPv6Loopback':             'win32',
       'virtualMachine.memoryInGB':                    'darwin',
       'virtualMachine.numberCPUs':                    'linux',
+      'WSL.mssClamping':                              'win32',
+      'WSL.mtu':                                      'win32',
     };
 
     const spyValidateSettings = jest.spyOn(subject, 'validateSettings');
@@ -383,6 +390,76 @@ describe('SettingsValidator', () => {
     });
   });
 
+  describe('WSL.subnet', () => {
+    beforeEach(() => {
+      modules.os.platform.mockReturnValue('win32');
+      modules.os.networkInterfaces.mockReturnValue({
+        Ethernet: [
+          {
+            address: '10.1.2.3', netmask: '255.255.0.0', family: 'IPv4', mac: '00:00:00:00:00:01', internal: false, cidr: '10.1.2.3/16',
+          },
+        ],
+        'Loopback Pseudo-Interface 1': [
+          {
+            address: '127.0.0.1', netmask: '255.0.0.0', family: 'IPv4', mac: '00:00:00:00:00:00', internal: true, cidr: '127.0.0.1/8',
+          },
+        ],
+      });
+    });
+    afterEach(() => {
+      modules.os.networkInterfaces.mockRestore();
+    });
+
+    it.each(['172.30.0.0/16', '10.2.0.0/24', '192.168.200.248/29'])('should accept %s', (subnet) => {
+      const [needToUpdate, errors] = subject.validateSettings(cfg, { WSL: { subnet } });
+
+      expect({ needToUpdate, errors }).toEqual({
+        needToUpdate: true,
+        errors:       [],
+      });
+    });
+
+    it.each(['192.168.127.0', '192.168.127.0/30', 'fd00::/64', '127.0.0.0/24', 'not a subnet'])('should reject %s', (subnet) => {
+      const [needToUpdate, errors, isFatal] = subject.validateSettings(cfg, { WSL: { subnet } });
+
+      expect({ needToUpdate, errors, isFatal }).toEqual({
+        needToUpdate: false,
+        errors:       [`Invalid value for "WSL.subnet": <${ JSON.stringify(subnet) }>; must be an IPv4 subnet such as 192.168.127.0/24, with a prefix length of at most 29`],
+        isFatal:      false,
+      });
+    });
+
+    it('should reject subnets overlapping a host network', () => {
+      const [needToUpdate, errors] = subject.validateSettings(cfg, { WSL: { subnet: '10.1.128.0/20' } });
+
+      expect({ needToUpdate, errors }).toEqual({
+        needToUpdate: false,
+        errors:       ['Setting "WSL.subnet" to 10.1.128.0/20 overlaps the host network 10.1.2.3/16.'],
+      });
+    });
+
+    it('should not check the current subnet for overlaps', () => {
+      const current = _.merge({}, cfg, { WSL: { subnet: '10.1.0.0/24' } });
+      const [needToUpdate, errors] = subject.validateSettings(current, { WSL: { subnet: '10.1.0.0/24' } });
+
+      expect({ needToUpdate, errors }).toEqual({
+        needToUpdate: false,
+        errors:       [],
+      });
+    });
+
+    it('should reject being set on non-Windows', () => {
+      modules.os.platform.mockReturnValue('linux');
+      const [needToUpdate, errors, isFatal] = subject.validateSettings(cfg, { WSL: { subnet: '172.30.0.0/16' } });
+
+      expect({ needToUpdate, errors, isFatal }).toEqual({
+        needToUpdate: false,
+        errors:       [`Changing field "WSL.subnet" via the API isn't supported.`],
+        isFatal:      true,
+      });
+    });
+  });
+
   describe('kubernetes.version', () => {
     it('should accept a valid version', () => {
       const [needToUpdate, errors] = subject.validateSettings(cfg, { kubernetes: { version: '1.0.0' } });
@@ -6,7 +6,7 @@ import { URL } from 'url';
 import express from 'express';
 import _ from 'lodash';
 
-import { State } from '@pkg/backend/backend';
+import { PortForwardingProblem, State } from '@pkg/backend/backend';
 import type { Settings } from '@pkg/config/settings';
 import type { TransientSettings } from '@pkg/config/transientSettings';
 import type { DiagnosticsResultCollection } from '@pkg/main/diagnostics/diagnostics';
@@ -104,6 +104,7 @@ export class HttpCommandServer {
       delete: { '/v1/snapshots': [0, this.deleteSnapshot] },
     } as const,
     {
+      get:    { '/v1/port_forwarding/problems': [1, this.listPortForwardingProblems] },
       post:   { '/v1/port_forwarding': [1, this.createPortForwarding] },
       delete: { '/v1/port_forwarding': [1, this.deletePortForwarding] },
     } as const,
@@ -654,6 +655,18 @@ export class HttpCommandServer {
     }
   }
 
+  protected async listPortForwardingProblems(request: express.Request, response: express.Response, context: commandContext): Promise<void> {
+    try {
+      const problems = await this.commandWorker.getPortForwardingProblems();
+
+      console.debug('listPortForwardingProblems: succeeded 200');
+      response.status(200).json(problems);
+    } catch (error: any) {
+      console.error(`listPortForwardingProblems: error getting port forwarding problems:`, error);
+      response.status(500).type('txt').send('Could not get port forwarding problems');
+    }
+  }
+
   wrapShutdown(request: express.Request, response: express.Response, context: commandContext): Promise<void> {
     console.debug('shutdown: succeeded 202');
     response.status(202).type('txt').send('Shutting down.');
@@ -936,6 +949,9 @@ export interface CommandWorkerInterface {
 
   forwardPort:   (namespace: string, service: string, k8sPort: string | number, hostPort: number) => Promise<number | undefined>;
   cancelForward: (namespace: string, service: string, k8sPort: string | number) => Promise<void>;
+
+  /** Get the published ports that could not be forwarded to the host. */
+  getPortForwardingProblems: () => Promise<PortForwardingProblem[]>;
 }
 
 // Extend CommandWorkerInterface to have extra types, as these types are used by
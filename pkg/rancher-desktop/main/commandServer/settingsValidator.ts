@@ -19,7 +19,12 @@ import { NavItemName, navItemNames, TransientSettings } from '@pkg/config/transi
 import { PathManagementStrategy } from '@pkg/integrations/pathManager';
 import { availableLocales, t } from '@pkg/main/i18n';
 import { parseImageReference, validateImageName, validateImageTag } from '@pkg/utils/dockerUtils';
-import { stripNoproxyPrefix } from '@pkg/utils/networks';
+import {
+  maxVirtualNetworkPrefixLength,
+  overlappingHostNetwork,
+  stripNoproxyPrefix,
+  virtualNetworkAddresses,
+} from '@pkg/utils/networks';
 import { getMacOsVersion } from '@pkg/utils/osVersion';
 import { RecursivePartial } from '@pkg/utils/typeUtils';
 import { preferencesNavItems } from '@pkg/window/preferenceConstants';
@@ -159,15 +164,28 @@ export default class SettingsValidator {
           sshPortForwarder: this.checkLima(this.checkBoolean),
         },
       },
-      WSL:        { integrations: this.checkPlatform('win32', this.checkBooleanMapping) },
+      WSL:        {
+        integrations: this.checkPlatform('win32', this.checkBooleanMapping),
+        subnet:       this.checkPlatform('win32', this.checkVirtualNetworkSubnet),
+        mtu:          this.checkPlatform('win32', this.checkNumber(1280, 4000)),
+        mssClamping:  this.checkPlatform('win32', this.checkBoolean),
+      },
       kubernetes: {
         version: this.checkKubernetesVersion,
         port:    this.checkNumber(1, 65535),
         enabled: this.checkBoolean,
-        options: { traefik: this.checkBoolean, flannel: this.checkBoolean },
+        options: {
+          traefik:              this.checkBoolean,
+          flannel:              this.checkBoolean,
+          serviceForwarding:    this.checkPlatform('win32', this.checkBoolean),
+          iptablesScanInterval: this.checkPlatform('win32', this.checkNumber(1, 3600)),
+        },
         ingress: { localhostOnly: this.checkPlatform('win32', this.checkBoolean) },
       },
-      portForwarding: { includeKubernetesServices: this.checkBoolean },
+      portForwarding: {
+        includeKubernetesServices: this.checkBoolean,
+        relayIPv6Loopback:         this.checkPlatform('win32', this.checkBoolean),
+      },
       images:         {
         showAll:   this.checkBoolean,
         namespace: this.checkString,
@@ -560,6 +578,41 @@ export default class SettingsValidator {
     return currentValue !== desiredValue;
   }
 
+  /**
+   * Check that the setting is a subnet the virtual network can use, and that
+   * it doesn't overlap the networks of the host, which would no longer be
+   * reachable.  Overlaps are only checked for new values, as the networks of
+   * the host may change after the subnet was set.
+   */
+  protected checkVirtualNetworkSubnet(_: Settings, currentValue: string, desiredValue: string, errors: string[], fqname: string): boolean {
+    if (typeof desiredValue !== 'string') {
+      errors.push(this.invalidSettingMessage(fqname, desiredValue));
+
+      return false;
+    }
+    if (desiredValue === currentValue) {
+      return false;
+    }
+    if (!virtualNetworkAddresses(desiredValue)) {
+      errors.push(t('validation.invalidSubnet', {
+        field:           fqname,
+        value:           JSON.stringify(desiredValue),
+        maxPrefixLength: maxVirtualNetworkPrefixLength,
+      }));
+
+      return false;
+    }
+    const network = overlappingHostNetwork(desiredValue);
+
+    if (network) {
+      errors.push(t('validation.subnetOverlapsHostNetwork', { field: fqname, value: desiredValue, network }));
+
+      return false;
+    }
+
+    return true;
+  }
+
   /**
    * Parse a string representing a number of bytes into a number, in a way that
    * is compatible with `github.com/docker/go-units`.
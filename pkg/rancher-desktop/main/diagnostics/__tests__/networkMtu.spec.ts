@@ -0,0 +1,32 @@
+import { probeMtu } from '../networkMtu';
+
+describe(probeMtu, () => {
+  /** Returns a sender that only gets packets up to the path MTU through. */
+  function sender(pathMtu: number) {
+    const sent: number[] = [];
+    const send = (mtu: number) => {
+      sent.push(mtu);
+
+      return Promise.resolve(mtu <= pathMtu);
+    };
+
+    return { send, sent };
+  }
+
+  it('should return the configured MTU when it fits', async() => {
+    const { send, sent } = sender(1500);
+
+    await expect(probeMtu(1500, send)).resolves.toEqual(1500);
+    expect(sent).toEqual([1500]);
+  });
+
+  it('should find a lower path MTU', async() => {
+    for (const pathMtu of [1280, 1281, 1380, 1420, 1499]) {
+      await expect(probeMtu(1500, sender(pathMtu).send)).resolves.toEqual(pathMtu);
+    }
+  });
+
+  it('should give up when nothing gets through', async() => {
+    await expect(probeMtu(1500, sender(0).send)).resolves.toBeUndefined();
+  });
+});
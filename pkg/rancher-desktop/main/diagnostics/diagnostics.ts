@@ -65,6 +65,7 @@ export class DiagnosticsManager {
           import('./limaOverrides'),
           import('./mobyImageStore'),
           import('./mockForScreenshots'),
+          import('./networkMtu'),
           import('./pathManagement'),
           import('./rdBinInShell'),
           import('./testCheckers'),
@@ -0,0 +1,118 @@
+import { DiagnosticsCategory, DiagnosticsChecker } from './types';
+
+import mainEvents from '@pkg/main/mainEvents';
+import { spawnFile } from '@pkg/utils/childProcess';
+import Logging from '@pkg/utils/logging';
+
+const console = Logging.diagnostics;
+
+/** The host the path MTU is probed towards. */
+const probeHost = 'docs.rancherdesktop.io';
+
+/** The smallest MTU the virtual network can use; see WSL.mtu. */
+const minimumMtu = 1280;
+
+/** The size of the IPv4 and ICMP headers of a ping. */
+const pingHeaderSize = 28;
+
+/**
+ * Send a single ping of a packet of the given size to the host, with the
+ * Don't Fragment flag set, and return whether it got a reply.
+ */
+async function ping(mtu: number): Promise<boolean> {
+  try {
+    const { stdout } = await spawnFile(
+      'ping.exe',
+      ['-n', '1', '-w', '2000', '-f', '-l', `${ mtu - pingHeaderSize }`, probeHost],
+      { stdio: ['ignore', 'pipe', 'pipe'], encoding: 'utf-8' },
+    );
+
+    // ping.exe exits successfully on some errors, such as an unreachable
+    // host; only replies have a TTL, whatever the language.
+    return stdout.includes('TTL=');
+  } catch {
+    return false;
+  }
+}
+
+/**
+ * Find the largest MTU up to the configured one that packets can be sent
+ * with without being fragmented, by sending packets of decreasing sizes.
+ * Returns undefined if not even the smallest packets get through, such as
+ * when ICMP is filtered, as the MTU can't be told then.
+ */
+export async function probeMtu(configured: number, send: (mtu: number) => Promise<boolean>): Promise<number | undefined> {
+  if (await send(configured)) {
+    return configured;
+  }
+  if (!await send(minimumMtu)) {
+    return undefined;
+  }
+  // Packets of low fit, and packets of high don't.
+  let low = minimumMtu;
+  let high = configured;
+
+  while (high - low > 1) {
+    const mtu = Math.floor((low + high) / 2);
+
+    if (await send(mtu)) {
+      low = mtu;
+    } else {
+      high = mtu;
+    }
+  }
+
+  return low;
+}
+
+/**
+ * Check whether the path MTU from the host to the internet is lower than the
+ * MTU of the virtual network, which makes large transfers from the VM hang
+ * when the ICMP messages reporting it are filtered, as they are on some VPNs.
+ */
+class CheckNetworkMtu implements DiagnosticsChecker {
+  readonly id = 'NETWORK_MTU';
+
+  category = DiagnosticsCategory.Networking;
+  applicable(): Promise<boolean> {
+    return Promise.resolve(process.platform === 'win32');
+  }
+
+  async check() {
+    const settings = await mainEvents.invoke('settings-fetch');
+    const configured = settings.WSL.mtu;
+    const effective = await probeMtu(configured, ping);
+
+    if (effective === undefined) {
+      console.debug(`${ this.id }: could not reach ${ probeHost } with ping; not checking the MTU.`);
+
+      return {
+        passed:      true,
+        description: `The path MTU could not be probed, as \`${ probeHost }\` does not answer pings.`,
+        fixes:       [],
+      };
+    }
+    if (effective >= configured) {
+      return {
+        passed:      true,
+        description: `The MTU of the virtual network (${ configured }) fits the network of the host.`,
+        fixes:       [],
+      };
+    }
+
+    const fixes = [{ description: `Set the MTU of the virtual network to ${ effective } with \`rdctl set --WSL.mtu=${ effective }\`.` }];
+
+    if (!settings.WSL.mssClamping) {
+      fixes.push({ description: 'Clamp the MSS of TCP connections with `rdctl set --WSL.mss-clamping`.' });
+    }
+
+    return {
+      passed:      false,
+      description: `The MTU of the virtual network (${ configured }) is larger than the path MTU of the network of ` +
+        `the host (${ effective }); large transfers from containers may hang.`,
+      fixes,
+    };
+  }
+}
+
+export default new CheckNetworkMtu();
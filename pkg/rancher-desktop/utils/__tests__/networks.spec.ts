@@ -1,6 +1,6 @@
 import net from 'net';
 
-import { getAvailablePorts, stripNoproxyPrefix } from '../networks';
+import { getAvailablePorts, stripNoproxyPrefix, virtualNetworkAddresses } from '../networks';
 
 describe('getAvailablePorts', () => {
   it('returns the requested number of ports', async() => {
@@ -78,3 +78,40 @@ describe('stripNoproxyPrefix', () => {
     expect(stripNoproxyPrefix('*')).toBe('*');
   });
 });
+
+describe('virtualNetworkAddresses', () => {
+  it.each([
+    ['192.168.127.0/24', {
+      subnet: '192.168.127.0/24', gateway: '192.168.127.1', vm: '192.168.127.2', host: '192.168.127.254',
+    }],
+    ['10.200.0.0/16', {
+      subnet: '10.200.0.0/16', gateway: '10.200.0.1', vm: '10.200.0.2', host: '10.200.255.254',
+    }],
+    ['172.31.7.16/28', {
+      subnet: '172.31.7.16/28', gateway: '172.31.7.17', vm: '172.31.7.18', host: '172.31.7.30',
+    }],
+    ['192.168.200.248/29', {
+      subnet: '192.168.200.248/29', gateway: '192.168.200.249', vm: '192.168.200.250', host: '192.168.200.254',
+    }],
+    ['10.1.2.3/22', {
+      subnet: '10.1.0.0/22', gateway: '10.1.0.1', vm: '10.1.0.2', host: '10.1.3.254',
+    }],
+  ])('derives the addresses of %s', (subnet, expected) => {
+    expect(virtualNetworkAddresses(subnet)).toEqual(expected);
+  });
+
+  it.each([
+    '',
+    '192.168.127.0',
+    '192.168.127.0/33',
+    '192.168.127.0/30',
+    '192.168.127.0/24/1',
+    'fd00::/64',
+    '0.0.0.0/8',
+    '127.0.0.0/24',
+    '169.254.0.0/16',
+    '224.0.0.0/24',
+  ])('rejects %j', (subnet) => {
+    expect(virtualNetworkAddresses(subnet)).toBeUndefined();
+  });
+});
@@ -58,3 +58,105 @@ export function wslHostIPv4Address(): string | undefined {
 
   return iface.find(addr => addr.family === 'IPv4')?.address;
 }
+
+/**
+ * The addresses of the virtual network between Windows and the WSL VM, which
+ * host-switch derives from its subnet.
+ */
+export interface VirtualNetworkAddresses {
+  /** The subnet, without host bits. */
+  subnet:  string;
+  /** The gateway, gateway.rancher-desktop.internal. */
+  gateway: string;
+  /** The address leased to the VM. */
+  vm:      string;
+  /** The address of the host in the network, host.rancher-desktop.internal. */
+  host:    string;
+}
+
+/**
+ * The longest prefix length of the virtual network subnet; smaller subnets
+ * have no room for all of its addresses.
+ */
+export const maxVirtualNetworkPrefixLength = 29;
+
+function parseIPv4(address: string): number | undefined {
+  if (!net.isIPv4(address)) {
+    return undefined;
+  }
+
+  return address.split('.').reduce((value, octet) => value * 256 + parseInt(octet, 10), 0);
+}
+
+function formatIPv4(value: number): string {
+  return [24, 16, 8, 0].map(shift => Math.floor(value / 2 ** shift) % 256).join('.');
+}
+
+/**
+ * Parse an IPv4 subnet in CIDR notation, returning its network address and
+ * size, or undefined if it is not one.
+ */
+function parseIPv4Subnet(subnet: string): { network: number, size: number } | undefined {
+  const [address, prefixLength, ...rest] = subnet.split('/');
+  const ip = parseIPv4(address);
+
+  if (rest.length > 0 || ip === undefined || !/^\d{1,2}$/.test(prefixLength ?? '') || parseInt(prefixLength, 10) > 32) {
+    return undefined;
+  }
+  const size = 2 ** (32 - parseInt(prefixLength, 10));
+
+  return { network: ip - ip % size, size };
+}
+
+/**
+ * Return the addresses of the virtual network with the given subnet, or
+ * undefined if the subnet can't be used for it.  This must match
+ * ValidateSubnet in src/go/networking/pkg/config, which host-switch uses.
+ */
+export function virtualNetworkAddresses(subnet: string): VirtualNetworkAddresses | undefined {
+  const parsed = parseIPv4Subnet(subnet);
+
+  if (!parsed || parsed.size < 2 ** (32 - maxVirtualNetworkPrefixLength)) {
+    return undefined;
+  }
+  const { network, size } = parsed;
+  const firstOctet = Math.floor(network / 2 ** 24);
+  const isLinkLocal = Math.floor(network / 2 ** 16) === 169 * 256 + 254;
+
+  // Unspecified, loopback, link-local and multicast subnets can't be routed.
+  if (network === 0 || firstOctet === 127 || isLinkLocal || (firstOctet >= 224 && firstOctet < 240)) {
+    return undefined;
+  }
+
+  return {
+    subnet:  `${ formatIPv4(network) }/${ 32 - Math.log2(size) }`,
+    gateway: formatIPv4(network + 1),
+    vm:      formatIPv4(network + 2),
+    host:    formatIPv4(network + size - 2),
+  };
+}
+
+/**
+ * Return the network of a host interface that overlaps the given subnet, if
+ * any.  Networks the host only reaches through a gateway are not found.
+ */
+export function overlappingHostNetwork(subnet: string): string | undefined {
+  const parsed = parseIPv4Subnet(subnet);
+
+  if (!parsed) {
+    return undefined;
+  }
+  for (const addresses of Object.values(os.networkInterfaces())) {
+    for (const address of addresses ?? []) {
+      const hostNetwork = address.family === 'IPv4' && !address.internal && address.cidr ? parseIPv4Subnet(address.cidr) : undefined;
+
+      if (hostNetwork &&
+        hostNetwork.network < parsed.network + parsed.size &&
+        parsed.network < hostNetwork.network + hostNetwork.size) {
+        return address.cidr ?? undefined;
+      }
+    }
+  }
+
+  return undefined;
+}
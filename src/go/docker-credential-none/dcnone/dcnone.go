@@ -3,6 +3,10 @@
 // ~/.docker/plaintext-credentials.config.json
 // in the `auths` section
 // as `ServerURL: auth : base64Encode(Username + ":" + Secret)`
+// With DOCKER_CREDENTIAL_NONE_ENCRYPT=true, the pairs are instead encrypted
+// with a key held by the OS (see encryption.go).
+// Access to the file is serialized through a lock on
+// plaintext-credentials.config.json.lock next to it.
 
 package dcnone
 
@@ -10,6 +14,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 
 	dockerconfig "github.com/docker/cli/cli/config"
@@ -25,8 +30,12 @@ type DCNone struct{}
 
 var configFile string
 
+// The docker config file, which names the helpers to migrate from.
+var dockerConfigFile string
+
 func init() {
 	configFile = filepath.Join(dockerconfig.Dir(), configFileName)
+	dockerConfigFile = filepath.Join(dockerconfig.Dir(), dockerconfig.ConfigFileName)
 	credentials.Name = "docker-credential-none"
 	credentials.Package = "github.com/rancher-sandbox/rancher-desktop/src/go/docker-credential-none"
 	credentials.Version = VERSION
@@ -39,74 +48,107 @@ func (p DCNone) Add(creds *credentials.Credentials) error {
 	if creds == nil {
 		return errors.New("missing credentials")
 	}
-	config, err := getParsedConfig()
-	if err != nil {
-		return err
-	}
-	authsInterface, ok := config["auths"]
-	if ok {
-		auths, ok = authsInterface.(map[string]any)
-	}
-	if !ok {
-		// Either config['auths'] doesn't exist or it isn't a hash
-		auths = map[string]any{}
-		config["auths"] = auths
-	}
-	payload := fmt.Sprintf("%s:%s", creds.Username, creds.Secret)
-	encoded := base64.URLEncoding.EncodeToString([]byte(payload))
-	auths[creds.ServerURL] = map[string]string{"auth": encoded}
-	return saveParsedConfig(&config)
+	return withConfigLock(true, func() error {
+		config, err := getParsedConfig()
+		if err != nil {
+			return err
+		}
+		authsInterface, ok := config["auths"]
+		if ok {
+			auths, ok = authsInterface.(map[string]any)
+		}
+		if !ok {
+			// Either config['auths'] doesn't exist or it isn't a hash
+			auths = map[string]any{}
+			config["auths"] = auths
+		}
+		// Replace any entry stored under another form of the same URL, so
+		// that it doesn't shadow the new one.
+		for _, key := range matchingServerURLs(auths, creds.ServerURL) {
+			delete(auths, key)
+		}
+		payload := fmt.Sprintf("%s:%s", creds.Username, creds.Secret)
+		encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+		// Encrypted by encodeAuths if encryption is on.
+		auths[creds.ServerURL] = map[string]any{"auth": encoded}
+		mergeServerURLs(auths)
+		if err := encodeAuths(config, newKeyLoader()); err != nil {
+			return err
+		}
+		return saveParsedConfig(&config)
+	})
 }
 
-// Delete removes credentials from the store.
+// Delete removes credentials from the store, under any form of the server URL.
+// Like the other helpers, removing credentials that aren't stored is a
+// not-found error.
 func (p DCNone) Delete(serverURL string) error {
 	if serverURL == "" {
 		return errors.New("missing server url")
 	}
-	config, err := getParsedConfig()
-	if err != nil {
-		return err
-	}
+	return withConfigLock(true, func() error {
+		config, err := getParsedConfig()
+		if err != nil {
+			return err
+		}
 
-	authsInterface, ok := config["auths"]
-	if !ok {
-		// Not an error if there's no URL (or auths)
-		return nil
-	}
-	auths, ok := authsInterface.(map[string]any)
-	if !ok {
-		// Same as above -- if we can't get the hash we don't have a URL entry to remove
-		return nil
-	}
-	_, ok = auths[serverURL]
-	if !ok {
-		// Not an error if there's no URL (or auths)
-		return nil
-	}
-	delete(auths, serverURL)
-	return saveParsedConfig(&config)
+		authsInterface, ok := config["auths"]
+		if !ok {
+			return credentials.NewErrCredentialsNotFound()
+		}
+		auths, ok := authsInterface.(map[string]any)
+		if !ok {
+			// If we can't get the hash we don't have a URL entry to remove
+			return credentials.NewErrCredentialsNotFound()
+		}
+		keys := matchingServerURLs(auths, serverURL)
+		if len(keys) == 0 {
+			return credentials.NewErrCredentialsNotFound()
+		}
+		for _, key := range keys {
+			delete(auths, key)
+		}
+		mergeServerURLs(auths)
+		if err := encodeAuths(config, newKeyLoader()); err != nil {
+			return err
+		}
+		return saveParsedConfig(&config)
+	})
 }
 
 // Get returns the username and secret to use for a given registry server URL.
+// Credentials stored under another form of the URL (see matchingServerURLs)
+// are found as well.
 func (p DCNone) Get(serverURL string) (string, string, error) {
 	if serverURL == "" {
 		return "", "", errors.New("missing server url")
 	}
-	config, err := getParsedConfig()
-	if err != nil {
-		return "", "", err
-	}
-	username, secret, err := getRecordForServerURL(&config, serverURL)
+	var username, secret string
+	err := withConfigLock(false, func() error {
+		config, err := getParsedConfig()
+		if err != nil {
+			return err
+		}
+		username, secret, err = getRecordForServerURL(&config, resolveServerURL(config, serverURL), newKeyLoader())
+		return err
+	})
 	if err != nil {
 		return "", "", err
 	}
 	return username, secret, nil
 }
 
-// List returns the stored URLs and corresponding usernames for a given credentials label
+// List returns the stored URLs and corresponding usernames for a given credentials label.
+// Entries that can't be read are skipped, with a warning on stderr, so that one corrupt
+// entry doesn't hide the others.
 func (p DCNone) List() (map[string]string, error) {
 	entries := make(map[string]string)
-	config, err := getParsedConfig()
+	var config dockerConfigType
+	err := withConfigLock(false, func() error {
+		var err error
+		config, err = getParsedConfig()
+		return err
+	})
 	if err != nil {
 		return entries, err
 	}
@@ -116,11 +158,14 @@ func (p DCNone) List() (map[string]string, error) {
 		if !ok {
 			return entries, fmt.Errorf("unexpected data: %v: not a hash", authsInterface)
 		}
+		keys := newKeyLoader()
 		for url := range auths {
-			username, _, err := getRecordForServerURL(&config, url)
-			if username != "" && err == nil {
-				entries[url] = username
+			username, _, err := getRecordForServerURL(&config, url, keys)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: skipping credentials for %s: %s\n", url, err)
+				continue
 			}
+			entries[url] = username
 		}
 	}
 	return entries, nil
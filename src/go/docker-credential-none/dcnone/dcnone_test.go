@@ -1,13 +1,31 @@
 package dcnone
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker-credential-helpers/credentials"
 )
 
+// useTempConfig points the helper at a config file in a temporary directory
+// for the duration of the test.
+func useTempConfig(t *testing.T) string {
+	saved := configFile
+	t.Cleanup(func() { configFile = saved })
+	configFile = filepath.Join(t.TempDir(), ".docker", configFileName)
+	return configFile
+}
+
 func TestDCNoneHelper(t *testing.T) {
+	useTempConfig(t)
 	helper := DCNone{}
 
 	const server1 = "https://foobar.docker.io:2376/v1"
@@ -91,3 +109,321 @@ func TestDCNoneHelper(t *testing.T) {
 		}
 	}
 }
+
+// runHelper runs a command the way the credential helper binary does, with
+// the given input, and returns its output.
+func runHelper(t *testing.T, action, input string) (string, error) {
+	var out bytes.Buffer
+	err := credentials.HandleCommand(DCNone{}, credentials.Action(action), strings.NewReader(input), &out)
+	return out.String(), err
+}
+
+func TestProtocol(t *testing.T) {
+	const serverURL = "https://registry.example.com/v2"
+
+	t.Run("list with nothing stored prints an empty object", func(t *testing.T) {
+		useTempConfig(t)
+		out, err := runHelper(t, "list", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(out) != "{}" {
+			t.Fatalf("unexpected output %q", out)
+		}
+	})
+
+	t.Run("store, get, list and erase round-trip credentials", func(t *testing.T) {
+		useTempConfig(t)
+		// The secret encodes to characters that differ between the
+		// standard and URL-safe base64 alphabets.
+		stored := credentials.Credentials{ServerURL: serverURL, Username: "user", Secret: "s3cr?t>>~"}
+		input, err := json.Marshal(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runHelper(t, "store", string(input)); err != nil {
+			t.Fatalf("store failed: %s", err)
+		}
+
+		out, err := runHelper(t, "get", serverURL+"\n")
+		if err != nil {
+			t.Fatalf("get failed: %s", err)
+		}
+		var got credentials.Credentials
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("get printed %q: %s", out, err)
+		}
+		if got != stored {
+			t.Fatalf("get returned %+v, expected %+v", got, stored)
+		}
+
+		out, err = runHelper(t, "list", "")
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		var listed map[string]string
+		if err := json.Unmarshal([]byte(out), &listed); err != nil {
+			t.Fatalf("list printed %q: %s", out, err)
+		}
+		if len(listed) != 1 || listed[serverURL] != "user" {
+			t.Fatalf("unexpected list %v", listed)
+		}
+
+		if _, err := runHelper(t, "erase", serverURL); err != nil {
+			t.Fatalf("erase failed: %s", err)
+		}
+		if _, err := runHelper(t, "get", serverURL); !credentials.IsErrCredentialsNotFound(err) {
+			t.Fatalf("expected credentials not found after erase, got %v", err)
+		}
+		if _, err := runHelper(t, "erase", serverURL); !credentials.IsErrCredentialsNotFound(err) {
+			t.Fatalf("expected credentials not found erasing missing credentials, got %v", err)
+		}
+	})
+
+	t.Run("get of unknown credentials is not found", func(t *testing.T) {
+		useTempConfig(t)
+		if _, err := runHelper(t, "get", serverURL); !credentials.IsErrCredentialsNotFound(err) {
+			t.Fatalf("expected credentials not found, got %v", err)
+		}
+	})
+
+	t.Run("list skips corrupt entries", func(t *testing.T) {
+		path := useTempConfig(t)
+		config := `{"auths": {
+			"good.example.com": {"auth": "dXNlcjpzZWNyZXQ="},
+			"not-base64.example.com": {"auth": "!!!"},
+			"no-colon.example.com": {"auth": "dXNlcg=="},
+			"not-a-string.example.com": {"auth": 42},
+			"not-a-hash.example.com": "dXNlcjpzZWNyZXQ=",
+			"no-username.example.com": {"auth": "OnNlY3JldA=="}
+		}}`
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		out, err := runHelper(t, "list", "")
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		var listed map[string]string
+		if err := json.Unmarshal([]byte(out), &listed); err != nil {
+			t.Fatalf("list printed %q: %s", out, err)
+		}
+		if len(listed) != 1 || listed["good.example.com"] != "user" {
+			t.Fatalf("unexpected list %v", listed)
+		}
+	})
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	t.Run("concurrent stores and erases don't lose updates", func(t *testing.T) {
+		path := useTempConfig(t)
+		const count = 40
+		var wg sync.WaitGroup
+		errs := make(chan error, 2*count)
+		for i := range count {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serverURL := fmt.Sprintf("https://registry%d.example.com", i)
+				input := fmt.Sprintf(`{"ServerURL": %q, "Username": "user%d", "Secret": "secret"}`, serverURL, i)
+				if _, err := runHelper(t, "store", input); err != nil {
+					errs <- fmt.Errorf("store %s: %w", serverURL, err)
+					return
+				}
+				if i%2 == 0 {
+					if _, err := runHelper(t, "erase", serverURL); err != nil {
+						errs <- fmt.Errorf("erase %s: %w", serverURL, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var config dockerConfigType
+		if err := json.Unmarshal(contents, &config); err != nil {
+			t.Fatalf("config file is corrupt: %s", err)
+		}
+		listed, err := DCNone{}.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listed) != count/2 {
+			t.Errorf("expected %d credentials, got %d: %v", count/2, len(listed), listed)
+		}
+		for i := 1; i < count; i += 2 {
+			serverURL := fmt.Sprintf("https://registry%d.example.com", i)
+			if listed[serverURL] != fmt.Sprintf("user%d", i) {
+				t.Errorf("lost the credentials for %s", serverURL)
+			}
+		}
+	})
+
+	t.Run("store times out while another process holds the lock", func(t *testing.T) {
+		path := useTempConfig(t)
+		savedTimeout := lockTimeout
+		defer func() { lockTimeout = savedTimeout }()
+		lockTimeout = 50 * time.Millisecond
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		lockFile, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lockFile.Close()
+		if err := tryLockFile(lockFile, true); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = unlockFile(lockFile) }()
+
+		creds := &credentials.Credentials{ServerURL: "https://registry.example.com", Username: "user", Secret: "secret"}
+		if err := (DCNone{}).Add(creds); !errors.Is(err, ErrStoreLocked) {
+			t.Fatalf("expected ErrStoreLocked, got %v", err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the config file not to be written, got %v", err)
+		}
+	})
+}
+
+func TestServerURLNormalization(t *testing.T) {
+	// Each group lists spellings of the same registry; spellings in
+	// different groups must not match.
+	groups := []struct {
+		name      string
+		spellings []string
+	}{
+		{"Docker Hub", []string{
+			"https://index.docker.io/v1/",
+			"https://index.docker.io/v1",
+			"index.docker.io/v1/",
+			"index.docker.io",
+			"https://index.docker.io",
+			"docker.io",
+			"https://docker.io/",
+			"registry-1.docker.io",
+			"https://registry-1.docker.io/v2/",
+			"registry.hub.docker.com",
+			"https://index.docker.io:443/v1/",
+			"HTTPS://Index.Docker.IO/v1/",
+		}},
+		{"ghcr.io", []string{
+			"ghcr.io",
+			"ghcr.io/",
+			"https://ghcr.io",
+			"https://ghcr.io/",
+			"http://ghcr.io",
+			"ghcr.io:443",
+			"https://ghcr.io:443/",
+			"GHCR.io",
+		}},
+		{"registry with a port", []string{
+			"registry.example.com:5000",
+			"registry.example.com:5000/",
+			"https://registry.example.com:5000",
+			"http://registry.example.com:5000/",
+			"Registry.Example.com:5000",
+		}},
+		{"registry without a port", []string{
+			"registry.example.com",
+			"https://registry.example.com:443",
+			"http://registry.example.com:80",
+		}},
+		{"registry path", []string{
+			"registry.example.com/v2",
+			"https://registry.example.com/v2/",
+		}},
+		{"registry on port 443 over http", []string{"http://registry.example.com:443"}},
+		{"registry on port 80 over https", []string{"https://registry.example.com:80"}},
+	}
+	for i, group := range groups {
+		want := normalizeServerURL(group.spellings[0])
+		for _, spelling := range group.spellings {
+			if got := normalizeServerURL(spelling); got != want {
+				t.Errorf("%s: %q normalized to %q, expected %q", group.name, spelling, got, want)
+			}
+		}
+		for _, other := range groups[i+1:] {
+			if otherWant := normalizeServerURL(other.spellings[0]); otherWant == want {
+				t.Errorf("%s and %s both normalize to %q", group.name, other.name, want)
+			}
+		}
+	}
+
+	for _, group := range groups {
+		t.Run(group.name, func(t *testing.T) {
+			useTempConfig(t)
+			helper := DCNone{}
+			stored := group.spellings[len(group.spellings)-1]
+			if err := helper.Add(&credentials.Credentials{ServerURL: stored, Username: "user", Secret: "secret"}); err != nil {
+				t.Fatal(err)
+			}
+			for _, spelling := range group.spellings {
+				username, secret, err := helper.Get(spelling)
+				if err != nil || username != "user" || secret != "secret" {
+					t.Errorf("get %q: got %q, %q, %v", spelling, username, secret, err)
+				}
+			}
+			// The URL is listed as it was stored.
+			list, err := helper.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 1 || list[stored] != "user" {
+				t.Errorf("unexpected list %v", list)
+			}
+			if err := helper.Delete(group.spellings[0]); err != nil {
+				t.Errorf("failed to erase %q: %s", group.spellings[0], err)
+			}
+		})
+	}
+
+	t.Run("duplicate variants are merged on the next write", func(t *testing.T) {
+		path := useTempConfig(t)
+		config := `{"auths": {
+			"https://index.docker.io/v1/": {"auth": "aHViOnNlY3JldA=="},
+			"docker.io": {"auth": "b2xkOnNlY3JldA=="},
+			"ghcr.io": {"auth": "Z2g6c2VjcmV0"},
+			"https://ghcr.io/": {"auth": "b2xkOnNlY3JldA=="}
+		}}`
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		helper := DCNone{}
+		if err := helper.Add(&credentials.Credentials{ServerURL: "registry.example.com:5000", Username: "user", Secret: "secret"}); err != nil {
+			t.Fatal(err)
+		}
+		list, err := helper.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{
+			"https://index.docker.io/v1/": "hub",
+			"ghcr.io":                     "gh",
+			"registry.example.com:5000":   "user",
+		}
+		if len(list) != len(expected) {
+			t.Errorf("expected %v, got %v", expected, list)
+		}
+		for url, username := range expected {
+			if list[url] != username {
+				t.Errorf("expected %v, got %v", expected, list)
+			}
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package dcnone
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Encryption is optional: when it is on, the auth field of each entry holds
+// encryptedAuthPrefix followed by the base64-encoded nonce and AES-GCM
+// ciphertext of the usual "username:secret" pair, rather than the pair
+// itself. The key is held outside the store, by the OS (see keyStore). The
+// layout of the store is otherwise unchanged.
+
+// The environment variable that turns encryption on ("true") or off
+// ("false"). Its value is recorded in the store on the next write, so that
+// later invocations, such as those by docker, keep to it.
+const encryptionEnv = "DOCKER_CREDENTIAL_NONE_ENCRYPT"
+
+// The top-level field of the store recording whether secrets are encrypted.
+const encryptionConfigKey = "encryptSecrets"
+
+// The prefix of encrypted auth data.
+const encryptedAuthPrefix = "encrypted:"
+
+// ErrEncryptionKey is returned when stored credentials are encrypted, but the
+// key to decrypt them is missing or doesn't match.
+var ErrEncryptionKey = errors.New("the encryption key for the credential store is missing or does not match")
+
+// errKeyNotFound is returned by keyStore.load when no key has been saved.
+var errKeyNotFound = errors.New("no encryption key")
+
+// keyStore holds the encryption key, in the way the OS provides.
+type keyStore interface {
+	// load returns the key, or errKeyNotFound.
+	load() ([]byte, error)
+	// save stores a new key.
+	save(key []byte) error
+	// String describes where the key is held, for error messages.
+	String() string
+}
+
+// newKeyStore returns the key store for this OS; tests replace it.
+var newKeyStore = platformKeyStore
+
+// encryptionEnabled returns whether secrets should be encrypted when written,
+// and whether that was set by encryptionEnv rather than read from the store.
+func encryptionEnabled(config dockerConfigType) (enabled, fromEnv bool, err error) {
+	if value, ok := os.LookupEnv(encryptionEnv); ok && value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, false, fmt.Errorf("invalid value %q for %s: %w", value, encryptionEnv, err)
+		}
+		return enabled, true, nil
+	}
+	enabled, _ = config[encryptionConfigKey].(bool)
+	return enabled, false, nil
+}
+
+// The size of the encryption key, for AES-256.
+const keySize = 32
+
+// keyLoader loads the encryption key at most once for each operation.
+type keyLoader struct {
+	store  keyStore
+	key    []byte
+	err    error
+	loaded bool
+}
+
+func newKeyLoader() *keyLoader {
+	return &keyLoader{store: newKeyStore()}
+}
+
+func (loader *keyLoader) load() {
+	if !loader.loaded {
+		loader.loaded = true
+		loader.key, loader.err = loader.store.load()
+	}
+}
+
+// get returns the key; an ErrEncryptionKey error if there is none, or it is
+// not usable.
+func (loader *keyLoader) get() ([]byte, error) {
+	loader.load()
+	switch {
+	case errors.Is(loader.err, errKeyNotFound):
+		return nil, fmt.Errorf("%w: there is no key in %s", ErrEncryptionKey, loader.store)
+	case loader.err != nil:
+		return nil, fmt.Errorf("reading the encryption key from %s: %w", loader.store, loader.err)
+	case len(loader.key) != keySize:
+		return nil, fmt.Errorf("%w: the key in %s is %d bytes instead of %d", ErrEncryptionKey, loader.store, len(loader.key), keySize)
+	}
+	return loader.key, nil
+}
+
+// getOrCreate returns the key, saving a new one if there is none yet.
+func (loader *keyLoader) getOrCreate() ([]byte, error) {
+	loader.load()
+	if !errors.Is(loader.err, errKeyNotFound) {
+		return loader.get()
+	}
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := loader.store.save(key); err != nil {
+		return nil, fmt.Errorf("saving the encryption key in %s: %w", loader.store, err)
+	}
+	loader.key, loader.err = key, nil
+	return key, nil
+}
+
+// decodeAuth returns the "username:secret" pair held by auth data, decrypting
+// it if needed.
+func decodeAuth(authData string, keys *keyLoader) ([]byte, error) {
+	encrypted, ok := strings.CutPrefix(authData, encryptedAuthPrefix)
+	if !ok {
+		pair, err := base64.StdEncoding.DecodeString(authData)
+		if err != nil {
+			// Older versions stored the pair using the URL-safe alphabet.
+			var urlErr error
+			if pair, urlErr = base64.URLEncoding.DecodeString(authData); urlErr != nil {
+				return nil, err
+			}
+		}
+		return pair, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.get()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	pair, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decryption failed", ErrEncryptionKey)
+	}
+	return pair, nil
+}
+
+// encodeAuth returns the auth data holding a "username:secret" pair,
+// encrypted with key unless it is nil.
+func encodeAuth(pair []byte, key []byte) (string, error) {
+	if key == nil {
+		return base64.StdEncoding.EncodeToString(pair), nil
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, pair, nil)
+	return encryptedAuthPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encodeAuths brings every entry of the store to the mode given by
+// encryptionEnabled before it is written, so that changing the mode takes
+// effect on the next write. Entries that can't be decoded are left alone.
+func encodeAuths(config dockerConfigType, keys *keyLoader) error {
+	enabled, fromEnv, err := encryptionEnabled(config)
+	if err != nil {
+		return err
+	}
+	if fromEnv {
+		if enabled {
+			config[encryptionConfigKey] = true
+		} else {
+			delete(config, encryptionConfigKey)
+		}
+	}
+	auths, ok := config["auths"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	// The entries not yet in the mode, and whether any are encrypted.
+	var pending []string
+	encrypted := false
+	for serverURL, entry := range auths {
+		record, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		authData, ok := record["auth"].(string)
+		if !ok {
+			continue
+		}
+		isEncrypted := strings.HasPrefix(authData, encryptedAuthPrefix)
+		encrypted = encrypted || isEncrypted
+		if isEncrypted != enabled {
+			pending = append(pending, serverURL)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	var key []byte
+	if enabled {
+		if encrypted {
+			// A new key can't be made when there is already encrypted
+			// data, as that data could then never be decrypted.
+			key, err = keys.get()
+			if errors.Is(err, ErrEncryptionKey) {
+				return fmt.Errorf("%w; restore the key, or remove %s and log in to the registries again", err, configFile)
+			}
+		} else {
+			key, err = keys.getOrCreate()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, serverURL := range pending {
+		record := auths[serverURL].(map[string]any)
+		pair, err := decodeAuth(record["auth"].(string), keys)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: leaving credentials for %s as they are: %s\n", serverURL, err)
+			continue
+		}
+		if record["auth"], err = encodeAuth(pair, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package dcnone
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// memoryKeyStore is a keyStore for tests.
+type memoryKeyStore struct {
+	key []byte
+}
+
+func (store *memoryKeyStore) load() ([]byte, error) {
+	if store.key == nil {
+		return nil, errKeyNotFound
+	}
+	return store.key, nil
+}
+
+func (store *memoryKeyStore) save(key []byte) error {
+	store.key = key
+	return nil
+}
+
+func (store *memoryKeyStore) String() string {
+	return "memory"
+}
+
+// useMemoryKeyStore makes the helper keep its key in the returned store for
+// the duration of the test.
+func useMemoryKeyStore(t *testing.T) *memoryKeyStore {
+	store := &memoryKeyStore{}
+	saved := newKeyStore
+	t.Cleanup(func() { newKeyStore = saved })
+	newKeyStore = func() keyStore { return store }
+	return store
+}
+
+// readStore returns the config file as written.
+func readStore(t *testing.T) (string, dockerConfigType) {
+	contents, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config dockerConfigType
+	if err := json.Unmarshal(contents, &config); err != nil {
+		t.Fatal(err)
+	}
+	return string(contents), config
+}
+
+func storedAuth(t *testing.T, serverURL string) string {
+	_, config := readStore(t)
+	auths, _ := config["auths"].(map[string]any)
+	record, _ := auths[serverURL].(map[string]any)
+	auth, _ := record["auth"].(string)
+	return auth
+}
+
+func TestEncryption(t *testing.T) {
+	const secret = "isthebestmeshuggahalbum"
+	creds := func(serverURL string) *credentials.Credentials {
+		return &credentials.Credentials{ServerURL: serverURL, Username: "nothing", Secret: secret}
+	}
+	checkGet := func(t *testing.T, serverURL string) {
+		username, got, err := DCNone{}.Get(serverURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "nothing" || got != secret {
+			t.Errorf("expected nothing/%s, got %s/%s", secret, username, got)
+		}
+	}
+
+	t.Run("encrypts when turned on", func(t *testing.T) {
+		useTempConfig(t)
+		store := useMemoryKeyStore(t)
+		t.Setenv(encryptionEnv, "true")
+		if err := (DCNone{}).Add(creds("registry.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if len(store.key) != keySize {
+			t.Fatalf("expected a %d-byte key to be saved, got %d bytes", keySize, len(store.key))
+		}
+		contents, config := readStore(t)
+		if !strings.HasPrefix(storedAuth(t, "registry.example.com"), encryptedAuthPrefix) {
+			t.Errorf("expected encrypted auth data, got %s", contents)
+		}
+		if strings.Contains(contents, "nothing") {
+			t.Errorf("expected no plaintext credentials, got %s", contents)
+		}
+		if config[encryptionConfigKey] != true {
+			t.Errorf("expected %s to be recorded, got %s", encryptionConfigKey, contents)
+		}
+		checkGet(t, "registry.example.com")
+		list, err := DCNone{}.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list["registry.example.com"] != "nothing" {
+			t.Errorf("unexpected list %v", list)
+		}
+	})
+
+	t.Run("keeps to the recorded mode", func(t *testing.T) {
+		useTempConfig(t)
+		useMemoryKeyStore(t)
+		t.Setenv(encryptionEnv, "true")
+		if err := (DCNone{}).Add(creds("one.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(encryptionEnv, "")
+		if err := (DCNone{}).Add(creds("two.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if auth := storedAuth(t, "two.example.com"); !strings.HasPrefix(auth, encryptedAuthPrefix) {
+			t.Errorf("expected encrypted auth data, got %q", auth)
+		}
+	})
+
+	t.Run("re-encodes existing entries on the next write", func(t *testing.T) {
+		useTempConfig(t)
+		useMemoryKeyStore(t)
+		if err := (DCNone{}).Add(creds("one.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if auth := storedAuth(t, "one.example.com"); strings.HasPrefix(auth, encryptedAuthPrefix) {
+			t.Fatalf("expected plaintext auth data, got %q", auth)
+		}
+
+		t.Setenv(encryptionEnv, "true")
+		if err := (DCNone{}).Add(creds("two.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if auth := storedAuth(t, "one.example.com"); !strings.HasPrefix(auth, encryptedAuthPrefix) {
+			t.Errorf("expected existing entry to be encrypted, got %q", auth)
+		}
+		checkGet(t, "one.example.com")
+
+		t.Setenv(encryptionEnv, "false")
+		if err := (DCNone{}).Delete("two.example.com"); err != nil {
+			t.Fatal(err)
+		}
+		contents, config := readStore(t)
+		if auth := storedAuth(t, "one.example.com"); strings.HasPrefix(auth, encryptedAuthPrefix) {
+			t.Errorf("expected existing entry to be decrypted, got %q", auth)
+		}
+		if _, ok := config[encryptionConfigKey]; ok {
+			t.Errorf("expected %s to be removed, got %s", encryptionConfigKey, contents)
+		}
+		t.Setenv(encryptionEnv, "")
+		checkGet(t, "one.example.com")
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		useTempConfig(t)
+		store := useMemoryKeyStore(t)
+		t.Setenv(encryptionEnv, "true")
+		if err := (DCNone{}).Add(creds("one.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		store.key = nil
+		if _, _, err := (DCNone{}).Get("one.example.com"); !errors.Is(err, ErrEncryptionKey) {
+			t.Errorf("expected %v, got %v", ErrEncryptionKey, err)
+		}
+		// No new key may be made, as the existing entry couldn't be read
+		// with it.
+		if err := (DCNone{}).Add(creds("two.example.com")); !errors.Is(err, ErrEncryptionKey) {
+			t.Errorf("expected %v, got %v", ErrEncryptionKey, err)
+		}
+		if store.key != nil {
+			t.Error("expected no new key to be saved")
+		}
+		// The entry can still be erased.
+		if err := (DCNone{}).Delete("one.example.com"); err != nil {
+			t.Errorf("failed to erase: %s", err)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		useTempConfig(t)
+		store := useMemoryKeyStore(t)
+		t.Setenv(encryptionEnv, "true")
+		if err := (DCNone{}).Add(creds("one.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		store.key = []byte(strings.Repeat("k", keySize))
+		if _, _, err := (DCNone{}).Get("one.example.com"); !errors.Is(err, ErrEncryptionKey) {
+			t.Errorf("expected %v, got %v", ErrEncryptionKey, err)
+		}
+		store.key = []byte("short")
+		if _, _, err := (DCNone{}).Get("one.example.com"); !errors.Is(err, ErrEncryptionKey) {
+			t.Errorf("expected %v, got %v", ErrEncryptionKey, err)
+		}
+		list, err := DCNone{}.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 0 {
+			t.Errorf("expected unreadable entries to be skipped, got %v", list)
+		}
+	})
+
+	t.Run("invalid setting", func(t *testing.T) {
+		useTempConfig(t)
+		useMemoryKeyStore(t)
+		t.Setenv(encryptionEnv, "sometimes")
+		if err := (DCNone{}).Add(creds("one.example.com")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestFileKeyStore(t *testing.T) {
+	useTempConfig(t)
+	store := newFileKeyStore()
+	if _, err := store.load(); !errors.Is(err, errKeyNotFound) {
+		t.Fatalf("expected %v, got %v", errKeyNotFound, err)
+	}
+	key := []byte(strings.Repeat("k", keySize))
+	if err := store.save(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.save([]byte("other")); err == nil {
+		t.Error("expected an existing key not to be replaced")
+	}
+	loaded, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded) != string(key) {
+		t.Errorf("expected %q, got %q", key, loaded)
+	}
+}
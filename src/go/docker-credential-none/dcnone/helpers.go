@@ -1,15 +1,16 @@
 package dcnone
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 
-	dockerconfig "github.com/docker/cli/cli/config"
 	"github.com/docker/docker-credential-helpers/credentials"
 )
 
@@ -37,42 +38,161 @@ func saveParsedConfig(config *dockerConfigType) error {
 	if err != nil {
 		return err
 	}
-	scratchFile, err := os.CreateTemp(dockerconfig.Dir(), "tmpconfig.json")
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(configFile), 0o700); err != nil {
 		return err
 	}
-	err = os.WriteFile(scratchFile.Name(), contents, 0o600)
-	scratchFile.Close()
+	// Write to a scratch file and rename it over the config file, so that
+	// the config file is always either the old or the new version.
+	scratchFile, err := os.CreateTemp(filepath.Dir(configFile), "tmpconfig.json")
 	if err != nil {
 		return err
 	}
-	return os.Rename(scratchFile.Name(), configFile)
+	scratchName := scratchFile.Name()
+	_, err = scratchFile.Write(contents)
+	if err == nil {
+		err = scratchFile.Sync()
+	}
+	if closeErr := scratchFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(scratchName, configFile)
+	}
+	if err != nil {
+		_ = os.Remove(scratchName)
+	}
+	return err
+}
+
+// The URL docker uses for the credentials of Docker Hub.
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+// The host names Docker Hub is known by.
+var dockerHubHosts = []string{"docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+// normalizeServerURL returns the form of a registry server URL used to match
+// stored credentials: docker passes "https://index.docker.io/v1/" for Docker
+// Hub but bare host names for other registries, and tools vary on which they
+// send. So the scheme, trailing slashes, default ports and the case of the
+// host name are ignored, and the names of Docker Hub are all taken to mean
+// dockerHubServerURL.
+func normalizeServerURL(serverURL string) string {
+	defaultPort := ":443"
+	for _, scheme := range []string{"https://", "http://"} {
+		if len(serverURL) >= len(scheme) && strings.EqualFold(serverURL[:len(scheme)], scheme) {
+			serverURL = serverURL[len(scheme):]
+			if scheme == "http://" {
+				defaultPort = ":80"
+			}
+			break
+		}
+	}
+	host, path, _ := strings.Cut(strings.TrimRight(serverURL, "/"), "/")
+	host = strings.TrimSuffix(strings.ToLower(host), defaultPort)
+	if slices.Contains(dockerHubHosts, host) && (path == "" || path == "v1" || path == "v2") {
+		// The normalized form of dockerHubServerURL.
+		return "index.docker.io/v1"
+	}
+	if path == "" {
+		return host
+	}
+	return host + "/" + path
+}
+
+// matchingServerURLs returns the keys of auths that are forms of serverURL,
+// sorted so that the exact form, if present, comes first.
+func matchingServerURLs(auths map[string]any, serverURL string) []string {
+	normalized := normalizeServerURL(serverURL)
+	var keys []string
+	for key := range auths {
+		if key != serverURL && normalizeServerURL(key) == normalized {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	if _, ok := auths[serverURL]; ok {
+		keys = slices.Insert(keys, 0, serverURL)
+	}
+	return keys
+}
+
+// mergeServerURLs removes the entries of auths stored under other forms of
+// the URL of an entry, which earlier versions could leave behind. The entry
+// kept is the one under the URL docker asks for: dockerHubServerURL for Docker
+// Hub, and the bare host name for other registries; failing that, the first.
+func mergeServerURLs(auths map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(auths)) {
+		if _, ok := auths[key]; !ok {
+			// Already merged into another key.
+			continue
+		}
+		variants := matchingServerURLs(auths, key)
+		if len(variants) < 2 {
+			continue
+		}
+		kept := variants[0]
+		for _, variant := range variants {
+			if variant == dockerHubServerURL || variant == normalizeServerURL(variant) {
+				kept = variant
+				break
+			}
+		}
+		for _, variant := range variants {
+			if variant != kept {
+				delete(auths, variant)
+			}
+		}
+	}
+}
+
+// resolveServerURL returns the key under which the credentials for serverURL
+// are stored, or serverURL itself if there are none.
+func resolveServerURL(config dockerConfigType, serverURL string) string {
+	if auths, ok := config["auths"].(map[string]any); ok {
+		if keys := matchingServerURLs(auths, serverURL); len(keys) > 0 {
+			return keys[0]
+		}
+	}
+	return serverURL
 }
 
 /**
  * Returns the Username and Secret associated with `urlArg`, or an error if there was a problem.
  */
-func getRecordForServerURL(config *dockerConfigType, urlArg string) (string, string, error) {
+func getRecordForServerURL(config *dockerConfigType, urlArg string, keys *keyLoader) (string, string, error) {
 	authsInterface, ok := (*config)["auths"]
 	if !ok {
 		return "", "", credentials.NewErrCredentialsNotFound()
 	}
-	auths := authsInterface.(map[string]any)
+	auths, ok := authsInterface.(map[string]any)
+	if !ok {
+		return "", "", fmt.Errorf("unexpected data: %v: not a hash", authsInterface)
+	}
 	authDataForURL, ok := auths[urlArg]
 	if !ok {
 		return "", "", credentials.NewErrCredentialsNotFound()
 	}
-	authData, ok := authDataForURL.(map[string]any)["auth"]
+	authRecord, ok := authDataForURL.(map[string]any)
+	if !ok {
+		return "", "", fmt.Errorf("unexpected data for URL %s: %v: not a hash", urlArg, authDataForURL)
+	}
+	authDataInterface, ok := authRecord["auth"]
 	if !ok {
 		return "", "", credentials.NewErrCredentialsNotFound()
 	}
-	credentialPair, err := base64.StdEncoding.DecodeString(authData.(string))
-	if err != nil {
-		return "", "", fmt.Errorf("base64-decoding authdata for URL %s: %s", urlArg, err)
+	authData, ok := authDataInterface.(string)
+	if !ok {
+		return "", "", fmt.Errorf("unexpected auth data for URL %s: %v: not a string", urlArg, authDataInterface)
+	}
+	credentialPair, err := decodeAuth(authData, keys)
+	if errors.Is(err, ErrEncryptionKey) {
+		return "", "", fmt.Errorf("decrypting authdata for URL %s: %w; restore the key, or log in to the registry again", urlArg, err)
+	} else if err != nil {
+		return "", "", fmt.Errorf("decoding authdata for URL %s: %s", urlArg, err)
 	}
 	parts := strings.SplitN(string(credentialPair), ":", 2)
 	if len(parts) == 1 {
-		return "", "", fmt.Errorf("not a valid base64-encoded pair: <%s>", authData.(string))
+		return "", "", fmt.Errorf("not a valid username:secret pair for URL %s", urlArg)
 	}
 	if parts[0] == "" {
 		return "", "", credentials.NewErrCredentialsMissingUsername()
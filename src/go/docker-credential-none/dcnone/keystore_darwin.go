@@ -0,0 +1,64 @@
+package dcnone
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The service and account of the keychain item holding the key.
+const (
+	keychainService = "docker-credential-none"
+	keychainAccount = "encryption-key"
+)
+
+// The exit code of security(1) when the item is not in the keychain.
+const securityItemNotFound = 44
+
+// keychainKeyStore holds the key, hex-encoded, in the login keychain.
+type keychainKeyStore struct{}
+
+func platformKeyStore() keyStore {
+	return keychainKeyStore{}
+}
+
+func (keychainKeyStore) load() ([]byte, error) {
+	output, err := exec.Command("security", "find-generic-password",
+		"-s", keychainService, "-a", keychainAccount, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+		return nil, errKeyNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("security find-generic-password: %w", err)
+	}
+	return hex.DecodeString(strings.TrimSpace(string(output)))
+}
+
+// save adds the keychain item through the interactive mode of security(1),
+// which reads the command from its standard input, so that the key is not on
+// a command line that other users can see.  That mode doesn't reliably exit
+// with an error when the command fails, so the item is read back instead.
+func (store keychainKeyStore) save(key []byte) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -s %s -a %s -w %s\n",
+		keychainService, keychainAccount, hex.EncodeToString(key)))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("security add-generic-password: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	saved, err := store.load()
+	if err != nil {
+		return fmt.Errorf("security add-generic-password: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if !bytes.Equal(saved, key) {
+		return fmt.Errorf("security add-generic-password: the keychain holds another key: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (keychainKeyStore) String() string {
+	return fmt.Sprintf("the keychain item %q", keychainService)
+}
@@ -0,0 +1,67 @@
+package dcnone
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// fileKeyStore holds the key in a file next to the store, readable only by the
+// user; protect and unprotect, if set, transform the key as it is written and
+// read.
+type fileKeyStore struct {
+	path      string
+	protect   func([]byte) ([]byte, error)
+	unprotect func([]byte) ([]byte, error)
+}
+
+func newFileKeyStore() *fileKeyStore {
+	return &fileKeyStore{path: configFile + ".key"}
+}
+
+func (store *fileKeyStore) load() ([]byte, error) {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if store.unprotect != nil {
+		return store.unprotect(data)
+	}
+	return data, nil
+}
+
+func (store *fileKeyStore) save(key []byte) error {
+	data := key
+	if store.protect != nil {
+		var err error
+		if data, err = store.protect(key); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(store.path), 0o700); err != nil {
+		return err
+	}
+	// O_EXCL, so that a key made by another process is never replaced.
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(store.path)
+	}
+	return err
+}
+
+func (store *fileKeyStore) String() string {
+	return store.path
+}
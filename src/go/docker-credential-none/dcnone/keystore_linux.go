@@ -0,0 +1,63 @@
+package dcnone
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The attributes of the Secret Service item holding the key.
+var secretAttributes = []string{"service", "docker-credential-none", "account", "encryption-key"}
+
+// secretServiceKeyStore holds the key, hex-encoded, through the Secret Service
+// (the GNOME keyring or KWallet), using secret-tool(1).
+type secretServiceKeyStore struct{}
+
+// platformKeyStore returns the Secret Service key store, unless secret-tool
+// isn't installed, or a key file was made before; a key file is used then.
+func platformKeyStore() keyStore {
+	fileStore := newFileKeyStore()
+	if _, err := os.Stat(fileStore.path); err == nil {
+		return fileStore
+	}
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return fileStore
+	}
+	return secretServiceKeyStore{}
+}
+
+func (secretServiceKeyStore) load() ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", append([]string{"lookup"}, secretAttributes...)...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	// secret-tool exits with 1, and writes nothing, if there is no such item.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		return nil, errKeyNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("secret-tool lookup: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, errKeyNotFound
+	}
+	return hex.DecodeString(strings.TrimSpace(string(output)))
+}
+
+func (secretServiceKeyStore) save(key []byte) error {
+	args := append([]string{"store", "--label=docker-credential-none encryption key"}, secretAttributes...)
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (secretServiceKeyStore) String() string {
+	return "the Secret Service item for docker-credential-none"
+}
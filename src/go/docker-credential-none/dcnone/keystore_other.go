@@ -0,0 +1,7 @@
+//go:build !darwin && !linux && !windows
+
+package dcnone
+
+func platformKeyStore() keyStore {
+	return newFileKeyStore()
+}
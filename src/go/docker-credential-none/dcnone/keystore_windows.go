@@ -0,0 +1,47 @@
+package dcnone
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// platformKeyStore returns a key file protected by DPAPI, so that only the
+// user can read the key.
+func platformKeyStore() keyStore {
+	store := newFileKeyStore()
+	store.protect = dpapiProtect
+	store.unprotect = dpapiUnprotect
+	return store
+}
+
+func dpapiProtect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(newDataBlob(data), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(newDataBlob(data), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+func newDataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeDataBlob copies the data of a blob allocated by DPAPI, and frees it.
+func takeDataBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte(nil), unsafe.Slice(blob.Data, blob.Size)...)
+}
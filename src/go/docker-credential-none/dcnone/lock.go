@@ -0,0 +1,55 @@
+package dcnone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrStoreLocked is returned when the credential store stays locked by
+// another invocation of the helper for longer than lockTimeout.
+var ErrStoreLocked = errors.New("credential store is locked by another process")
+
+// errLockBusy is returned by tryLockFile when the lock is held elsewhere.
+var errLockBusy = errors.New("lock is busy")
+
+// How long to wait for another invocation of the helper to release the lock
+// on the store; a variable so that tests can shorten it.
+var lockTimeout = 10 * time.Second
+
+// How long to wait between attempts to take the lock.
+const lockRetryInterval = 10 * time.Millisecond
+
+// withConfigLock runs fn while holding a lock on the config file: exclusive
+// for read-modify-write cycles, so that concurrent logins and logouts don't
+// lose each other's changes, and shared for reads. The lock is taken on a
+// separate file, as the config file itself is replaced on every write.
+func withConfigLock(exclusive bool, fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(configFile), 0o700); err != nil {
+		return err
+	}
+	lockPath := configFile + ".lock"
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening lock file %s: %w", lockPath, err)
+	}
+	defer lockFile.Close()
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err = tryLockFile(lockFile, exclusive)
+		if !errors.Is(err, errLockBusy) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w (waited %s for %s)", ErrStoreLocked, lockTimeout, lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+	if err != nil {
+		return fmt.Errorf("locking %s: %w", lockPath, err)
+	}
+	defer func() { _ = unlockFile(lockFile) }()
+	return fn()
+}
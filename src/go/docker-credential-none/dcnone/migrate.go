@@ -0,0 +1,118 @@
+package dcnone
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/docker/docker-credential-helpers/client"
+)
+
+// The name of this helper, as used in the credsStore and credHelpers fields
+// of the docker config.
+const helperName = "none"
+
+// MigrationSource is a credential helper to copy credentials from.
+type MigrationSource struct {
+	// The name of the helper, without the "docker-credential-" prefix.
+	Helper string
+	// The registries to copy from the helper; nil to copy all the
+	// credentials it lists.
+	Registries []string
+}
+
+// MigrationResult reports on copying the credentials of one registry.
+type MigrationResult struct {
+	Helper    string
+	ServerURL string
+	// Why the credentials couldn't be copied; nil if they were, or would
+	// be in a dry run.
+	Err error
+}
+
+// MigrationSources returns the helpers configured in the docker config,
+// ~/.docker/config.json: credsStore for all registries, and credHelpers for
+// single registries. This helper is left out, as there is nothing to copy
+// from it.
+func MigrationSources() ([]MigrationSource, error) {
+	path := dockerConfigFile
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var config struct {
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("reading config file %s: %s", path, err)
+	}
+	var sources []MigrationSource
+	if config.CredsStore != "" && config.CredsStore != helperName {
+		sources = append(sources, MigrationSource{Helper: config.CredsStore})
+	}
+	registries := make(map[string][]string)
+	for registry, helper := range config.CredHelpers {
+		if helper != "" && helper != helperName {
+			registries[helper] = append(registries[helper], registry)
+		}
+	}
+	for _, helper := range slices.Sorted(maps.Keys(registries)) {
+		slices.Sort(registries[helper])
+		sources = append(sources, MigrationSource{Helper: helper, Registries: registries[helper]})
+	}
+	return sources, nil
+}
+
+// Migrate copies the credentials from the given source into this helper's
+// store, running the source helper's list and get verbs through newProgram.
+// Failing to copy the credentials of a registry doesn't stop the others from
+// being copied; the outcome for each registry is in the results. With dryRun,
+// the registries are listed but nothing is fetched or stored. Secrets are
+// never part of the results.
+func Migrate(source MigrationSource, newProgram func(helper string) client.ProgramFunc, dryRun bool) ([]MigrationResult, error) {
+	program := newProgram(source.Helper)
+	registries := source.Registries
+	if registries == nil {
+		listed, err := client.List(program)
+		if err != nil {
+			return nil, fmt.Errorf("listing credentials from docker-credential-%s: %w", source.Helper, err)
+		}
+		for serverURL := range listed {
+			registries = append(registries, serverURL)
+		}
+		slices.Sort(registries)
+	}
+	results := make([]MigrationResult, 0, len(registries))
+	for _, serverURL := range registries {
+		result := MigrationResult{Helper: source.Helper, ServerURL: serverURL}
+		if !dryRun {
+			result.Err = migrateOne(program, serverURL)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func migrateOne(program client.ProgramFunc, serverURL string) error {
+	creds, err := client.Get(program, serverURL)
+	if err != nil {
+		return err
+	}
+	// Store the credentials under the URL the source helper listed them
+	// with, whatever URL its get verb reports.
+	creds.ServerURL = serverURL
+	return DCNone{}.Add(creds)
+}
+
+// HelperProgram runs the credential helper with the given name, as docker
+// does.
+func HelperProgram(helper string) client.ProgramFunc {
+	return client.NewShellProgramFunc("docker-credential-" + helper)
+}
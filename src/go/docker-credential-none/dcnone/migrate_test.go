@@ -0,0 +1,192 @@
+package dcnone
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// fakeHelper stands in for another credential helper, answering its verbs
+// from stored credentials.
+type fakeHelper struct {
+	// Secrets by server URL; the username is "user" for all of them.
+	secrets map[string]string
+	// Server URLs that list, but fail to get.
+	broken []string
+	// The calls made, as "verb input".
+	calls []string
+}
+
+type fakeProgram struct {
+	helper *fakeHelper
+	verb   string
+	input  string
+}
+
+func (p *fakeProgram) Input(in io.Reader) {
+	contents, _ := io.ReadAll(in)
+	p.input = string(contents)
+}
+
+func (p *fakeProgram) Output() ([]byte, error) {
+	p.helper.calls = append(p.helper.calls, p.verb+" "+p.input)
+	var out bytes.Buffer
+	err := credentials.HandleCommand(p.helper, p.verb, strings.NewReader(p.input), &out)
+	if err != nil {
+		return []byte(err.Error() + "\n"), errors.New("exit status 1")
+	}
+	return out.Bytes(), nil
+}
+
+func (h *fakeHelper) program(_ string) client.ProgramFunc {
+	return func(args ...string) client.Program {
+		return &fakeProgram{helper: h, verb: args[0]}
+	}
+}
+
+func (h *fakeHelper) Add(*credentials.Credentials) error {
+	return errors.New("read-only")
+}
+
+func (h *fakeHelper) Delete(string) error {
+	return errors.New("read-only")
+}
+
+func (h *fakeHelper) Get(serverURL string) (string, string, error) {
+	if slices.Contains(h.broken, serverURL) {
+		return "", "", errors.New("keychain is locked")
+	}
+	secret, ok := h.secrets[serverURL]
+	if !ok {
+		return "", "", credentials.NewErrCredentialsNotFound()
+	}
+	return "user", secret, nil
+}
+
+func (h *fakeHelper) List() (map[string]string, error) {
+	entries := make(map[string]string)
+	for serverURL := range h.secrets {
+		entries[serverURL] = "user"
+	}
+	for _, serverURL := range h.broken {
+		entries[serverURL] = "user"
+	}
+	return entries, nil
+}
+
+func TestMigrate(t *testing.T) {
+	t.Run("copies all listed credentials, carrying on after failures", func(t *testing.T) {
+		useTempConfig(t)
+		helper := &fakeHelper{
+			secrets: map[string]string{"registry-a.example.com": "secret-a", "https://registry-b.example.com": "secret-b"},
+			broken:  []string{"registry-c.example.com"},
+		}
+		results, err := Migrate(MigrationSource{Helper: "fake"}, helper.program, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var migrated, failed []string
+		for _, result := range results {
+			if result.Err != nil {
+				failed = append(failed, result.ServerURL)
+				if strings.Contains(result.Err.Error(), "secret") {
+					t.Errorf("error for %s mentions a secret: %s", result.ServerURL, result.Err)
+				}
+			} else {
+				migrated = append(migrated, result.ServerURL)
+			}
+		}
+		if !slices.Equal(migrated, []string{"https://registry-b.example.com", "registry-a.example.com"}) {
+			t.Errorf("unexpected migrated registries %q", migrated)
+		}
+		if !slices.Equal(failed, []string{"registry-c.example.com"}) {
+			t.Errorf("unexpected failed registries %q", failed)
+		}
+		for serverURL, expected := range helper.secrets {
+			username, secret, err := DCNone{}.Get(serverURL)
+			if err != nil || username != "user" || secret != expected {
+				t.Errorf("unexpected credentials for %s: %q, %q, %v", serverURL, username, secret, err)
+			}
+		}
+	})
+
+	t.Run("dry run only lists registries", func(t *testing.T) {
+		path := useTempConfig(t)
+		helper := &fakeHelper{secrets: map[string]string{"registry.example.com": "secret"}}
+		results, err := Migrate(MigrationSource{Helper: "fake"}, helper.program, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].ServerURL != "registry.example.com" || results[0].Err != nil {
+			t.Errorf("unexpected results %+v", results)
+		}
+		if !slices.Equal(helper.calls, []string{"list unused"}) {
+			t.Errorf("expected only a list call, got %q", helper.calls)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected nothing to be stored, got %v", err)
+		}
+	})
+
+	t.Run("only copies the given registries of a helper", func(t *testing.T) {
+		useTempConfig(t)
+		helper := &fakeHelper{secrets: map[string]string{"registry-a.example.com": "secret-a", "registry-b.example.com": "secret-b"}}
+		source := MigrationSource{Helper: "fake", Registries: []string{"registry-a.example.com"}}
+		if _, err := Migrate(source, helper.program, false); err != nil {
+			t.Fatal(err)
+		}
+		listed, err := DCNone{}.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listed) != 1 || listed["registry-a.example.com"] != "user" {
+			t.Errorf("unexpected credentials %v", listed)
+		}
+	})
+
+	t.Run("reads the helpers from the docker config", func(t *testing.T) {
+		saved := dockerConfigFile
+		defer func() { dockerConfigFile = saved }()
+		dockerConfigFile = filepath.Join(t.TempDir(), "config.json")
+		if sources, err := MigrationSources(); err != nil || sources != nil {
+			t.Errorf("expected no sources without a docker config, got %+v, %v", sources, err)
+		}
+		config := `{
+			"credsStore": "osxkeychain",
+			"credHelpers": {
+				"gcr.io": "gcloud",
+				"us.gcr.io": "gcloud",
+				"ecr.example.com": "ecr-login",
+				"local.example.com": "none"
+			}
+		}`
+		if err := os.WriteFile(dockerConfigFile, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		sources, err := MigrationSources()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []MigrationSource{
+			{Helper: "osxkeychain"},
+			{Helper: "ecr-login", Registries: []string{"ecr.example.com"}},
+			{Helper: "gcloud", Registries: []string{"gcr.io", "us.gcr.io"}},
+		}
+		if len(sources) != len(expected) {
+			t.Fatalf("expected sources %+v, got %+v", expected, sources)
+		}
+		for i := range expected {
+			if sources[i].Helper != expected[i].Helper || !slices.Equal(sources[i].Registries, expected[i].Registries) {
+				t.Errorf("expected source %+v, got %+v", expected[i], sources[i])
+			}
+		}
+	})
+}
@@ -1,11 +1,79 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/docker/docker-credential-helpers/credentials"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/docker-credential-none/dcnone"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
 	credentials.Serve(dcnone.DCNone{})
 }
+
+// migrate copies the credentials stored by other helpers into this one, and
+// returns the exit code: non-zero if any of them couldn't be copied.
+func migrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s migrate [--from <helper>] [--dry-run]\n\n", credentials.Name)
+		fmt.Fprintf(flags.Output(), "Copy the credentials stored by other credential helpers into this one.\n")
+		fmt.Fprintf(flags.Output(), "By default, the helpers are read from credsStore and credHelpers in the docker config.\n\n")
+		flags.PrintDefaults()
+	}
+	from := flags.String("from", "", "the helper to copy all credentials from, such as osxkeychain or wincred")
+	dryRun := flags.Bool("dry-run", false, "only list the registries whose credentials would be copied")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var sources []dcnone.MigrationSource
+	if *from != "" {
+		sources = []dcnone.MigrationSource{{Helper: *from}}
+	} else {
+		var err error
+		if sources, err = dcnone.MigrationSources(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read the docker config: %s\n", err)
+			return 1
+		}
+		if len(sources) == 0 {
+			fmt.Fprintln(os.Stderr, "the docker config names no other credential helper; use --from to name the one to migrate from")
+			return 1
+		}
+	}
+
+	exitCode := 0
+	for _, source := range sources {
+		results, err := dcnone.Migrate(source, dcnone.HelperProgram, *dryRun)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+			continue
+		}
+		for _, result := range results {
+			switch {
+			case *dryRun:
+				fmt.Printf("would migrate %s from %s\n", result.ServerURL, result.Helper)
+			case result.Err != nil:
+				fmt.Printf("failed to migrate %s from %s: %s\n", result.ServerURL, result.Helper, result.Err)
+				exitCode = 1
+			default:
+				fmt.Printf("migrated %s from %s\n", result.ServerURL, result.Helper)
+			}
+		}
+	}
+	return exitCode
+}
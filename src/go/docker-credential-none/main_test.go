@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// When set, the test binary runs the helper instead of the tests, so that the
+// tests can run it the way docker does.
+const runHelperEnv = "DOCKER_CREDENTIAL_NONE_TEST_RUN_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runHelperEnv) != "" {
+		os.Args = append([]string{"docker-credential-none"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestProtocol runs the helper with the verb as its argument and the payload
+// on stdin, as docker does, and checks everything it writes and its exit code.
+// The steps share a store, and run in order.
+func TestProtocol(t *testing.T) {
+	const notFound = "credentials not found in native keychain\n"
+	steps := []struct {
+		name   string
+		verb   string
+		stdin  string
+		stdout string
+		stderr string
+		exit   int
+	}{
+		{"list with nothing stored", "list", "", "{}\n", "", 0},
+		{"get of an unknown server", "get", "registry.example.com\n", notFound, "", 1},
+		{"erase of an unknown server", "erase", "registry.example.com\n", notFound, "", 1},
+		{"get without a server URL", "get", "", "no credentials server URL\n", "", 1},
+		{"erase without a server URL", "erase", "\n", "no credentials server URL\n", "", 1},
+		{"store with malformed JSON", "store", `{"ServerURL": "registry.example.com",`, "unexpected EOF\n", "", 1},
+		{"store with the wrong types", "store", `{"ServerURL": 1}`, "json: cannot unmarshal number into Go struct field Credentials.ServerURL of type string\n", "", 1},
+		{"store without a server URL", "store", `{"Username": "user", "Secret": "secret"}`, "no credentials server URL\n", "", 1},
+		{"store without a username", "store", `{"ServerURL": "registry.example.com", "Secret": "secret"}`, "no credentials username\n", "", 1},
+		{"list after failed stores", "list", "", "{}\n", "", 0},
+		{"store", "store", `{"ServerURL": "https://registry.example.com/", "Username": "user", "Secret": "secret"}`, "", "", 0},
+		{"get with the same URL", "get", "https://registry.example.com/\n", `{"ServerURL":"https://registry.example.com/","Username":"user","Secret":"secret"}` + "\n", "", 0},
+		{"get without the scheme and slash", "get", "registry.example.com", `{"ServerURL":"registry.example.com","Username":"user","Secret":"secret"}` + "\n", "", 0},
+		{"get with another scheme", "get", "http://registry.example.com", `{"ServerURL":"http://registry.example.com","Username":"user","Secret":"secret"}` + "\n", "", 0},
+		{"get of another path", "get", "registry.example.com/v2", notFound, "", 1},
+		{"list", "list", "", `{"https://registry.example.com/":"user"}` + "\n", "", 0},
+		{"store under another form of the URL", "store", `{"ServerURL": "registry.example.com", "Username": "other", "Secret": "other secret"}`, "", "", 0},
+		{"list after replacing", "list", "", `{"registry.example.com":"other"}` + "\n", "", 0},
+		{"erase under another form of the URL", "erase", "https://registry.example.com/\n", "", "", 0},
+		{"get after erase", "get", "registry.example.com\n", notFound, "", 1},
+		{"erase again", "erase", "registry.example.com\n", notFound, "", 1},
+		{"list after erase", "list", "", "{}\n", "", 0},
+		{"unknown verb", "frob", "", "docker-credential-none: unknown action: frob\n", "", 1},
+	}
+
+	configDir := t.TempDir()
+	for _, step := range steps {
+		if t.Failed() {
+			// The remaining steps depend on the store left by this one.
+			return
+		}
+		t.Run(step.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], step.verb)
+			cmd.Env = append(os.Environ(), runHelperEnv+"=1", "DOCKER_CONFIG="+configDir)
+			cmd.Stdin = strings.NewReader(step.stdin)
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			exit := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exit = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatalf("failed to run helper: %s", err)
+			}
+			if stdout.String() != step.stdout {
+				t.Errorf("stdout: expected %q, got %q", step.stdout, stdout.String())
+			}
+			if stderr.String() != step.stderr {
+				t.Errorf("stderr: expected %q, got %q", step.stderr, stderr.String())
+			}
+			if exit != step.exit {
+				t.Errorf("exit code: expected %d, got %d", step.exit, exit)
+			}
+		})
+	}
+}
@@ -33,92 +33,85 @@ import (
 	"github.com/docker/go-connections/nat"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/config"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/containerd"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/docker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/forwarder"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/kube"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/logging"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/procnet"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
-	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
 const (
-	iptablesUpdateInterval = 3 * time.Second
-	procNetScanInterval    = 3 * time.Second
-	socketInterval         = 5 * time.Second
-	socketRetryTimeout     = 2 * time.Minute
-	dockerSocketFile       = "/var/run/docker.sock"
-	containerdSocketFile   = "/run/k3s/containerd/containerd.sock"
+	procNetScanInterval  = 3 * time.Second
+	socketInterval       = 5 * time.Second
+	socketRetryTimeout   = 2 * time.Minute
+	dockerSocketFile     = "/var/run/docker.sock"
+	containerdSocketFile = "/run/k3s/containerd/containerd.sock"
+	// The ID the Kubernetes API port is forwarded under, alongside container IDs.
+	k8sAPIPortMappingID = "kubernetes-api"
+	// How long to wait for the host to acknowledge withdrawing the ports when
+	// shutting down; this must stay well below the grace period the init
+	// script allows between SIGTERM and SIGKILL.
+	shutdownTimeout = 3 * time.Second
 )
 
 func main() {
-	var (
-		debug            = flag.Bool("debug", false, "display debug output")
-		configPath       = flag.String("kubeconfig", "/etc/rancher/k3s/k3s.yaml", "path to kubeconfig")
-		enableKubernetes = flag.Bool("kubernetes", false, "enable Kubernetes service forwarding")
-		enableDocker     = flag.Bool("docker", false, "enable Docker event monitoring")
-		enableContainerd = flag.Bool("containerd", false, "enable Containerd event monitoring")
-		containerdSock   = flag.String("containerdSock",
-			containerdSocketFile,
-			"file path for Containerd socket address")
-		k8sServiceListenerAddr = flag.String("k8sServiceListenerAddr", net.IPv4zero.String(),
-			"address to bind Kubernetes services to on the host, valid options are 0.0.0.0 or 127.0.0.1")
-		adminInstall = flag.Bool("adminInstall", false, "indicates if Rancher Desktop is installed as admin or not")
-		k8sAPIPort   = flag.String("k8sAPIPort", "6443",
-			"K8sAPI port number to forward to rancher-desktop wsl-proxy as a static portMapping event")
-		tapIfaceIP = flag.String("tap-interface-ip", "192.168.127.2",
-			"IP address for the tap interface eth0 in network namespace")
-	)
-
-	// Setup logging with debug and trace levels
-	logger := log.NewStandard()
+	loader := config.NewLoader(flag.CommandLine)
+	printConfig := flag.Bool("print-config", false,
+		"print the effective configuration, and where each option was set, then exit")
 
 	flag.Parse()
 
-	if *debug {
-		logger.Level = log.DebugLevel
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	log.Current = logger
+	if *printConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	logCloser, err := logging.Setup(logging.Options{
+		Level:    logLevel(cfg),
+		Format:   cfg.LogFormat,
+		File:     cfg.LogFile,
+		MaxSize:  int64(cfg.LogMaxSize) << 20,
+		MaxFiles: cfg.LogMaxFiles,
+	})
+	if err != nil {
+		log.Fatalf("failed to set up logging: %s", err)
+	}
+	defer logCloser.Close()
 
-	log.Infof("Starting Rancher Desktop Agent in [AdminInstall=%t] mode", *adminInstall)
+	log.Infof("Starting Rancher Desktop Agent in [AdminInstall=%t] mode", cfg.AdminInstall)
 
 	if os.Geteuid() != 0 {
 		log.Fatal("agent must run as root")
 	}
 
-	if !*enableContainerd &&
-		!*enableDocker {
-		log.Fatal("requires either -docker or -containerd enabled.")
-	}
-
-	if *enableContainerd &&
-		*enableDocker {
-		log.Fatal("requires either -docker or -containerd but not both.")
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
 	}
 
-	if err := runAgent(
-		*enableContainerd, *enableDocker, *enableKubernetes,
-		*containerdSock, *configPath, *k8sServiceListenerAddr,
-		*adminInstall, *k8sAPIPort, *tapIfaceIP,
-	); err != nil {
+	if err := runAgent(loader, cfg); err != nil {
 		log.Fatal(err)
 	}
 
 	log.Info("Rancher Desktop Agent Shutting Down")
 }
 
-func runAgent(
-	enableContainerd, enableDocker, enableKubernetes bool,
-	containerdSock, configPath, k8sServiceListenerAddr string,
-	adminInstall bool,
-	k8sAPIPort, tapIfaceIP string,
-) error {
-	bindIP := net.ParseIP(tapIfaceIP)
-	if bindIP == nil {
-		return fmt.Errorf("invalid tap interface IP %q", tapIfaceIP)
-	}
+func runAgent(loader *config.Loader, cfg *config.Config) error {
+	bindIP := net.ParseIP(cfg.TapInterfaceIP)
 
 	groupCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -133,41 +126,56 @@ func runAgent(
 		cancel()
 	}()
 
+	// SIGHUP reloads the configuration once everything is set up; register
+	// for it right away, so that it never terminates the agent.
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	if cfg.HealthAddr != "" {
+		// A broken health endpoint must not stop port forwarding.
+		go func() {
+			if err := health.Serve(ctx, cfg.HealthAddr, cfg.HealthAllowNonLoopback); err != nil {
+				log.Errorf("%s", err)
+			}
+		}()
+	}
+
 	var portTracker tracker.Tracker
 
-	wslProxyForwarder := forwarder.NewWSLProxyForwarder(ctx, "/run/wsl-proxy.sock")
-	portTracker = tracker.NewAPITracker(ctx, wslProxyForwarder, tracker.GatewayBaseURL, tapIfaceIP, adminInstall)
+	// The WSL Proxy forwarder is not tied to ctx, so that the ports can still
+	// be withdrawn once the agent is shutting down.
+	wslProxyForwarder := forwarder.NewPortEventForwarder(ctx, forwarder.NewWSLProxyForwarder(context.Background(), "/run/wsl-proxy.sock"))
+	portTracker = tracker.NewAPITracker(ctx, wslProxyForwarder, tracker.GatewayBaseURL, cfg.TapInterfaceIP, cfg.AdminInstall)
 	// Manually register the port for K8s API, we would
 	// only want to send this manual port mapping if both
 	// of the following conditions are met:
 	// 1) if kubernetes is enabled
 	// 2) when wsl-proxy for wsl-integration is enabled
-	if enableKubernetes {
-		port, err := nat.NewPort("tcp", k8sAPIPort)
+	if cfg.Kubernetes {
+		port, err := nat.NewPort("tcp", cfg.K8sAPIPort)
 		if err != nil {
 			return fmt.Errorf("failed to parse port for k8s API: %w", err)
 		}
-		k8sAPIPortMapping := types.PortMapping{
-			Remove: false,
-			Ports: nat.PortMap{
-				port: []nat.PortBinding{
-					{
-						HostIP:   "127.0.0.1",
-						HostPort: k8sAPIPort,
-					},
+		k8sAPIPortMapping := nat.PortMap{
+			port: []nat.PortBinding{
+				{
+					HostIP:   "127.0.0.1",
+					HostPort: cfg.K8sAPIPort,
 				},
 			},
 		}
-		if err := wslProxyForwarder.Send(k8sAPIPortMapping); err != nil {
-			return fmt.Errorf("failed to send a static portMapping event to wsl-proxy: %w", err)
+		// If wsl-proxy is not reachable yet, the port is sent once it is.
+		if err := wslProxyForwarder.Set(k8sAPIPortMappingID, k8sAPIPortMapping); err != nil {
+			log.Warnf("failed to send a static portMapping event to wsl-proxy, will retry: %s", err)
+		} else {
+			log.Debugf("successfully forwarded k8s API port [%s] to wsl-proxy", cfg.K8sAPIPort)
 		}
-		log.Debugf("successfully forwarded k8s API port [%s] to wsl-proxy", k8sAPIPort)
 	}
 
-	if enableContainerd {
-		group.Go(func() error {
+	if cfg.Containerd {
+		group.Go(watcher("containerd", func() error {
 			for {
-				eventMonitor, err := containerd.NewEventMonitor(containerdSock, portTracker)
+				eventMonitor, err := containerd.NewEventMonitor(cfg.ContainerdSock, portTracker, cfg.ContainerdNamespaces)
 				if err != nil {
 					return fmt.Errorf("error initializing containerd event monitor: %w", err)
 				}
@@ -185,12 +193,16 @@ func runAgent(
 					return nil
 				default:
 				}
+				log.Infow("reconnecting to containerd", log.Fields{
+					logging.FieldEvent:   logging.EventReconnect,
+					logging.FieldWatcher: "containerd",
+				})
 			}
-		})
+		}))
 	}
 
-	if enableDocker {
-		group.Go(func() error {
+	if cfg.Docker {
+		group.Go(watcher("docker", func() error {
 			for {
 				eventMonitor, err := docker.NewEventMonitor(portTracker)
 				if err != nil {
@@ -207,50 +219,145 @@ func runAgent(
 					return nil
 				default:
 				}
+				log.Infow("reconnecting to docker", log.Fields{
+					logging.FieldEvent:   logging.EventReconnect,
+					logging.FieldWatcher: "docker",
+				})
 			}
-		})
+		}))
 	}
 
-	if enableKubernetes {
-		k8sServiceListenerIP := net.ParseIP(k8sServiceListenerAddr)
+	if cfg.Kubernetes && !cfg.K8sServiceForwarding {
+		log.Info("Kubernetes service forwarding is disabled")
+	}
 
-		if k8sServiceListenerIP == nil || (!k8sServiceListenerIP.Equal(net.IPv4zero) && !k8sServiceListenerIP.Equal(net.IPv4(127, 0, 0, 1))) {
-			return fmt.Errorf("empty or invalid Kubernetes service listener IP address %s; "+
-				"valid options are 0.0.0.0 and 127.0.0.1", k8sServiceListenerAddr)
-		}
+	var iptablesHandler *iptables.Iptables
+	if cfg.Kubernetes && cfg.K8sServiceForwarding {
+		k8sServiceListenerIP := net.ParseIP(cfg.K8sServiceListenerAddr)
+
+		iptablesScanner := iptables.NewIptablesScanner()
+		iptablesHandler = iptables.New(ctx, portTracker, iptablesScanner, k8sServiceListenerIP,
+			cfg.IptablesScanInterval, cfg.IptablesReconcileInterval)
 
-		group.Go(func() error {
+		group.Go(watcher("kubernetes", func() error {
 			// Watch for kube
 			err := kube.WatchForServices(ctx,
-				configPath,
+				cfg.Kubeconfig,
 				k8sServiceListenerIP,
-				portTracker)
+				portTracker,
+				iptablesHandler.SetWatcherActive)
 			if err != nil {
 				return fmt.Errorf("kubernetes service watcher failed: %w", err)
 			}
 			return nil
-		})
+		}))
 
-		group.Go(func() error {
-			iptablesScanner := iptables.NewIptablesScanner()
-			iptablesHandler := iptables.New(ctx, portTracker, iptablesScanner, k8sServiceListenerIP, iptablesUpdateInterval)
+		group.Go(watcher("iptables", func() error {
 			err := iptablesHandler.ForwardPorts()
 			if err != nil {
 				return fmt.Errorf("iptables port forwarding failed: %w", err)
 			}
 			return nil
-		})
+		}))
 	}
 
-	group.Go(func() error {
-		procScanner, err := procnet.NewProcNetScanner(ctx, portTracker, bindIP, procNetScanInterval)
+	group.Go(watcher("procnet", func() error {
+		procScanner, err := procnet.NewProcNetScanner(ctx, portTracker, bindIP, procNetScanInterval, cfg.RelayIPv6Loopback)
 		if err != nil {
 			return fmt.Errorf("scanning /proc/net/{tcp, udp} failed: %w", err)
 		}
 		return procScanner.ForwardPorts()
-	})
+	}))
 
-	return group.Wait()
+	go func() {
+		current := cfg
+		for range hupCh {
+			log.Debug("received [SIGHUP] signal, reloading configuration")
+			current = reloadConfig(loader, cfg, current, iptablesHandler)
+			if iptablesHandler != nil {
+				iptablesHandler.Rescan()
+			}
+		}
+	}()
+
+	err := group.Wait()
+
+	// Withdraw the forwarded ports, so that the host stops listening on them
+	// right away instead of once it notices the agent is gone.
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	if shutdownErr := wslProxyForwarder.Shutdown(shutdownCtx); shutdownErr != nil {
+		log.Warnf("failed to withdraw forwarded ports: %s", shutdownErr)
+	}
+
+	return err
+}
+
+// reloadConfig loads the configuration again, and applies the options that
+// can be changed while the agent runs; changes to other options are logged,
+// as they only take effect once the agent restarts. If the new configuration
+// is invalid, the current one is kept.
+func reloadConfig(
+	loader *config.Loader,
+	started, current *config.Config,
+	iptablesHandler *iptables.Iptables,
+) *config.Config {
+	cfg, err := loader.Load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Errorf("failed to reload configuration, keeping the current one: %s", err)
+		return current
+	}
+
+	_, restart := started.Changes(cfg)
+	for _, key := range restart {
+		log.Warnf("%s was changed in %s; restart the guest agent to apply it", key, cfg.Source(key))
+	}
+	reloadable, _ := current.Changes(cfg)
+	for _, key := range reloadable {
+		log.Infof("applying %s from %s", key, cfg.Source(key))
+	}
+
+	if err := logging.SetLevel(logLevel(cfg)); err != nil {
+		log.Errorf("failed to change the log level: %s", err)
+	}
+	if iptablesHandler != nil {
+		iptablesHandler.SetIntervals(cfg.IptablesScanInterval, cfg.IptablesReconcileInterval)
+	}
+	return cfg
+}
+
+// logLevel returns the level to log at; debug overrides logLevel.
+func logLevel(cfg *config.Config) string {
+	if cfg.Debug {
+		return "debug"
+	}
+	return cfg.LogLevel
+}
+
+// watcher wraps the function running the named watcher, so that it logs when
+// the watcher starts and stops, and why.
+func watcher(name string, run func() error) func() error {
+	return func() error {
+		log.Infow("watcher started", log.Fields{
+			logging.FieldEvent:   logging.EventStart,
+			logging.FieldWatcher: name,
+		})
+		err := run()
+		fields := log.Fields{
+			logging.FieldEvent:   logging.EventStop,
+			logging.FieldWatcher: name,
+		}
+		if err != nil {
+			fields[logging.FieldError] = err.Error()
+			log.Errorw("watcher stopped", fields)
+		} else {
+			log.Infow("watcher stopped", fields)
+		}
+		return err
+	}
 }
 
 func tryConnectAPI(ctx context.Context, socketFile string, verify func(context.Context) error) error {
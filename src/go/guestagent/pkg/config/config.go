@@ -0,0 +1,437 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the guest agent configuration from its config file,
+// RD_GUESTAGENT_* environment variables and command line flags. Flags take
+// precedence over environment variables, which take precedence over the
+// config file, which takes precedence over the defaults.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/containerd"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/logging"
+)
+
+const (
+	// DefaultPath is the config file read when no other one is given.
+	DefaultPath = "/etc/rancher-desktop/guestagent.yaml"
+	// EnvPrefix is the prefix of the environment variables setting options.
+	EnvPrefix = "RD_GUESTAGENT_"
+	// The environment variable naming the config file.
+	pathEnv = EnvPrefix + "CONFIG"
+)
+
+// Config is the guest agent configuration.
+type Config struct {
+	Debug                     bool
+	LogLevel                  string
+	LogFormat                 string
+	LogFile                   string
+	LogMaxSize                int
+	LogMaxFiles               int
+	Kubeconfig                string
+	Kubernetes                bool
+	Docker                    bool
+	Containerd                bool
+	ContainerdSock            string
+	ContainerdNamespaces      []string
+	K8sServiceListenerAddr    string
+	K8sServiceForwarding      bool
+	IptablesScanInterval      time.Duration
+	IptablesReconcileInterval time.Duration
+	AdminInstall              bool
+	K8sAPIPort                string
+	TapInterfaceIP            string
+	RelayIPv6Loopback         bool
+	HealthAddr                string
+	HealthAllowNonLoopback    bool
+
+	// Where the value of each option came from, by key.
+	sources map[string]string
+}
+
+// option describes a single configuration option.
+type option struct {
+	// The key in the config file.
+	key string
+	// The name of the command line flag, if it differs from the key.
+	flag string
+	// The environment variable, without EnvPrefix.
+	env   string
+	usage string
+	// Whether a change is applied on SIGHUP, rather than on restart.
+	reloadable bool
+	// field returns a pointer to the option in the config.
+	field func(*Config) any
+}
+
+func (o *option) flagName() string {
+	if o.flag != "" {
+		return o.flag
+	}
+	return o.key
+}
+
+var options = []*option{
+	{
+		key:        "debug",
+		env:        "DEBUG",
+		usage:      "display debug output",
+		reloadable: true,
+		field:      func(c *Config) any { return &c.Debug },
+	},
+	{
+		key:        "logLevel",
+		env:        "LOG_LEVEL",
+		usage:      "least severe level to log: trace, debug, info, warn or error",
+		reloadable: true,
+		field:      func(c *Config) any { return &c.LogLevel },
+	},
+	{
+		key:   "logFormat",
+		env:   "LOG_FORMAT",
+		usage: "log format, valid options are text or json",
+		field: func(c *Config) any { return &c.LogFormat },
+	},
+	{
+		key:   "logFile",
+		env:   "LOG_FILE",
+		usage: "file to log to, rotated by size; standard error if empty",
+		field: func(c *Config) any { return &c.LogFile },
+	},
+	{
+		key:   "logMaxSize",
+		env:   "LOG_MAX_SIZE",
+		usage: "size in megabytes above which the log file is rotated",
+		field: func(c *Config) any { return &c.LogMaxSize },
+	},
+	{
+		key:   "logMaxFiles",
+		env:   "LOG_MAX_FILES",
+		usage: "number of rotated log files to keep",
+		field: func(c *Config) any { return &c.LogMaxFiles },
+	},
+	{
+		key:   "kubeconfig",
+		env:   "KUBECONFIG",
+		usage: "path to kubeconfig",
+		field: func(c *Config) any { return &c.Kubeconfig },
+	},
+	{
+		key:   "kubernetes",
+		env:   "KUBERNETES",
+		usage: "enable Kubernetes service forwarding",
+		field: func(c *Config) any { return &c.Kubernetes },
+	},
+	{
+		key:   "docker",
+		env:   "DOCKER",
+		usage: "enable Docker event monitoring",
+		field: func(c *Config) any { return &c.Docker },
+	},
+	{
+		key:   "containerd",
+		env:   "CONTAINERD",
+		usage: "enable Containerd event monitoring",
+		field: func(c *Config) any { return &c.Containerd },
+	},
+	{
+		key:   "containerdSock",
+		env:   "CONTAINERD_SOCK",
+		usage: "file path for Containerd socket address",
+		field: func(c *Config) any { return &c.ContainerdSock },
+	},
+	{
+		key:   "containerdNamespaces",
+		env:   "CONTAINERD_NAMESPACES",
+		usage: "comma separated list of Containerd namespaces to watch for containers",
+		field: func(c *Config) any { return &c.ContainerdNamespaces },
+	},
+	{
+		key:   "k8sServiceListenerAddr",
+		env:   "K8S_SERVICE_LISTENER_ADDR",
+		usage: "address to bind Kubernetes services to on the host, valid options are 0.0.0.0 or 127.0.0.1",
+		field: func(c *Config) any { return &c.K8sServiceListenerAddr },
+	},
+	{
+		key:   "k8sServiceForwarding",
+		env:   "K8S_SERVICE_FORWARDING",
+		usage: "forward Kubernetes NodePort and LoadBalancer services to the host",
+		field: func(c *Config) any { return &c.K8sServiceForwarding },
+	},
+	{
+		key:        "iptablesScanInterval",
+		env:        "IPTABLES_SCAN_INTERVAL",
+		usage:      "interval between iptables scans; send SIGHUP to scan immediately",
+		reloadable: true,
+		field:      func(c *Config) any { return &c.IptablesScanInterval },
+	},
+	{
+		key:        "iptablesReconcileInterval",
+		env:        "IPTABLES_RECONCILE_INTERVAL",
+		usage:      "interval between iptables scans while the Kubernetes service watcher is active",
+		reloadable: true,
+		field:      func(c *Config) any { return &c.IptablesReconcileInterval },
+	},
+	{
+		key:   "adminInstall",
+		env:   "ADMIN_INSTALL",
+		usage: "indicates if Rancher Desktop is installed as admin or not",
+		field: func(c *Config) any { return &c.AdminInstall },
+	},
+	{
+		key:   "k8sAPIPort",
+		env:   "K8S_API_PORT",
+		usage: "K8sAPI port number to forward to rancher-desktop wsl-proxy as a static portMapping event",
+		field: func(c *Config) any { return &c.K8sAPIPort },
+	},
+	{
+		key:   "tapInterfaceIP",
+		flag:  "tap-interface-ip",
+		env:   "TAP_INTERFACE_IP",
+		usage: "IP address for the tap interface eth0 in network namespace",
+		field: func(c *Config) any { return &c.TapInterfaceIP },
+	},
+	{
+		key:   "relayIPv6Loopback",
+		env:   "RELAY_IPV6_LOOPBACK",
+		usage: "forward listeners that only bind [::1] through a relay on the tap interface",
+		field: func(c *Config) any { return &c.RelayIPv6Loopback },
+	},
+	{
+		key:   "healthAddr",
+		env:   "HEALTH_ADDR",
+		usage: "address to serve /healthz and /metrics on, as unix:///path or host:port; disabled if empty",
+		field: func(c *Config) any { return &c.HealthAddr },
+	},
+	{
+		key:   "healthAllowNonLoopback",
+		env:   "HEALTH_ALLOW_NON_LOOPBACK",
+		usage: "allow serving the health endpoint on a non-loopback address",
+		field: func(c *Config) any { return &c.HealthAllowNonLoopback },
+	},
+}
+
+func findOption(key string) *option {
+	for _, o := range options {
+		if o.key == key {
+			return o
+		}
+	}
+	return nil
+}
+
+// Default returns the configuration used when no option is set.
+func Default() *Config {
+	cfg := &Config{
+		LogLevel:                  "info",
+		LogFormat:                 logging.FormatText,
+		LogMaxSize:                10,
+		LogMaxFiles:               5,
+		Kubeconfig:                "/etc/rancher/k3s/k3s.yaml",
+		ContainerdSock:            "/run/k3s/containerd/containerd.sock",
+		ContainerdNamespaces:      slices.Clone(containerd.DefaultNamespaces),
+		K8sServiceListenerAddr:    net.IPv4zero.String(),
+		K8sServiceForwarding:      true,
+		IptablesScanInterval:      3 * time.Second,
+		IptablesReconcileInterval: time.Minute,
+		K8sAPIPort:                "6443",
+		TapInterfaceIP:            "192.168.127.2",
+		sources:                   make(map[string]string),
+	}
+	for _, o := range options {
+		cfg.sources[o.key] = "default"
+	}
+	return cfg
+}
+
+// Source returns where the value of the option with the given key came from,
+// such as "environment variable RD_GUESTAGENT_DEBUG".
+func (cfg *Config) Source(key string) string {
+	return cfg.sources[key]
+}
+
+// ValueError is returned for an option that can't be parsed or is invalid.
+type ValueError struct {
+	Key    string
+	Source string
+	Value  string
+	Err    error
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("invalid value %q for %s from %s: %s", e.Value, e.Key, e.Source, e.Err)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// set parses the value of an option, and records where it came from.
+func (cfg *Config) set(o *option, value, source string) error {
+	var err error
+	switch field := o.field(cfg).(type) {
+	case *bool:
+		*field, err = strconv.ParseBool(value)
+	case *string:
+		*field = value
+	case *int:
+		*field, err = strconv.Atoi(value)
+	case *time.Duration:
+		*field, err = time.ParseDuration(value)
+	case *[]string:
+		*field = splitList(value)
+	default:
+		panic(fmt.Sprintf("unsupported type %T for option %s", field, o.key))
+	}
+	if err != nil {
+		var numErr *strconv.NumError
+		if errors.As(err, &numErr) {
+			err = numErr.Err
+		}
+		return &ValueError{Key: o.key, Source: source, Value: value, Err: err}
+	}
+	cfg.sources[o.key] = source
+	return nil
+}
+
+// get returns the value of an option, in the format set parses.
+func (cfg *Config) get(o *option) string {
+	switch field := o.field(cfg).(type) {
+	case *bool:
+		return strconv.FormatBool(*field)
+	case *string:
+		return *field
+	case *int:
+		return strconv.Itoa(*field)
+	case *time.Duration:
+		return field.String()
+	case *[]string:
+		return strings.Join(*field, ",")
+	}
+	panic(fmt.Sprintf("unsupported type for option %s", o.key))
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate checks the options that can't be checked on their own, naming the
+// offending keys and where they were set.
+func (cfg *Config) Validate() error {
+	invalid := func(key string, err error) error {
+		o := findOption(key)
+		return &ValueError{Key: key, Source: cfg.sources[key], Value: cfg.get(o), Err: err}
+	}
+	switch {
+	case !cfg.Containerd && !cfg.Docker:
+		return errors.New("requires either docker or containerd enabled")
+	case cfg.Containerd && cfg.Docker:
+		return fmt.Errorf("requires either docker (from %s) or containerd (from %s) but not both",
+			cfg.sources["docker"], cfg.sources["containerd"])
+	}
+	if !slices.Contains(logging.Levels, cfg.LogLevel) {
+		return invalid("logLevel", fmt.Errorf("valid options are %s", strings.Join(logging.Levels, ", ")))
+	}
+	if cfg.LogFormat != logging.FormatText && cfg.LogFormat != logging.FormatJSON {
+		return invalid("logFormat", fmt.Errorf("valid options are %s and %s", logging.FormatText, logging.FormatJSON))
+	}
+	if cfg.LogMaxSize < 0 {
+		return invalid("logMaxSize", errors.New("must not be negative"))
+	}
+	if cfg.LogMaxFiles < 0 {
+		return invalid("logMaxFiles", errors.New("must not be negative"))
+	}
+	if cfg.Containerd && len(cfg.ContainerdNamespaces) == 0 {
+		return invalid("containerdNamespaces", errors.New("requires at least one namespace to watch"))
+	}
+	if cfg.IptablesScanInterval <= 0 {
+		return invalid("iptablesScanInterval", errors.New("must be positive"))
+	}
+	if cfg.IptablesReconcileInterval <= 0 {
+		return invalid("iptablesReconcileInterval", errors.New("must be positive"))
+	}
+	if ip := net.ParseIP(cfg.K8sServiceListenerAddr); ip == nil || (!ip.Equal(net.IPv4zero) && !ip.Equal(net.IPv4(127, 0, 0, 1))) {
+		return invalid("k8sServiceListenerAddr", errors.New("valid options are 0.0.0.0 and 127.0.0.1"))
+	}
+	if port, err := strconv.ParseUint(cfg.K8sAPIPort, 10, 16); err != nil || port == 0 {
+		return invalid("k8sAPIPort", errors.New("not a port number"))
+	}
+	if net.ParseIP(cfg.TapInterfaceIP) == nil {
+		return invalid("tapInterfaceIP", errors.New("not an IP address"))
+	}
+	return nil
+}
+
+// Changes returns the keys of the options that differ between the two
+// configurations, split into those that are applied on reload and those that
+// need a restart.
+func (cfg *Config) Changes(other *Config) (reloadable, restart []string) {
+	for _, o := range options {
+		if cfg.get(o) == other.get(o) {
+			continue
+		}
+		if o.reloadable {
+			reloadable = append(reloadable, o.key)
+		} else {
+			restart = append(restart, o.key)
+		}
+	}
+	return reloadable, restart
+}
+
+// Write writes the configuration as YAML, in the config file format, noting
+// where each value came from.
+func (cfg *Config) Write(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, o := range options {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: cfg.get(o)}
+		switch field := o.field(cfg).(type) {
+		case *bool:
+			value.Tag = "!!bool"
+		case *int:
+			value.Tag = "!!int"
+		case *[]string:
+			value = &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+			for _, item := range *field {
+				value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+			}
+		}
+		value.LineComment = cfg.sources[o.key]
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: o.key}, value)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLoader returns a loader for the given command line arguments and
+// environment, reading the given config file contents.
+func newTestLoader(t *testing.T, contents string, env []string, args ...string) (*Loader, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "guestagent.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	flags := flag.NewFlagSet("guestagent", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	loader := NewLoader(flags)
+	loader.environ = func() []string { return env }
+	require.NoError(t, flags.Parse(append([]string{"-config", path}, args...)))
+	return loader, path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("flags override the environment, which overrides the file", func(t *testing.T) {
+		loader, path := newTestLoader(t, `
+containerd: true
+iptablesScanInterval: 5s
+iptablesReconcileInterval: 2m
+containerdNamespaces: [default, k8s.io]
+`, []string{
+			"RD_GUESTAGENT_IPTABLES_SCAN_INTERVAL=10s",
+			"RD_GUESTAGENT_DEBUG=true",
+			"OTHER=1",
+		}, "-iptablesScanInterval=20s", "-tap-interface-ip", "192.168.127.3")
+		cfg, err := loader.Load()
+		require.NoError(t, err)
+		assert.True(t, cfg.Containerd)
+		assert.True(t, cfg.Debug)
+		assert.Equal(t, []string{"default", "k8s.io"}, cfg.ContainerdNamespaces)
+		assert.Equal(t, 20*time.Second, cfg.IptablesScanInterval)
+		assert.Equal(t, 2*time.Minute, cfg.IptablesReconcileInterval)
+		assert.Equal(t, "192.168.127.3", cfg.TapInterfaceIP)
+		assert.Equal(t, "6443", cfg.K8sAPIPort)
+		assert.Equal(t, "flag -iptablesScanInterval", cfg.Source("iptablesScanInterval"))
+		assert.Equal(t, "environment variable RD_GUESTAGENT_DEBUG", cfg.Source("debug"))
+		assert.Equal(t, "config file "+path+" line 4", cfg.Source("iptablesReconcileInterval"))
+		assert.Equal(t, "default", cfg.Source("k8sAPIPort"))
+		require.NoError(t, cfg.Validate())
+	})
+	t.Run("a missing config file is only an error if it was named", func(t *testing.T) {
+		_, err := (&Loader{path: filepath.Join(t.TempDir(), "missing.yaml"), environ: func() []string { return nil }}).Load()
+		require.ErrorIs(t, err, os.ErrNotExist)
+		if _, err := os.Stat(DefaultPath); err == nil {
+			t.Skipf("%s exists", DefaultPath)
+		}
+		cfg, err := (&Loader{environ: func() []string { return []string{"RD_GUESTAGENT_DOCKER=1"} }}).Load()
+		require.NoError(t, err)
+		assert.True(t, cfg.Docker)
+	})
+	t.Run("the config file can be named in the environment", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "agent.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("kubernetes: true\n"), 0o644))
+		cfg, err := (&Loader{environ: func() []string { return []string{"RD_GUESTAGENT_CONFIG=" + path} }}).Load()
+		require.NoError(t, err)
+		assert.True(t, cfg.Kubernetes)
+	})
+	t.Run("unknown environment variables are ignored", func(t *testing.T) {
+		loader, _ := newTestLoader(t, "", []string{"RD_GUESTAGENT_DEBUGGING=1", "RD_GUESTAGENT_DOCKER=true"})
+		cfg, err := loader.Load()
+		require.NoError(t, err)
+		assert.True(t, cfg.Docker)
+		assert.False(t, cfg.Debug)
+	})
+	t.Run("options set to null keep their value", func(t *testing.T) {
+		loader, _ := newTestLoader(t, "debug: null\ndocker: ~\nk8sAPIPort:\ncontainerdNamespaces: null\nkubernetes: true\n", nil)
+		cfg, err := loader.Load()
+		require.NoError(t, err)
+		defaults := Default()
+		assert.Equal(t, defaults.Debug, cfg.Debug)
+		assert.Equal(t, defaults.Docker, cfg.Docker)
+		assert.Equal(t, defaults.K8sAPIPort, cfg.K8sAPIPort)
+		assert.Equal(t, defaults.ContainerdNamespaces, cfg.ContainerdNamespaces)
+		assert.Equal(t, "default", cfg.Source("debug"))
+		assert.True(t, cfg.Kubernetes)
+	})
+	t.Run("the config file is read again on each load", func(t *testing.T) {
+		loader, path := newTestLoader(t, "debug: false\n", nil)
+		cfg, err := loader.Load()
+		require.NoError(t, err)
+		assert.False(t, cfg.Debug)
+		require.NoError(t, os.WriteFile(path, []byte("debug: true\n"), 0o644))
+		cfg, err = loader.Load()
+		require.NoError(t, err)
+		assert.True(t, cfg.Debug)
+	})
+	t.Run("errors name the key and where it was set", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			contents string
+			env      []string
+			expected string
+		}{
+			{
+				name:     "unknown key",
+				contents: "docker: true\niptablesInterval: 3s\n",
+				expected: `config file %s line 2: unknown key "iptablesInterval"`,
+			},
+			{
+				name:     "invalid file value",
+				contents: "docker: yes please\n",
+				expected: `invalid value "yes please" for docker from config file %s line 1: invalid syntax`,
+			},
+			{
+				name:     "list for a scalar",
+				contents: "healthAddr: [a, b]\n",
+				expected: `config file %s line 1: healthAddr does not take a list`,
+			},
+			{
+				name:     "invalid environment value",
+				env:      []string{"RD_GUESTAGENT_IPTABLES_RECONCILE_INTERVAL=soon"},
+				expected: `invalid value "soon" for iptablesReconcileInterval from environment variable RD_GUESTAGENT_IPTABLES_RECONCILE_INTERVAL: time: invalid duration "soon"`,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				loader, path := newTestLoader(t, tt.contents, tt.env)
+				_, err := loader.Load()
+				assert.EqualError(t, err, strings.ReplaceAll(tt.expected, "%s", path))
+			})
+		}
+	})
+	t.Run("invalid flags are rejected when parsing", func(t *testing.T) {
+		flags := flag.NewFlagSet("guestagent", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		NewLoader(flags)
+		err := flags.Parse([]string{"-iptablesScanInterval", "often"})
+		assert.EqualError(t, err, `invalid value "often" for flag -iptablesScanInterval: time: invalid duration "often"`)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	loader, path := newTestLoader(t, "containerd: true\ndocker: true\n", nil)
+	cfg, err := loader.Load()
+	require.NoError(t, err)
+	assert.EqualError(t, cfg.Validate(), "requires either docker (from config file "+path+" line 2) or containerd (from config file "+path+" line 1) but not both")
+
+	loader, _ = newTestLoader(t, "docker: true\n", []string{"RD_GUESTAGENT_K8S_SERVICE_LISTENER_ADDR=10.0.0.1"})
+	cfg, err = loader.Load()
+	require.NoError(t, err)
+	assert.EqualError(t, cfg.Validate(), `invalid value "10.0.0.1" for k8sServiceListenerAddr from environment variable RD_GUESTAGENT_K8S_SERVICE_LISTENER_ADDR: valid options are 0.0.0.0 and 127.0.0.1`)
+
+	loader, _ = newTestLoader(t, "containerd: true\n", nil, "-containerdNamespaces", " , ")
+	cfg, err = loader.Load()
+	require.NoError(t, err)
+	assert.EqualError(t, cfg.Validate(), `invalid value "" for containerdNamespaces from flag -containerdNamespaces: requires at least one namespace to watch`)
+
+	loader, path = newTestLoader(t, "docker: true\nlogLevel: verbose\n", nil)
+	cfg, err = loader.Load()
+	require.NoError(t, err)
+	assert.EqualError(t, cfg.Validate(), `invalid value "verbose" for logLevel from config file `+path+` line 2: valid options are trace, debug, info, warn, error`)
+}
+
+func TestChanges(t *testing.T) {
+	loader, path := newTestLoader(t, "docker: true\n", nil)
+	started, err := loader.Load()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("docker: true\ndebug: true\nhealthAddr: unix:///run/agent.sock\n"), 0o644))
+	reloaded, err := loader.Load()
+	require.NoError(t, err)
+	reloadable, restart := started.Changes(reloaded)
+	assert.Equal(t, []string{"debug"}, reloadable)
+	assert.Equal(t, []string{"healthAddr"}, restart)
+}
+
+func TestWrite(t *testing.T) {
+	loader, path := newTestLoader(t, "containerdNamespaces:\n  - default\n  - k8s.io\n", []string{"RD_GUESTAGENT_HEALTH_ADDR=unix:///run/agent.sock"})
+	cfg, err := loader.Load()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, cfg.Write(&buf))
+	assert.Contains(t, buf.String(), "containerdNamespaces: [default, k8s.io] # config file "+path+" line 1\n")
+	assert.Contains(t, buf.String(), "healthAddr: unix:///run/agent.sock # environment variable RD_GUESTAGENT_HEALTH_ADDR\n")
+	assert.Contains(t, buf.String(), "k8sAPIPort: \"6443\" # default\n")
+	assert.Contains(t, buf.String(), "logMaxSize: 10 # default\n")
+
+	// The output can be used as a config file.
+	written := filepath.Join(t.TempDir(), "written.yaml")
+	require.NoError(t, os.WriteFile(written, buf.Bytes(), 0o644))
+	reloaded, err := (&Loader{path: written, environ: func() []string { return nil }}).Load()
+	require.NoError(t, err)
+	reloadable, restart := cfg.Changes(reloaded)
+	assert.Empty(t, reloadable)
+	assert.Empty(t, restart)
+}
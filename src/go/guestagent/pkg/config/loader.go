@@ -0,0 +1,215 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/log-go"
+	"gopkg.in/yaml.v3"
+)
+
+// Loader loads the configuration from its sources. The command line is only
+// parsed once, but the config file is read again on every Load, so that it
+// can be changed while the guest agent runs.
+type Loader struct {
+	// The config file set with the -config flag, if any.
+	path string
+	// The values of the flags set on the command line, in the order given.
+	flags []flagSetting
+	// environ returns the environment variables; overridden in tests.
+	environ func() []string
+}
+
+type flagSetting struct {
+	option *option
+	value  string
+}
+
+// NewLoader returns a loader that takes flags from the given flag set, and
+// registers a flag for each option, and for the config file, in it.
+func NewLoader(flags *flag.FlagSet) *Loader {
+	loader := &Loader{environ: os.Environ}
+	flags.StringVar(&loader.path, "config", "",
+		fmt.Sprintf("path to the config file (default %s, or $%s)", DefaultPath, pathEnv))
+	defaults := Default()
+	for _, o := range options {
+		value := defaults.get(o)
+		if value == "false" {
+			// Boolean flags are off unless given; don't list a default.
+			value = ""
+		}
+		flags.Var(&flagValue{loader: loader, option: o, value: value}, o.flagName(), o.usage)
+	}
+	return loader
+}
+
+// flagValue is the flag.Value of an option; it only records the value given,
+// which is applied on top of the other sources on each Load.
+type flagValue struct {
+	loader *Loader
+	option *option
+	value  string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	// Parse the value right away, so that the flag package reports errors.
+	if err := Default().set(f.option, value, "flag"); err != nil {
+		var valueErr *ValueError
+		if errors.As(err, &valueErr) {
+			return valueErr.Err
+		}
+		return err
+	}
+	f.value = value
+	f.loader.flags = append(f.loader.flags, flagSetting{option: f.option, value: value})
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	if f == nil || f.option == nil {
+		return false
+	}
+	_, ok := f.option.field(&Config{}).(*bool)
+	return ok
+}
+
+// Load returns the configuration from the defaults, the config file, the
+// environment and the command line flags, each overriding the ones before.
+// A missing config file is only an error if it was named explicitly, and
+// unknown environment variables are only logged. The configuration is not
+// validated; see Config.Validate.
+func (loader *Loader) Load() (*Config, error) {
+	cfg := Default()
+	env := make(map[string]string)
+	for _, entry := range loader.environ() {
+		if name, value, ok := strings.Cut(entry, "="); ok && strings.HasPrefix(name, EnvPrefix) {
+			env[name] = value
+		}
+	}
+
+	path, explicit := loader.path, true
+	if path == "" {
+		path, explicit = env[pathEnv], env[pathEnv] != ""
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+	if err := cfg.loadFile(path, explicit); err != nil {
+		return nil, err
+	}
+
+	// Unknown variables may be meant for another version of the guest agent,
+	// so they don't stop it from starting.
+	names := slices.Sorted(maps.Keys(env))
+	for _, name := range names {
+		if name != pathEnv && findEnvOption(strings.TrimPrefix(name, EnvPrefix)) == nil {
+			log.Warnf("ignoring unknown environment variable %s", name)
+		}
+	}
+	for _, o := range options {
+		name := EnvPrefix + o.env
+		if value, ok := env[name]; ok {
+			if err := cfg.set(o, value, "environment variable "+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, setting := range loader.flags {
+		if err := cfg.set(setting.option, setting.value, "flag -"+setting.option.flagName()); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func findEnvOption(env string) *option {
+	for _, o := range options {
+		if o.env == env {
+			return o
+		}
+	}
+	return nil
+}
+
+// loadFile sets the options in the config file at path.
+func (cfg *Config) loadFile(path string, explicit bool) error {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		// The file is empty, or only has comments.
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s line %d: expected a mapping of options", path, root.Line)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		source := fmt.Sprintf("config file %s line %d", path, keyNode.Line)
+		o := findOption(keyNode.Value)
+		if o == nil {
+			return fmt.Errorf("%s: unknown key %q", source, keyNode.Value)
+		}
+		if valueNode.Kind == yaml.ScalarNode && valueNode.Tag == "!!null" {
+			// An option set to nothing, or null, keeps its value.
+			continue
+		}
+		var value string
+		switch valueNode.Kind {
+		case yaml.ScalarNode:
+			value = valueNode.Value
+		case yaml.SequenceNode:
+			if _, ok := o.field(cfg).(*[]string); !ok {
+				return fmt.Errorf("%s: %s does not take a list", source, o.key)
+			}
+			var items []string
+			for _, item := range valueNode.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("%s: %s must be a list of strings", source, o.key)
+				}
+				items = append(items, item.Value)
+			}
+			value = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("%s: unexpected value for %s", source, o.key)
+		}
+		if err := cfg.set(o, value, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
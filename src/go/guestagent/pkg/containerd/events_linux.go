@@ -25,18 +25,22 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/Masterminds/log-go"
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/errdefs"
+	containerdEvents "github.com/containerd/containerd/events"
 	"github.com/containerd/containerd/namespaces"
 	cnutils "github.com/containernetworking/plugins/pkg/utils"
 	"github.com/docker/go-connections/nat"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
@@ -48,20 +52,45 @@ const (
 	networkKey   = "nerdctl/networks"
 )
 
+// DefaultNamespaces are the containerd namespaces watched unless configured
+// otherwise; it is the namespace nerdctl uses by default.
+var DefaultNamespaces = []string{namespaces.Default}
+
+// containerdClient is the part of *containerd.Client used by the
+// EventMonitor, so that tests can substitute a fake.
+type containerdClient interface {
+	Subscribe(ctx context.Context, filters ...string) (<-chan *containerdEvents.Envelope, <-chan error)
+	ContainerService() containers.Store
+	LoadContainer(ctx context.Context, id string) (containerd.Container, error)
+	Containers(ctx context.Context, filters ...string) ([]containerd.Container, error)
+	IsServing(ctx context.Context) (bool, error)
+	Close() error
+}
+
 // EventMonitor monitors the Containerd API
 // for container events.
 type EventMonitor struct {
-	containerdClient *containerd.Client
+	containerdClient containerdClient
 	portTracker      tracker.Tracker
+	// the containerd namespaces to watch for containers
+	namespaces []string
 }
 
 // NewEventMonitor creates and returns a new Event Monitor for
 // Containerd API. Caller is responsible to make sure that
 // Docker engine is up and running.
+//
+// Only containers in the given containerd namespaces are tracked; as
+// container IDs are only unique within a namespace, the containers are
+// tracked as <namespace>/<id>.
 func NewEventMonitor(
 	containerdSock string,
 	portTracker tracker.Tracker,
+	watchNamespaces []string,
 ) (*EventMonitor, error) {
+	if len(watchNamespaces) == 0 {
+		return nil, errors.New("no containerd namespaces to watch")
+	}
 	client, err := containerd.New(containerdSock, containerd.WithDefaultNamespace(namespaces.Default))
 	if err != nil {
 		return nil, err
@@ -70,18 +99,43 @@ func NewEventMonitor(
 	return &EventMonitor{
 		containerdClient: client,
 		portTracker:      portTracker,
+		namespaces:       watchNamespaces,
 	}, nil
 }
 
+// containerKey returns the key a container is tracked as.
+func containerKey(namespace, containerID string) string {
+	return namespace + "/" + containerID
+}
+
+// watches returns whether the monitor tracks containers in the namespace.
+func (e *EventMonitor) watches(namespace string) bool {
+	return slices.Contains(e.namespaces, namespace)
+}
+
+// subscribeFilters returns the event filters for the topics the monitor
+// handles, in the namespaces it watches.
+func (e *EventMonitor) subscribeFilters() []string {
+	topics := []string{
+		"/tasks/start",
+		"/containers/update",
+		"/tasks/exit",
+	}
+	filters := make([]string, 0, len(topics)*len(e.namespaces))
+	for _, namespace := range e.namespaces {
+		for _, topic := range topics {
+			filters = append(filters, fmt.Sprintf(`topic==%q,namespace==%q`, topic, namespace))
+		}
+	}
+
+	return filters
+}
+
 // MonitorPorts subscribes to event API
 // for container Create/Update/Delete events.
 func (e *EventMonitor) MonitorPorts(ctx context.Context) {
-	subscribeFilters := []string{
-		`topic=="/tasks/start"`,
-		`topic=="/containers/update"`,
-		`topic=="/tasks/exit"`,
-	}
-	msgCh, errCh := e.containerdClient.Subscribe(ctx, subscribeFilters...)
+	msgCh, errCh := e.containerdClient.Subscribe(ctx, e.subscribeFilters()...)
+	health.WatcherSucceeded("containerd")
 
 	go e.initializeRunningContainers(ctx)
 
@@ -92,7 +146,15 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 
 			return
 		case envelope := <-msgCh:
-			log.Debugf("received an event: %+v", envelope.Topic)
+			log.Debugf("received an event: %+v in namespace: %s", envelope.Topic, envelope.Namespace)
+			health.WatcherSucceeded("containerd")
+
+			if !e.watches(envelope.Namespace) {
+				log.Debugf("ignoring event from unwatched namespace: %s", envelope.Namespace)
+
+				continue
+			}
+			ctx := namespaces.WithNamespace(ctx, envelope.Namespace)
 
 			switch envelope.Topic {
 			case "/tasks/start":
@@ -120,7 +182,7 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					log.Errorf("failed running iptable rules to update DNAT rule in CNI-HOSTPORT-DNAT chain: %v", err)
 				}
 
-				err = e.portTracker.Add(startTask.ContainerID, ports)
+				err = e.portTracker.Add(containerKey(envelope.Namespace, startTask.ContainerID), ports)
 				if err != nil {
 					log.Errorf("adding port mapping to tracker failed: %v", err)
 
@@ -148,15 +210,16 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					continue
 				}
 
-				existingPortMap := e.portTracker.Get(cuEvent.ID)
+				key := containerKey(envelope.Namespace, cuEvent.ID)
+				existingPortMap := e.portTracker.Get(key)
 				if existingPortMap != nil {
 					if !reflect.DeepEqual(ports, existingPortMap) {
-						err := e.portTracker.Remove(cuEvent.ID)
+						err := e.portTracker.Remove(key)
 						if err != nil {
 							log.Errorf("failed to remove port mapping from container update event: %v", err)
 						}
 
-						err = e.portTracker.Add(cuEvent.ID, ports)
+						err = e.portTracker.Add(key, ports)
 						if err != nil {
 							log.Errorf("failed to add port mapping from container update event: %v", err)
 
@@ -167,7 +230,7 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					continue
 				}
 				// Not 100% sure if we ever get here...
-				if err = e.portTracker.Add(cuEvent.ID, ports); err != nil {
+				if err = e.portTracker.Add(key, ports); err != nil {
 					log.Errorf("failed to add port mapping from container update event: %v", err)
 				}
 
@@ -182,7 +245,7 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 				if err != nil {
 					if errdefs.IsNotFound(err) {
 						log.Debugf("container: %s in namespace: %s not found, deleting port mapping", exitTask.ContainerID, envelope.Namespace)
-						e.removePortMapping(exitTask.ContainerID)
+						e.removePortMapping(containerKey(envelope.Namespace, exitTask.ContainerID))
 						continue
 					}
 					log.Errorf("failed to get the container %s from namespace %s: %s", exitTask.ContainerID, envelope.Namespace, err)
@@ -193,7 +256,7 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 				if err != nil {
 					if errdefs.IsNotFound(err) {
 						log.Debugf("task for container %s in namespace %s not found, deleting port mapping", exitTask.ContainerID, envelope.Namespace)
-						e.removePortMapping(exitTask.ContainerID)
+						e.removePortMapping(containerKey(envelope.Namespace, exitTask.ContainerID))
 						continue
 					}
 					log.Errorf("failed to get the task for container %s: %s", exitTask.ContainerID, err)
@@ -210,7 +273,7 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					continue
 				}
 
-				e.removePortMapping(exitTask.ContainerID)
+				e.removePortMapping(containerKey(envelope.Namespace, exitTask.ContainerID))
 			}
 
 		case err := <-errCh:
@@ -241,14 +304,22 @@ func (e *EventMonitor) IsServing(ctx context.Context) error {
 // startup or due to timing issues, this acts as a backup to capture all
 // previously running containers.
 func (e *EventMonitor) initializeRunningContainers(ctx context.Context) {
-	containers, err := e.containerdClient.Containers(ctx)
+	for _, namespace := range e.namespaces {
+		e.initializeNamespace(namespaces.WithNamespace(ctx, namespace), namespace)
+	}
+}
+
+// initializeNamespace adds the running containers in one namespace.
+func (e *EventMonitor) initializeNamespace(ctx context.Context, namespace string) {
+	containerList, err := e.containerdClient.Containers(ctx)
 	if err != nil {
-		log.Errorf("failed getting containers: %s", err)
+		log.Errorf("failed getting containers in namespace %s: %s", namespace, err)
 		return
 	}
-	for _, c := range containers {
+	for _, c := range containerList {
+		key := containerKey(namespace, c.ID())
 		// skip already added containers
-		if len(e.portTracker.Get(c.ID())) != 0 {
+		if len(e.portTracker.Get(key)) != 0 {
 			continue
 		}
 		t, err := c.Task(ctx, nil)
@@ -284,14 +355,14 @@ func (e *EventMonitor) initializeRunningContainers(ctx context.Context) {
 			log.Errorf("failed running iptable rules to update DNAT rule in CNI-HOSTPORT-DNAT chain: %v", err)
 		}
 
-		err = e.portTracker.Add(c.ID(), ports)
+		err = e.portTracker.Add(key, ports)
 		if err != nil {
 			log.Errorf("adding port mapping to tracker failed: %v", err)
 
 			continue
 		}
 
-		log.Debugf("initialized container %s task status: %+v with ports: %+v", c.ID(), status, ports)
+		log.Debugf("initialized container %s task status: %+v with ports: %+v", key, status, ports)
 	}
 }
 
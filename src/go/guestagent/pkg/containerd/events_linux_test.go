@@ -0,0 +1,278 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	containerdEvents "github.com/containerd/containerd/events"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// fakeTask is a running task; only the methods used by the monitor are
+// implemented.
+type fakeTask struct {
+	containerd.Task
+}
+
+func (fakeTask) Pid() uint32 { return 1 }
+
+func (fakeTask) Status(context.Context) (containerd.Status, error) {
+	return containerd.Status{Status: containerd.Running}, nil
+}
+
+// fakeContainer is a container with a running task; only the methods used by
+// the monitor are implemented.
+type fakeContainer struct {
+	containerd.Container
+	info containers.Container
+}
+
+func (c fakeContainer) ID() string { return c.info.ID }
+
+func (c fakeContainer) Labels(context.Context) (map[string]string, error) {
+	return c.info.Labels, nil
+}
+
+func (fakeContainer) Task(context.Context, cio.Attach) (containerd.Task, error) {
+	return fakeTask{}, nil
+}
+
+// fakeClient holds containers by namespace, looked up by the namespace of the
+// context like the real client does.  Events sent to it are delivered as is,
+// without applying the subscription filters.
+type fakeClient struct {
+	mutex      sync.Mutex
+	containers map[string]map[string]containers.Container
+	filters    []string
+	events     chan *containerdEvents.Envelope
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		containers: make(map[string]map[string]containers.Container),
+		events:     make(chan *containerdEvents.Envelope),
+	}
+}
+
+func (c *fakeClient) add(namespace, id string, hostPort string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.containers[namespace] == nil {
+		c.containers[namespace] = make(map[string]containers.Container)
+	}
+	c.containers[namespace][id] = containers.Container{
+		ID: id,
+		Labels: map[string]string{
+			portsKey: `[{"HostPort":` + hostPort + `,"ContainerPort":80,"Protocol":"tcp","HostIP":"0.0.0.0"}]`,
+		},
+	}
+}
+
+func (c *fakeClient) remove(namespace, id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.containers[namespace], id)
+}
+
+func (c *fakeClient) lookup(ctx context.Context, id string) (containers.Container, error) {
+	namespace, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return containers.Container{}, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	container, ok := c.containers[namespace][id]
+	if !ok {
+		return containers.Container{}, errdefs.ErrNotFound
+	}
+	return container, nil
+}
+
+func (c *fakeClient) Subscribe(_ context.Context, filters ...string) (<-chan *containerdEvents.Envelope, <-chan error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.filters = filters
+	return c.events, make(chan error)
+}
+
+func (c *fakeClient) ContainerService() containers.Store {
+	return fakeStore{client: c}
+}
+
+func (c *fakeClient) LoadContainer(ctx context.Context, id string) (containerd.Container, error) {
+	info, err := c.lookup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return fakeContainer{info: info}, nil
+}
+
+func (c *fakeClient) Containers(ctx context.Context, _ ...string) ([]containerd.Container, error) {
+	namespace, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var result []containerd.Container
+	for _, info := range c.containers[namespace] {
+		result = append(result, fakeContainer{info: info})
+	}
+	return result, nil
+}
+
+func (c *fakeClient) IsServing(context.Context) (bool, error) { return true, nil }
+
+func (c *fakeClient) Close() error { return nil }
+
+type fakeStore struct {
+	containers.Store
+	client *fakeClient
+}
+
+func (s fakeStore) Get(ctx context.Context, id string) (containers.Container, error) {
+	return s.client.lookup(ctx, id)
+}
+
+// fakeTracker records the port mappings by key.
+type fakeTracker struct {
+	mutex    sync.Mutex
+	portMaps map[string]nat.PortMap
+}
+
+func (t *fakeTracker) Get(containerID string) nat.PortMap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.portMaps[containerID]
+}
+
+func (t *fakeTracker) Add(containerID string, portMapping nat.PortMap) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.portMaps[containerID] = portMapping
+	return nil
+}
+
+func (t *fakeTracker) Remove(containerID string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.portMaps, containerID)
+	return nil
+}
+
+func (t *fakeTracker) RemoveAll() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	clear(t.portMaps)
+	return nil
+}
+
+// keys returns the tracked containers and their host ports.
+func (t *fakeTracker) keys() map[string]string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	keys := make(map[string]string, len(t.portMaps))
+	for key, portMap := range t.portMaps {
+		for _, bindings := range portMap {
+			for _, binding := range bindings {
+				keys[key] = binding.HostPort
+			}
+		}
+	}
+	return keys
+}
+
+func envelope(t *testing.T, namespace, topic string, event proto.Message) *containerdEvents.Envelope {
+	t.Helper()
+	value, err := anypb.New(event)
+	require.NoError(t, err)
+	return &containerdEvents.Envelope{Namespace: namespace, Topic: topic, Event: value}
+}
+
+func TestEventMonitorNamespaces(t *testing.T) {
+	t.Parallel()
+
+	client := newFakeClient()
+	client.add("default", "web", "8080")
+	client.add("custom", "web", "8081")
+	client.add("k8s.io", "web", "8082")
+
+	portTracker := &fakeTracker{portMaps: make(map[string]nat.PortMap)}
+	monitor := &EventMonitor{
+		containerdClient: client,
+		portTracker:      portTracker,
+		namespaces:       []string{"default", "custom"},
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		monitor.MonitorPorts(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Containers with the same ID in different watched namespaces are
+	// tracked separately; unwatched namespaces are skipped.
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]string{
+			"default/web": "8080",
+			"custom/web":  "8081",
+		}, portTracker.keys())
+	}, 5*time.Second, 10*time.Millisecond, "running containers were not discovered")
+
+	client.mutex.Lock()
+	assert.ElementsMatch(t, []string{
+		`topic=="/tasks/start",namespace=="default"`,
+		`topic=="/containers/update",namespace=="default"`,
+		`topic=="/tasks/exit",namespace=="default"`,
+		`topic=="/tasks/start",namespace=="custom"`,
+		`topic=="/containers/update",namespace=="custom"`,
+		`topic=="/tasks/exit",namespace=="custom"`,
+	}, client.filters)
+	client.mutex.Unlock()
+
+	// Events from unwatched namespaces are ignored even if delivered.
+	client.add("k8s.io", "api", "9090")
+	client.events <- envelope(t, "k8s.io", "/tasks/start", &events.TaskStart{ContainerID: "api", Pid: 1})
+	client.add("custom", "api", "9091")
+	client.events <- envelope(t, "custom", "/tasks/start", &events.TaskStart{ContainerID: "api", Pid: 1})
+
+	// Removing a container only removes it from its own namespace.
+	client.remove("default", "web")
+	client.events <- envelope(t, "default", "/tasks/exit", &events.TaskExit{ContainerID: "web"})
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]string{
+			"custom/web": "8081",
+			"custom/api": "9091",
+		}, portTracker.keys())
+	}, 5*time.Second, 10*time.Millisecond, "events were not applied per namespace")
+}
@@ -22,10 +22,12 @@ import (
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 )
 
+var DefaultNamespaces = []string{"default"}
+
 type EventMonitor struct {
 }
 
-func NewEventMonitor(containerdSock string, portTracker tracker.Tracker) (*EventMonitor, error) {
+func NewEventMonitor(containerdSock string, portTracker tracker.Tracker, watchNamespaces []string) (*EventMonitor, error) {
 	panic("not implement for non-Linux")
 }
 
@@ -27,17 +27,28 @@ import (
 	containerapi "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
 
+// dockerClient is the part of *client.Client used by the EventMonitor, so
+// that tests can substitute a fake.
+type dockerClient interface {
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+	ContainerInspect(ctx context.Context, containerID string) (containerapi.InspectResponse, error)
+	ContainerList(ctx context.Context, options containerapi.ListOptions) ([]containerapi.Summary, error)
+	Info(ctx context.Context) (system.Info, error)
+}
+
 // EventMonitor monitors the Docker engine's Event API
 // for container events.
 type EventMonitor struct {
-	dockerClient *client.Client
+	dockerClient dockerClient
 	portTracker  tracker.Tracker
 	// map of containerID to iptables rule entry to remove from DOCKER chain
 	iptablesRulesToDelete map[string]*exec.Cmd
@@ -72,6 +83,8 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 
 	if err := e.initializeRunningContainers(ctx); err != nil {
 		log.Errorf("failed to initialize existing container port mappings: %s", err)
+	} else {
+		health.WatcherSucceeded("docker")
 	}
 
 	for {
@@ -81,43 +94,16 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 
 			return
 		case event := <-msgCh:
-			container, err := e.dockerClient.ContainerInspect(ctx, event.Actor.ID)
-			if err != nil {
-				log.Errorf("inspecting container [%v] failed: %s", event.Actor.ID, err)
-
-				continue
-			}
-
-			log.Debugf("received an event: {Status: %+v ContainerID: %+v Ports: %+v}",
-				event.Action,
-				event.Actor.ID,
-				container.NetworkSettings.Ports)
+			log.Debugf("received an event: {Status: %+v ContainerID: %+v}", event.Action, event.Actor.ID)
+			health.WatcherSucceeded("docker")
 
 			switch event.Action {
 			case events.ActionStart:
-				if len(container.NetworkSettings.Ports) != 0 {
-					validatePortMapping(container.NetworkSettings.Ports)
-					err = e.portTracker.Add(container.ID, container.NetworkSettings.Ports)
-					if err != nil {
-						log.Errorf("adding port mapping to tracker failed: %s", err)
-					}
-
-					e.createIptablesRuleForContainer(ctx, container)
-				}
+				e.addContainer(ctx, event.Actor.ID)
 			case events.ActionStop, events.ActionDie:
-				err := e.portTracker.Remove(container.ID)
-				if err != nil {
-					log.Errorf("remove port mapping from tracker failed: %s", err)
-				}
-				if deleteIptablesCmd, ok := e.iptablesRulesToDelete[container.ID]; ok {
-					log.Debugf("removing the following rules from iptables: %s", deleteIptablesCmd.String())
-					var stderr bytes.Buffer
-					deleteIptablesCmd.Stderr = &stderr
-					if err := deleteIptablesCmd.Run(); err != nil {
-						log.Errorf("deleting loopback iptables rule failed: %s [%s]", err, stderr.String())
-					}
-					delete(e.iptablesRulesToDelete, container.ID)
-				}
+				// The container may already be gone (e.g. `docker run --rm`),
+				// so removal must only rely on the ID from the event.
+				e.removeContainer(event.Actor.ID)
 			}
 		case err := <-errCh:
 			log.Errorf("receiving container event failed: %s", err)
@@ -127,6 +113,46 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 	}
 }
 
+// addContainer inspects a newly started container and forwards its
+// published ports.
+func (e *EventMonitor) addContainer(ctx context.Context, containerID string) {
+	container, err := e.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		log.Errorf("inspecting container [%v] failed: %s", containerID, err)
+
+		return
+	}
+
+	log.Debugf("container [%v] started with ports: %+v", containerID, container.NetworkSettings.Ports)
+
+	if len(container.NetworkSettings.Ports) == 0 {
+		return
+	}
+	validatePortMapping(container.NetworkSettings.Ports)
+	if err := e.portTracker.Add(container.ID, container.NetworkSettings.Ports); err != nil {
+		log.Errorf("adding port mapping to tracker failed: %s", err)
+	}
+
+	e.createIptablesRuleForContainer(ctx, container)
+}
+
+// removeContainer stops forwarding the ports for a stopped container and
+// removes any loopback iptables rules that were created for it.
+func (e *EventMonitor) removeContainer(containerID string) {
+	if err := e.portTracker.Remove(containerID); err != nil {
+		log.Errorf("remove port mapping from tracker failed: %s", err)
+	}
+	if deleteIptablesCmd, ok := e.iptablesRulesToDelete[containerID]; ok {
+		log.Debugf("removing the following rules from iptables: %s", deleteIptablesCmd.String())
+		var stderr bytes.Buffer
+		deleteIptablesCmd.Stderr = &stderr
+		if err := deleteIptablesCmd.Run(); err != nil {
+			log.Errorf("deleting loopback iptables rule failed: %s [%s]", err, stderr.String())
+		}
+		delete(e.iptablesRulesToDelete, containerID)
+	}
+}
+
 // Flush clears all the container port mappings
 // out of the port tracker upon shutdown.
 func (e *EventMonitor) Flush() {
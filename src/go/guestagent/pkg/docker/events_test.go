@@ -0,0 +1,180 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	containerapi "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClient holds containers by ID; inspecting a container that is not
+// there fails with a not found error, like the real client does.  Events sent
+// to it are delivered as is, without applying the filters.
+type fakeClient struct {
+	mutex      sync.Mutex
+	containers map[string]containerapi.InspectResponse
+	events     chan events.Message
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		containers: make(map[string]containerapi.InspectResponse),
+		events:     make(chan events.Message),
+	}
+}
+
+func (c *fakeClient) add(id, hostPort string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.containers[id] = containerapi.InspectResponse{
+		ContainerJSONBase: &containerapi.ContainerJSONBase{ID: id},
+		NetworkSettings: &containerapi.NetworkSettings{
+			NetworkSettingsBase: containerapi.NetworkSettingsBase{ //nolint:staticcheck // Ports moves to NetworkSettings in v29
+				Ports: nat.PortMap{"80/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}}},
+			},
+		},
+	}
+}
+
+func (c *fakeClient) remove(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.containers, id)
+}
+
+func (c *fakeClient) Events(context.Context, events.ListOptions) (<-chan events.Message, <-chan error) {
+	return c.events, make(chan error)
+}
+
+func (c *fakeClient) ContainerInspect(_ context.Context, containerID string) (containerapi.InspectResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	container, ok := c.containers[containerID]
+	if !ok {
+		return containerapi.InspectResponse{}, errdefs.NotFound(fmt.Errorf("No such container: %s", containerID))
+	}
+	return container, nil
+}
+
+func (c *fakeClient) ContainerList(context.Context, containerapi.ListOptions) ([]containerapi.Summary, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) Info(context.Context) (system.Info, error) {
+	return system.Info{}, nil
+}
+
+// fakeTracker records the port mappings by container ID, and the containers
+// removed.
+type fakeTracker struct {
+	mutex    sync.Mutex
+	portMaps map[string]nat.PortMap
+	removed  []string
+}
+
+func (t *fakeTracker) Get(containerID string) nat.PortMap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.portMaps[containerID]
+}
+
+func (t *fakeTracker) Add(containerID string, portMapping nat.PortMap) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.portMaps[containerID] = portMapping
+	return nil
+}
+
+func (t *fakeTracker) Remove(containerID string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.removed = append(t.removed, containerID)
+	delete(t.portMaps, containerID)
+	return nil
+}
+
+func (t *fakeTracker) RemoveAll() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	clear(t.portMaps)
+	return nil
+}
+
+func (t *fakeTracker) removedContainers() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]string(nil), t.removed...)
+}
+
+func containerEvent(action events.Action, id string) events.Message {
+	return events.Message{Type: events.ContainerEventType, Action: action, Actor: events.Actor{ID: id}}
+}
+
+func TestEventMonitorRemovedContainer(t *testing.T) {
+	t.Parallel()
+
+	client := newFakeClient()
+	portTracker := &fakeTracker{portMaps: make(map[string]nat.PortMap)}
+	monitor := &EventMonitor{
+		dockerClient:          client,
+		portTracker:           portTracker,
+		iptablesRulesToDelete: make(map[string]*exec.Cmd),
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		monitor.MonitorPorts(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	client.add("web", "8080")
+	client.events <- containerEvent(events.ActionStart, "web")
+	require.Eventually(t, func() bool { return portTracker.Get("web") != nil },
+		5*time.Second, 10*time.Millisecond, "ports of the started container were not added")
+
+	// With `docker run --rm`, the container is gone by the time the events
+	// for it stopping arrive; inspecting it fails, but its ports are still
+	// removed.
+	client.remove("web")
+	client.events <- containerEvent(events.ActionDie, "web")
+	client.events <- containerEvent(events.ActionStop, "web")
+	require.Eventually(t, func() bool { return len(portTracker.removedContainers()) == 2 },
+		5*time.Second, 10*time.Millisecond, "ports of the removed container were not removed")
+	assert.Equal(t, []string{"web", "web"}, portTracker.removedContainers())
+	assert.Nil(t, portTracker.Get("web"))
+
+	// A container that is gone before its start event is not added.
+	client.events <- containerEvent(events.ActionStart, "gone")
+	client.events <- containerEvent(events.ActionDie, "gone")
+	require.Eventually(t, func() bool { return len(portTracker.removedContainers()) == 3 },
+		5*time.Second, 10*time.Millisecond, "ports of the removed container were not removed")
+	assert.Equal(t, "gone", portTracker.removedContainers()[2])
+	assert.Nil(t, portTracker.Get("gone"))
+}
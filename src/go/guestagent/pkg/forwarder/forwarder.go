@@ -16,6 +16,9 @@ limitations under the License.
 package forwarder
 
 import (
+	"context"
+	"errors"
+
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
@@ -26,3 +29,16 @@ type Forwarder interface {
 	// a tcp connection.
 	Send(portMapping types.PortMapping) error
 }
+
+// ErrNoAck is returned by AckForwarder.SendWithAck when the peer received the
+// message but closed the connection without acknowledging it, as peers that
+// predate acknowledgments do.
+var ErrNoAck = errors.New("peer did not acknowledge the message")
+
+// AckForwarder is implemented by forwarders that can wait for the peer to
+// acknowledge a message.
+type AckForwarder interface {
+	// SendWithAck sends the given port mappings, and waits for the peer to
+	// acknowledge them until the context is done.
+	SendWithAck(ctx context.Context, portMapping types.PortMapping) (types.PortMappingAck, error)
+}
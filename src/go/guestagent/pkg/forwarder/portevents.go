@@ -0,0 +1,579 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwarder
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/logging"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+const (
+	// The delay before the first attempt to reconnect to the host; it doubles
+	// with each failed attempt, up to maxReconnectDelay.
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	// How long to wait for the host to acknowledge a message.
+	ackTimeout = 5 * time.Second
+	// The smallest number of consecutive ports sent as a range.
+	minRangeCount = 2
+)
+
+// portBinding is a single forwarded port, as a comparable value.
+type portBinding struct {
+	port    nat.Port
+	binding nat.PortBinding
+}
+
+// bindConflict is a port binding the host failed to listen on.
+type bindConflict struct {
+	err   string
+	since time.Time
+}
+
+// PortEventForwarder keeps track of the ports forwarded for each container,
+// and sends only the changes to the ports the host has been told about to the
+// underlying Forwarder.  This avoids the host tearing down and recreating
+// listeners for ports that did not change.
+//
+// Every message carries a sequence number that increases by one for each
+// message, so that the host can detect lost messages.  The first message, and
+// the first message after a failure to send, is a resync message carrying the
+// complete set of ports instead of a change.
+//
+// When sending fails, the host is considered disconnected: changes are only
+// recorded, and a background loop retries the resync with exponential backoff
+// until it succeeds, so that the host catches up without waiting for the next
+// change.
+//
+// If the forwarder is an AckForwarder, messages adding ports wait for the
+// host to acknowledge them, and the ports it failed to listen on are reported
+// to the health package until they are removed or forwarded successfully.
+// Once the host has acknowledged that it supports them, consecutive ports are
+// sent as ranges, rather than a binding for each port.
+type PortEventForwarder struct {
+	ctx       context.Context
+	forwarder Forwarder
+	mutex     sync.Mutex
+	// ports forwarded for each container ID
+	ports map[string]nat.PortMap
+	// ports the host was last told about
+	sent map[portBinding]struct{}
+	seq  uint64
+	// whether the next message must be a resync
+	resync bool
+	// whether the host is unreachable, and since when
+	disconnected   bool
+	disconnectedAt time.Time
+	// the number of times the connection to the host was lost
+	disconnects int
+	// whether the ports were withdrawn for shutting down
+	shutdown bool
+	// whether to ask the host to acknowledge added ports; cleared if it
+	// turns out not to support reporting bind failures
+	acks bool
+	// whether the host was last seen accepting port ranges
+	ranges bool
+	// port bindings sent to the host that it failed to listen on
+	conflicts map[portBinding]bindConflict
+	// reconnect backoff bounds, overridden in tests
+	minDelay, maxDelay time.Duration
+}
+
+// NewPortEventForwarder returns a PortEventForwarder sending to the given
+// forwarder.  Reconnection attempts stop when the context is done.
+func NewPortEventForwarder(ctx context.Context, forwarder Forwarder) *PortEventForwarder {
+	_, acks := forwarder.(AckForwarder)
+	return &PortEventForwarder{
+		ctx:       ctx,
+		forwarder: forwarder,
+		ports:     make(map[string]nat.PortMap),
+		sent:      make(map[portBinding]struct{}),
+		resync:    true,
+		acks:      acks,
+		conflicts: make(map[portBinding]bindConflict),
+		minDelay:  minReconnectDelay,
+		maxDelay:  maxReconnectDelay,
+	}
+}
+
+// Set replaces the ports forwarded for the given container, and sends the
+// resulting changes.
+func (p *PortEventForwarder) Set(containerID string, portMap nat.PortMap) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(portMap) == 0 {
+		delete(p.ports, containerID)
+	} else {
+		p.ports[containerID] = portMap
+	}
+
+	return p.update()
+}
+
+// Remove stops forwarding the ports of the given container, and sends the
+// resulting changes.  Ports that are also forwarded for another container
+// are kept.
+func (p *PortEventForwarder) Remove(containerID string) error {
+	return p.Set(containerID, nil)
+}
+
+// RemoveAll stops forwarding the ports of all containers.
+func (p *PortEventForwarder) RemoveAll() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	clear(p.ports)
+
+	return p.update()
+}
+
+// Resync sends the complete set of forwarded ports, for use when the host may
+// have lost track of them.
+func (p *PortEventForwarder) Resync() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.resync = true
+
+	return p.update()
+}
+
+// Shutdown withdraws all forwarded ports from the host, for when the agent is
+// stopping, so that the host does not keep listening on them until it notices
+// that the agent is gone.  If the host acknowledges that it supports it, a
+// single withdraw-all message is sent; otherwise the ports it was told about
+// are removed.  No further changes are sent afterwards.
+func (p *PortEventForwarder) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	clear(p.ports)
+	p.shutdown = true
+	p.clearConflicts()
+	if ackForwarder, ok := p.forwarder.(AckForwarder); ok {
+		p.seq++
+		ack, err := ackForwarder.SendWithAck(ctx, types.PortMapping{WithdrawAll: true, Seq: p.seq})
+		if err == nil && slices.Contains(ack.Capabilities, types.CapabilityWithdrawAll) {
+			log.Infof("withdrew %d forwarded ports from the host", len(p.sent))
+			clear(p.sent)
+			health.SetPortsTracked(0)
+
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("host did not acknowledge withdrawing all ports: %w", ctx.Err())
+		}
+		log.Debugf("host does not support withdrawing all ports, removing them individually: %v", err)
+	}
+	if len(p.sent) == 0 {
+		return nil
+	}
+	if err := p.send(true, false, p.sent); err != nil {
+		return err
+	}
+	log.Infof("removed %d forwarded ports from the host", len(p.sent))
+	clear(p.sent)
+	health.SetPortsTracked(0)
+
+	return nil
+}
+
+// update sends the pending changes, unless the host is disconnected, in which
+// case they are sent as part of the resync on reconnection.  The caller must
+// hold the mutex.
+func (p *PortEventForwarder) update() error {
+	if p.shutdown {
+		return nil
+	}
+	if p.disconnected {
+		log.Debugf("host port forwarder is disconnected; deferring port mapping changes")
+		return nil
+	}
+	if err := p.flush(); err != nil {
+		health.SetHostConnected(false)
+		p.disconnected = true
+		p.disconnectedAt = time.Now()
+		p.disconnects++
+		log.Warnw("host port forwarder connection state: disconnected", log.Fields{
+			logging.FieldEvent: logging.EventDisconnect,
+			logging.FieldError: err.Error(),
+			"disconnects":      p.disconnects,
+		})
+		go p.reconnect()
+
+		return err
+	}
+	health.SetHostConnected(true)
+
+	return nil
+}
+
+// reconnect retries sending a resync to the host until it succeeds, or the
+// context is done.
+func (p *PortEventForwarder) reconnect() {
+	delay := p.minDelay
+	for attempt := 1; ; attempt++ {
+		// Wait between half and all of the delay, so that retries from
+		// several sources don't line up.
+		jittered := delay/2 + rand.N(delay/2+1)
+		timer := time.NewTimer(jittered)
+		select {
+		case <-p.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if p.tryReconnect(attempt) {
+			return
+		}
+		delay = min(delay*2, p.maxDelay)
+	}
+}
+
+// tryReconnect makes a single attempt to resync the host, and returns whether
+// it succeeded.
+func (p *PortEventForwarder) tryReconnect(attempt int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.shutdown {
+		return true
+	}
+	p.resync = true
+	if err := p.flush(); err != nil {
+		log.Debugw("reconnection attempt to host port forwarder failed", log.Fields{
+			logging.FieldEvent: logging.EventReconnect,
+			logging.FieldError: err.Error(),
+			"attempt":          attempt,
+		})
+		return false
+	}
+	p.disconnected = false
+	health.SetHostConnected(true)
+	log.Infow("host port forwarder connection state: connected", log.Fields{
+		logging.FieldEvent: logging.EventReconnect,
+		"disconnects":      p.disconnects,
+		"downtime":         time.Since(p.disconnectedAt).Round(time.Millisecond).String(),
+		"attempts":         attempt,
+	})
+
+	return true
+}
+
+// flush sends the differences between the wanted ports and the ports that
+// were last sent.  The caller must hold the mutex.
+func (p *PortEventForwarder) flush() error {
+	wanted := make(map[portBinding]struct{})
+	for _, portMap := range p.ports {
+		for port, bindings := range portMap {
+			for _, binding := range bindings {
+				wanted[portBinding{port: port, binding: binding}] = struct{}{}
+			}
+		}
+	}
+
+	if p.resync {
+		if err := p.send(false, true, wanted); err != nil {
+			return err
+		}
+		p.sent = wanted
+		p.resync = false
+		health.SetPortsTracked(len(p.sent))
+		for key := range p.conflicts {
+			if _, ok := wanted[key]; !ok {
+				delete(p.conflicts, key)
+			}
+		}
+		p.publishConflicts()
+
+		return nil
+	}
+
+	removed := make(map[portBinding]struct{})
+	for key := range p.sent {
+		if _, ok := wanted[key]; !ok {
+			removed[key] = struct{}{}
+		}
+	}
+	added := make(map[portBinding]struct{})
+	for key := range wanted {
+		if _, ok := p.sent[key]; !ok {
+			added[key] = struct{}{}
+		}
+	}
+
+	// Removals go first, so that a binding moving between host addresses of
+	// the same port is released before it is listened on again.
+	if len(removed) != 0 {
+		if err := p.send(true, false, removed); err != nil {
+			return err
+		}
+		for key := range removed {
+			delete(p.sent, key)
+			delete(p.conflicts, key)
+		}
+		p.publishConflicts()
+	}
+	if len(added) != 0 {
+		if err := p.send(false, false, added); err != nil {
+			return err
+		}
+		for key := range added {
+			p.sent[key] = struct{}{}
+		}
+	}
+	health.SetPortsTracked(len(p.sent))
+
+	return nil
+}
+
+// send sends a single message with the given ports.  If that fails, the next
+// message is a resync, as the host state is no longer known.
+func (p *PortEventForwarder) send(remove, resync bool, bindings map[portBinding]struct{}) error {
+	p.seq++
+	portMapping := types.PortMapping{
+		Remove: remove,
+		Seq:    p.seq,
+		Resync: resync,
+	}
+	if p.ranges {
+		portMapping.Ports, portMapping.Ranges = toPortRanges(bindings)
+	} else {
+		portMapping.Ports = toPortMap(bindings)
+	}
+	if ackForwarder, ok := p.forwarder.(AckForwarder); ok && p.acks && !remove {
+		return p.sendWithAck(ackForwarder, portMapping, bindings)
+	}
+	log.Debugf("forwarding port mapping: %s", describePortMapping(portMapping))
+	if err := p.forwarder.Send(portMapping); err != nil {
+		p.lostHost()
+
+		return err
+	}
+	health.EventEmitted()
+
+	return nil
+}
+
+// sendWithAck sends a message adding the given ports, and records the ports
+// the host reports it failed to listen on.
+func (p *PortEventForwarder) sendWithAck(forwarder AckForwarder, portMapping types.PortMapping, bindings map[portBinding]struct{}) error {
+	portMapping.Ack = true
+	log.Debugf("forwarding port mapping: %s", describePortMapping(portMapping))
+	ctx, cancel := context.WithTimeout(p.ctx, ackTimeout)
+	defer cancel()
+	ack, err := forwarder.SendWithAck(ctx, portMapping)
+	if err != nil && !errors.Is(err, ErrNoAck) {
+		p.lostHost()
+
+		return err
+	}
+	health.EventEmitted()
+	if err != nil || !slices.Contains(ack.Capabilities, types.CapabilityBindFailures) {
+		// The message was applied, but the host can't tell which ports
+		// failed; stop asking.
+		log.Infof("host port forwarder does not report bind failures; port conflicts will not be detected")
+		p.acks = false
+		p.ranges = false
+		p.clearConflicts()
+
+		return nil
+	}
+	p.ranges = slices.Contains(ack.Capabilities, types.CapabilityPortRanges)
+	p.recordConflicts(bindings, ack.Failures)
+
+	return nil
+}
+
+// lostHost records that a message may not have reached the host, so that the
+// next message is a resync.  As the host may have been replaced by one that
+// does not support port ranges, they are not used until it says otherwise.
+func (p *PortEventForwarder) lostHost() {
+	p.resync = true
+	p.ranges = false
+}
+
+// recordConflicts updates the conflicts for the given port bindings, which
+// were just sent to the host, with the failures it reported for them.  Each
+// conflict is only logged when it is first found and when it is resolved, as
+// the same ports are sent again on every resync.
+func (p *PortEventForwarder) recordConflicts(bindings map[portBinding]struct{}, failures []types.BindFailure) {
+	failed := make(map[portBinding]string, len(failures))
+	for _, failure := range failures {
+		key := portBinding{port: failure.Port, binding: nat.PortBinding{HostIP: failure.HostIP, HostPort: failure.HostPort}}
+		failed[key] = failure.Error
+	}
+	for key := range bindings {
+		err, isFailed := failed[key]
+		conflict, known := p.conflicts[key]
+		switch {
+		case isFailed && !known:
+			log.Warnf("failed to forward port %s to host address %s: %s",
+				key.port, net.JoinHostPort(key.binding.HostIP, key.binding.HostPort), err)
+			p.conflicts[key] = bindConflict{err: err, since: time.Now()}
+		case isFailed:
+			conflict.err = err
+			p.conflicts[key] = conflict
+		case known:
+			log.Infof("port %s is now forwarded to host address %s",
+				key.port, net.JoinHostPort(key.binding.HostIP, key.binding.HostPort))
+			delete(p.conflicts, key)
+		}
+	}
+	p.publishConflicts()
+}
+
+// clearConflicts forgets all conflicts, for when they can no longer be
+// tracked.
+func (p *PortEventForwarder) clearConflicts() {
+	clear(p.conflicts)
+	p.publishConflicts()
+}
+
+// publishConflicts reports the current conflicts to the health package.
+func (p *PortEventForwarder) publishConflicts() {
+	health.SetForwardingProblems(p.forwardingProblems())
+}
+
+// forwardingProblems returns the current conflicts, in a stable order.
+func (p *PortEventForwarder) forwardingProblems() []health.ForwardingProblem {
+	problems := make([]health.ForwardingProblem, 0, len(p.conflicts))
+	for key, conflict := range p.conflicts {
+		problems = append(problems, health.ForwardingProblem{
+			Port:     string(key.port),
+			HostIP:   key.binding.HostIP,
+			HostPort: key.binding.HostPort,
+			Error:    conflict.err,
+			Since:    conflict.since,
+		})
+	}
+	slices.SortFunc(problems, func(a, b health.ForwardingProblem) int {
+		return cmp.Or(cmp.Compare(a.Port, b.Port), cmp.Compare(a.HostIP, b.HostIP), cmp.Compare(a.HostPort, b.HostPort))
+	})
+
+	return problems
+}
+
+// toPortMap converts a set of port bindings to a port map, with the bindings
+// of each port in a stable order.
+func toPortMap(bindings map[portBinding]struct{}) nat.PortMap {
+	portMap := make(nat.PortMap)
+	for key := range bindings {
+		portMap[key.port] = append(portMap[key.port], key.binding)
+	}
+	for _, portBindings := range portMap {
+		slices.SortFunc(portBindings, func(a, b nat.PortBinding) int {
+			return cmp.Or(cmp.Compare(a.HostIP, b.HostIP), cmp.Compare(a.HostPort, b.HostPort))
+		})
+	}
+
+	return portMap
+}
+
+// toPortRanges converts a set of port bindings to ranges of consecutive ports
+// with the same protocol and host address, and a port map of the remaining
+// bindings, in a stable order.
+func toPortRanges(bindings map[portBinding]struct{}) (nat.PortMap, []types.PortRange) {
+	type rangeKey struct {
+		proto  string
+		hostIP string
+	}
+	type rangePort struct {
+		container, host int
+		key             portBinding
+	}
+	var singles []portBinding
+	groups := make(map[rangeKey][]rangePort)
+	for key := range bindings {
+		containerPort, containerErr := nat.ParsePort(key.port.Port())
+		hostPort, hostErr := nat.ParsePort(key.binding.HostPort)
+		if containerErr != nil || hostErr != nil {
+			singles = append(singles, key)
+
+			continue
+		}
+		group := rangeKey{proto: key.port.Proto(), hostIP: key.binding.HostIP}
+		groups[group] = append(groups[group], rangePort{container: containerPort, host: hostPort, key: key})
+	}
+
+	var ranges []types.PortRange
+	for _, ports := range groups {
+		slices.SortFunc(ports, func(a, b rangePort) int {
+			return cmp.Or(cmp.Compare(a.host, b.host), cmp.Compare(a.container, b.container))
+		})
+		for start := 0; start < len(ports); {
+			end := start + 1
+			for end < len(ports) &&
+				ports[end].host == ports[end-1].host+1 &&
+				ports[end].container == ports[end-1].container+1 {
+				end++
+			}
+			if end-start < minRangeCount {
+				for _, port := range ports[start:end] {
+					singles = append(singles, port.key)
+				}
+			} else {
+				first := ports[start].key
+				ranges = append(ranges, types.PortRange{
+					Port:     first.port,
+					HostIP:   first.binding.HostIP,
+					HostPort: first.binding.HostPort,
+					Count:    end - start,
+				})
+			}
+			start = end
+		}
+	}
+	slices.SortFunc(ranges, func(a, b types.PortRange) int {
+		return cmp.Or(
+			cmp.Compare(a.Port.Proto(), b.Port.Proto()),
+			cmp.Compare(a.HostIP, b.HostIP),
+			cmp.Compare(a.Port.Int(), b.Port.Int()),
+			cmp.Compare(a.HostPort, b.HostPort))
+	})
+
+	remaining := make(map[portBinding]struct{}, len(singles))
+	for _, key := range singles {
+		remaining[key] = struct{}{}
+	}
+
+	return toPortMap(remaining), ranges
+}
+
+// describePortMapping returns a port mapping for logging, with its ranges
+// shown as such rather than as a list of structures.
+func describePortMapping(portMapping types.PortMapping) string {
+	ranges := make([]string, 0, len(portMapping.Ranges))
+	for _, portRange := range portMapping.Ranges {
+		ranges = append(ranges, portRange.String())
+	}
+	portMapping.Ranges = nil
+
+	return fmt.Sprintf("%+v ranges:%v", portMapping, ranges)
+}
@@ -0,0 +1,676 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+var errHostDown = errors.New("host is down")
+
+type recordingForwarder struct {
+	sent []types.PortMapping
+}
+
+func (r *recordingForwarder) Send(portMapping types.PortMapping) error {
+	r.sent = append(r.sent, portMapping)
+
+	return nil
+}
+
+// takeSent returns the messages sent since the last call.
+func (r *recordingForwarder) takeSent() []types.PortMapping {
+	sent := r.sent
+	r.sent = nil
+
+	return sent
+}
+
+// fakeHost is a Forwarder that applies the messages it receives to its own
+// table of ports, like the host would, and that can be made unreachable.
+type fakeHost struct {
+	mutex sync.Mutex
+	down  bool
+	ports map[string]struct{}
+	// sequence number of the last message received
+	seq      uint64
+	resyncs  int
+	failures int
+	errs     []error
+}
+
+func newFakeHost() *fakeHost {
+	return &fakeHost{ports: make(map[string]struct{})}
+}
+
+func (h *fakeHost) Send(portMapping types.PortMapping) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.down {
+		h.failures++
+		return errHostDown
+	}
+	if portMapping.Seq <= h.seq {
+		h.errs = append(h.errs, fmt.Errorf("sequence number %d after %d", portMapping.Seq, h.seq))
+	} else if portMapping.Seq != h.seq+1 && !portMapping.Resync {
+		h.errs = append(h.errs, fmt.Errorf("gap in sequence numbers from %d to %d without resync", h.seq, portMapping.Seq))
+	}
+	h.seq = portMapping.Seq
+	if portMapping.WithdrawAll {
+		clear(h.ports)
+	}
+	if portMapping.Resync {
+		h.resyncs++
+		clear(h.ports)
+	}
+	portMaps := []nat.PortMap{portMapping.Ports}
+	for _, portRange := range portMapping.Ranges {
+		portMap, err := portRange.Bindings()
+		if err != nil {
+			h.errs = append(h.errs, err)
+			continue
+		}
+		portMaps = append(portMaps, portMap)
+	}
+	for _, portMap := range portMaps {
+		for port, bindings := range portMap {
+			for _, binding := range bindings {
+				key := portKey(port, binding)
+				if portMapping.Remove {
+					delete(h.ports, key)
+				} else {
+					h.ports[key] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// ackingHost is a fakeHost that acknowledges withdrawing all ports.
+type ackingHost struct {
+	*fakeHost
+}
+
+func (h ackingHost) SendWithAck(_ context.Context, portMapping types.PortMapping) (types.PortMappingAck, error) {
+	if err := h.Send(portMapping); err != nil {
+		return types.PortMappingAck{}, err
+	}
+
+	return types.PortMappingAck{Seq: portMapping.Seq, Capabilities: []string{types.CapabilityWithdrawAll}}, nil
+}
+
+// conflictingHost is a fakeHost that acknowledges every message, and reports
+// bind failures for the host ports other processes are listening on.
+type conflictingHost struct {
+	*fakeHost
+	taken map[string]bool
+}
+
+func (h conflictingHost) setTaken(hostPort string, taken bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.taken[hostPort] = taken
+}
+
+func (h conflictingHost) SendWithAck(_ context.Context, portMapping types.PortMapping) (types.PortMappingAck, error) {
+	if err := h.Send(portMapping); err != nil {
+		return types.PortMappingAck{}, err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ack := types.PortMappingAck{
+		Seq:          portMapping.Seq,
+		Capabilities: []string{types.CapabilityWithdrawAll, types.CapabilityBindFailures},
+	}
+	for port, bindings := range portMapping.Ports {
+		for _, binding := range bindings {
+			if h.taken[binding.HostPort] && !portMapping.Remove {
+				ack.Failures = append(ack.Failures, types.BindFailure{
+					Port:     port,
+					HostIP:   binding.HostIP,
+					HostPort: binding.HostPort,
+					Error:    "address already in use",
+				})
+			}
+		}
+	}
+
+	return ack, nil
+}
+
+// rangesHost is a fakeHost that acknowledges every message, supports port
+// ranges, and records the messages it receives.
+type rangesHost struct {
+	*fakeHost
+	sent *[]types.PortMapping
+}
+
+func newRangesHost() rangesHost {
+	return rangesHost{fakeHost: newFakeHost(), sent: &[]types.PortMapping{}}
+}
+
+func (h rangesHost) Send(portMapping types.PortMapping) error {
+	if err := h.fakeHost.Send(portMapping); err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	*h.sent = append(*h.sent, portMapping)
+
+	return nil
+}
+
+func (h rangesHost) SendWithAck(_ context.Context, portMapping types.PortMapping) (types.PortMappingAck, error) {
+	if err := h.Send(portMapping); err != nil {
+		return types.PortMappingAck{}, err
+	}
+
+	return types.PortMappingAck{
+		Seq:          portMapping.Seq,
+		Capabilities: []string{types.CapabilityWithdrawAll, types.CapabilityBindFailures, types.CapabilityPortRanges},
+	}, nil
+}
+
+// takeSent returns the messages received since the last call, without the
+// acknowledgment requests.
+func (h rangesHost) takeSent() []types.PortMapping {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	sent := *h.sent
+	*h.sent = nil
+	for i := range sent {
+		sent[i].Ack = false
+	}
+
+	return sent
+}
+
+// silentHost is a fakeHost that predates acknowledgments, and closes the
+// connection without replying.
+type silentHost struct {
+	*fakeHost
+}
+
+func (h silentHost) SendWithAck(_ context.Context, portMapping types.PortMapping) (types.PortMappingAck, error) {
+	if err := h.Send(portMapping); err != nil {
+		return types.PortMappingAck{}, err
+	}
+
+	return types.PortMappingAck{}, ErrNoAck
+}
+
+func (h *fakeHost) setDown(down bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.down = down
+}
+
+// state returns the ports the host knows about, and the number of resyncs
+// and failed sends.
+func (h *fakeHost) state() (ports map[string]struct{}, resyncs, failures int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ports = make(map[string]struct{}, len(h.ports))
+	for key := range h.ports {
+		ports[key] = struct{}{}
+	}
+	return ports, h.resyncs, h.failures
+}
+
+func portKey(port nat.Port, binding nat.PortBinding) string {
+	return fmt.Sprintf("%s %s:%s", port, binding.HostIP, binding.HostPort)
+}
+
+func newTestPortEventForwarder(t *testing.T, forwarder Forwarder) *PortEventForwarder {
+	t.Helper()
+	portEvents := NewPortEventForwarder(t.Context(), forwarder)
+	portEvents.minDelay = time.Millisecond
+	portEvents.maxDelay = 20 * time.Millisecond
+	return portEvents
+}
+
+// tcpPorts returns a port map forwarding count consecutive TCP ports from
+// start to the same ports.
+func tcpPorts(hostIP string, start, count int) nat.PortMap {
+	portMap := make(nat.PortMap)
+	for port := start; port < start+count; port++ {
+		for key, bindings := range tcpPort(hostIP, strconv.Itoa(port)) {
+			portMap[key] = bindings
+		}
+	}
+	return portMap
+}
+
+func tcpPort(hostIP, port string) nat.PortMap {
+	return nat.PortMap{
+		nat.Port(port + "/tcp"): []nat.PortBinding{{HostIP: hostIP, HostPort: port}},
+	}
+}
+
+func TestPortEventForwarder(t *testing.T) {
+	t.Parallel()
+
+	t.Run("starts with a resync", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := &recordingForwarder{}
+		portEvents := newTestPortEventForwarder(t, recorder)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		assert.Equal(t, []types.PortMapping{
+			{Ports: tcpPort("0.0.0.0", "80"), Seq: 1, Resync: true},
+		}, recorder.takeSent())
+
+		require.NoError(t, portEvents.Set("b", tcpPort("0.0.0.0", "443")))
+		assert.Equal(t, []types.PortMapping{
+			{Ports: tcpPort("0.0.0.0", "443"), Seq: 2},
+		}, recorder.takeSent())
+	})
+
+	t.Run("does not resend unchanged ports", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := &recordingForwarder{}
+		portEvents := newTestPortEventForwarder(t, recorder)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		recorder.takeSent()
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		assert.Empty(t, recorder.takeSent())
+	})
+
+	t.Run("sends only the changed ports", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := &recordingForwarder{}
+		portEvents := newTestPortEventForwarder(t, recorder)
+
+		portMap := nat.PortMap{
+			"80/tcp":  []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "80"}},
+			"443/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "443"}},
+		}
+		require.NoError(t, portEvents.Set("a", portMap))
+		recorder.takeSent()
+
+		portMap = nat.PortMap{
+			"80/tcp":   []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "80"}},
+			"8443/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8443"}},
+		}
+		require.NoError(t, portEvents.Set("a", portMap))
+		assert.Equal(t, []types.PortMapping{
+			{Remove: true, Ports: tcpPort("0.0.0.0", "443"), Seq: 2},
+			{Ports: tcpPort("0.0.0.0", "8443"), Seq: 3},
+		}, recorder.takeSent())
+	})
+
+	t.Run("keeps ports shared with another container", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := &recordingForwarder{}
+		portEvents := newTestPortEventForwarder(t, recorder)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		require.NoError(t, portEvents.Set("b", tcpPort("0.0.0.0", "80")))
+		recorder.takeSent()
+
+		require.NoError(t, portEvents.Remove("a"))
+		assert.Empty(t, recorder.takeSent())
+
+		require.NoError(t, portEvents.Remove("b"))
+		assert.Equal(t, []types.PortMapping{
+			{Remove: true, Ports: tcpPort("0.0.0.0", "80"), Seq: 2},
+		}, recorder.takeSent())
+	})
+
+	t.Run("handles flapping containers", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := &recordingForwarder{}
+		portEvents := newTestPortEventForwarder(t, recorder)
+
+		require.NoError(t, portEvents.Set("stable", tcpPort("0.0.0.0", "443")))
+		recorder.takeSent()
+
+		var expected []types.PortMapping
+		seq := uint64(1)
+		for range 5 {
+			require.NoError(t, portEvents.Set("flapping", tcpPort("127.0.0.1", "80")))
+			require.NoError(t, portEvents.Remove("flapping"))
+			// Removing an unknown container sends nothing.
+			require.NoError(t, portEvents.Remove("flapping"))
+			seq++
+			expected = append(expected, types.PortMapping{Ports: tcpPort("127.0.0.1", "80"), Seq: seq})
+			seq++
+			expected = append(expected, types.PortMapping{Remove: true, Ports: tcpPort("127.0.0.1", "80"), Seq: seq})
+		}
+		assert.Equal(t, expected, recorder.takeSent())
+
+		require.NoError(t, portEvents.Resync())
+		assert.Equal(t, []types.PortMapping{
+			{Ports: tcpPort("0.0.0.0", "443"), Seq: seq + 1, Resync: true},
+		}, recorder.takeSent())
+	})
+
+	t.Run("resyncs after reconnecting", func(t *testing.T) {
+		t.Parallel()
+
+		host := newFakeHost()
+		portEvents := newTestPortEventForwarder(t, host)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+
+		host.setDown(true)
+		require.ErrorIs(t, portEvents.Set("b", tcpPort("0.0.0.0", "443")), errHostDown)
+		// Changes while disconnected are kept for the resync.
+		require.NoError(t, portEvents.Remove("a"))
+		require.NoError(t, portEvents.Set("c", tcpPort("0.0.0.0", "8080")))
+		require.Eventually(t, func() bool {
+			_, _, failures := host.state()
+			return failures > 2
+		}, 10*time.Second, time.Millisecond, "reconnection was not retried")
+
+		host.setDown(false)
+		expected := map[string]struct{}{
+			portKey("443/tcp", nat.PortBinding{HostIP: "0.0.0.0", HostPort: "443"}):   {},
+			portKey("8080/tcp", nat.PortBinding{HostIP: "0.0.0.0", HostPort: "8080"}): {},
+		}
+		require.Eventually(t, func() bool {
+			ports, resyncs, _ := host.state()
+			return resyncs == 2 && assert.ObjectsAreEqual(expected, ports)
+		}, 10*time.Second, time.Millisecond, "host did not converge")
+
+		// Once reconnected, only changes are sent again.
+		require.NoError(t, portEvents.Remove("b"))
+		ports, resyncs, _ := host.state()
+		assert.Equal(t, 2, resyncs)
+		assert.Len(t, ports, 1)
+		assert.Empty(t, host.errs)
+	})
+
+	t.Run("converges after repeated drops", func(t *testing.T) {
+		t.Parallel()
+
+		host := newFakeHost()
+		portEvents := newTestPortEventForwarder(t, host)
+
+		expected := make(map[string]struct{})
+		containers := make(map[string]nat.PortMap)
+		setExpected := func() {
+			clear(expected)
+			for _, portMap := range containers {
+				for port, bindings := range portMap {
+					for _, binding := range bindings {
+						expected[portKey(port, binding)] = struct{}{}
+					}
+				}
+			}
+		}
+
+		for round := range 10 {
+			// Start each round with a change that fails, then make more
+			// changes while the host is down.
+			host.setDown(true)
+			for step := range 5 {
+				containerID := fmt.Sprintf("container-%d", (round+step)%4)
+				if step%2 == 0 {
+					port := fmt.Sprintf("%d", 8000+round*10+step)
+					containers[containerID] = tcpPort("127.0.0.1", port)
+					_ = portEvents.Set(containerID, containers[containerID])
+				} else {
+					delete(containers, containerID)
+					_ = portEvents.Remove(containerID)
+				}
+				if step == 2 {
+					// Drop again in the middle of reconnecting.
+					host.setDown(false)
+					time.Sleep(time.Millisecond)
+					host.setDown(true)
+				}
+			}
+			setExpected()
+			host.setDown(false)
+			require.Eventually(t, func() bool {
+				ports, _, _ := host.state()
+				return assert.ObjectsAreEqual(expected, ports)
+			}, 10*time.Second, time.Millisecond, "host did not converge in round %d", round)
+		}
+
+		host.mutex.Lock()
+		defer host.mutex.Unlock()
+		assert.Empty(t, host.errs)
+	})
+
+	t.Run("orders bindings of the same port", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := &recordingForwarder{}
+		portEvents := newTestPortEventForwarder(t, recorder)
+
+		require.NoError(t, portEvents.Set("a", nat.PortMap{
+			"80/tcp": []nat.PortBinding{
+				{HostIP: "127.0.0.2", HostPort: "80"},
+				{HostIP: "127.0.0.1", HostPort: "80"},
+			},
+		}))
+		assert.Equal(t, []types.PortMapping{
+			{
+				Ports: nat.PortMap{
+					"80/tcp": []nat.PortBinding{
+						{HostIP: "127.0.0.1", HostPort: "80"},
+						{HostIP: "127.0.0.2", HostPort: "80"},
+					},
+				},
+				Seq:    1,
+				Resync: true,
+			},
+		}, recorder.takeSent())
+	})
+
+	t.Run("withdraws all ports on shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		host := ackingHost{newFakeHost()}
+		portEvents := newTestPortEventForwarder(t, host)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		require.NoError(t, portEvents.Set("b", tcpPort("0.0.0.0", "443")))
+		require.NoError(t, portEvents.Shutdown(t.Context()))
+		ports, _, _ := host.state()
+		assert.Empty(t, ports)
+
+		// Nothing is sent once shut down.
+		require.NoError(t, portEvents.Set("c", tcpPort("0.0.0.0", "8080")))
+		ports, _, _ = host.state()
+		assert.Empty(t, ports)
+		assert.Empty(t, host.errs)
+	})
+
+	t.Run("leaves ports on the host when killed", func(t *testing.T) {
+		t.Parallel()
+
+		host := ackingHost{newFakeHost()}
+		ctx, cancel := context.WithCancel(t.Context())
+		portEvents := NewPortEventForwarder(ctx, host)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		// A killed agent stops without withdrawing anything; the host table
+		// is only cleaned up once it notices.
+		cancel()
+		ports, _, _ := host.state()
+		assert.Len(t, ports, 1)
+	})
+
+	t.Run("removes ports individually without withdraw-all support", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := &recordingForwarder{}
+		portEvents := newTestPortEventForwarder(t, recorder)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		recorder.takeSent()
+		require.NoError(t, portEvents.Shutdown(t.Context()))
+		assert.Equal(t, []types.PortMapping{
+			{Remove: true, Ports: tcpPort("0.0.0.0", "80"), Seq: 2},
+		}, recorder.takeSent())
+	})
+	t.Run("reports ports the host failed to listen on until they are forwarded", func(t *testing.T) {
+		t.Parallel()
+
+		host := conflictingHost{fakeHost: newFakeHost(), taken: map[string]bool{"8080": true}}
+		portEvents := newTestPortEventForwarder(t, host)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "8080")))
+		require.NoError(t, portEvents.Set("b", tcpPort("0.0.0.0", "443")))
+		problems := portEvents.forwardingProblems()
+		require.Len(t, problems, 1)
+		assert.Equal(t, "8080/tcp", problems[0].Port)
+		assert.Equal(t, "0.0.0.0", problems[0].HostIP)
+		assert.Equal(t, "8080", problems[0].HostPort)
+		assert.Equal(t, "address already in use", problems[0].Error)
+		since := problems[0].Since
+
+		// Failing again, as on every resync, keeps the original report.
+		require.NoError(t, portEvents.Resync())
+		problems = portEvents.forwardingProblems()
+		require.Len(t, problems, 1)
+		assert.Equal(t, since, problems[0].Since)
+
+		// Once the host port is free, the next attempt clears the problem.
+		host.setTaken("8080", false)
+		require.NoError(t, portEvents.Resync())
+		assert.Empty(t, portEvents.forwardingProblems())
+		ports, _, _ := host.state()
+		assert.Len(t, ports, 2)
+		assert.Empty(t, host.errs)
+	})
+
+	t.Run("forgets failures for removed ports", func(t *testing.T) {
+		t.Parallel()
+
+		host := conflictingHost{fakeHost: newFakeHost(), taken: map[string]bool{"8080": true}}
+		portEvents := newTestPortEventForwarder(t, host)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "8080")))
+		require.Len(t, portEvents.forwardingProblems(), 1)
+		require.NoError(t, portEvents.Remove("a"))
+		assert.Empty(t, portEvents.forwardingProblems())
+	})
+
+	t.Run("sends consecutive ports as ranges once the host supports them", func(t *testing.T) {
+		t.Parallel()
+
+		host := newRangesHost()
+		portEvents := newTestPortEventForwarder(t, host)
+
+		// Until the host has acknowledged a message, it may not know about
+		// ranges.
+		require.NoError(t, portEvents.Set("a", tcpPorts("0.0.0.0", 9000, 3)))
+		assert.Equal(t, []types.PortMapping{
+			{Ports: tcpPorts("0.0.0.0", 9000, 3), Seq: 1, Resync: true},
+		}, host.takeSent())
+
+		portMap := tcpPorts("0.0.0.0", 30000, 100)
+		portMap["8080/tcp"] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "8080"}}
+		require.NoError(t, portEvents.Set("b", portMap))
+		assert.Equal(t, []types.PortMapping{
+			{
+				Ports:  tcpPort("127.0.0.1", "8080"),
+				Ranges: []types.PortRange{{Port: "30000/tcp", HostIP: "0.0.0.0", HostPort: "30000", Count: 100}},
+				Seq:    2,
+			},
+		}, host.takeSent())
+
+		// Removing one port of the range only removes that port.
+		delete(portMap, "30050/tcp")
+		require.NoError(t, portEvents.Set("b", portMap))
+		assert.Equal(t, []types.PortMapping{
+			{Remove: true, Ports: tcpPort("0.0.0.0", "30050"), Seq: 3},
+		}, host.takeSent())
+
+		require.NoError(t, portEvents.Remove("b"))
+		assert.Equal(t, []types.PortMapping{
+			{
+				Remove: true,
+				Ports:  tcpPort("127.0.0.1", "8080"),
+				Ranges: []types.PortRange{
+					{Port: "30000/tcp", HostIP: "0.0.0.0", HostPort: "30000", Count: 50},
+					{Port: "30051/tcp", HostIP: "0.0.0.0", HostPort: "30051", Count: 49},
+				},
+				Seq: 4,
+			},
+		}, host.takeSent())
+
+		ports, _, _ := host.state()
+		assert.Len(t, ports, 3)
+		assert.Empty(t, host.errs)
+	})
+
+	t.Run("stops sending ranges after losing the host", func(t *testing.T) {
+		t.Parallel()
+
+		host := newRangesHost()
+		portEvents := newTestPortEventForwarder(t, host)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		require.True(t, portEvents.ranges)
+		host.takeSent()
+
+		host.setDown(true)
+		require.ErrorIs(t, portEvents.Set("b", tcpPorts("0.0.0.0", 9000, 2)), errHostDown)
+		assert.False(t, portEvents.ranges)
+		host.setDown(false)
+
+		// The resync to what may be another host lists each port.
+		require.Eventually(t, func() bool {
+			ports, resyncs, _ := host.state()
+			return resyncs == 2 && len(ports) == 3
+		}, 10*time.Second, time.Millisecond, "host did not converge")
+		sent := host.takeSent()
+		require.NotEmpty(t, sent)
+		assert.True(t, sent[0].Resync)
+		assert.Empty(t, sent[0].Ranges)
+		assert.Len(t, sent[0].Ports, 3)
+		assert.Empty(t, host.errs)
+	})
+
+	t.Run("stops asking for acknowledgments the host does not send", func(t *testing.T) {
+		t.Parallel()
+
+		host := silentHost{newFakeHost()}
+		portEvents := newTestPortEventForwarder(t, host)
+
+		require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "80")))
+		require.NoError(t, portEvents.Set("b", tcpPort("0.0.0.0", "443")))
+		ports, _, _ := host.state()
+		assert.Len(t, ports, 2)
+		assert.False(t, portEvents.acks)
+		assert.Empty(t, portEvents.forwardingProblems())
+		assert.Empty(t, host.errs)
+	})
+}
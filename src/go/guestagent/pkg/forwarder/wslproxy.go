@@ -18,6 +18,9 @@ package forwarder
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"time"
 
@@ -57,3 +60,32 @@ func (v *WSLProxyForwarder) Send(portMapping types.PortMapping) error {
 
 	return nil
 }
+
+// SendWithAck forwards the port mappings to WSL Proxy, and waits for it to
+// reply with an acknowledgment.  Unlike Send, it only uses the given context,
+// so that it can still be used while shutting down.
+func (v *WSLProxyForwarder) SendWithAck(ctx context.Context, portMapping types.PortMapping) (types.PortMappingAck, error) {
+	conn, err := v.dialer.DialContext(ctx, "unix", v.proxySocket)
+	if err != nil {
+		return types.PortMappingAck{}, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return types.PortMappingAck{}, err
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(portMapping); err != nil {
+		return types.PortMappingAck{}, err
+	}
+
+	var ack types.PortMappingAck
+	if err := json.NewDecoder(conn).Decode(&ack); errors.Is(err, io.EOF) {
+		return types.PortMappingAck{}, ErrNoAck
+	} else if err != nil {
+		return types.PortMappingAck{}, fmt.Errorf("failed to read acknowledgment: %w", err)
+	}
+
+	return ack, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+// serveFakeWSLProxy accepts port mappings on the given socket the way the
+// host's WSL proxy does, acknowledging them and reporting a bind failure for
+// every binding while taken is set.
+func serveFakeWSLProxy(t *testing.T, socket string, taken *atomic.Bool) {
+	t.Helper()
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(t.Context(), "unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var portMapping types.PortMapping
+			if err := json.NewDecoder(conn).Decode(&portMapping); err == nil && portMapping.Ack {
+				ack := types.PortMappingAck{
+					Seq:          portMapping.Seq,
+					Capabilities: []string{types.CapabilityWithdrawAll, types.CapabilityBindFailures},
+				}
+				for port, bindings := range portMapping.Ports {
+					for _, binding := range bindings {
+						if taken.Load() && !portMapping.Remove {
+							ack.Failures = append(ack.Failures, types.BindFailure{
+								Port:     port,
+								HostIP:   binding.HostIP,
+								HostPort: binding.HostPort,
+								Error:    "address already in use",
+							})
+						}
+					}
+				}
+				_ = json.NewEncoder(conn).Encode(ack)
+			}
+			_ = conn.Close()
+		}
+	}()
+}
+
+func TestWSLProxyForwarderReportsProblems(t *testing.T) {
+	dir := t.TempDir()
+	proxySocket := filepath.Join(dir, "proxy.sock")
+	healthSocket := filepath.Join(dir, "health.sock")
+
+	var taken atomic.Bool
+	taken.Store(true)
+	serveFakeWSLProxy(t, proxySocket, &taken)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan error, 1)
+	go func() { served <- health.Serve(ctx, "unix://"+healthSocket, false) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-served)
+	})
+
+	var dialer net.Dialer
+	healthClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", healthSocket)
+		},
+	}}
+	problems := func() []health.ForwardingProblem {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://localhost/healthz", http.NoBody)
+		require.NoError(t, err)
+		resp, err := healthClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var status struct {
+			ForwardingProblems []health.ForwardingProblem `json:"forwardingProblems"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		return status.ForwardingProblems
+	}
+	require.Eventually(t, func() bool {
+		conn, err := dialer.DialContext(t.Context(), "unix", healthSocket)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	portEvents := newTestPortEventForwarder(t, NewWSLProxyForwarder(t.Context(), proxySocket))
+	require.NoError(t, portEvents.Set("a", tcpPort("0.0.0.0", "8080")))
+	reported := problems()
+	require.Len(t, reported, 1)
+	assert.Equal(t, "8080/tcp", reported[0].Port)
+	assert.Equal(t, "0.0.0.0", reported[0].HostIP)
+	assert.Equal(t, "8080", reported[0].HostPort)
+	assert.Equal(t, "address already in use", reported[0].Error)
+
+	// A later successful forward clears the problem.
+	taken.Store(false)
+	require.NoError(t, portEvents.Resync())
+	assert.Empty(t, problems())
+	require.NoError(t, portEvents.Shutdown(t.Context()))
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health keeps track of the state of the guest agent, and serves it
+// over HTTP as a health check (/healthz) and as Prometheus metrics (/metrics).
+//
+// Recording is done through package level functions, so that the watchers
+// don't need to be handed a registry; each of them is a few atomic
+// operations, so that they can be called on the event path.
+package health
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The values of registry.hostConnection.
+const (
+	hostConnectionUnknown int32 = iota
+	hostConnectionConnected
+	hostConnectionDisconnected
+)
+
+// scanStats accumulates the durations of the scans of one scanner.
+type scanStats struct {
+	count atomic.Uint64
+	nanos atomic.Int64
+}
+
+// ForwardingProblem describes a port binding the host failed to listen on,
+// typically because another process on the host already uses the port.
+type ForwardingProblem struct {
+	// Port is the container port and protocol, e.g. "80/tcp".
+	Port     string `json:"port"`
+	HostIP   string `json:"hostIP"`
+	HostPort string `json:"hostPort"`
+	Error    string `json:"error"`
+	// Since is when the problem was first reported.
+	Since time.Time `json:"since"`
+}
+
+type registry struct {
+	started time.Time
+	// Watcher name to *atomic.Int64 holding the Unix time, in nanoseconds,
+	// of the last time it succeeded.
+	watchers sync.Map
+	// Scanner name to *scanStats.
+	scans          sync.Map
+	portsTracked   atomic.Int64
+	eventsEmitted  atomic.Uint64
+	reconnects     atomic.Uint64
+	hostConnection atomic.Int32
+	// The port bindings the host failed to listen on.
+	problemsMutex sync.Mutex
+	problems      []ForwardingProblem
+}
+
+func newRegistry() *registry {
+	return &registry{started: time.Now()}
+}
+
+var current = newRegistry()
+
+// WatcherSucceeded records that the named watcher did its work successfully:
+// a scanner completed a scan, or an event watcher received an event or
+// (re)connected to its source.
+func WatcherSucceeded(name string) {
+	current.watcherSucceeded(name, time.Now())
+}
+
+// ObserveScan records how long a scan by the named scanner took; the scan
+// also counts as a success of the watcher of the same name.
+func ObserveScan(name string, duration time.Duration) {
+	current.observeScan(name, duration, time.Now())
+}
+
+// SetPortsTracked records the number of port bindings currently forwarded
+// to the host.
+func SetPortsTracked(count int) {
+	current.portsTracked.Store(int64(count))
+}
+
+// EventEmitted records that a port mapping message was sent to the host.
+func EventEmitted() {
+	current.eventsEmitted.Add(1)
+}
+
+// SetHostConnected records whether the host port forwarder can be reached.
+// Going from disconnected to connected counts as a reconnection.
+func SetHostConnected(connected bool) {
+	current.setHostConnected(connected)
+}
+
+// SetForwardingProblems replaces the port bindings the host reported it
+// failed to listen on.
+func SetForwardingProblems(problems []ForwardingProblem) {
+	current.setForwardingProblems(problems)
+}
+
+func (r *registry) watcherSucceeded(name string, now time.Time) {
+	value, ok := r.watchers.Load(name)
+	if !ok {
+		value, _ = r.watchers.LoadOrStore(name, new(atomic.Int64))
+	}
+	value.(*atomic.Int64).Store(now.UnixNano())
+}
+
+func (r *registry) observeScan(name string, duration time.Duration, now time.Time) {
+	value, ok := r.scans.Load(name)
+	if !ok {
+		value, _ = r.scans.LoadOrStore(name, new(scanStats))
+	}
+	stats := value.(*scanStats)
+	stats.count.Add(1)
+	stats.nanos.Add(int64(duration))
+	r.watcherSucceeded(name, now)
+}
+
+func (r *registry) setHostConnected(connected bool) {
+	state := hostConnectionDisconnected
+	if connected {
+		state = hostConnectionConnected
+	}
+	if previous := r.hostConnection.Swap(state); previous == hostConnectionDisconnected && connected {
+		r.reconnects.Add(1)
+	}
+}
+
+func (r *registry) setForwardingProblems(problems []ForwardingProblem) {
+	r.problemsMutex.Lock()
+	defer r.problemsMutex.Unlock()
+	r.problems = slices.Clone(problems)
+}
+
+// forwardingProblems returns the port bindings the host failed to listen on;
+// it is never nil, so that it is serialized as an empty list.
+func (r *registry) forwardingProblems() []ForwardingProblem {
+	r.problemsMutex.Lock()
+	defer r.problemsMutex.Unlock()
+	return append([]ForwardingProblem{}, r.problems...)
+}
+
+// watcherTimes returns the last success time of each watcher.
+func (r *registry) watcherTimes() map[string]time.Time {
+	times := make(map[string]time.Time)
+	r.watchers.Range(func(key, value any) bool {
+		times[key.(string)] = time.Unix(0, value.(*atomic.Int64).Load())
+		return true
+	})
+	return times
+}
+
+func (r *registry) hostConnectionState() string {
+	switch r.hostConnection.Load() {
+	case hostConnectionConnected:
+		return "connected"
+	case hostConnectionDisconnected:
+		return "disconnected"
+	}
+	return "unknown"
+}
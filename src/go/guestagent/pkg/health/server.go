@@ -0,0 +1,184 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/log-go"
+)
+
+const metricPrefix = "rancher_desktop_guestagent_"
+
+// The prefix of addresses that are unix socket paths.
+const unixAddressPrefix = "unix://"
+
+var ErrNonLoopbackAddress = errors.New("refusing to serve health endpoint on a non-loopback address")
+
+// healthStatus is the body of the /healthz response.
+type healthStatus struct {
+	Status             string               `json:"status"`
+	StartTime          time.Time            `json:"startTime"`
+	HostConnection     string               `json:"hostConnection"`
+	Watchers           map[string]time.Time `json:"watchers"`
+	ForwardingProblems []ForwardingProblem  `json:"forwardingProblems"`
+}
+
+// Serve serves /healthz and /metrics on the given address until the context
+// is done.  The address is either a unix socket, as unix:///path/to/socket,
+// or host:port; unless allowNonLoopback is set, the host must be a loopback
+// address.
+func Serve(ctx context.Context, address string, allowNonLoopback bool) error {
+	listener, err := listen(ctx, address, allowNonLoopback)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           newHandler(current),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	log.Infof("serving health endpoint on %s", address)
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("health endpoint failed: %w", err)
+	}
+	return nil
+}
+
+func listen(ctx context.Context, address string, allowNonLoopback bool) (net.Listener, error) {
+	var listenConfig net.ListenConfig
+	if path, ok := strings.CutPrefix(address, unixAddressPrefix); ok {
+		// Remove the socket left behind by a previous instance.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale health socket: %w", err)
+		}
+		return listenConfig.Listen(ctx, "unix", path)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health endpoint address %q: %w", address, err)
+	}
+	if !allowNonLoopback && !isLoopback(host) {
+		return nil, fmt.Errorf("%w %q", ErrNonLoopbackAddress, address)
+	}
+	return listenConfig.Listen(ctx, "tcp", address)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func newHandler(r *registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		status := healthStatus{
+			Status:             "ok",
+			StartTime:          r.started,
+			HostConnection:     r.hostConnectionState(),
+			Watchers:           r.watcherTimes(),
+			ForwardingProblems: r.forwardingProblems(),
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Debugf("failed to write health status: %s", err)
+		}
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := r.writeMetrics(w); err != nil {
+			log.Debugf("failed to write metrics: %s", err)
+		}
+	})
+	return mux
+}
+
+// writeMetrics writes the metrics in the Prometheus text exposition format.
+func (r *registry) writeMetrics(w io.Writer) error {
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricPrefix, name, help, metricPrefix, name, kind)
+	}
+
+	metric("start_time_seconds", "gauge", "Start time of the guest agent since the Unix epoch in seconds.")
+	fmt.Fprintf(&b, "%sstart_time_seconds %d\n", metricPrefix, r.started.Unix())
+
+	metric("ports_tracked", "gauge", "Number of port bindings currently forwarded to the host.")
+	fmt.Fprintf(&b, "%sports_tracked %d\n", metricPrefix, r.portsTracked.Load())
+
+	metric("port_events_total", "counter", "Number of port mapping messages sent to the host.")
+	fmt.Fprintf(&b, "%sport_events_total %d\n", metricPrefix, r.eventsEmitted.Load())
+
+	connected := 0
+	if r.hostConnection.Load() == hostConnectionConnected {
+		connected = 1
+	}
+	metric("host_connected", "gauge", "Whether the host port forwarder can be reached.")
+	fmt.Fprintf(&b, "%shost_connected %d\n", metricPrefix, connected)
+
+	metric("host_reconnects_total", "counter", "Number of times the connection to the host port forwarder was restored.")
+	fmt.Fprintf(&b, "%shost_reconnects_total %d\n", metricPrefix, r.reconnects.Load())
+
+	metric("port_forwarding_problems", "gauge", "Number of port bindings the host failed to listen on.")
+	fmt.Fprintf(&b, "%sport_forwarding_problems %d\n", metricPrefix, len(r.forwardingProblems()))
+
+	metric("scan_duration_seconds", "summary", "Duration of port scans.")
+	type scan struct {
+		name  string
+		count uint64
+		nanos int64
+	}
+	var scans []scan
+	r.scans.Range(func(key, value any) bool {
+		stats := value.(*scanStats)
+		scans = append(scans, scan{name: key.(string), count: stats.count.Load(), nanos: stats.nanos.Load()})
+		return true
+	})
+	slices.SortFunc(scans, func(a, b scan) int { return strings.Compare(a.name, b.name) })
+	for _, s := range scans {
+		fmt.Fprintf(&b, "%sscan_duration_seconds_sum{scanner=%q} %g\n", metricPrefix, s.name, time.Duration(s.nanos).Seconds())
+		fmt.Fprintf(&b, "%sscan_duration_seconds_count{scanner=%q} %d\n", metricPrefix, s.name, s.count)
+	}
+
+	metric("watcher_last_success_timestamp_seconds", "gauge", "Time of the last success of each watcher since the Unix epoch in seconds.")
+	watchers := r.watcherTimes()
+	names := make([]string, 0, len(watchers))
+	for name := range watchers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%swatcher_last_success_timestamp_seconds{watcher=%q} %g\n",
+			metricPrefix, name, float64(watchers[name].UnixNano())/float64(time.Second))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
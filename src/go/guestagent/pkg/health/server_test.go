@@ -0,0 +1,149 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	return recorder
+}
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+
+	r := newRegistry()
+	handler := newHandler(r)
+
+	var status healthStatus
+	require.NoError(t, json.Unmarshal(get(t, handler, "/healthz").Body.Bytes(), &status))
+	assert.Equal(t, "ok", status.Status)
+	assert.Equal(t, "unknown", status.HostConnection)
+	assert.Empty(t, status.Watchers)
+	assert.NotNil(t, status.ForwardingProblems)
+	assert.Empty(t, status.ForwardingProblems)
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.watcherSucceeded("docker", now)
+	r.observeScan("iptables", time.Millisecond, now.Add(time.Second))
+	r.setHostConnected(true)
+	problem := ForwardingProblem{
+		Port:     "80/tcp",
+		HostIP:   "0.0.0.0",
+		HostPort: "8080",
+		Error:    "address already in use",
+		Since:    now,
+	}
+	r.setForwardingProblems([]ForwardingProblem{problem})
+
+	status = healthStatus{}
+	require.NoError(t, json.Unmarshal(get(t, handler, "/healthz").Body.Bytes(), &status))
+	assert.Equal(t, "connected", status.HostConnection)
+	require.Len(t, status.Watchers, 2)
+	assert.True(t, now.Equal(status.Watchers["docker"]))
+	assert.True(t, now.Add(time.Second).Equal(status.Watchers["iptables"]))
+	require.Len(t, status.ForwardingProblems, 1)
+	assert.True(t, now.Equal(status.ForwardingProblems[0].Since))
+	status.ForwardingProblems[0].Since = now
+	assert.Equal(t, problem, status.ForwardingProblems[0])
+
+	r.setForwardingProblems(nil)
+	status = healthStatus{}
+	require.NoError(t, json.Unmarshal(get(t, handler, "/healthz").Body.Bytes(), &status))
+	assert.Empty(t, status.ForwardingProblems)
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	r := newRegistry()
+	handler := newHandler(r)
+
+	now := time.Unix(1700000000, 0)
+	r.observeScan("procnet", 250*time.Millisecond, now)
+	r.observeScan("procnet", 250*time.Millisecond, now)
+	r.observeScan("iptables", time.Second, now)
+	r.portsTracked.Store(3)
+	r.eventsEmitted.Add(5)
+	r.setHostConnected(true)
+	r.setHostConnected(false)
+	r.setHostConnected(true)
+	r.setForwardingProblems([]ForwardingProblem{{Port: "80/tcp", HostIP: "0.0.0.0", HostPort: "80"}})
+
+	recorder := get(t, handler, "/metrics")
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		"# TYPE rancher_desktop_guestagent_ports_tracked gauge\n",
+		"rancher_desktop_guestagent_ports_tracked 3\n",
+		"rancher_desktop_guestagent_port_events_total 5\n",
+		"rancher_desktop_guestagent_host_connected 1\n",
+		"rancher_desktop_guestagent_host_reconnects_total 1\n",
+		"rancher_desktop_guestagent_port_forwarding_problems 1\n",
+		"rancher_desktop_guestagent_scan_duration_seconds_sum{scanner=\"iptables\"} 1\n",
+		"rancher_desktop_guestagent_scan_duration_seconds_sum{scanner=\"procnet\"} 0.5\n",
+		"rancher_desktop_guestagent_scan_duration_seconds_count{scanner=\"procnet\"} 2\n",
+		"rancher_desktop_guestagent_watcher_last_success_timestamp_seconds{watcher=\"procnet\"} 1.7e+09\n",
+	} {
+		assert.Contains(t, body, expected)
+	}
+	iptablesIndex := strings.Index(body, `scanner="iptables"`)
+	procnetIndex := strings.Index(body, `scanner="procnet"`)
+	require.NotEqual(t, -1, iptablesIndex)
+	assert.Less(t, iptablesIndex, procnetIndex, "scanners are not sorted")
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0", "localhost:0"} {
+		listener, err := listen(t.Context(), address, false)
+		if err != nil && address != "127.0.0.1:0" {
+			// IPv6 or localhost may not be available in the test environment.
+			t.Logf("skipping %s: %s", address, err)
+			continue
+		}
+		require.NoError(t, err, address)
+		require.NoError(t, listener.Close())
+	}
+
+	for _, address := range []string{"0.0.0.0:0", ":0", "192.0.2.1:0"} {
+		_, err := listen(t.Context(), address, false)
+		require.ErrorIs(t, err, ErrNonLoopbackAddress, address)
+	}
+
+	listener, err := listen(t.Context(), "0.0.0.0:0", true)
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+
+	socketPath := filepath.Join(t.TempDir(), "health.sock")
+	listener, err = listen(t.Context(), unixAddressPrefix+socketPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, "unix", listener.Addr().Network())
+	require.NoError(t, listener.Close())
+}
@@ -16,15 +16,18 @@ package iptables
 
 import (
 	"context"
+	"hash/fnv"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/log-go"
 	"github.com/docker/go-connections/nat"
-	limaiptables "github.com/lima-vm/lima/pkg/guestagent/iptables"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
@@ -34,23 +37,78 @@ import (
 // are not exposed via the Kubernetes API. The package scans iptables for these port and uses
 // the k8sServiceListenerAddr setting for the hostIP property to create a port mapping and
 // forwards them to both the API tracker and the WSL Proxy for proper routing and handling.
+//
+// While an event-driven watcher (such as the Kubernetes service watcher) is
+// active, most ports are forwarded by the watcher and the scanner only needs
+// to pick up stragglers; it then scans at the longer reconcile interval, and
+// does not start forwarding ports found in kube-proxy rules, as the watcher
+// forwards those itself.
 type Iptables struct {
 	context    context.Context
 	apiTracker tracker.Tracker
 	scanner    Scanner
 	listenerIP net.IP
-	// time, in seconds, to wait between updating.
-	updateInterval time.Duration
+	// time to wait between updating.
+	updateInterval atomic.Int64
+	// time to wait between updating while a watcher is active.
+	reconcileInterval atomic.Int64
+	watcherActive     atomic.Bool
+	rescanCh          chan struct{}
 }
 
-func New(ctx context.Context, apiTracker tracker.Tracker, iptablesScanner Scanner, listenerIP net.IP, updateInterval time.Duration) *Iptables {
-	return &Iptables{
-		context:        ctx,
-		apiTracker:     apiTracker,
-		scanner:        iptablesScanner,
-		listenerIP:     listenerIP,
-		updateInterval: updateInterval,
+func New(
+	ctx context.Context,
+	apiTracker tracker.Tracker,
+	iptablesScanner Scanner,
+	listenerIP net.IP,
+	updateInterval, reconcileInterval time.Duration,
+) *Iptables {
+	i := &Iptables{
+		context:    ctx,
+		apiTracker: apiTracker,
+		scanner:    iptablesScanner,
+		listenerIP: listenerIP,
+		rescanCh:   make(chan struct{}, 1),
 	}
+	i.updateInterval.Store(int64(updateInterval))
+	i.reconcileInterval.Store(int64(reconcileInterval))
+	return i
+}
+
+// SetIntervals changes the update and reconcile intervals, starting with a
+// scan right away.
+func (i *Iptables) SetIntervals(updateInterval, reconcileInterval time.Duration) {
+	i.updateInterval.Store(int64(updateInterval))
+	i.reconcileInterval.Store(int64(reconcileInterval))
+	i.Rescan()
+}
+
+// Rescan requests that iptables be scanned immediately, rather than at the
+// end of the current interval.
+func (i *Iptables) Rescan() {
+	select {
+	case i.rescanCh <- struct{}{}:
+	default:
+		// A rescan is already pending.
+	}
+}
+
+// SetWatcherActive records whether an event-driven watcher is currently
+// forwarding ports, which switches the scanner between the update interval
+// and the reconcile interval.
+func (i *Iptables) SetWatcherActive(active bool) {
+	if i.watcherActive.Swap(active) != active {
+		// Scan now to pick up anything missed during the switch; the timer
+		// is then set up with the new interval.
+		i.Rescan()
+	}
+}
+
+func (i *Iptables) interval() time.Duration {
+	if i.watcherActive.Load() {
+		return time.Duration(i.reconcileInterval.Load())
+	}
+	return time.Duration(i.updateInterval.Load())
 }
 
 // ForwardPorts forwards ports found in iptables DNAT. In some environments,
@@ -59,18 +117,24 @@ func New(ctx context.Context, apiTracker tracker.Tracker, iptablesScanner Scanne
 // as part of the normal forwarding system. This function detects those ports
 // and binds them to k8sServiceListenerAddr so that they are picked up.
 func (i *Iptables) ForwardPorts() error {
-	var ports []limaiptables.Entry
+	var ports []Entry
+	var portsHash uint64
+	scanned := false
 
-	ticker := time.NewTicker(i.updateInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(i.interval())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-i.context.Done():
 			return nil
-		case <-ticker.C:
+		case <-timer.C:
+		case <-i.rescanCh:
+			log.Debug("iptables scanner: rescan requested")
 		}
+		timer.Reset(i.interval())
 		// Detect ports for forward
+		scanStart := time.Now()
 		newPorts, err := i.scanner.GetPorts()
 		if err != nil {
 			// iptables exiting with an exit status of 4 means there
@@ -84,6 +148,16 @@ func (i *Iptables) ForwardPorts() error {
 			}
 			return err
 		}
+		health.ObserveScan("iptables", time.Since(scanStart))
+		newPorts = i.skipWatchedPorts(ports, newPorts)
+
+		// Most scans find the same ports; skip the diff in that case.
+		newHash := hashPorts(newPorts)
+		if scanned && newHash == portsHash {
+			continue
+		}
+		portsHash = newHash
+		scanned = true
 
 		// Diff from existing forwarded ports
 		added, removed := comparePorts(ports, newPorts)
@@ -96,7 +170,7 @@ func (i *Iptables) ForwardPorts() error {
 				log.Warnf("iptables scanner failed to remove portmap for %s: %w", name, err)
 				continue
 			}
-			log.Infof("iptables scanner removed portmap for %s", name)
+			log.Infof("iptables scanner removed portmap for %s (%s)", name, p.Source)
 		}
 
 		portMap := make(nat.PortMap)
@@ -124,17 +198,34 @@ func (i *Iptables) ForwardPorts() error {
 				log.Errorf("iptables scanner failed to forward portmap for %s: %s", name, err)
 				continue
 			}
-			log.Infof("iptables scanner forwarded portmap for %s", name)
+			log.Infof("iptables scanner forwarded portmap for %s (%s to %s)", name, p.Source, p.Target)
 		}
 	}
 }
 
+// skipWatchedPorts drops the newly found ports from kube-proxy rules while the
+// Kubernetes service watcher is active, so that they are not forwarded by both.
+// Ports that are already forwarded are kept until their rules go away, as
+// removing them would also remove the forward the watcher set up for them.
+func (i *Iptables) skipWatchedPorts(ports, newPorts []Entry) []Entry {
+	if !i.watcherActive.Load() {
+		return newPorts
+	}
+	forwarded := make(map[string]bool, len(ports))
+	for _, p := range ports {
+		forwarded[entryToString(p)] = true
+	}
+	return slices.DeleteFunc(newPorts, func(p Entry) bool {
+		return p.Source.IsKubernetes() && !forwarded[entryToString(p)]
+	})
+}
+
 // comparePorts compares the old and new ports to find those added or removed.
 // This function is mostly lifted from lima (github.com/lima-vm/lima) which is
 // licensed under the Apache 2.
-func comparePorts(oldPorts, newPorts []limaiptables.Entry) ([]limaiptables.Entry, []limaiptables.Entry) {
-	var added, removed []limaiptables.Entry
-	oldPortMap := make(map[string]limaiptables.Entry, len(oldPorts))
+func comparePorts(oldPorts, newPorts []Entry) ([]Entry, []Entry) {
+	var added, removed []Entry
+	oldPortMap := make(map[string]Entry, len(oldPorts))
 	portExistMap := make(map[string]bool, len(oldPorts))
 	for _, oldPort := range oldPorts {
 		key := entryToString(oldPort)
@@ -158,6 +249,23 @@ func comparePorts(oldPorts, newPorts []limaiptables.Entry) ([]limaiptables.Entry
 	return added, removed
 }
 
-func entryToString(ip limaiptables.Entry) string {
+// hashPorts returns a hash of the given entries that does not depend on their
+// order.
+func hashPorts(entries []Entry) uint64 {
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entryToString(entry)+"/"+strconv.FormatBool(entry.TCP))
+	}
+	slices.Sort(keys)
+
+	hash := fnv.New64a()
+	for _, key := range keys {
+		_, _ = hash.Write([]byte(key))
+		_, _ = hash.Write([]byte{0})
+	}
+	return hash.Sum64()
+}
+
+func entryToString(ip Entry) string {
 	return net.JoinHostPort(ip.IP.String(), strconv.Itoa(ip.Port))
 }
@@ -17,11 +17,11 @@ import (
 	"context"
 	"net"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/docker/go-connections/nat"
-	limaiptables "github.com/lima-vm/lima/pkg/guestagent/iptables"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
@@ -33,15 +33,15 @@ func TestForwardPorts(t *testing.T) {
 		name               string
 		remove             bool
 		listenerIP         net.IP
-		expectedEntries    []limaiptables.Entry
-		removedEntries     []limaiptables.Entry
-		updateEntries      []limaiptables.Entry
+		expectedEntries    []iptables.Entry
+		removedEntries     []iptables.Entry
+		updateEntries      []iptables.Entry
 		expectedAddFuncErr error
 	}{
 		{
 			name:       "With localhost listener and valid port mappings",
 			listenerIP: net.IPv4(127, 0, 0, 1),
-			expectedEntries: []limaiptables.Entry{
+			expectedEntries: []iptables.Entry{
 				{TCP: true, IP: net.IPv4(192, 168, 20, 10), Port: 1080},
 				{TCP: true, IP: net.IPv4(192, 168, 20, 11), Port: 1081},
 				{TCP: true, IP: net.IPv4(192, 168, 20, 12), Port: 1082},
@@ -50,7 +50,7 @@ func TestForwardPorts(t *testing.T) {
 		{
 			name:       "With wildcard listener and valid port mappings",
 			listenerIP: net.IPv4(0, 0, 0, 0),
-			expectedEntries: []limaiptables.Entry{
+			expectedEntries: []iptables.Entry{
 				{TCP: true, IP: net.IPv4(192, 168, 21, 10), Port: 1080},
 				{TCP: true, IP: net.IPv4(192, 168, 21, 11), Port: 1081},
 				{TCP: true, IP: net.IPv4(192, 168, 21, 12), Port: 1082},
@@ -60,18 +60,18 @@ func TestForwardPorts(t *testing.T) {
 			name:       "With entries removed",
 			remove:     true,
 			listenerIP: net.IPv4(0, 0, 0, 0),
-			expectedEntries: []limaiptables.Entry{
+			expectedEntries: []iptables.Entry{
 				{TCP: true, IP: net.IPv4(192, 168, 22, 10), Port: 1080},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 11), Port: 1081},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 12), Port: 1082},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 13), Port: 1083},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 14), Port: 1084},
 			},
-			removedEntries: []limaiptables.Entry{
+			removedEntries: []iptables.Entry{
 				{TCP: true, IP: net.IPv4(192, 168, 22, 11), Port: 1081},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 12), Port: 1082},
 			},
-			updateEntries: []limaiptables.Entry{
+			updateEntries: []iptables.Entry{
 				{TCP: true, IP: net.IPv4(192, 168, 22, 10), Port: 1080},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 13), Port: 1083},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 14), Port: 1084},
@@ -97,7 +97,7 @@ func TestForwardPorts(t *testing.T) {
 			defer cancel()
 
 			interval := time.Second
-			iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, tt.listenerIP, interval)
+			iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, tt.listenerIP, interval, interval)
 
 			go func() {
 				require.NoError(t, iptablesHandler.ForwardPorts())
@@ -165,13 +165,13 @@ func TestForwardPortsSamePortDifferentIP(t *testing.T) {
 	tests := []struct {
 		name               string
 		listenerIP         net.IP
-		expectedEntries    []limaiptables.Entry
+		expectedEntries    []iptables.Entry
 		expectedAddFuncErr error
 	}{
 		{
 			name:       "Same Port with different IP",
 			listenerIP: net.IPv4(0, 0, 0, 0),
-			expectedEntries: []limaiptables.Entry{
+			expectedEntries: []iptables.Entry{
 				{TCP: true, IP: net.IPv4(192, 168, 22, 10), Port: 1080},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 11), Port: 1081},
 				{TCP: true, IP: net.IPv4(192, 168, 22, 12), Port: 1082},
@@ -199,7 +199,7 @@ func TestForwardPortsSamePortDifferentIP(t *testing.T) {
 			defer cancel()
 
 			interval := time.Second
-			iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, tt.listenerIP, interval)
+			iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, tt.listenerIP, interval, interval)
 
 			go func() {
 				require.NoError(t, iptablesHandler.ForwardPorts())
@@ -233,6 +233,209 @@ func TestForwardPortsSamePortDifferentIP(t *testing.T) {
 	}
 }
 
+func TestForwardPortsSkipsUnchangedPorts(t *testing.T) {
+	entries := []iptables.Entry{
+		{TCP: true, IP: net.IPv4(192, 168, 23, 10), Port: 1080},
+		{TCP: true, IP: net.IPv4(192, 168, 23, 11), Port: 1081},
+	}
+	iptablesScanner := scanSequence{
+		scans: make(chan []iptables.Entry),
+	}
+	testTracker := countingTracker{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Use a long interval so that only explicit rescans happen.
+	iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, net.IPv4zero, time.Hour, time.Hour)
+	errCh := make(chan error)
+	go func() {
+		errCh <- iptablesHandler.ForwardPorts()
+	}()
+
+	scan := func(entries []iptables.Entry) {
+		t.Helper()
+		iptablesHandler.Rescan()
+		select {
+		case iptablesScanner.scans <- entries:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for iptables scan")
+		}
+	}
+
+	scan(entries)
+	scan(entries)
+	// The order of the entries does not matter.
+	scan([]iptables.Entry{entries[1], entries[0]})
+	// Wait for the previous scan to be processed.
+	scan(entries)
+	require.Equal(t, 2, testTracker.addCount())
+	require.Equal(t, 0, testTracker.removeCount())
+
+	scan(entries[:1])
+	scan(entries[:1])
+	require.Equal(t, 2, testTracker.addCount())
+	require.Equal(t, 1, testTracker.removeCount())
+
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+func TestSetWatcherActive(t *testing.T) {
+	entries := []iptables.Entry{
+		{TCP: true, IP: net.IPv4(192, 168, 24, 10), Port: 1080},
+	}
+	iptablesScanner := scanSequence{
+		scans: make(chan []iptables.Entry),
+	}
+	testTracker := countingTracker{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, net.IPv4zero, time.Hour, time.Hour)
+	go func() {
+		_ = iptablesHandler.ForwardPorts()
+	}()
+
+	// Switching to the reconcile interval triggers a scan.
+	iptablesHandler.SetWatcherActive(true)
+	select {
+	case iptablesScanner.scans <- entries:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for iptables scan")
+	}
+
+	// Setting the same state again does not.
+	iptablesHandler.SetWatcherActive(true)
+	select {
+	case iptablesScanner.scans <- entries:
+		require.FailNow(t, "unexpected iptables scan")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSetIntervals(t *testing.T) {
+	iptablesScanner := scanSequence{
+		scans: make(chan []iptables.Entry),
+	}
+	testTracker := countingTracker{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, net.IPv4zero, time.Hour, time.Hour)
+	go func() {
+		_ = iptablesHandler.ForwardPorts()
+	}()
+
+	// Changing the intervals triggers a scan, and the timer is then set up
+	// with the new interval.
+	iptablesHandler.SetIntervals(50*time.Millisecond, time.Hour)
+	for range 3 {
+		select {
+		case iptablesScanner.scans <- nil:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for iptables scan")
+		}
+	}
+}
+
+func TestSetWatcherActiveSkipsKubernetesPorts(t *testing.T) {
+	nodePort := iptables.Entry{TCP: true, IP: net.IPv4zero, Port: 30080, Source: iptables.SourceKubeNodePort}
+	loadBalancer := iptables.Entry{TCP: true, IP: net.IPv4(192, 168, 127, 2), Port: 443, Source: iptables.SourceKubeExternal}
+	container := iptables.Entry{TCP: true, IP: net.IPv4zero, Port: 8080, Source: iptables.SourceCNIPortmap}
+	iptablesScanner := scanSequence{
+		scans: make(chan []iptables.Entry),
+	}
+	testTracker := countingTracker{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iptablesHandler := iptables.New(ctx, &testTracker, &iptablesScanner, net.IPv4zero, time.Hour, time.Hour)
+	errCh := make(chan error)
+	go func() {
+		errCh <- iptablesHandler.ForwardPorts()
+	}()
+
+	scan := func(entries ...iptables.Entry) {
+		t.Helper()
+		select {
+		case iptablesScanner.scans <- entries:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for iptables scan")
+		}
+	}
+
+	// Without the watcher, ports from kube-proxy rules are forwarded.
+	iptablesHandler.Rescan()
+	scan(nodePort)
+	// The watcher becoming active triggers a scan; the node port forwarded
+	// before is kept, but new kube-proxy ports are left to the watcher.
+	iptablesHandler.SetWatcherActive(true)
+	scan(nodePort, loadBalancer, container)
+	// Wait for the previous scan to be processed.
+	iptablesHandler.Rescan()
+	scan(nodePort, loadBalancer, container)
+	require.Equal(t, 2, testTracker.addCount())
+	require.Equal(t, 0, testTracker.removeCount())
+
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+// countingTracker counts the port mappings added and removed.
+type countingTracker struct {
+	mutex   sync.Mutex
+	adds    int
+	removes int
+}
+
+func (c *countingTracker) Get(containerID string) nat.PortMap {
+	return nil
+}
+
+func (c *countingTracker) Add(containerID string, portMapping nat.PortMap) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.adds++
+	return nil
+}
+
+func (c *countingTracker) Remove(containerID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.removes++
+	return nil
+}
+
+func (c *countingTracker) RemoveAll() error {
+	return nil
+}
+
+func (c *countingTracker) addCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.adds
+}
+
+func (c *countingTracker) removeCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.removes
+}
+
+// scanSequence is a fake Scanner that returns the entries sent to it for
+// each scan.
+type scanSequence struct {
+	scans chan []iptables.Entry
+}
+
+func (s *scanSequence) GetPorts() ([]iptables.Entry, error) {
+	return <-s.scans, nil
+}
+
 // Fake Tracker implementation for mocking behavior
 type fakeTracker struct {
 	receivedID          chan string
@@ -262,15 +465,15 @@ func (f *fakeTracker) RemoveAll() error {
 
 // Fake Scanner to simulate iptables entries
 type fakeScanner struct {
-	expectedEntries []limaiptables.Entry
+	expectedEntries []iptables.Entry
 	expectedErr     error
 }
 
-func (f *fakeScanner) GetPorts() ([]limaiptables.Entry, error) {
+func (f *fakeScanner) GetPorts() ([]iptables.Entry, error) {
 	return f.expectedEntries, f.expectedErr
 }
 
 // Utility function to convert iptables entry to string
-func entryToString(ip limaiptables.Entry) string {
+func entryToString(ip iptables.Entry) string {
 	return net.JoinHostPort(ip.IP.String(), strconv.Itoa(ip.Port))
 }
@@ -0,0 +1,253 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iptables
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The order in which sources are preferred when the same port is found in
+// rules of several kinds.  Kubernetes rules come first, so that ports the
+// Kubernetes service watcher also forwards (such as the host ports of
+// klipper-lb pods) are recognized as such.
+var sourcePriority = []Source{SourceKubeExternal, SourceKubeNodePort, SourceCNIPortmap, SourceCNIHostport}
+
+// rule holds the parts of an iptables rule, as listed by `iptables -S`, that
+// are needed to find forwarded ports.
+type rule struct {
+	chain string
+	// The destination address, if the rule matches a single one.
+	destination string
+	protocol    string
+	// The destination ports, from --dport or --dports.
+	ports         []int
+	jump          string
+	toDestination string
+}
+
+// parseNATRules returns the ports forwarded by the given NAT rules, in the
+// format listed by `iptables -t nat -S` with either backend.  Lines that are
+// not rules, or that can't be parsed, are skipped.  Each port is returned
+// once per protocol, as the host side listens on the same address for all of
+// them.
+func parseNATRules(lines []string) []Entry {
+	var rules []rule
+	for _, line := range lines {
+		if r, ok := parseRule(line); ok {
+			rules = append(rules, r)
+		}
+	}
+
+	var entries []Entry
+	add := func(r rule, port int, source Source, target string) {
+		if r.protocol != "tcp" && r.protocol != "udp" {
+			return
+		}
+		ip := net.IPv4zero
+		if r.destination != "" {
+			ip = net.ParseIP(r.destination)
+		}
+		if ip == nil || ip.To4() == nil {
+			// TODO: add support for IPv6
+			return
+		}
+		entries = append(entries, Entry{
+			TCP:    r.protocol == "tcp",
+			IP:     ip,
+			Port:   port,
+			Source: source,
+			Target: target,
+		})
+	}
+
+	// The ports each CNI-DN-* chain has a DNAT rule for.
+	containerPorts := make(map[string]map[string]bool)
+	for _, r := range rules {
+		switch {
+		case strings.HasPrefix(r.chain, "CNI-DN-") && r.jump == "DNAT":
+			for _, port := range r.ports {
+				add(r, port, SourceCNIPortmap, r.toDestination)
+				if containerPorts[r.chain] == nil {
+					containerPorts[r.chain] = make(map[string]bool)
+				}
+				containerPorts[r.chain][r.protocol+"/"+strconv.Itoa(port)] = true
+			}
+		case r.chain == "KUBE-NODEPORTS" && (strings.HasPrefix(r.jump, "KUBE-EXT-") || strings.HasPrefix(r.jump, "KUBE-SVC-")):
+			// kube-proxy before 1.24 jumps straight to the KUBE-SVC-* chain.
+			for _, port := range r.ports {
+				add(r, port, SourceKubeNodePort, r.jump)
+			}
+		case r.chain == "KUBE-SERVICES" && (strings.HasPrefix(r.jump, "KUBE-EXT-") || strings.HasPrefix(r.jump, "KUBE-FW-")):
+			// kube-proxy before 1.24 jumps to a KUBE-FW-* chain for load
+			// balancers; KUBE-SVC-* jumps are for cluster IPs.
+			for _, port := range r.ports {
+				add(r, port, SourceKubeExternal, r.jump)
+			}
+		}
+	}
+	// CNI-HOSTPORT-DNAT lists the host ports of each container; they are only
+	// needed for containers whose own chain can't be parsed.
+	for _, r := range rules {
+		if r.chain != "CNI-HOSTPORT-DNAT" || !strings.HasPrefix(r.jump, "CNI-DN-") {
+			continue
+		}
+		for _, port := range r.ports {
+			if !containerPorts[r.jump][r.protocol+"/"+strconv.Itoa(port)] {
+				add(r, port, SourceCNIHostport, r.jump)
+			}
+		}
+	}
+
+	return reconcileEntries(entries)
+}
+
+// reconcileEntries keeps a single entry for each port and protocol, from the
+// most preferred source, and returns them sorted.
+func reconcileEntries(entries []Entry) []Entry {
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		return cmp.Or(
+			cmp.Compare(a.Port, b.Port),
+			compareBool(a.TCP, b.TCP),
+			cmp.Compare(slices.Index(sourcePriority, a.Source), slices.Index(sourcePriority, b.Source)),
+		)
+	})
+	return slices.CompactFunc(entries, func(a, b Entry) bool {
+		return a.Port == b.Port && a.TCP == b.TCP
+	})
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	}
+	return 1
+}
+
+// parseRule parses a line listed by `iptables -S`; it returns false for lines
+// that don't append a rule, such as chain declarations.  Options that are not
+// needed are skipped, so that rules from different iptables versions, which
+// differ in the matches they list and in their order, are parsed the same.
+func parseRule(line string) (rule, bool) {
+	fields := splitRuleFields(line)
+	if len(fields) < 2 || fields[0] != "-A" {
+		return rule{}, false
+	}
+	r := rule{chain: fields[1]}
+	negated := false
+	for i := 2; i < len(fields); i++ {
+		field := fields[i]
+		if field == "!" {
+			negated = true
+			continue
+		}
+		value := ""
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		switch field {
+		case "-d", "--destination":
+			i++
+			if !negated {
+				r.destination = singleAddress(value)
+			}
+		case "-p", "--protocol":
+			i++
+			if !negated {
+				r.protocol = strings.ToLower(value)
+			}
+		case "--dport", "--destination-port", "--dports", "--destination-ports":
+			i++
+			if !negated {
+				r.ports = parsePorts(value)
+			}
+		case "-j", "--jump":
+			i++
+			r.jump = value
+		case "--to-destination":
+			i++
+			r.toDestination = value
+		case "--comment", "-m", "--match":
+			// Skip the value, so that a comment can't be mistaken for an
+			// option.
+			i++
+		}
+		negated = false
+	}
+	return r, true
+}
+
+// singleAddress returns the address of a destination that matches a single
+// IPv4 address, with or without a /32 mask, and an empty string otherwise.
+func singleAddress(destination string) string {
+	address, mask, found := strings.Cut(destination, "/")
+	if found && mask != "32" && mask != "255.255.255.255" {
+		return ""
+	}
+	return address
+}
+
+// parsePorts parses a port or a comma separated list of ports; port ranges
+// are skipped, as they are never used for forwarded ports.
+func parsePorts(value string) []int {
+	var ports []int
+	for _, field := range strings.Split(value, ",") {
+		port, err := strconv.Atoi(field)
+		if err != nil || port <= 0 || port > 65535 {
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// splitRuleFields splits a rule into its fields, the way the shell would:
+// fields are separated by spaces, and double quoted strings, in which a
+// backslash escapes the next character, are a single field.
+func splitRuleFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	inField, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iptables
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNATRules(t *testing.T) {
+	entry := func(protocol, ip string, port int, source Source, target string) Entry {
+		return Entry{TCP: protocol == "tcp", IP: net.ParseIP(ip), Port: port, Source: source, Target: target}
+	}
+	tests := []struct {
+		fixtures []string
+		expected []Entry
+	}{
+		{
+			fixtures: []string{"portmap-legacy.rules"},
+			expected: []Entry{
+				entry("udp", "0.0.0.0", 5353, SourceCNIPortmap, "10.4.0.10:53"),
+				entry("tcp", "127.0.0.1", 8081, SourceCNIPortmap, "10.4.0.7:80"),
+				entry("tcp", "0.0.0.0", 8082, SourceCNIPortmap, "10.4.0.10:80"),
+			},
+		},
+		{
+			fixtures: []string{"portmap-nft.rules"},
+			expected: []Entry{
+				entry("tcp", "0.0.0.0", 8083, SourceCNIPortmap, "10.4.0.12:8080"),
+				entry("tcp", "0.0.0.0", 8084, SourceCNIHostport, "CNI-DN-5f0a8c0e3a2b7d1e9c4b6"),
+			},
+		},
+		{
+			fixtures: []string{"kube-proxy.rules"},
+			expected: []Entry{
+				entry("tcp", "192.168.127.2", 80, SourceKubeExternal, "KUBE-EXT-CVG3OEGEH7H5P3HQ"),
+				entry("tcp", "192.168.127.2", 443, SourceKubeExternal, "KUBE-EXT-UQMCRMJZLI3FTLDP"),
+				entry("tcp", "0.0.0.0", 30080, SourceKubeNodePort, "KUBE-EXT-CVG3OEGEH7H5P3HQ"),
+				entry("tcp", "0.0.0.0", 30443, SourceKubeNodePort, "KUBE-EXT-UQMCRMJZLI3FTLDP"),
+				entry("tcp", "0.0.0.0", 31000, SourceKubeNodePort, "KUBE-EXT-LG3KXJWYJDDNNVNQ"),
+			},
+		},
+		{
+			fixtures: []string{"kube-proxy-legacy.rules"},
+			expected: []Entry{
+				entry("tcp", "192.168.1.50", 8080, SourceKubeExternal, "KUBE-FW-LG3KXJWYJDDNNVNQ"),
+				entry("tcp", "0.0.0.0", 32080, SourceKubeNodePort, "KUBE-SVC-LG3KXJWYJDDNNVNQ"),
+			},
+		},
+		{
+			// Both backends listed: each port is returned once.
+			fixtures: []string{"portmap-legacy.rules", "portmap-nft.rules", "portmap-legacy.rules"},
+			expected: []Entry{
+				entry("udp", "0.0.0.0", 5353, SourceCNIPortmap, "10.4.0.10:53"),
+				entry("tcp", "127.0.0.1", 8081, SourceCNIPortmap, "10.4.0.7:80"),
+				entry("tcp", "0.0.0.0", 8082, SourceCNIPortmap, "10.4.0.10:80"),
+				entry("tcp", "0.0.0.0", 8083, SourceCNIPortmap, "10.4.0.12:8080"),
+				entry("tcp", "0.0.0.0", 8084, SourceCNIHostport, "CNI-DN-5f0a8c0e3a2b7d1e9c4b6"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.fixtures, "+"), func(t *testing.T) {
+			var lines []string
+			for _, fixture := range tt.fixtures {
+				contents, err := os.ReadFile(filepath.Join("testdata", fixture))
+				require.NoError(t, err)
+				lines = append(lines, strings.Split(string(contents), "\n")...)
+			}
+			assert.Equal(t, tt.expected, parseNATRules(lines))
+		})
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected rule
+		ok       bool
+	}{
+		{
+			name: "chain declaration",
+			line: "-N CNI-HOSTPORT-DNAT",
+		},
+		{
+			name: "comment",
+			line: "# Warning: iptables-legacy tables present, use iptables-legacy to see them",
+		},
+		{
+			name:     "options in comments are ignored",
+			line:     `-A CNI-HOSTPORT-DNAT -p tcp -m comment --comment "-d 10.0.0.1 --dport 9 -j \"DNAT\"" -m multiport --dports 80,443 -j CNI-DN-1`,
+			expected: rule{chain: "CNI-HOSTPORT-DNAT", protocol: "tcp", ports: []int{80, 443}, jump: "CNI-DN-1"},
+			ok:       true,
+		},
+		{
+			name:     "negated options are ignored",
+			line:     "-A KUBE-SERVICES ! -d 10.43.0.1/32 ! -p udp -m tcp ! --dport 53 -j KUBE-MARK-MASQ",
+			expected: rule{chain: "KUBE-SERVICES", jump: "KUBE-MARK-MASQ"},
+			ok:       true,
+		},
+		{
+			name:     "networks and port ranges are not single destinations",
+			line:     "-A CNI-DN-1 -d 127.0.0.0/8 -p TCP -m multiport --dports 8000:8010,8080 -j DNAT --to-destination 10.4.0.2",
+			expected: rule{chain: "CNI-DN-1", protocol: "tcp", ports: []int{8080}, jump: "DNAT", toDestination: "10.4.0.2"},
+			ok:       true,
+		},
+		{
+			name:     "long option names",
+			line:     "-A CNI-DN-1 --destination 127.0.0.1/255.255.255.255 --protocol udp --match udp --destination-port 53 --jump DNAT --to-destination 10.4.0.2:53",
+			expected: rule{chain: "CNI-DN-1", destination: "127.0.0.1", protocol: "udp", ports: []int{53}, jump: "DNAT", toDestination: "10.4.0.2:53"},
+			ok:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := parseRule(tt.line)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
@@ -14,20 +14,148 @@ limitations under the License.
 // Package iptables handles forwarding ports found in iptables DNAT
 package iptables
 
-import "github.com/lima-vm/lima/pkg/guestagent/iptables"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/log-go"
+)
+
+// Source identifies the kind of iptables rule a port was found in.
+type Source string
+
+const (
+	// A DNAT rule in a CNI-DN-* chain, created by the CNI portmap plugin for
+	// a container port published on a host port.
+	SourceCNIPortmap Source = "cni-portmap"
+	// A rule in CNI-HOSTPORT-DNAT jumping to a CNI-DN-* chain that has no
+	// DNAT rule for the port that could be parsed.
+	SourceCNIHostport Source = "cni-hostport"
+	// A kube-proxy rule in KUBE-NODEPORTS, for the node port of a service.
+	SourceKubeNodePort Source = "kube-nodeport"
+	// A kube-proxy rule in KUBE-SERVICES jumping to a KUBE-EXT-* (or, before
+	// kube-proxy 1.24, KUBE-FW-*) chain, for the load balancer or external
+	// IP of a service; klipper-lb load balancers show up here.
+	SourceKubeExternal Source = "kube-external"
+)
+
+// IsKubernetes reports whether the source is a kube-proxy rule, for a port
+// that the Kubernetes service watcher also forwards.
+func (s Source) IsKubernetes() bool {
+	return s == SourceKubeNodePort || s == SourceKubeExternal
+}
+
+// Entry is a port found in the iptables NAT table.
+type Entry struct {
+	TCP  bool
+	IP   net.IP
+	Port int
+	// Source is the kind of rule the port was found in.
+	Source Source
+	// Target is the address or chain the rule forwards to, for logging.
+	Target string
+}
 
 // Scanner is the interface that wraps the GetPorts method which
 // is used to scan the iptables.
 type Scanner interface {
-	GetPorts() ([]iptables.Entry, error)
+	GetPorts() ([]Entry, error)
 }
 
-type IptablesScanner struct{}
+// The iptables commands to list the NAT table with. The legacy and nft
+// backends keep separate tables, and Kubernetes may use either regardless of
+// which one the plain iptables command uses, so both are listed when
+// available; iptables is only used if neither is installed.
+var (
+	iptablesBackends = []string{"iptables-legacy", "iptables-nft"}
+	iptablesFallback = "iptables"
+)
+
+type IptablesScanner struct {
+	// checkOpen filters the entries to those that accept connections;
+	// overridden in tests.
+	checkOpen func([]Entry) []Entry
+}
 
 func NewIptablesScanner() *IptablesScanner {
-	return &IptablesScanner{}
+	return &IptablesScanner{checkOpen: checkPortsOpen}
+}
+
+// GetPorts lists the NAT rules of every available iptables backend, and
+// returns the ports they forward. A port found in several rules or backends
+// is only returned once.
+func (i *IptablesScanner) GetPorts() ([]Entry, error) {
+	var rules []string
+	var errs []error
+	listed := false
+	for _, command := range availableBackends() {
+		backendRules, err := listNATRules(command)
+		if err != nil {
+			log.Debugf("failed to list NAT rules with %s: %s", command, err)
+			errs = append(errs, fmt.Errorf("%s: %w", command, err))
+			continue
+		}
+		rules = append(rules, backendRules...)
+		listed = true
+	}
+	if !listed && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return i.checkOpen(parseNATRules(rules)), nil
+}
+
+// availableBackends returns the paths of the iptables commands to list the
+// NAT table with. The lookup is done on each scan, so that iptables being
+// installed after the agent started is picked up.
+func availableBackends() []string {
+	var commands []string
+	for _, name := range iptablesBackends {
+		if path, err := exec.LookPath(name); err == nil {
+			commands = append(commands, path)
+		}
+	}
+	if len(commands) == 0 {
+		if path, err := exec.LookPath(iptablesFallback); err == nil {
+			commands = append(commands, path)
+		}
+	}
+	return commands
+}
+
+// listNATRules runs the given iptables command to list the rules of the NAT
+// table, and returns them one per line.
+func listNATRules(command string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command, "-t", "nat", "-S")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Split(strings.TrimSpace(stdout.String()), "\n"), nil
 }
 
-func (i *IptablesScanner) GetPorts() ([]iptables.Entry, error) {
-	return iptables.GetPorts()
+// checkPortsOpen returns the entries whose TCP ports accept connections, so
+// that rules left behind by removed containers are not forwarded. UDP
+// entries can't be checked, and are all returned.
+func checkPortsOpen(entries []Entry) []Entry {
+	var open []Entry
+	for _, entry := range entries {
+		if entry.TCP {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(entry.IP.String(), strconv.Itoa(entry.Port)), time.Second)
+			if err != nil {
+				continue
+			}
+			conn.Close()
+		}
+		open = append(open, entry)
+	}
+	return open
 }
@@ -31,18 +31,25 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
-// event occurs when a NodePort in a service is added or removed.
+// event occurs when the NodePorts or LoadBalancer ports of a service change.
+// Each event carries the complete set of forwardable ports of the service.
 type event struct {
 	UID         types.UID
 	namespace   string
 	name        string
 	portMapping map[int32]corev1.Protocol
-	deleted     bool
+	// deleted is set when the service no longer has any forwardable ports,
+	// either because it was removed or because its type changed.
+	deleted bool
+	// synced is set on the event emitted once the initial listing of
+	// services has been sent; listed then holds the UIDs of those services.
+	synced bool
+	listed map[types.UID]bool
 }
 
 // watchServices monitors for NodePort and LoadBalancer services; after listing all service ports
 // initially, it reports service ports being added or deleted.
-func watchServices(ctx context.Context, client *kubernetes.Clientset) (<-chan event, <-chan error, error) {
+func watchServices(ctx context.Context, client kubernetes.Interface) (<-chan event, <-chan error, error) {
 	eventCh := make(chan event)
 	errorCh := make(chan error)
 	informerFactory := informers.NewSharedInformerFactory(client, 1*time.Hour)
@@ -51,18 +58,17 @@ func watchServices(ctx context.Context, client *kubernetes.Clientset) (<-chan ev
 	_, _ = sharedInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			log.Tracef("Service Informer: Add func called with: %+v", obj)
-			handleUpdate(nil, obj, eventCh)
+			handleUpdate(ctx, nil, obj, eventCh)
 		},
 		DeleteFunc: func(obj interface{}) {
 			log.Tracef("Service Informer: Del func called with: %+v", obj)
-			handleUpdate(obj, nil, eventCh)
+			handleUpdate(ctx, obj, nil, eventCh)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			log.Tracef("Service Informer: Update func called with old object %+v and new Object: %+v", oldObj, newObj)
-			handleUpdate(oldObj, newObj, eventCh)
+			handleUpdate(ctx, oldObj, newObj, eventCh)
 		},
 	})
-
 	err := sharedInformer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
 		log.Debugw("kubernetes: error watching", log.Fields{
 			"error": err,
@@ -113,8 +119,14 @@ func watchServices(ctx context.Context, client *kubernetes.Clientset) (<-chan ev
 	// List the initial set of services asynchronously, so that we don't have to
 	// worry about the channel blocking.
 	go func() {
+		listed := make(map[types.UID]bool, len(services.Items))
 		for i := range services.Items {
-			handleUpdate(nil, &services.Items[i], eventCh)
+			listed[services.Items[i].UID] = true
+			handleUpdate(ctx, nil, &services.Items[i], eventCh)
+		}
+		select {
+		case eventCh <- event{synced: true, listed: listed}:
+		case <-ctx.Done():
 		}
 	}()
 
@@ -126,72 +138,71 @@ func statusDebugString(err *apierrors.StatusError) string {
 	return fmt.Sprintf(format, args...)
 }
 
-// handleUpdate examines the old and new services, calculating the difference
-// and emitting events to the given channel.
-func handleUpdate(oldObj, newObj interface{}, eventCh chan<- event) {
-	deleted := make(map[int32]corev1.Protocol)
-	added := make(map[int32]corev1.Protocol)
-	oldSvc, _ := oldObj.(*corev1.Service)
-	newSvc, _ := newObj.(*corev1.Service)
+// handleUpdate examines the old and new services, and emits an event with
+// the resulting set of forwardable ports to the given channel.
+func handleUpdate(ctx context.Context, oldObj, newObj interface{}, eventCh chan<- event) {
+	oldSvc := toService(oldObj)
+	newSvc := toService(newObj)
+	oldPorts := servicePorts(oldSvc)
+	newPorts := servicePorts(newSvc)
+
+	switch {
+	case len(newPorts) > 0:
+		sendEvent(ctx, newPorts, newSvc, false, eventCh)
+	case len(oldPorts) > 0:
+		sendEvent(ctx, oldPorts, oldSvc, true, eventCh)
+	}
+
 	namespace := "<unknown>"
 	name := "<unknown>"
-
-	if oldSvc != nil {
-		namespace = oldSvc.Namespace
-		name = oldSvc.Name
-
-		if oldSvc.Spec.Type == corev1.ServiceTypeNodePort {
-			for _, port := range oldSvc.Spec.Ports {
-				deleted[port.NodePort] = port.Protocol
-			}
-		}
-
-		if oldSvc.Spec.Type == corev1.ServiceTypeLoadBalancer {
-			for _, port := range oldSvc.Spec.Ports {
-				deleted[port.Port] = port.Protocol
-			}
+	for _, svc := range []*corev1.Service{oldSvc, newSvc} {
+		if svc != nil {
+			namespace = svc.Namespace
+			name = svc.Name
 		}
 	}
+	log.Debugf("kubernetes service update: %s/%s has %d service port(s), previously %d",
+		namespace, name, len(newPorts), len(oldPorts))
+}
 
-	if newSvc != nil {
-		namespace = newSvc.Namespace
-		name = newSvc.Name
-
-		if newSvc.Spec.Type == corev1.ServiceTypeNodePort {
-			for _, port := range newSvc.Spec.Ports {
-				delete(deleted, port.NodePort)
-				added[port.NodePort] = port.Protocol
-			}
-		}
-
-		if newSvc.Spec.Type == corev1.ServiceTypeLoadBalancer {
-			for _, port := range newSvc.Spec.Ports {
-				delete(deleted, port.Port)
-				added[port.Port] = port.Protocol
-			}
-		}
+// toService converts an informer object into a service, unwrapping the
+// tombstone that is passed on deletion if the final state is unknown.
+func toService(obj interface{}) *corev1.Service {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
 	}
+	svc, _ := obj.(*corev1.Service)
+	return svc
+}
 
-	if len(deleted) > 0 {
-		sendEvents(deleted, oldSvc, true, eventCh)
+// servicePorts returns the ports that should be forwarded for the service:
+// the node ports of a NodePort service, or the service ports of a
+// LoadBalancer service (which klipper-lb listens on).
+func servicePorts(svc *corev1.Service) map[int32]corev1.Protocol {
+	ports := make(map[int32]corev1.Protocol)
+	if svc == nil {
+		return ports
 	}
-
-	if len(added) > 0 {
-		sendEvents(added, newSvc, false, eventCh)
+	for _, port := range svc.Spec.Ports {
+		switch svc.Spec.Type {
+		case corev1.ServiceTypeNodePort:
+			ports[port.NodePort] = port.Protocol
+		case corev1.ServiceTypeLoadBalancer:
+			ports[port.Port] = port.Protocol
+		}
 	}
-
-	log.Debugf("kubernetes service update: %s/%s has -%d +%d service port",
-		namespace, name, len(deleted), len(added))
+	return ports
 }
 
-func sendEvents(mapping map[int32]corev1.Protocol, svc *corev1.Service, deleted bool, eventCh chan<- event) {
-	if svc != nil {
-		eventCh <- event{
-			UID:         svc.UID,
-			namespace:   svc.Namespace,
-			name:        svc.Name,
-			portMapping: mapping,
-			deleted:     deleted,
-		}
+func sendEvent(ctx context.Context, mapping map[int32]corev1.Protocol, svc *corev1.Service, deleted bool, eventCh chan<- event) {
+	select {
+	case eventCh <- event{
+		UID:         svc.UID,
+		namespace:   svc.Namespace,
+		name:        svc.Name,
+		portMapping: mapping,
+		deleted:     deleted,
+	}:
+	case <-ctx.Done():
 	}
 }
@@ -25,7 +25,9 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -34,10 +36,12 @@ import (
 	"github.com/docker/go-connections/nat"
 	"golang.org/x/sys/unix"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 )
 
@@ -49,17 +53,18 @@ const (
 	stateNoConfig watcherState = iota
 	// stateDisconnected is when the configuration has been loaded, but not connected.
 	stateDisconnected
-	stateWatching
 )
 
 // WatchForServices watches Kubernetes for NodePort and LoadBalancer services
 // and create listeners on 0.0.0.0 matching them.
 // Any connection errors are ignored and retried.
+// If watching is not nil, it is called whenever the watch is established or lost.
 func WatchForServices(
 	ctx context.Context,
 	configPath string,
 	k8sServiceListenerIP net.IP,
 	portTracker tracker.Tracker,
+	watching func(active bool),
 ) error {
 	// These variables are shared across the different states
 	var (
@@ -67,14 +72,12 @@ func WatchForServices(
 		err       error
 		config    *restclient.Config
 		clientset *kubernetes.Clientset
-		eventCh   <-chan event
-		errorCh   <-chan error
+		forwarder = newServiceForwarder(portTracker, k8sServiceListenerIP)
 	)
 
-	watchContext, watchCancel := context.WithCancel(ctx)
-
-	// Always cancel if we failed.
-	defer watchCancel()
+	if watching != nil {
+		forwarder.watching = watching
+	}
 
 	for {
 		switch state {
@@ -111,8 +114,8 @@ func WatchForServices(
 				return fmt.Errorf("failed to create Kubernetes client: %w", err)
 			}
 
-			eventCh, errorCh, err = watchServices(watchContext, clientset)
-			if err != nil {
+			connected, err := forwarder.watch(ctx, clientset)
+			if !connected {
 				switch {
 				default:
 					return err
@@ -127,70 +130,160 @@ func WatchForServices(
 				continue
 			}
 
-			log.Debugf("watching kubernetes services")
-
-			state = stateWatching
-		case stateWatching:
-			select {
-			case <-ctx.Done():
+			if ctx.Err() != nil {
 				log.Debugw("kubernetes watcher: context closed", log.Fields{
 					"error": ctx.Err(),
 				})
 
 				return ctx.Err()
-			case err = <-errorCh:
-				log.Debugw("kubernetes: got error, rolling back", log.Fields{
-					"error": err,
-				})
-				watchCancel()
+			}
 
-				state = stateNoConfig
+			log.Debugw("kubernetes: got error, rolling back", log.Fields{
+				"error": err,
+			})
 
-				time.Sleep(time.Second)
+			state = stateNoConfig
 
-				continue
-			case event := <-eventCh:
-				if event.deleted {
-					if err := portTracker.Remove(string(event.UID)); err != nil {
-						log.Errorf("failed to delete port mapping: %v from tracker UID: %v namespace: %s name: %s failed: %s",
-							event.portMapping,
-							event.UID,
-							event.namespace,
-							event.name,
-							err)
-					} else {
-						log.Debugf("kubernetes service: port mapping deleted %s/%s:%v",
-							event.namespace, event.name, event.portMapping)
-					}
-				} else {
-					portMapping, err := createPortMapping(event.portMapping, k8sServiceListenerIP)
-					if err != nil {
-						log.Errorf("failed to create port mapping: %v from tracker UID: %v namespace: %s name: %s failed: %s",
-							event.portMapping,
-							event.UID,
-							event.namespace,
-							event.name,
-							err)
-
-						continue
-					}
-					if err := portTracker.Add(string(event.UID), portMapping); err != nil {
-						log.Errorf("failed to add port mapping: %v from tracker UID: %v namespace: %s name: %s failed: %s",
-							event.portMapping,
-							event.UID,
-							event.namespace,
-							event.name,
-							err)
-					} else {
-						log.Debugf("kubernetes service: port mapping added %s/%s:%v",
-							event.namespace, event.name, event.portMapping)
-					}
-				}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// serviceForwarder applies service events to the port tracker. It remembers
+// what has been forwarded for each service so that repeated events (for
+// example, when the watch is re-established after the API server restarts)
+// do not forward the same ports again.
+type serviceForwarder struct {
+	portTracker tracker.Tracker
+	listenerIP  net.IP
+	// The port mappings currently forwarded for each service.
+	forwarded map[types.UID]nat.PortMap
+	// Services forwarded before the current watch was established; any of
+	// these missing from its initial listing were deleted in the meantime.
+	stale map[types.UID]bool
+	// watching is called when the watch is established or lost.
+	watching func(active bool)
+}
+
+func newServiceForwarder(portTracker tracker.Tracker, listenerIP net.IP) *serviceForwarder {
+	return &serviceForwarder{
+		portTracker: portTracker,
+		listenerIP:  listenerIP,
+		forwarded:   make(map[types.UID]nat.PortMap),
+		watching:    func(bool) {},
+	}
+}
+
+// watch forwards services until the watch fails or the context is done. Each
+// watch gets its own context, so that it is torn down when the connection
+// fails. The returned boolean reports whether the watch was established.
+func (f *serviceForwarder) watch(ctx context.Context, client kubernetes.Interface) (bool, error) {
+	watchContext, watchCancel := context.WithCancel(ctx)
+	defer watchCancel()
+
+	eventCh, errorCh, err := watchServices(watchContext, client)
+	if err != nil {
+		return false, err
+	}
+
+	log.Debugf("watching kubernetes services")
+
+	f.watching(true)
+	defer f.watching(false)
+
+	f.restart()
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-errorCh:
+			return true, err
+		case event := <-eventCh:
+			f.handle(event)
+		}
+	}
+}
+
+// restart must be called whenever a new watch is established.
+func (f *serviceForwarder) restart() {
+	f.stale = make(map[types.UID]bool, len(f.forwarded))
+	for uid := range f.forwarded {
+		f.stale[uid] = true
+	}
+}
+
+func (f *serviceForwarder) handle(event event) {
+	health.WatcherSucceeded("kubernetes")
+	switch {
+	case event.synced:
+		for uid := range f.stale {
+			if !event.listed[uid] {
+				log.Debugf("kubernetes service: %s was removed while not watching", uid)
+				f.removeUID(uid)
 			}
 		}
+		f.stale = nil
+	case event.deleted:
+		f.removeUID(event.UID)
+		log.Debugf("kubernetes service: port mapping deleted %s/%s:%v",
+			event.namespace, event.name, event.portMapping)
+	default:
+		f.add(event)
 	}
 }
 
+func (f *serviceForwarder) add(event event) {
+	portMapping, err := createPortMapping(event.portMapping, f.listenerIP)
+	if err != nil {
+		log.Errorf("failed to create port mapping: %v from tracker UID: %v namespace: %s name: %s failed: %s",
+			event.portMapping,
+			event.UID,
+			event.namespace,
+			event.name,
+			err)
+
+		return
+	}
+	if current, ok := f.forwarded[event.UID]; ok {
+		if portMapsEqual(current, portMapping) {
+			log.Debugf("kubernetes service: port mapping unchanged %s/%s:%v",
+				event.namespace, event.name, event.portMapping)
+
+			return
+		}
+		// The tracker does not unbind existing listeners on Add.
+		f.removeUID(event.UID)
+	}
+	f.forwarded[event.UID] = portMapping
+	if err := f.portTracker.Add(string(event.UID), portMapping); err != nil {
+		log.Errorf("failed to add port mapping: %v from tracker UID: %v namespace: %s name: %s failed: %s",
+			event.portMapping,
+			event.UID,
+			event.namespace,
+			event.name,
+			err)
+	} else {
+		log.Debugf("kubernetes service: port mapping added %s/%s:%v",
+			event.namespace, event.name, event.portMapping)
+	}
+}
+
+func (f *serviceForwarder) removeUID(uid types.UID) {
+	if _, ok := f.forwarded[uid]; !ok {
+		return
+	}
+	delete(f.forwarded, uid)
+	if err := f.portTracker.Remove(string(uid)); err != nil {
+		log.Errorf("failed to delete port mapping from tracker UID: %v failed: %s", uid, err)
+	}
+}
+
+func portMapsEqual(a, b nat.PortMap) bool {
+	return maps.EqualFunc(a, b, func(x, y []nat.PortBinding) bool {
+		return slices.Equal(x, y)
+	})
+}
+
 // getClientConfig returns a rest config.
 func getClientConfig(configPath string) (*restclient.Config, error) {
 	loadingRules := clientcmd.ClientConfigLoadingRules{
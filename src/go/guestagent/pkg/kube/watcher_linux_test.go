@@ -0,0 +1,271 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// recordingTracker is a tracker.Tracker that records the calls made to it.
+type recordingTracker struct {
+	portMappings map[string]nat.PortMap
+	adds         []string
+	removes      []string
+}
+
+func newRecordingTracker() *recordingTracker {
+	return &recordingTracker{portMappings: make(map[string]nat.PortMap)}
+}
+
+func (r *recordingTracker) Get(containerID string) nat.PortMap {
+	return r.portMappings[containerID]
+}
+
+func (r *recordingTracker) Add(containerID string, portMapping nat.PortMap) error {
+	r.adds = append(r.adds, containerID)
+	r.portMappings[containerID] = portMapping
+	return nil
+}
+
+func (r *recordingTracker) Remove(containerID string) error {
+	r.removes = append(r.removes, containerID)
+	delete(r.portMappings, containerID)
+	return nil
+}
+
+func (r *recordingTracker) RemoveAll() error {
+	clear(r.portMappings)
+	return nil
+}
+
+func newService(uid, name string, serviceType corev1.ServiceType, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			UID:       types.UID(uid),
+			Namespace: "default",
+			Name:      name,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  serviceType,
+			Ports: ports,
+		},
+	}
+}
+
+// newFakeClient returns a fake clientset, and a channel that receives a value
+// each time a watch on services is established.
+func newFakeClient(objects ...runtime.Object) (*fake.Clientset, <-chan struct{}) {
+	client := fake.NewClientset(objects...)
+	watchStarted := make(chan struct{}, 10)
+	client.PrependWatchReactor("services", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		gvr := action.GetResource()
+		ns := action.GetNamespace()
+		watcher, err := client.Tracker().Watch(gvr, ns)
+		if err != nil {
+			return false, nil, err
+		}
+		watchStarted <- struct{}{}
+		return true, watcher, nil
+	})
+	return client, watchStarted
+}
+
+// pump feeds events to the forwarder until the condition is satisfied, and
+// then keeps going for a short while to catch any unexpected events.
+func pump(t *testing.T, eventCh <-chan event, forwarder *serviceForwarder, condition func() bool) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for !condition() {
+		select {
+		case e := <-eventCh:
+			forwarder.handle(e)
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for service events")
+		}
+	}
+	settle := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case e := <-eventCh:
+			forwarder.handle(e)
+		case <-settle:
+			return
+		}
+	}
+}
+
+func waitForWatch(t *testing.T, watchStarted <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-watchStarted:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out waiting for the service watch")
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	t.Run("forwards added services", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		client, watchStarted := newFakeClient(
+			newService("node-port", "web", corev1.ServiceTypeNodePort,
+				corev1.ServicePort{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}),
+			newService("cluster-ip", "internal", corev1.ServiceTypeClusterIP,
+				corev1.ServicePort{Port: 8080, Protocol: corev1.ProtocolTCP}),
+		)
+		eventCh, _, err := watchServices(ctx, client)
+		require.NoError(t, err)
+		portTracker := newRecordingTracker()
+		forwarder := newServiceForwarder(portTracker, net.IPv4zero)
+
+		pump(t, eventCh, forwarder, func() bool {
+			return portTracker.Get("node-port") != nil
+		})
+		require.Equal(t, nat.PortMap{
+			"30080/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "30080"}},
+		}, portTracker.Get("node-port"))
+		require.Nil(t, portTracker.Get("cluster-ip"))
+		// Both the informer and the initial listing report the service.
+		require.Equal(t, []string{"node-port"}, portTracker.adds)
+
+		waitForWatch(t, watchStarted)
+		_, err = client.CoreV1().Services("default").Create(ctx,
+			newService("load-balancer", "lb", corev1.ServiceTypeLoadBalancer,
+				corev1.ServicePort{Port: 443, NodePort: 30443, Protocol: corev1.ProtocolTCP}),
+			v1.CreateOptions{})
+		require.NoError(t, err)
+		pump(t, eventCh, forwarder, func() bool {
+			return portTracker.Get("load-balancer") != nil
+		})
+		require.Equal(t, nat.PortMap{
+			"443/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "443"}},
+		}, portTracker.Get("load-balancer"))
+		require.Empty(t, portTracker.removes)
+	})
+	t.Run("replaces ports on update", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		svc := newService("node-port", "web", corev1.ServiceTypeNodePort,
+			corev1.ServicePort{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP})
+		client, watchStarted := newFakeClient(svc)
+		eventCh, _, err := watchServices(ctx, client)
+		require.NoError(t, err)
+		portTracker := newRecordingTracker()
+		forwarder := newServiceForwarder(portTracker, net.IPv4(127, 0, 0, 1))
+
+		pump(t, eventCh, forwarder, func() bool {
+			return portTracker.Get("node-port") != nil
+		})
+		waitForWatch(t, watchStarted)
+
+		svc = svc.DeepCopy()
+		svc.Spec.Ports[0].NodePort = 30081
+		_, err = client.CoreV1().Services("default").Update(ctx, svc, v1.UpdateOptions{})
+		require.NoError(t, err)
+		pump(t, eventCh, forwarder, func() bool {
+			return len(portTracker.removes) > 0
+		})
+		require.Equal(t, nat.PortMap{
+			"30081/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "30081"}},
+		}, portTracker.Get("node-port"))
+		require.Equal(t, []string{"node-port", "node-port"}, portTracker.adds)
+		require.Equal(t, []string{"node-port"}, portTracker.removes)
+
+		svc = svc.DeepCopy()
+		svc.Spec.Type = corev1.ServiceTypeClusterIP
+		_, err = client.CoreV1().Services("default").Update(ctx, svc, v1.UpdateOptions{})
+		require.NoError(t, err)
+		pump(t, eventCh, forwarder, func() bool {
+			return portTracker.Get("node-port") == nil
+		})
+		require.Equal(t, []string{"node-port", "node-port"}, portTracker.removes)
+	})
+	t.Run("removes deleted services", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		client, watchStarted := newFakeClient(
+			newService("node-port", "web", corev1.ServiceTypeNodePort,
+				corev1.ServicePort{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}),
+		)
+		eventCh, _, err := watchServices(ctx, client)
+		require.NoError(t, err)
+		portTracker := newRecordingTracker()
+		forwarder := newServiceForwarder(portTracker, net.IPv4zero)
+
+		pump(t, eventCh, forwarder, func() bool {
+			return portTracker.Get("node-port") != nil
+		})
+		waitForWatch(t, watchStarted)
+
+		err = client.CoreV1().Services("default").Delete(ctx, "web", v1.DeleteOptions{})
+		require.NoError(t, err)
+		pump(t, eventCh, forwarder, func() bool {
+			return portTracker.Get("node-port") == nil
+		})
+		require.Equal(t, []string{"node-port"}, portTracker.removes)
+	})
+	t.Run("does not duplicate forwards when watching again", func(t *testing.T) {
+		client, watchStarted := newFakeClient(
+			newService("kept", "kept", corev1.ServiceTypeNodePort,
+				corev1.ServicePort{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}),
+			newService("deleted", "deleted", corev1.ServiceTypeNodePort,
+				corev1.ServicePort{Port: 81, NodePort: 30081, Protocol: corev1.ProtocolTCP}),
+		)
+		portTracker := newRecordingTracker()
+		forwarder := newServiceForwarder(portTracker, net.IPv4zero)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		eventCh, _, err := watchServices(ctx, client)
+		require.NoError(t, err)
+		forwarder.restart()
+		pump(t, eventCh, forwarder, func() bool {
+			return len(portTracker.adds) == 2
+		})
+		waitForWatch(t, watchStarted)
+		// Simulate the API server going away.
+		cancel()
+
+		// This deletion is not observed by any watch.
+		err = client.CoreV1().Services("default").Delete(t.Context(), "deleted", v1.DeleteOptions{})
+		require.NoError(t, err)
+
+		ctx, cancel = context.WithCancel(t.Context())
+		defer cancel()
+		eventCh, _, err = watchServices(ctx, client)
+		require.NoError(t, err)
+		forwarder.restart()
+		pump(t, eventCh, forwarder, func() bool {
+			return portTracker.Get("deleted") == nil
+		})
+		require.Len(t, portTracker.adds, 2)
+		require.Equal(t, []string{"deleted"}, portTracker.removes)
+		require.NotNil(t, portTracker.Get("kept"))
+	})
+}
@@ -28,6 +28,7 @@ func WatchForServices(
 	configPath string,
 	k8sServiceListenerIP net.IP,
 	portTracker tracker.Tracker,
+	watching func(active bool),
 ) error {
 	return fmt.Errorf("not implemented for non-linux")
 }
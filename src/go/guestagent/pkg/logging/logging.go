@@ -0,0 +1,156 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging sets up the logger of the guest agent: leveled, as text or
+// JSON, and written to a file that is rotated by size, since logrotate is not
+// guaranteed to be installed in the distro.
+//
+// Every key event is logged with the same fields, so that the logs can be
+// searched for a given container or watcher: FieldEvent names the event, and
+// the other Field* constants describe it.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/log-go"
+	logruslog "github.com/Masterminds/log-go/impl/logrus"
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+)
+
+// The fields attached to key events.
+const (
+	FieldEvent       = "event"
+	FieldWatcher     = "watcher"
+	FieldContainerID = "container-id"
+	FieldPorts       = "ports"
+	FieldError       = "error"
+)
+
+// The values of FieldEvent.
+const (
+	// A watcher started or stopped; FieldWatcher names it.
+	EventStart = "start"
+	EventStop  = "stop"
+	// Ports of a container were forwarded to, or withdrawn from, the host.
+	EventForward   = "forward"
+	EventUnforward = "unforward"
+	// The connection to the host or to a container engine was lost or
+	// restored.
+	EventDisconnect = "disconnect"
+	EventReconnect  = "reconnect"
+)
+
+// The formats logs can be written in.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Levels are the levels that can be logged at, from the most verbose.
+var Levels = []string{"trace", "debug", "info", "warn", "error"}
+
+// The logger set up by Setup, for SetLevel.
+var current *logrus.Logger
+
+// Options configures Setup.
+type Options struct {
+	// The least severe level logged; one of Levels.
+	Level string
+	// FormatText or FormatJSON.
+	Format string
+	// The file to log to; standard error if empty.
+	File string
+	// The size, in bytes, above which File is rotated; never if zero.
+	MaxSize int64
+	// The number of rotated files kept besides File.
+	MaxFiles int
+}
+
+// Setup makes log.Current log as described by the options. The returned
+// closer closes the log file, if any.
+func Setup(opts Options) (io.Closer, error) {
+	level, err := parseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(level)
+
+	switch opts.Format {
+	case FormatText, "":
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log format %q, valid options are %s and %s", opts.Format, FormatText, FormatJSON)
+	}
+
+	var closer io.Closer = io.NopCloser(nil)
+	if opts.File == "" {
+		logger.SetOutput(os.Stderr)
+	} else {
+		file, err := OpenRotatingFile(opts.File, opts.MaxSize, opts.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		logger.SetOutput(file)
+		closer = file
+	}
+
+	current = logger
+	log.Current = logruslog.New(logger)
+
+	return closer, nil
+}
+
+// SetLevel changes the level the logger set up by Setup logs at.
+func SetLevel(level string) error {
+	parsed, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		current.SetLevel(parsed)
+	}
+
+	return nil
+}
+
+func parseLevel(level string) (logrus.Level, error) {
+	if !slices.Contains(Levels, level) {
+		return 0, fmt.Errorf("unknown log level %q, valid options are %s", level, strings.Join(Levels, ", "))
+	}
+
+	return logrus.ParseLevel(level)
+}
+
+// Ports formats the ports of a port map for FieldPorts, in a stable order, as
+// in "80/tcp=127.0.0.1:8080,443/tcp=0.0.0.0:8443".
+func Ports(portMap nat.PortMap) string {
+	var ports []string
+	for port, bindings := range portMap {
+		for _, binding := range bindings {
+			ports = append(ports, fmt.Sprintf("%s=%s:%s", port, binding.HostIP, binding.HostPort))
+		}
+	}
+	slices.Sort(ports)
+
+	return strings.Join(ports, ",")
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/log-go"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Setup replaces the global logger, so these can't run in parallel.
+func TestSetup(t *testing.T) {
+	previous := log.Current
+	t.Cleanup(func() { log.Current = previous })
+
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "agent.log")
+		closer, err := Setup(Options{Level: "info", Format: FormatJSON, File: path})
+		require.NoError(t, err)
+
+		log.Debug("hidden")
+		log.Infow("ports forwarded", log.Fields{
+			FieldEvent:       EventForward,
+			FieldContainerID: "abc",
+		})
+		require.NoError(t, closer.Close())
+
+		lines := strings.Split(strings.TrimSpace(readFile(t, path)), "\n")
+		require.Len(t, lines, 1)
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "ports forwarded", entry["msg"])
+		assert.Equal(t, EventForward, entry[FieldEvent])
+		assert.Equal(t, "abc", entry[FieldContainerID])
+	})
+
+	t.Run("text", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "agent.log")
+		closer, err := Setup(Options{Level: "debug", Format: FormatText, File: path})
+		require.NoError(t, err)
+
+		log.Debugw("watcher started", log.Fields{FieldEvent: EventStart, FieldWatcher: "docker"})
+		require.NoError(t, closer.Close())
+
+		contents := readFile(t, path)
+		assert.Contains(t, contents, "level=debug")
+		assert.Contains(t, contents, `msg="watcher started"`)
+		assert.Contains(t, contents, "event=start")
+		assert.Contains(t, contents, "watcher=docker")
+	})
+
+	t.Run("set level", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "agent.log")
+		closer, err := Setup(Options{Level: "info", File: path})
+		require.NoError(t, err)
+
+		log.Debug("hidden")
+		require.NoError(t, SetLevel("debug"))
+		log.Debug("shown")
+		assert.Error(t, SetLevel("warning"))
+		require.NoError(t, closer.Close())
+
+		contents := readFile(t, path)
+		assert.NotContains(t, contents, "hidden")
+		assert.Contains(t, contents, "shown")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := Setup(Options{Level: "loud"})
+		assert.Error(t, err)
+		_, err = Setup(Options{Level: "info", Format: "xml"})
+		assert.Error(t, err)
+	})
+}
+
+func TestPorts(t *testing.T) {
+	t.Parallel()
+
+	portMap := nat.PortMap{
+		"443/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8443"}},
+		"80/tcp": []nat.PortBinding{
+			{HostIP: "127.0.0.1", HostPort: "8080"},
+			{HostIP: "0.0.0.0", HostPort: "8080"},
+		},
+	}
+	assert.Equal(t, "443/tcp=0.0.0.0:8443,80/tcp=0.0.0.0:8080,80/tcp=127.0.0.1:8080", Ports(portMap))
+	assert.Empty(t, Ports(nil))
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("rotates by size", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "agent.log")
+		file, err := OpenRotatingFile(path, 10, 2)
+		require.NoError(t, err)
+		defer file.Close()
+
+		for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+			_, err := file.Write([]byte(line))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, "fourth\n", readFile(t, path))
+		assert.Equal(t, "third\n", readFile(t, filepath.Join(filepath.Dir(path), "agent.1.log")))
+		assert.Equal(t, "second\n", readFile(t, filepath.Join(filepath.Dir(path), "agent.2.log")))
+		assert.NoFileExists(t, filepath.Join(filepath.Dir(path), "agent.3.log"))
+	})
+
+	t.Run("appends to an existing file", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "agent.log")
+		require.NoError(t, os.WriteFile(path, []byte("existing\n"), 0o644))
+		file, err := OpenRotatingFile(path, 12, 1)
+		require.NoError(t, err)
+		defer file.Close()
+
+		_, err = file.Write([]byte("new\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "new\n", readFile(t, path))
+		assert.Equal(t, "existing\n", readFile(t, filepath.Join(filepath.Dir(path), "agent.1.log")))
+	})
+
+	t.Run("never splits a write", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "agent.log")
+		file, err := OpenRotatingFile(path, 4, 1)
+		require.NoError(t, err)
+		defer file.Close()
+
+		long := strings.Repeat("x", 10) + "\n"
+		_, err = file.Write([]byte(long))
+		require.NoError(t, err)
+
+		assert.Equal(t, long, readFile(t, path))
+		assert.NoFileExists(t, filepath.Join(filepath.Dir(path), "agent.1.log"))
+	})
+
+	t.Run("keeps no rotated files", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "agent.log")
+		file, err := OpenRotatingFile(path, 8, 0)
+		require.NoError(t, err)
+		defer file.Close()
+
+		for _, line := range []string{"first\n", "second\n"} {
+			_, err := file.Write([]byte(line))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, "second\n", readFile(t, path))
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("never rotates without a size", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "agent.log")
+		file, err := OpenRotatingFile(path, 0, 2)
+		require.NoError(t, err)
+		defer file.Close()
+
+		for _, line := range []string{"first\n", "second\n"} {
+			_, err := file.Write([]byte(line))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, "first\nsecond\n", readFile(t, path))
+	})
+}
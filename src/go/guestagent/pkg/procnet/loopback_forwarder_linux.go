@@ -11,7 +11,9 @@ You may obtain a copy of the License at
 // observes (--network=host containers), it opens a matching listener on
 // bindIP -- the tap-interface IP that gvisor-tap-vsock host-switch
 // already routes to -- and pipes accepted connections to
-// 127.0.0.1:<port>.
+// 127.0.0.1:<port>. When IPv6 loopback relaying is enabled, [::1]
+// listeners are handled the same way, with connections piped to
+// [::1]:<port>.
 //
 // This replaces the PREROUTING DNAT rule procnet previously wrote into
 // the nat table. Both paths bridge eth0-arriving traffic to the
@@ -66,9 +68,9 @@ func key(proto string, port uint16) string {
 	return proto + "/" + strconv.Itoa(int(port))
 }
 
-// Add opens a userspace forwarder for proto/port. Repeated Adds for
-// the same key are idempotent. The caller must call Remove when the
-// upstream listener disappears.
+// Add opens a userspace forwarder for proto/port that connects to
+// target:port. Repeated Adds for the same key are idempotent. The
+// caller must call Remove when the upstream listener disappears.
 //
 // EADDRINUSE on the bind step propagates as a plain listen error.
 // The scanner's publish path rolls back the tracker entry and retries
@@ -79,7 +81,7 @@ func key(proto string, port uint16) string {
 // a wildcard entry, since the wildcard listener already accepts
 // bindIP:port directly. The remaining EADDRINUSE trigger is an
 // unrelated process inside the engine namespace holding bindIP:port.
-func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16) error {
+func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16, target net.IP) error {
 	k := key(proto, port)
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -94,7 +96,7 @@ func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16)
 			return fmt.Errorf("listen %s: %w", k, err)
 		}
 		f.tcp[k] = lis
-		go f.acceptTCP(ctx, lis, port)
+		go f.acceptTCP(ctx, lis, port, target)
 	case protoUDP:
 		if _, ok := f.udp[k]; ok {
 			return nil
@@ -103,7 +105,7 @@ func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16)
 		if err != nil {
 			return fmt.Errorf("listen %s: %w", k, err)
 		}
-		target := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
+		targetAddr := net.JoinHostPort(target.String(), strconv.Itoa(int(port)))
 		// Each flow's idle timeout is forwarder.UDPConnTrackTimeout (90s).
 		// The dial closure runs for every new client flow, including
 		// flows that arrive long after Add returns. ctx must therefore
@@ -111,7 +113,7 @@ func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16)
 		// scanner's lifetime context); a request-scoped or per-tick
 		// ctx would silently break new-flow dialing once cancelled.
 		proxy, err := forwarder.NewUDPProxy(pc, func() (net.Conn, error) {
-			return f.dialer.DialContext(ctx, protoUDP, target)
+			return f.dialer.DialContext(ctx, protoUDP, targetAddr)
 		})
 		if err != nil {
 			_ = pc.Close()
@@ -174,7 +176,7 @@ const (
 	halfCloseDrainTimeout     = 30 * time.Second
 )
 
-func (f *loopbackForwarder) acceptTCP(ctx context.Context, lis net.Listener, port uint16) {
+func (f *loopbackForwarder) acceptTCP(ctx context.Context, lis net.Listener, port uint16, target net.IP) {
 	backoff := acceptRetryInitialBackoff
 	// loggedAcceptError throttles per-listener Accept-error logs the
 	// same way logAddFailure throttles publish-failure logs in the
@@ -210,13 +212,13 @@ func (f *loopbackForwarder) acceptTCP(ctx context.Context, lis net.Listener, por
 		}
 		backoff = acceptRetryInitialBackoff
 		loggedAcceptError = false
-		go f.pipeTCP(ctx, conn, port)
+		go f.pipeTCP(ctx, conn, port, target)
 	}
 }
 
-func (f *loopbackForwarder) pipeTCP(ctx context.Context, in net.Conn, port uint16) {
+func (f *loopbackForwarder) pipeTCP(ctx context.Context, in net.Conn, port uint16, target net.IP) {
 	defer in.Close()
-	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
+	addr := net.JoinHostPort(target.String(), strconv.Itoa(int(port)))
 	out, err := f.dialer.DialContext(ctx, protoTCP, addr)
 	if err != nil {
 		log.Debugf("loopback forwarder dial tcp/%d: %s", port, err)
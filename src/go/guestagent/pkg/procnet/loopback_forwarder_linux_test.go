@@ -61,7 +61,7 @@ func TestForwarderTCPEndToEnd(t *testing.T) {
 	bindIP := net.ParseIP("127.0.0.99")
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
-	if err := fwd.Add(context.Background(), "tcp", port); err != nil {
+	if err := fwd.Add(context.Background(), "tcp", port, net.IPv4(127, 0, 0, 1)); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 
@@ -81,6 +81,48 @@ func TestForwarderTCPEndToEnd(t *testing.T) {
 	}
 }
 
+func TestForwarderTCPToIPv6Loopback(t *testing.T) {
+	var lc net.ListenConfig
+	ln, err := lc.Listen(context.Background(), "tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("listen on [::1] failed (IPv6 unavailable): %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(c, "hello from ipv6")
+			_ = c.Close()
+		}
+	}()
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	bindIP := net.ParseIP("127.0.0.99")
+	fwd := newLoopbackForwarder(bindIP)
+	defer fwd.Close()
+	if err := fwd.Add(context.Background(), "tcp", port, net.IPv6loopback); err != nil {
+		t.Fatalf("forwarder.Add: %v", err)
+	}
+
+	conn, err := dial("tcp", fmt.Sprintf("127.0.0.99:%d", port), 2*time.Second)
+	if err != nil {
+		t.Skipf("dial via 127.0.0.99 failed (loopback aliases unavailable): %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read from forwarder: %v", err)
+	}
+	if got, want := string(buf), "hello from ipv6"; got != want {
+		t.Fatalf("forwarded payload = %q, want %q", got, want)
+	}
+}
+
 func TestForwarderRemoveStopsListening(t *testing.T) {
 	port, stop := startUpstream(t, "x")
 	defer stop()
@@ -89,7 +131,7 @@ func TestForwarderRemoveStopsListening(t *testing.T) {
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
 
-	if err := fwd.Add(context.Background(), "tcp", port); err != nil {
+	if err := fwd.Add(context.Background(), "tcp", port, net.IPv4(127, 0, 0, 1)); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 	// Sanity: forwarder is listening.
@@ -122,7 +164,7 @@ func TestForwarderAddIsIdempotent(t *testing.T) {
 	defer fwd.Close()
 
 	for i := 0; i < 3; i++ {
-		if err := fwd.Add(context.Background(), "tcp", port); err != nil {
+		if err := fwd.Add(context.Background(), "tcp", port, net.IPv4(127, 0, 0, 1)); err != nil {
 			t.Fatalf("forwarder.Add iteration %d: %v", i, err)
 		}
 	}
@@ -163,7 +205,7 @@ func TestForwarderAddFailsWhenPortInUse(t *testing.T) {
 			fwd := newLoopbackForwarder(bindIP)
 			defer fwd.Close()
 
-			if err := fwd.Add(context.Background(), tc.proto, port); err == nil {
+			if err := fwd.Add(context.Background(), tc.proto, port, net.IPv4(127, 0, 0, 1)); err == nil {
 				t.Fatalf("forwarder.Add succeeded on busy port; expected EADDRINUSE")
 			}
 		})
@@ -206,7 +248,7 @@ func TestForwarderUDPEndToEnd(t *testing.T) {
 	bindIP := net.ParseIP("127.0.0.99")
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
-	if err := fwd.Add(context.Background(), "udp", port); err != nil {
+	if err := fwd.Add(context.Background(), "udp", port, net.IPv4(127, 0, 0, 1)); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 
@@ -238,7 +280,7 @@ func TestForwarderUDPConcurrentFlows(t *testing.T) {
 	bindIP := net.ParseIP("127.0.0.99")
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
-	if err := fwd.Add(context.Background(), "udp", port); err != nil {
+	if err := fwd.Add(context.Background(), "udp", port, net.IPv4(127, 0, 0, 1)); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 
@@ -32,6 +32,12 @@ reachable from Windows. Containers that bind dual-stack
 also invisible because the socket appears only in /proc/net/tcp6.
 Only listeners that bind 127.0.0.1, 0.0.0.0, or both v4 and v6
 separately are reachable from Windows.
+
+The exception is [::1]: when IPv6 loopback relaying is enabled, a
+listener that binds only [::1]:port is published as 127.0.0.1:port
+(the expose API only handles IPv4), and the userspace forwarder pipes
+traffic to [::1]:port. Without it, such listeners are still skipped,
+but the scanner logs that it did so.
 */
 package procnet
 
@@ -47,20 +53,23 @@ import (
 	"github.com/docker/go-connections/nat"
 	"github.com/lima-vm/lima/pkg/guestagent/procnettcp"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/health"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
 
 const (
-	loopbackIP = "127.0.0.1"
-	wildcardIP = "0.0.0.0"
+	loopbackIP     = "127.0.0.1"
+	ipv6LoopbackIP = "::1"
+	wildcardIP     = "0.0.0.0"
 )
 
 // loopbackController is what the scanner calls to manage userspace
-// listeners for 127.0.0.1 ports. The real implementation opens listeners
-// on bindIP; unit tests substitute a recording fake.
+// listeners for loopback ports. The real implementation opens listeners
+// on bindIP that connect to target; unit tests substitute a recording
+// fake.
 type loopbackController interface {
-	Add(ctx context.Context, proto string, port uint16) error
+	Add(ctx context.Context, proto string, port uint16, target net.IP) error
 	Remove(proto string, port uint16) error
 	Close() error
 }
@@ -86,6 +95,13 @@ type ProcNetScanner struct {
 	// Error lines per stuck port when wsl-proxy or host-switch is
 	// down, drowning the log.
 	addErrorLogged map[nat.Port]bool
+
+	// relayIPv6Loopback enables forwarding [::1] listeners; see the
+	// package comment.
+	relayIPv6Loopback bool
+	// skipLogged holds the [::1] listeners that were skipped in the
+	// last scan, so that each is only logged when it first appears.
+	skipLogged map[nat.Port]bool
 }
 
 // NewProcNetScanner constructs a /proc/net scanner that publishes
@@ -96,9 +112,18 @@ type ProcNetScanner struct {
 // engine-namespace listener to accept bindIP:port directly and
 // skip the forwarder. scanInterval controls the poll cadence; the
 // two-scan stability gate adds one additional cadence of delay
-// before a new port is published.
-func NewProcNetScanner(ctx context.Context, t tracker.Tracker, bindIP net.IP, scanInterval time.Duration) (*ProcNetScanner, error) {
-	return newScanner(ctx, t, newLoopbackForwarder(bindIP), bindIP, scanInterval), nil
+// before a new port is published. relayIPv6Loopback additionally
+// forwards listeners that only bind [::1].
+func NewProcNetScanner(
+	ctx context.Context,
+	t tracker.Tracker,
+	bindIP net.IP,
+	scanInterval time.Duration,
+	relayIPv6Loopback bool,
+) (*ProcNetScanner, error) {
+	scanner := newScanner(ctx, t, newLoopbackForwarder(bindIP), bindIP, scanInterval)
+	scanner.relayIPv6Loopback = relayIPv6Loopback
+	return scanner, nil
 }
 
 func newScanner(ctx context.Context, t tracker.Tracker, f loopbackController, bindIP net.IP, scanInterval time.Duration) *ProcNetScanner {
@@ -111,6 +136,7 @@ func newScanner(ctx context.Context, t tracker.Tracker, f loopbackController, bi
 		published:      make(nat.PortMap),
 		pending:        make(map[nat.Port]struct{}),
 		addErrorLogged: make(map[nat.Port]bool),
+		skipLogged:     make(map[nat.Port]bool),
 	}
 }
 
@@ -126,11 +152,13 @@ func (p *ProcNetScanner) ForwardPorts() error {
 		case <-p.ctx.Done():
 			return fmt.Errorf("/proc/net scanner context cancelled: %w", p.ctx.Err())
 		case <-ticker.C:
+			scanStart := time.Now()
 			scanned, err := p.scanListeners()
 			if err != nil {
 				log.Errorf("failed to scan /proc/net: %s", err)
 				continue
 			}
+			health.ObserveScan("procnet", time.Since(scanStart))
 			p.Tick(scanned)
 		}
 	}
@@ -196,7 +224,7 @@ func (p *ProcNetScanner) Tick(scanned nat.PortMap) {
 // of recording it as published.
 func (p *ProcNetScanner) publish(port nat.Port, bindings []nat.PortBinding) error {
 	id := utils.GenerateID(fmt.Sprintf("%s/%s", port.Proto(), port.Port()))
-	if err := p.tracker.Add(id, nat.PortMap{port: bindings}); err != nil {
+	if err := p.tracker.Add(id, nat.PortMap{port: hostBindings(port, bindings)}); err != nil {
 		p.logAddFailure(port, fmt.Sprintf("failed to add: %s", err))
 		if removeErr := p.tracker.Remove(id); removeErr != nil {
 			p.logAddFailure(port, fmt.Sprintf("rollback after tracker.Add failure: %s", removeErr))
@@ -213,7 +241,8 @@ func (p *ProcNetScanner) publish(port nat.Port, bindings []nat.PortBinding) erro
 		// (addEntryToPortMap derives both from entry.Port), so forwarder.Add
 		// sees one key and rollback unwinds at most one listener.
 		for _, b := range bindings {
-			if b.HostIP != loopbackIP {
+			target := loopbackTarget(b.HostIP)
+			if target == nil {
 				continue
 			}
 			portNum, err := strconv.ParseUint(b.HostPort, 10, 16)
@@ -229,7 +258,7 @@ func (p *ProcNetScanner) publish(port nat.Port, bindings []nat.PortBinding) erro
 				}
 				return fmt.Errorf("/proc/net scanner: bad port %q: %w", b.HostPort, err)
 			}
-			if err := p.forwarder.Add(p.ctx, port.Proto(), uint16(portNum)); err != nil {
+			if err := p.forwarder.Add(p.ctx, port.Proto(), uint16(portNum), target); err != nil {
 				p.logAddFailure(port, fmt.Sprintf("loopback forwarder %s/%s: %s", port.Proto(), b.HostPort, err))
 				if removeErr := p.tracker.Remove(id); removeErr != nil {
 					p.logAddFailure(port, fmt.Sprintf("rollback after forwarder.Add failure: %s", removeErr))
@@ -278,7 +307,7 @@ func (p *ProcNetScanner) unpublish(port nat.Port, bindings []nat.PortBinding) {
 	}
 
 	for _, b := range bindings {
-		if b.HostIP != loopbackIP {
+		if loopbackTarget(b.HostIP) == nil {
 			continue
 		}
 		portNum, err := strconv.ParseUint(b.HostPort, 10, 16)
@@ -317,16 +346,92 @@ func (p *ProcNetScanner) scanListeners() (nat.PortMap, error) {
 // bindIP -- so the gap is acceptable. A tighter filter would require
 // procnettcp to expose inode-level ownership so the forwarder's
 // sockets can be identified without overlap.
+//
+// Listeners on [::1] are only included for ports that have no IPv4
+// listener, and only if IPv6 loopback relaying is enabled.
 func (p *ProcNetScanner) entriesToPortMap(entries []procnettcp.Entry) nat.PortMap {
 	out := make(nat.PortMap)
+	ipv6Loopback := make(map[nat.Port]bool)
 	for _, entry := range entries {
 		if entry.IP.Equal(p.bindIP) {
 			continue
 		}
+		if port, ok := ipv6LoopbackPort(entry); ok {
+			ipv6Loopback[port] = true
+			continue
+		}
 		if err := addValidProtoEntryToPortMap(entry, out); err != nil {
 			log.Errorf("failed to create portMapping for entry: %s", err)
 		}
 	}
+
+	skipped := make(map[nat.Port]bool)
+	for port := range ipv6Loopback {
+		if _, ok := out[port]; ok {
+			// The IPv4 listener on the same port is forwarded instead.
+			continue
+		}
+		if !p.relayIPv6Loopback {
+			if !p.skipLogged[port] {
+				log.Infof("/proc/net scanner: not forwarding %s listener on [::1]:%s; "+
+					"enable IPv6 loopback relaying to forward it", port.Proto(), port.Port())
+			}
+			skipped[port] = true
+			continue
+		}
+		out[port] = []nat.PortBinding{{HostIP: ipv6LoopbackIP, HostPort: port.Port()}}
+	}
+	p.skipLogged = skipped
+	return out
+}
+
+// ipv6LoopbackPort returns the port of entry if it is a TCP or UDP
+// listener on [::1].
+func ipv6LoopbackPort(entry procnettcp.Entry) (nat.Port, bool) {
+	var proto string
+	switch {
+	case entry.Kind == procnettcp.TCP6 && entry.State == procnettcp.TCPListen:
+		proto = protoTCP
+	case entry.Kind == procnettcp.UDP6 && entry.State == procnettcp.UDPEstablished:
+		proto = protoUDP
+	default:
+		return "", false
+	}
+	if !entry.IP.Equal(net.IPv6loopback) {
+		return "", false
+	}
+	port, err := nat.NewPort(proto, strconv.Itoa(int(entry.Port)))
+	if err != nil {
+		return "", false
+	}
+	return port, true
+}
+
+// loopbackTarget returns the address the forwarder should connect to
+// for a binding on hostIP, or nil if the binding needs no forwarder.
+func loopbackTarget(hostIP string) net.IP {
+	switch hostIP {
+	case loopbackIP:
+		return net.IPv4(127, 0, 0, 1)
+	case ipv6LoopbackIP:
+		return net.IPv6loopback
+	}
+	return nil
+}
+
+// hostBindings returns the bindings to expose on the host. The expose
+// API only handles IPv4, so relayed [::1] bindings are exposed as
+// 127.0.0.1.
+func hostBindings(port nat.Port, bindings []nat.PortBinding) []nat.PortBinding {
+	out := make([]nat.PortBinding, 0, len(bindings))
+	for _, b := range bindings {
+		if b.HostIP == ipv6LoopbackIP {
+			log.Infof("/proc/net scanner: relaying %s listener on [::1]:%s as %s:%s",
+				port.Proto(), b.HostPort, loopbackIP, b.HostPort)
+			b.HostIP = loopbackIP
+		}
+		out = append(out, b)
+	}
 	return out
 }
 
@@ -23,18 +23,20 @@ import (
 )
 
 // fakeTracker records Add/Remove calls keyed by the containerID the
-// scanner builds from proto/port. addErr/removeErr, when non-nil, are
-// returned from the corresponding call so tests can drive failure
-// paths in publish.
+// scanner builds from proto/port, and the port maps passed to Add.
+// addErr/removeErr, when non-nil, are returned from the corresponding
+// call so tests can drive failure paths in publish.
 type fakeTracker struct {
 	added     []string
+	portMaps  []nat.PortMap
 	removed   []string
 	addErr    error
 	removeErr error
 }
 
-func (t *fakeTracker) Add(id string, _ nat.PortMap) error {
+func (t *fakeTracker) Add(id string, portMap nat.PortMap) error {
 	t.added = append(t.added, id)
+	t.portMaps = append(t.portMaps, portMap)
 	return t.addErr
 }
 
@@ -46,16 +48,19 @@ func (t *fakeTracker) Get(string) nat.PortMap { return nil }
 func (t *fakeTracker) RemoveAll() error       { return nil }
 
 // fakeForwarder records the proto/port pairs the scanner asks to bind
-// or release. addErr, when non-nil, is returned from Add so tests can
-// drive the forwarder-failure rollback path.
+// or release, and the targets it asks to connect to. addErr, when
+// non-nil, is returned from Add so tests can drive the
+// forwarder-failure rollback path.
 type fakeForwarder struct {
 	added   []string
+	targets []string
 	removed []string
 	addErr  error
 }
 
-func (f *fakeForwarder) Add(_ context.Context, proto string, port uint16) error {
+func (f *fakeForwarder) Add(_ context.Context, proto string, port uint16, target net.IP) error {
 	f.added = append(f.added, fmt.Sprintf("%s/%d", proto, port))
+	f.targets = append(f.targets, target.String())
 	return f.addErr
 }
 
@@ -678,6 +683,89 @@ func TestEndToEndUDPAndRemovalThroughTick(t *testing.T) {
 	}
 }
 
+// TestLoopbackBindingVariants runs 127.0.0.1, ::1 and 0.0.0.0
+// listeners through entriesToPortMap and Tick, with and without IPv6
+// loopback relaying, and checks what is exposed on the host and
+// which forwarder targets are opened.
+func TestLoopbackBindingVariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    procnettcp.Kind
+		ip      string
+		relay   bool
+		hostIP  string // exposed on the host; empty if not published
+		targets []string
+	}{
+		{name: "IPv4 loopback", kind: procnettcp.TCP, ip: "127.0.0.1", hostIP: "127.0.0.1", targets: []string{"127.0.0.1"}},
+		{name: "IPv4 loopback with relay", kind: procnettcp.TCP, ip: "127.0.0.1", relay: true, hostIP: "127.0.0.1", targets: []string{"127.0.0.1"}},
+		{name: "IPv6 loopback", kind: procnettcp.TCP6, ip: "::1"},
+		{name: "IPv6 loopback with relay", kind: procnettcp.TCP6, ip: "::1", relay: true, hostIP: "127.0.0.1", targets: []string{"::1"}},
+		{name: "wildcard", kind: procnettcp.TCP, ip: "0.0.0.0", hostIP: "0.0.0.0"},
+		{name: "wildcard with relay", kind: procnettcp.TCP, ip: "0.0.0.0", relay: true, hostIP: "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &fakeTracker{}
+			fwd := &fakeForwarder{}
+			s := newScanner(context.Background(), tr, fwd, net.ParseIP("192.168.127.2"), time.Second)
+			s.relayIPv6Loopback = tt.relay
+
+			entries := []procnettcp.Entry{
+				{Kind: tt.kind, IP: net.ParseIP(tt.ip), Port: 8009, State: procnettcp.TCPListen},
+			}
+			s.Tick(s.entriesToPortMap(entries))
+			s.Tick(s.entriesToPortMap(entries))
+
+			if tt.hostIP == "" {
+				if len(tr.added) != 0 || len(fwd.added) != 0 {
+					t.Fatalf("tracker.Add = %v, forwarder.Add = %v; want none", tr.added, fwd.added)
+				}
+				if !s.skipLogged[mustPort(t, "tcp", 8009)] {
+					t.Fatalf("skipped listener was not recorded as logged")
+				}
+				return
+			}
+			if len(tr.portMaps) != 1 {
+				t.Fatalf("tracker.Add = %v, want one call", tr.added)
+			}
+			want := nat.PortMap{mustPort(t, "tcp", 8009): []nat.PortBinding{{HostIP: tt.hostIP, HostPort: "8009"}}}
+			if fmt.Sprint(tr.portMaps[0]) != fmt.Sprint(want) {
+				t.Fatalf("tracker.Add port map = %v, want %v", tr.portMaps[0], want)
+			}
+			if !equalStringSlices(fwd.targets, tt.targets) {
+				t.Fatalf("forwarder targets = %v, want %v", fwd.targets, tt.targets)
+			}
+
+			// Removal closes the forwarder for relayed listeners too.
+			s.Tick(s.entriesToPortMap(nil))
+			if len(tr.removed) != 1 || len(fwd.removed) != len(tt.targets) {
+				t.Fatalf("tracker.Remove = %v, forwarder.Remove = %v after listener vanished", tr.removed, fwd.removed)
+			}
+		})
+	}
+}
+
+// TestIPv6LoopbackDefersToIPv4Listener checks that a port with both
+// 127.0.0.1 and ::1 listeners is forwarded through the IPv4 one.
+func TestIPv6LoopbackDefersToIPv4Listener(t *testing.T) {
+	s := newScanner(context.Background(), &fakeTracker{}, &fakeForwarder{}, nil, time.Second)
+	s.relayIPv6Loopback = true
+
+	out := s.entriesToPortMap([]procnettcp.Entry{
+		{Kind: procnettcp.TCP6, IP: net.IPv6loopback, Port: 8009, State: procnettcp.TCPListen},
+		{Kind: procnettcp.TCP, IP: net.ParseIP("127.0.0.1"), Port: 8009, State: procnettcp.TCPListen},
+		{Kind: procnettcp.UDP6, IP: net.IPv6loopback, Port: 8010, State: procnettcp.UDPEstablished},
+	})
+
+	want := nat.PortMap{
+		mustPort(t, "tcp", 8009): []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "8009"}},
+		mustPort(t, "udp", 8010): []nat.PortBinding{{HostIP: "::1", HostPort: "8010"}},
+	}
+	if fmt.Sprint(out) != fmt.Sprint(want) {
+		t.Fatalf("entriesToPortMap = %v, want %v", out, want)
+	}
+}
+
 func mustPort(t *testing.T, proto string, port int) nat.Port {
 	t.Helper()
 	p, err := nat.NewPort(proto, fmt.Sprint(port))
@@ -26,7 +26,7 @@ import (
 
 type ProcNetScanner struct{}
 
-func NewProcNetScanner(context.Context, tracker.Tracker, net.IP, time.Duration) (*ProcNetScanner, error) {
+func NewProcNetScanner(context.Context, tracker.Tracker, net.IP, time.Duration, bool) (*ProcNetScanner, error) {
 	panic("only implemented for Linux")
 }
 
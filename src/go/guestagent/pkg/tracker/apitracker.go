@@ -25,7 +25,7 @@ import (
 	"github.com/docker/go-connections/nat"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/forwarder"
-	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/logging"
 )
 
 const (
@@ -46,7 +46,7 @@ var (
 // the Rancher Desktop networking is enabled and the privileged service is disabled.
 type APITracker struct {
 	context           context.Context
-	wslProxyForwarder forwarder.Forwarder
+	wslProxyForwarder *forwarder.PortEventForwarder
 	isAdmin           bool
 	baseURL           string
 	tapInterfaceIP    string
@@ -57,8 +57,8 @@ type APITracker struct {
 // NewAPITracker creates a new instance of APITracker with the specified configuration.
 //   - ctx: The context to manage the lifecycle and cancellation of operations. It allows the APITracker
 //     to be aware of broader request timeouts or cancellation signals.
-//   - wslProxyForwarder: An interface or struct responsible for forwarding API calls to the Rancher Desktop's WSL proxy.
-//     It handles sending port mapping updates and removals from other WSL distros.
+//   - wslProxyForwarder: Responsible for forwarding port mapping updates and removals to the Rancher Desktop's
+//     WSL proxy, for use from other WSL distros.  Only changes to the forwarded ports are sent.
 //   - baseURL: The base URL of the API server that the APITracker will communicate with to expose or unexpose
 //     ports. This URL is used by the APIForwarder to construct API requests.
 //   - tapIfaceIP: The IP address of the tap interface that the API calls will use for port forwarding. This address
@@ -66,7 +66,7 @@ type APITracker struct {
 //   - isAdmin: Indicates whether the application is running with administrative privileges. This flag determines
 //     whether the APITracker should use the localhost IP address (127.0.0.1) for operations if not running as an
 //     administrator.
-func NewAPITracker(ctx context.Context, wslProxyForwarder forwarder.Forwarder, baseURL, tapIfaceIP string, isAdmin bool) *APITracker {
+func NewAPITracker(ctx context.Context, wslProxyForwarder *forwarder.PortEventForwarder, baseURL, tapIfaceIP string, isAdmin bool) *APITracker {
 	return &APITracker{
 		context:           ctx,
 		wslProxyForwarder: wslProxyForwarder,
@@ -122,12 +122,13 @@ func (a *APITracker) Add(containerID string, portMap nat.PortMap) error {
 
 	if len(successfullyForwarded) != 0 {
 		a.portStorage.add(containerID, successfullyForwarded)
-		portMapping := guestagentTypes.PortMapping{
-			Remove: false,
-			Ports:  successfullyForwarded,
-		}
-		log.Debugf("forwarding to wsl-proxy to add port mapping: %+v", portMapping)
-		err := a.wslProxyForwarder.Send(portMapping)
+		log.Infow("forwarded ports", log.Fields{
+			logging.FieldEvent:       logging.EventForward,
+			logging.FieldContainerID: containerID,
+			logging.FieldPorts:       logging.Ports(successfullyForwarded),
+		})
+		log.Debugf("forwarding to wsl-proxy to add port mapping: %+v", successfullyForwarded)
+		err := a.wslProxyForwarder.Set(containerID, successfullyForwarded)
 		if err != nil {
 			return fmt.Errorf("sending port mappings to wsl proxy error: %w", err)
 		}
@@ -179,12 +180,13 @@ func (a *APITracker) Remove(containerID string) error {
 	}
 
 	if len(portMap) != 0 {
-		portMapping := guestagentTypes.PortMapping{
-			Remove: true,
-			Ports:  portMap,
-		}
-		log.Debugf("forwarding to wsl-proxy to remove port mapping: %+v", portMapping)
-		err := a.wslProxyForwarder.Send(portMapping)
+		log.Infow("unforwarded ports", log.Fields{
+			logging.FieldEvent:       logging.EventUnforward,
+			logging.FieldContainerID: containerID,
+			logging.FieldPorts:       logging.Ports(portMap),
+		})
+		log.Debugf("forwarding to wsl-proxy to remove port mapping: %+v", portMap)
+		err := a.wslProxyForwarder.Remove(containerID)
 		if err != nil {
 			return fmt.Errorf("sending port mappings to wsl proxy error: %w", err)
 		}
@@ -200,9 +202,14 @@ func (a *APITracker) Remove(containerID string) error {
 // RemoveAll calls the /services/forwarder/unexpose
 // and removes all the port bindings from the tracker.
 func (a *APITracker) RemoveAll() error {
-	var apiErrs, wslProxyErrs []error
-
-	for _, portMapping := range a.portStorage.getAll() {
+	var apiErrs []error
+
+	for containerID, portMapping := range a.portStorage.getAll() {
+		log.Infow("unforwarded ports", log.Fields{
+			logging.FieldEvent:       logging.EventUnforward,
+			logging.FieldContainerID: containerID,
+			logging.FieldPorts:       logging.Ports(portMapping),
+		})
 		for _, portBindings := range portMapping {
 			for _, portBinding := range portBindings {
 				// The unexpose API only supports IPv4
@@ -225,28 +232,19 @@ func (a *APITracker) RemoveAll() error {
 				}
 			}
 		}
-
-		portMapping := guestagentTypes.PortMapping{
-			Remove: true,
-			Ports:  portMapping,
-		}
-
-		log.Debugf("forwarding to wsl-proxy to remove port mapping: %+v", portMapping)
-		wslProxyError := a.wslProxyForwarder.Send(portMapping)
-		if wslProxyError != nil {
-			wslProxyErrs = append(wslProxyErrs,
-				fmt.Errorf("sending port mappings to wsl proxy error: %w", wslProxyError))
-		}
 	}
 
 	a.portStorage.removeAll()
 
+	log.Debug("forwarding to wsl-proxy to remove all port mappings")
+	wslProxyErr := a.wslProxyForwarder.RemoveAll()
+
 	if len(apiErrs) != 0 {
 		return fmt.Errorf("%w: %+v", forwarder.ErrUnexposeAPI, apiErrs)
 	}
 
-	if len(wslProxyErrs) != 0 {
-		return fmt.Errorf("%w: %+v", ErrWSLProxy, wslProxyErrs)
+	if wslProxyErr != nil {
+		return fmt.Errorf("%w: sending port mappings to wsl proxy error: %w", ErrWSLProxy, wslProxyErr)
 	}
 
 	return nil
@@ -60,7 +60,7 @@ func TestBasicAdd(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 
 	protoPort, err := nat.NewPort(protocolTCP, hostPort)
 	require.NoError(t, err)
@@ -100,7 +100,7 @@ func TestAddOverride(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 
 	protoPort, err := nat.NewPort(protocolTCP, hostPort)
 	require.NoError(t, err)
@@ -205,7 +205,7 @@ func TestAddWithError(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 
 	protoPort, err := nat.NewPort(protocolTCP, hostPort)
 	require.NoError(t, err)
@@ -310,7 +310,7 @@ func TestGet(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 	err = apiTracker.Add(containerID, portMapping)
 	require.NoError(t, err)
 
@@ -338,7 +338,7 @@ func TestRemove(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 
 	protoPort, err := nat.NewPort(protocolTCP, hostPort)
 	require.NoError(t, err)
@@ -411,7 +411,7 @@ func TestRemoveWithError(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 
 	protoPort, err := nat.NewPort(protocolTCP, hostPort)
 	require.NoError(t, err)
@@ -481,7 +481,7 @@ func TestRemoveAll(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 
 	protoPort, err := nat.NewPort(protocolTCP, hostPort)
 	require.NoError(t, err)
@@ -552,7 +552,7 @@ func TestRemoveAllWithError(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, true)
 
 	protoPort, err := nat.NewPort(protocolTCP, hostPort)
 	require.NoError(t, err)
@@ -639,7 +639,7 @@ func TestNonAdminInstall(t *testing.T) {
 	testSrv := httptest.NewServer(mux)
 	defer testSrv.Close()
 
-	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, false)
+	apiTracker := tracker.NewAPITracker(context.Background(), forwarder.NewPortEventForwarder(context.Background(), &testForwarder{}), testSrv.URL, hostSwitchIP, false)
 
 	publishedPort := "1025"
 	protoPort, err := nat.NewPort(protocolTCP, publishedPort)
@@ -15,11 +15,18 @@ limitations under the License.
 // different packages.
 package types
 
-import "github.com/docker/go-connections/nat"
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/docker/go-connections/nat"
+)
 
 // PortMapping represents the mapping of ports and addresses to be communicated
 // over the network. It includes a flag (remove) on whether to add or remove port mappings
-// and specifies the backend addresses to connect to.
+// and specifies the backend addresses to connect to.  It may instead describe the complete
+// set of port mappings (resync).
 type PortMapping struct {
 	// Remove indicates whether the port mappings should be removed (true) or added (false)
 	Remove bool `json:"remove"`
@@ -30,6 +37,129 @@ type PortMapping struct {
 	// in terms of the network namespace the container engine is running in (i.e. the
 	// "Rancher Desktop" network namespace).
 	ConnectAddrs []ConnectAddrs `json:"connectAddrs"`
+	// Seq is the sequence number of the message; it increases by one for each
+	// message sent, so that a gap indicates a lost message.
+	Seq uint64 `json:"seq,omitempty"`
+	// Resync indicates that Ports holds the complete set of forwarded ports,
+	// replacing any earlier state, rather than a change to it.
+	Resync bool `json:"resync,omitempty"`
+	// WithdrawAll indicates that the sender is shutting down, and that all
+	// port mappings should be removed; Ports is ignored.  Receivers that
+	// support it reply with a PortMappingAck listing CapabilityWithdrawAll.
+	WithdrawAll bool `json:"withdrawAll,omitempty"`
+	// Ack indicates that the sender waits for a PortMappingAck once the
+	// message is applied.  Receivers listing CapabilityBindFailures report
+	// the ports they failed to listen on in it.
+	Ack bool `json:"ack,omitempty"`
+	// Ranges lists ranges of port bindings, in addition to those in Ports;
+	// each stands for the bindings of all the ports in it.  Senders only use
+	// it with receivers listing CapabilityPortRanges.
+	Ranges []PortRange `json:"ranges,omitempty"`
+	// Provisional indicates that the ports are added ahead of the guest
+	// agent, which owns them: receivers listing CapabilityProvisional stop
+	// listening on the new ones after a while, unless the guest agent adds
+	// them too.  This way the ports of a container that exits before the
+	// guest agent notices it are not forwarded forever.
+	Provisional bool `json:"provisional,omitempty"`
+}
+
+// PortRange is a contiguous range of port bindings with the same protocol and
+// host address, as published with `-p 30000-30100:30000-30100`: the container
+// port and the host port both go up by one from each binding to the next.
+type PortRange struct {
+	// Port is the first container port and protocol, e.g. "30000/tcp".
+	Port nat.Port `json:"port"`
+	// HostIP is the host address, and HostPort the first host port.
+	HostIP   string `json:"hostIP"`
+	HostPort string `json:"hostPort"`
+	// Count is the number of ports in the range.
+	Count int `json:"count"`
+}
+
+// Bindings returns the port bindings making up the range.
+func (r PortRange) Bindings() (nat.PortMap, error) {
+	proto, containerPort := nat.SplitProtoPort(string(r.Port))
+	containerStart, err := nat.ParsePort(containerPort)
+	if err != nil || containerStart == 0 {
+		return nil, fmt.Errorf("invalid container port in port range %s", r)
+	}
+	hostStart, err := nat.ParsePort(r.HostPort)
+	if err != nil || hostStart == 0 {
+		return nil, fmt.Errorf("invalid host port in port range %s", r)
+	}
+	if r.Count < 1 || containerStart+r.Count-1 > 65535 || hostStart+r.Count-1 > 65535 {
+		return nil, fmt.Errorf("invalid number of ports in port range %s", r)
+	}
+	portMap := make(nat.PortMap, r.Count)
+	for offset := range r.Count {
+		port := nat.Port(fmt.Sprintf("%d/%s", containerStart+offset, proto))
+		portMap[port] = []nat.PortBinding{{HostIP: r.HostIP, HostPort: strconv.Itoa(hostStart + offset)}}
+	}
+
+	return portMap, nil
+}
+
+// String returns the range in the form `docker ps` shows ranges in, e.g.
+// "0.0.0.0:30000-30100->30000-30100/tcp"; ranges that are not valid also show
+// the number of ports.
+func (r PortRange) String() string {
+	proto, containerPort := nat.SplitProtoPort(string(r.Port))
+	containerStart, containerErr := strconv.Atoi(containerPort)
+	hostStart, hostErr := strconv.Atoi(r.HostPort)
+	if r.Count == 1 {
+		return fmt.Sprintf("%s->%s", net.JoinHostPort(r.HostIP, r.HostPort), r.Port)
+	}
+	if containerErr != nil || hostErr != nil || r.Count < 1 {
+		return fmt.Sprintf("%s->%s (%d ports)", net.JoinHostPort(r.HostIP, r.HostPort), r.Port, r.Count)
+	}
+	host := net.JoinHostPort(r.HostIP, fmt.Sprintf("%d-%d", hostStart, hostStart+r.Count-1))
+
+	return fmt.Sprintf("%s->%d-%d/%s", host, containerStart, containerStart+r.Count-1, proto)
+}
+
+// CapabilityWithdrawAll is listed in a PortMappingAck by receivers that handle
+// PortMapping.WithdrawAll.
+const CapabilityWithdrawAll = "withdrawAll"
+
+// CapabilityBindFailures is listed in a PortMappingAck by receivers that
+// report the ports they failed to listen on in PortMappingAck.Failures.
+const CapabilityBindFailures = "bindFailures"
+
+// CapabilityPortRanges is listed in a PortMappingAck by receivers that handle
+// PortMapping.Ranges.  They reject ranges overlapping another range or port
+// binding of the same message, or a port forwarded to another container port,
+// and report each of their ports as a BindFailure.
+const CapabilityPortRanges = "portRanges"
+
+// CapabilityProvisional is listed in a PortMappingAck by receivers that handle
+// PortMapping.Provisional.
+const CapabilityProvisional = "provisional"
+
+// PortMappingAck is sent back over the same connection by a receiver that has
+// applied a PortMapping requiring acknowledgment.  Receivers that predate it
+// close the connection without replying, so that senders can fall back to
+// messages they understand.
+type PortMappingAck struct {
+	// Seq is the sequence number of the acknowledged message.
+	Seq uint64 `json:"seq"`
+	// Capabilities lists the optional parts of the protocol the receiver
+	// supports.
+	Capabilities []string `json:"capabilities"`
+	// Failures lists the port bindings of the acknowledged message that the
+	// receiver failed to listen on, typically because another process on
+	// the host already uses the port.
+	Failures []BindFailure `json:"failures,omitempty"`
+}
+
+// BindFailure describes a port binding the receiver failed to listen on.
+type BindFailure struct {
+	// Port is the container port and protocol, e.g. "80/tcp".
+	Port nat.Port `json:"port"`
+	// HostIP and HostPort are the host address that could not be listened on.
+	HostIP   string `json:"hostIP"`
+	HostPort string `json:"hostPort"`
+	// Error is the error listening failed with.
+	Error string `json:"error"`
 }
 
 // ConnectAddrs defines a network address used for the WSL interface inside
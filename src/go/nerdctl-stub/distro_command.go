@@ -0,0 +1,52 @@
+// This file contains the command line that runs nerdctl in the distribution.
+//
+// The arguments of nerdctl go through wsl.exe, which parses its command line
+// by its own rules before passing the command on, and through the scripts that
+// enter the namespace of the distribution. Rather than relying on each layer
+// to keep them as they are, the stub encodes every argument as base64, which
+// has no characters any of them treat specially, and a small shell script in
+// the distribution decodes them before running nerdctl. Every argument then
+// arrives exactly as the stub was given it, including empty arguments, and
+// ones with quotes, `%`, `$`, newlines or non-ASCII characters.
+
+package main
+
+import (
+	"encoding/base64"
+)
+
+// The prefix of every encoded argument, so that empty arguments are not
+// empty on the command line, where they may be dropped.
+const encodedArgPrefix = "x"
+
+// distroScript decodes its arguments, then runs the command they make up
+// after the first, which is the path of the status file. Once the command
+// exits, its exit status is written to the status file, unless the path is
+// empty. The trap keeps an interrupt, which reaches the shell too, from
+// killing it before the command exits; unlike ignoring the signal, it doesn't
+// stop the command from being interrupted. The command substitution is
+// followed by a dot, which is removed, so that trailing newlines are kept.
+// The script is on a single line, and is quoted on the command line by the
+// usual rules; it is the same every time, so a test checks that it arrives
+// intact.
+const distroScript = `set -- "$0" "$@"; ` +
+	`for arg do shift; value=$(printf %s "${arg#` + encodedArgPrefix + `}" | base64 -d && echo .) || exit 125; set -- "$@" "${value%.}"; done; ` +
+	`status_file=$1; shift; trap : INT; "$@"; status=$?; ` +
+	`if [ -n "$status_file" ]; then echo "$status" > "$status_file"; fi; exit "$status"`
+
+// encodeArg encodes an argument for distroScript.
+func encodeArg(arg string) string {
+	return encodedArgPrefix + base64.StdEncoding.EncodeToString([]byte(arg))
+}
+
+// distroCommand returns the command line that runs command in the
+// distribution, with its arguments as they are, and writes its exit status to
+// statusPath, a path in the distribution; the status is not written if
+// statusPath is empty.
+func distroCommand(statusPath string, command ...string) []string {
+	result := []string{"/bin/sh", "-c", distroScript, encodeArg(statusPath)}
+	for _, arg := range command {
+		result = append(result, encodeArg(arg))
+	}
+	return result
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistroCommandEncoding(t *testing.T) {
+	args := []string{"run", "-e", `MSG="hello world"`, "%PATH%", "$HOME", "héllo ✓", "", "a\nb", `C:\Users\`}
+	command := distroCommand("/mnt/c/Users/My Name/AppData/Local/Temp/nerdctl-status.1", args...)
+	if !assert.Len(t, command, 4+len(args)) {
+		return
+	}
+	assert.Equal(t, []string{"/bin/sh", "-c", distroScript}, command[:3])
+	assert.NotContains(t, distroScript, "\n", "the script should be on a single line")
+	// Whatever the arguments, what is on the command line can't be taken to
+	// mean anything else.
+	encoded := regexp.MustCompile(`^` + encodedArgPrefix + `[A-Za-z0-9+/=]*$`)
+	for _, arg := range command[3:] {
+		assert.Regexp(t, encoded, arg)
+	}
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDistroCommandArgs runs a command the way the stub runs nerdctl in the
+// distribution, and checks that it gets exactly the arguments it was given.
+func TestDistroCommandArgs(t *testing.T) {
+	testCases := [][]string{
+		{"plain"},
+		{"run", "-e", "MSG=hello world", "busybox"},
+		{"-e", `MSG="hello world"`},
+		{`it's`, `"`, `'`, `\`, `C:\Users\me\`, `\"quoted\"`},
+		{"%PATH%", "%", "100%"},
+		{"$HOME", "${HOME}", "$(id)", "`id`", "$"},
+		{"*", "?", "[a-z]", "~", "a;b", "a|b", "a&b", "a>b", "#comment"},
+		{"héllo", "✓", "日本語", "emoji 🐳"},
+		{""},
+		{"", "between", ""},
+		{"a\nb", "\n", "trailing\n", "\nleading", "crlf\r\n", "tab\there"},
+		{" ", "  padded  "},
+		{"-n", "-e", "--", "-"},
+		{"x", "xx", "=", "x="},
+	}
+	for _, args := range testCases {
+		t.Run(strings.Join(args, ","), func(t *testing.T) {
+			t.Parallel()
+			// The command prints each argument followed by a NUL, which
+			// can't be in an argument.
+			echo := []string{"/bin/sh", "-c", `for arg do printf '%s\0' "$arg"; done`, "echo"}
+			command := distroCommand("", append(echo, args...)...)
+			output, err := exec.Command(command[0], command[1:]...).Output()
+			if !assert.NoError(t, err) {
+				return
+			}
+			if assert.True(t, strings.HasSuffix(string(output), "\x00"), "output %q", output) {
+				assert.Equal(t, args, strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00"))
+			}
+		})
+	}
+	t.Run("no status file", func(t *testing.T) {
+		command := distroCommand("", "sh", "-c", "exit 3")
+		err := exec.Command(command[0], command[1:]...).Run()
+		assert.Equal(t, 3, exitCodeFromError(err))
+	})
+}
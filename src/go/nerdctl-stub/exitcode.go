@@ -0,0 +1,99 @@
+// This file contains the handling of the exit status of nerdctl.
+//
+// The stub exits with the status nerdctl exits with, so that scripts can rely
+// on it as they would on a native nerdctl. wsl.exe passes on the exit code of
+// the process it runs, but not how a process killed by a signal ended, so the
+// script nerdctl is run through in the distribution (see distroCommand) writes
+// its status to a file the stub can read; a shell reports a process killed by
+// a signal as 128 plus the signal number, which is what a native nerdctl run
+// from a shell would show.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// The exit code when nerdctl can't be run for an unknown reason.
+	exitCodeFailure = 1
+	// The exit code when nerdctl could not be run.
+	exitCodeCannotInvoke = 126
+	// The exit code when nerdctl could not be found.
+	exitCodeNotFound = 127
+	// The exit code of a process killed by a signal is this plus the signal
+	// number.
+	exitCodeSignalBase = 128
+)
+
+// readExitStatus reads the exit status from a status file written by
+// distroScript; ok is false if there is no valid status in the file, for
+// example because the script never ran.
+func readExitStatus(path string) (status int, ok bool) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	status, err = strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil || status < 0 || status > 255 {
+		return 0, false
+	}
+	return status, true
+}
+
+// exitCodeFromError returns the exit code for the error from running a
+// command: its exit code if it exited, 128 plus the signal number if a signal
+// killed it, and 126 or 127 if it could not be run at all, as shells do.
+func exitCodeFromError(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return exitCodeSignalBase + int(status.Signal())
+		}
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		return exitCodeFailure
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return exitCodeNotFound
+	case errors.Is(err, os.ErrPermission):
+		return exitCodeCannotInvoke
+	}
+	return exitCodeFailure
+}
+
+// commandExitStatus returns the exit status of a command run through
+// distroCommand, given the error from running it and the host path of the
+// status file. The error is nil if the command ran, whatever its exit status.
+func commandExitStatus(runErr error, statusPath string) (int, error) {
+	if status, ok := readExitStatus(statusPath); ok {
+		return status, nil
+	}
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return exitCodeFromError(runErr), fmt.Errorf("failed to run nerdctl: %w", runErr)
+	}
+	return exitCodeFromError(runErr), nil
+}
+
+// createStatusFile creates an empty status file in dir, and returns its path.
+func createStatusFile(dir string) (string, error) {
+	file, err := os.CreateTemp(dir, "nerdctl-status.*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create status file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("failed to create status file: %w", err)
+	}
+	return file.Name(), nil
+}
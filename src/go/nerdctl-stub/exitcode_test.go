@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadExitStatus(t *testing.T) {
+	testCases := []struct {
+		contents string
+		status   int
+		ok       bool
+	}{
+		{"0\n", 0, true},
+		{"1\n", 1, true},
+		{"125\n", 125, true},
+		{"130", 130, true},
+		{"", 0, false},
+		{"256\n", 0, false},
+		{"-1\n", 0, false},
+		{"nope\n", 0, false},
+	}
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("%q", testCase.contents), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "status")
+			assert.NoError(t, os.WriteFile(path, []byte(testCase.contents), 0o644))
+			status, ok := readExitStatus(path)
+			assert.Equal(t, testCase.ok, ok)
+			assert.Equal(t, testCase.status, status)
+		})
+	}
+	t.Run("missing file", func(t *testing.T) {
+		_, ok := readExitStatus(filepath.Join(t.TempDir(), "status"))
+		assert.False(t, ok)
+	})
+}
+
+func TestCommandExitStatus(t *testing.T) {
+	t.Run("prefers the status file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "status")
+		assert.NoError(t, os.WriteFile(path, []byte("137\n"), 0o644))
+		status, err := commandExitStatus(&exec.ExitError{}, path)
+		assert.NoError(t, err)
+		assert.Equal(t, 137, status)
+	})
+	t.Run("succeeds without a status file", func(t *testing.T) {
+		status, err := commandExitStatus(nil, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, status)
+	})
+	t.Run("reports failing to run", func(t *testing.T) {
+		status, err := commandExitStatus(fmt.Errorf("wrapped: %w", exec.ErrNotFound), "")
+		assert.ErrorIs(t, err, exec.ErrNotFound)
+		assert.Equal(t, exitCodeNotFound, status)
+	})
+	t.Run("reports failing to invoke", func(t *testing.T) {
+		status, err := commandExitStatus(os.ErrPermission, "")
+		assert.ErrorIs(t, err, os.ErrPermission)
+		assert.Equal(t, exitCodeCannotInvoke, status)
+	})
+	t.Run("reports other errors", func(t *testing.T) {
+		status, err := commandExitStatus(errExpected, "")
+		assert.ErrorIs(t, err, errExpected)
+		assert.Equal(t, exitCodeFailure, status)
+	})
+}
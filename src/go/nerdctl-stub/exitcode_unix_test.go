@@ -0,0 +1,64 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExitStatusRoundTrip runs commands the way the stub runs nerdctl in the
+// distribution, and checks that their exit status is the one the stub sees.
+func TestExitStatusRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name     string
+		command  []string
+		expected int
+	}{
+		{"success", []string{"true"}, 0},
+		{"failure", []string{"false"}, 1},
+		{"given code", []string{"sh", "-c", "exit 3"}, 3},
+		{"daemon error", []string{"sh", "-c", "exit 125"}, 125},
+		{"cannot invoke", []string{"sh", "-c", "exit 126"}, 126},
+		{"not found", []string{"/nonexistent/nerdctl"}, 127},
+		{"interrupted", []string{"sh", "-c", "kill -INT $$"}, 130},
+		{"killed", []string{"sh", "-c", "kill -KILL $$"}, 137},
+		{"terminated", []string{"sh", "-c", "kill -TERM $$"}, 143},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			statusPath, err := createStatusFile(t.TempDir())
+			if !assert.NoError(t, err) {
+				return
+			}
+			command := distroCommand(statusPath, testCase.command...)
+			runErr := exec.Command(command[0], command[1:]...).Run()
+			status, ok := readExitStatus(statusPath)
+			assert.True(t, ok, "the status file should have been written")
+			assert.Equal(t, testCase.expected, status)
+			// Without the status file, the exit code of the shell is used.
+			status, err = commandExitStatus(runErr, filepath.Join(t.TempDir(), "missing"))
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, status)
+		})
+	}
+}
+
+func TestExitCodeFromError(t *testing.T) {
+	t.Run("signals", func(t *testing.T) {
+		err := exec.Command("sh", "-c", "kill -TERM $$").Run()
+		assert.Equal(t, exitCodeSignalBase+15, exitCodeFromError(err))
+	})
+	t.Run("not found", func(t *testing.T) {
+		err := exec.Command("/nonexistent/nerdctl").Run()
+		assert.Equal(t, exitCodeNotFound, exitCodeFromError(err))
+	})
+	t.Run("cannot invoke", func(t *testing.T) {
+		err := exec.Command(t.TempDir()).Run()
+		assert.Equal(t, exitCodeCannotInvoke, exitCodeFromError(err))
+	})
+}
@@ -17,15 +17,23 @@ type spawnOptions struct {
 	args *parsedArgs
 }
 
+// wslDistro returns the name of the WSL distribution for rancher-desktop.
+func wslDistro() string {
+	if distro := os.Getenv("RD_WSL_DISTRO"); distro != "" {
+		return distro
+	}
+	return "rancher-desktop"
+}
+
 func main() {
-	err := func() (err error) {
+	if len(os.Args) > 1 && os.Args[1] == preregisterPortsCommand {
+		os.Exit(runPreregisterPorts(os.Args[2:]))
+	}
+	exitCode, err := func() (exitCode int, err error) {
 		opts := spawnOptions{
-			distro:  os.Getenv("RD_WSL_DISTRO"),
+			distro:  wslDistro(),
 			nerdctl: os.Getenv("RD_NERDCTL"),
 		}
-		if opts.distro == "" {
-			opts.distro = "rancher-desktop"
-		}
 		if opts.nerdctl == "" {
 			opts.nerdctl = "/usr/local/bin/nerdctl"
 		}
@@ -41,17 +49,24 @@ func main() {
 		}
 
 		defer func() {
-			err = cleanupParseArgs()
+			cleanupErr := cleanupParseArgs()
+			if cleanupErr == nil {
+				return
+			}
+			if err != nil || exitCode != 0 {
+				// Keep the status of nerdctl.
+				log.Printf("Error cleaning up: %s", cleanupErr)
+				return
+			}
+			exitCode, err = exitCodeFailure, cleanupErr
 			// The top-level function handles the error
 		}()
 
-		err = spawn(context.Background(), opts)
-		if err != nil {
-			return err
-		}
-		return nil
+		return spawn(context.Background(), opts)
 	}()
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
 	}
+	// Exit with the status of nerdctl, once everything is cleaned up.
+	os.Exit(exitCode)
 }
@@ -6,7 +6,6 @@ import (
 	"encoding/csv"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -24,28 +23,36 @@ const (
 	mountPointField = 4
 )
 
-func spawn(ctx context.Context, opts spawnOptions) error {
-	args := []string{"--distribution", opts.distro, "--exec", opts.nerdctl, "--address", opts.containerdSocket}
-	args = append(args, opts.args.args...)
+func spawn(ctx context.Context, opts spawnOptions) (int, error) {
+	args := []string{"--distribution", opts.distro, "--exec"}
+	command := append([]string{opts.nerdctl, "--address", opts.containerdSocket}, opts.args.args...)
+	// Have the exit status of nerdctl written to a file in the work
+	// directory, which is shared with the rancher-desktop distribution;
+	// without one, rely on the exit code of wsl.exe.
+	statusPath := ""
+	if workdir != "" {
+		if path, err := createStatusFile(workdir); err == nil {
+			statusPath = path
+			defer os.Remove(statusPath)
+		} else {
+			log.Printf("Error preparing to get the exit status of nerdctl: %s", err)
+		}
+	}
+	command = distroCommand(statusPath, command...)
+	args = append(args, command...)
 	cmd := exec.CommandContext(ctx, "wsl.exe", args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	release := holdInterrupts(nil)
+	runErr := cmd.Run()
+	release()
 	for _, cleanup := range opts.args.cleanup {
 		if cleanupErr := cleanup(); cleanupErr != nil {
 			log.Printf("Error cleaning up: %s", cleanupErr)
 		}
 	}
-	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			os.Exit(exitErr.ExitCode())
-		} else {
-			return err
-		}
-	}
-	return nil
+	return commandExitStatus(runErr, statusPath)
 }
 
 var workdir string
@@ -195,44 +202,25 @@ func filePathArgHandler(arg string) (string, []cleanupFunc, error) {
 // outputPathArgHandler handles arguments that take a file path to indicate
 // where some file should be output.
 func outputPathArgHandler(arg string) (string, []cleanupFunc, error) {
-	file, err := os.CreateTemp(workdir, "output.*")
-	if err != nil {
-		return "", nil, err
-	}
-	err = file.Close()
-	if err != nil {
-		return "", nil, err
-	}
-	// Some arguments error out if the file exists already.
-	err = os.Remove(file.Name())
-	if err != nil {
-		return "", nil, err
-	}
-	callback := func() error {
-		defer os.Remove(file.Name())
-		input, err := os.Open(file.Name())
-		if err != nil {
-			return err
-		}
-		defer input.Close()
-		output, err := os.Create(arg)
-		if err != nil {
-			return err
-		}
-		defer output.Close()
-		_, err = io.Copy(output, input)
-		if err != nil {
-			return err
-		}
-		// Since the executable is setuid, we need to make sure the normal
-		// user owns the output file.
-		err = os.Chown(arg, os.Getuid(), os.Getgid())
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	return file.Name(), []cleanupFunc{callback}, nil
+	return redirectOutput(arg, false, workdir, keepPath, chownToUser)
+}
+
+// outputDirArgHandler handles arguments that take the path of a directory
+// that some files should be output to.
+func outputDirArgHandler(arg string) (string, []cleanupFunc, error) {
+	return redirectOutput(arg, true, workdir, keepPath, chownToUser)
+}
+
+// keepPath returns the path of a file in workdir as it is, as it is shared
+// with the rancher-desktop distribution.
+func keepPath(p string) (string, error) {
+	return p, nil
+}
+
+// chownToUser makes the user running the stub own an output file; since the
+// executable is setuid, it would be owned by root otherwise.
+func chownToUser(p string) error {
+	return os.Lchown(p, os.Getuid(), os.Getgid())
 }
 
 // builderCacheArgHandler handles arguments for
@@ -241,6 +229,12 @@ func builderCacheArgHandler(arg string) (string, []cleanupFunc, error) {
 	return builderCacheProcessor(arg, filePathArgHandler, outputPathArgHandler)
 }
 
+// builderOutputArgHandler handles arguments for
+// `nerdctl builder build --output=`.
+func builderOutputArgHandler(arg string) (string, []cleanupFunc, error) {
+	return builderOutputProcessor(arg, outputPathArgHandler, outputDirArgHandler)
+}
+
 // buildContextArgHandler handles arguments for
 // `nerdctl builder build --build-context=`.
 func buildContextArgHandler(arg string) (string, []cleanupFunc, error) {
@@ -290,10 +284,11 @@ func buildContextArgHandler(arg string) (string, []cleanupFunc, error) {
 
 // argHandlers is the table of argument handlers.
 var argHandlers = argHandlersType{
-	volumeArgHandler:       volumeArgHandler,
-	filePathArgHandler:     filePathArgHandler,
-	outputPathArgHandler:   outputPathArgHandler,
-	mountArgHandler:        mountArgHandler,
-	builderCacheArgHandler: builderCacheArgHandler,
-	buildContextArgHandler: buildContextArgHandler,
+	volumeArgHandler:        volumeArgHandler,
+	filePathArgHandler:      filePathArgHandler,
+	outputPathArgHandler:    outputPathArgHandler,
+	mountArgHandler:         mountArgHandler,
+	builderCacheArgHandler:  builderCacheArgHandler,
+	builderOutputArgHandler: builderOutputArgHandler,
+	buildContextArgHandler:  buildContextArgHandler,
 }
@@ -3,6 +3,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
 	"fmt"
 	"strings"
 
@@ -22,44 +24,50 @@ func runCleanups(cleanups []cleanupFunc) error {
 }
 
 // mountArgProcessor implements the details for handling the argument for
-// `nerdctl run --mount=...`
+// `nerdctl run --mount=...`. The argument is a CSV record of key=value fields
+// (or bare flags, such as `readonly`), so that values containing commas can
+// be quoted; the source of bind mounts is passed through mounter.
 func mountArgProcessor(arg string, mounter func(string) (string, error)) (string, []cleanupFunc, error) {
-	var chunks [][]string
+	reader := csv.NewReader(strings.NewReader(arg))
+	fields, err := reader.Read()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse mount %q: %w", arg, err)
+	}
 	isBind := false
-	for _, chunk := range strings.Split(arg, ",") {
-		parts := strings.SplitN(chunk, "=", 2)
-		if len(parts) != 2 {
-			// Got something with no value, e.g. --mount=...,readonly,...
-			chunks = append(chunks, []string{chunk})
-			continue
-		}
-		if parts[0] == "type" && parts[1] == "bind" {
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		if strings.EqualFold(key, "type") && strings.EqualFold(value, "bind") {
 			isBind = true
 		}
-		chunks = append(chunks, parts)
 	}
 	if !isBind {
 		// Not a bind mount; don't attempt to fix anything
 		return arg, nil, nil
 	}
-	for _, chunk := range chunks {
-		if len(chunk) != 2 {
+	for i, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
 			continue
 		}
-		if chunk[0] != "source" && chunk[0] != "src" {
+		if !strings.EqualFold(key, "source") && !strings.EqualFold(key, "src") {
 			continue
 		}
-		mountDir, err := mounter(chunk[1])
+		mountDir, err := mounter(value)
 		if err != nil {
 			return "", nil, err
 		}
-		chunk[1] = mountDir
+		fields[i] = key + "=" + mountDir
+	}
+	var result bytes.Buffer
+	writer := csv.NewWriter(&result)
+	if err := writer.Write(fields); err != nil {
+		return "", nil, err
 	}
-	result := ""
-	for _, chunk := range chunks {
-		result = fmt.Sprintf("%s,%s", result, strings.Join(chunk, "="))
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", nil, err
 	}
-	return result[1:], nil, nil // Skip the initial "," we added
+	return strings.TrimSuffix(result.String(), "\n"), nil, nil
 }
 
 // builderCacheProcessor implements the details for handling the argument for
@@ -13,14 +13,15 @@ func unhandledArgHandler(arg string) (string, []cleanupFunc, error) {
 
 // argHandlers is the table of argument handlers.
 var argHandlers = argHandlersType{
-	volumeArgHandler:       unhandledArgHandler,
-	filePathArgHandler:     unhandledArgHandler,
-	outputPathArgHandler:   unhandledArgHandler,
-	mountArgHandler:        unhandledArgHandler,
-	builderCacheArgHandler: unhandledArgHandler,
+	volumeArgHandler:        unhandledArgHandler,
+	filePathArgHandler:      unhandledArgHandler,
+	outputPathArgHandler:    unhandledArgHandler,
+	mountArgHandler:         unhandledArgHandler,
+	builderCacheArgHandler:  unhandledArgHandler,
+	builderOutputArgHandler: unhandledArgHandler,
 }
 
-func spawn(ctx context.Context, opts spawnOptions) error {
+func spawn(ctx context.Context, opts spawnOptions) (int, error) {
 	panic("Platform is unsupported")
 }
 
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -11,31 +13,100 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+
+	"golang.org/x/sys/windows"
 )
 
-func spawn(ctx context.Context, opts spawnOptions) error {
-	args := []string{"--distribution", opts.distro, "--exec", "/usr/local/bin/wsl-exec", opts.nerdctl, "--address", opts.containerdSocket}
-	args = append(args, opts.args.args...)
+// windowsConsoleAPI is the console API of Windows.
+var windowsConsoleAPI = consoleModeAPI{
+	get: func(handle uintptr) (uint32, error) {
+		var mode uint32
+		err := windows.GetConsoleMode(windows.Handle(handle), &mode)
+		return mode, err
+	},
+	set: func(handle uintptr, mode uint32) error {
+		return windows.SetConsoleMode(windows.Handle(handle), mode)
+	},
+}
+
+func spawn(ctx context.Context, opts spawnOptions) (int, error) {
+	args := []string{"--distribution", opts.distro}
+	// Run nerdctl in the current directory, so that the files it looks for
+	// there (such as compose.yaml and .env), and relative paths in compose
+	// files, are found as they would be by a native nerdctl. If the directory
+	// can't be reached from WSL, wsl.exe picks one as usual.
+	if workDir, err := pathToWSL("."); err == nil {
+		args = append(args, "--cd", workDir)
+	}
+	args = append(args, "--exec", "/usr/local/bin/wsl-exec")
+	command := append([]string{opts.nerdctl, "--address", opts.containerdSocket}, opts.args.args...)
+	// Have the exit status of nerdctl written to a file in the temporary
+	// directory, if WSL can reach it; otherwise rely on the exit code of
+	// wsl.exe.
+	statusPath, wslStatusPath := "", ""
+	if path, err := createStatusFile(os.TempDir()); err == nil {
+		statusPath = path
+		defer os.Remove(statusPath)
+		if wslPath, err := pathToWSL(statusPath); err == nil {
+			wslStatusPath = wslPath
+		}
+	} else {
+		log.Printf("Error preparing to get the exit status of nerdctl: %s", err)
+	}
+	command = distroCommand(wslStatusPath, command...)
+	args = append(args, command...)
 	cmd := exec.CommandContext(ctx, "wsl.exe", args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	// wsl.exe puts the console in raw mode while nerdctl runs; make sure it
+	// is put back even if wsl.exe exits without doing so.
+	modes := saveConsoleModes(windowsConsoleAPI, os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd())
+	release := holdInterrupts(nil)
+	runErr := cmd.Run()
+	release()
+	if restoreErr := modes.restore(); restoreErr != nil {
+		log.Printf("Error restoring console mode: %s", restoreErr)
+	}
 	for _, handler := range opts.args.cleanup {
 		cleanupErr := handler()
 		if cleanupErr != nil {
 			log.Printf("Error cleaning up: %s", cleanupErr)
 		}
 	}
-	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			os.Exit(exitErr.ExitCode())
-		} else {
-			return err
+	exitCode, err := commandExitStatus(runErr, statusPath)
+	if err == nil && exitCode == 0 && len(publishedPorts) > 0 && isDetached(opts.args.args) {
+		// The container is running; forwarding its ports is best effort, as
+		// the guest agent forwards them shortly anyway.
+		if err := preregisterPorts(ctx, opts); err != nil {
+			log.Printf("Error forwarding published ports: %s", err)
 		}
 	}
-	return nil
+	return exitCode, err
+}
+
+// preregisterPorts tells the WSL proxy about the ports published by the
+// container just started, by running the Linux stub in the distribution.
+func preregisterPorts(ctx context.Context, opts spawnOptions) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// The Windows stub is in resources/win32/bin, the Linux one in
+	// resources/linux/bin.
+	stub, err := pathToWSL(filepath.Join(filepath.Dir(executable), "..", "..", "linux", "bin", "nerdctl-stub"))
+	if err != nil {
+		return err
+	}
+	mapping, err := json.Marshal(newPortMapping(publishedPorts))
+	if err != nil {
+		return err
+	}
+	args := []string{"--distribution", opts.distro, "--exec", "/usr/local/bin/wsl-exec"}
+	args = append(args, distroCommand("", stub, preregisterPortsCommand, string(mapping))...)
+	cmd := exec.CommandContext(ctx, "wsl.exe", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
 }
 
 // function prepareParseArgs should be called before argument parsing to set up
@@ -54,53 +125,37 @@ func cleanupParseArgs() error {
 
 // pathToWSL converts a Windows path to one that can be used in WSL.
 func pathToWSL(arg string) (string, error) {
-	// absPath is something like C:\Foo\Bar\Baz
-	absPath, err := filepath.Abs(filepath.FromSlash(arg))
+	cwd, err := os.Getwd()
 	if err != nil {
 		return "", err
 	}
-	slashPath := filepath.ToSlash(absPath)
-	vol := filepath.VolumeName(absPath)
-	if vol != "" && vol[len(vol)-1] == ':' {
-		volName := strings.ToLower(vol[:len(vol)-1])
-		return "/mnt/" + volName + slashPath[len(vol):], nil
+	return windowsPathToWSL(arg, cwd, wslDistro())
+}
+
+// hostPathToWSL converts the host path of a bind mount to one that can be
+// used in WSL, leaving Linux paths as they are.
+func hostPathToWSL(arg string) (string, error) {
+	if isLinuxPath(arg) {
+		return arg, nil
 	}
-	// volume name is not what we expected
-	return slashPath, nil
+	return pathToWSL(arg)
 }
 
 // volumeArgHandler handles the argument for `nerdctl run --volume=...`
 func volumeArgHandler(arg string) (string, []cleanupFunc, error) {
-	// Valid arguments are:
-	// <host path>:<container path>
-	// <host path>:<container path>:rw
-	// <host path>:<container path>:ro
 	// Because we only have Linux containers, and this is for Windows, we don't
 	// need to worry about just `<path>` (where the host and container have the
 	// same path).
-	cleanArg := arg
-	readWrite := ""
-	if strings.HasSuffix(arg, ":ro") || strings.HasSuffix(arg, ":rw") {
-		readWrite = arg[len(arg)-3:]
-		cleanArg = arg[:len(arg)-3]
-	}
-	// For now, assume the container path doesn't contain colons.
-	colonIndex := strings.LastIndex(cleanArg, ":")
-	if colonIndex < 0 {
-		return "", nil, fmt.Errorf("invalid volume mount: %s does not contain : separator", arg)
-	}
-	hostPath := cleanArg[:colonIndex]
-	containerPath := cleanArg[colonIndex+1:]
-	wslHostPath, err := pathToWSL(hostPath)
+	result, err := volumeArgProcessor(arg, hostPathToWSL)
 	if err != nil {
-		return "", nil, fmt.Errorf("could not get volume host path for %s: %w", arg, err)
+		return "", nil, err
 	}
-	return wslHostPath + ":" + containerPath + readWrite, nil, nil
+	return result, nil, nil
 }
 
 // mountArgHandler handles the argument for `nerdctl run --mount=...`
 func mountArgHandler(arg string) (string, []cleanupFunc, error) {
-	return mountArgProcessor(arg, pathToWSL)
+	return mountArgProcessor(arg, hostPathToWSL)
 }
 
 // filePathArgHandler handles arguments that take a file path for input
@@ -115,11 +170,47 @@ func filePathArgHandler(arg string) (string, []cleanupFunc, error) {
 // outputPathArgHandler handles arguments that take a file path to indicate
 // where some file should be output.
 func outputPathArgHandler(arg string) (string, []cleanupFunc, error) {
+	return outputArgProcessor(arg, false)
+}
+
+// outputDirArgHandler handles arguments that take the path of a directory
+// that some files should be output to.
+func outputDirArgHandler(arg string) (string, []cleanupFunc, error) {
+	return outputArgProcessor(arg, true)
+}
+
+// outputArgProcessor implements the details of outputPathArgHandler and
+// outputDirArgHandler. nerdctl writes directly to paths on drives mounted in
+// WSL; for those it can't reach (network shares and drives, which WSL doesn't
+// mount, and other distributions), it writes to the temporary directory, and
+// the result is copied to the path once it exits.
+func outputArgProcessor(arg string, isDir bool) (string, []cleanupFunc, error) {
 	result, err := pathToWSL(arg)
-	if err != nil {
+	if err == nil && !isNetworkDrive(arg) {
+		return result, nil, nil
+	}
+	if err != nil && !errors.Is(err, errUnreachablePath) {
 		return "", nil, err
 	}
-	return result, nil, nil
+	return redirectOutput(arg, isDir, os.TempDir(), pathToWSL, nil)
+}
+
+// isNetworkDrive reports whether a path is on a drive letter mapped to a
+// network share.
+func isNetworkDrive(arg string) bool {
+	absPath, err := filepath.Abs(arg)
+	if err != nil {
+		return false
+	}
+	volume := filepath.VolumeName(absPath)
+	if !hasDriveLetter(volume) {
+		return false
+	}
+	root, err := windows.UTF16PtrFromString(volume + `\`)
+	if err != nil {
+		return false
+	}
+	return windows.GetDriveType(root) == windows.DRIVE_REMOTE
 }
 
 // builderCacheArgHandler handles arguments for
@@ -128,6 +219,12 @@ func builderCacheArgHandler(arg string) (string, []cleanupFunc, error) {
 	return builderCacheProcessor(arg, filePathArgHandler, outputPathArgHandler)
 }
 
+// builderOutputArgHandler handles arguments for
+// `nerdctl builder build --output=`.
+func builderOutputArgHandler(arg string) (string, []cleanupFunc, error) {
+	return builderOutputProcessor(arg, outputPathArgHandler, outputDirArgHandler)
+}
+
 // buildContextArgHandler handles arguments for
 // `nerdctl builder build --build-context=`.
 func buildContextArgHandler(arg string) (string, []cleanupFunc, error) {
@@ -172,10 +269,11 @@ func buildContextArgHandler(arg string) (string, []cleanupFunc, error) {
 
 // argHandlers is the table of argument handlers.
 var argHandlers = argHandlersType{
-	volumeArgHandler:       volumeArgHandler,
-	filePathArgHandler:     filePathArgHandler,
-	outputPathArgHandler:   outputPathArgHandler,
-	mountArgHandler:        mountArgHandler,
-	builderCacheArgHandler: builderCacheArgHandler,
-	buildContextArgHandler: buildContextArgHandler,
+	volumeArgHandler:        volumeArgHandler,
+	filePathArgHandler:      filePathArgHandler,
+	outputPathArgHandler:    outputPathArgHandler,
+	mountArgHandler:         mountArgHandler,
+	builderCacheArgHandler:  builderCacheArgHandler,
+	builderOutputArgHandler: builderOutputArgHandler,
+	buildContextArgHandler:  buildContextArgHandler,
 }
@@ -0,0 +1,201 @@
+// This file contains the handling of options naming the files and directories
+// nerdctl writes, such as `nerdctl save --output` and
+// `nerdctl build --output type=local,dest=...`.
+//
+// Where nerdctl can reach the destination, it is given the path of the
+// destination itself, and writes there directly. Otherwise (such as for
+// network shares on Windows, or paths in another distribution), it writes to
+// a temporary file or directory that both sides can reach, which the stub
+// copies to the destination once nerdctl exits, then removes.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// builderOutputProcessor implements the details for handling the argument for
+// `nerdctl builder build --output=...`. The argument is either the path of a
+// directory to write the result to, or a CSV record of key=value fields in
+// the syntax of buildctl, as in `type=local,dest=out`: exporters of type
+// local write a directory to dest, and those of types tar, docker and oci
+// write a file, unless dest is `-` (standard output). Other exporters, such as
+// image, don't write any files. The paths are passed through fileMounter and
+// dirMounter respectively.
+func builderOutputProcessor(arg string, fileMounter, dirMounter argHandler) (string, []cleanupFunc, error) {
+	// Like nerdctl, take an argument without a type as a directory.
+	if !strings.Contains(arg, "type=") {
+		if arg == "-" {
+			return arg, nil, nil
+		}
+		return dirMounter(arg)
+	}
+	fields, err := csv.NewReader(strings.NewReader(arg)).Read()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse output %q: %w", arg, err)
+	}
+	var mounter argHandler
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		if !strings.EqualFold(key, "type") {
+			continue
+		}
+		switch strings.ToLower(value) {
+		case "local":
+			mounter = dirMounter
+		case "tar", "docker", "oci":
+			mounter = fileMounter
+		}
+	}
+	if mounter == nil {
+		return arg, nil, nil
+	}
+	var cleanups []cleanupFunc
+	for i, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || !strings.EqualFold(key, "dest") || value == "-" {
+			continue
+		}
+		result, newCleanups, err := mounter(value)
+		cleanups = append(cleanups, newCleanups...)
+		if err != nil {
+			_ = runCleanups(cleanups)
+			return "", nil, err
+		}
+		fields[i] = key + "=" + result
+	}
+	var result bytes.Buffer
+	writer := csv.NewWriter(&result)
+	if err := writer.Write(fields); err != nil {
+		_ = runCleanups(cleanups)
+		return "", nil, err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		_ = runCleanups(cleanups)
+		return "", nil, err
+	}
+	return strings.TrimSuffix(result.String(), "\n"), cleanups, nil
+}
+
+// redirectOutput handles an option naming a file (or a directory, if isDir is
+// set) that nerdctl writes to, where nerdctl can't reach dest. It returns the
+// path of a temporary file in tempDir, translated with toPath, for nerdctl to
+// write to instead; the cleanup copies what nerdctl wrote to dest with
+// copyOutput, and removes the temporary file. If nerdctl wrote nothing, dest
+// is left as it is.
+func redirectOutput(dest string, isDir bool, tempDir string, toPath func(string) (string, error), written func(string) error) (string, []cleanupFunc, error) {
+	var tempPath string
+	if isDir {
+		dir, err := os.MkdirTemp(tempDir, "output.*")
+		if err != nil {
+			return "", nil, err
+		}
+		tempPath = dir
+	} else {
+		file, err := os.CreateTemp(tempDir, "output.*")
+		if err != nil {
+			return "", nil, err
+		}
+		tempPath = file.Name()
+		if err := file.Close(); err != nil {
+			return "", nil, err
+		}
+		// Some arguments error out if the file exists already.
+		if err := os.Remove(tempPath); err != nil {
+			return "", nil, err
+		}
+	}
+	cleanup := func() error {
+		defer os.RemoveAll(tempPath)
+		if isDir {
+			if entries, err := os.ReadDir(tempPath); err == nil && len(entries) == 0 {
+				// nerdctl failed before writing anything, and has said why.
+				return nil
+			}
+		} else if _, err := os.Lstat(tempPath); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return copyOutput(tempPath, dest, written)
+	}
+	result, err := toPath(tempPath)
+	if err != nil {
+		_ = cleanup()
+		return "", nil, err
+	}
+	return result, []cleanupFunc{cleanup}, nil
+}
+
+// copyOutput copies the file or directory tree at source to dest, replacing
+// the files that are there already, and calls written (if given) with the path
+// of each file written and directory created. Only files, directories and
+// symbolic links are copied.
+func copyOutput(source, dest string, written func(string) error) error {
+	return filepath.WalkDir(source, func(sourcePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, sourcePath)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dest, rel)
+		switch {
+		case entry.IsDir():
+			if _, err := os.Lstat(destPath); err == nil {
+				// Leave directories that exist already as they are.
+				return nil
+			}
+			if err := os.MkdirAll(destPath, 0o755); err != nil {
+				return err
+			}
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(sourcePath)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(destPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if err := os.Symlink(target, destPath); err != nil {
+				return err
+			}
+		case entry.Type().IsRegular():
+			if err := copyOutputFile(sourcePath, destPath); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		if written != nil {
+			return written(destPath)
+		}
+		return nil
+	})
+}
+
+// copyOutputFile copies the regular file source to dest.
+func copyOutputFile(source, dest string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
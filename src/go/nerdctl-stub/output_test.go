@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderOutputProcessor(t *testing.T) {
+	t.Parallel()
+	fileMounter := func(s string) (string, []cleanupFunc, error) {
+		return "file:" + s, nil, nil
+	}
+	dirMounter := func(s string) (string, []cleanupFunc, error) {
+		return "dir:" + s, nil, nil
+	}
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: `C:\out`, expected: `dir:C:\out`},
+		{input: "-", expected: "-"},
+		{input: `type=local,dest=C:\out`, expected: `type=local,dest=dir:C:\out`},
+		{input: `type=tar,dest=image.tar`, expected: `type=tar,dest=file:image.tar`},
+		{input: `dest=image.tar,type=OCI`, expected: `dest=file:image.tar,type=OCI`},
+		{input: `type=docker,name=app,dest=app.tar`, expected: `type=docker,name=app,dest=file:app.tar`},
+		{input: `type=docker,dest=-`, expected: `type=docker,dest=-`},
+		{input: `type=docker,name=app`, expected: `type=docker,name=app`},
+		{input: `type=image,name=example.com/app,push=true`, expected: `type=image,name=example.com/app,push=true`},
+		{input: `type=local,"dest=C:\My Files\a,b"`, expected: `type=local,"dest=dir:C:\My Files\a,b"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			t.Parallel()
+			result, cleanups, err := builderOutputProcessor(tc.input, fileMounter, dirMounter)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, result)
+				assert.Empty(t, cleanups)
+			}
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, _, err := builderOutputProcessor(`type=local,dest="out`, fileMounter, dirMounter)
+		assert.Error(t, err)
+	})
+	t.Run("runs cleanups on errors", func(t *testing.T) {
+		t.Parallel()
+		cleanupDone := false
+		_, cleanups, err := builderOutputProcessor("type=tar,dest=a.tar,dest=b.tar",
+			func(s string) (string, []cleanupFunc, error) {
+				if s == "b.tar" {
+					return "", nil, fmt.Errorf("some handler error")
+				}
+				return s, []cleanupFunc{func() error {
+					cleanupDone = true
+					return nil
+				}}, nil
+			}, dirMounter)
+		assert.Error(t, err)
+		assert.Empty(t, cleanups)
+		assert.True(t, cleanupDone, "cleanup function did not run")
+	})
+}
+
+// TestRedirectOutput checks that output nerdctl writes to a temporary file
+// ends up at the destination it couldn't reach.
+func TestRedirectOutput(t *testing.T) {
+	t.Parallel()
+	// The temporary directory is seen by nerdctl under another path.
+	const prefix = "/mnt/x"
+	toPath := func(p string) (string, error) {
+		return prefix + filepath.ToSlash(p), nil
+	}
+	// nerdctlPath returns the local path of a path given to nerdctl.
+	nerdctlPath := func(t *testing.T, p string) string {
+		require.True(t, strings.HasPrefix(p, prefix), "path %q was not translated", p)
+		return filepath.FromSlash(strings.TrimPrefix(p, prefix))
+	}
+	t.Run("save", func(t *testing.T) {
+		t.Parallel()
+		tempDir, dest := t.TempDir(), filepath.Join(t.TempDir(), "image.tar")
+		result, cleanups, err := redirectOutput(dest, false, tempDir, toPath, nil)
+		require.NoError(t, err)
+		tempPath := nerdctlPath(t, result)
+		assert.NoFileExists(t, tempPath, "the temporary file should not exist")
+		require.NoError(t, os.WriteFile(tempPath, []byte("image contents"), 0o644))
+		require.NoError(t, runCleanups(cleanups))
+		contents, err := os.ReadFile(dest)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "image contents", string(contents))
+		}
+		assert.NoFileExists(t, tempPath, "the temporary file should be removed")
+	})
+	t.Run("build", func(t *testing.T) {
+		t.Parallel()
+		tempDir, dest := t.TempDir(), filepath.Join(t.TempDir(), "out")
+		require.NoError(t, os.MkdirAll(dest, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dest, "index.html"), []byte("old"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dest, "keep.txt"), []byte("kept"), 0o644))
+		var written []string
+		dirMounter := func(s string) (string, []cleanupFunc, error) {
+			return redirectOutput(s, true, tempDir, toPath, func(p string) error {
+				written = append(written, p)
+				return nil
+			})
+		}
+		mounterError := func(s string) (string, []cleanupFunc, error) {
+			t.Error("should not have called fileMounter with", s)
+			return "", nil, fmt.Errorf("test failed")
+		}
+		result, cleanups, err := builderOutputProcessor("type=local,dest="+dest, mounterError, dirMounter)
+		require.NoError(t, err)
+		tempPath := nerdctlPath(t, strings.TrimPrefix(result, "type=local,dest="))
+		require.NoError(t, os.MkdirAll(filepath.Join(tempPath, "assets"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(tempPath, "index.html"), []byte("new"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(tempPath, "assets", "app.js"), []byte("app"), 0o644))
+		require.NoError(t, runCleanups(cleanups))
+		for name, expected := range map[string]string{
+			"index.html":                      "new",
+			"keep.txt":                        "kept",
+			filepath.Join("assets", "app.js"): "app",
+		} {
+			contents, err := os.ReadFile(filepath.Join(dest, name))
+			if assert.NoError(t, err) {
+				assert.Equal(t, expected, string(contents), "contents of %s", name)
+			}
+		}
+		assert.ElementsMatch(t, []string{
+			filepath.Join(dest, "assets"),
+			filepath.Join(dest, "assets", "app.js"),
+			filepath.Join(dest, "index.html"),
+		}, written, "only new directories and written files should be reported")
+		assert.NoDirExists(t, tempPath, "the temporary directory should be removed")
+	})
+	t.Run("nerdctl failed", func(t *testing.T) {
+		t.Parallel()
+		tempDir, dest := t.TempDir(), filepath.Join(t.TempDir(), "image.tar")
+		_, cleanups, err := redirectOutput(dest, false, tempDir, toPath, nil)
+		require.NoError(t, err)
+		assert.NoError(t, runCleanups(cleanups))
+		assert.NoFileExists(t, dest)
+		_, cleanups, err = redirectOutput(dest, true, tempDir, toPath, nil)
+		require.NoError(t, err)
+		assert.NoError(t, runCleanups(cleanups))
+		assert.NoDirExists(t, dest)
+	})
+	t.Run("translation failed", func(t *testing.T) {
+		t.Parallel()
+		tempDir := t.TempDir()
+		_, _, err := redirectOutput(filepath.Join(t.TempDir(), "out"), true, tempDir,
+			func(string) (string, error) { return "", fmt.Errorf("some error") }, nil)
+		assert.Error(t, err)
+		entries, err := os.ReadDir(tempDir)
+		if assert.NoError(t, err) {
+			assert.Empty(t, entries, "the temporary directory should be removed")
+		}
+	})
+}
@@ -23,12 +23,13 @@ type argHandler func(string) (string, []cleanupFunc, error)
 
 // argHandlersType defines the functions passed in command handlers.
 type argHandlersType struct {
-	volumeArgHandler       argHandler
-	filePathArgHandler     argHandler
-	outputPathArgHandler   argHandler
-	mountArgHandler        argHandler
-	builderCacheArgHandler argHandler
-	buildContextArgHandler argHandler
+	volumeArgHandler        argHandler
+	filePathArgHandler      argHandler
+	outputPathArgHandler    argHandler
+	mountArgHandler         argHandler
+	builderCacheArgHandler  argHandler
+	builderOutputArgHandler argHandler
+	buildContextArgHandler  argHandler
 }
 
 // commandHandlerType is the type of commandDefinition.handler, which is used
@@ -132,6 +133,12 @@ func (c *commandDefinition) parseOption(arg, next string) ([]string, bool, []cle
 		}
 		extraCleanups = parentCleanups
 	}
+	if sep >= 0 {
+		// An option we don't know about (perhaps from a newer nerdctl), but as
+		// its value is part of the argument, we can pass it on as is and keep
+		// going with the arguments after it.
+		return []string{arg}, false, extraCleanups, nil
+	}
 	return nil, false, extraCleanups, fmt.Errorf("command %q does not support option %s", c.commandPath, arg)
 }
 
@@ -260,6 +267,18 @@ func ignoredArgHandler(input string) (string, []cleanupFunc, error) {
 	return input, nil, nil
 }
 
+// stdinArgHandler wraps the handler of an option taking a file path that
+// reads from standard input when the path is `-`, so that `-` is passed on as
+// it is.
+func stdinArgHandler(handler argHandler) argHandler {
+	return func(input string) (string, []cleanupFunc, error) {
+		if input == "-" {
+			return input, nil, nil
+		}
+		return handler(input)
+	}
+}
+
 // registerArgHandler sets option handlers.  This should be called from init()
 // to set up any option handlers that need to handle paths.
 func registerArgHandler(command, option string, handler argHandler) {
@@ -330,63 +349,89 @@ func aliasCommand(alias, target string) {
 	commands[alias] = commands[target]
 }
 
+// pathOptions lists the options taking a host path, by command; their values
+// are translated so that nerdctl, running elsewhere, reaches the same file.
+// Output paths are for files that nerdctl writes, such as --cidfile. Options
+// not listed here or registered in init are passed through unchanged.
+var pathOptions = []struct {
+	command string
+	option  string
+	output  bool
+}{
+	{"builder build", "--file", false},
+	{"builder build", "--iidfile", true},
+	{"builder build", "--source-policy-file", false},
+	{"builder build", "-f", false},
+	{"builder debug", "--file", false},
+	{"builder debug", "-f", false},
+	{"checkpoint create", "--checkpoint-dir", false},
+	{"checkpoint ls", "--checkpoint-dir", false},
+	{"checkpoint rm", "--checkpoint-dir", false},
+	{"compose", "--env-file", false},
+	{"compose", "--project-directory", false},
+	{"container create", "--cidfile", true},
+	{"container create", "--cosign-key", false},
+	{"container create", "--env-file", false},
+	{"container create", "--label-file", false},
+	{"container create", "--pidfile", true},
+	{"container exec", "--env-file", false},
+	{"container export", "--output", true},
+	{"container export", "-o", true},
+	{"container run", "--cidfile", true},
+	{"container run", "--cosign-key", false},
+	{"container run", "--env-file", false},
+	{"container run", "--label-file", false},
+	{"container run", "--pidfile", true},
+	{"container start", "--checkpoint-dir", false},
+	{"image convert", "--estargz-record-in", false},
+	{"image convert", "--zstdchunked-record-in", false},
+	{"image decrypt", "--gpg-homedir", false},
+	{"image decrypt", "--key", false},
+	{"image encrypt", "--gpg-homedir", false},
+	{"image encrypt", "--key", false},
+	{"image load", "--input", false},
+	{"image load", "-i", false},
+	{"image pull", "--cosign-key", false},
+	{"image push", "--cosign-key", false},
+	{"image save", "--output", true},
+	{"image save", "-o", true},
+}
+
 func init() {
 	// Set up the argument handlers
+	for _, pathOption := range pathOptions {
+		handler := argHandlers.filePathArgHandler
+		if pathOption.output {
+			handler = argHandlers.outputPathArgHandler
+		}
+		registerArgHandler(pathOption.command, pathOption.option, handler)
+	}
 	registerArgHandler("builder build", "--build-context", argHandlers.buildContextArgHandler)
 	registerArgHandler("builder build", "--cache-from", argHandlers.builderCacheArgHandler)
 	registerArgHandler("builder build", "--cache-to", argHandlers.builderCacheArgHandler)
-	registerArgHandler("builder build", "--file", argHandlers.filePathArgHandler)
-	registerArgHandler("builder build", "-f", argHandlers.filePathArgHandler)
-	registerArgHandler("builder build", "--iidfile", argHandlers.outputPathArgHandler)
-	// --output and --secret are CSV whose src= and dest= values are paths.
-	registerArgHandler("builder build", "--output", argHandlers.builderCacheArgHandler)
-	registerArgHandler("builder build", "-o", argHandlers.builderCacheArgHandler)
+	// --output is a directory, or CSV in the syntax of buildctl.
+	registerArgHandler("builder build", "--output", argHandlers.builderOutputArgHandler)
+	registerArgHandler("builder build", "-o", argHandlers.builderOutputArgHandler)
+	// --secret is CSV whose src= values are paths.
 	registerArgHandler("builder build", "--secret", argHandlers.builderCacheArgHandler)
-	registerArgHandler("builder build", "--source-policy-file", argHandlers.filePathArgHandler)
-	registerArgHandler("builder debug", "--file", argHandlers.filePathArgHandler)
-	registerArgHandler("builder debug", "-f", argHandlers.filePathArgHandler)
 	registerArgHandler("builder debug", "--secret", argHandlers.builderCacheArgHandler)
-	registerArgHandler("checkpoint create", "--checkpoint-dir", argHandlers.filePathArgHandler)
-	registerArgHandler("checkpoint ls", "--checkpoint-dir", argHandlers.filePathArgHandler)
-	registerArgHandler("checkpoint rm", "--checkpoint-dir", argHandlers.filePathArgHandler)
-	registerArgHandler("compose", "--file", argHandlers.filePathArgHandler)
-	registerArgHandler("compose", "-f", argHandlers.filePathArgHandler)
-	registerArgHandler("compose", "--project-directory", argHandlers.filePathArgHandler)
-	registerArgHandler("compose", "--env-file", argHandlers.filePathArgHandler)
+	// `-f -` reads the compose file from standard input.
+	registerArgHandler("compose", "--file", stdinArgHandler(argHandlers.filePathArgHandler))
+	registerArgHandler("compose", "-f", stdinArgHandler(argHandlers.filePathArgHandler))
 	// nerdctl's help text renders these with a metavar because the
 	// description quotes the "volumes" Compose section, but they are booleans.
 	registerArgHandler("compose down", "--volumes", nil)
 	registerArgHandler("compose down", "-v", nil)
 	registerArgHandler("compose run", "--volume", argHandlers.volumeArgHandler)
 	registerArgHandler("compose run", "-v", argHandlers.volumeArgHandler)
-	registerArgHandler("container create", "--cidfile", argHandlers.outputPathArgHandler)
-	registerArgHandler("container create", "--cosign-key", argHandlers.filePathArgHandler)
-	registerArgHandler("container create", "--env-file", argHandlers.filePathArgHandler)
-	registerArgHandler("container create", "--label-file", argHandlers.filePathArgHandler)
 	registerArgHandler("container create", "--mount", argHandlers.mountArgHandler)
-	registerArgHandler("container create", "--pidfile", argHandlers.outputPathArgHandler)
 	registerArgHandler("container create", "--volume", argHandlers.volumeArgHandler)
 	registerArgHandler("container create", "-v", argHandlers.volumeArgHandler)
-	registerArgHandler("container exec", "--env-file", argHandlers.filePathArgHandler)
-	registerArgHandler("container export", "--output", argHandlers.outputPathArgHandler)
-	registerArgHandler("container export", "-o", argHandlers.outputPathArgHandler)
-	registerArgHandler("container run", "--cidfile", argHandlers.outputPathArgHandler)
-	registerArgHandler("container run", "--cosign-key", argHandlers.filePathArgHandler)
-	registerArgHandler("container run", "--env-file", argHandlers.filePathArgHandler)
-	registerArgHandler("container run", "--label-file", argHandlers.filePathArgHandler)
 	registerArgHandler("container run", "--mount", argHandlers.mountArgHandler)
-	registerArgHandler("container run", "--pidfile", argHandlers.outputPathArgHandler)
+	registerArgHandler("container run", "--publish", publishArgHandler)
+	registerArgHandler("container run", "-p", publishArgHandler)
 	registerArgHandler("container run", "--volume", argHandlers.volumeArgHandler)
 	registerArgHandler("container run", "-v", argHandlers.volumeArgHandler)
-	registerArgHandler("container start", "--checkpoint-dir", argHandlers.filePathArgHandler)
-	registerArgHandler("image convert", "--estargz-record-in", argHandlers.filePathArgHandler)
-	registerArgHandler("image convert", "--zstdchunked-record-in", argHandlers.filePathArgHandler)
-	registerArgHandler("image decrypt", "--key", argHandlers.filePathArgHandler)
-	registerArgHandler("image encrypt", "--key", argHandlers.filePathArgHandler)
-	registerArgHandler("image load", "--input", argHandlers.filePathArgHandler)
-	registerArgHandler("image pull", "--cosign-key", argHandlers.filePathArgHandler)
-	registerArgHandler("image push", "--cosign-key", argHandlers.filePathArgHandler)
-	registerArgHandler("image save", "--output", argHandlers.outputPathArgHandler)
 
 	// Set up command handlers
 	registerCommandHandler("builder build", builderBuildHandler)
@@ -40,6 +40,23 @@ func TestParseOptions(t *testing.T) {
 		_, _, _, err := c.parseOption("-hello", "world")
 		assert.EqualError(t, err, `command "" does not support option -hello`)
 	})
+	t.Run("unsupported option with embedded value", func(t *testing.T) {
+		t.Parallel()
+		localCommands := map[string]commandDefinition{}
+		localCommands[""] = commandDefinition{commands: &localCommands}
+		localCommands["subcommand"] = commandDefinition{
+			commands:    &localCommands,
+			commandPath: "subcommand",
+			options:     map[string]argHandler{"--world": nil},
+		}
+		command := localCommands["subcommand"]
+		args, consumed, cleanup, err := command.parseOption("--hello=world", "next")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--hello=world"}, args)
+			assert.False(t, consumed)
+			assert.Nil(t, cleanup)
+		}
+	})
 	t.Run("option with no value", func(t *testing.T) {
 		t.Parallel()
 		c := commandDefinition{options: map[string]argHandler{"--hello": nil}}
@@ -322,8 +339,8 @@ func TestPathArgHandlers(t *testing.T) {
 		option  string
 		handler argHandler
 	}{
-		{"builder build", "--output", argHandlers.builderCacheArgHandler},
-		{"builder build", "-o", argHandlers.builderCacheArgHandler},
+		{"builder build", "--output", argHandlers.builderOutputArgHandler},
+		{"builder build", "-o", argHandlers.builderOutputArgHandler},
 		{"builder build", "--secret", argHandlers.builderCacheArgHandler},
 		{"builder debug", "--secret", argHandlers.builderCacheArgHandler},
 		{"container exec", "--env-file", argHandlers.filePathArgHandler},
@@ -351,3 +368,43 @@ func TestPathArgHandlers(t *testing.T) {
 		})
 	}
 }
+
+// TestPathOptions checks that every option in pathOptions is registered with
+// the path handler, and that its value is translated whether it is given as a
+// separate argument or after `=`.
+func TestPathOptions(t *testing.T) {
+	t.Parallel()
+	translate := func(arg string) (string, []cleanupFunc, error) {
+		return "translated:" + arg, nil, nil
+	}
+	for _, pathOption := range pathOptions {
+		t.Run(pathOption.command+" "+pathOption.option, func(t *testing.T) {
+			t.Parallel()
+			expected := argHandlers.filePathArgHandler
+			if pathOption.output {
+				expected = argHandlers.outputPathArgHandler
+			}
+			handler, ok := commands[pathOption.command].options[pathOption.option]
+			if assert.True(t, ok, "option %s of %q not found", pathOption.option, pathOption.command) {
+				assert.Equal(t,
+					reflect.ValueOf(expected).Pointer(),
+					reflect.ValueOf(handler).Pointer(),
+					"option %s of %q has the wrong handler", pathOption.option, pathOption.command)
+			}
+			c := commandDefinition{
+				commandPath: pathOption.command,
+				options:     map[string]argHandler{pathOption.option: translate},
+			}
+			args, consumed, _, err := c.parseOption(pathOption.option, `C:\file`)
+			if assert.NoError(t, err) {
+				assert.Equal(t, []string{pathOption.option, `translated:C:\file`}, args)
+				assert.True(t, consumed)
+			}
+			args, consumed, _, err = c.parseOption(pathOption.option+`=C:\file`, "next")
+			if assert.NoError(t, err) {
+				assert.Equal(t, []string{pathOption.option, `translated:C:\file`}, args)
+				assert.False(t, consumed)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+// This file contains the handling of the ports published by `nerdctl run`.
+//
+// On Windows, a published port is reachable from the host once the WSL proxy
+// in the distribution listens on it, which it does when the guest agent
+// notices the container and tells it to; this takes a moment after nerdctl
+// returns. For detached containers, the stub tells the WSL proxy about the
+// ports itself as soon as nerdctl has started the container, the same way the
+// guest agent does. The ports are added provisionally: the WSL proxy keeps
+// listening on them once the guest agent tells it about them too, and the
+// guest agent removes them once the container stops. If the container exits
+// before the guest agent notices it, the guest agent never does either, and
+// the WSL proxy stops listening on the ports after a while instead.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The socket the WSL proxy listens on for port mappings, in the distribution.
+const wslProxySocket = "/run/wsl-proxy.sock"
+
+// preregisterPortsCommand is the first argument of the Linux stub when it is
+// run in the distribution to send a port mapping to the WSL proxy; the second
+// argument is the port mapping, as JSON.
+const preregisterPortsCommand = "__preregister-ports"
+
+// publishedPort is a port published by `nerdctl run --publish`.
+type publishedPort struct {
+	// The protocol, "tcp" or "udp".
+	proto string
+	// The port in the container.
+	containerPort int
+	// The host address and port; the address is empty for all addresses.
+	hostIP   string
+	hostPort int
+}
+
+// publishedPorts holds the ports published by the command being run, as the
+// arguments are parsed.
+var publishedPorts []publishedPort
+
+// publishArgHandler handles the argument for `nerdctl run --publish=...`,
+// recording the ports it publishes; the argument is passed on as it is.
+func publishArgHandler(arg string) (string, []cleanupFunc, error) {
+	ports, err := parsePublishSpec(arg)
+	if err == nil {
+		publishedPorts = append(publishedPorts, ports...)
+	}
+	// nerdctl reports invalid specifications itself.
+	return arg, nil, nil
+}
+
+// parsePublishSpec parses the argument of `nerdctl run --publish`, of the
+// form `[[ip:][hostPort]:]containerPort[/protocol]`, where the ports may be
+// ranges of the same length and IPv6 addresses are in brackets. It returns
+// the ports published on known host ports; ports nerdctl picks the host port
+// of, and protocols the WSL proxy does not forward, are left out.
+func parsePublishSpec(spec string) ([]publishedPort, error) {
+	rest, proto, ok := strings.Cut(spec, "/")
+	if !ok {
+		proto = "tcp"
+	}
+	proto = strings.ToLower(proto)
+	var hostIP, hostPorts, containerPorts string
+	if sep := strings.LastIndex(rest, ":"); sep < 0 {
+		containerPorts = rest
+	} else {
+		containerPorts = rest[sep+1:]
+		rest = rest[:sep]
+		if sep := strings.LastIndex(rest, ":"); sep < 0 {
+			hostPorts = rest
+		} else {
+			hostIP, hostPorts = rest[:sep], rest[sep+1:]
+			if strings.HasPrefix(hostIP, "[") && strings.HasSuffix(hostIP, "]") {
+				hostIP = hostIP[1 : len(hostIP)-1]
+			}
+			if net.ParseIP(hostIP) == nil {
+				return nil, fmt.Errorf("invalid host address in %q", spec)
+			}
+		}
+	}
+	containerStart, containerEnd, err := parsePortRange(containerPorts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid container port in %q: %w", spec, err)
+	}
+	if hostPorts == "" {
+		// nerdctl picks the host ports.
+		return nil, nil
+	}
+	hostStart, hostEnd, err := parsePortRange(hostPorts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host port in %q: %w", spec, err)
+	}
+	if hostEnd-hostStart != containerEnd-containerStart {
+		if containerStart == containerEnd {
+			// nerdctl picks one of the host ports.
+			return nil, nil
+		}
+		return nil, fmt.Errorf("host and container port ranges of %q differ in size", spec)
+	}
+	switch proto {
+	case "tcp", "udp":
+	case "sctp":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid protocol in %q", spec)
+	}
+	var ports []publishedPort
+	for offset := range containerEnd - containerStart + 1 {
+		ports = append(ports, publishedPort{
+			proto:         proto,
+			containerPort: containerStart + offset,
+			hostIP:        hostIP,
+			hostPort:      hostStart + offset,
+		})
+	}
+	return ports, nil
+}
+
+// parsePortRange parses a port, or a range of ports such as `8080-8090`.
+func parsePortRange(ports string) (start, end int, err error) {
+	startText, endText, isRange := strings.Cut(ports, "-")
+	start, err = parsePort(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return start, start, nil
+	}
+	end, err = parsePort(endText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid port range %q", ports)
+	}
+	return start, end, nil
+}
+
+func parsePort(port string) (int, error) {
+	result, err := strconv.Atoi(port)
+	if err != nil || result < 1 || result > 65535 {
+		return 0, fmt.Errorf("invalid port %q", port)
+	}
+	return result, nil
+}
+
+// isDetached reports whether parsed `nerdctl run` arguments run the container
+// detached; options are before the `--` the parser puts before the image.
+func isDetached(args []string) bool {
+	for _, arg := range args {
+		switch {
+		case arg == "--":
+			return false
+		case arg == "--detach", arg == "--detach=true":
+			return true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && !strings.Contains(arg, "="):
+			// Short options, which may be bunched together, as in `-dp`;
+			// -d is the only one with a "d".
+			if strings.Contains(arg[1:], "d") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The JSON form of a port mapping for the WSL proxy; this must match
+// PortMapping in src/go/guestagent/pkg/types, which the stub does not depend
+// on.
+type wslProxyPortMapping struct {
+	Remove      bool                             `json:"remove"`
+	Ports       map[string][]wslProxyPortBinding `json:"ports"`
+	Ack         bool                             `json:"ack,omitempty"`
+	Provisional bool                             `json:"provisional,omitempty"`
+}
+
+type wslProxyPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type wslProxyPortMappingAck struct {
+	Failures []struct {
+		Port     string `json:"port"`
+		HostIP   string `json:"hostIP"`
+		HostPort string `json:"hostPort"`
+		Error    string `json:"error"`
+	} `json:"failures"`
+}
+
+// newPortMapping returns the port mapping provisionally adding the given
+// ports.
+func newPortMapping(ports []publishedPort) wslProxyPortMapping {
+	mapping := wslProxyPortMapping{Ports: make(map[string][]wslProxyPortBinding), Ack: true, Provisional: true}
+	for _, port := range ports {
+		key := fmt.Sprintf("%d/%s", port.containerPort, port.proto)
+		mapping.Ports[key] = append(mapping.Ports[key], wslProxyPortBinding{
+			HostIP:   port.hostIP,
+			HostPort: strconv.Itoa(port.hostPort),
+		})
+	}
+	return mapping
+}
+
+// sendPortMapping sends a port mapping, as JSON, to the WSL proxy listening
+// on socketPath, and logs the ports it failed to listen on.
+func sendPortMapping(socketPath, mappingJSON string) error {
+	var mapping wslProxyPortMapping
+	if err := json.Unmarshal([]byte(mappingJSON), &mapping); err != nil {
+		return fmt.Errorf("invalid port mapping: %w", err)
+	}
+	conn, err := net.DialTimeout("unix", socketPath, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the WSL proxy: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(mapping); err != nil {
+		return fmt.Errorf("failed to send port mapping: %w", err)
+	}
+	var ack wslProxyPortMappingAck
+	if err := json.NewDecoder(conn).Decode(&ack); err != nil {
+		// Older proxies close the connection without acknowledging.
+		return nil
+	}
+	for _, failure := range ack.Failures {
+		log.Printf("Could not forward port %s from %s: %s",
+			failure.Port, net.JoinHostPort(failure.HostIP, failure.HostPort), failure.Error)
+	}
+	return nil
+}
+
+// runPreregisterPorts runs the stub as started with preregisterPortsCommand,
+// and returns the exit code.
+func runPreregisterPorts(args []string) int {
+	if len(args) != 1 {
+		log.Printf("Usage: %s %s <port mapping>", os.Args[0], preregisterPortsCommand)
+		return exitCodeFailure
+	}
+	if err := sendPortMapping(wslProxySocket, args[0]); err != nil {
+		log.Printf("Error forwarding published ports: %s", err)
+		return exitCodeFailure
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePublishSpec(t *testing.T) {
+	t.Parallel()
+	tcp := func(hostIP string, hostPort, containerPort int) publishedPort {
+		return publishedPort{proto: "tcp", containerPort: containerPort, hostIP: hostIP, hostPort: hostPort}
+	}
+	udp := func(hostIP string, hostPort, containerPort int) publishedPort {
+		return publishedPort{proto: "udp", containerPort: containerPort, hostIP: hostIP, hostPort: hostPort}
+	}
+	testCases := []struct {
+		spec     string
+		expected []publishedPort
+	}{
+		{spec: "8080:80", expected: []publishedPort{tcp("", 8080, 80)}},
+		{spec: "8080:80/tcp", expected: []publishedPort{tcp("", 8080, 80)}},
+		{spec: "127.0.0.1:8080:80", expected: []publishedPort{tcp("127.0.0.1", 8080, 80)}},
+		{spec: "[::1]:8080:80", expected: []publishedPort{tcp("::1", 8080, 80)}},
+		{spec: "[::]:5353:53/udp", expected: []publishedPort{udp("::", 5353, 53)}},
+		{spec: "5353:53/udp", expected: []publishedPort{udp("", 5353, 53)}},
+		{spec: "5353:53/UDP", expected: []publishedPort{udp("", 5353, 53)}},
+		{spec: "8000-8002:9000-9002", expected: []publishedPort{
+			tcp("", 8000, 9000), tcp("", 8001, 9001), tcp("", 8002, 9002),
+		}},
+		{spec: "0.0.0.0:7000-7001:7000-7001/udp", expected: []publishedPort{
+			udp("0.0.0.0", 7000, 7000), udp("0.0.0.0", 7001, 7001),
+		}},
+		// nerdctl picks the host port of these.
+		{spec: "80"},
+		{spec: "80/udp"},
+		{spec: ":80"},
+		{spec: "127.0.0.1::80"},
+		{spec: "[::1]::80"},
+		{spec: "8000-8010:80"},
+		// The WSL proxy does not forward SCTP.
+		{spec: "8080:80/sctp"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			t.Parallel()
+			ports, err := parsePublishSpec(tc.spec)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, ports)
+			}
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, spec := range []string{
+			"",
+			"http",
+			"8080:http",
+			"70000:80",
+			"0:80",
+			"8080:80/icmp",
+			"localhost:8080:80",
+			"8002-8000:80",
+			"8000-8001:9000-9002",
+		} {
+			_, err := parsePublishSpec(spec)
+			assert.Error(t, err, "spec %q", spec)
+		}
+	})
+}
+
+func TestPublishArgHandler(t *testing.T) {
+	// publishArgHandler records ports in a global, so this can't be parallel
+	// with other tests running the handler.
+	savedPorts := publishedPorts
+	defer func() { publishedPorts = savedPorts }()
+	publishedPorts = nil
+	for _, spec := range []string{"8080:80", "invalid", "9000:90/udp"} {
+		result, cleanups, err := publishArgHandler(spec)
+		assert.NoError(t, err)
+		assert.Empty(t, cleanups)
+		assert.Equal(t, spec, result, "the argument should be passed on unchanged")
+	}
+	assert.Equal(t, []publishedPort{
+		{proto: "tcp", containerPort: 80, hostPort: 8080},
+		{proto: "udp", containerPort: 90, hostPort: 9000},
+	}, publishedPorts)
+}
+
+func TestIsDetached(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		args     []string
+		expected bool
+	}{
+		{args: []string{"container", "run", "-d", "--", "nginx"}, expected: true},
+		{args: []string{"container", "run", "--detach", "--", "nginx"}, expected: true},
+		{args: []string{"container", "run", "--detach=true", "--", "nginx"}, expected: true},
+		{args: []string{"container", "run", "-dp", "8080:80", "--", "nginx"}, expected: true},
+		{args: []string{"container", "run", "-itd", "--", "nginx"}, expected: true},
+		{args: []string{"container", "run", "--detach=false", "--", "nginx"}},
+		{args: []string{"container", "run", "-it", "-p", "8080:80", "--", "nginx"}},
+		// Options of the command in the container are not nerdctl's.
+		{args: []string{"container", "run", "--", "nginx", "-d"}},
+		{args: []string{"container", "run", "--name", "daemon", "--", "nginx"}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, isDetached(tc.args), "args %q", tc.args)
+	}
+}
+
+func TestNewPortMapping(t *testing.T) {
+	t.Parallel()
+	mapping := newPortMapping([]publishedPort{
+		{proto: "tcp", containerPort: 80, hostIP: "127.0.0.1", hostPort: 8080},
+		{proto: "tcp", containerPort: 80, hostIP: "::1", hostPort: 8080},
+		{proto: "udp", containerPort: 53, hostPort: 5353},
+	})
+	actual, err := json.Marshal(mapping)
+	if assert.NoError(t, err) {
+		// This is the JSON form of the PortMapping the guest agent sends.
+		assert.JSONEq(t, `{
+			"remove": false,
+			"ports": {
+				"80/tcp": [{"HostIp": "127.0.0.1", "HostPort": "8080"}, {"HostIp": "::1", "HostPort": "8080"}],
+				"53/udp": [{"HostIp": "", "HostPort": "5353"}]
+			},
+			"ack": true,
+			"provisional": true
+		}`, string(actual))
+	}
+}
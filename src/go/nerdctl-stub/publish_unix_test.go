@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendPortMapping(t *testing.T) {
+	t.Parallel()
+	socketPath := filepath.Join(t.TempDir(), "wsl-proxy.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan wslProxyPortMapping, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var mapping wslProxyPortMapping
+		if err := json.NewDecoder(conn).Decode(&mapping); err != nil {
+			return
+		}
+		received <- mapping
+		_, _ = conn.Write([]byte(`{"seq":0,"capabilities":["bindFailures"],"failures":[{"port":"80/tcp","hostIP":"","hostPort":"8080","error":"address in use"}]}` + "\n"))
+	}()
+
+	mappingJSON, err := json.Marshal(newPortMapping([]publishedPort{{proto: "tcp", containerPort: 80, hostPort: 8080}}))
+	require.NoError(t, err)
+	// Ports the proxy failed to listen on are logged, not returned.
+	assert.NoError(t, sendPortMapping(socketPath, string(mappingJSON)))
+	mapping := <-received
+	assert.True(t, mapping.Ack)
+	assert.True(t, mapping.Provisional)
+	assert.Equal(t, []wslProxyPortBinding{{HostPort: "8080"}}, mapping.Ports["80/tcp"])
+
+	assert.Error(t, sendPortMapping(filepath.Join(t.TempDir(), "missing.sock"), string(mappingJSON)))
+	assert.Error(t, sendPortMapping(socketPath, "not json"))
+}
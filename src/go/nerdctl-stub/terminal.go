@@ -0,0 +1,85 @@
+// This file contains the handling of the terminal while nerdctl runs.
+//
+// nerdctl runs through wsl.exe with the stub's own standard handles, so that
+// wsl.exe sees the terminal: when they are a console, it gives nerdctl a
+// pseudo terminal in the distribution, puts the console in raw mode and
+// passes on changes of the window size, and when they are pipes, it passes on
+// the data and the end of the input. The stub only has to stay out of the way
+// until nerdctl exits, and put the console back as it was should wsl.exe not.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// holdInterrupts keeps interrupts (Ctrl-C, and Ctrl-Break on Windows) from
+// terminating the stub until release is called. An interrupt also reaches
+// nerdctl, through the terminal, which decides what to do with it; the stub
+// waits for it to exit, so that it can clean up and exit with its status.
+// Each interrupt held is passed to notify, which may be nil.
+func holdInterrupts(notify func(os.Signal)) (release func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		defer close(done)
+		for sig := range signals {
+			if notify != nil {
+				notify(sig)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}
+}
+
+// consoleModeAPI reads and sets the mode of a console handle; reading fails
+// for handles that are not consoles, such as pipes.
+type consoleModeAPI struct {
+	get func(handle uintptr) (uint32, error)
+	set func(handle uintptr, mode uint32) error
+}
+
+type savedConsoleMode struct {
+	handle uintptr
+	mode   uint32
+}
+
+// consoleModes holds the modes of console handles, as they were saved.
+type consoleModes struct {
+	api   consoleModeAPI
+	saved []savedConsoleMode
+}
+
+// saveConsoleModes saves the modes of those of the given handles that are
+// consoles.
+func saveConsoleModes(api consoleModeAPI, handles ...uintptr) *consoleModes {
+	modes := &consoleModes{api: api}
+	for _, handle := range handles {
+		if mode, err := api.get(handle); err == nil {
+			modes.saved = append(modes.saved, savedConsoleMode{handle: handle, mode: mode})
+		}
+	}
+	return modes
+}
+
+// restore puts back the saved modes of the consoles that have changed.
+func (modes *consoleModes) restore() error {
+	var errors *multierror.Error
+	for _, saved := range modes.saved {
+		if mode, err := modes.api.get(saved.handle); err == nil && mode == saved.mode {
+			continue
+		}
+		if err := modes.api.set(saved.handle, saved.mode); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+	return errors.ErrorOrNil()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsoles is a consoleModeAPI for the consoles it holds the modes of;
+// other handles are not consoles.
+type fakeConsoles struct {
+	modes  map[uintptr]uint32
+	sets   []uintptr
+	setErr error
+}
+
+func (consoles *fakeConsoles) api() consoleModeAPI {
+	return consoleModeAPI{
+		get: func(handle uintptr) (uint32, error) {
+			mode, ok := consoles.modes[handle]
+			if !ok {
+				return 0, errors.New("not a console")
+			}
+			return mode, nil
+		},
+		set: func(handle uintptr, mode uint32) error {
+			consoles.sets = append(consoles.sets, handle)
+			if consoles.setErr != nil {
+				return consoles.setErr
+			}
+			consoles.modes[handle] = mode
+			return nil
+		},
+	}
+}
+
+func TestConsoleModes(t *testing.T) {
+	t.Parallel()
+	t.Run("restores changed consoles", func(t *testing.T) {
+		t.Parallel()
+		consoles := &fakeConsoles{modes: map[uintptr]uint32{1: 0x1f7, 2: 0x7}}
+		modes := saveConsoleModes(consoles.api(), 1, 2, 3)
+		// As wsl.exe does when it puts the input in raw mode.
+		consoles.modes[1] = 0x200
+		if assert.NoError(t, modes.restore()) {
+			assert.Equal(t, map[uintptr]uint32{1: 0x1f7, 2: 0x7}, consoles.modes)
+			assert.Equal(t, []uintptr{1}, consoles.sets, "only the changed console should be set")
+		}
+	})
+	t.Run("ignores handles that are not consoles", func(t *testing.T) {
+		t.Parallel()
+		consoles := &fakeConsoles{modes: map[uintptr]uint32{}}
+		modes := saveConsoleModes(consoles.api(), 1, 2, 3)
+		assert.Empty(t, modes.saved)
+		assert.NoError(t, modes.restore())
+		assert.Empty(t, consoles.sets)
+	})
+	t.Run("restores every console despite errors", func(t *testing.T) {
+		t.Parallel()
+		consoles := &fakeConsoles{modes: map[uintptr]uint32{1: 0x1f7, 2: 0x7}, setErr: errExpected}
+		modes := saveConsoleModes(consoles.api(), 1, 2)
+		consoles.modes[1] = 0
+		consoles.modes[2] = 0
+		err := modes.restore()
+		assert.ErrorIs(t, err, errExpected)
+		assert.Equal(t, []uintptr{1, 2}, consoles.sets)
+	})
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldInterrupts(t *testing.T) {
+	held := make(chan os.Signal, 1)
+	release := holdInterrupts(func(sig os.Signal) {
+		held <- sig
+	})
+	defer release()
+	// Were the interrupt not held, it would terminate the test.
+	if !assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT)) {
+		return
+	}
+	select {
+	case sig := <-held:
+		assert.Equal(t, os.Interrupt, sig)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "the interrupt was not held")
+	}
+}
@@ -0,0 +1,138 @@
+// This file contains the parsing of Windows host paths for the Windows stub;
+// it is not specific to Windows so that it can be tested everywhere.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// errUnreachablePath is wrapped by the errors of windowsPathToWSL for paths
+// that exist, but can't be reached from the distribution.
+var errUnreachablePath = errors.New("can't be reached from WSL")
+
+// hasDriveLetter reports whether a path starts with a drive letter, as in
+// `C:\Users` or `C:foo`.
+func hasDriveLetter(p string) bool {
+	if len(p) < 2 || p[1] != ':' {
+		return false
+	}
+	letter := p[0] | 0x20 // lower case
+	return 'a' <= letter && letter <= 'z'
+}
+
+// isAbsWindowsPath reports whether a Windows path is absolute: on a drive, as
+// in `C:\Users`, or a UNC path.
+func isAbsWindowsPath(p string) bool {
+	slashPath := strings.ReplaceAll(p, `\`, "/")
+	return strings.HasPrefix(slashPath, "//") || (hasDriveLetter(slashPath) && strings.HasPrefix(slashPath[2:], "/"))
+}
+
+// isLinuxPath reports whether a host path is already a Linux path, which must
+// not be translated: it is absolute, and not a UNC path written with forward
+// slashes (`//server/share`).
+func isLinuxPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//")
+}
+
+// windowsPathToWSL translates a Windows path to the path of the same file in
+// the WSL distribution named distro: paths on drives are under /mnt, and UNC
+// paths into the distribution itself (`\\wsl$\<distro>\...` or
+// `\\wsl.localhost\<distro>\...`) are made absolute paths in it. Relative
+// paths are resolved against the Windows directory cwd. Other UNC paths
+// (network shares), and paths in other distributions, can't be reached from
+// WSL, and are an error wrapping errUnreachablePath.
+func windowsPathToWSL(p, cwd, distro string) (string, error) {
+	slashPath := strings.ReplaceAll(p, `\`, "/")
+	// Drop the prefix of extended-length paths, `\\?\C:\...`.
+	for _, prefix := range []string{"//?/UNC/", "//?/", "//./"} {
+		if rest, ok := strings.CutPrefix(slashPath, prefix); ok {
+			if prefix == "//?/UNC/" {
+				rest = "//" + rest
+			}
+			slashPath = rest
+			break
+		}
+	}
+	switch {
+	case strings.HasPrefix(slashPath, "//"):
+		server, rest, _ := strings.Cut(slashPath[2:], "/")
+		share, rest, _ := strings.Cut(rest, "/")
+		if !strings.EqualFold(server, "wsl$") && !strings.EqualFold(server, "wsl.localhost") {
+			return "", fmt.Errorf("network path %s %w; copy the files to a local drive", p, errUnreachablePath)
+		}
+		if !strings.EqualFold(share, distro) {
+			return "", fmt.Errorf("path %s %w: it is in distribution %q, not %q", p, errUnreachablePath, share, distro)
+		}
+		return path.Clean("/" + rest), nil
+	case hasDriveLetter(slashPath):
+		drive, rest := strings.ToLower(slashPath[:1]), slashPath[2:]
+		if !strings.HasPrefix(rest, "/") {
+			// Relative to the current directory of the drive; only that of
+			// the current drive is known.
+			if isAbsWindowsPath(cwd) && hasDriveLetter(cwd) && strings.EqualFold(cwd[:1], drive) {
+				return windowsPathToWSL(cwd+`\`+rest, cwd, distro)
+			}
+			rest = "/" + rest
+		}
+		return path.Clean("/mnt/" + drive + rest), nil
+	case strings.HasPrefix(slashPath, "/"):
+		// Relative to the root of the current drive.
+		if !isAbsWindowsPath(cwd) || !hasDriveLetter(cwd) {
+			return "", fmt.Errorf("can't resolve path %s: the current directory %q is not on a drive", p, cwd)
+		}
+		return windowsPathToWSL(cwd[:2]+slashPath, cwd, distro)
+	default:
+		if !isAbsWindowsPath(cwd) {
+			return "", fmt.Errorf("can't resolve relative path %s against the current directory %q", p, cwd)
+		}
+		return windowsPathToWSL(cwd+`\`+p, cwd, distro)
+	}
+}
+
+// splitVolumeArg splits the argument of `nerdctl run --volume=...`, which is
+// `[<host>:]<container path>[:<options>]`, into its parts; host is empty if
+// there is none. The host part may be a Windows path starting with a drive
+// letter, whose colon doesn't separate the parts. Container paths are Linux
+// paths, and so have no colons.
+func splitVolumeArg(arg string) (host, container, options string) {
+	start := 0
+	if hasDriveLetter(arg) && len(arg) > 2 && (arg[2] == '\\' || arg[2] == '/') {
+		start = 2
+	}
+	index := strings.Index(arg[start:], ":")
+	if index < 0 {
+		return "", arg, ""
+	}
+	host, rest := arg[:start+index], arg[start+index+1:]
+	container, options, _ = strings.Cut(rest, ":")
+	return host, container, options
+}
+
+// isVolumeName reports whether the host part of a volume argument names a
+// volume, rather than being a path; names can't contain path separators.
+func isVolumeName(host string) bool {
+	return host != "." && host != ".." && !hasDriveLetter(host) && !strings.ContainsAny(host, `/\`)
+}
+
+// volumeArgProcessor implements the handling of the argument for
+// `nerdctl run --volume=...` on Windows, translating the host path with
+// mounter; volume names and Linux paths are left as they are.
+func volumeArgProcessor(arg string, mounter func(string) (string, error)) (string, error) {
+	host, container, options := splitVolumeArg(arg)
+	if host == "" || isVolumeName(host) || isLinuxPath(host) {
+		return arg, nil
+	}
+	wslPath, err := mounter(host)
+	if err != nil {
+		return "", fmt.Errorf("could not get volume host path for %s: %w", arg, err)
+	}
+	result := wslPath + ":" + container
+	if options != "" {
+		result += ":" + options
+	}
+	return result, nil
+}
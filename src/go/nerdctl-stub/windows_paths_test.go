@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowsPathToWSL(t *testing.T) {
+	const cwd = `C:\Users\me\project`
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`C:\Users\me\project`, "/mnt/c/Users/me/project"},
+		{`c:/Users/me/My Project`, "/mnt/c/Users/me/My Project"},
+		{`D:\`, "/mnt/d"},
+		{`D:\data\..\other\.\dir\`, "/mnt/d/other/dir"},
+		{`.`, "/mnt/c/Users/me/project"},
+		{`..\shared`, "/mnt/c/Users/me/shared"},
+		{`src\app`, "/mnt/c/Users/me/project/src/app"},
+		{`./src/app`, "/mnt/c/Users/me/project/src/app"},
+		{`\Windows\Temp`, "/mnt/c/Windows/Temp"},
+		{`C:src`, "/mnt/c/Users/me/project/src"},
+		{`E:src`, "/mnt/e/src"},
+		{`\\?\C:\Very\Long\Path`, "/mnt/c/Very/Long/Path"},
+		{`\\wsl$\rancher-desktop\etc\hosts`, "/etc/hosts"},
+		{`\\wsl.localhost\Rancher-Desktop\var\lib`, "/var/lib"},
+		{`//wsl.localhost/rancher-desktop/`, "/"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			actual, err := windowsPathToWSL(testCase.input, cwd, "rancher-desktop")
+			if assert.NoError(t, err) {
+				assert.Equal(t, testCase.expected, actual)
+			}
+		})
+	}
+	for _, input := range []string{
+		`\\server\share\dir`,
+		`\\?\UNC\server\share\dir`,
+		`\\wsl$\Ubuntu\home\me`,
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := windowsPathToWSL(input, cwd, "rancher-desktop")
+			assert.ErrorIs(t, err, errUnreachablePath)
+		})
+	}
+	t.Run("relative path without a current directory", func(t *testing.T) {
+		_, err := windowsPathToWSL("foo", "", "rancher-desktop")
+		if assert.Error(t, err) {
+			assert.NotErrorIs(t, err, errUnreachablePath)
+		}
+	})
+}
+
+func TestVolumeArgProcessor(t *testing.T) {
+	mounter := func(hostPath string) (string, error) {
+		return windowsPathToWSL(hostPath, `C:\work`, "rancher-desktop")
+	}
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`C:\Users\me\project:/work`, "/mnt/c/Users/me/project:/work"},
+		{`C:\Users\me\My Project:/work`, "/mnt/c/Users/me/My Project:/work"},
+		{`C:\Users\me\project:/work:ro`, "/mnt/c/Users/me/project:/work:ro"},
+		{`C:\Users\me\project:/work:rw`, "/mnt/c/Users/me/project:/work:rw"},
+		{`C:\Users\me\project:/work:cached`, "/mnt/c/Users/me/project:/work:cached"},
+		{`C:\Users\me\project:/work:delegated`, "/mnt/c/Users/me/project:/work:delegated"},
+		{`C:\Users\me\project:/work:ro,z`, "/mnt/c/Users/me/project:/work:ro,z"},
+		{`c:/Users/me/project:/work:ro`, "/mnt/c/Users/me/project:/work:ro"},
+		{`.\src:/src`, "/mnt/c/work/src:/src"},
+		{`.:/src:ro`, "/mnt/c/work:/src:ro"},
+		{`\\wsl$\rancher-desktop\tmp:/host-tmp`, "/tmp:/host-tmp"},
+		// Linux paths and volume names are left as they are.
+		{`/var/run/docker.sock:/var/run/docker.sock`, "/var/run/docker.sock:/var/run/docker.sock"},
+		{`/data:/data:ro`, "/data:/data:ro"},
+		{`my-volume:/data`, "my-volume:/data"},
+		{`my_volume.1:/data:ro`, "my_volume.1:/data:ro"},
+		// Anonymous volumes have no host part.
+		{`/data`, "/data"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			actual, err := volumeArgProcessor(testCase.input, mounter)
+			if assert.NoError(t, err) {
+				assert.Equal(t, testCase.expected, actual)
+			}
+		})
+	}
+	t.Run("reports errors from the mounter", func(t *testing.T) {
+		_, err := volumeArgProcessor(`C:\foo:/foo`, func(string) (string, error) {
+			return "", errExpected
+		})
+		assert.ErrorIs(t, err, errExpected)
+	})
+}
+
+func TestMountArgProcessorTranslatesBindSources(t *testing.T) {
+	mounter := func(hostPath string) (string, error) {
+		if isLinuxPath(hostPath) {
+			return hostPath, nil
+		}
+		return windowsPathToWSL(hostPath, `C:\work`, "rancher-desktop")
+	}
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`type=bind,src=C:\Users\me\project,dst=/work`, "type=bind,src=/mnt/c/Users/me/project,dst=/work"},
+		{`type=bind,source=C:\Users\me\My Project,target=/work,readonly`, "type=bind,source=/mnt/c/Users/me/My Project,target=/work,readonly"},
+		{`src=.\src,type=bind,dst=/src`, "src=/mnt/c/work/src,type=bind,dst=/src"},
+		{`type=bind,"src=C:\a,b",dst=/work`, `type=bind,"src=/mnt/c/a,b",dst=/work`},
+		{`Type=Bind,Source=D:\data,Target=/data,bind-propagation=rshared`, "Type=Bind,Source=/mnt/d/data,Target=/data,bind-propagation=rshared"},
+		{`type=bind,src=/var/lib/data,dst=/data`, "type=bind,src=/var/lib/data,dst=/data"},
+		// Other mounts are left as they are.
+		{`type=volume,src=C:\Users,dst=/work`, `type=volume,src=C:\Users,dst=/work`},
+		{`type=tmpfs,dst=/tmp`, "type=tmpfs,dst=/tmp"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			actual, cleanups, err := mountArgProcessor(testCase.input, mounter)
+			if assert.NoError(t, err) {
+				assert.Equal(t, testCase.expected, actual)
+			}
+			assert.Empty(t, cleanups)
+		})
+	}
+	t.Run("reports errors from the mounter", func(t *testing.T) {
+		_, _, err := mountArgProcessor(`type=bind,src=C:\foo,dst=/foo`, func(string) (string, error) {
+			return "", fmt.Errorf("wrapped: %w", errExpected)
+		})
+		assert.ErrorIs(t, err, errExpected)
+	})
+}
+
+// TestComposeArgs checks that a compose command line run from a Windows
+// project directory reaches nerdctl as the command line of a native nerdctl
+// run from the same directory in WSL, which is where the Windows stub runs
+// it, so that compose resolves the project the same way.
+func TestComposeArgs(t *testing.T) {
+	const cwd = `C:\Users\me\project`
+	translate := func(arg string) (string, []cleanupFunc, error) {
+		result, err := windowsPathToWSL(arg, cwd, "rancher-desktop")
+		return result, nil, err
+	}
+	// The command table, with the compose options translated as by the
+	// Windows stub instead of by the stub this test runs as.
+	localCommands := make(map[string]commandDefinition, len(commands))
+	for commandPath, command := range commands {
+		command.commands = &localCommands
+		command.options = maps.Clone(command.options)
+		localCommands[commandPath] = command
+	}
+	for _, option := range []string{"--env-file", "--project-directory"} {
+		localCommands["compose"].options[option] = translate
+	}
+	for _, option := range []string{"--file", "-f"} {
+		localCommands["compose"].options[option] = stdinArgHandler(translate)
+	}
+
+	workDir, err := windowsPathToWSL(".", cwd, "rancher-desktop")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/mnt/c/Users/me/project", workDir)
+	}
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "default project",
+			args:     []string{"compose", "config"},
+			expected: []string{"compose", "config"},
+		},
+		{
+			name:     "relative compose files",
+			args:     []string{"compose", "-f", "compose.yaml", `--file=deploy\compose.prod.yaml`, "up", "-d"},
+			expected: []string{"compose", "-f", "/mnt/c/Users/me/project/compose.yaml", "--file", "/mnt/c/Users/me/project/deploy/compose.prod.yaml", "up", "-d"},
+		},
+		{
+			name:     "compose file after the subcommand",
+			args:     []string{"compose", "config", "-f", `D:\stacks\web\compose.yaml`},
+			expected: []string{"compose", "config", "-f", "/mnt/d/stacks/web/compose.yaml"},
+		},
+		{
+			name:     "compose file from standard input",
+			args:     []string{"compose", "-f", "-", "config"},
+			expected: []string{"compose", "-f", "-", "config"},
+		},
+		{
+			name:     "project directory and env file",
+			args:     []string{"compose", "--project-directory", `..\other`, "--env-file", `..\other\.env.local`, "config"},
+			expected: []string{"compose", "--project-directory", "/mnt/c/Users/me/other", "--env-file", "/mnt/c/Users/me/other/.env.local", "config"},
+		},
+		{
+			name:     "project in WSL",
+			args:     []string{"compose", "--project-directory", `\\wsl$\rancher-desktop\srv\app`, "config"},
+			expected: []string{"compose", "--project-directory", "/srv/app", "config"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := localCommands[""].parse(testCase.args)
+			if assert.NoError(t, err) {
+				assert.Equal(t, testCase.expected, result.args)
+				assert.Empty(t, result.cleanup)
+			}
+		})
+	}
+}
@@ -15,13 +15,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/signal"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -33,12 +36,18 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/control"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/lease"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/netwatch"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/vsock"
 )
 
 var (
 	debug             bool
 	virtualSubnet     string
+	leaseFile         string
+	controlEndpoint   string
+	mtu               int
 	staticPortForward arrayFlags
 )
 
@@ -47,12 +56,22 @@ const (
 	vsockHandshakePort = 6669
 	timeoutSeconds     = 5 * 60
 	debugLogInterval   = 5 * time.Second
+	leaseSyncInterval  = 30 * time.Second
+	// The prefix of the names of the WSL adapters, such as "vEthernet (WSL)"
+	// or "vEthernet (WSL (Hyper-V firewall))".
+	wslAdapterPrefix = "vEthernet (WSL"
 )
 
 func main() {
 	flag.BoolVar(&debug, "debug", false, "enable additional debugging")
 	flag.StringVar(&virtualSubnet, "subnet", config.DefaultSubnet,
 		fmt.Sprintf("Subnet range with CIDR suffix for virtual network, e,g: %s", config.DefaultSubnet))
+	flag.IntVar(&mtu, "mtu", config.DefaultMTU,
+		fmt.Sprintf("MTU of the virtual network, between %d and %d; it is given to the VM through DHCP", config.MinMTU, config.MaxMTU))
+	flag.StringVar(&leaseFile, "lease-file", "",
+		"File to keep the DHCP leases of the virtual network in across restarts")
+	flag.StringVar(&controlEndpoint, "control-endpoint", control.DefaultEndpoint,
+		"Named pipe to serve the status of the switch on; empty to disable it, and the counting of forwarded connections")
 	flag.Var(&staticPortForward, "port-forward",
 		"List of ports that needs to be pre forwarded to the WSL VM in Host:Port=Guest:Port format e.g: 127.0.0.1:2222=192.168.127.2:22")
 	flag.Parse()
@@ -66,6 +85,27 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	if err := config.ValidateMTU(mtu); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// The settings reject a subnet overlapping the host's networks, but
+	// those can change afterwards, as when connecting to a VPN.
+	if err := config.CheckHostNetworks(subnet); err != nil {
+		logrus.Warnf("%s; change the virtual network subnet to reach it", err)
+	}
+
+	leases, conflicts, err := lease.Load(leaseFile, subnet)
+	if err != nil {
+		// Losing the leases only means clients may get other addresses.
+		logrus.Warnf("ignoring saved DHCP leases: %s", err)
+		if leases, conflicts, err = lease.Load("", subnet); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	logLeaseConflicts(conflicts)
+	subnet.StaticDHCPLease = leases.StaticLeases()
+
 	logrus.Debugf("attempting to start with the following subnet: %+v", subnet)
 
 	portForwarding, err := config.ParsePortForwarding(staticPortForward)
@@ -73,13 +113,13 @@ func main() {
 		logrus.Fatal(err)
 	}
 
-	if err := runSwitch(*subnet, portForwarding); err != nil {
+	if err := runSwitch(*subnet, leases, portForwarding); err != nil {
 		logrus.Error(err)
 		os.Exit(1)
 	}
 }
 
-func runSwitch(subnet config.Subnet, portForwarding map[string]string) error {
+func runSwitch(subnet config.Subnet, leases *lease.Table, portForwarding map[string]string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	groupErrs, ctx := errgroup.WithContext(ctx)
@@ -88,7 +128,7 @@ func runSwitch(subnet config.Subnet, portForwarding map[string]string) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
-	cfg := newConfig(subnet, portForwarding, debug)
+	cfg := newConfig(subnet, mtu, portForwarding, debug)
 
 	logrus.Debugf("attempting to start a virtual network with the following config: %+v", cfg)
 	vn, err := virtualnetwork.New(&cfg)
@@ -101,10 +141,22 @@ func runSwitch(subnet config.Subnet, portForwarding map[string]string) error {
 	if err != nil {
 		logrus.Fatalf("listening on port forwarding API failed: %v", err)
 	}
+	// The forwarder takes over the port forwarding API, so that the status
+	// can count the traffic of each port.
+	var forwarder http.Handler = vn.Mux()
+	var portForwarder *control.Forwarder
+	if controlEndpoint != "" {
+		portForwarder = control.NewForwarder(vn.DialContextTCP, vn.Mux())
+		defer portForwarder.Close()
+		forwarder = portForwarder
+		serveControl(ctx, groupErrs, control.NewCollector(vn.ServicesMux(), portForwarder, leases))
+	}
+	watchAdapters(ctx, groupErrs, control.NewRebinder(vn.Mux(), portForwarder))
 	mux := http.NewServeMux()
-	mux.Handle("/services/forwarder/all", vn.Mux())
-	mux.Handle("/services/forwarder/expose", vn.Mux())
-	mux.Handle("/services/forwarder/unexpose", vn.Mux())
+	mux.Handle("/services/forwarder/all", forwarder)
+	mux.Handle("/services/forwarder/expose", forwarder)
+	mux.Handle("/services/forwarder/unexpose", forwarder)
+	mux.Handle("/services/dhcp/leases", vn.Mux())
 	httpServe(ctx, groupErrs, vnLn, mux)
 	logrus.Infof("port forwarding API server is running on: %s", apiServer)
 
@@ -118,6 +170,10 @@ func runSwitch(subnet config.Subnet, portForwarding map[string]string) error {
 		return runHandshakeLoop(ctx, vn)
 	})
 
+	groupErrs.Go(func() error {
+		return leaseSyncLoop(ctx, vn, leases, leaseSyncInterval)
+	})
+
 	// Wait for something to happen
 	groupErrs.Go(func() error {
 		select {
@@ -198,6 +254,97 @@ func debugLogLoop(ctx context.Context, vn *virtualnetwork.VirtualNetwork, interv
 	}
 }
 
+// leaseSyncLoop copies the leases the DHCP server handed out into the lease
+// table, and saves it when it changed, so that clients keep their addresses
+// when the switch restarts.  The table is saved one last time on exit.
+func leaseSyncLoop(ctx context.Context, vn *virtualnetwork.VirtualNetwork, leases *lease.Table, interval time.Duration) error {
+	update := func() {
+		current, err := dhcpLeases(vn)
+		if err != nil {
+			logrus.Errorf("failed to get DHCP leases: %v", err)
+			return
+		}
+		changed, conflicts := leases.Update(current)
+		logLeaseConflicts(conflicts)
+		if changed {
+			if err := leases.Save(); err != nil {
+				logrus.Errorf("failed to save DHCP leases: %v", err)
+			}
+		}
+	}
+	for {
+		select {
+		case <-time.After(interval):
+			update()
+		case <-ctx.Done():
+			update()
+			return nil
+		}
+	}
+}
+
+// dhcpLeases returns the leases the DHCP server holds, as the address of each
+// lease mapped to the MAC address it is leased to.
+func dhcpLeases(vn *virtualnetwork.VirtualNetwork) (map[string]string, error) {
+	recorder := httptest.NewRecorder()
+	vn.ServicesMux().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/leases", http.NoBody))
+	if recorder.Code != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var leases map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &leases); err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+func logLeaseConflicts(conflicts []lease.Lease) {
+	for _, conflict := range conflicts {
+		logrus.Warnf("dropping DHCP lease of %s to %s, which conflicts with a reserved address", conflict.IP, conflict.MAC)
+	}
+}
+
+// watchAdapters rebinds the forwarded ports when Windows recreates the WSL
+// adapter or gives it other addresses, as it does on updates, when the
+// Hyper-V switch is recreated, and on resume; the listeners on the addresses
+// of the host otherwise stop getting connections until the app is restarted.
+// The virtual network itself doesn't depend on the adapter.  Watching is only
+// there to recover, so failing to watch doesn't stop the switch.
+func watchAdapters(ctx context.Context, g *errgroup.Group, rebinder *control.Rebinder) {
+	isWSLAdapter := func(name string) bool {
+		return strings.HasPrefix(name, wslAdapterPrefix)
+	}
+	watcher := netwatch.New(isWSLAdapter, func(ctx context.Context, changes []netwatch.Change) {
+		start := time.Now()
+		result := rebinder.Rebind(ctx)
+		descriptions := make([]string, 0, len(changes))
+		for _, change := range changes {
+			descriptions = append(descriptions, change.String())
+		}
+		logrus.Infof("recovered from network adapter change: %s; %s in %s",
+			strings.Join(descriptions, "; "), result, time.Since(start).Round(time.Millisecond))
+	})
+	g.Go(func() error {
+		if err := watcher.Run(ctx); err != nil {
+			logrus.Errorf("failed to watch network adapters: %v", err)
+		}
+		return nil
+	})
+}
+
+// serveControl serves the status of the switch on the control endpoint.  The
+// endpoint is only there to inspect the switch, so failing to listen on it
+// doesn't stop the switch.
+func serveControl(ctx context.Context, g *errgroup.Group, collector *control.Collector) {
+	ln, err := control.Listen(ctx, controlEndpoint)
+	if err != nil {
+		logrus.Errorf("failed to serve the control endpoint: %v", err)
+		return
+	}
+	httpServe(ctx, g, ln, collector.Mux())
+	logrus.Infof("control endpoint is running on: %s", controlEndpoint)
+}
+
 func httpServe(ctx context.Context, g *errgroup.Group, ln net.Listener, mux http.Handler) {
 	g.Go(func() error {
 		<-ctx.Done()
@@ -53,6 +53,8 @@ var options struct {
 	tapIface         string
 	subnet           string
 	tapDeviceMacAddr string
+	mtu              int
+	mssClamping      bool
 }
 
 const (
@@ -83,6 +85,18 @@ func run() error {
 		return fmt.Errorf("path to the vm-switch process must be provided")
 	}
 
+	subnet, err := config.ValidateSubnet(options.subnet)
+	if err != nil {
+		return err
+	}
+	if err := config.ValidateMTU(options.mtu); err != nil {
+		return err
+	}
+	// The veth pair between the namespaces must stay reachable.
+	if err := config.CheckNetworks(subnet, &net.IPNet{IP: net.ParseIP(namespaceVethIP), Mask: net.CIDRMask(cidrOnes, cidrBits)}); err != nil {
+		return err
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), unix.SIGTERM, unix.SIGHUP, unix.SIGQUIT)
 	defer cancel()
 
@@ -212,6 +226,10 @@ func initializeFlags() {
 		fmt.Sprintf("Subnet range with CIDR suffix that is associated to the tap interface, e,g: %s", config.DefaultSubnet))
 	flag.StringVar(&options.tapDeviceMacAddr, "tap-mac-address", config.TapDeviceMacAddr,
 		"MAC address that is associated to the tap interface")
+	flag.IntVar(&options.mtu, "mtu", config.DefaultMTU,
+		fmt.Sprintf("MTU of the tap interface, between %d and %d", config.MinMTU, config.MaxMTU))
+	flag.BoolVar(&options.mssClamping, "mss-clamping", false,
+		"clamp the MSS of TCP connections forwarded through the tap interface to its MTU")
 	flag.StringVar(&options.dhcpScript, "dhcp-script", "", "script to run on DHCP events")
 	flag.StringVar(&options.vmSwitchPath, "vm-switch-path", "", "the path to the vm-switch binary that will run in a new namespace")
 	flag.StringVar(&options.vmSwitchLogFile, "vm-switch-logfile", "", "path to the logfile for vm-switch process")
@@ -259,6 +277,11 @@ func configureVMSwitch(
 		tapDevMacAddr,
 		"-dhcp-script",
 		dhcpScript,
+		"-mtu",
+		strconv.Itoa(options.mtu),
+	}
+	if options.mssClamping {
+		args = append(args, "-mss-clamping")
 	}
 	if vmSwitchLogFile != "" {
 		args = append(args, "-logfile", vmSwitchLogFile)
@@ -14,6 +14,7 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"flag"
@@ -48,6 +49,8 @@ var (
 	logFile          string
 	subnet           string
 	tapDeviceMacAddr string
+	mtu              int
+	mssClamping      bool
 )
 
 const (
@@ -74,6 +77,10 @@ func main() {
 		"MAC address that is associated to the tap interface")
 	flag.StringVar(&subnet, "subnet", config.DefaultSubnet,
 		fmt.Sprintf("Subnet range with CIDR suffix that is associated to the tap interface, e,g: %s", config.DefaultSubnet))
+	flag.IntVar(&mtu, "mtu", config.DefaultMTU,
+		fmt.Sprintf("MTU of the tap interface, between %d and %d", config.MinMTU, config.MaxMTU))
+	flag.BoolVar(&mssClamping, "mss-clamping", false,
+		"clamp the MSS of TCP connections forwarded through the tap interface to its MTU")
 	flag.StringVar(&logFile, "logfile", "/var/log/vm-switch.log", "path to vm-switch process logfile")
 	flag.Parse()
 
@@ -81,6 +88,14 @@ func main() {
 		logrus.Fatalf("setting logger's output file failed: %v", err)
 	}
 
+	if _, err := config.ValidateSubnet(subnet); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := config.ValidateMTU(mtu); err != nil {
+		logrus.Fatal(err)
+	}
+
 	if debug {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
@@ -161,8 +176,15 @@ func run(ctx context.Context, cancel context.CancelFunc, connFile io.ReadWriteCl
 		logrus.Debugf("closed tap device: %s", tapIface)
 	}()
 
-	if err := linkUp(tapIface, tapDeviceMacAddr); err != nil {
-		logrus.Fatalf("setting mac address [%s] for %s tap device failed: %s", tapDeviceMacAddr, tapIface, err)
+	if err := linkUp(tapIface, tapDeviceMacAddr, mtu); err != nil {
+		logrus.Fatalf("setting mac address [%s] and MTU %d for %s tap device failed: %s", tapDeviceMacAddr, mtu, tapIface, err)
+	}
+	if mssClamping {
+		if err := clampMSS(ctx, tapIface); err != nil {
+			// Connections still work, unless the path MTU is lower
+			// than the MTU and ICMP is filtered.
+			logrus.Errorf("clamping the MSS of TCP connections through %s failed: %s", tapIface, err)
+		}
 	}
 	if err := loopbackUp(); err != nil {
 		logrus.Fatalf("enabling loop back device failed: %s", err)
@@ -192,11 +214,16 @@ func loopbackUp() error {
 	return netlink.LinkSetUp(lo)
 }
 
-func linkUp(iface, mac string) error {
+func linkUp(iface, mac string, mtu int) error {
 	link, err := netlink.LinkByName(iface)
 	if err != nil {
 		return err
 	}
+	// The DHCP server gives the same MTU, but the DHCP script may not
+	// apply it.
+	if err := netlink.LinkSetMTU(link, mtu); err != nil {
+		return err
+	}
 	if mac == "" {
 		return netlink.LinkSetUp(link)
 	}
@@ -212,6 +239,38 @@ func linkUp(iface, mac string) error {
 	return netlink.LinkSetUp(link)
 }
 
+// clampMSS adds a rule lowering the MSS that TCP connections forwarded through
+// the interface, such as those of containers, negotiate to fit in its MTU.
+// Without it, a path MTU lower than the MTU of the containers' networks is a
+// black hole when the ICMP messages reporting it are filtered, as on some VPNs:
+// small requests work, but large transfers hang.  Local connections already
+// use the MTU of the interface.  The rule is only added once, as run is called
+// again when the connection to the host switch is re-established.
+func clampMSS(ctx context.Context, iface string) error {
+	rule := []string{
+		"FORWARD",
+		"--out-interface", iface,
+		"--protocol", "tcp",
+		"--tcp-flags", "SYN,RST", "SYN",
+		"--jump", "TCPMSS",
+		"--clamp-mss-to-pmtu",
+	}
+	//nolint:gosec // no security concern with the potentially tainted command arguments
+	check := exec.CommandContext(ctx, "iptables", append([]string{"--table", "mangle", "--check"}, rule...)...)
+	if check.Run() == nil {
+		return nil
+	}
+	//nolint:gosec // no security concern with the potentially tainted command arguments
+	cmd := exec.CommandContext(ctx, "iptables", append([]string{"--table", "mangle", "--append"}, rule...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w [%s]", err, stderr.String())
+	}
+	logrus.Infof("clamping the MSS of TCP connections through %s to its MTU", iface)
+	return nil
+}
+
 func dhcp(ctx context.Context, iface string) error {
 	args := []string{"-f", "-i", iface}
 	if dhcpScript != "" {
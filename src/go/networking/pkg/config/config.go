@@ -16,6 +16,7 @@ package config
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"os"
@@ -30,13 +31,31 @@ const (
 	// Subnet range that is used by default if
 	// one is not provided through the arguments.
 	DefaultSubnet = "192.168.127.0/24"
+	// The longest prefix length of a subnet: the smallest subnet
+	// has room for the gateway, the tap device and the static DNS
+	// host, besides its network and broadcast addresses.
+	MaxSubnetPrefixLength = 29
 	// Reserved Mac Address for the tap device eth0 that
 	// is used by vm switch during the tap device
 	// creation.
-	TapDeviceMacAddr   = "5a:94:ef:e4:0c:ee"
-	gatewayLastByte    = 1
-	staticDHCPLastByte = 2
-	staticHostLastByte = 254
+	TapDeviceMacAddr = "5a:94:ef:e4:0c:ee"
+	// Offsets of the addresses in the subnet from its network
+	// address; the static DNS host is the last address before
+	// the broadcast address.
+	gatewayOffset    = 1
+	staticDHCPOffset = 2
+)
+
+const (
+	// MTU of the virtual network used by default if one is not
+	// provided through the arguments.
+	DefaultMTU = 1500
+	// The smallest MTU of the virtual network: the minimum MTU of
+	// IPv6, which some networks behind VPNs come down to.
+	MinMTU = 1280
+	// The largest MTU of the virtual network, which the frames
+	// exchanged by the host switch and the vm switch must fit in.
+	MaxMTU = 4000
 )
 
 // Subnet represents all the network properties
@@ -46,27 +65,86 @@ type Subnet struct {
 	StaticDHCPLease map[string]string
 	StaticDNSHost   string
 	SubnetCIDR      string
+	// TapDeviceIP is the address leased to the tap device
+	// in the VM.
+	TapDeviceIP string
 }
 
 // ValidateSubnet validates a given IP CIDR format and
 // creates all the network addresses that are consumable
-// by the host switch process.
+// by the host switch process.  The subnet must be an IPv4
+// unicast subnet (typically a private one), with a prefix
+// of at most MaxSubnetPrefixLength; host bits are ignored,
+// so that 10.1.2.3/16 stands for 10.1.0.0/16.
 func ValidateSubnet(subnet string) (*Subnet, error) {
-	ip, _, err := net.ParseCIDR(subnet)
+	_, ipNet, err := net.ParseCIDR(subnet)
 	if err != nil {
 		return nil, fmt.Errorf("validating subnet: %w", err)
 	}
-	ipv4 := ip.To4()
+	network := ipNet.IP.To4()
+	if network == nil {
+		return nil, fmt.Errorf("validating subnet: %s is not an IPv4 subnet", subnet)
+	}
+	if network.IsUnspecified() || network.IsLoopback() || network.IsLinkLocalUnicast() || network.IsMulticast() {
+		return nil, fmt.Errorf("validating subnet: %s is not a unicast subnet", subnet)
+	}
+	ones, _ := ipNet.Mask.Size()
+	if ones > MaxSubnetPrefixLength {
+		return nil, fmt.Errorf("validating subnet: %s is too small, the prefix length must be at most %d", subnet, MaxSubnetPrefixLength)
+	}
+	tapDeviceIP := addressAt(network, staticDHCPOffset).String()
 	return &Subnet{
-		GatewayIP: gatewayIP(ipv4),
+		GatewayIP: addressAt(network, gatewayOffset).String(),
 		StaticDHCPLease: map[string]string{
-			TapDeviceIP(ipv4): TapDeviceMacAddr,
+			tapDeviceIP: TapDeviceMacAddr,
 		},
-		StaticDNSHost: staticDNSHost(ipv4),
-		SubnetCIDR:    subnet,
+		StaticDNSHost: addressAt(network, (1<<(32-ones))-2).String(),
+		SubnetCIDR:    ipNet.String(),
+		TapDeviceIP:   tapDeviceIP,
 	}, nil
 }
 
+// CheckHostNetworks returns an error if the subnet overlaps a
+// network the host has an address in, such as its LAN or a VPN,
+// as the host would no longer route traffic to that network
+// correctly.  Networks the host only reaches through a gateway
+// can't be detected.
+func CheckHostNetworks(subnet *Subnet) error {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("listing host network addresses: %w", err)
+	}
+	return CheckNetworks(subnet, addrs...)
+}
+
+// CheckNetworks returns an error if the subnet overlaps the network
+// of any of the given addresses, other than loopback and IPv6 ones.
+func CheckNetworks(subnet *Subnet, addrs ...net.Addr) error {
+	_, ipNet, err := net.ParseCIDR(subnet.SubnetCIDR)
+	if err != nil {
+		return fmt.Errorf("validating subnet: %w", err)
+	}
+	for _, addr := range addrs {
+		hostNet, ok := addr.(*net.IPNet)
+		if !ok || hostNet.IP.To4() == nil || hostNet.IP.IsLoopback() {
+			continue
+		}
+		if ipNet.Contains(hostNet.IP) || hostNet.Contains(ipNet.IP) {
+			return fmt.Errorf("subnet %s overlaps the network %s", subnet.SubnetCIDR, hostNet)
+		}
+	}
+	return nil
+}
+
+// ValidateMTU returns an error if the virtual network can't use the
+// MTU, which must be between MinMTU and MaxMTU.
+func ValidateMTU(mtu int) error {
+	if mtu < MinMTU || mtu > MaxMTU {
+		return fmt.Errorf("MTU %d is not between %d and %d", mtu, MinMTU, MaxMTU)
+	}
+	return nil
+}
+
 // SearchDomains reads the content of the /etc/resolv.conf when
 // supported by the platform and returns an array of search domains.
 func SearchDomains() []string {
@@ -112,21 +190,11 @@ func ParsePortForwarding(ipPorts []string) (map[string]string, error) {
 	return portForwards, nil
 }
 
-// TapDeviceIP returns the allocated IP address for
-// the Tap Device.
-func TapDeviceIP(ip net.IP) string {
-	// Tap device IP is always x.x.x.2
-	return net.IPv4(ip[0], ip[1], ip[2], staticDHCPLastByte).String()
-}
-
-func gatewayIP(ip net.IP) string {
-	// Gateway is always x.x.x.1
-	return net.IPv4(ip[0], ip[1], ip[2], gatewayLastByte).String()
-}
-
-func staticDNSHost(ip net.IP) string {
-	// Static DNS Host is always x.x.x.254
-	return net.IPv4(ip[0], ip[1], ip[2], staticHostLastByte).String()
+// addressAt returns the IPv4 address offset from the
+// given network address.
+func addressAt(network net.IP, offset int) net.IP {
+	ip := binary.BigEndian.Uint32(network.To4()) + uint32(offset)
+	return binary.BigEndian.AppendUint32(nil, ip)
 }
 
 func validateIPPort(ipPorts []string) error {
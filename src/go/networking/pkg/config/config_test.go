@@ -0,0 +1,144 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSubnet(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		subnet   string
+		expected Subnet
+	}{
+		{
+			subnet: DefaultSubnet,
+			expected: Subnet{
+				GatewayIP:       "192.168.127.1",
+				StaticDHCPLease: map[string]string{"192.168.127.2": TapDeviceMacAddr},
+				StaticDNSHost:   "192.168.127.254",
+				SubnetCIDR:      "192.168.127.0/24",
+				TapDeviceIP:     "192.168.127.2",
+			},
+		},
+		{
+			subnet: "10.200.0.0/16",
+			expected: Subnet{
+				GatewayIP:       "10.200.0.1",
+				StaticDHCPLease: map[string]string{"10.200.0.2": TapDeviceMacAddr},
+				StaticDNSHost:   "10.200.255.254",
+				SubnetCIDR:      "10.200.0.0/16",
+				TapDeviceIP:     "10.200.0.2",
+			},
+		},
+		{
+			subnet: "172.31.7.16/28",
+			expected: Subnet{
+				GatewayIP:       "172.31.7.17",
+				StaticDHCPLease: map[string]string{"172.31.7.18": TapDeviceMacAddr},
+				StaticDNSHost:   "172.31.7.30",
+				SubnetCIDR:      "172.31.7.16/28",
+				TapDeviceIP:     "172.31.7.18",
+			},
+		},
+		{
+			// The smallest subnet.
+			subnet: "192.168.200.248/29",
+			expected: Subnet{
+				GatewayIP:       "192.168.200.249",
+				StaticDHCPLease: map[string]string{"192.168.200.250": TapDeviceMacAddr},
+				StaticDNSHost:   "192.168.200.254",
+				SubnetCIDR:      "192.168.200.248/29",
+				TapDeviceIP:     "192.168.200.250",
+			},
+		},
+		{
+			// Host bits are ignored.
+			subnet: "10.1.2.3/22",
+			expected: Subnet{
+				GatewayIP:       "10.1.0.1",
+				StaticDHCPLease: map[string]string{"10.1.0.2": TapDeviceMacAddr},
+				StaticDNSHost:   "10.1.3.254",
+				SubnetCIDR:      "10.1.0.0/22",
+				TapDeviceIP:     "10.1.0.2",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.subnet, func(t *testing.T) {
+			t.Parallel()
+			subnet, err := ValidateSubnet(tc.subnet)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, *subnet)
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, subnet := range []string{
+			"",
+			"192.168.127.0",
+			"192.168.127.0/33",
+			"192.168.127.0/30",
+			"192.168.127.0/32",
+			"fd00::/64",
+			"0.0.0.0/8",
+			"127.0.0.0/24",
+			"169.254.0.0/16",
+			"224.0.0.0/24",
+			"not a subnet",
+		} {
+			_, err := ValidateSubnet(subnet)
+			assert.Error(t, err, "subnet %q", subnet)
+		}
+	})
+}
+
+func TestCheckNetworks(t *testing.T) {
+	t.Parallel()
+	addr := func(cidr string) net.Addr {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ipNet.IP = ip
+		return ipNet
+	}
+	subnet, err := ValidateSubnet("10.20.0.0/16")
+	require.NoError(t, err)
+
+	assert.NoError(t, CheckNetworks(subnet))
+	assert.NoError(t, CheckNetworks(subnet,
+		addr("127.0.0.1/8"),
+		addr("192.168.1.20/24"),
+		addr("10.21.0.5/16"),
+		addr("fd00::1/64"),
+	))
+	// A host address in the subnet, as on a smaller network.
+	assert.Error(t, CheckNetworks(subnet, addr("10.20.30.40/24")))
+	// The subnet is within a larger host network.
+	assert.Error(t, CheckNetworks(subnet, addr("10.1.2.3/8")))
+}
+
+func TestValidateMTU(t *testing.T) {
+	t.Parallel()
+	for _, mtu := range []int{MinMTU, 1400, DefaultMTU, MaxMTU} {
+		assert.NoError(t, ValidateMTU(mtu), "MTU %d", mtu)
+	}
+	for _, mtu := range []int{0, 576, MinMTU - 1, MaxMTU + 1, 9000} {
+		assert.Error(t, ValidateMTU(mtu), "MTU %d", mtu)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package control serves the status of the host switch on a local endpoint,
+// so that what the virtual network is doing can be inspected when
+// connectivity misbehaves: the forwarded ports and their counters, the size
+// of the connection table, the DHCP leases and the byte counters of the
+// interfaces.  The endpoint is a named pipe on Windows, and a unix socket
+// elsewhere; only the user running the switch can connect to it.
+//
+// Collecting a status copies the counters, which are only ever updated
+// atomically, and the tables, which are only locked while they are copied,
+// so that it never holds up the traffic.
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/lease"
+)
+
+// StatusPath is the path of the status on the endpoint.
+const StatusPath = "/status"
+
+// Status is the status of the host switch.
+type Status struct {
+	Forwards    []Forward     `json:"forwards"`
+	Connections Connections   `json:"connections"`
+	Leases      []lease.Lease `json:"leases"`
+	Interfaces  []Interface   `json:"interfaces"`
+}
+
+// Connections is the size of the connection table of the virtual network.
+type Connections struct {
+	// The number of TCP connections established, and of those connected,
+	// which includes the ones being closed.
+	TCPEstablished uint64 `json:"tcp-established"`
+	TCPConnected   uint64 `json:"tcp-connected"`
+	// The number of MAC addresses the switch sends frames to.
+	SwitchClients int `json:"switch-clients"`
+}
+
+// Interface holds the counters of an interface of the virtual network.
+type Interface struct {
+	Name string `json:"name"`
+	// What the interface is, for people reading the status.
+	Description   string `json:"description"`
+	BytesSent     uint64 `json:"bytes-sent"`
+	BytesReceived uint64 `json:"bytes-received"`
+	// The packet counters are zero for interfaces that don't count them.
+	PacketsSent     uint64 `json:"packets-sent"`
+	PacketsReceived uint64 `json:"packets-received"`
+}
+
+// Collector collects the status of the host switch.
+type Collector struct {
+	// The services of the virtual network, as returned by
+	// VirtualNetwork.ServicesMux.
+	services  http.Handler
+	forwarder *Forwarder
+	leases    *lease.Table
+}
+
+// NewCollector returns a collector of the status of the virtual network with
+// the given services, forwarding ports with forwarder, and keeping its leases
+// in the lease table.
+func NewCollector(services http.Handler, forwarder *Forwarder, leases *lease.Table) *Collector {
+	return &Collector{services: services, forwarder: forwarder, leases: leases}
+}
+
+// networkStats holds the parts of the statistics of the virtual network the
+// status reports.
+type networkStats struct {
+	// Sent to and received from the VM by the switch.
+	BytesSent     uint64
+	BytesReceived uint64
+	// Sent and received by the network stack of the gateway.
+	NICs struct {
+		Tx, Rx struct {
+			Bytes, Packets uint64
+		}
+	}
+	TCP struct {
+		CurrentEstablished, CurrentConnected uint64
+	}
+}
+
+// Status collects the status of the host switch.
+func (c *Collector) Status() (Status, error) {
+	var stats networkStats
+	if err := c.get("/stats", &stats); err != nil {
+		return Status{}, err
+	}
+	var cam map[string]int
+	if err := c.get("/cam", &cam); err != nil {
+		return Status{}, err
+	}
+	var current map[string]string
+	if err := c.get("/leases", &current); err != nil {
+		return Status{}, err
+	}
+	forwards, err := c.forwarder.Forwards()
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Forwards: forwards,
+		Connections: Connections{
+			TCPEstablished: stats.TCP.CurrentEstablished,
+			TCPConnected:   stats.TCP.CurrentConnected,
+			SwitchClients:  len(cam),
+		},
+		Leases: c.currentLeases(current),
+		Interfaces: []Interface{
+			{
+				Name:          "vm",
+				Description:   "the link to the VM",
+				BytesSent:     stats.BytesSent,
+				BytesReceived: stats.BytesReceived,
+			},
+			{
+				Name:            "gateway",
+				Description:     "the network stack of the gateway",
+				BytesSent:       stats.NICs.Tx.Bytes,
+				BytesReceived:   stats.NICs.Rx.Bytes,
+				PacketsSent:     stats.NICs.Tx.Packets,
+				PacketsReceived: stats.NICs.Rx.Packets,
+			},
+		},
+	}, nil
+}
+
+// currentLeases returns the leases the DHCP server holds, given as the address
+// of each lease mapped to the MAC address it is leased to, ordered by address.
+// The leases of the gateway, which is never handed out, are left out.
+func (c *Collector) currentLeases(current map[string]string) []lease.Lease {
+	gateway := c.leases.Gateway()
+	leases := make([]lease.Lease, 0, len(current))
+	for ip, mac := range current {
+		if ip == gateway {
+			continue
+		}
+		item := lease.Lease{IP: ip, MAC: mac}
+		if saved, ok := c.leases.Lookup(mac); ok && saved.IP == ip {
+			item.Reserved = saved.Reserved
+		}
+		leases = append(leases, item)
+	}
+	slices.SortFunc(leases, func(a, b lease.Lease) int {
+		return slices.Compare(net.ParseIP(a.IP).To16(), net.ParseIP(b.IP).To16())
+	})
+	return leases
+}
+
+// get decodes what the services of the virtual network serve on path into
+// result.
+func (c *Collector) get(path string, result any) error {
+	recorder := httptest.NewRecorder()
+	c.services.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	if recorder.Code != http.StatusOK {
+		return fmt.Errorf("unexpected status %d getting %s: %s", recorder.Code, path, recorder.Body.String())
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// Mux returns the handler serving the status.
+func (c *Collector) Mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatusPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "get only", http.StatusMethodNotAllowed)
+			return
+		}
+		status, err := c.Status()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	})
+	return mux
+}
@@ -0,0 +1,351 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/lease"
+)
+
+// testSwitch is a virtual network with a forwarder and the control endpoint,
+// as the host switch sets them up, except that the forwarder dials the host
+// instead of the VM, which the tests don't have.
+type testSwitch struct {
+	// Serves the port forwarding API, as the gateway of the virtual network
+	// does for the guest agent.
+	api    http.Handler
+	client *http.Client
+	// Rebinds all the forwarded ports, as the tests only have the loopback
+	// addresses to forward.
+	rebinder *Rebinder
+}
+
+func newTestSwitch(t *testing.T) *testSwitch {
+	t.Helper()
+	subnet, err := config.ValidateSubnet(config.DefaultSubnet)
+	require.NoError(t, err)
+	leases, _, err := lease.Load("", subnet)
+	require.NoError(t, err)
+	vn, err := virtualnetwork.New(&types.Configuration{
+		MTU:               config.DefaultMTU,
+		Subnet:            subnet.SubnetCIDR,
+		GatewayIP:         subnet.GatewayIP,
+		GatewayMacAddress: "5a:94:ef:e4:0c:dd",
+		DHCPStaticLeases:  leases.StaticLeases(),
+		Protocol:          types.HyperKitProtocol,
+	})
+	require.NoError(t, err)
+
+	var dialer net.Dialer
+	forwarder := NewForwarder(func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}, vn.Mux())
+	t.Cleanup(func() { _ = forwarder.Close() })
+
+	endpoint := testEndpoint(t)
+	listener, err := Listen(t.Context(), endpoint)
+	require.NoError(t, err)
+	server := &http.Server{Handler: NewCollector(vn.ServicesMux(), forwarder, leases).Mux(), ReadHeaderTimeout: time.Second}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	rebinder := NewRebinder(vn.Mux(), forwarder)
+	rebinder.rebindable = func(string) bool { return true }
+
+	return &testSwitch{
+		api:      forwarder,
+		rebinder: rebinder,
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return Dial(ctx, endpoint)
+			},
+		}},
+	}
+}
+
+// testEndpoint returns the path of a control endpoint for the test.
+func testEndpoint(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf(`\\.\pipe\rancher_desktop_control_test_%d_%d`, os.Getpid(), time.Now().UnixNano())
+	}
+	// Unix socket paths are limited to about a hundred bytes, which the
+	// temporary directory of a test with a long name may exceed.
+	dir, err := os.MkdirTemp("", "control")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "control.sock")
+}
+
+// status gets the status from the control endpoint.
+func (s *testSwitch) status(t *testing.T) Status {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://control"+StatusPath, http.NoBody)
+	require.NoError(t, err)
+	resp, err := s.client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var status Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status
+}
+
+// post calls the port forwarding API.
+func (s *testSwitch) post(t *testing.T, path string, body any) int {
+	t.Helper()
+	contents, err := json.Marshal(body)
+	require.NoError(t, err)
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "http://192.168.127.1"+path, bytes.NewReader(contents))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.168.127.2:40000"
+	recorder := httptest.NewRecorder()
+	s.api.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+// forward returns the status of the forward of the local address.
+func (status Status) forward(protocol, local string) (Forward, bool) {
+	for _, fw := range status.Forwards {
+		if fw.Protocol == protocol && fw.Local == local {
+			return fw, true
+		}
+	}
+	return Forward{}, false
+}
+
+// freeAddress returns a local address that nothing listens on.
+func freeAddress(t *testing.T, network string) string {
+	t.Helper()
+	var listenConfig net.ListenConfig
+	if network == "udp" {
+		conn, err := listenConfig.ListenPacket(t.Context(), network, "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}
+	listener, err := listenConfig.Listen(t.Context(), network, "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// echoServer returns the address of a server sending back what it receives.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(t.Context(), "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+	t.Run("counts the traffic of forwarded ports", func(t *testing.T) {
+		t.Parallel()
+		s := newTestSwitch(t)
+		local := freeAddress(t, "tcp")
+		remote := echoServer(t)
+		require.Equal(t, http.StatusOK, s.post(t, forwarderExposePath, types.ExposeRequest{Local: local, Remote: remote}))
+		fw, ok := s.status(t).forward("tcp", local)
+		require.True(t, ok)
+		assert.Equal(t, Forward{Protocol: "tcp", Local: local, Remote: remote, Counted: true}, fw)
+
+		payload := bytes.Repeat([]byte("rancher desktop "), 4096)
+		for range 2 {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(t.Context(), "tcp", local)
+			require.NoError(t, err)
+			_, err = conn.Write(payload)
+			require.NoError(t, err)
+			require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+			echoed, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			assert.Len(t, echoed, len(payload))
+			require.NoError(t, conn.Close())
+		}
+		// The counters are updated as the data is copied, but the last
+		// connection may not be closed on the forwarder's side yet.
+		want := Forward{
+			Protocol:    "tcp",
+			Local:       local,
+			Remote:      remote,
+			Counted:     true,
+			Connections: 2,
+			BytesToVM:   uint64(2 * len(payload)),
+			BytesFromVM: uint64(2 * len(payload)),
+		}
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			fw, _ := s.status(t).forward("tcp", local)
+			assert.Equal(c, want, fw)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+	t.Run("counts connections to the VM that fail", func(t *testing.T) {
+		t.Parallel()
+		s := newTestSwitch(t)
+		local := freeAddress(t, "tcp")
+		require.Equal(t, http.StatusOK, s.post(t, forwarderExposePath, types.ExposeRequest{Local: local, Remote: freeAddress(t, "tcp")}))
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(t.Context(), "tcp", local)
+		require.NoError(t, err)
+		// The forwarder closes the connection when it fails to dial.
+		_, err = conn.Read(make([]byte, 1))
+		assert.Error(t, err)
+		require.NoError(t, conn.Close())
+		fw, ok := s.status(t).forward("tcp", local)
+		require.True(t, ok)
+		assert.Equal(t, uint64(0), fw.Connections)
+		assert.Equal(t, uint64(1), fw.FailedConnections)
+	})
+	t.Run("lists the ports the virtual network forwards", func(t *testing.T) {
+		t.Parallel()
+		s := newTestSwitch(t)
+		local := freeAddress(t, "udp")
+		remote := "192.168.127.2:53"
+		require.Equal(t, http.StatusOK, s.post(t, forwarderExposePath, types.ExposeRequest{Local: local, Remote: remote, Protocol: types.UDP}))
+		fw, ok := s.status(t).forward("udp", local)
+		require.True(t, ok)
+		assert.Equal(t, Forward{Protocol: "udp", Local: local, Remote: remote}, fw)
+		require.Equal(t, http.StatusOK, s.post(t, forwarderUnexposePath, types.UnexposeRequest{Local: local, Protocol: types.UDP}))
+		assert.Empty(t, s.status(t).Forwards)
+	})
+	t.Run("stops listing unexposed ports", func(t *testing.T) {
+		t.Parallel()
+		s := newTestSwitch(t)
+		local := freeAddress(t, "tcp")
+		require.Equal(t, http.StatusOK, s.post(t, forwarderExposePath, types.ExposeRequest{Local: local, Remote: ":8080"}))
+		fw, ok := s.status(t).forward("tcp", local)
+		require.True(t, ok)
+		// The remote address without a host is on the requester.
+		assert.Equal(t, "192.168.127.2:8080", fw.Remote)
+		require.Equal(t, http.StatusOK, s.post(t, forwarderUnexposePath, types.UnexposeRequest{Local: local}))
+		assert.Empty(t, s.status(t).Forwards)
+		assert.Equal(t, http.StatusInternalServerError, s.post(t, forwarderUnexposePath, types.UnexposeRequest{Local: local}))
+	})
+	t.Run("reports the leases and interfaces", func(t *testing.T) {
+		t.Parallel()
+		status := newTestSwitch(t).status(t)
+		assert.Equal(t, []lease.Lease{{IP: "192.168.127.2", MAC: config.TapDeviceMacAddr, Reserved: true}}, status.Leases)
+		var names []string
+		for _, iface := range status.Interfaces {
+			names = append(names, iface.Name)
+		}
+		assert.Equal(t, []string{"vm", "gateway"}, names)
+	})
+}
+
+// echo sends payload through the forwarded port of the local address, and
+// returns what was sent back.
+func echo(t *testing.T, local string, payload []byte) []byte {
+	t.Helper()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(t.Context(), "tcp", local)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	echoed, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return echoed
+}
+
+func TestRebind(t *testing.T) {
+	t.Parallel()
+	t.Run("listens again on the forwarded ports", func(t *testing.T) {
+		t.Parallel()
+		s := newTestSwitch(t)
+		tcpLocal := freeAddress(t, "tcp")
+		require.Equal(t, http.StatusOK, s.post(t, forwarderExposePath, types.ExposeRequest{Local: tcpLocal, Remote: echoServer(t)}))
+		udpLocal := freeAddress(t, "udp")
+		require.Equal(t, http.StatusOK, s.post(t, forwarderExposePath, types.ExposeRequest{Local: udpLocal, Remote: "192.168.127.2:53", Protocol: types.UDP}))
+		assert.Equal(t, []byte("before"), echo(t, tcpLocal, []byte("before")))
+
+		assert.Equal(t, RebindResult{Rebound: 2}, s.rebinder.Rebind(t.Context()))
+		// Rebinding again is harmless.
+		assert.Equal(t, RebindResult{Rebound: 2}, s.rebinder.Rebind(t.Context()))
+
+		assert.Equal(t, []byte("after"), echo(t, tcpLocal, []byte("after")))
+		status := s.status(t)
+		// The counters are kept.
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			fw, _ := s.status(t).forward("tcp", tcpLocal)
+			assert.Equal(c, uint64(2), fw.Connections)
+		}, 5*time.Second, 10*time.Millisecond)
+		_, ok := status.forward("udp", udpLocal)
+		assert.True(t, ok, "the UDP port is still forwarded")
+		// A port unexposed after being rebound is no longer listened on.
+		require.Equal(t, http.StatusOK, s.post(t, forwarderUnexposePath, types.UnexposeRequest{Local: tcpLocal}))
+		var dialer net.Dialer
+		_, err := dialer.DialContext(t.Context(), "tcp", tcpLocal)
+		assert.Error(t, err)
+	})
+	t.Run("leaves the loopback and unspecified addresses alone", func(t *testing.T) {
+		t.Parallel()
+		assert.False(t, hostBound("127.0.0.1:8080"))
+		assert.False(t, hostBound("[::1]:8080"))
+		assert.False(t, hostBound("0.0.0.0:8080"))
+		assert.False(t, hostBound(":8080"))
+		assert.True(t, hostBound("172.20.0.1:8080"))
+		assert.True(t, hostBound("[fe80::1]:8080"))
+	})
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("named pipes have no permission bits")
+	}
+	endpoint := testEndpoint(t)
+	// A socket left behind is replaced.
+	require.NoError(t, os.WriteFile(endpoint, nil, 0o644))
+	listener, err := Listen(t.Context(), endpoint)
+	require.NoError(t, err)
+	defer listener.Close()
+	info, err := os.Stat(endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
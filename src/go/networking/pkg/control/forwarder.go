@@ -0,0 +1,401 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+// The paths of the port forwarding API of gvisor-tap-vsock, which the guest
+// agent calls to forward the ports of containers.
+const (
+	forwarderAllPath      = "/services/forwarder/all"
+	forwarderExposePath   = "/services/forwarder/expose"
+	forwarderUnexposePath = "/services/forwarder/unexpose"
+)
+
+// dialTimeout is how long connecting to the VM for a forwarded connection
+// may take.
+const dialTimeout = 5 * time.Second
+
+// Forwarder forwards ports of the host to the VM, serving the port forwarding
+// API of gvisor-tap-vsock in its place.  It forwards TCP ports itself, so
+// that it can count the connections and bytes of each port; the other
+// protocols, and the ports forwarded at startup, are left to the services of
+// the virtual network, which don't count them.
+//
+// The counters are only ever updated atomically, so that copying data never
+// waits for a status to be collected.
+type Forwarder struct {
+	// Dials an address of the virtual network.
+	dial func(ctx context.Context, addr string) (net.Conn, error)
+	// The services of the virtual network.
+	services http.Handler
+	mutex    sync.Mutex
+	// The TCP ports forwarded, by local address.
+	forwards map[string]*forward
+}
+
+// forward is a TCP port forwarded by a Forwarder.
+type forward struct {
+	local    string
+	remote   string
+	listener net.Listener
+	// The number of connections accepted, and of those still open.
+	connections atomic.Uint64
+	active      atomic.Int64
+	// The number of connections that could not be forwarded, as the VM
+	// could not be dialed.
+	failures atomic.Uint64
+	// The number of bytes copied to and from the VM.
+	toVM   atomic.Uint64
+	fromVM atomic.Uint64
+}
+
+// Forward is the status of a forwarded port.
+type Forward struct {
+	Protocol string `json:"protocol"`
+	Local    string `json:"local"`
+	Remote   string `json:"remote"`
+	// Whether the connections and bytes of the port are counted; the other
+	// counters are zero if not.
+	Counted           bool   `json:"counted"`
+	Connections       uint64 `json:"connections"`
+	ActiveConnections int64  `json:"active-connections"`
+	FailedConnections uint64 `json:"failed-connections"`
+	BytesToVM         uint64 `json:"bytes-to-vm"`
+	BytesFromVM       uint64 `json:"bytes-from-vm"`
+}
+
+// NewForwarder returns a forwarder dialing the VM with dial, and handing the
+// requests it doesn't handle itself to services.
+func NewForwarder(dial func(ctx context.Context, addr string) (net.Conn, error), services http.Handler) *Forwarder {
+	return &Forwarder{
+		dial:     dial,
+		services: services,
+		forwards: map[string]*forward{},
+	}
+}
+
+// Expose forwards the local TCP address to the remote address of the VM.
+func (f *Forwarder) Expose(ctx context.Context, local, remote string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.forwards[local]; ok {
+		return errors.New("proxy already running")
+	}
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", local)
+	if err != nil {
+		return err
+	}
+	fw := &forward{local: local, remote: remote, listener: listener}
+	f.forwards[local] = fw
+	go f.accept(fw, listener)
+	return nil
+}
+
+// Unexpose stops forwarding the local TCP address.  Connections already
+// forwarded are left open.
+func (f *Forwarder) Unexpose(local string) error {
+	f.mutex.Lock()
+	fw, ok := f.forwards[local]
+	delete(f.forwards, local)
+	f.mutex.Unlock()
+	if !ok {
+		return errors.New("proxy not found")
+	}
+	// The listener of a port that failed to be rebound is closed already.
+	if err := fw.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops forwarding all the ports.
+func (f *Forwarder) Close() error {
+	f.mutex.Lock()
+	forwards := f.forwards
+	f.forwards = map[string]*forward{}
+	f.mutex.Unlock()
+	var errs []error
+	for _, fw := range forwards {
+		if err := fw.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rebind listens again on the forwarded ports whose local address rebindable
+// accepts, keeping their counters and the connections already forwarded.  A
+// port that can't be listened on again, as when its address is not back yet,
+// is left without a listener until the next rebind.
+func (f *Forwarder) rebind(ctx context.Context, rebindable func(local string) bool) (rebound, failed int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, fw := range f.forwards {
+		if !rebindable(fw.local) {
+			continue
+		}
+		_ = fw.listener.Close()
+		var listenConfig net.ListenConfig
+		listener, err := listenConfig.Listen(ctx, "tcp", fw.local)
+		if err != nil {
+			logrus.Warnf("failed to listen on %s again: %s", fw.local, err)
+			failed++
+			continue
+		}
+		fw.listener = listener
+		go f.accept(fw, listener)
+		rebound++
+	}
+	return rebound, failed
+}
+
+// accept forwards the connections listener accepts for fw, until it is
+// closed.
+func (f *Forwarder) accept(fw *forward, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logrus.Errorf("failed to accept connection on %s: %s", fw.local, err)
+			}
+			return
+		}
+		go f.handle(fw, conn)
+	}
+}
+
+// handle copies the data of a connection to the forwarded port to the VM and
+// back, until both sides have closed it.
+func (f *Forwarder) handle(fw *forward, conn net.Conn) {
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	upstream, err := f.dial(ctx, fw.remote)
+	cancel()
+	if err != nil {
+		fw.failures.Add(1)
+		logrus.Errorf("failed to dial %s for %s: %s", fw.remote, fw.local, err)
+		return
+	}
+	defer upstream.Close()
+	fw.connections.Add(1)
+	fw.active.Add(1)
+	defer fw.active.Add(-1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		copyCounted(upstream, conn, &fw.toVM)
+	}()
+	copyCounted(conn, upstream, &fw.fromVM)
+	<-done
+}
+
+// copyCounted copies from src to dst until src is closed, adding the bytes
+// copied to count as they are written, then closes the write side of dst so
+// that its peer sees the end of the data.
+func copyCounted(dst, src net.Conn, count *atomic.Uint64) {
+	if _, err := io.Copy(&countingWriter{Writer: dst, count: count}, src); err != nil && !errors.Is(err, net.ErrClosed) {
+		logrus.Debugf("error copying from %s to %s: %s", src.RemoteAddr(), dst.RemoteAddr(), err)
+	}
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = closer.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
+
+// countingWriter adds the bytes written to its counter.
+type countingWriter struct {
+	io.Writer
+	count *atomic.Uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count.Add(uint64(n))
+	return n, err
+}
+
+// Forwards returns the status of the forwarded ports, ordered by local
+// address and protocol.
+func (f *Forwarder) Forwards() ([]Forward, error) {
+	f.mutex.Lock()
+	forwards := make([]*forward, 0, len(f.forwards))
+	for _, fw := range f.forwards {
+		forwards = append(forwards, fw)
+	}
+	f.mutex.Unlock()
+
+	result := make([]Forward, 0, len(forwards))
+	for _, fw := range forwards {
+		result = append(result, Forward{
+			Protocol:          string(types.TCP),
+			Local:             fw.local,
+			Remote:            fw.remote,
+			Counted:           true,
+			Connections:       fw.connections.Load(),
+			ActiveConnections: fw.active.Load(),
+			FailedConnections: fw.failures.Load(),
+			BytesToVM:         fw.toVM.Load(),
+			BytesFromVM:       fw.fromVM.Load(),
+		})
+	}
+	others, err := servicesForwards(f.services)
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, others...)
+	slices.SortFunc(result, func(a, b Forward) int {
+		if c := strings.Compare(a.Local, b.Local); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Protocol, b.Protocol)
+	})
+	return result, nil
+}
+
+// servicesForwards returns the ports the services of the virtual network
+// forward.
+func servicesForwards(services http.Handler) ([]Forward, error) {
+	recorder := httptest.NewRecorder()
+	services.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, forwarderAllPath, http.NoBody))
+	if recorder.Code != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d listing forwarded ports: %s", recorder.Code, recorder.Body.String())
+	}
+	var forwards []Forward
+	if err := json.Unmarshal(recorder.Body.Bytes(), &forwards); err != nil {
+		return nil, fmt.Errorf("failed to parse forwarded ports: %w", err)
+	}
+	return forwards, nil
+}
+
+// ServeHTTP serves the port forwarding API.
+func (f *Forwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case forwarderAllPath:
+		forwards, err := f.Forwards()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(forwards)
+	case forwarderExposePath:
+		f.serveExpose(w, r)
+	case forwarderUnexposePath:
+		f.serveUnexpose(w, r)
+	default:
+		f.services.ServeHTTP(w, r)
+	}
+}
+
+func (f *Forwarder) serveExpose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "post only", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req types.ExposeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Protocol != "" && req.Protocol != types.TCP {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		f.services.ServeHTTP(w, r)
+		return
+	}
+	remote, err := remoteAddress(req.Remote, r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := f.Expose(r.Context(), req.Local, remote); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *Forwarder) serveUnexpose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "post only", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req types.UnexposeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mutex.Lock()
+	_, ours := f.forwards[req.Local]
+	f.mutex.Unlock()
+	if !ours || (req.Protocol != "" && req.Protocol != types.TCP) {
+		// The ports forwarded at startup are the services' too.
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		f.services.ServeHTTP(w, r)
+		return
+	}
+	if err := f.Unexpose(req.Local); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// remoteAddress returns the address to forward to: remote, or, if it has no
+// host, the port of remote on the host the request came from, as
+// gvisor-tap-vsock does.
+func remoteAddress(remote, requester string) (string, error) {
+	host, port, err := net.SplitHostPort(remote)
+	if err != nil {
+		return "", err
+	}
+	if host != "" {
+		return remote, nil
+	}
+	host, _, err = net.SplitHostPort(requester)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
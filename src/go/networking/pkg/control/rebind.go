@@ -0,0 +1,144 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Rebinder listens again on the forwarded ports bound to an address of the
+// host, whose listeners stop getting connections when the adapter holding the
+// address is recreated, even once the address is back.  Ports bound to the
+// loopback or unspecified addresses are not affected, and are left alone.
+//
+// Rebinding is idempotent: ports that can't be listened on again are retried
+// by the next Rebind, and rebinding ports that were fine only replaces their
+// listeners.  The guest agent needs not be told, as the forwards it asked for
+// are kept.
+type Rebinder struct {
+	services  http.Handler
+	forwarder *Forwarder
+	// Whether the forward of a local address is rebound.
+	rebindable func(local string) bool
+	mutex      sync.Mutex
+	// The ports forwarded by the services that could not be exposed again
+	// once unexposed, by protocol and local address.
+	lost map[string]types.ExposeRequest
+}
+
+// RebindResult is the result of Rebinder.Rebind.
+type RebindResult struct {
+	// The number of ports listened on again.
+	Rebound int
+	// The number of ports that could not be listened on again, and are
+	// retried by the next rebind.
+	Failed int
+}
+
+func (r RebindResult) String() string {
+	if r.Failed == 0 {
+		return fmt.Sprintf("rebound %d forwarded ports", r.Rebound)
+	}
+	return fmt.Sprintf("rebound %d forwarded ports, %d failed and will be retried", r.Rebound, r.Failed)
+}
+
+// NewRebinder returns a rebinder of the ports forwarded by the services of
+// the virtual network, and by forwarder, which may be nil if there is none.
+func NewRebinder(services http.Handler, forwarder *Forwarder) *Rebinder {
+	return &Rebinder{
+		services:   services,
+		forwarder:  forwarder,
+		rebindable: hostBound,
+		lost:       map[string]types.ExposeRequest{},
+	}
+}
+
+// hostBound returns whether the local address is an address of the host,
+// rather than a loopback or unspecified address.
+func hostBound(local string) bool {
+	host, _, err := net.SplitHostPort(local)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified()
+}
+
+// Rebind listens again on the forwarded ports bound to an address of the
+// host.
+func (r *Rebinder) Rebind(ctx context.Context) RebindResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var result RebindResult
+	if r.forwarder != nil {
+		result.Rebound, result.Failed = r.forwarder.rebind(ctx, r.rebindable)
+	}
+	forwards, err := servicesForwards(r.services)
+	if err != nil {
+		logrus.Errorf("failed to rebind forwarded ports: %s", err)
+		return result
+	}
+	requests := make(map[string]types.ExposeRequest, len(r.lost))
+	for key, req := range r.lost {
+		requests[key] = req
+	}
+	for _, fw := range forwards {
+		if !r.rebindable(fw.Local) {
+			continue
+		}
+		req := types.ExposeRequest{Local: fw.Local, Remote: fw.Remote, Protocol: types.TransportProtocol(fw.Protocol)}
+		// gvisor-tap-vsock only listens again on ports it doesn't forward.
+		if err := r.post(forwarderUnexposePath, types.UnexposeRequest{Local: req.Local, Protocol: req.Protocol}); err != nil {
+			logrus.Warnf("failed to stop forwarding %s to rebind it: %s", req.Local, err)
+			result.Failed++
+			continue
+		}
+		requests[fw.Protocol+"/"+fw.Local] = req
+	}
+	for key, req := range requests {
+		if err := r.post(forwarderExposePath, req); err != nil {
+			logrus.Warnf("failed to forward %s again: %s", req.Local, err)
+			r.lost[key] = req
+			result.Failed++
+			continue
+		}
+		delete(r.lost, key)
+		result.Rebound++
+	}
+	return result
+}
+
+// post calls the port forwarding API of the services.
+func (r *Rebinder) post(path string, body any) error {
+	contents, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	recorder := httptest.NewRecorder()
+	r.services.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(contents)))
+	if recorder.Code != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", recorder.Code, bytes.TrimSpace(recorder.Body.Bytes()))
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lease keeps the DHCP leases of the virtual network across restarts
+// of the host switch.  The DHCP server of the switch only knows the leases it
+// handed out since it started, so without them a client could get another
+// address each time; the table is loaded at startup and given to the server
+// as static leases, and saved as leases are handed out.
+//
+// Some addresses are reserved for a MAC address, such as that of the tap
+// device of the Rancher Desktop VM.  A reservation always wins: a lease of the
+// reserved address to another MAC address, or of another address to the
+// reserved MAC address, is dropped in its favour.
+package lease
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+)
+
+// Lease is an address handed out to a MAC address.
+type Lease struct {
+	IP  string `json:"ip"`
+	MAC string `json:"mac"`
+	// Whether the address is reserved for the MAC address; see
+	// config.Subnet.StaticDHCPLease.
+	Reserved bool `json:"reserved,omitempty"`
+}
+
+// file is the contents of a lease file.
+type file struct {
+	// The subnet the leases are in; the leases of another subnet are
+	// dropped when it changes.
+	Subnet string  `json:"subnet"`
+	Leases []Lease `json:"leases"`
+}
+
+// Table is the lease table of a subnet.  It is safe for concurrent use.
+type Table struct {
+	// The file the table is saved to; empty if it is not saved.
+	path   string
+	subnet *net.IPNet
+	// The gateway, which the DHCP server holds a lease for, but never
+	// hands out.
+	gateway string
+	mutex   sync.Mutex
+	// The leases, by address.
+	leases map[string]Lease
+}
+
+// Load reads the lease table of the subnet from path, and applies the
+// reservations of the subnet to it.  A missing file is an empty table, and
+// so is a file for another subnet; leases outside of the subnet are dropped.
+// If path is empty, the table only holds the reservations, and is never saved.
+// The conflicts resolved in favour of the reservations are returned, so that
+// they can be logged.
+func Load(path string, subnet *config.Subnet) (*Table, []Lease, error) {
+	_, ipNet, err := net.ParseCIDR(subnet.SubnetCIDR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing subnet: %w", err)
+	}
+	table := &Table{
+		path:    path,
+		subnet:  ipNet,
+		gateway: subnet.GatewayIP,
+		leases:  map[string]Lease{},
+	}
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("reading lease file: %w", err)
+		}
+		var saved file
+		if err == nil {
+			if err := json.Unmarshal(contents, &saved); err != nil {
+				return nil, nil, fmt.Errorf("parsing lease file %s: %w", path, err)
+			}
+		}
+		if saved.Subnet == subnet.SubnetCIDR {
+			for _, lease := range saved.Leases {
+				// Reservations are only kept while they are configured.
+				if !lease.Reserved && table.valid(lease) {
+					table.leases[lease.IP] = lease
+				}
+			}
+		}
+	}
+	var conflicts []Lease
+	for ip, mac := range subnet.StaticDHCPLease {
+		conflicts = append(conflicts, table.reserve(Lease{IP: ip, MAC: mac, Reserved: true})...)
+	}
+	return table, conflicts, nil
+}
+
+// valid returns whether the lease can be handed out in the subnet.
+func (table *Table) valid(lease Lease) bool {
+	ip := net.ParseIP(lease.IP)
+	if ip == nil || !table.subnet.Contains(ip) || lease.IP == table.gateway {
+		return false
+	}
+	_, err := net.ParseMAC(lease.MAC)
+	return err == nil
+}
+
+// reserve adds the reservation, dropping the leases conflicting with it, which
+// are returned.  The mutex must be held, or the table not shared yet.
+func (table *Table) reserve(reservation Lease) []Lease {
+	var conflicts []Lease
+	for ip, lease := range table.leases {
+		if (ip == reservation.IP) != sameMAC(lease.MAC, reservation.MAC) {
+			conflicts = append(conflicts, lease)
+			delete(table.leases, ip)
+		}
+	}
+	table.leases[reservation.IP] = reservation
+	return conflicts
+}
+
+// sameMAC returns whether the two MAC addresses are the same, whichever way
+// they are written.
+func sameMAC(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// StaticLeases returns the leases, as the address of each lease mapped to the
+// MAC address it is leased to, for types.Configuration.DHCPStaticLeases.
+func (table *Table) StaticLeases() map[string]string {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	leases := make(map[string]string, len(table.leases))
+	for ip, lease := range table.leases {
+		leases[ip] = lease.MAC
+	}
+	return leases
+}
+
+// Gateway returns the address of the gateway, which the DHCP server holds a
+// lease for, but never hands out.
+func (table *Table) Gateway() string {
+	return table.gateway
+}
+
+// Lookup returns the address leased to the MAC address.
+func (table *Table) Lookup(mac string) (Lease, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	for _, lease := range table.leases {
+		if sameMAC(lease.MAC, mac) {
+			return lease, true
+		}
+	}
+	return Lease{}, false
+}
+
+// Update adds the leases the DHCP server holds, given as the address of each
+// lease mapped to the MAC address it is leased to, and returns whether the
+// table changed.  Leases that are no longer held are kept, so that their
+// clients get the same address when they come back.  Leases conflicting with
+// a reservation are not added, and are returned: the reservation is given to
+// the DHCP server again when the switch restarts, and the client holding the
+// conflicting lease gets another address when it renews it.
+func (table *Table) Update(current map[string]string) (bool, []Lease) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	changed := false
+	var conflicts []Lease
+	for ip, mac := range current {
+		lease := Lease{IP: ip, MAC: mac}
+		if !table.valid(lease) {
+			continue
+		}
+		if existing, ok := table.leases[ip]; ok && sameMAC(existing.MAC, mac) {
+			continue
+		}
+		if table.conflicts(lease) {
+			conflicts = append(conflicts, lease)
+			continue
+		}
+		// A client leasing a new address gives up its old one.
+		for oldIP, old := range table.leases {
+			if sameMAC(old.MAC, mac) {
+				delete(table.leases, oldIP)
+			}
+		}
+		table.leases[ip] = lease
+		changed = true
+	}
+	return changed, conflicts
+}
+
+// conflicts returns whether the lease conflicts with a reservation.  The mutex
+// must be held.
+func (table *Table) conflicts(lease Lease) bool {
+	for ip, existing := range table.leases {
+		if existing.Reserved && (ip == lease.IP) != sameMAC(existing.MAC, lease.MAC) {
+			return true
+		}
+	}
+	return false
+}
+
+// Leases returns the leases, ordered by address.
+func (table *Table) Leases() []Lease {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	return table.sortedLeases()
+}
+
+// sortedLeases returns the leases ordered by address.  The mutex must be held.
+func (table *Table) sortedLeases() []Lease {
+	leases := make([]Lease, 0, len(table.leases))
+	for _, lease := range table.leases {
+		leases = append(leases, lease)
+	}
+	slices.SortFunc(leases, func(a, b Lease) int {
+		return slices.Compare(net.ParseIP(a.IP).To16(), net.ParseIP(b.IP).To16())
+	})
+	return leases
+}
+
+// Save writes the table to its file, replacing it, so that a failure never
+// leaves a partly written file behind.  Tables loaded without a file are not
+// saved.
+func (table *Table) Save() error {
+	if table.path == "" {
+		return nil
+	}
+	table.mutex.Lock()
+	contents, err := json.MarshalIndent(file{
+		Subnet: table.subnet.String(),
+		Leases: table.sortedLeases(),
+	}, "", "  ")
+	table.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(table.path), 0o755); err != nil {
+		return fmt.Errorf("creating lease file directory: %w", err)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(table.path), filepath.Base(table.path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing lease file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(append(contents, '\n'))
+	if err = errors.Join(err, temp.Close()); err != nil {
+		return fmt.Errorf("writing lease file: %w", err)
+	}
+	if err := os.Rename(temp.Name(), table.path); err != nil {
+		return fmt.Errorf("writing lease file: %w", err)
+	}
+	return nil
+}
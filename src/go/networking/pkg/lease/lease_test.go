@@ -0,0 +1,186 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lease
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+)
+
+const otherMAC = "02:00:00:00:00:01"
+
+func defaultSubnet(t *testing.T) *config.Subnet {
+	t.Helper()
+	subnet, err := config.ValidateSubnet(config.DefaultSubnet)
+	require.NoError(t, err)
+	return subnet
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	t.Run("reserves the address of the tap device", func(t *testing.T) {
+		t.Parallel()
+		table, conflicts, err := Load(filepath.Join(t.TempDir(), "leases.json"), defaultSubnet(t))
+		require.NoError(t, err)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, map[string]string{"192.168.127.2": config.TapDeviceMacAddr}, table.StaticLeases())
+		lease, ok := table.Lookup(config.TapDeviceMacAddr)
+		assert.True(t, ok)
+		assert.Equal(t, Lease{IP: "192.168.127.2", MAC: config.TapDeviceMacAddr, Reserved: true}, lease)
+	})
+	t.Run("works without a file", func(t *testing.T) {
+		t.Parallel()
+		table, _, err := Load("", defaultSubnet(t))
+		require.NoError(t, err)
+		changed, _ := table.Update(map[string]string{"192.168.127.3": otherMAC})
+		assert.True(t, changed)
+		assert.NoError(t, table.Save())
+	})
+	t.Run("fails on a corrupt file", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "leases.json")
+		require.NoError(t, os.WriteFile(path, []byte("not json"), 0o644))
+		_, _, err := Load(path, defaultSubnet(t))
+		assert.Error(t, err)
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	t.Parallel()
+	t.Run("keeps leases across restarts", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "leases.json")
+		table, _, err := Load(path, defaultSubnet(t))
+		require.NoError(t, err)
+		changed, conflicts := table.Update(map[string]string{
+			"192.168.127.1": "5a:94:ef:e4:0c:dd", // The gateway is never saved.
+			"192.168.127.2": config.TapDeviceMacAddr,
+			"192.168.127.3": otherMAC,
+		})
+		assert.True(t, changed)
+		assert.Empty(t, conflicts)
+		require.NoError(t, table.Save())
+
+		reloaded, conflicts, err := Load(path, defaultSubnet(t))
+		require.NoError(t, err)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, []Lease{
+			{IP: "192.168.127.2", MAC: config.TapDeviceMacAddr, Reserved: true},
+			{IP: "192.168.127.3", MAC: otherMAC},
+		}, reloaded.Leases())
+		// Leases that the server no longer holds are kept.
+		changed, _ = reloaded.Update(map[string]string{})
+		assert.False(t, changed)
+		assert.Len(t, reloaded.Leases(), 2)
+	})
+	t.Run("moves a client to its new address", func(t *testing.T) {
+		t.Parallel()
+		table, _, err := Load("", defaultSubnet(t))
+		require.NoError(t, err)
+		table.Update(map[string]string{"192.168.127.3": otherMAC})
+		changed, _ := table.Update(map[string]string{"192.168.127.4": otherMAC})
+		assert.True(t, changed)
+		lease, ok := table.Lookup(otherMAC)
+		assert.True(t, ok)
+		assert.Equal(t, "192.168.127.4", lease.IP)
+		assert.Len(t, table.Leases(), 2)
+	})
+	t.Run("drops the leases of another subnet", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "leases.json")
+		table, _, err := Load(path, defaultSubnet(t))
+		require.NoError(t, err)
+		table.Update(map[string]string{"192.168.127.3": otherMAC})
+		require.NoError(t, table.Save())
+
+		subnet, err := config.ValidateSubnet("10.20.0.0/16")
+		require.NoError(t, err)
+		reloaded, _, err := Load(path, subnet)
+		require.NoError(t, err)
+		assert.Equal(t, []Lease{{IP: "10.20.0.2", MAC: config.TapDeviceMacAddr, Reserved: true}}, reloaded.Leases())
+	})
+	t.Run("leaves no temporary files behind", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		table, _, err := Load(filepath.Join(dir, "leases.json"), defaultSubnet(t))
+		require.NoError(t, err)
+		require.NoError(t, table.Save())
+		require.NoError(t, table.Save())
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "leases.json", entries[0].Name())
+	})
+}
+
+func TestConflicts(t *testing.T) {
+	t.Parallel()
+	t.Run("a saved lease of the reserved address is dropped", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "leases.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{
+			"subnet": "192.168.127.0/24",
+			"leases": [
+				{"ip": "192.168.127.2", "mac": "02:00:00:00:00:01"},
+				{"ip": "192.168.127.9", "mac": "5A:94:EF:E4:0C:EE"},
+				{"ip": "192.168.127.10", "mac": "02:00:00:00:00:02"}
+			]
+		}`), 0o644))
+		table, conflicts, err := Load(path, defaultSubnet(t))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []Lease{
+			{IP: "192.168.127.2", MAC: otherMAC},
+			{IP: "192.168.127.9", MAC: "5A:94:EF:E4:0C:EE"},
+		}, conflicts)
+		assert.Equal(t, map[string]string{
+			"192.168.127.2":  config.TapDeviceMacAddr,
+			"192.168.127.10": "02:00:00:00:00:02",
+		}, table.StaticLeases())
+	})
+	t.Run("a lease conflicting with a reservation is not added", func(t *testing.T) {
+		t.Parallel()
+		table, _, err := Load("", defaultSubnet(t))
+		require.NoError(t, err)
+		changed, conflicts := table.Update(map[string]string{
+			"192.168.127.2": otherMAC,
+			"192.168.127.5": config.TapDeviceMacAddr,
+		})
+		assert.False(t, changed)
+		assert.ElementsMatch(t, []Lease{
+			{IP: "192.168.127.2", MAC: otherMAC},
+			{IP: "192.168.127.5", MAC: config.TapDeviceMacAddr},
+		}, conflicts)
+		lease, ok := table.Lookup(config.TapDeviceMacAddr)
+		assert.True(t, ok)
+		assert.Equal(t, "192.168.127.2", lease.IP)
+	})
+	t.Run("saved reservations that are no longer configured are dropped", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "leases.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{
+			"subnet": "192.168.127.0/24",
+			"leases": [{"ip": "192.168.127.7", "mac": "02:00:00:00:00:01", "reserved": true}]
+		}`), 0o644))
+		table, _, err := Load(path, defaultSubnet(t))
+		require.NoError(t, err)
+		_, ok := table.Lookup(otherMAC)
+		assert.False(t, ok)
+	})
+}
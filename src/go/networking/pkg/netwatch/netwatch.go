@@ -0,0 +1,223 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package netwatch watches the network adapters of the host, and reports the
+// adapters that were recreated or given other addresses, as Windows does to
+// the WSL adapter on updates, when the Hyper-V switch is recreated, and on
+// resume.  The state that depends on the adapter can then be reconciled
+// without restarting the app.
+//
+// Changes are reported once they have settled, and at most once in a while,
+// so that an adapter flapping doesn't have the state reconciled over and
+// over.
+package netwatch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long the adapters must stay unchanged before changes are
+	// reported; notifications come in bursts while an adapter is set up.
+	DefaultSettle = 5 * time.Second
+	// The least time between two reports of changes.
+	DefaultMinInterval = 30 * time.Second
+	// How often the adapters are checked when no notification came, in
+	// case one was missed, or notifications are not supported.
+	DefaultPollInterval = time.Minute
+)
+
+// Adapter is the state of a network adapter that matters to the watcher.
+type Adapter struct {
+	Name string
+	// The index of the adapter, which changes when it is recreated.
+	Index int
+	// The addresses of the adapter, in CIDR notation, sorted.
+	Addrs []string
+}
+
+// Change is a change to a network adapter.
+type Change struct {
+	// The adapter before the change; its index is zero if it was not there.
+	Old Adapter
+	// The adapter after the change.
+	New Adapter
+}
+
+// Recreated returns whether the adapter was recreated, rather than only
+// given other addresses.
+func (c Change) Recreated() bool {
+	return c.Old.Index != c.New.Index
+}
+
+func (c Change) String() string {
+	what := "was re-addressed"
+	if c.Old.Index == 0 {
+		what = "appeared"
+	} else if c.Recreated() {
+		what = "was recreated"
+	}
+	return fmt.Sprintf("%s %s (%s -> %s)", c.New.Name, what, formatAddrs(c.Old.Addrs), formatAddrs(c.New.Addrs))
+}
+
+func formatAddrs(addrs []string) string {
+	if len(addrs) == 0 {
+		return "no addresses"
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// Watcher watches the network adapters of the host.
+type Watcher struct {
+	// Whether the adapter with the given name is watched.
+	match func(name string) bool
+	// Called with the changes to the watched adapters.
+	reconcile func(ctx context.Context, changes []Change)
+	// Lists the adapters of the host.
+	list func() ([]Adapter, error)
+	// Subscribes to the notifications of changes to the adapters; the
+	// channel receives a value when an adapter may have changed.
+	subscribe    func(ctx context.Context) (<-chan struct{}, error)
+	settle       time.Duration
+	minInterval  time.Duration
+	pollInterval time.Duration
+}
+
+// New returns a watcher calling reconcile with the changes to the adapters
+// whose name match accepts.  Calls to reconcile are made one at a time.
+func New(match func(name string) bool, reconcile func(ctx context.Context, changes []Change)) *Watcher {
+	return &Watcher{
+		match:        match,
+		reconcile:    reconcile,
+		list:         listAdapters,
+		subscribe:    subscribe,
+		settle:       DefaultSettle,
+		minInterval:  DefaultMinInterval,
+		pollInterval: DefaultPollInterval,
+	}
+}
+
+// Run watches the adapters until the context is done.  It only returns an
+// error if the adapters can't be listed to begin with.
+func (w *Watcher) Run(ctx context.Context) error {
+	notifications, err := w.subscribe(ctx)
+	if err != nil {
+		logrus.Warnf("failed to subscribe to network adapter changes; checking every %s instead: %v", w.pollInterval, err)
+	}
+	known, err := w.adapters()
+	if err != nil {
+		return err
+	}
+	poll := time.NewTicker(w.pollInterval)
+	defer poll.Stop()
+	// Fires when the adapters are to be checked; nil when no check is due.
+	var due <-chan time.Time
+	var lastReconcile time.Time
+	check := func() {
+		current, err := w.adapters()
+		if err != nil {
+			logrus.Errorf("failed to list network adapters: %v", err)
+			return
+		}
+		changes := diff(known, current)
+		if len(changes) == 0 {
+			known = current
+			return
+		}
+		if wait := time.Until(lastReconcile.Add(w.minInterval)); wait > 0 {
+			// The changes are kept until they can be reported.
+			due = time.After(wait)
+			return
+		}
+		known = current
+		lastReconcile = time.Now()
+		w.reconcile(ctx, changes)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notifications:
+			due = time.After(w.settle)
+		case <-poll.C:
+			if due == nil {
+				check()
+			}
+		case <-due:
+			due = nil
+			check()
+		}
+	}
+}
+
+// adapters returns the watched adapters, by name.
+func (w *Watcher) adapters() (map[string]Adapter, error) {
+	adapters, err := w.list()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]Adapter{}
+	for _, adapter := range adapters {
+		if w.match(adapter.Name) {
+			result[adapter.Name] = adapter
+		}
+	}
+	return result, nil
+}
+
+// diff returns the adapters of current that were not in known, or that have
+// another index or other addresses, ordered by name.  Adapters that are gone
+// are not changes: what depended on them can only be reconciled once they are
+// back.
+func diff(known, current map[string]Adapter) []Change {
+	var changes []Change
+	for name, adapter := range current {
+		old := known[name]
+		if old.Index != adapter.Index || !slices.Equal(old.Addrs, adapter.Addrs) {
+			changes = append(changes, Change{Old: old, New: adapter})
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int {
+		return strings.Compare(a.New.Name, b.New.Name)
+	})
+	return changes
+}
+
+// listAdapters lists the adapters of the host.
+func listAdapters() ([]Adapter, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+	adapters := make([]Adapter, 0, len(interfaces))
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the addresses of %s: %w", iface.Name, err)
+		}
+		adapter := Adapter{Name: iface.Name, Index: iface.Index, Addrs: make([]string, 0, len(addrs))}
+		for _, addr := range addrs {
+			adapter.Addrs = append(adapter.Addrs, addr.String())
+		}
+		slices.Sort(adapter.Addrs)
+		adapters = append(adapters, adapter)
+	}
+	return adapters, nil
+}
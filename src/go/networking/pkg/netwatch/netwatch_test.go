@@ -0,0 +1,182 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netwatch
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const wslAdapter = "vEthernet (WSL)"
+
+// fakeHost is a host whose adapters the tests change.
+type fakeHost struct {
+	mutex    sync.Mutex
+	adapters []Adapter
+	// Notifications of the adapters changing.
+	notifications chan struct{}
+	// The changes reconciled, one entry per call.
+	reconciled chan []Change
+}
+
+func (h *fakeHost) set(adapters ...Adapter) {
+	h.mutex.Lock()
+	h.adapters = adapters
+	h.mutex.Unlock()
+	h.notifications <- struct{}{}
+}
+
+// watch runs a watcher on the host, with short delays, until the end of the
+// test.
+func (h *fakeHost) watch(t *testing.T, minInterval time.Duration) {
+	t.Helper()
+	w := New(func(name string) bool { return strings.HasPrefix(name, "vEthernet (WSL") },
+		func(_ context.Context, changes []Change) { h.reconciled <- changes })
+	w.list = func() ([]Adapter, error) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		return h.adapters, nil
+	}
+	w.subscribe = func(context.Context) (<-chan struct{}, error) { return h.notifications, nil }
+	w.settle = 20 * time.Millisecond
+	w.minInterval = minInterval
+	w.pollInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	// Let the watcher list the adapters it starts with.
+	time.Sleep(10 * time.Millisecond)
+}
+
+// next returns the next changes reconciled, failing if there are none within
+// the timeout.
+func (h *fakeHost) next(t *testing.T, timeout time.Duration) []Change {
+	t.Helper()
+	select {
+	case changes := <-h.reconciled:
+		return changes
+	case <-time.After(timeout):
+		require.FailNow(t, "the changes were not reconciled")
+		return nil
+	}
+}
+
+// none checks that nothing is reconciled for the duration.
+func (h *fakeHost) none(t *testing.T, duration time.Duration) {
+	t.Helper()
+	select {
+	case changes := <-h.reconciled:
+		assert.Failf(t, "unexpected reconciliation", "%v", changes)
+	case <-time.After(duration):
+	}
+}
+
+func newFakeHost(adapters ...Adapter) *fakeHost {
+	return &fakeHost{
+		adapters:      adapters,
+		notifications: make(chan struct{}),
+		reconciled:    make(chan []Change, 10),
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	t.Parallel()
+	wsl := Adapter{Name: wslAdapter, Index: 12, Addrs: []string{"172.20.0.1/20"}}
+	ethernet := Adapter{Name: "Ethernet", Index: 3, Addrs: []string{"192.168.1.5/24"}}
+
+	t.Run("reports a recreated adapter once it settled", func(t *testing.T) {
+		t.Parallel()
+		h := newFakeHost(wsl, ethernet)
+		h.watch(t, time.Hour)
+		// Windows removes the adapter, then adds it back without and with
+		// an address.
+		h.set(ethernet)
+		h.set(Adapter{Name: wslAdapter, Index: 31}, ethernet)
+		recreated := Adapter{Name: wslAdapter, Index: 31, Addrs: []string{"172.27.16.1/20"}}
+		h.set(recreated, ethernet)
+		changes := h.next(t, time.Second)
+		require.Equal(t, []Change{{Old: wsl, New: recreated}}, changes)
+		assert.True(t, changes[0].Recreated())
+		assert.Equal(t, "vEthernet (WSL) was recreated (172.20.0.1/20 -> 172.27.16.1/20)", changes[0].String())
+		h.none(t, 100*time.Millisecond)
+	})
+	t.Run("reports a re-addressed adapter", func(t *testing.T) {
+		t.Parallel()
+		h := newFakeHost(wsl)
+		h.watch(t, time.Hour)
+		readdressed := Adapter{Name: wslAdapter, Index: 12, Addrs: []string{"172.27.16.1/20"}}
+		h.set(readdressed)
+		changes := h.next(t, time.Second)
+		require.Equal(t, []Change{{Old: wsl, New: readdressed}}, changes)
+		assert.False(t, changes[0].Recreated())
+	})
+	t.Run("reports an adapter that appeared", func(t *testing.T) {
+		t.Parallel()
+		h := newFakeHost(ethernet)
+		h.watch(t, time.Hour)
+		h.set(wsl, ethernet)
+		changes := h.next(t, time.Second)
+		require.Equal(t, []Change{{New: wsl}}, changes)
+		assert.Equal(t, "vEthernet (WSL) appeared (no addresses -> 172.20.0.1/20)", changes[0].String())
+	})
+	t.Run("ignores the other adapters", func(t *testing.T) {
+		t.Parallel()
+		h := newFakeHost(wsl, ethernet)
+		h.watch(t, time.Hour)
+		h.set(wsl, Adapter{Name: "Ethernet", Index: 3, Addrs: []string{"10.0.0.5/8"}})
+		h.set(wsl)
+		h.none(t, 100*time.Millisecond)
+	})
+	t.Run("limits how often changes are reported", func(t *testing.T) {
+		t.Parallel()
+		const minInterval = 300 * time.Millisecond
+		h := newFakeHost(wsl)
+		h.watch(t, minInterval)
+		first := Adapter{Name: wslAdapter, Index: 13, Addrs: []string{"172.21.0.1/20"}}
+		h.set(first)
+		start := time.Now()
+		h.next(t, time.Second)
+		// The adapter keeps flapping; the changes since the last report
+		// are reported together once the interval is over.
+		second := Adapter{Name: wslAdapter, Index: 14, Addrs: []string{"172.22.0.1/20"}}
+		h.set(second)
+		h.none(t, 100*time.Millisecond)
+		third := Adapter{Name: wslAdapter, Index: 15, Addrs: []string{"172.23.0.1/20"}}
+		h.set(third)
+		assert.Equal(t, []Change{{Old: first, New: third}}, h.next(t, time.Second))
+		assert.GreaterOrEqual(t, time.Since(start), minInterval)
+		h.none(t, 100*time.Millisecond)
+	})
+}
+
+func TestListAdapters(t *testing.T) {
+	t.Parallel()
+	adapters, err := listAdapters()
+	require.NoError(t, err)
+	assert.NotEmpty(t, adapters)
+	for _, adapter := range adapters {
+		assert.NotZero(t, adapter.Index, adapter.Name)
+		assert.IsIncreasing(t, adapter.Addrs, adapter.Name)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netwatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	// The channels of the watchers subscribed to the notifications.
+	subscribersMutex sync.Mutex
+	subscribers      = map[chan struct{}]bool{}
+	// The callback of the notifications.  Windows can only call back a
+	// limited number of Go functions per process, so there is only one.
+	notifyCallback = sync.OnceValue(func() uintptr {
+		return windows.NewCallback(func(_, _ uintptr, _ uint32) uintptr {
+			subscribersMutex.Lock()
+			defer subscribersMutex.Unlock()
+			for ch := range subscribers {
+				// A notification already pending will do.
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+			return 0
+		})
+	})
+)
+
+// subscribe subscribes to the notifications of IP interfaces changing, and
+// of unicast addresses being added, removed or changed, until the context is
+// done.
+func subscribe(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	subscribersMutex.Lock()
+	subscribers[ch] = true
+	subscribersMutex.Unlock()
+	var handles []windows.Handle
+	cancel := func() {
+		for _, handle := range handles {
+			_ = windows.CancelMibChangeNotify2(handle)
+		}
+		subscribersMutex.Lock()
+		delete(subscribers, ch)
+		subscribersMutex.Unlock()
+	}
+	for _, notify := range []struct {
+		name string
+		fn   func(uint16, uintptr, unsafe.Pointer, bool, *windows.Handle) error
+	}{
+		{"interface", windows.NotifyIpInterfaceChange},
+		{"address", windows.NotifyUnicastIpAddressChange},
+	} {
+		var handle windows.Handle
+		if err := notify.fn(windows.AF_UNSPEC, notifyCallback(), nil, false, &handle); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to subscribe to %s changes: %w", notify.name, err)
+		}
+		handles = append(handles, handle)
+	}
+	context.AfterFunc(ctx, cancel)
+	return ch, nil
+}
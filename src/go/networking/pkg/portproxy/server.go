@@ -19,9 +19,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	gvisorTypes "github.com/containers/gvisor-tap-vsock/pkg/types"
 	"github.com/docker/go-connections/nat"
@@ -34,8 +36,16 @@ import (
 type ProxyConfig struct {
 	UpstreamAddress string
 	UDPBufferSize   int
+	// ProvisionalTimeout is how long ports added provisionally are listened
+	// on unless they are added again without being provisional; zero means
+	// defaultProvisionalTimeout.
+	ProvisionalTimeout time.Duration
 }
 
+// defaultProvisionalTimeout leaves the guest agent time to notice the
+// container a provisional port was added for.
+const defaultProvisionalTimeout = time.Minute
+
 type PortProxy struct {
 	ctx            context.Context
 	config         *ProxyConfig
@@ -44,22 +54,36 @@ type PortProxy struct {
 	listenerConfig net.ListenConfig
 	// map of TCP port number as a key to associated listener
 	activeListeners map[int]net.Listener
-	listenerMutex   sync.Mutex
+	// map of TCP port number as a key to the container port it forwards to
+	listenerPorts map[int]nat.Port
+	// map of TCP port number as a key to the timer closing the listener, for
+	// ports added provisionally and not claimed yet
+	provisionalListeners map[int]*time.Timer
+	listenerMutex        sync.Mutex
 	// map of UDP port number as a key to associated UDPConn
 	activeUDPConns map[int]*net.UDPConn
-	udpConnMutex   sync.Mutex
-	wg             sync.WaitGroup
+	// map of UDP port number as a key to the container port it forwards to
+	udpConnPorts map[int]nat.Port
+	// map of UDP port number as a key to the timer closing the UDPConn, for
+	// ports added provisionally and not claimed yet
+	provisionalUDPConns map[int]*time.Timer
+	udpConnMutex        sync.Mutex
+	wg                  sync.WaitGroup
 }
 
 func NewPortProxy(ctx context.Context, listener net.Listener, cfg *ProxyConfig) *PortProxy {
 	portProxy := &PortProxy{
-		ctx:             ctx,
-		config:          cfg,
-		listener:        listener,
-		quit:            make(chan struct{}),
-		listenerConfig:  net.ListenConfig{},
-		activeListeners: make(map[int]net.Listener),
-		activeUDPConns:  make(map[int]*net.UDPConn),
+		ctx:                  ctx,
+		config:               cfg,
+		listener:             listener,
+		quit:                 make(chan struct{}),
+		listenerConfig:       net.ListenConfig{},
+		activeListeners:      make(map[int]net.Listener),
+		listenerPorts:        make(map[int]nat.Port),
+		provisionalListeners: make(map[int]*time.Timer),
+		activeUDPConns:       make(map[int]*net.UDPConn),
+		udpConnPorts:         make(map[int]nat.Port),
+		provisionalUDPConns:  make(map[int]*time.Timer),
 	}
 	return portProxy
 }
@@ -88,6 +112,15 @@ func (p *PortProxy) UDPPortMappings() map[int]*net.UDPConn {
 	return p.activeUDPConns
 }
 
+// capabilities lists the optional parts of the protocol the proxy supports,
+// for acknowledgments.
+var capabilities = []string{
+	types.CapabilityWithdrawAll,
+	types.CapabilityBindFailures,
+	types.CapabilityPortRanges,
+	types.CapabilityProvisional,
+}
+
 func (p *PortProxy) handleEvent(conn net.Conn) {
 	defer conn.Close()
 
@@ -96,26 +129,282 @@ func (p *PortProxy) handleEvent(conn net.Conn) {
 		logrus.Errorf("port server decoding received payload error: %s", err)
 		return
 	}
-	p.exec(pm)
+	failures := p.exec(pm)
+	if pm.WithdrawAll || pm.Ack {
+		ack := types.PortMappingAck{
+			Seq:          pm.Seq,
+			Capabilities: capabilities,
+			Failures:     failures,
+		}
+		if err := json.NewEncoder(conn).Encode(ack); err != nil {
+			logrus.Errorf("port server failed to acknowledge port mapping: %s", err)
+		}
+	}
 }
 
-func (p *PortProxy) exec(pm types.PortMapping) {
+// exec applies the port mapping, and returns the port bindings that could not
+// be listened on.
+func (p *PortProxy) exec(pm types.PortMapping) []types.BindFailure {
+	if pm.WithdrawAll {
+		logrus.Debug("withdrawing all ports as the guest agent is shutting down")
+		p.resync(nil, false)
+		return nil
+	}
+	ranges, failures := p.checkRanges(pm)
+	if pm.Resync && !pm.Remove {
+		portMap := pm.Ports
+		if len(ranges) > 0 {
+			portMap = make(nat.PortMap, len(pm.Ports))
+			maps.Copy(portMap, pm.Ports)
+			for _, portRange := range ranges {
+				// The ranges were checked already.
+				bindings, _ := portRange.Bindings()
+				for portProto, portBindings := range bindings {
+					portMap[portProto] = append(portMap[portProto], portBindings...)
+				}
+			}
+			ranges = nil
+		}
+		pm.Ports = p.resync(portMap, true)
+	}
 	for portProto, portBindings := range pm.Ports {
 		proto := strings.ToLower(portProto.Proto())
 		logrus.Debugf("received the following port: [%s] and protocol: [%s] from portMapping: %+v", portProto.Port(), proto, pm)
+		failures = append(failures, p.handlePort(portProto, portBindings, pm.Remove, pm.Provisional)...)
+	}
+	for _, portRange := range ranges {
+		failures = append(failures, p.handleRange(portRange, pm.Remove, pm.Provisional)...)
+	}
+	return failures
+}
 
-		switch gvisorTypes.TransportProtocol(proto) {
-		case gvisorTypes.TCP:
-			p.handleTCP(portBindings, pm.Remove)
-		case gvisorTypes.UDP:
-			p.handleUDP(portBindings, pm.Remove)
-		default:
-			logrus.Warnf("unsupported protocol: [%s]", proto)
+// handlePort adds or removes the listeners for the bindings of a port.
+func (p *PortProxy) handlePort(portProto nat.Port, portBindings []nat.PortBinding, remove, provisional bool) []types.BindFailure {
+	proto := strings.ToLower(portProto.Proto())
+	switch gvisorTypes.TransportProtocol(proto) {
+	case gvisorTypes.TCP:
+		return p.handleTCP(portProto, portBindings, remove, provisional)
+	case gvisorTypes.UDP:
+		return p.handleUDP(portProto, portBindings, remove, provisional)
+	default:
+		logrus.Warnf("unsupported protocol: [%s]", proto)
+		return nil
+	}
+}
+
+// handleRange adds or removes the listeners for the bindings of a range,
+// which was checked already, logging once for the whole range.
+func (p *PortProxy) handleRange(portRange types.PortRange, remove, provisional bool) []types.BindFailure {
+	bindings, err := portRange.Bindings()
+	if err != nil {
+		logrus.Errorf("parsing port range error: %s", err)
+		return nil
+	}
+	var failures []types.BindFailure
+	for portProto, portBindings := range bindings {
+		failures = append(failures, p.handlePort(portProto, portBindings, remove, provisional)...)
+	}
+	if remove {
+		logrus.Debugf("closed listeners for port range %s", portRange)
+	} else {
+		logrus.Debugf("created %d of %d listeners for port range %s", portRange.Count-len(failures), portRange.Count, portRange)
+	}
+	return failures
+}
+
+// checkRanges returns the port ranges of a port mapping that can be applied,
+// and a bind failure for each port of those that can't.  Ranges are rejected
+// if they are not valid, or, when adding them, if they overlap an earlier
+// range or a port binding of the message, or (unless the message is a
+// resync, which may replace them) a port forwarded to a different container
+// port.
+func (p *PortProxy) checkRanges(pm types.PortMapping) ([]types.PortRange, []types.BindFailure) {
+	var accepted []types.PortRange
+	var failures []types.BindFailure
+	for _, portRange := range pm.Ranges {
+		bindings, err := portRange.Bindings()
+		if err != nil {
+			logrus.Errorf("parsing port range error: %s", err)
+			continue
+		}
+		if pm.Remove {
+			accepted = append(accepted, portRange)
+			continue
+		}
+		err = p.checkRange(portRange, accepted, pm.Ports, !pm.Resync)
+		if err == nil {
+			accepted = append(accepted, portRange)
+			continue
+		}
+		logrus.Errorf("rejecting port range: %s", err)
+		for portProto, portBindings := range bindings {
+			for _, portBinding := range portBindings {
+				failures = append(failures, bindFailure(portProto, portBinding, err))
+			}
 		}
 	}
+	return accepted, failures
 }
 
-func (p *PortProxy) handleUDP(portBindings []nat.PortBinding, remove bool) {
+// checkRange returns an error if a port range overlaps one of the given
+// ranges or port bindings, or, if existing is set, a port forwarded to a
+// different container port.
+func (p *PortProxy) checkRange(portRange types.PortRange, ranges []types.PortRange, portMap nat.PortMap, existing bool) error {
+	proto := strings.ToLower(portRange.Port.Proto())
+	containerStart := portRange.Port.Int()
+	hostStart, _ := nat.ParsePort(portRange.HostPort)
+	hostEnd := hostStart + portRange.Count - 1
+	for _, other := range ranges {
+		otherStart, _ := nat.ParsePort(other.HostPort)
+		if strings.ToLower(other.Port.Proto()) == proto && hostIPsOverlap(portRange.HostIP, other.HostIP) &&
+			otherStart <= hostEnd && hostStart <= otherStart+other.Count-1 {
+			return fmt.Errorf("port range %s overlaps port range %s", portRange, other)
+		}
+	}
+	for portProto, portBindings := range portMap {
+		if strings.ToLower(portProto.Proto()) != proto {
+			continue
+		}
+		for _, portBinding := range portBindings {
+			hostPort, err := nat.ParsePort(portBinding.HostPort)
+			if err == nil && hostStart <= hostPort && hostPort <= hostEnd && hostIPsOverlap(portRange.HostIP, portBinding.HostIP) {
+				return fmt.Errorf("port range %s overlaps port %s->%s",
+					portRange, net.JoinHostPort(portBinding.HostIP, portBinding.HostPort), portProto)
+			}
+		}
+	}
+	if !existing {
+		return nil
+	}
+	var forwarded map[int]nat.Port
+	var mutex *sync.Mutex
+	switch gvisorTypes.TransportProtocol(proto) {
+	case gvisorTypes.TCP:
+		forwarded, mutex = p.listenerPorts, &p.listenerMutex
+	case gvisorTypes.UDP:
+		forwarded, mutex = p.udpConnPorts, &p.udpConnMutex
+	default:
+		return nil
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for offset := range portRange.Count {
+		// The same range may be added again, as when a resync follows.
+		forwardedPort, ok := forwarded[hostStart+offset]
+		if ok && forwardedPort.Int() != containerStart+offset {
+			return fmt.Errorf("port range %s overlaps host port %d, which is forwarded to port %s",
+				portRange, hostStart+offset, forwardedPort)
+		}
+	}
+	return nil
+}
+
+// hostIPsOverlap reports whether listening on two host addresses can
+// conflict: they are the same, or either is all addresses.
+func hostIPsOverlap(a, b string) bool {
+	unspecified := func(ip string) bool {
+		parsed := net.ParseIP(ip)
+		return ip == "" || (parsed != nil && parsed.IsUnspecified())
+	}
+	return a == b || unspecified(a) || unspecified(b)
+}
+
+func bindFailure(portProto nat.Port, portBinding nat.PortBinding, err error) types.BindFailure {
+	return types.BindFailure{
+		Port:     portProto,
+		HostIP:   portBinding.HostIP,
+		HostPort: portBinding.HostPort,
+		Error:    err.Error(),
+	}
+}
+
+// resync applies a message carrying the complete set of forwarded ports: it
+// closes the listeners for ports that are not in the set, and returns the
+// ports in the set that are not listened on yet.  Provisional ports in the set
+// are claimed; those that are not are closed only if keepProvisional is not
+// set, as the sender may not know about them yet.
+func (p *PortProxy) resync(portMap nat.PortMap, keepProvisional bool) nat.PortMap {
+	wanted := map[gvisorTypes.TransportProtocol]map[int]struct{}{
+		gvisorTypes.TCP: {},
+		gvisorTypes.UDP: {},
+	}
+	for portProto, portBindings := range portMap {
+		ports, ok := wanted[gvisorTypes.TransportProtocol(strings.ToLower(portProto.Proto()))]
+		if !ok {
+			continue
+		}
+		for _, portBinding := range portBindings {
+			if port, err := nat.ParsePort(portBinding.HostPort); err == nil {
+				ports[port] = struct{}{}
+			}
+		}
+	}
+
+	p.listenerMutex.Lock()
+	tcpActive := make(map[int]struct{}, len(p.activeListeners))
+	for port, listener := range p.activeListeners {
+		if _, ok := wanted[gvisorTypes.TCP][port]; ok {
+			tcpActive[port] = struct{}{}
+			p.claimListener(port)
+			continue
+		}
+		if _, ok := p.provisionalListeners[port]; ok && keepProvisional {
+			continue
+		}
+		p.claimListener(port)
+		logrus.Debugf("closing listener for port %d missing from resync", port)
+		if err := listener.Close(); err != nil {
+			logrus.Errorf("error closing listener for port [%d]: %s", port, err)
+		}
+		delete(p.activeListeners, port)
+		delete(p.listenerPorts, port)
+	}
+	p.listenerMutex.Unlock()
+
+	p.udpConnMutex.Lock()
+	udpActive := make(map[int]struct{}, len(p.activeUDPConns))
+	for port, udpConn := range p.activeUDPConns {
+		if _, ok := wanted[gvisorTypes.UDP][port]; ok {
+			udpActive[port] = struct{}{}
+			p.claimUDPConn(port)
+			continue
+		}
+		if _, ok := p.provisionalUDPConns[port]; ok && keepProvisional {
+			continue
+		}
+		p.claimUDPConn(port)
+		logrus.Debugf("closing UDPConn for port %d missing from resync", port)
+		if err := udpConn.Close(); err != nil {
+			logrus.Errorf("error closing UDPConn for port [%d]: %s", port, err)
+		}
+		delete(p.activeUDPConns, port)
+		delete(p.udpConnPorts, port)
+	}
+	p.udpConnMutex.Unlock()
+
+	active := map[gvisorTypes.TransportProtocol]map[int]struct{}{
+		gvisorTypes.TCP: tcpActive,
+		gvisorTypes.UDP: udpActive,
+	}
+	added := make(nat.PortMap)
+	for portProto, portBindings := range portMap {
+		ports := active[gvisorTypes.TransportProtocol(strings.ToLower(portProto.Proto()))]
+		for _, portBinding := range portBindings {
+			port, err := nat.ParsePort(portBinding.HostPort)
+			if err != nil {
+				added[portProto] = append(added[portProto], portBinding)
+				continue
+			}
+			if _, ok := ports[port]; !ok {
+				added[portProto] = append(added[portProto], portBinding)
+			}
+		}
+	}
+	return added
+}
+
+func (p *PortProxy) handleUDP(portProto nat.Port, portBindings []nat.PortBinding, remove, provisional bool) []types.BindFailure {
+	var failures []types.BindFailure
 	for _, portBinding := range portBindings {
 		port, err := nat.ParsePort(portBinding.HostPort)
 		if err != nil {
@@ -129,7 +418,9 @@ func (p *PortProxy) handleUDP(portBindings []nat.PortBinding, remove bool) {
 					logrus.Errorf("error closing UDPConn for port [%s]: %s", portBinding.HostPort, err)
 				}
 			}
+			p.claimUDPConn(port)
 			delete(p.activeUDPConns, port)
+			delete(p.udpConnPorts, port)
 			p.udpConnMutex.Unlock()
 			logrus.Debugf("closing UDPConn for port: %d", port)
 			continue
@@ -143,44 +434,94 @@ func (p *PortProxy) handleUDP(portBindings []nat.PortBinding, remove bool) {
 			continue
 		}
 
-		c, err := net.ListenUDP("udp", sourceAddr)
-		if err != nil {
-			logrus.Errorf("failed creating listener for published port [%s]: %s", portBinding.HostPort, err)
-			continue
-		}
-
 		forwardAddr := net.JoinHostPort(p.config.UpstreamAddress, portBinding.HostPort)
 		targetAddr, err := net.ResolveUDPAddr("udp", forwardAddr)
 		if err != nil {
-			c.Close()
 			logrus.Errorf("failed to resolve UDP target address [%s]: %s", targetAddr, err)
 			continue
 		}
 
+		// The mutex is held until the UDPConn is recorded, so that adding
+		// the same port from two connections at once listens only once.
 		p.udpConnMutex.Lock()
+		if _, exist := p.activeUDPConns[port]; exist {
+			// The port may be added more than once, as when the nerdctl stub
+			// adds it before the guest agent sees the container.
+			if !provisional {
+				p.claimUDPConn(port)
+			}
+			p.udpConnMutex.Unlock()
+			logrus.Debugf("UDPConn for port %d already exists", port)
+			continue
+		}
+
+		if p.closing() {
+			p.udpConnMutex.Unlock()
+			continue
+		}
+		c, err := net.ListenUDP("udp", sourceAddr)
+		if err != nil {
+			p.udpConnMutex.Unlock()
+			logrus.Errorf("failed creating listener for published port [%s]: %s", portBinding.HostPort, err)
+			failures = append(failures, bindFailure(portProto, portBinding, err))
+			continue
+		}
 		p.activeUDPConns[port] = c
+		p.udpConnPorts[port] = portProto
+		if provisional {
+			p.provisionalUDPConns[port] = time.AfterFunc(p.provisionalTimeout(), func() {
+				p.expireUDPConn(port, c)
+			})
+		}
+		p.wg.Add(1)
 		p.udpConnMutex.Unlock()
 		logrus.Debugf("created UDPConn for: %v", sourceAddr)
 
 		go p.acceptUDPConn(c, targetAddr)
 	}
+	return failures
+}
+
+// claimUDPConn stops the UDPConn for a port from being closed if it was added
+// provisionally.  The caller must hold udpConnMutex.
+func (p *PortProxy) claimUDPConn(port int) {
+	if timer, ok := p.provisionalUDPConns[port]; ok {
+		timer.Stop()
+		delete(p.provisionalUDPConns, port)
+	}
+}
+
+// expireUDPConn closes the UDPConn for a port added provisionally, unless the
+// port has been claimed or removed since.
+func (p *PortProxy) expireUDPConn(port int, udpConn *net.UDPConn) {
+	p.udpConnMutex.Lock()
+	defer p.udpConnMutex.Unlock()
+	if _, ok := p.provisionalUDPConns[port]; !ok || p.activeUDPConns[port] != udpConn {
+		return
+	}
+	logrus.Debugf("closing UDPConn for provisional port %d that was not claimed", port)
+	if err := udpConn.Close(); err != nil {
+		logrus.Errorf("error closing UDPConn for port [%d]: %s", port, err)
+	}
+	delete(p.provisionalUDPConns, port)
+	delete(p.activeUDPConns, port)
+	delete(p.udpConnPorts, port)
 }
 
 func (p *PortProxy) acceptUDPConn(sourceConn *net.UDPConn, targetAddr *net.UDPAddr) {
+	defer p.wg.Done()
 	targetConn, err := net.DialUDP("udp", nil, targetAddr)
 	if err != nil {
 		logrus.Errorf("failed to connect to target address: %s : %s", targetAddr, err)
 		return
 	}
 	defer targetConn.Close()
-	p.wg.Add(1)
 	for {
 		b := make([]byte, p.config.UDPBufferSize)
 		n, addr, err := sourceConn.ReadFromUDP(b)
 		if err != nil && n == 0 {
 			logrus.Errorf("error reading UDP packet from source: %s : %s", addr, err)
 			if errors.Is(err, net.ErrClosed) {
-				p.wg.Done()
 				break
 			}
 			continue
@@ -191,7 +532,6 @@ func (p *PortProxy) acceptUDPConn(sourceConn *net.UDPConn, targetAddr *net.UDPAd
 		if err != nil {
 			logrus.Errorf("error forwarding UDP packet to target: %s : %s", targetAddr, err)
 			if errors.Is(err, net.ErrClosed) {
-				p.wg.Done()
 				break
 			}
 			continue
@@ -200,7 +540,8 @@ func (p *PortProxy) acceptUDPConn(sourceConn *net.UDPConn, targetAddr *net.UDPAd
 	}
 }
 
-func (p *PortProxy) handleTCP(portBindings []nat.PortBinding, remove bool) {
+func (p *PortProxy) handleTCP(portProto nat.Port, portBindings []nat.PortBinding, remove, provisional bool) []types.BindFailure {
+	var failures []types.BindFailure
 	for _, portBinding := range portBindings {
 		port, err := nat.ParsePort(portBinding.HostPort)
 		if err != nil {
@@ -215,25 +556,87 @@ func (p *PortProxy) handleTCP(portBindings []nat.PortBinding, remove bool) {
 					logrus.Errorf("error closing listener for port [%s]: %s", portBinding.HostPort, err)
 				}
 			}
+			p.claimListener(port)
 			delete(p.activeListeners, port)
+			delete(p.listenerPorts, port)
+			p.listenerMutex.Unlock()
+			continue
+		}
+		// The mutex is held until the listener is recorded, so that adding
+		// the same port from two connections at once listens only once.
+		p.listenerMutex.Lock()
+		if _, exist := p.activeListeners[port]; exist {
+			// The port may be added more than once, as when the nerdctl stub
+			// adds it before the guest agent sees the container.
+			if !provisional {
+				p.claimListener(port)
+			}
+			p.listenerMutex.Unlock()
+			logrus.Debugf("listener for port %d already exists", port)
+			continue
+		}
+		if p.closing() {
 			p.listenerMutex.Unlock()
 			continue
 		}
 		addr := net.JoinHostPort(portBinding.HostIP, portBinding.HostPort)
 		l, err := p.listenerConfig.Listen(p.ctx, "tcp", addr)
 		if err != nil {
+			p.listenerMutex.Unlock()
 			logrus.Errorf("failed creating listener for published port [%s]: %s", portBinding.HostPort, err)
+			failures = append(failures, bindFailure(portProto, portBinding, err))
 			continue
 		}
-		p.listenerMutex.Lock()
 		p.activeListeners[port] = l
+		p.listenerPorts[port] = portProto
+		if provisional {
+			p.provisionalListeners[port] = time.AfterFunc(p.provisionalTimeout(), func() {
+				p.expireListener(port, l)
+			})
+		}
+		p.wg.Add(1)
 		p.listenerMutex.Unlock()
 		logrus.Debugf("created listener for: %s", addr)
 		go p.acceptTraffic(l, portBinding.HostPort)
 	}
+	return failures
+}
+
+// claimListener stops the listener for a port from being closed if it was
+// added provisionally.  The caller must hold listenerMutex.
+func (p *PortProxy) claimListener(port int) {
+	if timer, ok := p.provisionalListeners[port]; ok {
+		timer.Stop()
+		delete(p.provisionalListeners, port)
+	}
+}
+
+// expireListener closes the listener for a port added provisionally, unless
+// the port has been claimed or removed since.
+func (p *PortProxy) expireListener(port int, listener net.Listener) {
+	p.listenerMutex.Lock()
+	defer p.listenerMutex.Unlock()
+	if _, ok := p.provisionalListeners[port]; !ok || p.activeListeners[port] != listener {
+		return
+	}
+	logrus.Debugf("closing listener for provisional port %d that was not claimed", port)
+	if err := listener.Close(); err != nil {
+		logrus.Errorf("error closing listener for port [%d]: %s", port, err)
+	}
+	delete(p.provisionalListeners, port)
+	delete(p.activeListeners, port)
+	delete(p.listenerPorts, port)
+}
+
+func (p *PortProxy) provisionalTimeout() time.Duration {
+	if p.config.ProvisionalTimeout > 0 {
+		return p.config.ProvisionalTimeout
+	}
+	return defaultProvisionalTimeout
 }
 
 func (p *PortProxy) acceptTraffic(listener net.Listener, port string) {
+	defer p.wg.Done()
 	forwardAddr := net.JoinHostPort(p.config.UpstreamAddress, port)
 	for {
 		conn, err := listener.Accept()
@@ -256,22 +659,35 @@ func (p *PortProxy) acceptTraffic(listener net.Listener, port string) {
 	}
 }
 
+// closing reports whether Close has been called.  Listeners are only added
+// to the wait group while holding their mutex and checking this, so that none
+// are added once Close has taken the mutex to close them and goes on to wait.
+func (p *PortProxy) closing() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
 func (p *PortProxy) Close() error {
+	// Signal the quit channel to stop accepting new connections, and adding
+	// new listeners.
+	close(p.quit)
+
 	// Close all the active listeners
 	p.cleanupListeners()
 
 	// Close all active UDP connections
 	p.cleanupUDPConns()
 
-	// Close the listener first to prevent new connections.
+	// Close the listener to prevent new connections.
 	err := p.listener.Close()
 	if err != nil {
 		return err
 	}
 
-	// Signal the quit channel to stop accepting new connections.
-	close(p.quit)
-
 	// Wait for all pending connections to finish.
 	p.wg.Wait()
 
@@ -281,7 +697,8 @@ func (p *PortProxy) Close() error {
 func (p *PortProxy) cleanupListeners() {
 	p.listenerMutex.Lock()
 	defer p.listenerMutex.Unlock()
-	for _, l := range p.activeListeners {
+	for port, l := range p.activeListeners {
+		p.claimListener(port)
 		_ = l.Close()
 	}
 }
@@ -289,7 +706,8 @@ func (p *PortProxy) cleanupListeners() {
 func (p *PortProxy) cleanupUDPConns() {
 	p.udpConnMutex.Lock()
 	defer p.udpConnMutex.Unlock()
-	for _, c := range p.activeUDPConns {
+	for port, c := range p.activeUDPConns {
+		p.claimUDPConn(port)
 		_ = c.Close()
 	}
 }
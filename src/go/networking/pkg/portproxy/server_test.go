@@ -20,13 +20,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"strconv"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/nettest"
 
@@ -200,6 +203,471 @@ func TestNewPortProxyTCP(t *testing.T) {
 	portProxy.Close()
 }
 
+func TestPortProxyResync(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+
+	// Nothing needs to answer upstream; only the listeners are checked.
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.2",
+	})
+	startPortProxy(t, portProxy, localListener)
+
+	freePort := func() string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		_, port, err := net.SplitHostPort(listener.Addr().String())
+		require.NoError(t, err)
+		return port
+	}
+	resync := func(ports ...string) {
+		portMap := nat.PortMap{}
+		for _, port := range ports {
+			portMap[nat.Port(port+"/tcp")] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}
+		}
+		require.NoError(t, marshalAndSend(t.Context(), localListener, types.PortMapping{Ports: portMap, Resync: true}))
+	}
+	listening := func(port string) bool {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	kept, dropped := freePort(), freePort()
+	resync(kept, dropped)
+	require.Eventually(t, func() bool { return listening(kept) && listening(dropped) },
+		5*time.Second, 10*time.Millisecond, "listeners were not created")
+
+	resync(kept)
+	require.Eventually(t, func() bool { return !listening(dropped) },
+		5*time.Second, 10*time.Millisecond, "listener for port %s missing from the resync was not closed", dropped)
+	require.True(t, listening(kept), "listener for port %s in the resync was closed", kept)
+}
+
+func TestPortProxyWithdrawAll(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+
+	// Nothing needs to answer upstream; only the listeners are checked.
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.2",
+	})
+	startPortProxy(t, portProxy, localListener)
+
+	listening := func(port string) bool {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	var ports []string
+	portMap := nat.PortMap{}
+	for range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		_, port, err := net.SplitHostPort(listener.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, listener.Close())
+		ports = append(ports, port)
+		portMap[nat.Port(port+"/tcp")] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}
+	}
+	require.NoError(t, marshalAndSend(t.Context(), localListener, types.PortMapping{Ports: portMap, Seq: 1, Resync: true}))
+	require.Eventually(t, func() bool { return listening(ports[0]) && listening(ports[1]) },
+		5*time.Second, 10*time.Millisecond, "listeners were not created")
+
+	// A guest agent that is killed sends nothing, so the listeners are kept
+	// until something else cleans them up.
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, listening(ports[0]) && listening(ports[1]), "listeners were closed without being withdrawn")
+
+	// A guest agent that shuts down gracefully withdraws all ports, and
+	// waits for the acknowledgment.
+	conn, err := net.DialTimeout(localListener.Addr().Network(), localListener.Addr().String(), 5*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, json.NewEncoder(conn).Encode(types.PortMapping{Seq: 2, WithdrawAll: true}))
+	var ack types.PortMappingAck
+	require.NoError(t, json.NewDecoder(conn).Decode(&ack))
+	assert.Equal(t, uint64(2), ack.Seq)
+	assert.Contains(t, ack.Capabilities, types.CapabilityWithdrawAll)
+	for _, port := range ports {
+		assert.False(t, listening(port), "listener for port %s was not closed before the acknowledgment", port)
+	}
+}
+
+func TestPortProxyBindFailures(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.2",
+	})
+	startPortProxy(t, portProxy, localListener)
+
+	// Another process on the host already listens on the taken port.
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+	_, takenPort, err := net.SplitHostPort(taken.Addr().String())
+	require.NoError(t, err)
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, freePort, err := net.SplitHostPort(free.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, free.Close())
+
+	conn, err := net.DialTimeout(localListener.Addr().Network(), localListener.Addr().String(), 5*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, json.NewEncoder(conn).Encode(types.PortMapping{
+		Ports: nat.PortMap{
+			"80/tcp":  []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: takenPort}},
+			"443/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: freePort}},
+		},
+		Seq: 1,
+		Ack: true,
+	}))
+	var ack types.PortMappingAck
+	require.NoError(t, json.NewDecoder(conn).Decode(&ack))
+	assert.Equal(t, uint64(1), ack.Seq)
+	assert.Contains(t, ack.Capabilities, types.CapabilityBindFailures)
+	require.Len(t, ack.Failures, 1)
+	assert.Equal(t, nat.Port("80/tcp"), ack.Failures[0].Port)
+	assert.Equal(t, "127.0.0.1", ack.Failures[0].HostIP)
+	assert.Equal(t, takenPort, ack.Failures[0].HostPort)
+	assert.NotEmpty(t, ack.Failures[0].Error)
+}
+
+func TestPortProxyRepeatedAdd(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.2",
+	})
+	startPortProxy(t, portProxy, localListener)
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(free.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, free.Close())
+
+	// The nerdctl stub adds the port as the container starts, and the guest
+	// agent adds it again once it sees the container.
+	for seq := range uint64(2) {
+		conn, err := net.DialTimeout(localListener.Addr().Network(), localListener.Addr().String(), 5*time.Second)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+		require.NoError(t, json.NewEncoder(conn).Encode(types.PortMapping{
+			Ports: nat.PortMap{"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}},
+			Seq:   seq + 1,
+			Ack:   true,
+		}))
+		var ack types.PortMappingAck
+		require.NoError(t, json.NewDecoder(conn).Decode(&ack))
+		require.NoError(t, conn.Close())
+		assert.Empty(t, ack.Failures, "adding the port a second time failed")
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second)
+	require.NoError(t, err, "listener for port %s was not kept", port)
+	conn.Close()
+}
+
+func TestPortProxyConcurrentAdd(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.2",
+	})
+	startPortProxy(t, portProxy, localListener)
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(free.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, free.Close())
+
+	// The nerdctl stub and the guest agent may add the same port at the same
+	// time; neither may fail to listen on it.
+	const senders = 8
+	acks := make(chan types.PortMappingAck, senders)
+	for seq := range uint64(senders) {
+		go func() {
+			acks <- sendWithAck(t, localListener, types.PortMapping{
+				Ports: nat.PortMap{"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}},
+				Seq:   seq + 1,
+			})
+		}()
+	}
+	for range senders {
+		assert.Empty(t, (<-acks).Failures, "adding the port concurrently failed")
+	}
+}
+
+func TestPortProxyProvisional(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress:    "127.0.0.2",
+		ProvisionalTimeout: 200 * time.Millisecond,
+	})
+	startPortProxy(t, portProxy, localListener)
+
+	freePort := func() string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		_, port, err := net.SplitHostPort(listener.Addr().String())
+		require.NoError(t, err)
+		return port
+	}
+	portMap := func(port string) nat.PortMap {
+		return nat.PortMap{nat.Port(port + "/tcp"): []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}}
+	}
+	listening := func(port string) bool {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	// The nerdctl stub adds both ports; the guest agent only notices the
+	// container of the claimed one, and resyncs without the exited one.
+	exited, claimed := freePort(), freePort()
+	for seq, port := range []string{exited, claimed} {
+		ack := sendWithAck(t, localListener, types.PortMapping{Ports: portMap(port), Seq: uint64(seq + 1), Provisional: true})
+		require.Empty(t, ack.Failures)
+		assert.Contains(t, ack.Capabilities, types.CapabilityProvisional)
+	}
+	require.Empty(t, sendWithAck(t, localListener, types.PortMapping{Ports: portMap(claimed), Seq: 3}).Failures)
+	require.Empty(t, sendWithAck(t, localListener, types.PortMapping{Ports: portMap(claimed), Seq: 4, Resync: true}).Failures)
+	assert.True(t, listening(exited), "provisional port %s missing from the resync was closed", exited)
+
+	require.Eventually(t, func() bool { return !listening(exited) },
+		5*time.Second, 10*time.Millisecond, "listener for provisional port %s was not closed", exited)
+	time.Sleep(400 * time.Millisecond)
+	assert.True(t, listening(claimed), "listener for claimed port %s was closed", claimed)
+}
+
+func TestPortProxyRanges(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.2",
+	})
+	startPortProxy(t, portProxy, localListener)
+
+	start := freePortRange(t, 4)
+	hostPort := func(offset int) string { return strconv.Itoa(start + offset) }
+	portRange := func(containerStart, offset, count int) types.PortRange {
+		return types.PortRange{
+			Port:     nat.Port(fmt.Sprintf("%d/tcp", containerStart)),
+			HostIP:   "127.0.0.1",
+			HostPort: hostPort(offset),
+			Count:    count,
+		}
+	}
+	listening := func(offset int) bool {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", hostPort(offset)), time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	failedPorts := func(ack types.PortMappingAck) []string {
+		var ports []string
+		for _, failure := range ack.Failures {
+			ports = append(ports, failure.HostPort)
+		}
+		return ports
+	}
+
+	// Another process on the host already listens on the last port.
+	taken, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", hostPort(3)))
+	require.NoError(t, err)
+	defer taken.Close()
+
+	ack := sendWithAck(t, localListener, types.PortMapping{Seq: 1, Ranges: []types.PortRange{portRange(8000, 0, 4)}})
+	assert.Contains(t, ack.Capabilities, types.CapabilityPortRanges)
+	assert.Equal(t, []string{hostPort(3)}, failedPorts(ack), "only the taken port should fail")
+	assert.Equal(t, nat.Port("8003/tcp"), ack.Failures[0].Port)
+	for offset := range 3 {
+		assert.True(t, listening(offset), "port %s of the range is not listened on", hostPort(offset))
+	}
+
+	t.Run("adding the same range again", func(t *testing.T) {
+		ack := sendWithAck(t, localListener, types.PortMapping{Seq: 2, Ranges: []types.PortRange{portRange(8000, 0, 3)}})
+		assert.Empty(t, ack.Failures)
+	})
+	t.Run("overlapping ports forwarded to other container ports", func(t *testing.T) {
+		ack := sendWithAck(t, localListener, types.PortMapping{Seq: 3, Ranges: []types.PortRange{portRange(9001, 1, 2)}})
+		assert.ElementsMatch(t, []string{hostPort(1), hostPort(2)}, failedPorts(ack))
+		for _, failure := range ack.Failures {
+			assert.Contains(t, failure.Error, fmt.Sprintf("overlaps host port %s, which is forwarded to port 8001/tcp", hostPort(1)))
+		}
+	})
+	t.Run("overlapping ranges of the same message", func(t *testing.T) {
+		ack := sendWithAck(t, localListener, types.PortMapping{Seq: 4, Ranges: []types.PortRange{
+			portRange(8000, 0, 2),
+			portRange(8001, 1, 2),
+		}})
+		assert.ElementsMatch(t, []string{hostPort(1), hostPort(2)}, failedPorts(ack))
+		for _, failure := range ack.Failures {
+			assert.Contains(t, failure.Error, "overlaps port range 127.0.0.1:"+hostPort(0)+"-"+hostPort(1)+"->8000-8001/tcp")
+		}
+	})
+	t.Run("overlapping ports of the same message", func(t *testing.T) {
+		ack := sendWithAck(t, localListener, types.PortMapping{
+			Seq:    5,
+			Ports:  nat.PortMap{"9000/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort(1)}}},
+			Ranges: []types.PortRange{portRange(8000, 0, 2)},
+		})
+		// The port itself is left alone, as its host port is listened on
+		// already.
+		assert.ElementsMatch(t, []string{hostPort(0), hostPort(1)}, failedPorts(ack))
+		for _, failure := range ack.Failures {
+			assert.Contains(t, failure.Error, "overlaps port 0.0.0.0:"+hostPort(1)+"->9000/tcp")
+		}
+	})
+	t.Run("removing a single port of a range", func(t *testing.T) {
+		require.NoError(t, marshalAndSend(t.Context(), localListener, types.PortMapping{
+			Seq:    6,
+			Remove: true,
+			Ports:  nat.PortMap{"8001/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: hostPort(1)}}},
+		}))
+		require.Eventually(t, func() bool { return !listening(1) },
+			5*time.Second, 10*time.Millisecond, "listener for port %s was not closed", hostPort(1))
+		assert.True(t, listening(0) && listening(2), "the other ports of the range were closed")
+	})
+	t.Run("removing a range", func(t *testing.T) {
+		sendWithAck(t, localListener, types.PortMapping{Seq: 7, Remove: true, Ranges: []types.PortRange{portRange(8000, 0, 3)}})
+		for offset := range 3 {
+			assert.False(t, listening(offset), "listener for port %s was not closed", hostPort(offset))
+		}
+	})
+}
+
+// BenchmarkPortProxyRange compares setting up (then removing) the listeners
+// for a hundred ports sent as a message per port, as a single message with a
+// binding for each port, and as a single range.
+func BenchmarkPortProxyRange(b *testing.B) {
+	const count = 100
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(b, err)
+
+	portProxy := portproxy.NewPortProxy(b.Context(), localListener, &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.2",
+	})
+	startPortProxy(b, portProxy, localListener)
+
+	start := freePortRange(b, count)
+	portMap := make(nat.PortMap, count)
+	for offset := range count {
+		port := strconv.Itoa(start + offset)
+		portMap[nat.Port(port+"/tcp")] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}
+	}
+	portRange := types.PortRange{Port: nat.Port(fmt.Sprintf("%d/tcp", start)), HostIP: "127.0.0.1", HostPort: strconv.Itoa(start), Count: count}
+	var seq uint64
+	send := func(pm types.PortMapping) {
+		seq++
+		pm.Seq = seq
+		if ack := sendWithAck(b, localListener, pm); len(ack.Failures) > 0 {
+			b.Fatalf("failed to listen: %+v", ack.Failures)
+		}
+	}
+
+	b.Run("message per port", func(b *testing.B) {
+		for b.Loop() {
+			for port, bindings := range portMap {
+				send(types.PortMapping{Ports: nat.PortMap{port: bindings}})
+			}
+			for port, bindings := range portMap {
+				send(types.PortMapping{Remove: true, Ports: nat.PortMap{port: bindings}})
+			}
+		}
+	})
+	b.Run("single message", func(b *testing.B) {
+		for b.Loop() {
+			send(types.PortMapping{Ports: portMap})
+			send(types.PortMapping{Remove: true, Ports: portMap})
+		}
+	})
+	b.Run("range", func(b *testing.B) {
+		for b.Loop() {
+			send(types.PortMapping{Ranges: []types.PortRange{portRange}})
+			send(types.PortMapping{Remove: true, Ranges: []types.PortRange{portRange}})
+		}
+	})
+}
+
+// freePortRange returns the first of count consecutive ports that nothing
+// listens on at 127.0.0.1.
+func freePortRange(tb testing.TB, count int) int {
+	tb.Helper()
+	for start := 20000 + rand.IntN(20000); start+count <= 65535; start += count {
+		free := true
+		for port := start; port < start+count && free; port++ {
+			listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				free = false
+				continue
+			}
+			require.NoError(tb, listener.Close())
+		}
+		if free {
+			return start
+		}
+	}
+	tb.Fatalf("could not find %d free consecutive ports", count)
+	return 0
+}
+
+// startPortProxy runs the port proxy, which closes the listener, until the
+// test ends; it returns once the port proxy accepts port mappings.
+func startPortProxy(tb testing.TB, portProxy *portproxy.PortProxy, listener net.Listener) {
+	tb.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- portProxy.Start()
+	}()
+	tb.Cleanup(func() {
+		assert.NoError(tb, portProxy.Close())
+		assert.NoError(tb, <-done)
+	})
+	// An acknowledgment shows that Start is accepting connections.
+	sendWithAck(tb, listener, types.PortMapping{})
+}
+
+// sendWithAck sends a port mapping asking for an acknowledgment, and returns
+// it once the port mapping is applied.
+func sendWithAck(tb testing.TB, listener net.Listener, portMapping types.PortMapping) types.PortMappingAck {
+	tb.Helper()
+	conn, err := net.DialTimeout(listener.Addr().Network(), listener.Addr().String(), 5*time.Second)
+	require.NoError(tb, err)
+	defer conn.Close()
+	require.NoError(tb, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	portMapping.Ack = true
+	require.NoError(tb, json.NewEncoder(conn).Encode(portMapping))
+	var ack types.PortMappingAck
+	require.NoError(tb, json.NewDecoder(conn).Decode(&ack))
+	return ack
+}
+
 func httpGetRequest(ctx context.Context, url string) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
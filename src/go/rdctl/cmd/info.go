@@ -156,7 +156,7 @@ func doInfoCommand(cmd *cobra.Command, args []string) error {
 			if !ok {
 				name = field.Name
 			}
-			if _, err := fmt.Fprintf(writer, "%s:\t%s\n", name, value.Field(i)); err != nil {
+			if _, err := fmt.Fprintf(writer, "%s:\t%v\n", name, value.Field(i)); err != nil {
 				return err
 			}
 		}
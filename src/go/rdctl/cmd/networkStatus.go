@@ -0,0 +1,116 @@
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/network"
+)
+
+var networkStatusEndpoint string
+
+var networkStatusOutput = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
+var networkStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show what the host switch of the virtual network is doing",
+	Long: `Show what the host switch of the virtual network is doing: the forwarded
+ports, with the connections and bytes forwarded for each, the size of the
+connection table, the DHCP leases, and the byte counters of the interfaces.
+
+The status is read from the control endpoint of the host switch, which only
+runs on Windows, and can only be read by the user running Rancher Desktop.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		status, err := network.GetStatus(cmd.Context(), networkStatusEndpoint)
+		if err != nil {
+			return err
+		}
+		if networkStatusOutput.String() == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(status)
+		}
+		writeNetworkStatus(os.Stdout, status)
+		return nil
+	},
+}
+
+func init() {
+	networkCmd.AddCommand(networkStatusCmd)
+	networkStatusCmd.Flags().StringVar(&networkStatusEndpoint, "endpoint", network.DefaultEndpoint, "control endpoint of the host switch")
+	networkStatusCmd.Flags().VarP(&networkStatusOutput, "output", "o", "output format")
+}
+
+func writeNetworkStatus(out io.Writer, status network.Status) {
+	writer := tabwriter.NewWriter(out, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(writer, "INTERFACE\tSENT\tRECEIVED\tDESCRIPTION\n")
+	for _, iface := range status.Interfaces {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", iface.Name,
+			formatSize(int64(iface.BytesSent)), formatSize(int64(iface.BytesReceived)), iface.Description)
+	}
+	writer.Flush()
+
+	fmt.Fprintf(out, "\n%d TCP connections established, %d connected; %d clients on the switch\n",
+		status.Connections.TCPEstablished, status.Connections.TCPConnected, status.Connections.SwitchClients)
+
+	fmt.Fprintln(out)
+	if len(status.Forwards) == 0 {
+		fmt.Fprintln(out, "No ports are forwarded.")
+	} else {
+		writer = tabwriter.NewWriter(out, 0, 4, 4, ' ', 0)
+		fmt.Fprintf(writer, "PROTOCOL\tLOCAL\tREMOTE\tCONNECTIONS\tACTIVE\tFAILED\tTO VM\tFROM VM\n")
+		for _, fw := range status.Forwards {
+			if !fw.Counted {
+				// Ports the switch forwards at startup aren't counted.
+				fmt.Fprintf(writer, "%s\t%s\t%s\t-\t-\t-\t-\t-\n", fw.Protocol, fw.Local, fw.Remote)
+				continue
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n", fw.Protocol, fw.Local, fw.Remote,
+				fw.Connections, fw.ActiveConnections, fw.FailedConnections,
+				formatSize(int64(fw.BytesToVM)), formatSize(int64(fw.BytesFromVM)))
+		}
+		writer.Flush()
+	}
+
+	fmt.Fprintln(out)
+	if len(status.Leases) == 0 {
+		fmt.Fprintln(out, "No DHCP leases are held.")
+	} else {
+		writer = tabwriter.NewWriter(out, 0, 4, 4, ' ', 0)
+		fmt.Fprintf(writer, "ADDRESS\tMAC ADDRESS\tRESERVED\n")
+		for _, lease := range status.Leases {
+			reserved := ""
+			if lease.Reserved {
+				reserved = "yes"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", lease.IP, lease.MAC, reserved)
+		}
+		writer.Flush()
+	}
+}
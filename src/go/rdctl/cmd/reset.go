@@ -22,7 +22,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/client"
@@ -37,8 +42,14 @@ var (
 	k8sReset     bool
 	cacheReset   bool
 	factoryReset bool
+	resetDryRun  bool
 )
 
+var resetOutput = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
 var resetCmd = &cobra.Command{
 	Use:   "reset",
 	Short: "Reset Rancher Desktop",
@@ -48,7 +59,10 @@ Some reset options are combinations of others:
   * --factory includes --vm and --k8s (but not --cache)
   * --vm includes --k8s
 
-At least one option must be specified.`,
+At least one option must be specified.
+
+With --factory --dry-run, the files that would be deleted are listed with
+their sizes, and nothing is deleted.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := cobra.NoArgs(cmd, args); err != nil {
 			return err
@@ -60,6 +74,13 @@ At least one option must be specified.`,
 			return fmt.Errorf("no reset options specified. Use --help to see available options")
 		}
 
+		if resetDryRun {
+			if !factoryReset {
+				return fmt.Errorf("--dry-run is only supported with --factory")
+			}
+			return showFactoryResetDryRun(cacheReset, resetOutput.String())
+		}
+
 		// Handle factory reset (includes VM, K8s and possibly cache reset)
 		if factoryReset {
 			return performFactoryReset(cmd.Context(), cacheReset)
@@ -100,7 +121,71 @@ func performFactoryReset(ctx context.Context, removeCache bool) error {
 	if err != nil {
 		return err
 	}
-	return factoryreset.DeleteData(ctx, pathsCfg, removeCache)
+	// Removing large caches can take a while; allow interrupting it between
+	// directories rather than killing rdctl part way through one.
+	notifyCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
+	defer stop()
+	return factoryreset.DeleteDataWithProgress(notifyCtx, pathsCfg, removeCache, func(progress factoryreset.Progress) {
+		if progress.Err == nil {
+			logrus.Infof("Removed %s (%s) [%d/%d]", progress.Path, formatSize(progress.Size), progress.Index, progress.Total)
+		}
+	})
+}
+
+// showFactoryResetDryRun prints the paths a factory reset would delete, with
+// the space that would be reclaimed, without deleting anything.
+func showFactoryResetDryRun(removeCache bool, output string) error {
+	pathsCfg, err := paths.GetPaths()
+	if err != nil {
+		return fmt.Errorf("failed to get paths: %w", err)
+	}
+	result, err := factoryreset.DryRun(pathsCfg, removeCache)
+	if err != nil {
+		return fmt.Errorf("failed to list data to delete: %w", err)
+	}
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(writer, "SIZE\tPATH\n")
+	for _, pathSize := range result.Paths {
+		size := formatSize(pathSize.Size)
+		if len(pathSize.Unknown) > 0 {
+			size = fmt.Sprintf("%s+", size)
+		}
+		fmt.Fprintf(writer, "%s\t%s\n", size, pathSize.Path)
+		for _, unknown := range pathSize.Unknown {
+			fmt.Fprintf(writer, "unknown size\t  %s\n", unknown)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if result.Incomplete {
+		fmt.Printf("\nAt least %s would be reclaimed; the size of some paths is unknown.\n", formatSize(result.Reclaimable))
+	} else {
+		fmt.Printf("\n%s would be reclaimed.\n", formatSize(result.Reclaimable))
+	}
+	return nil
+}
+
+// formatSize formats a size in bytes using binary units.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	prefixes := "KMGTPE"
+	index := -1
+	for value >= unit && index < len(prefixes)-1 {
+		value /= unit
+		index++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, prefixes[index])
 }
 
 // doReset performs a reset with the specified mode
@@ -134,4 +219,6 @@ func init() {
 	resetCmd.Flags().BoolVar(&k8sReset, "k8s", false, "Delete deployed Kubernetes workloads")
 	resetCmd.Flags().BoolVar(&cacheReset, "cache", false, "Delete cached Kubernetes images")
 	resetCmd.Flags().BoolVar(&factoryReset, "factory", false, "Delete VM and show first-run dialog on next start")
+	resetCmd.Flags().BoolVar(&resetDryRun, "dry-run", false, "With --factory, list the files that would be deleted and their sizes without deleting them")
+	resetCmd.Flags().VarP(&resetOutput, "output", "o", "output format for --dry-run")
 }
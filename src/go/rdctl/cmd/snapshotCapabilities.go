@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotCapabilitiesFormat = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
+var snapshotCapabilitiesCmd = &cobra.Command{
+	Use:   "capabilities",
+	Short: "Show what snapshots support on this platform",
+	Long: `Show what snapshots support with this version of rdctl on this platform,
+such as the codecs files can be stored with, whether snapshots can be
+deduplicated, and the components that can be restored. Whether the snapshots
+are frozen, by "rdctl snapshot freeze", is shown too.
+
+With --format json, the capabilities are written as a single JSON object. Its
+"version" changes only when a field is removed or changes meaning, so that
+tools can rely on the fields they know.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return showSnapshotCapabilities(cmd.OutOrStdout(), snapshotCapabilitiesFormat.String())
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotCapabilitiesCmd)
+	snapshotCapabilitiesCmd.Flags().Var(&snapshotCapabilitiesFormat, "format", "output format")
+}
+
+func showSnapshotCapabilities(output io.Writer, format string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	capabilities, err := manager.Capabilities()
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(capabilities)
+	}
+	yesNo := func(supported bool) string {
+		if supported {
+			return "yes"
+		}
+		return "no"
+	}
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Codecs:\t%s\n", strings.Join(capabilities.Codecs, ", "))
+	fmt.Fprintf(writer, "Encryption:\t%s\n", yesNo(capabilities.Encryption))
+	fmt.Fprintf(writer, "Incremental:\t%s\n", yesNo(capabilities.Incremental))
+	fmt.Fprintf(writer, "Deduplication:\t%s\n", yesNo(capabilities.Deduplication))
+	fmt.Fprintf(writer, "Reflink:\t%s\n", yesNo(capabilities.Reflink))
+	fmt.Fprintf(writer, "Resumable restore:\t%s\n", yesNo(capabilities.ResumableRestore))
+	fmt.Fprintf(writer, "Minimal restore:\t%s\n", yesNo(capabilities.MinimalRestore))
+	fmt.Fprintf(writer, "Metadata version:\t%d\n", capabilities.MetadataVersion)
+	fmt.Fprintf(writer, "Max name length:\t%d\n", capabilities.MaxNameLength)
+	fmt.Fprintf(writer, "Components:\t%s\n", strings.Join(capabilities.Components, ", "))
+	if capabilities.Frozen {
+		fmt.Fprintf(writer, "Frozen:\tsince %s\n", capabilities.FrozenSince.Format(time.RFC1123))
+	} else {
+		fmt.Fprintf(writer, "Frozen:\tno\n")
+	}
+	return writer.Flush()
+}
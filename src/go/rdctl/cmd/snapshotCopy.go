@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var (
+	snapshotCopyTo    string
+	snapshotCopyForce bool
+)
+
+var snapshotCopyCmd = &cobra.Command{
+	Use:   "copy <name> --to <directory>",
+	Short: "Copy a snapshot to another directory",
+	Long: `Copy a snapshot to another directory, such as one on a backup drive,
+leaving the original in place.
+
+The directory is laid out like the snapshots directory, so that it can hold
+copies of several snapshots. Every file is read back once it is copied, and
+its checksum compared to the original's. If the directory already holds a
+copy of the snapshot, it is only replaced with --force.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if snapshotCopyTo == "" {
+			return errors.New(`the "--to" option is required`)
+		}
+		cmd.SilenceUsage = true
+		return exitWithJSONOrErrorCondition(copySnapshot(cmd, args[0]))
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotCopyCmd)
+	snapshotCopyCmd.Flags().BoolVarP(&outputJSONFormat, "json", "", false, "output json format")
+	snapshotCopyCmd.Flags().StringVar(&snapshotCopyTo, "to", "", "the directory to copy the snapshot to")
+	snapshotCopyCmd.Flags().BoolVar(&snapshotCopyForce, "force", false, "replace a copy of the snapshot already in the directory")
+}
+
+func copySnapshot(cmd *cobra.Command, nameOrID string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	aSnapshot, err := manager.Stat(nameOrID)
+	if err != nil {
+		return fmt.Errorf("failed to copy snapshot %q: %w", nameOrID, err)
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
+	defer stop()
+	if err := manager.Copy(ctx, aSnapshot.ID, snapshotCopyTo, snapshot.CopyOptions{Force: snapshotCopyForce}); err != nil {
+		return fmt.Errorf("failed to copy snapshot %q: %w", aSnapshot.Name, err)
+	}
+	return nil
+}
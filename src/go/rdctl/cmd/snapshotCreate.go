@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -20,11 +21,91 @@ import (
 
 var snapshotDescription string
 var snapshotDescriptionFrom string
+var snapshotCreateCheckCluster bool
+var snapshotCreateRequireHealthy bool
+var snapshotCreateProfile string
+var snapshotCreateMaxSnapshots int
+var snapshotCreatePruneOldest bool
+var snapshotCreatePruneBy string
+var snapshotCreateDeduplicate bool
+var snapshotCreateRecordHostname bool
+var snapshotCreateVerify bool
+var snapshotCreateCaptureLogs bool
+var snapshotCreateEstimate bool
 
 var snapshotCreateCmd = &cobra.Command{
-	Use:   "create <name>",
+	Use:   "create (<name> | --estimate)",
 	Short: "Create a snapshot",
-	Args:  cobra.ExactArgs(1),
+	Long: `Create a snapshot.
+
+With --check-cluster, the Kubernetes cluster is checked first, and a warning
+is shown if it is not in a steady state: nodes that are not ready, or pods
+that are pending, terminating, not ready or crash-looping. The snapshot
+would then capture the cluster in that state. The result of the check is
+recorded in the snapshot, and shown by "rdctl snapshot list --json". With
+--require-healthy, the snapshot is not created unless the cluster can be
+checked and is healthy.
+
+With --max-snapshots, the snapshot is not created if there are already that
+many snapshots. With --prune-oldest as well, the oldest snapshots are deleted
+instead, once the new snapshot is created, to stay within the limit.
+Protected snapshots count towards the limit, but are never pruned. With
+--prune-by=lastUsed, snapshots are as old as when they were last restored
+(or created, if they never were), so that old snapshots in frequent use are
+kept; by default they are as old as when they were created.
+
+With --deduplicate, the files of the snapshot are kept in an object store
+shared by snapshots, in the snapshots directory, so that files that are
+identical to those of other snapshots are only stored once. Objects are
+removed once the last snapshot using them is deleted. This is not supported
+on Windows.
+
+Each snapshot records the machine it was created on, as a random machine ID
+kept in the Rancher Desktop config directory, so that snapshots collected
+from several machines can be told apart. With --record-hostname, the host
+name of the machine is recorded as well.
+
+With --verify, the snapshot is checked once its files are copied: all of its
+files must be there, and the copies of the working files must have the same
+checksums. If not, the snapshot is removed and creating it fails. This reads
+the files again, so it takes about as long as copying them. On Windows, the
+exported WSL distros can only be checked to exist.
+
+With --capture-logs, the end of the logs of Rancher Desktop, its services and
+the cluster is captured in the snapshot, up to 16 MiB, for debugging: "rdctl
+snapshot logs --captured" shows them. They are never restored.
+
+With --estimate, no snapshot is created: the space a snapshot created with the
+same options would take is shown instead; no name is needed. Snapshots are not
+compressed, so this is the size of the files they capture; copy-on-write
+copies and deduplicated files may take less. On Windows, the size of the WSL
+disks is used, which is usually more than their exports take.
+
+With --profile, the options are taken from the named profile in
+snapshot-profiles.json, in the Rancher Desktop config directory; options
+given on the command line override the profile. The file maps profile
+names to options, for example:
+
+  {
+    "nightly": {
+      "description": "Nightly snapshot",
+      "checkCluster": true,
+      "requireHealthy": false,
+      "maxSnapshots": 7,
+      "pruneOldest": true,
+      "pruneBy": "lastUsed",
+      "deduplicate": true,
+      "recordHostname": false,
+      "verifyAfterCreate": true,
+      "captureLogs": false
+    }
+  }`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if snapshotCreateEstimate {
+			return cobra.MaximumNArgs(1)(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if snapshotDescription != "" && snapshotDescriptionFrom != "" {
 			return fmt.Errorf(`can't specify more than one option from "--description" and "--description-from"`)
@@ -43,7 +124,7 @@ var snapshotCreateCmd = &cobra.Command{
 			}
 			snapshotDescription = string(bytes)
 		}
-		return exitWithJSONOrErrorCondition(createSnapshot(cmd.Context(), args))
+		return exitWithJSONOrErrorCondition(createSnapshot(cmd, args))
 	},
 }
 
@@ -52,17 +133,76 @@ func init() {
 	snapshotCreateCmd.Flags().BoolVar(&outputJSONFormat, "json", false, "output json format")
 	snapshotCreateCmd.Flags().StringVar(&snapshotDescription, "description", "", "snapshot description")
 	snapshotCreateCmd.Flags().StringVar(&snapshotDescriptionFrom, "description-from", "", "snapshot description from a file (or - for stdin)")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreateCheckCluster, "check-cluster", false, "check the health of the Kubernetes cluster first, and warn if it is not in a steady state")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreateRequireHealthy, "require-healthy", false, "only create the snapshot if the Kubernetes cluster is healthy; implies --check-cluster")
+	snapshotCreateCmd.Flags().IntVar(&snapshotCreateMaxSnapshots, "max-snapshots", 0, "the most snapshots there may be, including this one; 0 for no limit")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreatePruneOldest, "prune-oldest", false, "delete the oldest snapshots to stay within --max-snapshots")
+	snapshotCreateCmd.Flags().StringVar(&snapshotCreatePruneBy, "prune-by", string(snapshot.PruneByCreated), `how --prune-oldest measures age: "created" or "lastUsed"`)
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreateDeduplicate, "deduplicate", false, "store files identical to those of other snapshots only once")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreateRecordHostname, "record-hostname", false, "record the host name of this machine in the snapshot")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreateVerify, "verify", false, "check the files of the snapshot once it is created, and remove it if they don't match")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreateCaptureLogs, "capture-logs", false, "capture the end of the logs in the snapshot, for debugging")
+	snapshotCreateCmd.Flags().StringVar(&snapshotCreateProfile, "profile", "", "take the options from this profile in snapshot-profiles.json")
+	snapshotCreateCmd.Flags().BoolVar(&snapshotCreateEstimate, "estimate", false, "show how much space the snapshot would take, without creating it")
 }
 
-func createSnapshot(ctx context.Context, args []string) error {
-	name := args[0]
+func createSnapshot(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
 	manager, err := snapshot.NewManager()
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot manager: %w", err)
 	}
-	// Report on invalid names before locking and shutting down the backend
-	if err := manager.ValidateName(name); err != nil {
-		return err
+	defer manager.Close()
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+		// Report on invalid names before locking and shutting down the backend
+		if err := manager.ValidateName(name); err != nil {
+			return err
+		}
+	}
+	var opts snapshot.CreateOptions
+	if snapshotCreateProfile != "" {
+		if opts, err = manager.CreateProfile(snapshotCreateProfile); err != nil {
+			return err
+		}
+	}
+	flags := cmd.Flags()
+	if flags.Changed("description") || flags.Changed("description-from") {
+		opts.Description = snapshotDescription
+	}
+	if flags.Changed("check-cluster") {
+		opts.CheckCluster = snapshotCreateCheckCluster
+	}
+	if flags.Changed("require-healthy") {
+		opts.RequireHealthy = snapshotCreateRequireHealthy
+	}
+	if flags.Changed("max-snapshots") {
+		if snapshotCreateMaxSnapshots < 0 {
+			return fmt.Errorf("invalid value %d for --max-snapshots: must not be negative", snapshotCreateMaxSnapshots)
+		}
+		opts.MaxSnapshots = snapshotCreateMaxSnapshots
+	}
+	if flags.Changed("prune-oldest") {
+		opts.PruneOldest = snapshotCreatePruneOldest
+	}
+	if flags.Changed("prune-by") {
+		opts.PruneBy = snapshot.PruneBasis(snapshotCreatePruneBy)
+	}
+	if flags.Changed("deduplicate") {
+		opts.Deduplicate = snapshotCreateDeduplicate
+	}
+	if flags.Changed("record-hostname") {
+		opts.RecordHostname = snapshotCreateRecordHostname
+	}
+	if flags.Changed("verify") {
+		opts.VerifyAfterCreate = snapshotCreateVerify
+	}
+	if flags.Changed("capture-logs") {
+		opts.CaptureLogs = snapshotCreateCaptureLogs
+	}
+	if snapshotCreateEstimate {
+		return estimateSnapshotSize(manager, opts)
 	}
 
 	// Ideally we would not use the deprecated syscall package,
@@ -76,10 +216,15 @@ func createSnapshot(ctx context.Context, args []string) error {
 		}
 	})
 	defer stopAfterFunc()
-	_, err = manager.Create(notifyCtx, name, snapshotDescription)
+	created, err := manager.CreateWithOptions(notifyCtx, name, opts)
 	if err != nil && !errors.Is(err, runner.ErrContextDone) {
 		return fmt.Errorf("failed to create snapshot: %w", err)
 	}
+	if err == nil && created.Verification != nil {
+		if err := writeVerification(*created.Verification); err != nil {
+			return err
+		}
+	}
 
 	// exclude snapshots directory from time machine backups if on macOS
 	if runtime.GOOS != "darwin" {
@@ -98,3 +243,49 @@ func createSnapshot(ctx context.Context, args []string) error {
 	}
 	return nil
 }
+
+// writeVerification shows the result of verifying the snapshot created.
+func writeVerification(verification snapshot.Verification) error {
+	if outputJSONFormat {
+		jsonBuffer, err := json.Marshal(struct {
+			Verification snapshot.Verification `json:"verification"`
+		}{verification})
+		if err != nil {
+			return fmt.Errorf("error json-converting verification result: %w", err)
+		}
+		fmt.Println(string(jsonBuffer))
+		return nil
+	}
+	fmt.Printf("Verified snapshot: %d files, %d of them by checksum.\n", verification.Files, verification.Checksummed)
+	return nil
+}
+
+// estimateSnapshotSize shows how much space a snapshot created with the given
+// options would take.
+func estimateSnapshotSize(manager *snapshot.Manager, opts snapshot.CreateOptions) error {
+	size, err := manager.EstimateSize(opts)
+	incomplete := errors.Is(err, snapshot.ErrSizeUnknown)
+	if err != nil && !incomplete {
+		return fmt.Errorf("failed to estimate snapshot size: %w", err)
+	}
+	if outputJSONFormat {
+		payload := struct {
+			EstimatedSize int64  `json:"estimatedSize"`
+			Incomplete    bool   `json:"incomplete"`
+			Error         string `json:"error,omitempty"`
+		}{EstimatedSize: size, Incomplete: incomplete}
+		if incomplete {
+			payload.Error = err.Error()
+		}
+		jsonBuffer, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("error json-converting size estimate: %w", err)
+		}
+		fmt.Println(string(jsonBuffer))
+	} else if incomplete {
+		fmt.Printf("The snapshot would take at least %s; %s.\n", formatSize(size), err)
+	} else {
+		fmt.Printf("The snapshot would take about %s.\n", formatSize(size))
+	}
+	return nil
+}
@@ -1,20 +1,45 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/spf13/cobra"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
 )
 
+var (
+	snapshotDeletePrefix string
+	snapshotDeleteYes    bool
+)
+
 var snapshotDeleteCmd = &cobra.Command{
-	Use:   "delete <id>",
+	Use:   "delete (<name> | --prefix <prefix>)",
 	Short: "Delete a snapshot",
-	Args:  cobra.ExactArgs(1),
+	Long: `Delete a snapshot.
+
+With --prefix, delete all snapshots whose names start with the given prefix
+instead; an empty prefix matches all snapshots. The matching snapshots are
+listed first, and are only deleted if --yes is given.
+
+Protected snapshots (see "rdctl snapshot protect") are never deleted: deleting
+one by name fails, and deleting by prefix skips them.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("prefix") {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true
-		err := deleteSnapshot(cmd, args)
+		var err error
+		if cmd.Flags().Changed("prefix") {
+			err = deleteSnapshotsByPrefix(snapshotDeletePrefix, snapshotDeleteYes)
+		} else {
+			err = deleteSnapshot(cmd, args)
+		}
 		return exitWithJSONOrErrorCondition(err)
 	},
 }
@@ -22,6 +47,8 @@ var snapshotDeleteCmd = &cobra.Command{
 func init() {
 	snapshotCmd.AddCommand(snapshotDeleteCmd)
 	snapshotDeleteCmd.Flags().BoolVarP(&outputJSONFormat, "json", "", false, "output json format")
+	snapshotDeleteCmd.Flags().StringVar(&snapshotDeletePrefix, "prefix", "", "delete all snapshots whose names start with this prefix")
+	snapshotDeleteCmd.Flags().BoolVarP(&snapshotDeleteYes, "yes", "y", false, "confirm deleting the snapshots matched by --prefix")
 }
 
 func deleteSnapshot(_ *cobra.Command, args []string) error {
@@ -29,8 +56,37 @@ func deleteSnapshot(_ *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot manager: %w", err)
 	}
+	defer manager.Close()
 	if err = manager.Delete(args[0]); err != nil {
 		return fmt.Errorf("failed to delete snapshot %q: %w", args[0], err)
 	}
 	return nil
 }
+
+func deleteSnapshotsByPrefix(prefix string, confirmed bool) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	snapshots, err := manager.ListByPrefix(prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshots match prefix %q", prefix)
+	}
+	sort.Sort(SortableSnapshots(snapshots))
+	if outputJSONFormat {
+		err = jsonOutput(snapshots)
+	} else {
+		err = tabularOutput(snapshots)
+	}
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return errors.New("not deleting the snapshots listed above; use --yes to confirm")
+	}
+	return manager.DeleteSnapshots(snapshots)
+}
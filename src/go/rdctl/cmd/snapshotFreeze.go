@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotFreezeCmd = &cobra.Command{
+	Use:   "freeze",
+	Short: "Keep snapshots from being created, deleted or restored",
+	Long: `Freeze the snapshots, as a safety measure before doing something risky.
+
+While the snapshots are frozen, they can't be created, deleted, restored,
+pruned, migrated, or repaired by "rdctl snapshot fsck --fix"; those commands
+fail until "rdctl snapshot unfreeze" is run. Listing, showing and copying
+snapshots still work, as do their notes and protection. Unlike protecting a
+single snapshot, freezing applies to all of them, and lasts until they are
+unfrozen, even across restarts. Whether they are frozen is shown by
+"rdctl snapshot capabilities".`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return exitWithJSONOrErrorCondition(setSnapshotsFrozen(true))
+	},
+}
+
+var snapshotUnfreezeCmd = &cobra.Command{
+	Use:   "unfreeze",
+	Short: "Allow frozen snapshots to be changed again",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return exitWithJSONOrErrorCondition(setSnapshotsFrozen(false))
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotFreezeCmd)
+	snapshotFreezeCmd.Flags().BoolVarP(&outputJSONFormat, "json", "", false, "output json format")
+	snapshotCmd.AddCommand(snapshotUnfreezeCmd)
+	snapshotUnfreezeCmd.Flags().BoolVarP(&outputJSONFormat, "json", "", false, "output json format")
+}
+
+func setSnapshotsFrozen(frozen bool) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	if frozen {
+		return manager.Freeze()
+	}
+	return manager.Unfreeze()
+}
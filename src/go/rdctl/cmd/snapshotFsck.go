@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var (
+	snapshotFsckFix      bool
+	snapshotFsckVerify   bool
+	snapshotFsckWorkers  int
+	snapshotFsckFailFast bool
+)
+
+var snapshotFsckFormat = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
+var snapshotFsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the snapshots directory for problems",
+	Long: `Check the snapshots directory for problems, such as incomplete snapshots,
+snapshots missing files, corrupt metadata and duplicate names.
+
+With --fix, also repair them where that is safe: incomplete snapshots are
+deleted, metadata is reconstructed or corrected, duplicate names are made
+unique, and snapshots that can't be repaired are moved to the quarantine
+directory inside the snapshots directory. Entries that are not snapshots are
+only reported. The command exits with an error if problems remain.
+
+With --verify, also read every file of each snapshot, to find files that can't
+be read, and files of deduplicated snapshots that don't match the checksum
+they are stored under. This reads all the snapshots, so several are checked at
+once; --workers sets how many, and 1 is best for spinning disks. With
+--fail-fast, stop at the first problem rather than checking everything.
+Problems are reported in the same order however many workers there are.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return fsckSnapshots(cmd, snapshot.FsckOptions{
+			Fix:      snapshotFsckFix,
+			Verify:   snapshotFsckVerify,
+			Workers:  snapshotFsckWorkers,
+			FailFast: snapshotFsckFailFast,
+		}, snapshotFsckFormat.String())
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotFsckCmd)
+	snapshotFsckCmd.Flags().BoolVar(&snapshotFsckFix, "fix", false, "repair the problems found")
+	snapshotFsckCmd.Flags().BoolVar(&snapshotFsckVerify, "verify", false, "read every file of each snapshot")
+	snapshotFsckCmd.Flags().IntVar(&snapshotFsckWorkers, "workers", snapshot.DefaultFsckWorkers, "the number of snapshots checked at once")
+	snapshotFsckCmd.Flags().BoolVar(&snapshotFsckFailFast, "fail-fast", false, "stop at the first problem")
+	snapshotFsckCmd.Flags().Var(&snapshotFsckFormat, "format", "output format")
+}
+
+func fsckSnapshots(cmd *cobra.Command, opts snapshot.FsckOptions, format string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
+	defer stop()
+	report, err := manager.FsckWithOptions(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writeFsckReport(report)
+	}
+	if unrepaired := report.Unrepaired(); unrepaired != 0 {
+		if opts.Fix {
+			return fmt.Errorf("%d problems could not be repaired", unrepaired)
+		}
+		return fmt.Errorf("found %d problems; use --fix to repair them", unrepaired)
+	}
+	return nil
+}
+
+func writeFsckReport(report snapshot.FsckReport) {
+	fmt.Printf("Checked %d snapshots.\n", report.Checked)
+	if len(report.Problems) == 0 {
+		fmt.Println("No problems found.")
+		return
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(writer, "ENTRY\tNAME\tPROBLEM\tDETAIL\tREPAIR\n")
+	for _, problem := range report.Problems {
+		repair := problem.Repair
+		switch {
+		case repair == "":
+			repair = "none"
+		case problem.Error != "":
+			repair = fmt.Sprintf("%s: failed: %s", repair, problem.Error)
+		case problem.Repaired:
+			repair += ": done"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", problem.Entry,
+			truncateAtNewlineOrMaxRunes(problem.Name, tableMaxRunes), problem.Kind,
+			truncateAtNewlineOrMaxRunes(problem.Detail, tableMaxRunes), repair)
+	}
+	writer.Flush()
+}
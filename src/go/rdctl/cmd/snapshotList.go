@@ -34,6 +34,9 @@ func (snapshots SortableSnapshots) Swap(i, j int) {
 	snapshots[i], snapshots[j] = snapshots[j], snapshots[i]
 }
 
+var snapshotListLastUsed bool
+var snapshotListHost bool
+
 var snapshotListCmd = &cobra.Command{
 	Use:     "list",
 	Aliases: []string{"ls"},
@@ -48,6 +51,8 @@ var snapshotListCmd = &cobra.Command{
 func init() {
 	snapshotCmd.AddCommand(snapshotListCmd)
 	snapshotListCmd.Flags().BoolVar(&outputJSONFormat, "json", false, "output json format")
+	snapshotListCmd.Flags().BoolVar(&snapshotListLastUsed, "last-used", false, "show when each snapshot was last restored")
+	snapshotListCmd.Flags().BoolVar(&snapshotListHost, "host", false, "show the machine each snapshot was created on")
 }
 
 func listSnapshot() error {
@@ -55,6 +60,7 @@ func listSnapshot() error {
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot manager: %w", err)
 	}
+	defer manager.Close()
 	snapshots, err := manager.List(false)
 	if err != nil {
 		return fmt.Errorf("failed to list snapshots: %w", err)
@@ -84,11 +90,37 @@ func tabularOutput(snapshots []snapshot.Snapshot) error {
 		return nil
 	}
 	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
-	fmt.Fprintf(writer, "NAME\tCREATED\tDESCRIPTION\n")
+	columns := []string{"NAME", "CREATED"}
+	if snapshotListLastUsed {
+		columns = append(columns, "LAST USED")
+	}
+	if snapshotListHost {
+		columns = append(columns, "HOST")
+	}
+	columns = append(columns, "DESCRIPTION")
+	fmt.Fprintln(writer, strings.Join(columns, "\t"))
 	for _, aSnapshot := range snapshots {
-		prettyCreated := aSnapshot.Created.Format(time.RFC1123)
-		desc := truncateAtNewlineOrMaxRunes(aSnapshot.Description, tableMaxRunes)
-		fmt.Fprintf(writer, "%s\t%s\t%s\n", aSnapshot.Name, prettyCreated, desc)
+		name := aSnapshot.Name
+		if aSnapshot.Protected {
+			name += " (protected)"
+		}
+		fields := []string{name, aSnapshot.Created.Format(time.RFC1123)}
+		if snapshotListLastUsed {
+			prettyLastUsed := "never"
+			if !aSnapshot.LastUsed.IsZero() {
+				prettyLastUsed = aSnapshot.LastUsed.Format(time.RFC1123)
+			}
+			fields = append(fields, prettyLastUsed)
+		}
+		if snapshotListHost {
+			host := "unknown"
+			if aSnapshot.Host != nil {
+				host = aSnapshot.Host.String()
+			}
+			fields = append(fields, host)
+		}
+		fields = append(fields, truncateAtNewlineOrMaxRunes(aSnapshot.Description, tableMaxRunes))
+		fmt.Fprintln(writer, strings.Join(fields, "\t"))
 	}
 	writer.Flush()
 	return nil
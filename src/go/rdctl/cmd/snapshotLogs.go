@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotLogsLast bool
+var snapshotLogsCaptured bool
+
+var snapshotLogsCmd = &cobra.Command{
+	Use:   "logs [<name>]",
+	Short: "Show the logs of snapshot operations",
+	Long: `Show the logs of the create and restore operations done on a snapshot.
+With --last, only show the log of the most recent operation; if no name is
+given, this is the most recent operation on any snapshot, which may include
+a snapshot that failed to be created.
+
+With --captured, show the logs of Rancher Desktop captured in the snapshot
+when it was created with "rdctl snapshot create --capture-logs" instead, each
+one after a line with its name.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if snapshotLogsCaptured {
+			if len(args) == 0 || snapshotLogsLast {
+				return errors.New("--captured requires a snapshot name, and can't be used with --last")
+			}
+			cmd.SilenceUsage = true
+			return showCapturedLogs(cmd.OutOrStdout(), args[0])
+		}
+		if len(args) == 0 && !snapshotLogsLast {
+			return errors.New("requires a snapshot name, or --last")
+		}
+		cmd.SilenceUsage = true
+		return showSnapshotLogs(cmd.OutOrStdout(), args)
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotLogsCmd)
+	snapshotLogsCmd.Flags().BoolVar(&snapshotLogsLast, "last", false, "only show the log of the most recent operation")
+	snapshotLogsCmd.Flags().BoolVar(&snapshotLogsCaptured, "captured", false, "show the logs captured in the snapshot instead")
+}
+
+func showSnapshotLogs(output io.Writer, args []string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+
+	var logPaths []string
+	if len(args) == 0 {
+		logPath, err := manager.LastOperationLog()
+		if err != nil {
+			return err
+		}
+		logPaths = []string{logPath}
+	} else {
+		target, err := manager.Snapshot(args[0])
+		if err != nil {
+			return err
+		}
+		logPaths, err = manager.OperationLogs(target)
+		if err != nil {
+			return err
+		}
+		if snapshotLogsLast {
+			logPaths = logPaths[len(logPaths)-1:]
+		}
+	}
+	for _, logPath := range logPaths {
+		contents, err := os.ReadFile(logPath)
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot log: %w", err)
+		}
+		if _, err := output.Write(contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// showCapturedLogs writes the logs captured in the snapshot to output.
+func showCapturedLogs(output io.Writer, name string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	target, err := manager.Snapshot(name)
+	if err != nil {
+		return err
+	}
+	return manager.ReadCapturedLogs(target, func(logName string, contents io.Reader) error {
+		if _, err := fmt.Fprintf(output, "==> %s <==\n", logName); err != nil {
+			return err
+		}
+		_, err := io.Copy(output, contents)
+		return err
+	})
+}
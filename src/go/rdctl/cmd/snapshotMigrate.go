@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotMigrateTo string
+
+var snapshotMigrateFormat = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
+var snapshotMigrateCmd = &cobra.Command{
+	Use:   "migrate --to <directory>",
+	Short: "Move all snapshots to another directory",
+	Long: `Move all snapshots to another directory, such as one on a new drive.
+
+Each snapshot is copied, and every file read back once it is copied, before
+the original is removed; a snapshot that fails to copy is left where it is,
+and the other snapshots are still moved. Running the command again after a
+failure only moves the snapshots that are left. The command exits with an
+error if any snapshot could not be moved.
+
+The snapshots directory is not changed: to keep using the moved snapshots,
+replace the snapshots directory with a symlink to the new directory.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if snapshotMigrateTo == "" {
+			return errors.New(`the "--to" option is required`)
+		}
+		cmd.SilenceUsage = true
+		return migrateSnapshots(cmd, snapshotMigrateFormat.String())
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotMigrateCmd)
+	snapshotMigrateCmd.Flags().StringVar(&snapshotMigrateTo, "to", "", "the directory to move the snapshots to")
+	snapshotMigrateCmd.Flags().Var(&snapshotMigrateFormat, "format", "output format")
+}
+
+func migrateSnapshots(cmd *cobra.Command, format string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
+	defer stop()
+	report, migrateErr := manager.Migrate(ctx, snapshotMigrateTo)
+	if migrateErr != nil && report.Destination == "" {
+		return fmt.Errorf("failed to migrate snapshots: %w", migrateErr)
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writeMigrateReport(report)
+	}
+	if migrateErr != nil {
+		return fmt.Errorf("failed to migrate snapshots: %w", migrateErr)
+	}
+	if failed := report.Failed(); failed != 0 {
+		return fmt.Errorf("%d snapshots could not be moved; run the command again to retry them", failed)
+	}
+	return nil
+}
+
+func writeMigrateReport(report snapshot.MigrateReport) {
+	if len(report.Snapshots) == 0 {
+		fmt.Println("No snapshots to move.")
+		return
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(writer, "ID\tNAME\tSTATUS\n")
+	for _, migrated := range report.Snapshots {
+		status := string(migrated.Status)
+		if migrated.Error != "" {
+			status = fmt.Sprintf("%s: %s", status, migrated.Error)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", migrated.ID,
+			truncateAtNewlineOrMaxRunes(migrated.Name, tableMaxRunes), status)
+	}
+	writer.Flush()
+	fmt.Printf("Moved snapshots are in %s.\n", report.Destination)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotNotesEdit bool
+
+var snapshotNotesCmd = &cobra.Command{
+	Use:   "notes <name>",
+	Short: "Show or edit the notes of a snapshot",
+	Long: `Show the notes of a snapshot: free-form text, usually Markdown, such as the
+steps to reproduce a problem, that is longer than a description. Nothing is
+shown if the snapshot has no notes.
+
+With --edit, open the notes in the editor named by $VISUAL or $EDITOR, and save
+them once the editor exits; emptying them removes them. Notes are copied along
+with the snapshot, but are never restored.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		if snapshotNotesEdit {
+			return editSnapshotNotes(cmd, args[0])
+		}
+		return showSnapshotNotes(cmd, args[0])
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotNotesCmd)
+	snapshotNotesCmd.Flags().BoolVar(&snapshotNotesEdit, "edit", false, "edit the notes")
+}
+
+func showSnapshotNotes(cmd *cobra.Command, nameOrID string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	aSnapshot, err := manager.Stat(nameOrID)
+	if err != nil {
+		return fmt.Errorf("failed to show notes of snapshot %q: %w", nameOrID, err)
+	}
+	notes, err := manager.GetNotes(aSnapshot.ID)
+	if err != nil {
+		return fmt.Errorf("failed to show notes of snapshot %q: %w", aSnapshot.Name, err)
+	}
+	_, err = fmt.Fprint(cmd.OutOrStdout(), notes)
+	return err
+}
+
+func editSnapshotNotes(cmd *cobra.Command, nameOrID string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	aSnapshot, err := manager.Stat(nameOrID)
+	if err != nil {
+		return fmt.Errorf("failed to edit notes of snapshot %q: %w", nameOrID, err)
+	}
+	notes, err := manager.GetNotes(aSnapshot.ID)
+	if err != nil {
+		return fmt.Errorf("failed to edit notes of snapshot %q: %w", aSnapshot.Name, err)
+	}
+	edited, err := runEditor(cmd, notes)
+	if err != nil {
+		return fmt.Errorf("failed to edit notes of snapshot %q: %w", aSnapshot.Name, err)
+	}
+	if edited == notes {
+		return nil
+	}
+	if strings.TrimSpace(edited) == "" {
+		edited = ""
+	}
+	if err := manager.SetNotes(aSnapshot.ID, edited); err != nil {
+		return fmt.Errorf("failed to save notes of snapshot %q: %w", aSnapshot.Name, err)
+	}
+	return nil
+}
+
+// runEditor opens the contents in the user's editor, and returns them as they
+// are once the editor exits.
+func runEditor(cmd *cobra.Command, contents string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	// The editor may be given with arguments, as in "code --wait".
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		return "", errors.New("no editor is set")
+	}
+	file, err := os.CreateTemp("", "rdctl-notes-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(contents)
+	if err = errors.Join(err, file.Close()); err != nil {
+		return "", err
+	}
+	//nolint:gosec // Running the editor the user chose is the point.
+	editorCmd := exec.CommandContext(cmd.Context(), args[0], append(args[1:], file.Name())...)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", args[0], err)
+	}
+	edited, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(edited), nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotPathFormat = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
+var snapshotPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Show the path of the snapshots directory",
+	Long: `Show the path of the directory the snapshots are kept in, as snapshot
+commands use it: with the environment of this command taken into account, and
+any symlinks in it resolved. The directory may not exist yet if no snapshot
+was ever created.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return showSnapshotPath(cmd.OutOrStdout(), snapshotPathFormat.String())
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotPathCmd)
+	snapshotPathCmd.Flags().Var(&snapshotPathFormat, "format", "output format")
+}
+
+func showSnapshotPath(output io.Writer, format string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	if format == "json" {
+		return json.NewEncoder(output).Encode(struct {
+			Snapshots string `json:"snapshots"`
+		}{manager.Snapshots})
+	}
+	_, err = fmt.Fprintln(output, manager.Snapshots)
+	return err
+}
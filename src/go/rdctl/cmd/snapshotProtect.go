@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotProtectCmd = &cobra.Command{
+	Use:   "protect <name>",
+	Short: "Protect a snapshot from being deleted",
+	Long: `Protect a snapshot from being deleted.
+
+"rdctl snapshot delete" refuses to delete a protected snapshot, and skips it
+when deleting snapshots by prefix. Snapshots created with --prune-oldest never
+prune it either. Use "rdctl snapshot unprotect" to allow it to be deleted again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return exitWithJSONOrErrorCondition(setSnapshotProtected(args[0], true))
+	},
+}
+
+var snapshotUnprotectCmd = &cobra.Command{
+	Use:   "unprotect <name>",
+	Short: "Allow a protected snapshot to be deleted",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return exitWithJSONOrErrorCondition(setSnapshotProtected(args[0], false))
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotProtectCmd)
+	snapshotProtectCmd.Flags().BoolVarP(&outputJSONFormat, "json", "", false, "output json format")
+	snapshotCmd.AddCommand(snapshotUnprotectCmd)
+	snapshotUnprotectCmd.Flags().BoolVarP(&outputJSONFormat, "json", "", false, "output json format")
+}
+
+func setSnapshotProtected(name string, protected bool) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	aSnapshot, err := manager.Snapshot(name)
+	if err != nil {
+		return err
+	}
+	if _, err := manager.SetProtected(aSnapshot.ID, protected); err != nil {
+		action := "protect"
+		if !protected {
+			action = "unprotect"
+		}
+		return fmt.Errorf("failed to %s snapshot %q: %w", action, name, err)
+	}
+	return nil
+}
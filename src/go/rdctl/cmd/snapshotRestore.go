@@ -2,9 +2,13 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -13,26 +17,99 @@ import (
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
 )
 
+var (
+	snapshotRestoreForce      bool
+	snapshotRestoreResume     bool
+	snapshotRestoreRateLimit  string
+	snapshotRestoreDigest     string
+	snapshotRestoreLatest     bool
+	snapshotRestoreComponents []string
+	snapshotRestoreLive       bool
+	snapshotRestoreMinimal    bool
+)
+
 var snapshotRestoreCmd = &cobra.Command{
-	Use:   "restore <id>",
+	Use:   "restore (<name> | --latest)",
 	Short: "Restore a snapshot",
-	Args:  cobra.ExactArgs(1),
+	Long: `Restore a snapshot.
+
+The progress of the restore is recorded as files are restored. If the restore
+is interrupted, Rancher Desktop is left partially restored and can't be
+snapshotted until the restore is completed: run the same restore again with
+--resume to only restore the files that are still missing or were changed
+since, or without it to start over.
+
+Use --rate-limit to limit how fast the snapshot is read, for example "50M" for
+50 MiB per second, to reduce the impact on other disk activity.
+
+Use --digest with the digest shown by "rdctl snapshot list --json" to only
+restore the snapshot if it hasn't been replaced or modified since it was
+listed.
+
+With --latest, restore the most recently created snapshot instead of naming
+one, to undo everything done since.
+
+Snapshots record the Kubernetes version in use when they were created; a
+warning is shown when restoring a snapshot of another version.
+
+Use --components to only restore some of the components of the snapshot, such
+as the settings; the others are left as they are.
+
+With --live, components the running app can reload (the settings) are restored
+without stopping the backend, and the app applies them as "rdctl set" would;
+settings it can only apply by restarting the backend still restart it. If other
+components are restored, the backend is stopped as usual. Either way, which
+components were reloaded, and which needed the backend to be restarted, is
+shown. For example, to restore only the settings of a snapshot:
+
+  rdctl snapshot restore --live --components settings my-snapshot
+
+With --minimal, only the files that differ from the snapshot are restored, and
+the others are left alone; the files reverted are listed. Every file that has
+the same size as in the snapshot is read to compare it, which is still faster
+than copying it.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if snapshotRestoreLatest {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true
-		return exitWithJSONOrErrorCondition(restoreSnapshot(args[0]))
+		name := ""
+		if len(args) > 0 {
+			name = args[0]
+		}
+		return exitWithJSONOrErrorCondition(restoreSnapshot(name))
 	},
 }
 
 func init() {
 	snapshotCmd.AddCommand(snapshotRestoreCmd)
 	snapshotRestoreCmd.Flags().BoolVarP(&outputJSONFormat, "json", "", false, "output json format")
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotRestoreForce, "force", false, "restore even if the snapshot was created by an incompatible version")
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotRestoreResume, "resume", false, "continue an interrupted restore of the snapshot")
+	snapshotRestoreCmd.Flags().StringVar(&snapshotRestoreRateLimit, "rate-limit", "0", "maximum bytes per second to read, with an optional K, M or G suffix; 0 for no limit")
+	snapshotRestoreCmd.Flags().StringVar(&snapshotRestoreDigest, "digest", "", "only restore if the snapshot still has this digest")
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotRestoreLatest, "latest", false, "restore the most recently created snapshot")
+	snapshotRestoreCmd.Flags().StringSliceVar(&snapshotRestoreComponents, "components", nil,
+		fmt.Sprintf("only restore these components (%s)", strings.Join(snapshot.RestorableComponents(), ", ")))
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotRestoreLive, "live", false, "restore the components the running app can reload without restarting the backend")
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotRestoreMinimal, "minimal", false, "only restore the files that differ from the snapshot")
 }
 
+// restoreSnapshot restores the named snapshot, or the latest one if name is
+// empty.
 func restoreSnapshot(name string) error {
+	rateLimit, err := parseSize(snapshotRestoreRateLimit)
+	if err != nil {
+		return fmt.Errorf("invalid --rate-limit: %w", err)
+	}
 	manager, err := snapshot.NewManager()
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot manager: %w", err)
 	}
+	defer manager.Close()
 
 	// Ideally we would not use the deprecated syscall package,
 	// but it works well with all expected scenarios and allows us
@@ -45,9 +122,91 @@ func restoreSnapshot(name string) error {
 		}
 	})
 	defer stopAfterFunc()
-	err = manager.Restore(ctx, name)
+	opts := snapshot.RestoreOptions{
+		Force:          snapshotRestoreForce,
+		Resume:         snapshotRestoreResume,
+		RateLimit:      rateLimit,
+		ExpectedDigest: snapshotRestoreDigest,
+		Components:     snapshotRestoreComponents,
+		Live:           snapshotRestoreLive,
+		MinimalChanges: snapshotRestoreMinimal,
+	}
+	var result snapshot.RestoreResult
+	if name == "" {
+		var latest snapshot.Snapshot
+		latest, result, err = manager.RestoreLatest(ctx, opts)
+		if latest.Name == "" {
+			// No snapshot is returned if there is no single latest
+			// snapshot to restore.
+			return fmt.Errorf("failed to restore the latest snapshot: %w", err)
+		}
+		name = latest.Name
+		if err == nil && !outputJSONFormat {
+			fmt.Printf("Restored snapshot %q\n", name)
+		}
+	} else {
+		result, err = manager.RestoreWithResult(ctx, name, opts)
+	}
+	if err == nil && (snapshotRestoreLive || snapshotRestoreMinimal) {
+		if err := writeRestoreResult(result); err != nil {
+			return err
+		}
+	}
+	if errors.Is(err, snapshot.ErrDataReset) && errors.Is(err, snapshot.ErrRestoreIncomplete) {
+		return fmt.Errorf("failed to restore snapshot %q: %w; run `rdctl snapshot restore --resume %s` to continue", name, err, name)
+	}
 	if err != nil && !errors.Is(err, runner.ErrContextDone) {
 		return fmt.Errorf("failed to restore snapshot %q: %w", name, err)
 	}
 	return nil
 }
+
+// writeRestoreResult shows which components were reloaded by the running app,
+// and which needed the backend to be restarted; with --minimal, it also shows
+// the files reverted.
+func writeRestoreResult(result snapshot.RestoreResult) error {
+	if outputJSONFormat {
+		jsonBuffer, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("error json-converting restore result: %w", err)
+		}
+		fmt.Println(string(jsonBuffer))
+		return nil
+	}
+	if len(result.Reloaded) > 0 {
+		fmt.Printf("Reloaded without restarting: %s\n", strings.Join(result.Reloaded, ", "))
+	}
+	if len(result.RestartRequired) > 0 {
+		fmt.Printf("Restarted the backend, as these can't be reloaded: %s\n", strings.Join(result.RestartRequired, ", "))
+	}
+	if snapshotRestoreMinimal {
+		if len(result.Reverted) == 0 {
+			fmt.Println("No files differed from the snapshot")
+		} else {
+			fmt.Printf("Reverted %d files:\n", len(result.Reverted))
+			for _, path := range result.Reverted {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+	}
+	return nil
+}
+
+// parseSize parses a number of bytes with an optional binary unit suffix,
+// such as "512K", "50M" or "2GiB".
+func parseSize(value string) (int64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B"), "I")
+	multiplier := int64(1)
+	if index := strings.IndexAny(number, "KMGT"); index >= 0 && index == len(number)-1 {
+		multiplier = int64(1) << (10 * (strings.IndexByte("KMGT", number[index]) + 1))
+		number = number[:index]
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%q is not a size", value)
+	}
+	if size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("%q is too large", value)
+	}
+	return size * multiplier, nil
+}
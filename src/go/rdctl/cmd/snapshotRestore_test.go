@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		Input    string
+		Expected int64
+		Error    string
+	}{
+		{Input: "1024", Expected: 1024},
+		{Input: "10M", Expected: 10 << 20},
+		{Input: "2GiB", Expected: 2 << 30},
+		{Input: " 1kb ", Expected: 1 << 10},
+		{Input: "8191P", Error: `"8191P" is not a size`},
+		{Input: "-1K", Error: `"-1K" is not a size`},
+		{Input: "9000000000G", Error: `"9000000000G" is too large`},
+		{Input: "8388608T", Error: `"8388608T" is too large`},
+		{Input: "8388607T", Expected: 8388607 << 40},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Input, func(t *testing.T) {
+			size, err := parseSize(testCase.Input)
+			if testCase.Error != "" {
+				assert.EqualError(t, err, testCase.Error)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, testCase.Expected, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotShowFormat = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
+var snapshotShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show the details of a snapshot",
+	Long: `Show the details of a single snapshot, given its name or its ID.
+
+With --format json, the snapshot is written as a single JSON object, with the
+same fields as "rdctl snapshot list --json" plus the ID.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return showSnapshot(args[0], snapshotShowFormat.String())
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotShowCmd)
+	snapshotShowCmd.Flags().Var(&snapshotShowFormat, "format", "output format")
+}
+
+func showSnapshot(nameOrID, format string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	aSnapshot, err := manager.Stat(nameOrID)
+	if err != nil {
+		return fmt.Errorf("failed to show snapshot %q: %w", nameOrID, err)
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(aSnapshot)
+	}
+	writeSnapshotDetails(*aSnapshot)
+	return nil
+}
+
+func writeSnapshotDetails(aSnapshot snapshot.Snapshot) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Name:\t%s\n", aSnapshot.Name)
+	fmt.Fprintf(writer, "ID:\t%s\n", aSnapshot.ID)
+	fmt.Fprintf(writer, "Created:\t%s\n", aSnapshot.Created.Format(time.RFC1123))
+	lastUsed := "never"
+	if !aSnapshot.LastUsed.IsZero() {
+		lastUsed = aSnapshot.LastUsed.Format(time.RFC1123)
+	}
+	fmt.Fprintf(writer, "Last used:\t%s\n", lastUsed)
+	if aSnapshot.Protected {
+		fmt.Fprintf(writer, "Protected:\tyes\n")
+	}
+	if aSnapshot.Host != nil {
+		fmt.Fprintf(writer, "Host:\t%s\n", aSnapshot.Host)
+	}
+	if aSnapshot.SettingsVersion != 0 {
+		fmt.Fprintf(writer, "Settings version:\t%d\n", aSnapshot.SettingsVersion)
+	}
+	for _, component := range slices.Sorted(maps.Keys(aSnapshot.ComponentVersions)) {
+		fmt.Fprintf(writer, "Version of %s:\t%s\n", component, aSnapshot.ComponentVersions[component])
+	}
+	if aSnapshot.Digest != "" {
+		fmt.Fprintf(writer, "Digest:\t%s\n", aSnapshot.Digest)
+	}
+	if health := aSnapshot.ClusterHealth; health != nil {
+		state := "healthy"
+		if !health.Healthy {
+			state = "not healthy"
+		}
+		fmt.Fprintf(writer, "Cluster:\t%s (checked %s)\n", state, health.Checked.Format(time.RFC1123))
+		for _, problem := range health.Problems {
+			fmt.Fprintf(writer, "\t- %s\n", problem)
+		}
+	}
+	if logs := aSnapshot.CapturedLogs; logs != nil {
+		truncated := ""
+		if logs.Truncated {
+			truncated = ", truncated"
+		}
+		fmt.Fprintf(writer, "Captured logs:\t%d files, %s%s\n", len(logs.Files), formatSize(logs.Size), truncated)
+	}
+	description := strings.TrimSpace(aSnapshot.Description)
+	if description == "" {
+		fmt.Fprintf(writer, "Description:\t\n")
+	} else {
+		fmt.Fprintf(writer, "Description:\t%s\n", strings.ReplaceAll(description, "\n", "\n\t"))
+	}
+	writer.Flush()
+}
@@ -34,5 +34,6 @@ func unlockSnapshot(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot manager: %w", err)
 	}
+	defer manager.Close()
 	return manager.Unlock(ctx, manager.Paths, false)
 }
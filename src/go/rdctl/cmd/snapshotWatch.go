@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/snapshot"
+)
+
+var snapshotWatchFormat = enumValue{
+	val:     "text",
+	allowed: []string{"text", "json"},
+}
+
+var snapshotWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Show changes to snapshots as they happen",
+	Long: `Show snapshots being created, restored, changed and deleted, and the
+progress of snapshot operations, as they happen, until interrupted.
+
+With --format json, each event is written as a JSON object on a line of its
+own, with these fields:
+
+  type       created, deleted, updated, restored, started, progress, failed
+             or error
+  time       when the change was seen
+  id, name   the snapshot
+  snapshot   the snapshot's details, as in "rdctl snapshot show", for created
+             and updated events
+  operation  create or restore, for the events of an operation
+  level      info, warning or error, for progress events
+  message    the progress made, why an operation failed, or why the snapshots
+             can't be watched
+  dropped    the number of progress events dropped before this one, when
+             they are not read in time
+
+While the snapshots can't be watched, for example when they are on a share
+that is not mounted, watch keeps trying, and reports what changed once it can
+watch them again.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return watchSnapshots(cmd, snapshotWatchFormat.String())
+	},
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotWatchCmd)
+	snapshotWatchCmd.Flags().Var(&snapshotWatchFormat, "format", "output format")
+}
+
+func watchSnapshots(cmd *cobra.Command, format string) error {
+	manager, err := snapshot.NewManager()
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	defer manager.Close()
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
+	defer stop()
+	output := cmd.OutOrStdout()
+	encoder := json.NewEncoder(output)
+	for event := range manager.Watch(ctx, snapshot.WatchOptions{}) {
+		if format == "json" {
+			err = encoder.Encode(event)
+		} else {
+			err = writeSnapshotEvent(output, event)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSnapshotEvent writes an event as a line of text.
+func writeSnapshotEvent(output io.Writer, event snapshot.Event) error {
+	fields := []string{event.Time.Format(time.TimeOnly), string(event.Type)}
+	if event.Name != "" {
+		fields = append(fields, fmt.Sprintf("%q", event.Name))
+	} else if event.ID != "" {
+		fields = append(fields, event.ID)
+	}
+	if event.Level != "" && event.Level != "info" {
+		fields = append(fields, event.Level+":")
+	}
+	if event.Message != "" {
+		fields = append(fields, event.Message)
+	}
+	if event.Dropped > 0 {
+		fields = append(fields, fmt.Sprintf("(%d progress events dropped)", event.Dropped))
+	}
+	_, err := fmt.Fprintln(output, strings.Join(fields, " "))
+	return err
+}
@@ -12,7 +12,10 @@ import (
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/process"
 )
 
-func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCache bool) error {
+// DeleteDataWithProgress is DeleteData, reporting each path it removes to
+// progress, which may be nil.  Cancelling the context stops it before the
+// next path.
+func DeleteDataWithProgress(ctx context.Context, appPaths *paths.Paths, removeKubernetesCache bool, progress ProgressFunc) error {
 	if err := autostart.EnsureAutostart(ctx, false); err != nil {
 		logrus.Errorf("Failed to remove autostart configuration: %s", err)
 	}
@@ -21,6 +24,15 @@ func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCach
 		logrus.Errorf("Failed to stop extension processes, ignoring: %s", err)
 	}
 
+	pathList, err := PathsToDelete(appPaths, removeKubernetesCache)
+	if err != nil {
+		return err
+	}
+	return deleteUnixLikeData(ctx, appPaths, pathList, progress)
+}
+
+// PathsToDelete returns the paths that a factory reset removes.
+func PathsToDelete(appPaths *paths.Paths, removeKubernetesCache bool) ([]string, error) {
 	pathList := []string{
 		appPaths.AltAppHome,
 		appPaths.Config,
@@ -45,5 +57,5 @@ func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCach
 	} else {
 		pathList = append(pathList, filepath.Join(appPaths.Cache, "updater-longhorn.json"))
 	}
-	return deleteUnixLikeData(ctx, appPaths, pathList)
+	return pathList, nil
 }
@@ -12,18 +12,30 @@ import (
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/process"
 )
 
-func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCache bool) error {
+// DeleteDataWithProgress is DeleteData, reporting each path it removes to
+// progress, which may be nil.  Cancelling the context stops it before the
+// next path.
+func DeleteDataWithProgress(ctx context.Context, appPaths *paths.Paths, removeKubernetesCache bool, progress ProgressFunc) error {
 	if err := autostart.EnsureAutostart(ctx, false); err != nil {
 		logrus.Errorf("Failed to remove autostart configuration: %s", err)
 	}
 
-	homeDir, err := os.UserHomeDir()
+	if err := process.TerminateProcessInDirectory(appPaths.ExtensionRoot, false); err != nil {
+		logrus.Errorf("Failed to stop extension processes, ignoring: %s", err)
+	}
+
+	pathList, err := PathsToDelete(appPaths, removeKubernetesCache)
 	if err != nil {
-		logrus.Errorf("Error getting home directory: %s", err)
+		return err
 	}
+	return deleteUnixLikeData(ctx, appPaths, pathList, progress)
+}
 
-	if err := process.TerminateProcessInDirectory(appPaths.ExtensionRoot, false); err != nil {
-		logrus.Errorf("Failed to stop extension processes, ignoring: %s", err)
+// PathsToDelete returns the paths that a factory reset removes.
+func PathsToDelete(appPaths *paths.Paths, removeKubernetesCache bool) ([]string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		logrus.Errorf("Error getting home directory: %s", err)
 	}
 
 	pathList := []string{
@@ -49,5 +61,5 @@ func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCach
 		pathList = append(pathList, filepath.Join(appPaths.Cache, "updater-longhorn.json"))
 	}
 	pathList = append(pathList, appHomeDirectories(appPaths)...)
-	return deleteUnixLikeData(ctx, appPaths, pathList)
+	return pathList, nil
 }
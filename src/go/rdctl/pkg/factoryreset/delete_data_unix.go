@@ -77,14 +77,12 @@ func appHomeDirectories(appPaths *paths.Paths) []string {
 // because there isn't really a dependency graph here.
 // For example, if we can't delete the Lima VM, that doesn't mean we can't remove docker files
 // or pull the path settings out of the shell profile files.
-func deleteUnixLikeData(ctx context.Context, appPaths *paths.Paths, pathList []string) error {
+func deleteUnixLikeData(ctx context.Context, appPaths *paths.Paths, pathList []string, progress ProgressFunc) error {
 	if err := deleteLimaVM(ctx); err != nil {
 		logrus.Errorf("Error trying to delete the Lima VM: %s\n", err)
 	}
-	for _, currentPath := range pathList {
-		if err := os.RemoveAll(currentPath); err != nil {
-			logrus.Errorf("Error trying to remove %s: %s", currentPath, err)
-		}
+	if err := removePaths(ctx, pathList, progress); err != nil {
+		return err
 	}
 	if err := clearDockerContext(); err != nil {
 		logrus.Errorf("Error trying to clear the docker context %s", err)
@@ -13,7 +13,10 @@ import (
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/wsl"
 )
 
-func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCache bool) error {
+// DeleteDataWithProgress is DeleteData, reporting each path it removes to
+// progress, which may be nil.  Cancelling the context stops it before the
+// next path.
+func DeleteDataWithProgress(ctx context.Context, appPaths *paths.Paths, removeKubernetesCache bool, progress ProgressFunc) error {
 	if err := autostart.EnsureAutostart(ctx, false); err != nil {
 		logrus.Errorf("Failed to remove autostart configuration: %s", err)
 	}
@@ -28,7 +31,7 @@ func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCach
 	if err := process.TerminateProcessInDirectory(appPaths.ExtensionRoot, false); err != nil {
 		logrus.Errorf("Failed to stop extension processes, ignoring: %s", err)
 	}
-	if err := deleteWindowsData(!removeKubernetesCache, "rancher-desktop"); err != nil {
+	if err := deleteWindowsData(ctx, !removeKubernetesCache, "rancher-desktop", progress); err != nil {
 		logrus.Errorf("could not delete data: %s", err)
 		return err
 	}
@@ -39,3 +42,8 @@ func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCach
 	logrus.Infoln("successfully cleared data.")
 	return nil
 }
+
+// PathsToDelete returns the paths that a factory reset removes.
+func PathsToDelete(_ *paths.Paths, removeKubernetesCache bool) ([]string, error) {
+	return getDirectoriesToDelete(!removeKubernetesCache, "rancher-desktop")
+}
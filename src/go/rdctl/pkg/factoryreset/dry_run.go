@@ -0,0 +1,141 @@
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package factoryreset
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
+)
+
+// PathSize is a path that a factory reset would remove, with the space that
+// removing it would reclaim.
+type PathSize struct {
+	Path string `json:"path"`
+	// Size is the total size of the files under the path that could be read,
+	// in bytes.
+	Size int64 `json:"size"`
+	// Unknown lists the path itself, or the subdirectories under it, whose
+	// size could not be determined; if it is not empty, Size is a lower
+	// bound.
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// DryRunResult describes what a factory reset would remove.
+type DryRunResult struct {
+	// Paths lists the existing paths that would be removed.
+	Paths []PathSize `json:"paths"`
+	// Reclaimable is the sum of the sizes of all the paths, in bytes.
+	Reclaimable int64 `json:"reclaimable"`
+	// Incomplete is set if the size of any path is not fully known, in which
+	// case Reclaimable is a lower bound.
+	Incomplete bool `json:"incomplete"`
+}
+
+// DryRun reports the paths that DeleteData would remove and how much space
+// removing them would reclaim, without removing anything.  Paths that do not
+// exist are left out.
+func DryRun(appPaths *paths.Paths, removeKubernetesCache bool) (DryRunResult, error) {
+	pathList, err := PathsToDelete(appPaths, removeKubernetesCache)
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	result := DryRunResult{Paths: []PathSize{}}
+	for _, path := range withoutNestedPaths(pathList) {
+		pathSize, ok := sizeOf(path)
+		if !ok {
+			continue
+		}
+		result.Paths = append(result.Paths, pathSize)
+		result.Reclaimable += pathSize.Size
+		if len(pathSize.Unknown) > 0 {
+			result.Incomplete = true
+		}
+	}
+	return result, nil
+}
+
+// withoutNestedPaths returns the paths in the list, in order, leaving out
+// duplicates and paths inside another path in the list, so that nothing is
+// counted twice.
+func withoutNestedPaths(pathList []string) []string {
+	var result []string
+	for i, path := range pathList {
+		if path == "" {
+			continue
+		}
+		nested := false
+		for j, other := range pathList {
+			if other == "" || i == j {
+				continue
+			}
+			if (other == path && j < i) || isInside(path, other) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// isInside returns whether path is strictly inside parent.
+func isInside(path, parent string) bool {
+	rel, err := filepath.Rel(parent, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sizeOf walks the given path and adds up the sizes of everything under it.
+// Entries that cannot be read are recorded as unknown instead of failing the
+// walk.  It returns false if the path does not exist.
+func sizeOf(path string) (PathSize, bool) {
+	result := PathSize{Path: path}
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return result, false
+	}
+	_ = filepath.WalkDir(path, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Entries removed during the walk have nothing to reclaim.
+			if !errors.Is(err, fs.ErrNotExist) {
+				result.Unknown = append(result.Unknown, entryPath)
+			}
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				result.Unknown = append(result.Unknown, entryPath)
+			}
+			return nil
+		}
+		result.Size += allocatedSize(info)
+		return nil
+	})
+	return result, true
+}
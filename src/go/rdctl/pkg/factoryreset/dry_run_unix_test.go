@@ -0,0 +1,74 @@
+//go:build unix
+
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package factoryreset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeOf(t *testing.T) {
+	t.Run("adds up nested files", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a", "one"), make([]byte, 10000), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a", "b", "two"), make([]byte, 20000), 0o644))
+
+		pathSize, ok := sizeOf(dir)
+		require.True(t, ok)
+		assert.Equal(t, dir, pathSize.Path)
+		// Sizes are in allocated blocks, so they include the directories.
+		assert.GreaterOrEqual(t, pathSize.Size, int64(30000))
+		assert.Empty(t, pathSize.Unknown)
+	})
+	t.Run("skips missing paths", func(t *testing.T) {
+		_, ok := sizeOf(filepath.Join(t.TempDir(), "missing"))
+		assert.False(t, ok)
+	})
+	t.Run("reports unreadable directories as unknown", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can read any directory")
+		}
+		dir := t.TempDir()
+		unreadable := filepath.Join(dir, "unreadable")
+		require.NoError(t, os.Mkdir(unreadable, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(unreadable, "file"), make([]byte, 1<<20), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "readable"), make([]byte, 10000), 0o644))
+		require.NoError(t, os.Chmod(unreadable, 0))
+		t.Cleanup(func() { _ = os.Chmod(unreadable, 0o755) })
+
+		pathSize, ok := sizeOf(dir)
+		require.True(t, ok)
+		assert.GreaterOrEqual(t, pathSize.Size, int64(10000))
+		assert.Less(t, pathSize.Size, int64(1<<20))
+		assert.Equal(t, []string{unreadable}, pathSize.Unknown)
+	})
+}
+
+func TestWithoutNestedPaths(t *testing.T) {
+	assert.Equal(t,
+		[]string{"/a/b", "/c", "/d/e/f"},
+		withoutNestedPaths([]string{"/a/b", "", "/a/b/c", "/c", "/a/b", "/d/e/f", "/c/d"}))
+	assert.Equal(t,
+		[]string{"/ab", "/a"},
+		withoutNestedPaths([]string{"/ab", "/a", "/a/b"}))
+}
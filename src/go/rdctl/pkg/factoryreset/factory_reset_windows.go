@@ -78,18 +78,12 @@ func KillRancherDesktop(ctx context.Context) error {
 	return nil
 }
 
-func deleteWindowsData(keepSystemImages bool, appName string) error {
+func deleteWindowsData(ctx context.Context, keepSystemImages bool, appName string, progress ProgressFunc) error {
 	dirs, err := getDirectoriesToDelete(keepSystemImages, appName)
 	if err != nil {
 		return err
 	}
-	for _, dir := range dirs {
-		logrus.WithField("path", dir).Trace("Removing directory")
-		if err := os.RemoveAll(dir); err != nil {
-			logrus.Errorf("Problem trying to delete %s: %s\n", dir, err)
-		}
-	}
-	return nil
+	return removePaths(ctx, dirs, progress)
 }
 
 func getDirectoriesToDelete(keepSystemImages bool, appName string) ([]string, error) {
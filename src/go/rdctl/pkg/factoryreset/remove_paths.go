@@ -0,0 +1,84 @@
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package factoryreset
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
+)
+
+// Progress describes a path that a factory reset has removed, or failed to
+// remove.
+type Progress struct {
+	Path string `json:"path"`
+	// Size is the space that removing the path reclaimed, in bytes, as
+	// measured before removing it; it is a lower bound if some of the path
+	// could not be read.
+	Size int64 `json:"size"`
+	// Index is the number of the path, starting from 1, out of Total.
+	Index int `json:"index"`
+	Total int `json:"total"`
+	// Err is why removing the path failed, if it did.  A factory reset
+	// carries on with the other paths regardless.
+	Err error `json:"-"`
+}
+
+// ProgressFunc is called by DeleteDataWithProgress after each path it
+// removes.
+type ProgressFunc func(Progress)
+
+// DeleteData removes all the Rancher Desktop data, except for snapshots and
+// containerd shims, and the Kubernetes cache unless removeKubernetesCache is
+// set.
+func DeleteData(ctx context.Context, appPaths *paths.Paths, removeKubernetesCache bool) error {
+	return DeleteDataWithProgress(ctx, appPaths, removeKubernetesCache, nil)
+}
+
+// removePaths removes the given paths, carrying on if any of them can't be
+// removed; paths that don't exist are skipped.  If progress is not nil, it is
+// called after each path, which is measured first so that the space reclaimed
+// can be reported.  Cancelling the context stops the removal before the next
+// path, and returns an error.
+func removePaths(ctx context.Context, pathList []string, progress ProgressFunc) error {
+	for i, path := range pathList {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("factory reset cancelled with %d of %d paths removed: %w", i, len(pathList), err)
+		}
+		var size int64
+		if progress != nil {
+			pathSize, ok := sizeOf(path)
+			if !ok {
+				continue
+			}
+			size = pathSize.Size
+		}
+		logrus.WithField("path", path).Trace("Removing directory")
+		err := os.RemoveAll(path)
+		if err != nil {
+			logrus.Errorf("Error trying to remove %s: %s", path, err)
+		}
+		if progress != nil {
+			progress(Progress{Path: path, Size: size, Index: i + 1, Total: len(pathList), Err: err})
+		}
+	}
+	return nil
+}
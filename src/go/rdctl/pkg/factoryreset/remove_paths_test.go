@@ -0,0 +1,89 @@
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package factoryreset
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemovePaths(t *testing.T) {
+	// populate creates directories holding a file each, and returns them
+	// along with a path that does not exist.
+	populate := func(t *testing.T) []string {
+		dir := t.TempDir()
+		var pathList []string
+		for _, name := range []string{"config", "logs", "missing", "cache"} {
+			path := filepath.Join(dir, name)
+			pathList = append(pathList, path)
+			if name == "missing" {
+				continue
+			}
+			require.NoError(t, os.MkdirAll(path, 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(path, "file"), []byte("contents"), 0o644))
+		}
+		return pathList
+	}
+
+	t.Run("reports each path removed", func(t *testing.T) {
+		pathList := populate(t)
+		var reported []Progress
+		require.NoError(t, removePaths(context.Background(), pathList, func(progress Progress) {
+			reported = append(reported, progress)
+		}))
+		require.Len(t, reported, 3)
+		for i, index := range []int{1, 2, 4} {
+			assert.Equal(t, pathList[index-1], reported[i].Path)
+			assert.Equal(t, index, reported[i].Index)
+			assert.Equal(t, len(pathList), reported[i].Total)
+			assert.Positive(t, reported[i].Size)
+			assert.NoError(t, reported[i].Err)
+		}
+		for _, path := range pathList {
+			assert.NoDirExists(t, path)
+		}
+	})
+
+	t.Run("works without a progress callback", func(t *testing.T) {
+		pathList := populate(t)
+		require.NoError(t, removePaths(context.Background(), pathList, nil))
+		for _, path := range pathList {
+			assert.NoDirExists(t, path)
+		}
+	})
+
+	t.Run("stops between paths when cancelled", func(t *testing.T) {
+		pathList := populate(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var reported []string
+		err := removePaths(ctx, pathList, func(progress Progress) {
+			reported = append(reported, progress.Path)
+			cancel()
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, pathList[:1], reported)
+		assert.NoDirExists(t, pathList[0])
+		assert.DirExists(t, pathList[1])
+		assert.DirExists(t, pathList[3])
+	})
+}
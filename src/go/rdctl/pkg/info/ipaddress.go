@@ -33,6 +33,7 @@ type interfaceInfo struct {
 	Flags         []string
 	State         string `json:"operstate"`
 	MACAddress    string `json:"address"`
+	MTU           int    `json:"mtu"`
 	Addresses     []struct {
 		Family       string
 		Local        string
@@ -42,21 +43,23 @@ type interfaceInfo struct {
 	} `json:"addr_info"`
 }
 
-func getIPAddress(ctx context.Context, result *Info, _ client.RDClient) error {
+// vmInterface returns the network interface used to contact the VM, and its
+// global IPv4 address.
+func vmInterface(ctx context.Context) (interfaceInfo, string, error) {
 	cmd, err := shell.SpawnCommand(ctx, "ip", "-json", "address", "show")
 	if err != nil {
-		return err
+		return interfaceInfo{}, "", err
 	}
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		return err
+		return interfaceInfo{}, "", err
 	}
 
 	var interfaces []interfaceInfo
 	if err := json.Unmarshal(buf.Bytes(), &interfaces); err != nil {
-		return err
+		return interfaceInfo{}, "", err
 	}
 
 	// The list of interface names to try, varying by OS.
@@ -73,16 +76,37 @@ func getIPAddress(ctx context.Context, result *Info, _ client.RDClient) error {
 			}
 			for _, addr := range iface.Addresses {
 				if addr.Family == "inet" && addr.Scope == "global" {
-					result.IPAddress = addr.Local
-					return nil
+					return iface, addr.Local, nil
 				}
 			}
 		}
 	}
 
-	return fmt.Errorf("failed to find IP address")
+	return interfaceInfo{}, "", fmt.Errorf("failed to find IP address")
+}
+
+func getIPAddress(ctx context.Context, result *Info, _ client.RDClient) error {
+	_, address, err := vmInterface(ctx)
+	if err != nil {
+		return err
+	}
+	result.IPAddress = address
+	return nil
+}
+
+// getNetworkMTU reports the MTU of the interface used to contact the VM; on
+// Windows, that is the interface on the virtual network, so it shows whether
+// the WSL.mtu setting took effect.
+func getNetworkMTU(ctx context.Context, result *Info, _ client.RDClient) error {
+	iface, _, err := vmInterface(ctx)
+	if err != nil {
+		return err
+	}
+	result.NetworkMTU = iface.MTU
+	return nil
 }
 
 func init() {
 	register("ip-address", getIPAddress)
+	register("network-mtu", getNetworkMTU)
 }
@@ -0,0 +1,81 @@
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package info
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/client"
+)
+
+// PortForwardingProblem is a published port that the host failed to listen
+// on, typically because another process already uses it.
+type PortForwardingProblem struct {
+	Port     string    `json:"port"`
+	HostIP   string    `json:"hostIP"`
+	HostPort string    `json:"hostPort"`
+	Error    string    `json:"error"`
+	Since    time.Time `json:"since"`
+}
+
+// PortForwardingProblems is the list of current port forwarding problems.
+type PortForwardingProblems []PortForwardingProblem
+
+func (problems PortForwardingProblems) String() string {
+	if len(problems) == 0 {
+		return "none"
+	}
+	descriptions := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		descriptions = append(descriptions, fmt.Sprintf("%s on %s: %s", problem.Port,
+			net.JoinHostPort(problem.HostIP, problem.HostPort), problem.Error))
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// getPortForwardingProblems asks the application for the problems the guest
+// agent reported; only the WSL backend forwards ports through the host proxy
+// that reports failures, so the list is always empty elsewhere.
+func getPortForwardingProblems(ctx context.Context, result *Info, rdClient client.RDClient) error {
+	result.PortForwardingProblems = PortForwardingProblems{}
+	if rdClient == nil {
+		return fmt.Errorf("failed to get connection info for the application")
+	}
+	command := client.VersionCommand("", "port_forwarding/problems")
+	body, err := client.ProcessRequestForUtility(rdClient.DoRequest(ctx, http.MethodGet, command))
+	if err != nil {
+		return fmt.Errorf("failed to get port forwarding problems: %w", err)
+	}
+	var problems PortForwardingProblems
+	if err := json.Unmarshal(body, &problems); err != nil {
+		return fmt.Errorf("failed to parse port forwarding problems: %w", err)
+	}
+	if problems != nil {
+		result.PortForwardingProblems = problems
+	}
+	return nil
+}
+
+func init() {
+	register("port-forwarding-problems", getPortForwardingProblems)
+}
@@ -0,0 +1,54 @@
+package info
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/client"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/config"
+)
+
+func TestGetPortForwardingProblems(t *testing.T) {
+	var response atomic.Value
+	response.Store(`[{"port":"80/tcp","hostIP":"0.0.0.0","hostPort":"8080","error":"address already in use","since":"2026-01-02T03:04:05Z"}]`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/port_forwarding/problems" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response.Load().(string)))
+	}))
+	t.Cleanup(server.Close)
+
+	host, portText, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portText)
+	require.NoError(t, err)
+	rdClient := client.NewRDClient(&config.ConnectionInfo{Host: host, Port: port})
+
+	var result Info
+	require.NoError(t, getPortForwardingProblems(t.Context(), &result, rdClient))
+	require.Len(t, result.PortForwardingProblems, 1)
+	problem := result.PortForwardingProblems[0]
+	assert.Equal(t, "80/tcp", problem.Port)
+	assert.Equal(t, "0.0.0.0", problem.HostIP)
+	assert.Equal(t, "8080", problem.HostPort)
+	assert.Equal(t, "address already in use", problem.Error)
+	assert.Equal(t, "80/tcp on 0.0.0.0:8080: address already in use", result.PortForwardingProblems.String())
+
+	// Once the port is forwarded, the application reports no problems.
+	response.Store(`[]`)
+	require.NoError(t, getPortForwardingProblems(t.Context(), &result, rdClient))
+	assert.Empty(t, result.PortForwardingProblems)
+	assert.Equal(t, "none", result.PortForwardingProblems.String())
+
+	assert.Error(t, getPortForwardingProblems(t.Context(), &result, nil))
+}
@@ -25,8 +25,11 @@ import (
 // Info describes the output `rdctl info` will generate when run with no
 // special options.
 type Info struct {
-	Version   string `json:"version" help:"Rancher Desktop application version"`
-	IPAddress string `json:"ip-address" help:"IP address to use to contact the VM"`
+	Version                string                 `json:"version" help:"Rancher Desktop application version"`
+	IPAddress              string                 `json:"ip-address" help:"IP address to use to contact the VM"`
+	PortForwardingProblems PortForwardingProblems `json:"port-forwarding-problems" help:"Published ports that could not be forwarded to the host"`
+	VirtualNetworkAddress  string                 `json:"virtual-network-address" help:"IP address of the VM on the virtual network (Windows only)"`
+	NetworkMTU             int                    `json:"network-mtu" help:"MTU of the network interface used to contact the VM"`
 }
 
 // HandlerFunc is the generic interface to populate the [Info] result structure.
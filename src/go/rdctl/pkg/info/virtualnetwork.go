@@ -0,0 +1,76 @@
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package info
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/client"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
+)
+
+// On Windows, the host switch keeps the DHCP leases of the virtual network in
+// this file in the application directory; the VM is the client with the MAC
+// address of its tap device.
+const (
+	leaseFileName       = "dhcp-leases.json"
+	tapDeviceMACAddress = "5a:94:ef:e4:0c:ee"
+)
+
+func getVirtualNetworkAddress(_ context.Context, result *Info, _ client.RDClient) error {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	appPaths, err := paths.GetPaths()
+	if err != nil {
+		return err
+	}
+	contents, err := os.ReadFile(filepath.Join(appPaths.AppHome, leaseFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		// The host switch has not run yet.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var leases struct {
+		Leases []struct {
+			IP  string `json:"ip"`
+			MAC string `json:"mac"`
+		} `json:"leases"`
+	}
+	if err := json.Unmarshal(contents, &leases); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", leaseFileName, err)
+	}
+	for _, lease := range leases.Leases {
+		if strings.EqualFold(lease.MAC, tapDeviceMACAddress) {
+			result.VirtualNetworkAddress = lease.IP
+			break
+		}
+	}
+	return nil
+}
+
+func init() {
+	register("virtual-network-address", getVirtualNetworkAddress)
+}
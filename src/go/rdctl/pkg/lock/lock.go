@@ -75,6 +75,18 @@ func (lock *BackendLock) Unlock(ctx context.Context, appPaths *paths.Paths, rest
 	return err
 }
 
+// IsLocked reports whether the backend lock is held, meaning that a snapshot
+// operation is in progress, or was interrupted without releasing it.
+func IsLocked(appPaths *paths.Paths) (bool, error) {
+	_, err := os.Stat(filepath.Join(appPaths.AppHome, backendLockName))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check backend lock: %w", err)
+	}
+	return true, nil
+}
+
 func ensureBackendStarted(ctx context.Context) error {
 	connectionInfo, err := config.GetConnectionInfo(true)
 	if err != nil || connectionInfo == nil {
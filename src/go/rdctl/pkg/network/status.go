@@ -0,0 +1,113 @@
+/*
+Copyright © 2026 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package network gets the status of the virtual network from the control
+// endpoint of the host switch, which only runs on Windows.  The types mirror
+// those of the control package of the networking module.
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// statusPath is the path of the status on the control endpoint.
+const statusPath = "/status"
+
+// ErrUnsupported is returned on platforms without a host switch.
+var ErrUnsupported = errors.New("the virtual network status is only available on Windows")
+
+// Status is the status of the host switch.
+type Status struct {
+	Forwards    []Forward   `json:"forwards"`
+	Connections Connections `json:"connections"`
+	Leases      []Lease     `json:"leases"`
+	Interfaces  []Interface `json:"interfaces"`
+}
+
+// Forward is the status of a forwarded port.
+type Forward struct {
+	Protocol string `json:"protocol"`
+	Local    string `json:"local"`
+	Remote   string `json:"remote"`
+	// Whether the connections and bytes of the port are counted.
+	Counted           bool   `json:"counted"`
+	Connections       uint64 `json:"connections"`
+	ActiveConnections int64  `json:"active-connections"`
+	FailedConnections uint64 `json:"failed-connections"`
+	BytesToVM         uint64 `json:"bytes-to-vm"`
+	BytesFromVM       uint64 `json:"bytes-from-vm"`
+}
+
+// Connections is the size of the connection table of the virtual network.
+type Connections struct {
+	TCPEstablished uint64 `json:"tcp-established"`
+	TCPConnected   uint64 `json:"tcp-connected"`
+	SwitchClients  int    `json:"switch-clients"`
+}
+
+// Lease is an address the DHCP server of the virtual network handed out.
+type Lease struct {
+	IP       string `json:"ip"`
+	MAC      string `json:"mac"`
+	Reserved bool   `json:"reserved,omitempty"`
+}
+
+// Interface holds the counters of an interface of the virtual network.
+type Interface struct {
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	BytesSent       uint64 `json:"bytes-sent"`
+	BytesReceived   uint64 `json:"bytes-received"`
+	PacketsSent     uint64 `json:"packets-sent"`
+	PacketsReceived uint64 `json:"packets-received"`
+}
+
+// GetStatus gets the status of the host switch from the control endpoint at
+// endpoint, which is DefaultEndpoint unless the switch was told otherwise.
+func GetStatus(ctx context.Context, endpoint string) (Status, error) {
+	if endpoint == "" {
+		return Status{}, ErrUnsupported
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx, endpoint)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://host-switch"+statusPath, http.NoBody)
+	if err != nil {
+		return Status{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to connect to the host switch (is Rancher Desktop running?): %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return Status{}, fmt.Errorf("failed to get the status of the host switch: %s: %s", resp.Status, message)
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return Status{}, fmt.Errorf("failed to parse the status of the host switch: %w", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,89 @@
+package snapshot
+
+import "time"
+
+// CapabilitiesVersion is the version of Capabilities. Fields may be added to
+// Capabilities without changing it; it changes only when a field is removed
+// or changes meaning.
+const CapabilitiesVersion = 1
+
+// MetadataVersion is the version of the schema of the metadata file of
+// snapshots. Fields are added to the schema without changing it, as earlier
+// versions ignore them; it changes only if earlier versions can no longer
+// read the metadata.
+const MetadataVersion = 1
+
+// The codec the files of snapshots are stored with: snapshots hold the files
+// as they are.
+const codecNone = "none"
+
+// Capabilities describes what snapshots can be on this build and platform,
+// so that tools, such as the GUI, only offer the options that work.
+type Capabilities struct {
+	// The version of this description; see CapabilitiesVersion.
+	Version int `json:"version"`
+	// The codecs the files of snapshots can be stored with; "none" keeps
+	// them as they are.
+	Codecs []string `json:"codecs"`
+	// Whether snapshots can be encrypted.
+	Encryption bool `json:"encryption"`
+	// Whether snapshots can be stored as changes to earlier snapshots.
+	Incremental bool `json:"incremental"`
+	// Whether snapshots can share the files they have in common; see
+	// CreateOptions.Deduplicate.
+	Deduplication bool `json:"deduplication"`
+	// Whether large files are cloned, rather than copied, on filesystems
+	// that support it, such as APFS, Btrfs and XFS.
+	Reflink bool `json:"reflink"`
+	// Whether an interrupted restore can be resumed, and restores rate
+	// limited; see RestoreOptions.Resume.
+	ResumableRestore bool `json:"resumableRestore"`
+	// Whether a restore can leave the files that match the snapshot alone;
+	// see RestoreOptions.MinimalChanges.
+	MinimalRestore bool `json:"minimalRestore"`
+	// The version of the metadata schema written; see MetadataVersion.
+	MetadataVersion int `json:"metadataVersion"`
+	// The most characters the default name validator accepts in a name.
+	MaxNameLength int `json:"maxNameLength"`
+	// The components of snapshots that can be restored, as named in
+	// RestoreOptions.Components.
+	Components []string `json:"components"`
+	// Whether the snapshots are frozen, and since when; see Manager.Freeze.
+	// Only reported by Manager.Capabilities, as it depends on the snapshots
+	// directory.
+	Frozen      bool      `json:"frozen"`
+	FrozenSince time.Time `json:"frozenSince,omitzero"`
+}
+
+// SupportedCapabilities returns the capabilities of snapshots on this build
+// and platform. They don't depend on the snapshots directory, or on any
+// snapshot.
+func SupportedCapabilities() Capabilities {
+	return Capabilities{
+		Version:          CapabilitiesVersion,
+		Codecs:           []string{codecNone},
+		Encryption:       false,
+		Incremental:      false,
+		Deduplication:    deduplicatedSnapshots,
+		Reflink:          copyOnWriteCopies,
+		ResumableRestore: resumableRestore,
+		MinimalRestore:   minimalRestore,
+		MetadataVersion:  MetadataVersion,
+		MaxNameLength:    maxNameLength,
+		Components:       RestorableComponents(),
+	}
+}
+
+// Capabilities returns the capabilities of snapshots like
+// SupportedCapabilities, along with whether the snapshots of the manager are
+// frozen.
+func (manager *Manager) Capabilities() (Capabilities, error) {
+	capabilities := SupportedCapabilities()
+	since, err := manager.FrozenSince()
+	if err != nil {
+		return capabilities, err
+	}
+	capabilities.Frozen = !since.IsZero()
+	capabilities.FrozenSince = since
+	return capabilities, nil
+}
@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSupportedCapabilities(t *testing.T) {
+	capabilities := SupportedCapabilities()
+	if capabilities.Version != CapabilitiesVersion || capabilities.MetadataVersion != MetadataVersion {
+		t.Errorf("unexpected versions in %+v", capabilities)
+	}
+	if !slices.Contains(capabilities.Codecs, codecNone) {
+		t.Errorf("expected the codecs to include %q, got %v", codecNone, capabilities.Codecs)
+	}
+
+	t.Run("names up to the maximum length are accepted", func(t *testing.T) {
+		if err := DefaultNameValidator(strings.Repeat("a", capabilities.MaxNameLength)); err != nil {
+			t.Errorf("unexpected error for a name of the maximum length: %s", err)
+		}
+		if err := DefaultNameValidator(strings.Repeat("a", capabilities.MaxNameLength+1)); err == nil {
+			t.Error("expected an error for a name longer than the maximum")
+		}
+	})
+
+	t.Run("the components can be restored", func(t *testing.T) {
+		selected, err := selectRestoreComponents(capabilities.Components)
+		if err != nil {
+			t.Fatalf("failed to select components: %s", err)
+		}
+		if !slices.Equal(selected, capabilities.Components) {
+			t.Errorf("selected %v, expected %v", selected, capabilities.Components)
+		}
+	})
+
+	t.Run("deduplication works only where it is supported", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		_, err := manager.CreateWithOptions(context.Background(), "deduplicated", CreateOptions{Deduplicate: true})
+		if capabilities.Deduplication && err != nil {
+			t.Errorf("failed to create a deduplicated snapshot: %s", err)
+		} else if !capabilities.Deduplication && err == nil {
+			t.Error("expected an error creating a deduplicated snapshot")
+		}
+	})
+
+	t.Run("minimal restores work only where they are supported", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "minimal", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		_, err = manager.RestoreWithResult(context.Background(), snapshot.Name, RestoreOptions{MinimalChanges: true})
+		if capabilities.MinimalRestore && err != nil {
+			t.Errorf("failed to restore only the files that changed: %s", err)
+		} else if !capabilities.MinimalRestore && err == nil {
+			t.Error("expected an error restoring only the files that changed")
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The component of the logs captured with CreateOptions.CaptureLogs. It is
+// only there to be looked at: restoring a snapshot never replaces the logs in
+// use, which describe what happened since.
+const componentLogs = "logs"
+
+// The name of the archive, in a snapshot directory, holding the captured
+// logs. The snapshot directory only holds regular files.
+const capturedLogsFileName = "logs.tar.gz"
+
+const (
+	// The most of a single log that is captured; longer logs are captured
+	// from the end.
+	maxCapturedLogSize = 4 << 20
+	// The most that is captured of all the logs together; the logs written
+	// to last are captured first.
+	maxCapturedLogsSize = 16 << 20
+)
+
+// CapturedLogs describes the logs captured in a snapshot; see
+// CreateOptions.CaptureLogs.
+type CapturedLogs struct {
+	// The names of the logs captured, in the logs directory.
+	Files []string `json:"files"`
+	// The size of the logs captured, before compression.
+	Size int64 `json:"size"`
+	// Whether logs were left out, or captured from the end only, to stay
+	// within the limits.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// capturedLogsPath returns the path of the captured logs of the snapshot in
+// snapshotDir.
+func capturedLogsPath(snapshotDir string) string {
+	return filepath.Join(snapshotDir, capturedLogsFileName)
+}
+
+// captureLogs captures the end of the logs in logsDir into the snapshot in
+// snapshotDir, within maxCapturedLogSize and maxCapturedLogsSize. It returns
+// nil if there are no logs to capture.
+func captureLogs(logsDir, snapshotDir string) (*CapturedLogs, error) {
+	entries, err := os.ReadDir(logsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read logs directory: %w", err)
+	}
+	type logFile struct {
+		name     string
+		modified time.Time
+	}
+	var logs []logFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, logFile{name: entry.Name(), modified: info.ModTime()})
+	}
+	if len(logs) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(logs, func(a, b logFile) int {
+		return b.modified.Compare(a.modified)
+	})
+
+	if err := os.MkdirAll(snapshotDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	archivePath := capturedLogsPath(snapshotDir)
+	archive, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create captured logs: %w", err)
+	}
+	defer archive.Close()
+	compressed := gzip.NewWriter(archive)
+	tarWriter := tar.NewWriter(compressed)
+	captured := &CapturedLogs{Files: []string{}}
+	for _, log := range logs {
+		remaining := maxCapturedLogsSize - captured.Size
+		if remaining <= 0 {
+			captured.Truncated = true
+			break
+		}
+		contents, truncated, err := readLogTail(filepath.Join(logsDir, log.name), min(remaining, maxCapturedLogSize))
+		if errors.Is(err, os.ErrNotExist) {
+			// Logs rotated away since the directory was read are gone.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		header := &tar.Header{
+			Name:    log.name,
+			Mode:    0o644,
+			Size:    int64(len(contents)),
+			ModTime: log.modified,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to capture %s: %w", log.name, err)
+		}
+		if _, err := tarWriter.Write(contents); err != nil {
+			return nil, fmt.Errorf("failed to capture %s: %w", log.name, err)
+		}
+		captured.Files = append(captured.Files, log.name)
+		captured.Size += int64(len(contents))
+		captured.Truncated = captured.Truncated || truncated
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write captured logs: %w", err)
+	}
+	if err := compressed.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write captured logs: %w", err)
+	}
+	size, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write captured logs: %w", err)
+	}
+	if err := finishFile(archive, size); err != nil {
+		return nil, err
+	}
+	return captured, nil
+}
+
+// readLogTail returns the end of the log at path, at most limit bytes of it,
+// and whether that is not all of it. A log read from the middle starts at the
+// first line that is read whole.
+func readLogTail(path string, limit int64) ([]byte, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	offset := max(info.Size()-limit, 0)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, false, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	// The log may still be growing; only what it had when it was opened is
+	// captured.
+	contents, err := io.ReadAll(io.LimitReader(file, info.Size()-offset))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	if offset == 0 {
+		return contents, false, nil
+	}
+	if index := bytes.IndexByte(contents, '\n'); index >= 0 {
+		contents = contents[index+1:]
+	}
+	return contents, true, nil
+}
+
+// ReadCapturedLogs calls fn with the name and contents of each of the logs
+// captured in the snapshot, in the order they were captured. It returns an
+// error wrapping os.ErrNotExist if the snapshot has no captured logs.
+func (manager *Manager) ReadCapturedLogs(snapshot Snapshot, fn func(name string, contents io.Reader) error) error {
+	archive, err := os.Open(capturedLogsPath(manager.SnapshotDirectory(snapshot)))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("snapshot %q has no captured logs: %w", snapshot.Name, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to open captured logs: %w", err)
+	}
+	defer archive.Close()
+	compressed, err := gzip.NewReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read captured logs: %w", err)
+	}
+	tarReader := tar.NewReader(compressed)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read captured logs: %w", err)
+		}
+		if err := fn(header.Name, tarReader); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// cleanupStack holds the actions undoing the steps of an operation, so that
+// an operation that fails or is cancelled part way leaves nothing behind.
+// Each step pushes the action undoing it before it starts changing anything,
+// so that a step that fails half way is undone too; the actions are run in
+// the reverse order, so that each one sees what the steps before it left.
+type cleanupStack struct {
+	actions []cleanupAction
+}
+
+// cleanupAction is an action undoing a step of an operation.
+type cleanupAction struct {
+	// What the action does, for the operation log.
+	description string
+	undo        func() error
+}
+
+// push adds the action undoing the next step.
+func (stack *cleanupStack) push(description string, undo func() error) {
+	stack.actions = append(stack.actions, cleanupAction{description: description, undo: undo})
+}
+
+// run runs the actions, the last one pushed first, and empties the stack.
+// An action failing doesn't stop the others from running, as they don't
+// depend on each other succeeding; the errors are logged, and all returned.
+func (stack *cleanupStack) run(oplog *operationLog) error {
+	var errs []error
+	for i := len(stack.actions) - 1; i >= 0; i-- {
+		action := stack.actions[i]
+		oplog.Warn(action.description)
+		if err := action.undo(); err != nil {
+			oplog.Errorf("failed %s: %s", action.description, err)
+			errs = append(errs, fmt.Errorf("failed %s: %w", action.description, err))
+		}
+	}
+	stack.actions = nil
+	return errors.Join(errs...)
+}
+
+// listObjects returns the names of the objects in the objects directory of
+// the snapshots in the same directory as snapshotDir, and whether the
+// directory exists.
+func listObjects(snapshotDir string) (map[string]bool, bool, error) {
+	entries, err := os.ReadDir(objectsDirPath(snapshotDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read objects directory: %w", err)
+	}
+	objects := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		objects[entry.Name()] = true
+	}
+	return objects, true, nil
+}
+
+// removeNewObjects undoes storing the objects of the deduplicated snapshot in
+// snapshotDir: it removes the objects that were not in the objects directory
+// before, as given by listObjects, unless another snapshot refers to them,
+// and then the objects directory if it did not exist before and is empty.
+// Unlike collectObjects, it doesn't wait for the objects to be old, as they
+// are known to belong to the snapshot.
+func (manager *Manager) removeNewObjects(snapshotDir string, existing map[string]bool, existed bool) error {
+	current, _, err := listObjects(snapshotDir)
+	if err != nil {
+		return err
+	}
+	referenced, err := manager.referencedObjects()
+	if err != nil {
+		return err
+	}
+	// The manifest of the snapshot being undone doesn't count; it is
+	// removed with the snapshot directory.
+	manifest, err := readObjectManifest(snapshotDir)
+	if err != nil {
+		return err
+	}
+	for _, checksum := range manifest {
+		referenced[checksum]--
+	}
+	objectsDir := objectsDirPath(snapshotDir)
+	var errs []error
+	for name := range current {
+		if existing[name] || referenced[name] > 0 {
+			continue
+		}
+		if err := os.Remove(objectPath(objectsDir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to remove object %q: %w", name, err))
+		}
+	}
+	if !existed {
+		// Objects that are kept keep the directory too.
+		if entries, err := os.ReadDir(objectsDir); err == nil && len(entries) == 0 {
+			if err := os.Remove(objectsDir); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove objects directory: %w", err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
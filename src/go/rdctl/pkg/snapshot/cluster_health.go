@@ -0,0 +1,209 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The kubeconfig context that Rancher Desktop creates for its cluster.
+const kubeContext = "rancher-desktop"
+
+// How long checking the health of the cluster may take. The check is meant
+// to be quick; a cluster that doesn't answer in this time is not healthy.
+const clusterHealthTimeout = 10 * time.Second
+
+// Reasons for which a container waits that mean it is failing repeatedly,
+// rather than just starting.
+var crashLoopReasons = []string{
+	"CrashLoopBackOff",
+	"CreateContainerConfigError",
+	"ErrImagePull",
+	"ImagePullBackOff",
+	"RunContainerError",
+}
+
+// ClusterHealth records the state of the Kubernetes cluster just before a
+// snapshot was created, so that whoever restores the snapshot knows whether
+// it captured a cluster in a steady state.
+type ClusterHealth struct {
+	// When the cluster was checked, in local time.
+	Checked time.Time `json:"checked"`
+	// Whether no problems were found.
+	Healthy bool `json:"healthy"`
+	// The problems found, such as nodes that are not ready or pods that are
+	// crash-looping.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// CheckClusterHealth checks whether the Rancher Desktop Kubernetes cluster is
+// in a steady state: all nodes are ready, and no pods are pending,
+// terminating, not ready or crash-looping. Pods that have completed or
+// failed are not considered, as they no longer change. An error is returned
+// if the cluster can't be checked, for example because Kubernetes is not
+// running.
+func CheckClusterHealth(ctx context.Context) (*ClusterHealth, error) {
+	kubectl, err := kubectlPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find kubectl: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, clusterHealthTimeout)
+	defer cancel()
+	//nolint:gosec // kubectl is next to rdctl
+	cmd := exec.CommandContext(ctx, kubectl, "--context", kubeContext,
+		"--request-timeout", clusterHealthTimeout.String(),
+		"get", "nodes,pods", "--all-namespaces", "--output", "json")
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("failed to get cluster state: %w", err)
+	}
+	return parseClusterHealth(output, time.Now())
+}
+
+// checkClusterHealthFunc checks the health of the cluster; tests replace it.
+var checkClusterHealthFunc = CheckClusterHealth
+
+// checkClusterHealth checks the health of the cluster before creating a
+// snapshot, and returns it to be recorded. Problems are warned about, unless
+// required is set, in which case they are errors. It returns nil if the
+// cluster could not be checked and that isn't required.
+func checkClusterHealth(ctx context.Context, required bool, oplog *operationLog) (*ClusterHealth, error) {
+	health, err := checkClusterHealthFunc(ctx)
+	if err != nil {
+		if required {
+			return nil, fmt.Errorf("failed to check the health of the cluster: %w", err)
+		}
+		logrus.Warnf("failed to check the health of the cluster: %s", err)
+		oplog.Warnf("failed to check the health of the cluster: %s", err)
+		return nil, nil
+	}
+	if health.Healthy {
+		return health, nil
+	}
+	problems := strings.Join(health.Problems, "; ")
+	if required {
+		return nil, fmt.Errorf("%w: %s", ErrClusterUnhealthy, problems)
+	}
+	logrus.Warnf("the cluster is not in a steady state; the snapshot will capture it as it is: %s", problems)
+	oplog.Warnf("the cluster is not in a steady state: %s", problems)
+	return health, nil
+}
+
+// kubectlPath returns the path to the kubectl shipped with Rancher Desktop,
+// which is in the same directory as rdctl.
+func kubectlPath() (string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	execPath, err = filepath.EvalSymlinks(execPath)
+	if err != nil {
+		return "", err
+	}
+	result := filepath.Join(filepath.Dir(execPath), "kubectl")
+	if runtime.GOOS == "windows" {
+		result += ".exe"
+	}
+	return result, nil
+}
+
+// kubeObjects is the part of the output of `kubectl get nodes,pods --output
+// json` needed to check the health of the cluster.
+type kubeObjects struct {
+	Items []struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name              string     `json:"name"`
+			Namespace         string     `json:"namespace"`
+			DeletionTimestamp *time.Time `json:"deletionTimestamp"`
+		} `json:"metadata"`
+		Status struct {
+			// For nodes.
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+			// For pods.
+			Phase             string `json:"phase"`
+			ContainerStatuses []struct {
+				Name  string `json:"name"`
+				Ready bool   `json:"ready"`
+				State struct {
+					Waiting *struct {
+						Reason string `json:"reason"`
+					} `json:"waiting"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// parseClusterHealth returns the health of the cluster described by the
+// output of `kubectl get nodes,pods --output json`.
+func parseClusterHealth(output []byte, checked time.Time) (*ClusterHealth, error) {
+	var objects kubeObjects
+	if err := json.Unmarshal(output, &objects); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster state: %w", err)
+	}
+	health := &ClusterHealth{Checked: checked}
+	nodes := 0
+	for _, item := range objects.Items {
+		switch item.Kind {
+		case "Node":
+			nodes++
+			ready := false
+			for _, condition := range item.Status.Conditions {
+				if condition.Type == "Ready" {
+					ready = condition.Status == "True"
+				}
+			}
+			if !ready {
+				health.Problems = append(health.Problems, fmt.Sprintf("node %s is not ready", item.Metadata.Name))
+			}
+		case "Pod":
+			name := item.Metadata.Namespace + "/" + item.Metadata.Name
+			if item.Metadata.DeletionTimestamp != nil {
+				health.Problems = append(health.Problems, fmt.Sprintf("pod %s is terminating", name))
+				continue
+			}
+			if item.Status.Phase == "Succeeded" || item.Status.Phase == "Failed" {
+				continue
+			}
+			problem := ""
+			if item.Status.Phase == "Pending" {
+				problem = fmt.Sprintf("pod %s is pending", name)
+			}
+			for _, container := range item.Status.ContainerStatuses {
+				if waiting := container.State.Waiting; waiting != nil && slices.Contains(crashLoopReasons, waiting.Reason) {
+					problem = fmt.Sprintf("pod %s is crash-looping (container %s: %s)", name, container.Name, waiting.Reason)
+					break
+				}
+				if !container.Ready && problem == "" {
+					problem = fmt.Sprintf("pod %s is not ready (container %s)", name, container.Name)
+				}
+			}
+			if problem != "" {
+				health.Problems = append(health.Problems, problem)
+			}
+		}
+	}
+	if nodes == 0 {
+		health.Problems = append(health.Problems, "the cluster has no nodes")
+	}
+	health.Healthy = len(health.Problems) == 0
+	return health, nil
+}
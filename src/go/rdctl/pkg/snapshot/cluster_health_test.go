@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseClusterHealth(t *testing.T) {
+	readyNode := `{"kind": "Node", "metadata": {"name": "lima-rancher-desktop"},
+		"status": {"conditions": [{"type": "MemoryPressure", "status": "False"}, {"type": "Ready", "status": "True"}]}}`
+	runningPod := `{"kind": "Pod", "metadata": {"name": "coredns-6799fbcd5-x2k8v", "namespace": "kube-system"},
+		"status": {"phase": "Running", "containerStatuses": [{"name": "coredns", "ready": true, "state": {"running": {}}}]}}`
+	completedPod := `{"kind": "Pod", "metadata": {"name": "helm-install-traefik-4m5xq", "namespace": "kube-system"},
+		"status": {"phase": "Succeeded", "containerStatuses": [{"name": "helm", "ready": false, "state": {"terminated": {"reason": "Completed"}}}]}}`
+
+	testCases := []struct {
+		name     string
+		items    []string
+		problems []string
+	}{
+		{
+			name:  "a cluster in a steady state is healthy",
+			items: []string{readyNode, runningPod, completedPod},
+		},
+		{
+			name: "a node that is not ready is a problem",
+			items: []string{
+				`{"kind": "Node", "metadata": {"name": "lima-rancher-desktop"},
+					"status": {"conditions": [{"type": "Ready", "status": "Unknown"}]}}`,
+				runningPod,
+			},
+			problems: []string{"node lima-rancher-desktop is not ready"},
+		},
+		{
+			name:     "a cluster without nodes is a problem",
+			items:    []string{runningPod},
+			problems: []string{"the cluster has no nodes"},
+		},
+		{
+			name: "pods that are not settled are problems",
+			items: []string{
+				readyNode,
+				runningPod,
+				`{"kind": "Pod", "metadata": {"name": "web-0", "namespace": "default"},
+					"status": {"phase": "Running", "containerStatuses": [
+						{"name": "init", "ready": true, "state": {"running": {}}},
+						{"name": "web", "ready": false, "state": {"waiting": {"reason": "CrashLoopBackOff"}}}]}}`,
+				`{"kind": "Pod", "metadata": {"name": "web-1", "namespace": "default"},
+					"status": {"phase": "Pending", "containerStatuses": [{"name": "web", "ready": false, "state": {"waiting": {"reason": "ContainerCreating"}}}]}}`,
+				`{"kind": "Pod", "metadata": {"name": "web-2", "namespace": "default"},
+					"status": {"phase": "Pending", "containerStatuses": [{"name": "web", "ready": false, "state": {"waiting": {"reason": "ImagePullBackOff"}}}]}}`,
+				`{"kind": "Pod", "metadata": {"name": "api", "namespace": "default"},
+					"status": {"phase": "Running", "containerStatuses": [{"name": "api", "ready": false, "state": {"running": {}}}]}}`,
+				`{"kind": "Pod", "metadata": {"name": "old", "namespace": "default", "deletionTimestamp": "2026-10-14T10:00:00Z"},
+					"status": {"phase": "Running", "containerStatuses": [{"name": "old", "ready": true, "state": {"running": {}}}]}}`,
+				`{"kind": "Pod", "metadata": {"name": "job-x7w2p", "namespace": "default"},
+					"status": {"phase": "Failed", "containerStatuses": [{"name": "job", "ready": false, "state": {"terminated": {"reason": "Error"}}}]}}`,
+			},
+			problems: []string{
+				"pod default/web-0 is crash-looping (container web: CrashLoopBackOff)",
+				"pod default/web-1 is pending",
+				"pod default/web-2 is crash-looping (container web: ImagePullBackOff)",
+				"pod default/api is not ready (container api)",
+				"pod default/old is terminating",
+			},
+		},
+	}
+	checked := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			output := `{"apiVersion": "v1", "kind": "List", "items": [`
+			for i, item := range testCase.items {
+				if i > 0 {
+					output += ","
+				}
+				output += item
+			}
+			output += "]}"
+			health, err := parseClusterHealth([]byte(output), checked)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !health.Checked.Equal(checked) {
+				t.Errorf("unexpected checked time %s", health.Checked)
+			}
+			if health.Healthy != (len(testCase.problems) == 0) {
+				t.Errorf("unexpected healthy %t", health.Healthy)
+			}
+			if !slices.Equal(health.Problems, testCase.problems) {
+				t.Errorf("expected problems %q, got %q", testCase.problems, health.Problems)
+			}
+		})
+	}
+
+	t.Run("output that isn't JSON is an error", func(t *testing.T) {
+		if _, err := parseClusterHealth([]byte("error: context not found"), checked); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
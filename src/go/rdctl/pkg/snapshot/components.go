@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
+)
+
+// ComponentKubernetes is the component name of the Kubernetes version (k3s)
+// Rancher Desktop is set to run.
+const ComponentKubernetes = "kubernetes"
+
+// ComponentMigration describes a component whose version recorded in a
+// snapshot differs from the version in use when the snapshot is restored.
+type ComponentMigration struct {
+	// The name of the component, such as ComponentKubernetes.
+	Component string
+	// The version of the component recorded in the snapshot.
+	SnapshotVersion string
+	// The version of the component in use before the restore.
+	CurrentVersion string
+	// The paths of the restored data.
+	Paths *paths.Paths
+}
+
+// MigrationHook upgrades or downgrades the restored data of a component, so
+// that it can be used by the version in use. Hooks are registered by
+// component name in ManagerConfig.MigrationHooks. Restore runs them once the
+// files are restored, before the backend is restarted; if a hook fails, the
+// restore fails.
+type MigrationHook func(ctx context.Context, migration ComponentMigration) error
+
+// readComponentVersions returns the versions of the components in use, as
+// recorded in the given settings.json; components without a version are left
+// out.
+func readComponentVersions(settingsPath string) map[string]string {
+	contents, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return nil
+	}
+	var settings struct {
+		Kubernetes struct {
+			Version string `json:"version"`
+		} `json:"kubernetes"`
+	}
+	if err := json.Unmarshal(contents, &settings); err != nil {
+		return nil
+	}
+	if settings.Kubernetes.Version == "" {
+		return nil
+	}
+	return map[string]string{ComponentKubernetes: settings.Kubernetes.Version}
+}
+
+// componentMigrations returns the components of the snapshot whose versions
+// differ from those in use. Components whose version is unknown on either
+// side are not compared.
+func (manager *Manager) componentMigrations(snapshot Snapshot) []ComponentMigration {
+	current := readComponentVersions(filepath.Join(manager.Config, "settings.json"))
+	var migrations []ComponentMigration
+	for _, component := range slices.Sorted(maps.Keys(snapshot.ComponentVersions)) {
+		snapshotVersion := snapshot.ComponentVersions[component]
+		currentVersion, ok := current[component]
+		if ok && snapshotVersion != "" && currentVersion != snapshotVersion {
+			migrations = append(migrations, ComponentMigration{
+				Component:       component,
+				SnapshotVersion: snapshotVersion,
+				CurrentVersion:  currentVersion,
+				Paths:           manager.Paths,
+			})
+		}
+	}
+	return migrations
+}
+
+// warnUnhandledMigrations warns about the migrations there is no hook for, as
+// the restored data may not work with the version in use.
+func (manager *Manager) warnUnhandledMigrations(snapshot Snapshot, migrations []ComponentMigration, oplog *operationLog) {
+	for _, migration := range migrations {
+		if manager.config.MigrationHooks[migration.Component] == nil {
+			msg := fmt.Sprintf("snapshot %q has %s %s, but %s is in use, and there is no migration for it; the restored data may not work",
+				snapshot.Name, migration.Component, migration.SnapshotVersion, migration.CurrentVersion)
+			logrus.Warn(msg)
+			oplog.Warn(msg)
+		}
+	}
+}
+
+// runComponentMigrations runs the hooks for the migrations, in order.
+func (manager *Manager) runComponentMigrations(ctx context.Context, migrations []ComponentMigration, oplog *operationLog) error {
+	for _, migration := range migrations {
+		hook := manager.config.MigrationHooks[migration.Component]
+		if hook == nil {
+			continue
+		}
+		oplog.Infof("migrating %s from %s to %s", migration.Component, migration.SnapshotVersion, migration.CurrentVersion)
+		if err := hook(ctx, migration); err != nil {
+			return fmt.Errorf("failed to migrate %s from %s to %s: %w",
+				migration.Component, migration.SnapshotVersion, migration.CurrentVersion, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ErrCopyDestinationExists is returned by Copy when the destination already
+// holds the snapshot, and CopyOptions.Force is not set.
+var ErrCopyDestinationExists = errors.New("the destination already holds the snapshot")
+
+// ErrCopyVerificationFailed is returned by Copy when a copied file doesn't
+// read back the same as the file it was copied from.
+var ErrCopyVerificationFailed = errors.New("copied file does not match the original")
+
+// CopyOptions modifies the behaviour of Manager.Copy.
+type CopyOptions struct {
+	// Replace the snapshot if the destination already holds it.
+	Force bool
+}
+
+// Copy copies the complete snapshot with the given ID into destDir, leaving
+// the original as it is. The destination is laid out like the snapshots
+// directory: the snapshot is copied to a directory named by its ID, and the
+// objects of deduplicated snapshots to the objects directory, so that destDir
+// can be used as a snapshots directory. Each file is read back once written,
+// and its checksum compared to the original's. The snapshot is copied to a
+// temporary directory first, so that a copy that fails or is cancelled leaves
+// nothing at the destination.
+func (manager *Manager) Copy(ctx context.Context, id, destDir string, opts CopyOptions) error {
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	index := slices.IndexFunc(snapshots, func(snapshot Snapshot) bool {
+		return snapshot.ID == id
+	})
+	if index < 0 {
+		return &NotFoundError{ID: id}
+	}
+	snapshot := snapshots[index]
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	if same, err := sameDirectory(destDir, manager.Snapshots); err != nil {
+		return err
+	} else if same {
+		return errors.New("can't copy a snapshot into the snapshots directory")
+	}
+	dest := snapshotDirPath(destDir, snapshot.ID)
+	if _, err := os.Lstat(dest); err == nil {
+		if !opts.Force {
+			return fmt.Errorf("%w: %s exists", ErrCopyDestinationExists, dest)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check destination: %w", err)
+	}
+
+	srcDir := manager.SnapshotDirectory(snapshot)
+	manifest, err := readObjectManifest(srcDir)
+	if err != nil {
+		return err
+	}
+	// The objects go first, so that the snapshot never refers to objects
+	// that aren't there.
+	if err := copyObjects(ctx, manifest, objectsDirPath(srcDir), filepath.Join(destDir, objectsDirName)); err != nil {
+		return err
+	}
+	// The temporary directory doesn't look like a snapshot, so it is ignored
+	// if it is left behind.
+	tempDir, err := os.MkdirTemp(destDir, ".copy-"+snapshot.ID+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("unexpected entry %q in snapshot directory", entry.Name())
+		}
+		if err := copyVerified(ctx, filepath.Join(tempDir, entry.Name()), filepath.Join(srcDir, entry.Name()), ""); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(tempDir); err != nil {
+		return err
+	}
+	if opts.Force {
+		if err := os.RemoveAll(dest); err != nil {
+			return fmt.Errorf("failed to remove existing copy: %w", err)
+		}
+	}
+	if err := renameFile(tempDir, dest); err != nil {
+		return fmt.Errorf("failed to move copy into place: %w", err)
+	}
+	return syncDir(destDir)
+}
+
+// copyObjects copies the objects a manifest refers to, skipping those that
+// are already at the destination and intact.
+func copyObjects(ctx context.Context, manifest objectManifest, srcDir, destDir string) error {
+	if len(manifest) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create objects directory: %w", err)
+	}
+	// Files with the same contents share an object.
+	for _, checksum := range slices.Compact(slices.Sorted(maps.Values(manifest))) {
+		dest := objectPath(destDir, checksum)
+		if existing, err := checksumFile(ctx, dest, 0); err == nil && existing == checksum {
+			continue
+		}
+		tempFile, err := os.CreateTemp(destDir, ".incoming-*")
+		if err != nil {
+			return err
+		}
+		tempPath := tempFile.Name()
+		_ = tempFile.Close()
+		if err := copyVerified(ctx, tempPath, objectPath(srcDir, checksum), checksum); err != nil {
+			_ = os.Remove(tempPath)
+			return err
+		}
+		if err := renameFile(tempPath, dest); err != nil {
+			_ = os.Remove(tempPath)
+			return fmt.Errorf("failed to store object: %w", err)
+		}
+	}
+	return syncDir(destDir)
+}
+
+// copyVerified copies a file, then reads the copy back to check that it has
+// the same checksum as the original. If expected is not empty, the original
+// must have that checksum too.
+func copyVerified(ctx context.Context, dst, src, expected string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", src, err)
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %q: %w", src, err)
+	}
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %w", dst, err)
+	}
+	hash := sha256.New()
+	if _, err := copyData(ctx, dstFile, io.TeeReader(srcFile, hash), srcFile, dstFile); err != nil {
+		_ = dstFile.Close()
+		return fmt.Errorf("failed to copy %q: %w", src, err)
+	}
+	if err := finishFile(dstFile, info.Size()); err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && checksum != expected {
+		return fmt.Errorf("%w: %q has checksum %s, not %s", ErrCopyVerificationFailed, src, checksum, expected)
+	}
+	copied, err := checksumFile(ctx, dst, 0)
+	if err != nil {
+		return err
+	}
+	if copied != checksum {
+		return fmt.Errorf("%w: %q has checksum %s, not %s", ErrCopyVerificationFailed, dst, copied, checksum)
+	}
+	return nil
+}
+
+// sameDirectory reports whether two existing directories are the same one; a
+// directory that doesn't exist is not the same as any other.
+func sameDirectory(a, b string) (bool, error) {
+	var infos []os.FileInfo
+	for _, path := range []string{a, b} {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		infos = append(infos, info)
+	}
+	return os.SameFile(infos[0], infos[1]), nil
+}
@@ -1,6 +1,7 @@
 package snapshot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,33 +15,58 @@ import (
 // use clonefile syscall to do the copy. If clonefile is not supported
 // by the underlying filesystem, or src and dst are on different
 // drives, falls back to a plain copy. If copyOnWrite is false, does a
-// plain copy.
-func copyFile(dst, src string, copyOnWrite bool, fileMode os.FileMode) error {
+// plain copy. If wrapReader is not nil, a plain copy reads the source through
+// the reader it returns. The destination is synced, and checked to have the
+// size of the source, so that failed writes to network shares are caught.
+// Reports whether the file was cloned rather than copied.
+func copyFile(ctx context.Context, dst, src string, copyOnWrite bool, fileMode os.FileMode, wrapReader func(io.Reader) io.Reader) (bool, error) {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-		return fmt.Errorf("failed to create destination parent dir: %w", err)
+		return false, fmt.Errorf("failed to create destination parent dir: %w", err)
 	}
 	if copyOnWrite {
 		if err := os.RemoveAll(dst); err != nil {
-			return fmt.Errorf("failed to remove existing destination file: %w", err)
+			return false, fmt.Errorf("failed to remove existing destination file: %w", err)
 		}
 		if err := unix.Clonefile(src, dst, 0); err == nil {
-			return nil
+			return true, verifyClone(dst, src)
 		} else if !errors.Is(err, unix.ENOTSUP) && !errors.Is(err, unix.EXDEV) {
-			return fmt.Errorf("failed to clone src to dest: %w", err)
+			return false, fmt.Errorf("failed to clone src to dest: %w", err)
 		}
 	}
 	srcFd, err := os.Open(src)
 	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err)
+		return false, fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer srcFd.Close()
+	srcInfo, err := srcFd.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat source file: %w", err)
+	}
 	dstFd, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
 	if err != nil {
-		return fmt.Errorf("failed to open destination file: %w", err)
+		return false, fmt.Errorf("failed to open destination file: %w", err)
 	}
-	defer dstFd.Close()
-	if _, err := io.Copy(dstFd, srcFd); err != nil {
-		return fmt.Errorf("failed to copy contents of src to dst: %w", err)
+	var reader io.Reader = srcFd
+	if wrapReader != nil {
+		reader = wrapReader(srcFd)
+	}
+	if _, err := copyData(ctx, dstFd, reader, srcFd, dstFd); err != nil {
+		_ = dstFd.Close()
+		return false, fmt.Errorf("failed to copy contents of src to dst: %w", err)
+	}
+	return false, finishFile(dstFd, srcInfo.Size())
+}
+
+// verifyClone syncs a file cloned from src, and checks that it has the same
+// size.
+func verifyClone(dst, src string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+	dstFd, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open destination file: %w", err)
 	}
-	return nil
+	return finishFile(dstFd, srcInfo.Size())
 }
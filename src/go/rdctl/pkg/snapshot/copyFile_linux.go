@@ -1,6 +1,7 @@
 package snapshot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,30 +15,43 @@ import (
 // use ioctl FICLONE to do the copy. If ioctl FICLONE is not supported
 // by the underlying filesystem, falls back to a plain copy. If
 // copyOnWrite is false, does a plain copy. fileMode specifies the
-// permissions that are applied to the destination file.
-func copyFile(dst, src string, copyOnWrite bool, fileMode os.FileMode) error {
+// permissions that are applied to the destination file. If wrapReader is not
+// nil, a plain copy reads the source through the reader it returns. The
+// destination is synced, and checked to have the size of the source, so that
+// failed writes to network shares are caught. Reports whether the file was
+// cloned rather than copied.
+func copyFile(ctx context.Context, dst, src string, copyOnWrite bool, fileMode os.FileMode, wrapReader func(io.Reader) io.Reader) (bool, error) {
 	srcFd, err := os.Open(src)
 	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err)
+		return false, fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer srcFd.Close()
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-		return fmt.Errorf("failed to create destination parent dir: %w", err)
+		return false, fmt.Errorf("failed to create destination parent dir: %w", err)
+	}
+	srcInfo, err := srcFd.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat source file: %w", err)
 	}
 	dstFd, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
 	if err != nil {
-		return fmt.Errorf("failed to open destination file: %w", err)
+		return false, fmt.Errorf("failed to open destination file: %w", err)
 	}
-	defer dstFd.Close()
 	if copyOnWrite {
 		if err := unix.IoctlFileClone(int(dstFd.Fd()), int(srcFd.Fd())); err == nil {
-			return nil
+			return true, finishFile(dstFd, srcInfo.Size())
 		} else if !errors.Is(err, unix.ENOTSUP) {
-			return fmt.Errorf("failed to ioctl_ficlone file: %w", err)
+			_ = dstFd.Close()
+			return false, fmt.Errorf("failed to ioctl_ficlone file: %w", err)
 		}
 	}
-	if _, err := io.Copy(dstFd, srcFd); err != nil {
-		return fmt.Errorf("failed to copy contents of src to dst: %w", err)
+	var reader io.Reader = srcFd
+	if wrapReader != nil {
+		reader = wrapReader(srcFd)
+	}
+	if _, err := copyData(ctx, dstFd, reader, srcFd, dstFd); err != nil {
+		_ = dstFd.Close()
+		return false, fmt.Errorf("failed to copy contents of src to dst: %w", err)
 	}
-	return nil
+	return false, finishFile(dstFd, srcInfo.Size())
 }
@@ -0,0 +1,235 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/runner"
+)
+
+// The snapshots directory may be on a network share, such as an SMB or NFS
+// mount. Writes there are slower, can fail part way, and are only known to
+// have reached the server once the file is synced; a failed write may not be
+// reported until the file is synced or closed. The helpers in this file sync
+// every file and directory written, check the errors from closing files, and
+// check that files have the expected size afterwards.
+
+// ErrIncompleteWrite is returned when a file written by a snapshot operation
+// does not have the expected size afterwards.
+var ErrIncompleteWrite = errors.New("file was not completely written")
+
+// ErrCopyStalled is returned when copying a file makes no progress for
+// copyStallTimeout.
+var ErrCopyStalled = errors.New("copy made no progress")
+
+// renameFile renames a file; tests replace it to mimic network shares that
+// refuse to rename over an existing file.
+var renameFile = os.Rename
+
+// How long copying a file may go without making progress before it is given
+// up on. Network shares can stall for a while, for example while a server
+// reconnects, but a share that is stuck for this long is unlikely to recover.
+// This is a variable so that tests can shorten it.
+var copyStallTimeout = 2 * time.Minute
+
+// copyData copies src to dst like io.Copy, but gives up once the context is
+// done or no data has been copied for copyStallTimeout. Progress is measured
+// by reads, which wait for the previous write.
+//
+// A read or write that is already blocked is interrupted by setting a past
+// deadline on the given files, which are those src and dst read from and
+// write to. This only works for files that support deadlines, such as pipes;
+// a read or write of a regular file, including one on a network share,
+// can't be interrupted, so the copy is only given up on once it returns.
+// How long that takes depends on the share: a soft NFS mount, or SMB, fails
+// it after its own timeout, but a hard NFS mount retries forever.
+func copyData(ctx context.Context, dst io.Writer, src io.Reader, files ...*os.File) (int64, error) {
+	// Read the timeout once, so that it can't change while the watchdog runs.
+	timeout := copyStallTimeout
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var lastProgress atomic.Int64
+	lastProgress.Store(time.Now().UnixNano())
+	copyDone := make(chan struct{})
+	watchdogDone := make(chan struct{})
+	go func() {
+		defer close(watchdogDone)
+		ticker := time.NewTicker(timeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-copyDone:
+				return
+			case <-ctx.Done():
+			case <-ticker.C:
+				if time.Since(time.Unix(0, lastProgress.Load())) <= timeout {
+					continue
+				}
+				cancel(fmt.Errorf("%w for %s", ErrCopyStalled, timeout))
+			}
+			for _, file := range files {
+				// Files that don't support deadlines return an error, and
+				// can't be interrupted.
+				_ = file.SetDeadline(time.Now())
+			}
+			return
+		}
+	}()
+
+	copied, err := io.Copy(dst, &contextReader{ctx: ctx, reader: src, progress: &lastProgress})
+	close(copyDone)
+	<-watchdogDone
+	if err != nil && !errors.Is(err, ErrCopyStalled) && !errors.Is(err, runner.ErrContextDone) {
+		if cause := context.Cause(ctx); errors.Is(cause, ErrCopyStalled) {
+			// The read or write that stalled was interrupted, or failed
+			// once it returned.
+			err = fmt.Errorf("%w: %w", cause, err)
+		} else if ctx.Err() != nil {
+			err = fmt.Errorf("%w: %w", runner.ErrContextDone, err)
+		}
+	}
+	return copied, err
+}
+
+// contextReader stops reading once its context is done, and records the time
+// of each successful read.
+type contextReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	progress *atomic.Int64
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		if cause := context.Cause(reader.ctx); errors.Is(cause, ErrCopyStalled) {
+			return 0, cause
+		}
+		return 0, fmt.Errorf("%w: %w", runner.ErrContextDone, err)
+	}
+	n, err := reader.reader.Read(p)
+	if n > 0 {
+		reader.progress.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// finishFile syncs and closes a file that was written, and checks that it has
+// the expected size; it always closes the file.
+func finishFile(file *os.File, expectedSize int64) error {
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to sync %q: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close %q: %w", file.Name(), err)
+	}
+	return verifySize(file.Name(), expectedSize)
+}
+
+// verifySize checks that the file at path has the expected size.
+func verifySize(path string, expectedSize int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify %q: %w", path, err)
+	}
+	if info.Size() != expectedSize {
+		return fmt.Errorf("%w: %q has %d bytes instead of %d", ErrIncompleteWrite, path, info.Size(), expectedSize)
+	}
+	return nil
+}
+
+// syncFile syncs a file that was written by another process.
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to sync %q: %w", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to sync %q: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to sync %q: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileSynced writes a file like os.WriteFile, but syncs it, and checks
+// that all of it was written.
+func writeFileSynced(path string, contents []byte, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(contents); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := finishFile(file, int64(len(contents))); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// replaceFile replaces the file at path with the given contents. The contents
+// are written to a temporary file that is then renamed over the file, so that
+// an interruption never leaves the file partially written. Some network
+// shares don't allow renaming over an existing file, or don't do it
+// atomically; there, the file is overwritten in place instead, which may leave
+// it partially written if interrupted. For metadata files, `rdctl snapshot
+// fsck --fix` can then reconstruct them.
+func replaceFile(path string, contents []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	tempFile, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if err := tempFile.Chmod(mode); err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+	if _, err := tempFile.Write(contents); err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+	if err := finishFile(tempFile, int64(len(contents))); err != nil {
+		return err
+	}
+	if renameErr := renameFile(tempFile.Name(), path); renameErr != nil {
+		if _, err := os.Stat(path); err != nil {
+			// There is nothing to rename over, so this isn't the case
+			// being worked around.
+			return renameErr
+		}
+		if err := writeFileSynced(path, contents, mode); err != nil {
+			return fmt.Errorf("%w (after failing to rename over it: %w)", err, renameErr)
+		}
+		return nil
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	return verifySize(path, int64(len(contents)))
+}
+
+// writeCompleteFile marks a snapshot complete. This must be done last, once
+// all of its other files are written, because the presence of the file
+// signifies a complete and valid snapshot; the snapshot directory is synced
+// before and after, so that complete.txt is never there without the files.
+func writeCompleteFile(snapshotDir string) error {
+	if err := syncDir(snapshotDir); err != nil {
+		return err
+	}
+	completeFilePath := filepath.Join(snapshotDir, completeFileName)
+	if err := writeFileSynced(completeFilePath, []byte(completeFileContents), 0o644); err != nil {
+		return fmt.Errorf("failed to write %q: %w", completeFileName, err)
+	}
+	return syncDir(filepath.Dir(snapshotDir))
+}
@@ -0,0 +1,195 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/runner"
+)
+
+// slowWriter mimics a network share: each write takes delay, and writes fail
+// once failAfter bytes have been written, if it is positive.
+type slowWriter struct {
+	// Not embedded, so that io.Copy can't use bytes.Buffer.ReadFrom.
+	written   bytes.Buffer
+	delay     time.Duration
+	failAfter int
+}
+
+var errShareGone = errors.New("share went away")
+
+func (writer *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(writer.delay)
+	if writer.failAfter > 0 && writer.written.Len()+len(p) > writer.failAfter {
+		n, _ := writer.written.Write(p[:writer.failAfter-writer.written.Len()])
+		return n, errShareGone
+	}
+	return writer.written.Write(p)
+}
+
+// chunkedReader returns its data a few bytes at a time, so that copies take
+// several writes.
+type chunkedReader struct {
+	data []byte
+}
+
+func (reader *chunkedReader) Read(p []byte) (int, error) {
+	if len(reader.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), 4)], reader.data)
+	reader.data = reader.data[n:]
+	return n, nil
+}
+
+func TestCopyData(t *testing.T) {
+	defer func(timeout time.Duration) { copyStallTimeout = timeout }(copyStallTimeout)
+	copyStallTimeout = 200 * time.Millisecond
+	data := []byte(strings.Repeat("snapshot data ", 8))
+
+	t.Run("slow writes that keep making progress are not a stall", func(t *testing.T) {
+		writer := &slowWriter{delay: copyStallTimeout / 10}
+		copied, err := copyData(context.Background(), writer, &chunkedReader{data: data})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if copied != int64(len(data)) || !bytes.Equal(writer.written.Bytes(), data) {
+			t.Errorf("copied %d bytes %q, expected %q", copied, writer.written.Bytes(), data)
+		}
+	})
+	t.Run("a write that stalls is given up on", func(t *testing.T) {
+		writer := &slowWriter{delay: 2 * copyStallTimeout}
+		_, err := copyData(context.Background(), writer, &chunkedReader{data: data})
+		if !errors.Is(err, ErrCopyStalled) {
+			t.Errorf("expected ErrCopyStalled, got %v", err)
+		}
+	})
+	t.Run("a failing write is reported", func(t *testing.T) {
+		writer := &slowWriter{failAfter: 10}
+		copied, err := copyData(context.Background(), writer, &chunkedReader{data: data})
+		if !errors.Is(err, errShareGone) {
+			t.Errorf("expected the write error, got %v", err)
+		}
+		if copied != 10 {
+			t.Errorf("expected 10 bytes to be copied, got %d", copied)
+		}
+	})
+	t.Run("a read that is blocked is interrupted", func(t *testing.T) {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %s", err)
+		}
+		defer reader.Close()
+		defer writer.Close()
+		if _, err := writer.WriteString("partial"); err != nil {
+			t.Fatalf("failed to write to pipe: %s", err)
+		}
+		copied, err := copyData(context.Background(), &slowWriter{}, reader, reader)
+		if !errors.Is(err, ErrCopyStalled) {
+			t.Errorf("expected ErrCopyStalled, got %v", err)
+		}
+		if copied != int64(len("partial")) {
+			t.Errorf("expected %d bytes to be copied, got %d", len("partial"), copied)
+		}
+	})
+	t.Run("a blocked read is interrupted when the context is done", func(t *testing.T) {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %s", err)
+		}
+		defer reader.Close()
+		defer writer.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(copyStallTimeout/10, cancel)
+		_, err = copyData(ctx, &slowWriter{}, reader, reader)
+		if !errors.Is(err, runner.ErrContextDone) {
+			t.Errorf("expected ErrContextDone, got %v", err)
+		}
+	})
+	t.Run("a cancelled copy stops", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := copyData(ctx, &slowWriter{}, &chunkedReader{data: data})
+		if !errors.Is(err, runner.ErrContextDone) {
+			t.Errorf("expected ErrContextDone, got %v", err)
+		}
+	})
+}
+
+func TestVerifySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk")
+	if err := os.WriteFile(path, []byte("truncated"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := verifySize(path, 9); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := verifySize(path, 1024); !errors.Is(err, ErrIncompleteWrite) {
+		t.Errorf("expected ErrIncompleteWrite, got %v", err)
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	readFile := func(t *testing.T, path string) string {
+		t.Helper()
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %q: %s", path, err)
+		}
+		return string(contents)
+	}
+	// Temporary files must not be left behind.
+	checkEntries := func(t *testing.T, dir string) {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("failed to read %q: %s", dir, err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected a single file, got %d entries", len(entries))
+		}
+	}
+
+	t.Run("replaceFile should create and replace files", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, metadataFileName)
+		if err := replaceFile(path, []byte("first"), 0o644); err != nil {
+			t.Fatalf("failed to create file: %s", err)
+		}
+		if err := replaceFile(path, []byte("second"), 0o644); err != nil {
+			t.Fatalf("failed to replace file: %s", err)
+		}
+		if contents := readFile(t, path); contents != "second" {
+			t.Errorf("unexpected contents %q", contents)
+		}
+		checkEntries(t, dir)
+	})
+	t.Run("replaceFile should overwrite files on shares that can't rename over them", func(t *testing.T) {
+		defer func(rename func(string, string) error) { renameFile = rename }(renameFile)
+		renameFile = func(oldPath, newPath string) error {
+			if _, err := os.Stat(newPath); err == nil {
+				return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrExist}
+			}
+			return os.Rename(oldPath, newPath)
+		}
+		dir := t.TempDir()
+		path := filepath.Join(dir, metadataFileName)
+		if err := replaceFile(path, []byte("the first version"), 0o644); err != nil {
+			t.Fatalf("failed to create file: %s", err)
+		}
+		if err := replaceFile(path, []byte("second"), 0o644); err != nil {
+			t.Fatalf("failed to replace file: %s", err)
+		}
+		if contents := readFile(t, path); contents != "second" {
+			t.Errorf("unexpected contents %q", contents)
+		}
+		checkEntries(t, dir)
+	})
+}
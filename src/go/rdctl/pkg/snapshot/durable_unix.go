@@ -0,0 +1,26 @@
+//go:build unix
+
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// syncDir syncs a directory, so that the files created in or renamed into it
+// are known to be there. Some network filesystems don't support syncing
+// directories, and persist the entries as part of the operation instead;
+// that is not an error.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to sync directory %q: %w", path, err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return fmt.Errorf("failed to sync directory %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package snapshot
+
+// syncDir does nothing on Windows, where directories can't be opened to be
+// synced; flushing the files written in them is as far as Windows goes.
+func syncDir(path string) error {
+	return nil
+}
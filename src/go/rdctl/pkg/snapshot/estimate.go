@@ -0,0 +1,49 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSizeUnknown is returned by EstimateSize, along with the estimated size of
+// the files that could be sized, when the size of some of them is unknown.
+var ErrSizeUnknown = errors.New("the size of some files is unknown")
+
+// sizedSource is a file a snapshot captures, as sized by EstimateSize.
+type sizedSource struct {
+	// The working file.
+	Path string
+	// Whether the snapshot is created without the file if it is missing.
+	MissingOk bool
+}
+
+// EstimateSize returns roughly how many bytes a snapshot created now with the
+// given options would take, without creating it. Snapshots are not
+// compressed, so this is the size of the files they capture; copy-on-write
+// clones, and with opts.Deduplicate, files stored by other snapshots already,
+// may take less. If some of the files can't be sized, the estimate of the
+// others is returned with an error wrapping ErrSizeUnknown.
+func (manager *Manager) EstimateSize(opts CreateOptions) (int64, error) {
+	if opts.Deduplicate && !deduplicatedSnapshots {
+		return 0, errors.New("deduplicated snapshots are not supported on this platform")
+	}
+	var size int64
+	var unknown []string
+	for _, source := range estimateSources(manager.Paths) {
+		info, err := os.Stat(source.Path)
+		if errors.Is(err, os.ErrNotExist) && source.MissingOk {
+			continue
+		} else if err != nil {
+			unknown = append(unknown, filepath.Base(source.Path))
+			continue
+		}
+		size += info.Size()
+	}
+	if len(unknown) > 0 {
+		return size, fmt.Errorf("%w: %s", ErrSizeUnknown, strings.Join(unknown, ", "))
+	}
+	return size, nil
+}
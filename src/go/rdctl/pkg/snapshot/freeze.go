@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The name of the file, in the snapshots directory, whose presence freezes
+// the snapshots; see Manager.Freeze. Keeping it in the snapshots directory
+// freezes the snapshots for every manager using them, and across restarts.
+const frozenFileName = "frozen.json"
+
+// ErrStoreFrozen is returned by operations that would create, delete or
+// restore snapshots while the snapshots are frozen; see Manager.Freeze.
+var ErrStoreFrozen = errors.New("the snapshots are frozen")
+
+// frozenState is the schema of the frozen file.
+type frozenState struct {
+	// When the snapshots were frozen.
+	Since time.Time `json:"since"`
+}
+
+func frozenFilePath(snapshotsDir string) string {
+	return filepath.Join(snapshotsDir, frozenFileName)
+}
+
+// Freeze freezes the snapshots, as a safety measure before doing something
+// risky: until Unfreeze is called, snapshots can't be created, deleted,
+// restored, pruned, migrated or repaired by Fsck, and those operations return
+// ErrStoreFrozen. Listing, inspecting and copying snapshots, and changing
+// their notes and protection, still work. Freezing snapshots that are already
+// frozen keeps the time they were first frozen.
+func (manager *Manager) Freeze() error {
+	if err := manager.checkWritable(); err != nil {
+		return err
+	}
+	if since, err := manager.FrozenSince(); err == nil && !since.IsZero() {
+		return nil
+	}
+	contents, err := json.Marshal(frozenState{Since: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to freeze snapshots: %w", err)
+	}
+	if err := os.MkdirAll(manager.Snapshots, 0o755); err != nil {
+		return fmt.Errorf("failed to freeze snapshots: %w", err)
+	}
+	if err := replaceFile(frozenFilePath(manager.Snapshots), contents, 0o644); err != nil {
+		return fmt.Errorf("failed to freeze snapshots: %w", err)
+	}
+	return nil
+}
+
+// Unfreeze undoes Freeze. Unfreezing snapshots that are not frozen does
+// nothing.
+func (manager *Manager) Unfreeze() error {
+	if err := manager.checkWritable(); err != nil {
+		return err
+	}
+	if err := os.Remove(frozenFilePath(manager.Snapshots)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to unfreeze snapshots: %w", err)
+	}
+	return nil
+}
+
+// FrozenSince returns when the snapshots were frozen, or the zero time if
+// they are not frozen.
+func (manager *Manager) FrozenSince() (time.Time, error) {
+	contents, err := os.ReadFile(frozenFilePath(manager.Snapshots))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read frozen state: %w", err)
+	}
+	var state frozenState
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read frozen state: %w", err)
+	}
+	if state.Since.IsZero() {
+		return time.Time{}, errors.New("failed to read frozen state: the time the snapshots were frozen is missing")
+	}
+	return state.Since, nil
+}
+
+// checkNotFrozen returns ErrStoreFrozen if the snapshots are frozen, so that
+// operations changing them fail before doing anything. A frozen file that
+// can't be read still freezes them.
+func (manager *Manager) checkNotFrozen() error {
+	if !exists(frozenFilePath(manager.Snapshots)) {
+		return nil
+	}
+	since, err := manager.FrozenSince()
+	if err != nil {
+		return fmt.Errorf("%w (%w); run `rdctl snapshot unfreeze` to unfreeze them", ErrStoreFrozen, err)
+	}
+	return fmt.Errorf("%w since %s; run `rdctl snapshot unfreeze` to unfreeze them", ErrStoreFrozen, since.Format(time.RFC3339))
+}
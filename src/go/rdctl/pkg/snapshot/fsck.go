@@ -0,0 +1,478 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/lock"
+)
+
+// The name of the directory, under the snapshots directory, that Fsck moves
+// snapshots it can't repair to, so that they are out of the way but can still
+// be inspected or recovered by hand.
+const quarantineDirName = "quarantine"
+
+// FsckProblemKind identifies a kind of problem found by Fsck.
+type FsckProblemKind string
+
+const (
+	// The snapshot directory has no complete.txt: the snapshot was never
+	// finished, or was partially deleted.
+	FsckIncomplete FsckProblemKind = "incomplete"
+	// Files needed to restore the snapshot are missing.
+	FsckMissingFiles FsckProblemKind = "missing-files"
+	// The metadata file is missing or can't be parsed.
+	FsckCorruptMetadata FsckProblemKind = "corrupt-metadata"
+	// The ID in the metadata does not match the name of the directory.
+	FsckIDMismatch FsckProblemKind = "id-mismatch"
+	// Another snapshot has the same name, ignoring case.
+	FsckDuplicateName FsckProblemKind = "duplicate-name"
+	// Files of the snapshot can't be read, or objects it refers to don't
+	// match their checksum; only found with FsckOptions.Verify.
+	FsckCorruptFiles FsckProblemKind = "corrupt-files"
+	// The snapshots directory contains something that is not a snapshot.
+	// These are never touched, as they may not belong to Rancher Desktop.
+	FsckUnexpectedEntry FsckProblemKind = "unexpected-entry"
+)
+
+// FsckProblem describes a single problem found by Fsck.
+type FsckProblem struct {
+	// The name of the entry in the snapshots directory; for snapshots, this
+	// is the snapshot ID.
+	Entry string `json:"entry"`
+	// The name of the snapshot, if known.
+	Name   string          `json:"name,omitempty"`
+	Kind   FsckProblemKind `json:"kind"`
+	Detail string          `json:"detail"`
+	// The repair that fixes the problem, or was done to fix it; empty if
+	// there is none.
+	Repair string `json:"repair,omitempty"`
+	// Whether the repair was done.
+	Repaired bool `json:"repaired"`
+	// Why the repair failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// FsckReport is the result of Fsck.
+type FsckReport struct {
+	// The number of snapshot directories checked.
+	Checked int `json:"checked"`
+	// The problems found, in the order of the directory entries.
+	Problems []FsckProblem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems that remain.
+func (report FsckReport) Unrepaired() int {
+	count := 0
+	for _, problem := range report.Problems {
+		if !problem.Repaired {
+			count++
+		}
+	}
+	return count
+}
+
+// add adds a problem to the report, along with the error that repairing it
+// failed with, if any.
+func (report *FsckReport) add(problem FsckProblem, repairErr error) {
+	if repairErr != nil {
+		problem.Error = repairErr.Error()
+	}
+	report.Problems = append(report.Problems, problem)
+}
+
+// DefaultFsckWorkers is the number of snapshots FsckWithOptions checks at
+// once by default: enough to keep an SSD busy while files are read with
+// FsckOptions.Verify, few enough not to starve everything else of IO. A
+// single worker is best for spinning disks.
+const DefaultFsckWorkers = 4
+
+// FsckOptions modifies the behaviour of Manager.FsckWithOptions.
+type FsckOptions struct {
+	// Also make the repairs that are safe to do.
+	Fix bool
+	// Also read every file of each snapshot, to find files that can't be
+	// read, and objects of deduplicated snapshots that don't match the
+	// checksum they are stored under. Snapshots with such files can't be
+	// repaired, and are quarantined by Fix.
+	Verify bool
+	// The number of snapshots checked at once; zero for
+	// DefaultFsckWorkers.
+	Workers int
+	// Stop at the first entry with a problem, in the order of the
+	// directory entries, rather than checking them all. The entries before
+	// it are still all checked, so that the report is the same however the
+	// checks are run; names are only checked for duplicates if every entry
+	// was.
+	FailFast bool
+}
+
+// Fsck checks the snapshots directory for problems: incomplete snapshots,
+// snapshots missing files, corrupt metadata, metadata whose ID does not match
+// its directory, snapshots with duplicate names, and entries that are not
+// snapshots. If fix is true, it also makes the repairs that are safe to do:
+// incomplete snapshots are deleted, metadata is reconstructed or corrected,
+// duplicate names are made unique, and snapshots that can't be repaired are
+// moved to a quarantine directory. Entries that are not snapshots are only
+// reported.
+func (manager *Manager) Fsck(fix bool) (FsckReport, error) {
+	return manager.FsckWithOptions(context.Background(), FsckOptions{Fix: fix})
+}
+
+// fsckEntryReport is what checking a single entry of the snapshots directory
+// found.
+type fsckEntryReport struct {
+	// Whether the entry is a snapshot directory.
+	snapshotDir bool
+	problems    []FsckProblem
+	// The snapshot, if it is usable after any repairs.
+	snapshot *Snapshot
+}
+
+// add adds a problem to the report, as FsckReport.add does.
+func (report *fsckEntryReport) add(problem FsckProblem, repairErr error) {
+	if repairErr != nil {
+		problem.Error = repairErr.Error()
+	}
+	report.problems = append(report.problems, problem)
+}
+
+// FsckWithOptions checks the snapshots directory like Fsck, with the given
+// options. The snapshots are checked by several workers at once, but the
+// problems are reported in the order of the directory entries whatever the
+// order the checks finish in, followed by the duplicate names.
+func (manager *Manager) FsckWithOptions(ctx context.Context, opts FsckOptions) (FsckReport, error) {
+	fix := opts.Fix
+	if fix {
+		if err := manager.checkWritable(); err != nil {
+			return FsckReport{}, err
+		}
+		if err := manager.checkNotFrozen(); err != nil {
+			return FsckReport{}, err
+		}
+		// Repairs would break an operation that is creating or deleting
+		// snapshots.
+		if locked, err := lock.IsLocked(manager.Paths); err != nil {
+			return FsckReport{}, err
+		} else if locked {
+			return FsckReport{}, errors.New("a snapshot operation is in progress; if there is none, remove the lock with `rdctl snapshot unlock` first")
+		}
+	}
+	if fix {
+		defer manager.invalidateListCache()
+	}
+	dirEntries, err := os.ReadDir(manager.Snapshots)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return FsckReport{}, fmt.Errorf("failed to read snapshots directory: %w", err)
+	}
+	dirEntries = slices.DeleteFunc(dirEntries, func(dirEntry os.DirEntry) bool {
+		entry := dirEntry.Name()
+		// The throwaway directory of a self-test is skipped too; see
+		// SelfTest.
+		return entry == logsDirName || entry == quarantineDirName || entry == objectsDirName || entry == frozenFileName ||
+			(strings.HasPrefix(entry, selfTestDirPrefix) && dirEntry.IsDir())
+	})
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultFsckWorkers
+	}
+	reports := make([]fsckEntryReport, len(dirEntries))
+	// The index of the first entry found to have a problem, with FailFast;
+	// the entries after it are not checked.
+	var firstProblem atomic.Int64
+	firstProblem.Store(int64(len(dirEntries)))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(dirEntries)) {
+		wg.Go(func() {
+			for index := range indexes {
+				if opts.FailFast && int64(index) > firstProblem.Load() {
+					continue
+				}
+				reports[index] = manager.fsckEntry(ctx, dirEntries[index], opts)
+				if opts.FailFast && len(reports[index].problems) > 0 {
+					for current := firstProblem.Load(); int64(index) < current; current = firstProblem.Load() {
+						if firstProblem.CompareAndSwap(current, int64(index)) {
+							break
+						}
+					}
+				}
+			}
+		})
+	}
+	for index := range dirEntries {
+		if ctx.Err() != nil || (opts.FailFast && int64(index) > firstProblem.Load()) {
+			break
+		}
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return FsckReport{}, err
+	}
+
+	report := FsckReport{Problems: []FsckProblem{}}
+	var snapshots []Snapshot
+	checkedAll := true
+	for index, entryReport := range reports {
+		if int64(index) > firstProblem.Load() {
+			checkedAll = false
+			break
+		}
+		if entryReport.snapshotDir {
+			report.Checked++
+		}
+		report.Problems = append(report.Problems, entryReport.problems...)
+		if entryReport.snapshot != nil {
+			snapshots = append(snapshots, *entryReport.snapshot)
+		}
+	}
+	if checkedAll {
+		manager.fsckDuplicateNames(&report, snapshots, fix)
+	}
+	if fix {
+		// Deleting and quarantining snapshots can leave objects that
+		// nothing refers to.
+		if err := manager.collectObjects(); err != nil {
+			return report, fmt.Errorf("failed to remove unused snapshot objects: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// fsckEntry checks a single entry of the snapshots directory.
+func (manager *Manager) fsckEntry(ctx context.Context, dirEntry os.DirEntry, opts FsckOptions) fsckEntryReport {
+	var report fsckEntryReport
+	entry := dirEntry.Name()
+	if _, err := uuid.Parse(entry); err != nil || !dirEntry.IsDir() {
+		report.add(FsckProblem{
+			Entry:  entry,
+			Kind:   FsckUnexpectedEntry,
+			Detail: "not a snapshot directory",
+		}, nil)
+		return report
+	}
+	report.snapshotDir = true
+	if snapshot, ok := manager.fsckSnapshot(ctx, &report, entry, opts); ok {
+		report.snapshot = &snapshot
+	}
+	return report
+}
+
+// fsckSnapshot checks a single snapshot directory, and returns the snapshot
+// if it is usable after any repairs.
+func (manager *Manager) fsckSnapshot(ctx context.Context, report *fsckEntryReport, id string, opts FsckOptions) (Snapshot, bool) {
+	fix := opts.Fix
+	snapshotDir := snapshotDirPath(manager.Snapshots, id)
+	snapshot, metadataErr := readMetadataFile(metadataFilePath(manager.Snapshots, id))
+
+	completeInfo, err := os.Stat(completeFilePath(manager.Snapshots, id))
+	if err != nil {
+		problem := FsckProblem{
+			Entry:  id,
+			Name:   snapshot.Name,
+			Kind:   FsckIncomplete,
+			Detail: fmt.Sprintf("%s is missing", completeFileName),
+			Repair: "delete",
+		}
+		var repairErr error
+		if fix {
+			if repairErr = os.RemoveAll(snapshotDir); repairErr == nil {
+				problem.Repaired = true
+			}
+		}
+		report.add(problem, repairErr)
+		return Snapshot{}, false
+	}
+
+	manifest, manifestErr := readObjectManifest(snapshotDir)
+	var missing []string
+	for _, candidates := range requiredSnapshotFiles(snapshotDir) {
+		if !slices.ContainsFunc(candidates, func(path string) bool {
+			info, err := os.Stat(manifest.snapshotFilePath(snapshotDir, filepath.Base(path)))
+			return err == nil && info.Mode().IsRegular()
+		}) {
+			missing = append(missing, filepath.Base(candidates[0]))
+		}
+	}
+	if manifestErr != nil || len(missing) > 0 {
+		detail := fmt.Sprintf("missing %s", strings.Join(missing, ", "))
+		if manifestErr != nil {
+			detail = manifestErr.Error()
+		}
+		problem := FsckProblem{
+			Entry:  id,
+			Name:   snapshot.Name,
+			Kind:   FsckMissingFiles,
+			Detail: detail,
+			Repair: "quarantine",
+		}
+		var repairErr error
+		if fix {
+			if repairErr = manager.quarantine(id); repairErr == nil {
+				problem.Repaired = true
+			}
+		}
+		report.add(problem, repairErr)
+		return Snapshot{}, false
+	}
+
+	if opts.Verify {
+		if corrupt := verifyStoredFiles(ctx, snapshotDir, manifest); len(corrupt) > 0 {
+			problem := FsckProblem{
+				Entry:  id,
+				Name:   snapshot.Name,
+				Kind:   FsckCorruptFiles,
+				Detail: strings.Join(corrupt, "; "),
+				Repair: "quarantine",
+			}
+			var repairErr error
+			if fix {
+				if repairErr = manager.quarantine(id); repairErr == nil {
+					problem.Repaired = true
+				}
+			}
+			report.add(problem, repairErr)
+			return Snapshot{}, false
+		}
+	}
+
+	if metadataErr != nil {
+		// The files are all there, so only the metadata needs replacing.
+		snapshot = Snapshot{
+			Created:         completeInfo.ModTime(),
+			Name:            "recovered-" + id[:8],
+			ID:              id,
+			Description:     "Metadata reconstructed by rdctl snapshot fsck.",
+			SettingsVersion: readSettingsVersion(manifest.snapshotFilePath(snapshotDir, "settings.json")),
+		}
+		problem := FsckProblem{
+			Entry:  id,
+			Kind:   FsckCorruptMetadata,
+			Detail: metadataErr.Error(),
+			Repair: fmt.Sprintf("reconstruct metadata, named %q", snapshot.Name),
+		}
+		var repairErr error
+		if fix {
+			if repairErr = manager.writeMetadataFile(snapshot); repairErr == nil {
+				problem.Repaired = true
+			}
+		}
+		report.add(problem, repairErr)
+		return snapshot, problem.Repaired
+	}
+
+	if snapshot.ID != id {
+		problem := FsckProblem{
+			Entry:  id,
+			Name:   snapshot.Name,
+			Kind:   FsckIDMismatch,
+			Detail: fmt.Sprintf("metadata has ID %q", snapshot.ID),
+			Repair: "set the ID to the directory name",
+		}
+		snapshot.ID = id
+		var repairErr error
+		if fix {
+			if repairErr = manager.writeMetadataFile(snapshot); repairErr == nil {
+				problem.Repaired = true
+			}
+		}
+		report.add(problem, repairErr)
+		return snapshot, problem.Repaired
+	}
+
+	return snapshot, true
+}
+
+// verifyStoredFiles reads every file of the snapshot in snapshotDir, and the
+// objects its manifest refers to, and describes those that can't be read or
+// don't match the checksum they are stored under. It stops early if the
+// context is done; the caller checks it.
+func verifyStoredFiles(ctx context.Context, snapshotDir string, manifest objectManifest) []string {
+	var corrupt []string
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if _, err := checksumFile(ctx, filepath.Join(snapshotDir, entry.Name()), 0); err != nil && ctx.Err() == nil {
+			corrupt = append(corrupt, fmt.Sprintf("%s can't be read: %s", entry.Name(), err))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(manifest)) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		checksum, err := checksumFile(ctx, objectPath(objectsDirPath(snapshotDir), manifest[name]), 0)
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			corrupt = append(corrupt, fmt.Sprintf("%s can't be read: %s", name, err))
+		} else if checksum != manifest[name] {
+			corrupt = append(corrupt, fmt.Sprintf("%s has checksum %s, not %s", name, checksum, manifest[name]))
+		}
+	}
+	return corrupt
+}
+
+// fsckDuplicateNames checks for snapshots whose names differ only in case.
+// The oldest snapshot keeps its name; the others are renamed by appending
+// the start of their IDs.
+func (manager *Manager) fsckDuplicateNames(report *FsckReport, snapshots []Snapshot, fix bool) {
+	slices.SortStableFunc(snapshots, func(a, b Snapshot) int {
+		return a.Created.Compare(b.Created)
+	})
+	names := make(map[string]Snapshot, len(snapshots))
+	for _, snapshot := range snapshots {
+		key := strings.ToLower(snapshot.Name)
+		original, ok := names[key]
+		if !ok {
+			names[key] = snapshot
+			continue
+		}
+		newName := fmt.Sprintf("%s-%s", truncate(snapshot.Name, maxNameLength-9), snapshot.ID[:8])
+		problem := FsckProblem{
+			Entry:  snapshot.ID,
+			Name:   snapshot.Name,
+			Kind:   FsckDuplicateName,
+			Detail: fmt.Sprintf("snapshot %s is also named %q", original.ID, original.Name),
+			Repair: fmt.Sprintf("rename to %q", newName),
+		}
+		var repairErr error
+		if fix {
+			snapshot.Name = newName
+			if repairErr = manager.writeMetadataFile(snapshot); repairErr == nil {
+				problem.Repaired = true
+			}
+		}
+		names[strings.ToLower(newName)] = snapshot
+		report.add(problem, repairErr)
+	}
+}
+
+// quarantine moves a snapshot directory into the quarantine directory.
+func (manager *Manager) quarantine(id string) error {
+	quarantineDir := filepath.Join(manager.Snapshots, quarantineDirName)
+	if err := os.MkdirAll(quarantineDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(snapshotDirPath(manager.Snapshots, id), snapshotDirPath(quarantineDir, id)); err != nil {
+		return fmt.Errorf("failed to quarantine snapshot: %w", err)
+	}
+	return nil
+}
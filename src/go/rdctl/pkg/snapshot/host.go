@@ -0,0 +1,92 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The name of the file, in the config directory, holding the ID of this
+// machine recorded in snapshots. It is not part of any snapshot, so restoring
+// a snapshot taken elsewhere doesn't change it.
+const machineIDFileName = "snapshot-machine-id"
+
+// HostIdentity records the machine a snapshot was created on, to tell apart
+// snapshots collected from several machines.
+type HostIdentity struct {
+	// A random UUID made the first time a snapshot is created on the
+	// machine, and kept in the config directory; it says nothing about the
+	// machine, other than that snapshots with the same ID share it.
+	MachineID string `json:"machineId"`
+	// The host name of the machine; only recorded when
+	// CreateOptions.RecordHostname is set.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// String describes the host, for display.
+func (host *HostIdentity) String() string {
+	if host.Hostname != "" {
+		return fmt.Sprintf("%s (%s)", host.Hostname, host.MachineID)
+	}
+	return host.MachineID
+}
+
+// osHostname returns the host name of the machine; tests replace it.
+var osHostname = os.Hostname
+
+// hostIdentity returns the identity to record in a snapshot created here.
+func (manager *Manager) hostIdentity(recordHostname bool) (*HostIdentity, error) {
+	machineID, err := manager.machineID()
+	if err != nil {
+		return nil, err
+	}
+	host := &HostIdentity{MachineID: machineID}
+	if recordHostname {
+		if host.Hostname, err = osHostname(); err != nil {
+			return nil, fmt.Errorf("failed to get host name: %w", err)
+		}
+	}
+	return host, nil
+}
+
+// machineID returns the ID of this machine, making it if there is none yet.
+func (manager *Manager) machineID() (string, error) {
+	path := filepath.Join(manager.Config, machineIDFileName)
+	contents, err := os.ReadFile(path)
+	if err == nil {
+		id, err := uuid.Parse(strings.TrimSpace(string(contents)))
+		if err != nil {
+			return "", fmt.Errorf("invalid machine ID in %q: %w", path, err)
+		}
+		return id.String(), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read machine ID: %w", err)
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate machine ID: %w", err)
+	}
+	if err := os.MkdirAll(manager.Config, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create config directory: %w", err)
+	}
+	// O_EXCL, so that of two snapshots created at once, both get the same ID.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return manager.machineID()
+	} else if err != nil {
+		return "", fmt.Errorf("failed to write machine ID: %w", err)
+	}
+	_, err = fmt.Fprintln(file, id.String())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("failed to write machine ID: %w", err)
+	}
+	return id.String(), nil
+}
@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// With ManagerConfig.IndexFile set, List reads the metadata of all snapshots
+// from a single index file, which can be kept on local storage when the
+// snapshots directory is on slow or remote storage. The metadata file of each
+// snapshot stays the source of truth: the index is only a cache of it, and is
+// rebuilt from the snapshots whenever it can't be trusted. It can't be
+// trusted if the snapshots directory has been modified since it was built, as
+// happens when a snapshot directory is added or removed, and managers that
+// change snapshots remove it, so that the next List rebuilds it.
+
+// The version of the index file format; an index of another version is
+// rebuilt.
+const indexVersion = 1
+
+// metadataIndex is the schema of the index file.
+type metadataIndex struct {
+	Version int `json:"version"`
+	// The snapshots directory the index was built from.
+	Snapshots string `json:"snapshots"`
+	// The modification time of the snapshots directory when the index was
+	// built; zero if it was too recent to be trusted, in which case the
+	// index is never used.
+	DirModTime time.Time `json:"dirModTime,omitzero"`
+	// The snapshots, in directory order, including incomplete ones.
+	Entries []indexEntry `json:"entries"`
+}
+
+// indexEntry is a snapshot in the index.
+type indexEntry struct {
+	// The ID of the snapshot directory.
+	ID string `json:"id"`
+	// Whether the snapshot is complete.
+	Complete bool `json:"complete"`
+	// The contents of the metadata file.
+	Metadata metadata `json:"metadata"`
+}
+
+// readIndex returns the entries of the index, and whether it can be used: the
+// index is configured, readable, and built from the snapshots directory as it
+// is now.
+func (manager *Manager) readIndex() ([]indexEntry, bool) {
+	if manager.config.IndexFile == "" {
+		return nil, false
+	}
+	contents, err := os.ReadFile(manager.config.IndexFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Debugf("not using snapshot index: %s", err)
+		}
+		return nil, false
+	}
+	var index metadataIndex
+	if err := json.Unmarshal(contents, &index); err != nil {
+		logrus.Debugf("not using snapshot index %q: %s", manager.config.IndexFile, err)
+		return nil, false
+	}
+	if index.Version != indexVersion || index.Snapshots != manager.Snapshots || index.DirModTime.IsZero() {
+		return nil, false
+	}
+	info, err := os.Stat(manager.Snapshots)
+	if err != nil || !info.ModTime().Equal(index.DirModTime) {
+		return nil, false
+	}
+	return index.Entries, true
+}
+
+// writeIndex writes the index of the entries, read from the snapshots
+// directory when it had the given modification time.
+func (manager *Manager) writeIndex(entries []indexEntry, dirModTime time.Time) error {
+	contents, err := json.Marshal(metadataIndex{
+		Version:    indexVersion,
+		Snapshots:  manager.Snapshots,
+		DirModTime: dirModTime,
+		Entries:    entries,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(manager.config.IndexFile), 0o755); err != nil {
+		return fmt.Errorf("failed to write snapshot index: %w", err)
+	}
+	if err := replaceFile(manager.config.IndexFile, contents, 0o644); err != nil {
+		return fmt.Errorf("failed to write snapshot index: %w", err)
+	}
+	return nil
+}
+
+// removeIndex removes the index, so that the next List rebuilds it.
+func (manager *Manager) removeIndex() {
+	if manager.config.IndexFile == "" {
+		return
+	}
+	if err := os.Remove(manager.config.IndexFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logrus.Warnf("failed to remove snapshot index: %s", err)
+	}
+}
+
+// RebuildIndex rebuilds the index of ManagerConfig.IndexFile from the metadata
+// of the snapshots, as after it was changed by something other than a
+// manager.
+func (manager *Manager) RebuildIndex() error {
+	if manager.config.IndexFile == "" {
+		return errors.New("no snapshot index is configured")
+	}
+	_, err := manager.listSnapshots(ListOptions{IncludeIncomplete: true, ForceRefresh: true}, true)
+	return err
+}
@@ -0,0 +1,240 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// List is called often, by the GUI in particular, and reading the metadata of
+// every snapshot each time is slow when there are many. The manager keeps the
+// snapshots it read, and reads a snapshot's metadata again only when the
+// metadata file has changed; the snapshots directory is only read again when
+// its modification time changes, as it does when a snapshot directory is added
+// or removed. The complete file is checked every time, as it is what makes a
+// snapshot usable. The manager also drops the cache whenever it changes
+// snapshots itself.
+
+// Modification times this close to when they were read are not trusted, as a
+// change in the same tick of the file system's clock would keep them as they
+// are; some file systems only keep times to two seconds.
+const listCacheRacyWindow = 2 * time.Second
+
+// The current time; tests replace it.
+var listCacheNow = time.Now
+
+// ListOptions modifies the behaviour of Manager.ListWithOptions.
+type ListOptions struct {
+	// Include snapshots that are being created, are being deleted, or are
+	// otherwise incomplete and cannot be restored from.
+	IncludeIncomplete bool
+	// Read all snapshots again, rather than those that changed since they
+	// were last listed.
+	ForceRefresh bool
+}
+
+// listCacheEntry is the metadata of a snapshot, as last read.
+type listCacheEntry struct {
+	snapshot Snapshot
+	// The modification time and size of the metadata file when it was read.
+	modTime time.Time
+	size    int64
+}
+
+// listCache holds the snapshots read by List. The zero value is an empty
+// cache.
+type listCache struct {
+	// Protects the other fields, and serializes listing, so that concurrent
+	// calls don't read the same files.
+	mutex sync.Mutex
+	// The modification time of the snapshots directory when ids was read;
+	// zero if ids must be read again.
+	dirModTime time.Time
+	// The IDs of the snapshot directories, in directory order.
+	ids []string
+	// The metadata last read, by snapshot ID.
+	entries map[string]listCacheEntry
+}
+
+// invalidate drops everything in the cache.
+func (cache *listCache) invalidate() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.dirModTime = time.Time{}
+	cache.ids = nil
+	cache.entries = nil
+}
+
+// invalidateListCache is called after changing any snapshot, so that the
+// change is listed even where modification times are too coarse to show it.
+// The index is removed too, as other managers may be using it.
+func (manager *Manager) invalidateListCache() {
+	manager.listCache.invalidate()
+	manager.removeIndex()
+}
+
+// ListWithOptions lists snapshots like List, with the given options.
+func (manager *Manager) ListWithOptions(opts ListOptions) ([]Snapshot, error) {
+	return manager.listSnapshots(opts, false)
+}
+
+// listSnapshots lists snapshots, from the index if it can be used; otherwise
+// the snapshots are read, and the index rebuilt from them. Failing to write
+// the index fails listing only if mustIndex is set.
+func (manager *Manager) listSnapshots(opts ListOptions, mustIndex bool) ([]Snapshot, error) {
+	cache := &manager.listCache
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if opts.ForceRefresh {
+		cache.dirModTime = time.Time{}
+		cache.entries = nil
+	}
+	listed, ok := manager.readIndex()
+	if !ok || opts.ForceRefresh {
+		var dirModTime time.Time
+		var err error
+		listed, dirModTime, err = manager.readSnapshots()
+		if err != nil {
+			return []Snapshot{}, err
+		}
+		if manager.config.IndexFile != "" {
+			if err := manager.writeIndex(listed, dirModTime); err != nil {
+				if mustIndex {
+					return []Snapshot{}, err
+				}
+				logrus.Warnf("%s", err)
+			}
+		}
+	}
+	snapshots := make([]Snapshot, 0, len(listed))
+	for _, entry := range listed {
+		if !opts.IncludeIncomplete && !entry.Complete {
+			continue
+		}
+		snapshots = append(snapshots, cloneSnapshot(entry.Metadata.snapshot()))
+	}
+	return snapshots, nil
+}
+
+// readSnapshots reads the snapshots from the snapshots directory, along with
+// its modification time if it is old enough to be trusted, reading again only
+// the metadata that changed since it was last read. The caller holds the
+// cache mutex.
+func (manager *Manager) readSnapshots() ([]indexEntry, time.Time, error) {
+	cache := &manager.listCache
+	// Anything changed after this may have a modification time from before
+	// it, so only times from well before it are trusted.
+	trustedBefore := listCacheNow().Add(-listCacheRacyWindow)
+
+	ids, err := cache.readIDs(manager.Snapshots, trustedBefore)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// The time from before the directory was read, so that a change while
+	// reading it leaves the index untrusted.
+	dirModTime := cache.dirModTime
+	entries := make(map[string]listCacheEntry, len(ids))
+	listed := make([]indexEntry, 0, len(ids))
+	for _, id := range ids {
+		snapshot, entry, err := cache.readSnapshot(manager.Snapshots, id)
+		if errors.Is(err, os.ErrNotExist) && !exists(completeFilePath(manager.Snapshots, id)) {
+			// What an interrupted deletion leaves behind; see
+			// deleteSnapshot.
+			continue
+		} else if err != nil {
+			return nil, time.Time{}, err
+		}
+		if entry != nil && entry.modTime.Before(trustedBefore) {
+			entries[id] = *entry
+		}
+
+		_, err = os.Stat(completeFilePath(manager.Snapshots, snapshot.ID))
+		completeFileExists := err == nil
+
+		listed = append(listed, indexEntry{ID: id, Complete: completeFileExists, Metadata: newMetadata(snapshot)})
+	}
+	// Only the snapshots still there are kept.
+	cache.entries = entries
+	return listed, dirModTime, nil
+}
+
+// readIDs returns the IDs of the snapshot directories, reading the snapshots
+// directory only if it changed since it was last read.
+func (cache *listCache) readIDs(snapshotsDir string, trustedBefore time.Time) ([]string, error) {
+	info, err := os.Stat(snapshotsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		cache.dirModTime = time.Time{}
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read snapshots directory: %w", err)
+	}
+	if !cache.dirModTime.IsZero() && cache.dirModTime.Equal(info.ModTime()) {
+		return cache.ids, nil
+	}
+	dirEntries, err := os.ReadDir(snapshotsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read snapshots directory: %w", err)
+	}
+	ids := make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if _, err := uuid.Parse(dirEntry.Name()); err != nil {
+			continue
+		}
+		ids = append(ids, dirEntry.Name())
+	}
+	cache.ids = ids
+	cache.dirModTime = time.Time{}
+	if info.ModTime().Before(trustedBefore) {
+		cache.dirModTime = info.ModTime()
+	}
+	return ids, nil
+}
+
+// readSnapshot returns the snapshot with the given ID, from the cache if its
+// metadata file hasn't changed, along with the entry to cache for it; the
+// entry is nil if the file couldn't be checked.
+func (cache *listCache) readSnapshot(snapshotsDir, id string) (Snapshot, *listCacheEntry, error) {
+	metadataPath := metadataFilePath(snapshotsDir, id)
+	info, err := os.Stat(metadataPath)
+	if err != nil {
+		// Let reading the file report the problem.
+		snapshot, err := readMetadataFile(metadataPath)
+		return snapshot, nil, err
+	}
+	if entry, ok := cache.entries[id]; ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.snapshot, &entry, nil
+	}
+	snapshot, err := readMetadataFile(metadataPath)
+	if err != nil {
+		return Snapshot{}, nil, err
+	}
+	return snapshot, &listCacheEntry{snapshot: snapshot, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// cloneSnapshot returns a copy of the snapshot that shares nothing with it, so
+// that callers can't change the cached snapshots.
+func cloneSnapshot(s Snapshot) Snapshot {
+	s.ComponentVersions = maps.Clone(s.ComponentVersions)
+	if s.ClusterHealth != nil {
+		health := *s.ClusterHealth
+		health.Problems = slices.Clone(health.Problems)
+		s.ClusterHealth = &health
+	}
+	if s.Host != nil {
+		host := *s.Host
+		s.Host = &host
+	}
+	if s.CapturedLogs != nil {
+		captured := *s.CapturedLogs
+		captured.Files = slices.Clone(captured.Files)
+		s.CapturedLogs = &captured
+	}
+	return s
+}
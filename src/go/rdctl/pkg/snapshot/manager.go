@@ -5,14 +5,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 	"unicode"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/lock"
+	options "github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/options/generated"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/runner"
 )
@@ -22,69 +28,433 @@ const completeFileContents = "The presence of this file indicates that this snap
 const maxNameLength = 250
 const nameDisplayCutoffSize = 30
 
+// Rancher Desktop migrates settings from older versions on startup, but
+// snapshots that are many settings versions behind were taken by a release
+// old enough that the rest of the restored data may be incompatible. Restoring
+// from a snapshot that is further behind than this, or from a snapshot that
+// is newer than the current version, requires RestoreOptions.Force.
+const maxSettingsVersionGap = 4
+
+// The settings version used by this version of Rancher Desktop.
+var currentSettingsVersion = options.CURRENT_SETTINGS_VERSION
+
+// ErrSettingsVersionMismatch is returned by Restore when the snapshot was
+// created with an incompatible settings version.
+var ErrSettingsVersionMismatch = errors.New("snapshot settings version is incompatible")
+
+// RestoreOptions modifies the behaviour of Manager.Restore.
+type RestoreOptions struct {
+	// Restore even if the snapshot's settings version is incompatible
+	// with the current version of Rancher Desktop.
+	Force bool
+	// Continue an interrupted restore of the same snapshot, skipping the
+	// files that were already restored and haven't changed since.
+	Resume bool
+	// The maximum number of bytes per second to read from the snapshot
+	// while restoring; zero means no limit. Cloning files with copy-on-write
+	// isn't throttled as no data is copied, but reading them to record their
+	// checksums is.
+	RateLimit int64
+	// If set, only restore the snapshot if its digest still matches, to
+	// avoid acting on a stale view of it; see Snapshot.Digest.
+	ExpectedDigest string
+	// The components to restore, such as "settings"; all of them if empty.
+	// The other components are left as they are.
+	Components []string
+	// Restore into the running app where possible: if the app can reload
+	// all of the components restored, the backend is not stopped, and the
+	// app is asked to reload them instead. Otherwise, the backend is stopped
+	// and restarted as usual. See RestoreResult.
+	Live bool
+	// Only restore the files whose contents differ from the snapshot, and
+	// leave those that already match it alone; which files were restored
+	// is listed in RestoreResult.Reverted. Files are compared by size, then
+	// by checksum, so every file that has the same size as in the snapshot
+	// is read. Only supported where minimalRestore is set.
+	MinimalChanges bool
+}
+
+// RestoreResult describes how the components were restored by
+// Manager.RestoreWithResult.
+type RestoreResult struct {
+	// The components restored into the running app, which reloaded them.
+	Reloaded []string `json:"reloaded,omitempty"`
+	// The components restored while the backend was stopped.
+	Restarted []string `json:"restarted,omitempty"`
+	// With RestoreOptions.Live, the components of Restarted the app can't
+	// reload, which required the backend to be stopped.
+	RestartRequired []string `json:"restartRequired,omitempty"`
+	// With RestoreOptions.MinimalChanges, the working files that differed
+	// from the snapshot, and were restored or, if the snapshot doesn't
+	// include them, removed; in lexical order.
+	Reverted []string `json:"reverted,omitempty"`
+}
+
+// CreateOptions modifies the behaviour of Manager.CreateWithOptions. The JSON
+// form is used for the profiles read by Manager.CreateProfile.
+type CreateOptions struct {
+	// The description of the snapshot; may be empty.
+	Description string `json:"description,omitempty"`
+	// Check the health of the Kubernetes cluster before stopping the
+	// backend, and record it in the snapshot; problems found, or failing to
+	// check, are only warned about.
+	CheckCluster bool `json:"checkCluster,omitempty"`
+	// Like CheckCluster, but don't create the snapshot unless the cluster
+	// could be checked and is healthy.
+	RequireHealthy bool `json:"requireHealthy,omitempty"`
+	// The most complete snapshots there may be once the snapshot is
+	// created; zero for no limit. Reaching the limit is an error, unless
+	// PruneOldest is set.
+	MaxSnapshots int `json:"maxSnapshots,omitempty"`
+	// When MaxSnapshots would be exceeded, delete the oldest snapshots to
+	// make room. They are only deleted once the new snapshot is complete.
+	PruneOldest bool `json:"pruneOldest,omitempty"`
+	// How PruneOldest measures the age of snapshots; empty for
+	// PruneByCreated.
+	PruneBy PruneBasis `json:"pruneBy,omitempty"`
+	// Store the files of the snapshot in the object store shared by
+	// snapshots, so that files identical to those of other snapshots are
+	// only stored once. Only supported where deduplicatedSnapshots is set.
+	Deduplicate bool `json:"deduplicate,omitempty"`
+	// Record the host name of the machine in the snapshot, as well as the
+	// machine ID that is always recorded; see HostIdentity.
+	RecordHostname bool `json:"recordHostname,omitempty"`
+	// Once the files are copied, check that the snapshot has all of them
+	// and that their checksums match the working files, and fail creating
+	// it if not; see Snapshot.Verification. This reads every file again,
+	// so it is off by default.
+	VerifyAfterCreate bool `json:"verifyAfterCreate,omitempty"`
+	// Capture the end of the logs of the app, its services and the cluster
+	// in the snapshot, within a limit, for debugging; see
+	// Snapshot.CapturedLogs. Restoring the snapshot doesn't restore them.
+	// Failing to capture the logs is only warned about.
+	CaptureLogs bool `json:"captureLogs,omitempty"`
+}
+
+// PruneBasis is how CreateOptions.PruneOldest measures the age of snapshots.
+type PruneBasis string
+
+const (
+	// Snapshots are as old as when they were created.
+	PruneByCreated PruneBasis = "created"
+	// Snapshots are as old as when they were last restored or touched, or
+	// created if they never were, so that old snapshots in frequent use are
+	// kept.
+	PruneByLastUsed PruneBasis = "lastUsed"
+)
+
+// ErrClusterUnhealthy is returned by CreateWithOptions when
+// CreateOptions.RequireHealthy is set and the cluster is not healthy.
+var ErrClusterUnhealthy = errors.New("the cluster is not healthy")
+
+// ErrSnapshotLimitReached is returned by CreateWithOptions when there are
+// already CreateOptions.MaxSnapshots snapshots and PruneOldest is not set.
+var ErrSnapshotLimitReached = errors.New("snapshot limit reached")
+
+// ErrDiskInUse is returned by CreateWithOptions when the VM is still running
+// once the backend has been stopped, so that its disk could only be captured
+// part way through being written.
+var ErrDiskInUse = errors.New("the VM disk is in use")
+
+// ErrSnapshotProtected is returned by Delete when the snapshot is protected;
+// see Manager.SetProtected.
+var ErrSnapshotProtected = errors.New("snapshot is protected")
+
+// ErrSnapshotChanged is returned by Restore when the snapshot no longer
+// matches RestoreOptions.ExpectedDigest.
+var ErrSnapshotChanged = errors.New("snapshot has changed")
+
+// ErrNameExists is returned when a snapshot name is already in use. Names
+// that differ only in case are considered the same.
+var ErrNameExists = errors.New("already exists")
+
+// ErrManagerClosed is returned when a Manager is used after Close.
+var ErrManagerClosed = errors.New("snapshot manager is closed")
+
+// NotFoundError is returned when there is no complete snapshot with the
+// requested name or ID.
+type NotFoundError struct {
+	// The name that was looked for, if any.
+	Name string
+	// The ID that was looked for, if any.
+	ID string
+}
+
+func (err *NotFoundError) Error() string {
+	if err.ID != "" {
+		return fmt.Sprintf(`can't find snapshot with ID %q`, err.ID)
+	}
+	return fmt.Sprintf(`can't find snapshot %q`, err.Name)
+}
+
+// ManagerConfig sets the naming policy of a Manager, and the migrations it can
+// run when restoring, for embedders that need them; the zero value keeps the
+// default policy, with no migrations.
+type ManagerConfig struct {
+	// NameValidator checks that a name is acceptable for a new snapshot; if
+	// nil, DefaultNameValidator is used. Names are always also checked not
+	// to be in use by an existing snapshot, whatever the validator.
+	NameValidator func(name string) error
+	// NameGenerator, if set, names snapshots created without a name, given
+	// the existing complete snapshots. The generated name is validated like
+	// any other.
+	NameGenerator func(snapshots []Snapshot) (string, error)
+	// MigrationHooks maps component names, such as ComponentKubernetes, to
+	// the hook that migrates restored data when the version of the
+	// component in the snapshot differs from the one in use.
+	MigrationHooks map[string]MigrationHook
+	// ReadOnly marks the snapshots directory as read-only, as for snapshots
+	// on read-only media or a read-only mount. Snapshots can then be listed,
+	// inspected, restored and copied elsewhere, but operations that would
+	// change the directory fail with ErrReadOnly, and the last used time of
+	// restored snapshots is not recorded. A directory on a read-only
+	// filesystem is always treated as read-only.
+	ReadOnly bool
+	// StateDirectory, if set, holds the files operations write besides the
+	// snapshots, such as their logs, instead of the snapshots directory; it
+	// must be writable. Without it, operations on a read-only snapshots
+	// directory are not logged. The backend lock and the restore journal
+	// are kept in the application directory either way.
+	StateDirectory string
+	// IndexFile, if set, is the file List keeps the metadata of all
+	// snapshots in, so that listing reads that file rather than every
+	// snapshot, as matters when the snapshots directory is on slow or remote
+	// storage. It should be on local storage. Managers remove the index
+	// when they change snapshots, so one changing them without the index
+	// configured leaves it stale until RebuildIndex is called.
+	IndexFile string
+}
+
 // Manager handles all snapshot-related functionality.
+// A Manager must not be used after Close has been called on it.
+//
+// If the snapshots directory is a symlink, as when the user moved it
+// elsewhere, Paths.Snapshots holds the directory it points to, resolved
+// once when the manager is created, so that all operations act on the same
+// directory.
 type Manager struct {
 	Snapshotter
 	*paths.Paths
 	lock.BackendLocker
+	config ManagerConfig
+	// Protects locked and closed.
+	mutex sync.Mutex
+	// Whether the backend lock is currently held by this manager.
+	locked bool
+	// Whether Close has been called.
+	closed bool
+	// The snapshots read by List; see ListWithOptions.
+	listCache listCache
+	// If set, called by CreateWithOptions before each of its steps with the
+	// name of the step, which fails with the error it returns; for tests.
+	createStepHook func(step string) error
+	// If set, called by deleteSnapshot once the snapshot is no longer
+	// listed, before the rest of its directory is removed; an error stops
+	// the deletion there, as an interruption would. For tests.
+	deleteInterruptHook func() error
 }
 
+// NewManager returns a Manager with the default naming policy.
 func NewManager() (*Manager, error) {
+	return NewManagerWithConfig(ManagerConfig{})
+}
+
+// NewManagerWithConfig returns a Manager with the given naming policy.
+func NewManagerWithConfig(config ManagerConfig) (*Manager, error) {
 	appPaths, err := paths.GetPaths()
 	if err != nil {
 		return nil, err
 	}
+	manager, err := newManager(appPaths, NewSnapshotterImpl(), &lock.BackendLock{})
+	if err != nil {
+		return nil, err
+	}
+	manager.config = config
+	return manager, nil
+}
+
+func newManager(appPaths *paths.Paths, snapshotter Snapshotter, locker lock.BackendLocker) (*Manager, error) {
+	snapshotsDir, err := resolvePath(appPaths.Snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve snapshots directory %q: %w", appPaths.Snapshots, err)
+	}
+	// Copy the paths so that the caller's are not changed.
+	managerPaths := *appPaths
+	managerPaths.Snapshots = snapshotsDir
 	manager := &Manager{
-		Paths:         appPaths,
-		Snapshotter:   NewSnapshotterImpl(),
-		BackendLocker: &lock.BackendLock{},
+		Paths:         &managerPaths,
+		Snapshotter:   snapshotter,
+		BackendLocker: locker,
 	}
 	return manager, nil
 }
 
+// resolvePath returns the given path with any symlinks in it resolved.  The
+// parts of the path that do not exist yet, including the target of a symlink
+// that does not exist yet, are kept as they are.
+func resolvePath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if target, err := os.Readlink(path); err == nil {
+		// A symlink to a directory that does not exist yet.
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return resolvePath(target)
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolvedParent, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
+
 // Snapshot returns a Snapshot object for an existing and complete snapshot with the given name.
+// Names are matched case-insensitively, unless that matches more than one snapshot
+// (which can only happen for snapshots created by older versions).
 // It will return an error if no snapshot is found, or if the snapshot is not complete.
 func (manager *Manager) Snapshot(name string) (Snapshot, error) {
 	snapshots, err := manager.List(false)
 	if err != nil {
 		return Snapshot{}, fmt.Errorf("failed to list snapshots: %w", err)
 	}
+	var matches []Snapshot
 	for _, candidate := range snapshots {
 		if name == candidate.Name {
 			return candidate, nil
 		}
+		if strings.EqualFold(name, candidate.Name) {
+			matches = append(matches, candidate)
+		}
 	}
-	return Snapshot{}, fmt.Errorf(`can't find snapshot %q`, name)
+	switch len(matches) {
+	case 0:
+		return Snapshot{}, &NotFoundError{Name: name}
+	case 1:
+		return matches[0], nil
+	}
+	return Snapshot{}, fmt.Errorf(`snapshot name %q is ambiguous: there are %d snapshots with names that differ only in case`, name, len(matches))
 }
 
-func (manager *Manager) SnapshotDirectory(snapshot Snapshot) string {
-	return filepath.Join(manager.Snapshots, snapshot.ID)
+// Stat returns the complete snapshot with the given name or ID. Names are
+// matched as for Snapshot; a snapshot is looked up by ID first, so that one
+// can be found without listing all snapshots. A *NotFoundError is returned if
+// there is no such snapshot.
+func (manager *Manager) Stat(nameOrID string) (*Snapshot, error) {
+	if id, err := uuid.Parse(nameOrID); err == nil && id.String() == nameOrID {
+		snapshot, err := readMetadataFile(metadataFilePath(manager.Snapshots, nameOrID))
+		if err == nil && snapshot.ID == nameOrID {
+			if _, err := os.Stat(completeFilePath(manager.Snapshots, nameOrID)); err == nil {
+				return &snapshot, nil
+			}
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	snapshot, err := manager.Snapshot(nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
 }
 
-// ValidateName checks that name is a valid snapshot name and that
-// it is not used by an existing snapshot.
-func (manager *Manager) ValidateName(name string) error {
-	if name == "" {
-		return fmt.Errorf("snapshot name must not be the empty string")
+// Close releases any resources held by the manager, including a backend lock
+// left behind by an operation that did not finish. The backend is not
+// restarted, as its state is unknown. Calling Close more than once is allowed;
+// any other use of the manager after Close is invalid.
+func (manager *Manager) Close() error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if manager.closed {
+		return nil
 	}
-	runeName := []rune(name)
-	if len(runeName) > maxNameLength {
-		errMsgName := truncate(name, nameDisplayCutoffSize)
-		return fmt.Errorf(`invalid name %q: max length is %d, %d were specified`, errMsgName, maxNameLength, len(runeName))
+	manager.closed = true
+	if !manager.locked {
+		return nil
 	}
-	if err := checkForInvalidCharacter(name); err != nil {
+	manager.locked = false
+	if err := manager.Unlock(context.Background(), manager.Paths, false); err != nil {
+		return fmt.Errorf("failed to release backend lock: %w", err)
+	}
+	return nil
+}
+
+// lockBackend acquires the backend lock, recording that it is held so that
+// Close can release it.
+func (manager *Manager) lockBackend(ctx context.Context, action string) error {
+	manager.mutex.Lock()
+	closed := manager.closed
+	manager.mutex.Unlock()
+	if closed {
+		return ErrManagerClosed
+	}
+	// Acquiring the lock may take a while (it stops the backend), so don't
+	// block Close while doing so.
+	if err := manager.Lock(ctx, manager.Paths, action); err != nil {
 		return err
 	}
-	if unicode.IsSpace(rune(name[0])) {
-		errMsgName := truncate(name, nameDisplayCutoffSize)
-		return fmt.Errorf(`invalid name %q: must not start with a white-space character`, errMsgName)
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if manager.closed {
+		return errors.Join(ErrManagerClosed, manager.Unlock(ctx, manager.Paths, false))
 	}
-	if unicode.IsSpace(runeName[len(runeName)-1]) {
-		errMsgName := name
-		if len(runeName) > nameDisplayCutoffSize {
-			errMsgName = "…" + string(runeName[len(runeName)-nameDisplayCutoffSize:])
-		}
-		return fmt.Errorf(`invalid name %q: must not end with a white-space character`, errMsgName)
+	manager.locked = true
+	return nil
+}
+
+// unlockBackend releases the backend lock if it is still held; it may have
+// already been released by Close.
+func (manager *Manager) unlockBackend(ctx context.Context, restart bool) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if !manager.locked {
+		return nil
+	}
+	manager.locked = false
+	return manager.Unlock(ctx, manager.Paths, restart)
+}
+
+func (manager *Manager) SnapshotDirectory(snapshot Snapshot) string {
+	return snapshotDirPath(manager.Snapshots, snapshot.ID)
+}
+
+// snapshotDirPath returns the directory of the snapshot with the given ID, in
+// the given snapshots directory. The paths of a snapshot's directory and of
+// the files that describe it are only built by this and the functions below.
+func snapshotDirPath(snapshotsDir, id string) string {
+	return filepath.Join(snapshotsDir, id)
+}
+
+// metadataFilePath returns the path of the metadata file of the snapshot
+// with the given ID.
+func metadataFilePath(snapshotsDir, id string) string {
+	return filepath.Join(snapshotDirPath(snapshotsDir, id), metadataFileName)
+}
+
+// completeFilePath returns the path of the file marking the snapshot with the
+// given ID as complete.
+func completeFilePath(snapshotsDir, id string) string {
+	return filepath.Join(snapshotDirPath(snapshotsDir, id), completeFileName)
+}
+
+// ValidateName checks that name is a valid snapshot name, according to the
+// manager's NameValidator, and that it is not used by an existing snapshot,
+// ignoring case.
+func (manager *Manager) ValidateName(name string) error {
+	validator := manager.config.NameValidator
+	if validator == nil {
+		validator = DefaultNameValidator
+	}
+	if err := validator(name); err != nil {
+		return err
 	}
 	currentSnapshots, err := manager.List(false)
 	if err != nil {
@@ -93,145 +463,710 @@ func (manager *Manager) ValidateName(name string) error {
 	for _, currentSnapshot := range currentSnapshots {
 		if currentSnapshot.Name == name {
 			errMsgName := truncate(name, nameDisplayCutoffSize)
-			return fmt.Errorf("name %q already exists", errMsgName)
+			return fmt.Errorf("name %q %w", errMsgName, ErrNameExists)
+		}
+		if strings.EqualFold(currentSnapshot.Name, name) {
+			errMsgName := truncate(name, nameDisplayCutoffSize)
+			existingName := truncate(currentSnapshot.Name, nameDisplayCutoffSize)
+			return fmt.Errorf("name %q %w as %q", errMsgName, ErrNameExists, existingName)
 		}
 	}
 	return nil
 }
 
 func (manager *Manager) writeMetadataFile(snapshot Snapshot) error {
+	defer manager.invalidateListCache()
 	snapshotDir := manager.SnapshotDirectory(snapshot)
 	if err := os.MkdirAll(snapshotDir, 0o755); err != nil {
 		return fmt.Errorf("failed to create snapshot directory: %w", err)
 	}
-	metadataPath := filepath.Join(snapshotDir, "metadata.json")
-	metadataFile, err := os.Create(metadataPath)
+	contents, err := json.MarshalIndent(newMetadata(snapshot), "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
+		return fmt.Errorf("failed to write metadata file: %w", err)
 	}
-	defer metadataFile.Close()
-	encoder := json.NewEncoder(metadataFile)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(snapshot); err != nil {
+	// Replace the file rather than writing it in place, so that updating the
+	// metadata of an existing snapshot never leaves it unreadable.
+	contents = append(contents, '\n')
+	if err := replaceFile(metadataFilePath(manager.Snapshots, snapshot.ID), contents, 0o644); err != nil {
 		return fmt.Errorf("failed to write metadata file: %w", err)
 	}
 	return nil
 }
 
-// Create a new snapshot.
+// Create a new snapshot. If name is empty and the manager has a
+// NameGenerator, the snapshot is given a generated name.
 func (manager *Manager) Create(ctx context.Context, name, description string) (Snapshot, error) {
+	return manager.CreateWithOptions(ctx, name, CreateOptions{Description: description})
+}
+
+// CreateWithOptions creates a new snapshot like Create, with the given options.
+func (manager *Manager) CreateWithOptions(ctx context.Context, name string, opts CreateOptions) (snapshot Snapshot, err error) {
+	// A snapshot of partially restored files would not be usable.
+	if journal, err := manager.readRestoreJournal(); err != nil {
+		return Snapshot{}, err
+	} else if journal != nil {
+		return Snapshot{}, fmt.Errorf("%w: resume the restore of snapshot %q before creating a snapshot",
+			ErrRestoreIncomplete, journal.SnapshotName)
+	}
+	if opts.Deduplicate && !deduplicatedSnapshots {
+		return Snapshot{}, errors.New("deduplicated snapshots are not supported on this platform")
+	}
+	if err := manager.checkWritable(); err != nil {
+		return Snapshot{}, err
+	}
+	if err := manager.checkNotFrozen(); err != nil {
+		return Snapshot{}, err
+	}
+	if name == "" && manager.config.NameGenerator != nil {
+		snapshots, err := manager.List(false)
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		if name, err = manager.config.NameGenerator(snapshots); err != nil {
+			return Snapshot{}, fmt.Errorf("failed to generate snapshot name: %w", err)
+		}
+	}
 	id, err := uuid.NewRandom()
 	if err != nil {
 		return Snapshot{}, fmt.Errorf("failed to generate ID for snapshot: %w", err)
 	}
-	snapshot := Snapshot{
-		Created:     time.Now(),
-		Name:        name,
-		ID:          id.String(),
-		Description: description,
+	snapshot = Snapshot{
+		Created:           time.Now(),
+		Name:              name,
+		ID:                id.String(),
+		Description:       opts.Description,
+		SettingsVersion:   readSettingsVersion(filepath.Join(manager.Config, "settings.json")),
+		ComponentVersions: readComponentVersions(filepath.Join(manager.Config, "settings.json")),
+	}
+	oplog := manager.startOperationLog(snapshot, "create")
+	defer func() {
+		oplog.finish(err)
+	}()
+	// The snapshot is no less usable without the host identity.
+	if snapshot.Host, err = manager.hostIdentity(opts.RecordHostname); err != nil {
+		logrus.Warnf("not recording the host in the snapshot: %s", err)
+		oplog.Warnf("not recording the host in the snapshot: %s", err)
+		err = nil
+	}
+	if opts.CheckCluster || opts.RequireHealthy {
+		oplog.Info("checking the health of the cluster")
+		if snapshot.ClusterHealth, err = checkClusterHealth(ctx, opts.RequireHealthy, oplog); err != nil {
+			return snapshot, err
+		}
+	}
+	// Check the limit before stopping the backend, so that a snapshot that
+	// can't be created doesn't interrupt it; it is checked again below.
+	if _, err := manager.snapshotsToPrune(opts); err != nil {
+		return snapshot, err
 	}
 	action := fmt.Sprintf("Creating snapshot %q", name)
-	if err := manager.Lock(ctx, manager.Paths, action); err != nil {
+	oplog.Info("stopping the backend")
+	if err := manager.lockBackend(ctx, action); err != nil {
 		return snapshot, err
 	}
+	// Each step below pushes the action undoing it; if creating the snapshot
+	// fails or is cancelled, they are run before the backend is restarted.
+	var cleanups cleanupStack
 	defer func() {
+		// The snapshot is complete, or removed.
+		manager.invalidateListCache()
 		if err != nil {
-			os.RemoveAll(manager.SnapshotDirectory(snapshot))
+			// The errors have been logged, and don't change why creating
+			// the snapshot failed.
+			_ = cleanups.run(oplog)
 		}
-		unlockErr := manager.Unlock(ctx, manager.Paths, true)
+		oplog.Info("restarting the backend")
+		unlockErr := manager.unlockBackend(ctx, true)
 		if err == nil {
 			err = unlockErr
 		}
 	}()
+	startStep := func(step string) error {
+		if manager.createStepHook != nil {
+			if err := manager.createStepHook(step); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+	if err := startStep("validate"); err != nil {
+		return snapshot, err
+	}
 	// (Re)validate the name after acquiring the lock in case another process created a snapshot with the same name
 	if err := manager.ValidateName(name); err != nil {
 		return snapshot, err
 	}
-	if err = manager.writeMetadataFile(snapshot); err == nil {
-		err = manager.CreateFiles(ctx, manager.Paths, manager.SnapshotDirectory(snapshot))
+	prune, err := manager.snapshotsToPrune(opts)
+	if err != nil {
+		return snapshot, err
 	}
-	return snapshot, err
-}
-
-// List snapshots that are present on the system. If includeIncomplete is
-// true, includes snapshots that are currently being created, are currently
-// being deleted, or are otherwise incomplete and cannot be restored from.
-func (manager *Manager) List(includeIncomplete bool) ([]Snapshot, error) {
-	dirEntries, err := os.ReadDir(manager.Snapshots)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return []Snapshot{}, fmt.Errorf("failed to read snapshots directory: %w", err)
+	// Stopping the backend doesn't stop a VM that Rancher Desktop lost track
+	// of; copying its disk would capture a torn image.
+	if holder, err := diskInUse(manager.Paths); err != nil {
+		return snapshot, fmt.Errorf("failed to check whether the VM disk is in use: %w", err)
+	} else if holder != "" {
+		return snapshot, fmt.Errorf("%w by %s; stop the VM and try again", ErrDiskInUse, holder)
 	}
-	snapshots := make([]Snapshot, 0, len(dirEntries))
-	for _, dirEntry := range dirEntries {
-		if _, err := uuid.Parse(dirEntry.Name()); err != nil {
-			continue
+	snapshotDir := manager.SnapshotDirectory(snapshot)
+	if err := startStep("metadata"); err != nil {
+		return snapshot, err
+	}
+	cleanups.push("removing incomplete snapshot directory", func() error {
+		return os.RemoveAll(snapshotDir)
+	})
+	if opts.CaptureLogs {
+		if err := startStep("logs"); err != nil {
+			return snapshot, err
 		}
-		snapshot := Snapshot{}
-		metadataPath := filepath.Join(manager.Snapshots, dirEntry.Name(), "metadata.json")
-		contents, err := os.ReadFile(metadataPath)
+		oplog.Info("capturing logs")
+		// The metadata records what was captured, so the logs come first.
+		if snapshot.CapturedLogs, err = captureLogs(manager.Logs, snapshotDir); err != nil {
+			logrus.Warnf("not capturing logs in the snapshot: %s", err)
+			oplog.Warnf("not capturing logs in the snapshot: %s", err)
+			snapshot.CapturedLogs = nil
+			err = os.Remove(capturedLogsPath(snapshotDir))
+			if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			} else if err != nil {
+				return snapshot, fmt.Errorf("failed to remove partially captured logs: %w", err)
+			}
+		}
+	}
+	oplog.Info("writing metadata")
+	snapshot.Digest = newMetadata(snapshot).digest()
+	if err := manager.writeMetadataFile(snapshot); err != nil {
+		return snapshot, err
+	}
+	if err := startStep("files"); err != nil {
+		return snapshot, err
+	}
+	if opts.Deduplicate {
+		// Objects already in the store may be shared with other snapshots,
+		// so only those stored for this one are removed.
+		existing, existed, err := listObjects(snapshotDir)
 		if err != nil {
-			return []Snapshot{}, fmt.Errorf("failed to read %q: %w", metadataPath, err)
+			return snapshot, err
 		}
-		if err := json.Unmarshal(contents, &snapshot); err != nil {
-			return []Snapshot{}, fmt.Errorf("failed to unmarshal contents of %q: %w", metadataPath, err)
+		cleanups.push("removing objects stored for the incomplete snapshot", func() error {
+			return manager.removeNewObjects(snapshotDir, existing, existed)
+		})
+	}
+	oplog.Info("copying files")
+	if err := manager.CreateFiles(ctx, manager.Paths, snapshotDir, opts); err != nil {
+		return snapshot, err
+	}
+	if opts.VerifyAfterCreate {
+		if err := startStep("verify"); err != nil {
+			return snapshot, err
 		}
-		// TODO this should be done by the caller
-		snapshot.Created = snapshot.Created.Local()
-
-		completeFilePath := filepath.Join(manager.Snapshots, snapshot.ID, completeFileName)
-		_, err = os.Stat(completeFilePath)
-		completeFileExists := err == nil
+		oplog.Info("verifying the snapshot")
+		verification, err := manager.verifySnapshot(ctx, snapshotDir)
+		if err != nil {
+			return snapshot, err
+		}
+		oplog.Infof("verified %d files, %d of them by checksum", verification.Files, verification.Checksummed)
+		snapshot.Verification = &verification
+	}
+	for _, oldSnapshot := range prune {
+		oplog.Infof("pruning snapshot %q (%s) to stay within the limit of %d snapshots", oldSnapshot.Name, oldSnapshot.ID, opts.MaxSnapshots)
+		// The new snapshot is complete, so failing to prune doesn't fail
+		// creating it.
+		if pruneErr := manager.deleteSnapshot(oldSnapshot); pruneErr != nil {
+			logrus.Warnf("failed to prune snapshot %q: %s", oldSnapshot.Name, pruneErr)
+			oplog.Warnf("failed to prune snapshot %q: %s", oldSnapshot.Name, pruneErr)
+		}
+	}
+	return snapshot, nil
+}
 
-		if !includeIncomplete && !completeFileExists {
-			continue
+// snapshotsToPrune checks CreateOptions.MaxSnapshots before creating a
+// snapshot, and returns the oldest snapshots to delete to stay within it, by
+// CreateOptions.PruneBy.
+func (manager *Manager) snapshotsToPrune(opts CreateOptions) ([]Snapshot, error) {
+	var age func(Snapshot) time.Time
+	switch opts.PruneBy {
+	case "", PruneByCreated:
+		age = func(snapshot Snapshot) time.Time { return snapshot.Created }
+	case PruneByLastUsed:
+		age = func(snapshot Snapshot) time.Time {
+			if snapshot.LastUsed.IsZero() {
+				return snapshot.Created
+			}
+			return snapshot.LastUsed
 		}
+	default:
+		return nil, fmt.Errorf("invalid prune basis %q: must be %q or %q", opts.PruneBy, PruneByCreated, PruneByLastUsed)
+	}
+	if opts.MaxSnapshots <= 0 {
+		return nil, nil
+	}
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	excess := len(snapshots) - opts.MaxSnapshots + 1
+	if excess <= 0 {
+		return nil, nil
+	}
+	if !opts.PruneOldest {
+		return nil, fmt.Errorf("%w: there are already %d snapshots, and at most %d are allowed; delete some snapshots, or prune the oldest, to make room",
+			ErrSnapshotLimitReached, len(snapshots), opts.MaxSnapshots)
+	}
+	// Protected snapshots count towards the limit, but are never pruned.
+	prunable := slices.DeleteFunc(slices.Clone(snapshots), func(snapshot Snapshot) bool {
+		return snapshot.Protected
+	})
+	if len(prunable) < excess {
+		return nil, fmt.Errorf("%w: there are already %d snapshots, and at most %d are allowed, but only %d of them are not protected; unprotect or delete some snapshots to make room",
+			ErrSnapshotLimitReached, len(snapshots), opts.MaxSnapshots, len(prunable))
+	}
+	slices.SortFunc(prunable, func(a, b Snapshot) int {
+		return age(a).Compare(age(b))
+	})
+	return prunable[:excess], nil
+}
 
-		snapshots = append(snapshots, snapshot)
+// List snapshots that are present on the system. If includeIncomplete is
+// true, includes snapshots that are currently being created, are currently
+// being deleted, or are otherwise incomplete and cannot be restored from.
+// Snapshots whose metadata hasn't changed since they were last listed are not
+// read again; see ListWithOptions.
+func (manager *Manager) List(includeIncomplete bool) ([]Snapshot, error) {
+	return manager.ListWithOptions(ListOptions{IncludeIncomplete: includeIncomplete})
+}
+
+// ListByPrefix returns the complete snapshots whose names start with the given
+// prefix, matched case-insensitively like names are.  An empty prefix matches
+// all snapshots.
+func (manager *Manager) ListByPrefix(prefix string) ([]Snapshot, error) {
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return nil, err
 	}
-	return snapshots, nil
+	prefix = strings.ToLower(prefix)
+	matches := make([]Snapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(strings.ToLower(snapshot.Name), prefix) {
+			matches = append(matches, snapshot)
+		}
+	}
+	return matches, nil
 }
 
-// Delete a snapshot.
+// Delete a snapshot. Protected snapshots are not deleted; ErrSnapshotProtected
+// is returned instead.
 func (manager *Manager) Delete(name string) error {
+	if err := manager.checkWritable(); err != nil {
+		return err
+	}
+	if err := manager.checkNotFrozen(); err != nil {
+		return err
+	}
 	snapshot, err := manager.Snapshot(name)
 	if err != nil {
 		return err
 	}
+	if snapshot.Protected {
+		return fmt.Errorf("%w: unprotect snapshot %q to delete it", ErrSnapshotProtected, snapshot.Name)
+	}
+	return manager.deleteSnapshot(snapshot)
+}
+
+// DeleteSnapshots deletes the given snapshots, as returned by List or
+// ListByPrefix, skipping those that are protected.  It attempts to delete all
+// of them even if some fail.
+func (manager *Manager) DeleteSnapshots(snapshots []Snapshot) error {
+	if err := manager.checkWritable(); err != nil {
+		return err
+	}
+	if err := manager.checkNotFrozen(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, snapshot := range snapshots {
+		if snapshot.Protected {
+			logrus.Infof("not deleting protected snapshot %q", snapshot.Name)
+			continue
+		}
+		if err := manager.deleteSnapshot(snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete snapshot %q: %w", snapshot.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (manager *Manager) deleteSnapshot(snapshot Snapshot) error {
+	defer manager.invalidateListCache()
 	snapshotDir := manager.SnapshotDirectory(snapshot)
 	// Remove complete.txt file. This must be done first because restoring
-	// from a partially-deleted snapshot could result in errors.
-	err = os.RemoveAll(filepath.Join(snapshotDir, completeFileName))
-	return errors.Join(err, os.RemoveAll(snapshotDir))
+	// from a partially-deleted snapshot could result in errors. The metadata
+	// goes next, and only then the rest: an interrupted deletion leaves a
+	// directory with neither, which List ignores and fsck deletes, rather
+	// than a snapshot that looks broken, or one that fsck would recover.
+	for _, path := range []string{completeFilePath(manager.Snapshots, snapshot.ID), metadataFilePath(manager.Snapshots, snapshot.ID)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := syncDir(snapshotDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if manager.deleteInterruptHook != nil {
+		if err := manager.deleteInterruptHook(); err != nil {
+			return err
+		}
+	}
+	if err := errors.Join(os.RemoveAll(snapshotDir), manager.removeOperationLogs(snapshot)); err != nil {
+		return err
+	}
+	// The snapshot is gone, so failing to remove the objects only it used
+	// doesn't fail deleting it; a later deletion removes them.
+	if err := manager.collectObjects(); err != nil {
+		logrus.Warnf("failed to remove unused snapshot objects: %s", err)
+	}
+	return nil
 }
 
 // Restore Rancher Desktop to the state saved in a snapshot.
-func (manager *Manager) Restore(ctx context.Context, name string) (err error) {
+func (manager *Manager) Restore(ctx context.Context, name string, opts RestoreOptions) error {
+	_, err := manager.RestoreWithResult(ctx, name, opts)
+	return err
+}
+
+// RestoreWithResult is like Restore, and also returns which components could
+// be restored into the running app, and which were restored with the backend
+// stopped; see RestoreOptions.Live.
+func (manager *Manager) RestoreWithResult(ctx context.Context, name string, opts RestoreOptions) (result RestoreResult, err error) {
+	components, err := selectRestoreComponents(opts.Components)
+	if err != nil {
+		return result, err
+	}
+	if opts.MinimalChanges && !minimalRestore {
+		return result, errors.New("restoring only the files that changed is not supported on this platform")
+	}
+	if err := manager.checkNotFrozen(); err != nil {
+		return result, err
+	}
 	snapshot, err := manager.Snapshot(name)
 	if err != nil {
-		return err
+		return result, err
 	}
-
-	action := fmt.Sprintf("Restoring snapshot %q", name)
-	if err := manager.Lock(ctx, manager.Paths, action); err != nil {
-		return err
+	if err := checkDigest(snapshot, opts.ExpectedDigest); err != nil {
+		return result, err
 	}
+	oplog := manager.startOperationLog(snapshot, "restore")
 	defer func() {
-		// Restart the backend only if a data reset occurred
-		unlockErr := manager.Unlock(ctx, manager.Paths, !errors.Is(err, ErrDataReset))
-		if err == nil {
-			err = unlockErr
-		}
+		oplog.finish(err)
 	}()
+	if err := manager.checkSettingsVersion(snapshot, opts.Force, oplog); err != nil {
+		return result, err
+	}
+	// The versions in use must be read before the files are restored. The
+	// migrations are of the data, so there are none without it.
+	var migrations []ComponentMigration
+	if slices.Contains(components, dataComponent) {
+		migrations = manager.componentMigrations(snapshot)
+		manager.warnUnhandledMigrations(snapshot, migrations, oplog)
+	}
+	journal, err := manager.prepareRestoreJournal(snapshot, opts)
+	if err != nil {
+		return result, err
+	}
+	if opts.Resume {
+		oplog.Infof("resuming the restore started at %s, with %d files already restored",
+			journal.Started.Format(time.RFC3339), len(journal.Restored))
+	}
+	if len(opts.Components) > 0 {
+		oplog.Infof("restoring only %s", strings.Join(components, ", "))
+	}
+	if opts.MinimalChanges {
+		oplog.Info("restoring only the files that differ from the snapshot")
+	}
+
+	live := false
+	if opts.Live {
+		result.RestartRequired = slices.DeleteFunc(slices.Clone(components), func(component string) bool {
+			return slices.Contains(reloadableComponents, component)
+		})
+		if len(result.RestartRequired) == 0 {
+			live = true
+			result.RestartRequired = nil
+		} else {
+			oplog.Infof("the backend must be stopped to restore %s", strings.Join(result.RestartRequired, ", "))
+		}
+	}
+	if live {
+		oplog.Info("restoring into the running app")
+	} else {
+		action := fmt.Sprintf("Restoring snapshot %q", name)
+		oplog.Info("stopping the backend")
+		if err := manager.lockBackend(ctx, action); err != nil {
+			return result, err
+		}
+		defer func() {
+			// Restart the backend only if a data reset occurred
+			if errors.Is(err, ErrDataReset) {
+				oplog.Warn("data was reset; not restarting the backend")
+			} else {
+				oplog.Info("restarting the backend")
+			}
+			unlockErr := manager.unlockBackend(ctx, !errors.Is(err, ErrDataReset))
+			if err == nil {
+				err = unlockErr
+			}
+			if err == nil {
+				result.Restarted = components
+			}
+		}()
+	}
+	if opts.ExpectedDigest != "" {
+		// Check again now that no other snapshot operation can run, in
+		// case the snapshot changed while the backend was stopping.
+		current, err := readMetadataFile(metadataFilePath(manager.Snapshots, snapshot.ID))
+		if err != nil {
+			return result, fmt.Errorf("%w: %w", ErrSnapshotChanged, err)
+		}
+		if err := checkDigest(current, opts.ExpectedDigest); err != nil {
+			return result, err
+		}
+	}
 	// If the context is marked done (i.e. the user cancelled the
 	// operation) we can avoid running RestoreFiles() and thus avoid
 	// an unnecessary data reset.
 	if contextIsDone(ctx) {
-		return runner.ErrContextDone
+		return result, runner.ErrContextDone
+	}
+	oplog.Info("restoring files")
+	if err = manager.RestoreFiles(ctx, manager.Paths, manager.SnapshotDirectory(snapshot), opts, journal); err != nil {
+		return result, fmt.Errorf("failed to restore files: %w", err)
+	}
+	if journal != nil {
+		if opts.MinimalChanges {
+			// The journal lists the files restored, and only them, as
+			// the unchanged files are skipped.
+			result.Reverted = slices.Sorted(maps.Keys(journal.Restored))
+			oplog.Infof("reverted %d files", len(result.Reverted))
+		}
+		if err := journal.remove(); err != nil {
+			return result, err
+		}
+	}
+	if err := manager.runComponentMigrations(ctx, migrations, oplog); err != nil {
+		return result, err
+	}
+	if live {
+		oplog.Infof("reloading %s", strings.Join(components, ", "))
+		if err := reloadSettings(ctx, filepath.Join(manager.Config, "settings.json")); err != nil {
+			return result, fmt.Errorf("restored %s, but the app failed to reload them (restart Rancher Desktop to apply them): %w",
+				strings.Join(components, ", "), err)
+		}
+		result.Reloaded = components
+	}
+	// Failing to record the time doesn't make the restore any less complete.
+	if manager.readOnly() {
+		oplog.Info("not updating the last used time, as the snapshots directory is read-only")
+	} else if _, err := manager.touch(snapshot); err != nil {
+		logrus.Warnf("failed to update last used time of snapshot %q: %s", snapshot.Name, err)
+		oplog.Warnf("failed to update last used time: %s", err)
+	}
+
+	return result, nil
+}
+
+// selectRestoreComponents returns the components to restore, in the order
+// they are restored, given those asked for; all of them if none are.
+func selectRestoreComponents(selected []string) ([]string, error) {
+	all := RestorableComponents()
+	for _, component := range selected {
+		if component == componentLogs {
+			return nil, fmt.Errorf("the %s component is never restored; read it with `rdctl snapshot logs --captured`", componentLogs)
+		}
+		if !slices.Contains(all, component) {
+			return nil, fmt.Errorf("unknown component %q; the components are %s", component, strings.Join(all, ", "))
+		}
 	}
-	if err = manager.RestoreFiles(ctx, manager.Paths, manager.SnapshotDirectory(snapshot)); err != nil {
-		return fmt.Errorf("failed to restore files: %w", err)
+	if len(selected) == 0 {
+		return all, nil
 	}
+	return slices.DeleteFunc(all, func(component string) bool {
+		return !slices.Contains(selected, component)
+	}), nil
+}
+
+// ErrNoSnapshots is returned by Latest and RestoreLatest when there are no
+// complete snapshots.
+var ErrNoSnapshots = errors.New("there are no snapshots")
+
+// Latest returns the most recently created complete snapshot. If several
+// snapshots were created at exactly the same time, which one is the latest
+// is ambiguous and an error is returned.
+func (manager *Manager) Latest() (Snapshot, error) {
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if len(snapshots) == 0 {
+		return Snapshot{}, ErrNoSnapshots
+	}
+	latest := slices.MaxFunc(snapshots, func(a, b Snapshot) int {
+		return a.Created.Compare(b.Created)
+	})
+	for _, candidate := range snapshots {
+		if candidate.ID != latest.ID && candidate.Created.Equal(latest.Created) {
+			return Snapshot{}, fmt.Errorf("the latest snapshot is ambiguous: snapshots %q and %q were both created at %s",
+				latest.Name, candidate.Name, latest.getTimeString())
+		}
+	}
+	return latest, nil
+}
+
+// RestoreLatest restores the most recently created snapshot, with the same
+// checks as RestoreWithResult, and returns it along with the result. Unless
+// RestoreOptions.ExpectedDigest is set, the snapshot restored is the one found
+// to be the latest, even if it is replaced in the meantime.
+func (manager *Manager) RestoreLatest(ctx context.Context, opts RestoreOptions) (Snapshot, RestoreResult, error) {
+	snapshot, err := manager.Latest()
+	if err != nil {
+		return Snapshot{}, RestoreResult{}, err
+	}
+	if opts.ExpectedDigest == "" {
+		opts.ExpectedDigest = snapshot.Digest
+	}
+	result, err := manager.RestoreWithResult(ctx, snapshot.Name, opts)
+	return snapshot, result, err
+}
+
+// checkDigest returns ErrSnapshotChanged if an expected digest is given and
+// the snapshot doesn't match it.
+func checkDigest(snapshot Snapshot, expected string) error {
+	if expected != "" && snapshot.Digest != expected {
+		return fmt.Errorf("%w since it was listed: snapshot %q has digest %s, not %s",
+			ErrSnapshotChanged, snapshot.Name, snapshot.Digest, expected)
+	}
+	return nil
+}
+
+// Touch sets the last used time of the snapshot with the given ID to now, as
+// if it had just been restored.
+func (manager *Manager) Touch(id string) (Snapshot, error) {
+	if err := manager.checkWritable(); err != nil {
+		return Snapshot{}, err
+	}
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, candidate := range snapshots {
+		if candidate.ID == id {
+			return manager.touch(candidate)
+		}
+	}
+	return Snapshot{}, &NotFoundError{ID: id}
+}
 
+// SetProtected protects the snapshot with the given ID from being deleted, or
+// removes that protection. Protected snapshots are refused by Delete, skipped
+// by DeleteSnapshots and never pruned by CreateWithOptions.
+func (manager *Manager) SetProtected(id string, protected bool) (Snapshot, error) {
+	if err := manager.checkWritable(); err != nil {
+		return Snapshot{}, err
+	}
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, candidate := range snapshots {
+		if candidate.ID == id {
+			candidate.Protected = protected
+			if err := manager.writeMetadataFile(candidate); err != nil {
+				return Snapshot{}, err
+			}
+			return candidate, nil
+		}
+	}
+	return Snapshot{}, &NotFoundError{ID: id}
+}
+
+func (manager *Manager) touch(snapshot Snapshot) (Snapshot, error) {
+	snapshot.LastUsed = time.Now()
+	if err := manager.writeMetadataFile(snapshot); err != nil {
+		return Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// checkSettingsVersion returns ErrSettingsVersionMismatch if the snapshot was
+// created with a settings version that is newer than the current one, or that
+// is more than maxSettingsVersionGap versions older. If force is set, a
+// warning is logged instead. Snapshots with an unknown version are allowed.
+func (manager *Manager) checkSettingsVersion(snapshot Snapshot, force bool, oplog *operationLog) error {
+	version := snapshot.SettingsVersion
+	if version == 0 {
+		// Older snapshots don't record the version in their metadata.
+		version = readSettingsVersion(filepath.Join(manager.SnapshotDirectory(snapshot), "settings.json"))
+	}
+	if version == 0 {
+		return nil
+	}
+	var err error
+	if version > currentSettingsVersion {
+		err = fmt.Errorf("%w: snapshot %q was created by a newer version of Rancher Desktop (settings version %d, current version %d)",
+			ErrSettingsVersionMismatch, snapshot.Name, version, currentSettingsVersion)
+	} else if currentSettingsVersion-version > maxSettingsVersionGap {
+		err = fmt.Errorf("%w: snapshot %q was created by a much older version of Rancher Desktop (settings version %d, current version %d); "+
+			"the restored data may need to be migrated before Rancher Desktop can start",
+			ErrSettingsVersionMismatch, snapshot.Name, version, currentSettingsVersion)
+	}
+	if err != nil && force {
+		logrus.Warnf("restoring anyway: %s", err)
+		oplog.Warnf("restoring anyway: %s", err)
+		return nil
+	}
+	return err
+}
+
+// readSettingsVersion returns the version field of the given settings.json,
+// or zero if it can't be determined.
+func readSettingsVersion(settingsPath string) int {
+	contents, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return 0
+	}
+	var settings struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(contents, &settings); err != nil {
+		return 0
+	}
+	return settings.Version
+}
+
+// DefaultNameValidator is the default NameValidator: names must be non-empty,
+// at most 250 characters long, printable, and must not start or end with
+// white space.
+func DefaultNameValidator(name string) error {
+	if name == "" {
+		return fmt.Errorf("snapshot name must not be the empty string")
+	}
+	runeName := []rune(name)
+	if len(runeName) > maxNameLength {
+		errMsgName := truncate(name, nameDisplayCutoffSize)
+		return fmt.Errorf(`invalid name %q: max length is %d, %d were specified`, errMsgName, maxNameLength, len(runeName))
+	}
+	if err := checkForInvalidCharacter(name); err != nil {
+		return err
+	}
+	if unicode.IsSpace(rune(name[0])) {
+		errMsgName := truncate(name, nameDisplayCutoffSize)
+		return fmt.Errorf(`invalid name %q: must not start with a white-space character`, errMsgName)
+	}
+	if unicode.IsSpace(runeName[len(runeName)-1]) {
+		errMsgName := name
+		if len(runeName) > nameDisplayCutoffSize {
+			errMsgName = "…" + string(runeName[len(runeName)-nameDisplayCutoffSize:])
+		}
+		return fmt.Errorf(`invalid name %q: must not end with a white-space character`, errMsgName)
+	}
 	return nil
 }
 
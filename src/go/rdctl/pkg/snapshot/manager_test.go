@@ -1,15 +1,24 @@
 package snapshot
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/runner"
 )
 
@@ -18,6 +27,59 @@ type TestFile struct {
 	Contents string
 }
 
+// recordingBackendLock is a lock.BackendLocker that keeps track of whether
+// it is currently locked.
+type recordingBackendLock struct {
+	locked  bool
+	unlocks int
+}
+
+func (lock *recordingBackendLock) Lock(ctx context.Context, appPaths *paths.Paths, action string) error {
+	if lock.locked {
+		return errors.New("already locked")
+	}
+	lock.locked = true
+	return nil
+}
+
+func (lock *recordingBackendLock) Unlock(ctx context.Context, appPaths *paths.Paths, restart bool) error {
+	lock.locked = false
+	lock.unlocks++
+	return nil
+}
+
+// failingSnapshotter is a Snapshotter that fails to create files.
+type failingSnapshotter struct {
+	Snapshotter
+}
+
+func (snapshotter failingSnapshotter) CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, opts CreateOptions) error {
+	return errors.New("injected failure")
+}
+
+// hookedSnapshotter is a Snapshotter that calls hook after creating the
+// files, and fails with the error it returns.
+type hookedSnapshotter struct {
+	Snapshotter
+	hook func() error
+}
+
+func (snapshotter hookedSnapshotter) CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, opts CreateOptions) error {
+	if err := snapshotter.Snapshotter.CreateFiles(ctx, appPaths, snapshotDir, opts); err != nil {
+		return err
+	}
+	return snapshotter.hook()
+}
+
+func readLog(t *testing.T, logPath string) string {
+	t.Helper()
+	contents, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read log %q: %s", logPath, err)
+	}
+	return string(contents)
+}
+
 func TestManager(t *testing.T) {
 	t.Run("ValidateName should disallow two snapshots with the same name, but only when the first is complete", func(t *testing.T) {
 		paths, _ := populateFiles(t, true)
@@ -42,6 +104,134 @@ func TestManager(t *testing.T) {
 		}
 	})
 
+	t.Run("ValidateName and Create should reject names that differ only in case", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if _, err := manager.Create(context.Background(), "MySnapshot", ""); err != nil {
+			t.Fatalf("failed to create first snapshot: %s", err)
+		}
+		if err := manager.ValidateName("MySnapshot"); !errors.Is(err, ErrNameExists) {
+			t.Errorf("unexpected error validating identical name: %v", err)
+		} else if err.Error() != `name "MySnapshot" already exists` {
+			t.Errorf("unexpected error message %q", err)
+		}
+		err := manager.ValidateName("mysnapshot")
+		if !errors.Is(err, ErrNameExists) {
+			t.Fatalf("unexpected error validating name differing in case: %v", err)
+		}
+		if !strings.Contains(err.Error(), `"MySnapshot"`) {
+			t.Errorf("error %q does not mention the existing name", err)
+		}
+		if _, err := manager.Create(context.Background(), "MYSNAPSHOT", ""); !errors.Is(err, ErrNameExists) {
+			t.Errorf("unexpected error creating name differing in case: %v", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 1 {
+			t.Errorf("expected 1 snapshot, got %d", len(snapshots))
+		}
+	})
+
+	t.Run("Snapshot should resolve names ignoring case", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		created, err := manager.Create(context.Background(), "MySnapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for _, name := range []string{"MySnapshot", "mysnapshot", "MYSNAPSHOT"} {
+			snapshot, err := manager.Snapshot(name)
+			if err != nil {
+				t.Errorf("failed to resolve %q: %s", name, err)
+				continue
+			}
+			if snapshot.ID != created.ID {
+				t.Errorf("resolved %q to snapshot %q, expected %q", name, snapshot.ID, created.ID)
+			}
+			if snapshot.Name != "MySnapshot" {
+				t.Errorf("resolved %q to name %q, expected original casing", name, snapshot.Name)
+			}
+		}
+		if _, err := manager.Snapshot("other"); err == nil {
+			t.Errorf("expected error resolving nonexistent snapshot")
+		}
+	})
+
+	t.Run("Snapshot should prefer an exact match over names differing in case", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		first, err := manager.Create(context.Background(), "snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		// Older versions allowed names differing only in case; write one directly.
+		second := first
+		second.ID = uuid.NewString()
+		second.Name = "Snapshot"
+		if err := os.MkdirAll(manager.SnapshotDirectory(second), 0o755); err != nil {
+			t.Fatalf("failed to create snapshot directory: %s", err)
+		}
+		if err := manager.writeMetadataFile(second); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(manager.SnapshotDirectory(second), completeFileName), []byte(completeFileContents), 0o644); err != nil {
+			t.Fatalf("failed to write complete file: %s", err)
+		}
+		for _, expected := range []Snapshot{first, second} {
+			snapshot, err := manager.Snapshot(expected.Name)
+			if err != nil {
+				t.Fatalf("failed to resolve %q: %s", expected.Name, err)
+			}
+			if snapshot.ID != expected.ID {
+				t.Errorf("resolved %q to snapshot %q, expected %q", expected.Name, snapshot.ID, expected.ID)
+			}
+		}
+		if _, err := manager.Snapshot("SNAPSHOT"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+			t.Errorf("unexpected error resolving ambiguous name: %v", err)
+		}
+	})
+
+	t.Run("Stat should find a snapshot by name or ID", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		created, err := manager.Create(context.Background(), "snapshot", "description")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for _, nameOrID := range []string{"snapshot", "SNAPSHOT", created.ID} {
+			snapshot, err := manager.Stat(nameOrID)
+			if err != nil {
+				t.Fatalf("failed to stat %q: %s", nameOrID, err)
+			}
+			if snapshot.ID != created.ID || snapshot.Name != created.Name || snapshot.Digest != created.Digest {
+				t.Errorf("stat of %q returned %+v, expected %+v", nameOrID, *snapshot, created)
+			}
+		}
+	})
+
+	t.Run("Stat should return a NotFoundError for unknown snapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		created, err := manager.Create(context.Background(), "snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := os.Remove(completeFilePath(manager.Snapshots, created.ID)); err != nil {
+			t.Fatalf("failed to remove %q: %s", completeFileName, err)
+		}
+		for _, nameOrID := range []string{"other", uuid.NewString(), created.ID, "snapshot"} {
+			_, err := manager.Stat(nameOrID)
+			var notFound *NotFoundError
+			if !errors.As(err, &notFound) {
+				t.Errorf("expected a NotFoundError for %q, got %v", nameOrID, err)
+			} else if err.Error() != fmt.Sprintf("can't find snapshot %q", nameOrID) {
+				t.Errorf("unexpected error for %q: %s", nameOrID, err)
+			}
+		}
+	})
+
 	testCases := []struct {
 		Name          string
 		ExpectedError string
@@ -149,6 +339,72 @@ func TestManager(t *testing.T) {
 		}
 	})
 
+	t.Run("ValidateName should use a custom NameValidator", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		errNoPrefix := errors.New("names must start with team-")
+		manager.config.NameValidator = func(name string) error {
+			if !strings.HasPrefix(name, "team-") {
+				return errNoPrefix
+			}
+			return nil
+		}
+		if err := manager.ValidateName("my snapshot"); !errors.Is(err, errNoPrefix) {
+			t.Errorf("expected the validator's error, got %v", err)
+		}
+		if _, err := manager.Create(context.Background(), "my snapshot", ""); !errors.Is(err, errNoPrefix) {
+			t.Errorf("expected Create to use the validator, got %v", err)
+		}
+		// The validator replaces the default checks, but not the check
+		// for names in use.
+		if err := manager.ValidateName("team- "); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if _, err := manager.Create(context.Background(), "team-a", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.ValidateName("team-A"); !errors.Is(err, ErrNameExists) {
+			t.Errorf("expected ErrNameExists, got %v", err)
+		}
+	})
+	t.Run("Create should use a NameGenerator for snapshots without a name", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if _, err := manager.Create(context.Background(), "", ""); err == nil {
+			t.Fatalf("expected an error creating a snapshot without a name or generator")
+		}
+		manager.config.NameGenerator = func(snapshots []Snapshot) (string, error) {
+			return fmt.Sprintf("snapshot-%d", len(snapshots)+1), nil
+		}
+		for _, expected := range []string{"snapshot-1", "snapshot-2"} {
+			snapshot, err := manager.Create(context.Background(), "", "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot: %s", err)
+			}
+			if snapshot.Name != expected {
+				t.Errorf("expected generated name %q, got %q", expected, snapshot.Name)
+			}
+		}
+		// Names that are given are not replaced.
+		if snapshot, err := manager.Create(context.Background(), "named", ""); err != nil || snapshot.Name != "named" {
+			t.Errorf("unexpected snapshot %q: %v", snapshot.Name, err)
+		}
+		// Generated names are validated like any other.
+		manager.config.NameGenerator = func([]Snapshot) (string, error) {
+			return "snapshot-1", nil
+		}
+		if _, err := manager.Create(context.Background(), "", ""); !errors.Is(err, ErrNameExists) {
+			t.Errorf("expected ErrNameExists for a generated name in use, got %v", err)
+		}
+		errGenerator := errors.New("out of names")
+		manager.config.NameGenerator = func([]Snapshot) (string, error) {
+			return "", errGenerator
+		}
+		if _, err := manager.Create(context.Background(), "", ""); !errors.Is(err, errGenerator) {
+			t.Errorf("expected the generator's error, got %v", err)
+		}
+	})
+
 	for _, includeIncomplete := range []bool{true, false} {
 		t.Run(fmt.Sprintf("List with includeIncomplete %t", includeIncomplete), func(t *testing.T) {
 			paths, _ := populateFiles(t, true)
@@ -209,59 +465,2220 @@ func TestManager(t *testing.T) {
 		}
 	})
 
-	t.Run("Restore should return an error if asked to restore a nonexistent snapshot", func(t *testing.T) {
+	t.Run("An interrupted Delete should leave nothing that is listed", func(t *testing.T) {
 		paths, _ := populateFiles(t, true)
 		manager := newTestManager(paths)
-		if err := manager.Restore(context.Background(), "no-such-snapshot-id"); err == nil {
-			t.Errorf("Failed to complain when asked to restore a nonexistent snapshot")
+		snapshot, err := manager.Create(context.Background(), "test-interrupted", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		interrupted := errors.New("interrupted")
+		manager.deleteInterruptHook = func() error { return interrupted }
+		if err := manager.Delete(snapshot.Name); !errors.Is(err, interrupted) {
+			t.Fatalf("expected the deletion to be interrupted, got %v", err)
+		}
+		manager.deleteInterruptHook = nil
+		snapshotDir := manager.SnapshotDirectory(snapshot)
+		for _, name := range []string{completeFileName, metadataFileName} {
+			if _, err := os.Stat(filepath.Join(snapshotDir, name)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected %s to be removed first, got %v", name, err)
+			}
+		}
+		if entries, err := os.ReadDir(snapshotDir); err != nil || len(entries) == 0 {
+			t.Fatalf("expected the rest of the snapshot to be left behind: %v", err)
+		}
+		snapshots, err := manager.ListWithOptions(ListOptions{IncludeIncomplete: true, ForceRefresh: true})
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 0 {
+			t.Errorf("expected the remnant not to be listed, got %+v", snapshots)
+		}
+		if _, err := manager.Stat(snapshot.ID); err == nil {
+			t.Errorf("expected the remnant not to be found by ID")
+		}
+		report, err := manager.Fsck(true)
+		if err != nil {
+			t.Fatalf("failed to check snapshots: %s", err)
+		}
+		if len(report.Problems) != 1 || report.Problems[0].Kind != FsckIncomplete || !report.Problems[0].Repaired {
+			t.Errorf("expected fsck to remove the remnant, got %+v", report.Problems)
+		}
+		if _, err := os.Stat(snapshotDir); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("the remnant is still there: %v", err)
 		}
 	})
 
-	t.Run("Restore should return the proper error if asked to restore from an incomplete snapshot", func(t *testing.T) {
+	t.Run("ListByPrefix should match names by prefix", func(t *testing.T) {
 		paths, _ := populateFiles(t, true)
 		manager := newTestManager(paths)
-		snapshot, err := manager.Create(context.Background(), "test-snapshot-restore-incomplete", "")
+		for _, name := range []string{"test-one", "Test-two", "other", "test"} {
+			if _, err := manager.Create(context.Background(), name, ""); err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+		}
+		for prefix, expected := range map[string][]string{
+			"":         {"other", "test", "test-one", "Test-two"},
+			"test-":    {"test-one", "Test-two"},
+			"TEST":     {"test", "test-one", "Test-two"},
+			"test-one": {"test-one"},
+			"missing":  {},
+		} {
+			snapshots, err := manager.ListByPrefix(prefix)
+			if err != nil {
+				t.Fatalf("failed to list snapshots with prefix %q: %s", prefix, err)
+			}
+			names := make([]string, 0, len(snapshots))
+			for _, snapshot := range snapshots {
+				names = append(names, snapshot.Name)
+			}
+			slices.SortFunc(names, func(a, b string) int {
+				return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+			})
+			if !slices.Equal(names, expected) {
+				t.Errorf("prefix %q matched %q, expected %q", prefix, names, expected)
+			}
+		}
+	})
+
+	t.Run("ListByPrefix should skip incomplete snapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-incomplete", "")
 		if err != nil {
 			t.Fatalf("failed to create snapshot: %s", err)
 		}
-		completeFilePath := filepath.Join(manager.Snapshots, snapshot.ID, completeFileName)
-		if err := os.Remove(completeFilePath); err != nil {
-			t.Fatalf("failed to remove %q: %s", completeFileName, err)
+		if err := os.Remove(filepath.Join(manager.SnapshotDirectory(snapshot), completeFileName)); err != nil {
+			t.Fatalf("failed to remove %s: %s", completeFileName, err)
 		}
-		if err := manager.Restore(context.Background(), snapshot.Name); err == nil {
-			t.Errorf("Failed to complain when asked to restore an incomplete snapshot")
+		snapshots, err := manager.ListByPrefix("test-")
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 0 {
+			t.Errorf("expected no snapshots, got %d", len(snapshots))
 		}
 	})
 
-	t.Run("Restore should return proper error and not run RestoreFiles when context is already cancelled", func(t *testing.T) {
+	t.Run("List should not read unchanged snapshots again", func(t *testing.T) {
+		savedNow := listCacheNow
+		defer func() { listCacheNow = savedNow }()
+		// Trust the modification times of the files just written.
+		listCacheNow = func() time.Time { return time.Now().Add(time.Hour) }
 		paths, _ := populateFiles(t, true)
 		manager := newTestManager(paths)
-		snapshotName := "test-snapshot-restore-cancelled"
-		_, err := manager.Create(context.Background(), snapshotName, "")
+		snapshot, err := manager.Create(context.Background(), "test-cached", "")
 		if err != nil {
 			t.Fatalf("failed to create snapshot: %s", err)
 		}
-		ctx, cancel := context.WithCancel(context.Background())
-		cancel()
-		if err := manager.Restore(ctx, snapshotName); !errors.Is(err, runner.ErrContextDone) {
-			t.Errorf("Error is of unexpected type: %q", err)
+		if _, err := manager.List(false); err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		// Break the metadata, keeping its size and modification time, so that
+		// only a snapshot that is read again fails.
+		metadataPath := metadataFilePath(manager.Snapshots, snapshot.ID)
+		info, err := os.Stat(metadataPath)
+		if err != nil {
+			t.Fatalf("failed to stat metadata: %s", err)
+		}
+		if err := os.WriteFile(metadataPath, bytes.Repeat([]byte("x"), int(info.Size())), 0o644); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+		if err := os.Chtimes(metadataPath, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatalf("failed to set metadata times: %s", err)
+		}
+		snapshots, err := manager.List(false)
+		if err != nil {
+			t.Fatalf("failed to list cached snapshots: %s", err)
+		}
+		if len(snapshots) != 1 || snapshots[0].ID != snapshot.ID {
+			t.Errorf("expected cached snapshot %q, got %+v", snapshot.ID, snapshots)
+		}
+		if _, err := manager.ListWithOptions(ListOptions{ForceRefresh: true}); err == nil {
+			t.Error("expected an error listing with ForceRefresh")
 		}
 	})
 
-	t.Run("Restore should return data reset error when RestoreFiles encounters an error and resets data", func(t *testing.T) {
+	t.Run("List should not trust recent modification times", func(t *testing.T) {
 		paths, _ := populateFiles(t, true)
 		manager := newTestManager(paths)
-		snapshotName := "test-snapshot-error"
-		snapshot, err := manager.Create(context.Background(), snapshotName, "")
+		snapshot, err := manager.Create(context.Background(), "test-recent", "")
 		if err != nil {
 			t.Fatalf("failed to create snapshot: %s", err)
 		}
-		snapshotSettingsPath := filepath.Join(paths.Snapshots, snapshot.ID, "settings.json")
-		if err := os.RemoveAll(snapshotSettingsPath); err != nil {
-			t.Fatalf("failed to remove settings.json: %s", err)
+		if _, err := manager.List(false); err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
 		}
-		if err := manager.Restore(context.Background(), snapshotName); !errors.Is(err, ErrDataReset) {
-			t.Errorf("Error is of unexpected type: %q", err)
+		// The metadata was written just now, so rewriting it in the same
+		// clock tick must still be seen.
+		metadataPath := metadataFilePath(manager.Snapshots, snapshot.ID)
+		info, err := os.Stat(metadataPath)
+		if err != nil {
+			t.Fatalf("failed to stat metadata: %s", err)
+		}
+		if err := os.WriteFile(metadataPath, bytes.Repeat([]byte("x"), int(info.Size())), 0o644); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+		if err := os.Chtimes(metadataPath, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatalf("failed to set metadata times: %s", err)
+		}
+		if _, err := manager.List(false); err == nil {
+			t.Error("expected an error listing a snapshot with broken metadata")
+		}
+	})
+
+	t.Run("List should see changes made by other processes", func(t *testing.T) {
+		savedNow := listCacheNow
+		defer func() { listCacheNow = savedNow }()
+		listCacheNow = func() time.Time { return time.Now().Add(time.Hour) }
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		for _, name := range []string{"test-edited", "test-removed"} {
+			if _, err := manager.Create(context.Background(), name, ""); err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+		}
+		listNames := func() []string {
+			t.Helper()
+			snapshots, err := manager.List(false)
+			if err != nil {
+				t.Fatalf("failed to list snapshots: %s", err)
+			}
+			names := make([]string, 0, len(snapshots))
+			for _, snapshot := range snapshots {
+				names = append(names, snapshot.Name+":"+snapshot.Description)
+			}
+			slices.Sort(names)
+			return names
+		}
+		if names := listNames(); !slices.Equal(names, []string{"test-edited:", "test-removed:"}) {
+			t.Fatalf("unexpected snapshots %q", names)
+		}
+
+		// Another manager stands in for another process.
+		other := newTestManager(paths)
+		edited, err := other.Snapshot("test-edited")
+		if err != nil {
+			t.Fatalf("failed to find snapshot: %s", err)
+		}
+		edited.Description = "edited elsewhere"
+		if err := other.writeMetadataFile(edited); err != nil {
+			t.Fatalf("failed to edit snapshot: %s", err)
+		}
+		if err := other.Delete("test-removed"); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		if _, err := other.Create(context.Background(), "test-added", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		expected := []string{"test-added:", "test-edited:edited elsewhere"}
+		if names := listNames(); !slices.Equal(names, expected) {
+			t.Errorf("listed %q, expected %q", names, expected)
+		}
+
+		// A snapshot that was completed elsewhere is listed once complete.
+		incomplete, err := manager.Snapshot("test-added")
+		if err != nil {
+			t.Fatalf("failed to find snapshot: %s", err)
+		}
+		completePath := completeFilePath(manager.Snapshots, incomplete.ID)
+		if err := os.Remove(completePath); err != nil {
+			t.Fatalf("failed to remove %s: %s", completeFileName, err)
+		}
+		if names := listNames(); !slices.Equal(names, expected[1:]) {
+			t.Errorf("listed %q, expected %q", names, expected[1:])
+		}
+		if err := os.WriteFile(completePath, []byte(completeFileContents), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %s", completeFileName, err)
+		}
+		if names := listNames(); !slices.Equal(names, expected) {
+			t.Errorf("listed %q, expected %q", names, expected)
+		}
+	})
+
+	t.Run("List should not return cached data that callers can change", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if _, err := manager.Create(context.Background(), "test-shared", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for range 2 {
+			snapshots, err := manager.List(false)
+			if err != nil {
+				t.Fatalf("failed to list snapshots: %s", err)
+			}
+			if len(snapshots) != 1 {
+				t.Fatalf("expected one snapshot, got %d", len(snapshots))
+			}
+			if snapshots[0].Description != "" {
+				t.Errorf("cached snapshot was changed to %q", snapshots[0].Description)
+			}
+			snapshots[0].Description = "changed"
+		}
+	})
+
+	t.Run("List should be safe to call concurrently", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-concurrent", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		errs := make(chan error)
+		for i := range 8 {
+			go func() {
+				var err error
+				// A single writer: concurrent writes of the same metadata
+				// file are not what this checks.
+				if i == 0 {
+					_, err = manager.Touch(snapshot.ID)
+				} else {
+					_, err = manager.List(false)
+				}
+				errs <- err
+			}()
+		}
+		for range 8 {
+			if err := <-errs; err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}
+	})
+
+	t.Run("DeleteSnapshots should delete the matched snapshots only", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		for _, name := range []string{"test-one", "test-two", "keep"} {
+			if _, err := manager.Create(context.Background(), name, ""); err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+		}
+		matches, err := manager.ListByPrefix("test-")
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if err := manager.DeleteSnapshots(matches); err != nil {
+			t.Fatalf("failed to delete snapshots: %s", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots after delete: %s", err)
+		}
+		if len(snapshots) != 1 || snapshots[0].Name != "keep" {
+			t.Errorf("unexpected snapshots after delete: %+v", snapshots)
+		}
+	})
+
+	t.Run("Restore should return an error if asked to restore a nonexistent snapshot", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if err := manager.Restore(context.Background(), "no-such-snapshot-id", RestoreOptions{}); err == nil {
+			t.Errorf("Failed to complain when asked to restore a nonexistent snapshot")
+		}
+	})
+
+	t.Run("Restore should reject unknown components", func(t *testing.T) {
+		paths, testFiles := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			if err := os.WriteFile(testFile.Path, []byte(`{"something": "different"}`), 0o644); err != nil {
+				t.Fatalf("failed to modify %s: %s", testFileName, err)
+			}
+		}
+		err = manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Components: []string{componentSettings, "no-such-component"}})
+		if err == nil || !strings.Contains(err.Error(), "no-such-component") {
+			t.Errorf("unexpected error restoring an unknown component: %v", err)
+		}
+		if contents, err := os.ReadFile(testFiles["settings.json"].Path); err != nil || string(contents) == testFiles["settings.json"].Contents {
+			t.Errorf("settings were restored despite the unknown component: %v", err)
+		}
+	})
+
+	t.Run("Restore should return the proper error if asked to restore from an incomplete snapshot", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-restore-incomplete", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		completeFilePath := filepath.Join(manager.Snapshots, snapshot.ID, completeFileName)
+		if err := os.Remove(completeFilePath); err != nil {
+			t.Fatalf("failed to remove %q: %s", completeFileName, err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err == nil {
+			t.Errorf("Failed to complain when asked to restore an incomplete snapshot")
+		}
+	})
+
+	t.Run("Restore should return proper error and not run RestoreFiles when context is already cancelled", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshotName := "test-snapshot-restore-cancelled"
+		_, err := manager.Create(context.Background(), snapshotName, "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := manager.Restore(ctx, snapshotName, RestoreOptions{}); !errors.Is(err, runner.ErrContextDone) {
+			t.Errorf("Error is of unexpected type: %q", err)
+		}
+	})
+
+	t.Run("Restore should return data reset error when RestoreFiles encounters an error and resets data", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshotName := "test-snapshot-error"
+		snapshot, err := manager.Create(context.Background(), snapshotName, "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		snapshotSettingsPath := filepath.Join(paths.Snapshots, snapshot.ID, "settings.json")
+		if err := os.RemoveAll(snapshotSettingsPath); err != nil {
+			t.Fatalf("failed to remove settings.json: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshotName, RestoreOptions{}); !errors.Is(err, ErrDataReset) {
+			t.Errorf("Error is of unexpected type: %q", err)
+		}
+	})
+	t.Run("Close should release a backend lock held by an unfinished operation", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		backendLock := &recordingBackendLock{}
+		manager.BackendLocker = backendLock
+		if err := manager.lockBackend(context.Background(), "test"); err != nil {
+			t.Fatalf("failed to lock backend: %s", err)
+		}
+		if err := manager.Close(); err != nil {
+			t.Fatalf("failed to close manager: %s", err)
+		}
+		if backendLock.locked {
+			t.Errorf("backend lock was not released by Close")
+		}
+		// The operation finishing after Close must not unlock a second time.
+		if err := manager.unlockBackend(context.Background(), true); err != nil {
+			t.Fatalf("failed to unlock backend: %s", err)
+		}
+		if backendLock.unlocks != 1 {
+			t.Errorf("expected backend to be unlocked once, got %d", backendLock.unlocks)
+		}
+	})
+
+	t.Run("Metadata should convert between its stored and public forms", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "a description")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if snapshot, err = manager.Touch(snapshot.ID); err != nil {
+			t.Fatalf("failed to touch snapshot: %s", err)
+		}
+		contents, err := os.ReadFile(filepath.Join(manager.SnapshotDirectory(snapshot), metadataFileName))
+		if err != nil {
+			t.Fatalf("failed to read metadata: %s", err)
+		}
+		var stored map[string]any
+		if err := json.Unmarshal(contents, &stored); err != nil {
+			t.Fatalf("failed to parse metadata: %s", err)
+		}
+		for _, key := range []string{"created", "name", "id", "description", "lastUsed"} {
+			if _, ok := stored[key]; !ok {
+				t.Errorf("stored metadata is missing %q: %s", key, contents)
+			}
+		}
+		snapshots, err := manager.List(false)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 1 {
+			t.Fatalf("unexpected snapshots: %+v", snapshots)
+		}
+		listed := snapshots[0]
+		if listed.Name != snapshot.Name || listed.ID != snapshot.ID || listed.Description != snapshot.Description ||
+			!listed.Created.Equal(snapshot.Created) || !listed.LastUsed.Equal(snapshot.LastUsed) {
+			t.Errorf("listed snapshot %+v does not match %+v", listed, snapshot)
+		}
+		if listed.Created.Location() != time.Local {
+			t.Errorf("creation time is not in local time: %s", listed.Created)
+		}
+		if converted := newMetadata(listed).snapshot(); !reflect.DeepEqual(converted, listed) {
+			t.Errorf("conversion did not round trip: %+v != %+v", converted, listed)
+		}
+	})
+
+	t.Run("Close should not unlock when no lock is held", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		backendLock := &recordingBackendLock{}
+		manager.BackendLocker = backendLock
+		if _, err := manager.Create(context.Background(), "test-snapshot-close", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.Close(); err != nil {
+			t.Fatalf("failed to close manager: %s", err)
+		}
+		if backendLock.unlocks != 1 {
+			t.Errorf("expected backend to be unlocked once, got %d", backendLock.unlocks)
+		}
+	})
+
+	t.Run("Operations after Close should fail", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if err := manager.Close(); err != nil {
+			t.Fatalf("failed to close manager: %s", err)
+		}
+		if _, err := manager.Create(context.Background(), "test-snapshot-closed", ""); !errors.Is(err, ErrManagerClosed) {
+			t.Errorf("Error is of unexpected type: %q", err)
+		}
+	})
+	t.Run("Create should record the settings version", func(t *testing.T) {
+		paths, testFiles := populateFiles(t, true)
+		manager := newTestManager(paths)
+		settings := fmt.Sprintf(`{"version": %d}`, currentSettingsVersion)
+		if err := os.WriteFile(testFiles["settings.json"].Path, []byte(settings), 0o644); err != nil {
+			t.Fatalf("failed to write settings.json: %s", err)
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-version", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		listed, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to find snapshot: %s", err)
+		}
+		if listed.SettingsVersion != currentSettingsVersion {
+			t.Errorf("unexpected settings version %d (expected %d)", listed.SettingsVersion, currentSettingsVersion)
+		}
+	})
+
+	t.Run("CreateWithOptions should record the host", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		savedHostname := osHostname
+		defer func() { osHostname = savedHostname }()
+		osHostname = func() (string, error) { return "test-host", nil }
+
+		anonymous, err := manager.Create(context.Background(), "test-anonymous", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		named, err := manager.CreateWithOptions(context.Background(), "test-named", CreateOptions{RecordHostname: true})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for _, created := range []Snapshot{anonymous, named} {
+			listed, err := manager.Snapshot(created.Name)
+			if err != nil {
+				t.Fatalf("failed to find snapshot: %s", err)
+			}
+			if listed.Host == nil {
+				t.Fatalf("snapshot %q has no host", created.Name)
+			}
+			if *listed.Host != *created.Host {
+				t.Errorf("host %+v of snapshot %q did not round trip: %+v", created.Host, created.Name, listed.Host)
+			}
+			if _, err := uuid.Parse(listed.Host.MachineID); err != nil {
+				t.Errorf("invalid machine ID %q: %s", listed.Host.MachineID, err)
+			}
+		}
+		if anonymous.Host.Hostname != "" {
+			t.Errorf("host name recorded without RecordHostname: %+v", anonymous.Host)
+		}
+		if named.Host.Hostname != "test-host" {
+			t.Errorf("unexpected host name %q", named.Host.Hostname)
+		}
+		if anonymous.Host.MachineID != named.Host.MachineID {
+			t.Errorf("machine ID changed from %q to %q", anonymous.Host.MachineID, named.Host.MachineID)
+		}
+
+		// The host survives copying the snapshot elsewhere.
+		destDir := filepath.Join(t.TempDir(), "backup")
+		if err := manager.Copy(context.Background(), named.ID, destDir, CopyOptions{}); err != nil {
+			t.Fatalf("failed to copy snapshot: %s", err)
+		}
+		backupPaths := *paths
+		backupPaths.Snapshots = destDir
+		copies, err := newTestManager(&backupPaths).List(false)
+		if err != nil || len(copies) != 1 || copies[0].Host == nil || *copies[0].Host != *named.Host {
+			t.Errorf("unexpected snapshots at the destination: %+v, %v", copies, err)
+		}
+	})
+
+	for _, offset := range []int{1, -maxSettingsVersionGap - 1} {
+		t.Run(fmt.Sprintf("Restore should require force for a settings version offset of %d", offset), func(t *testing.T) {
+			paths, testFiles := populateFiles(t, true)
+			manager := newTestManager(paths)
+			settings := fmt.Sprintf(`{"version": %d}`, currentSettingsVersion+offset)
+			if err := os.WriteFile(testFiles["settings.json"].Path, []byte(settings), 0o644); err != nil {
+				t.Fatalf("failed to write settings.json: %s", err)
+			}
+			snapshot, err := manager.Create(context.Background(), "test-snapshot-version-mismatch", "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot: %s", err)
+			}
+			if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); !errors.Is(err, ErrSettingsVersionMismatch) {
+				t.Errorf("Error is of unexpected type: %q", err)
+			}
+			if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Force: true}); err != nil {
+				t.Errorf("failed to restore snapshot with force: %s", err)
+			}
+		})
+	}
+
+	t.Run("Restore should allow a slightly older settings version", func(t *testing.T) {
+		paths, testFiles := populateFiles(t, true)
+		manager := newTestManager(paths)
+		settings := fmt.Sprintf(`{"version": %d}`, currentSettingsVersion-maxSettingsVersionGap)
+		if err := os.WriteFile(testFiles["settings.json"].Path, []byte(settings), 0o644); err != nil {
+			t.Fatalf("failed to write settings.json: %s", err)
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-version-older", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Errorf("failed to restore snapshot: %s", err)
+		}
+	})
+	t.Run("Restore should migrate components whose version differs", func(t *testing.T) {
+		paths, testFiles := populateFiles(t, true)
+		settingsPath := testFiles["settings.json"].Path
+		writeKubernetesVersion := func(version string) {
+			settings := fmt.Sprintf(`{"version": %d, "kubernetes": {"version": %q}}`, currentSettingsVersion, version)
+			if err := os.WriteFile(settingsPath, []byte(settings), 0o644); err != nil {
+				t.Fatalf("failed to write settings.json: %s", err)
+			}
+		}
+		writeKubernetesVersion("1.29.4")
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-components", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		listed, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to find snapshot: %s", err)
+		}
+		if version := listed.ComponentVersions[ComponentKubernetes]; version != "1.29.4" {
+			t.Fatalf("unexpected Kubernetes version %q recorded", version)
+		}
+
+		// The same version needs no migration.
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+
+		// Without a hook, the restore goes ahead.
+		writeKubernetesVersion("1.30.1")
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Errorf("failed to restore snapshot without a hook: %s", err)
+		}
+
+		var migrations []ComponentMigration
+		var hookErr error
+		manager.config.MigrationHooks = map[string]MigrationHook{
+			ComponentKubernetes: func(ctx context.Context, migration ComponentMigration) error {
+				// The hook runs on the restored files.
+				if version := readComponentVersions(settingsPath)[ComponentKubernetes]; version != "1.29.4" {
+					t.Errorf("hook ran before settings.json was restored: version %q", version)
+				}
+				migrations = append(migrations, migration)
+				return hookErr
+			},
+		}
+		writeKubernetesVersion("1.30.1")
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		expected := ComponentMigration{
+			Component:       ComponentKubernetes,
+			SnapshotVersion: "1.29.4",
+			CurrentVersion:  "1.30.1",
+			Paths:           manager.Paths,
+		}
+		if len(migrations) != 1 || migrations[0] != expected {
+			t.Errorf("expected migration %+v, got %+v", expected, migrations)
+		}
+
+		hookErr = errors.New("migration failed")
+		writeKubernetesVersion("1.30.1")
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); !errors.Is(err, hookErr) {
+			t.Errorf("expected the hook's error, got %v", err)
+		}
+	})
+
+	t.Run("Restore should update the last used time", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-last-used", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		listed, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to get snapshot: %s", err)
+		}
+		if !listed.LastUsed.IsZero() {
+			t.Errorf("new snapshot has last used time %s", listed.LastUsed)
+		}
+		before := time.Now().Add(-time.Second)
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		listed, err = manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to get snapshot: %s", err)
+		}
+		if listed.LastUsed.Before(before) {
+			t.Errorf("last used time %s was not updated", listed.LastUsed)
+		}
+		if !listed.Created.Equal(snapshot.Created) {
+			t.Errorf("created time changed from %s to %s", snapshot.Created, listed.Created)
+		}
+	})
+
+	t.Run("Restore should refuse a snapshot that changed since it was listed", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-digest", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		listed, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to get snapshot: %s", err)
+		}
+		if listed.Digest == "" || listed.Digest != snapshot.Digest {
+			t.Fatalf("listed digest %q does not match created digest %q", listed.Digest, snapshot.Digest)
+		}
+		// Restoring only updates the last used time, which keeps the digest.
+		for range 2 {
+			if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{ExpectedDigest: listed.Digest}); err != nil {
+				t.Fatalf("failed to restore snapshot: %s", err)
+			}
+		}
+
+		if err := manager.Delete(snapshot.Name); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		if _, err := manager.Create(context.Background(), snapshot.Name, ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		err = manager.Restore(context.Background(), snapshot.Name, RestoreOptions{ExpectedDigest: listed.Digest})
+		if !errors.Is(err, ErrSnapshotChanged) {
+			t.Errorf("expected ErrSnapshotChanged restoring a replaced snapshot, got %v", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Errorf("failed to restore snapshot without a digest: %s", err)
+		}
+	})
+
+	t.Run("Touch should update the last used time", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-touch", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		touched, err := manager.Touch(snapshot.ID)
+		if err != nil {
+			t.Fatalf("failed to touch snapshot: %s", err)
+		}
+		listed, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to get snapshot: %s", err)
+		}
+		if !listed.LastUsed.Equal(touched.LastUsed) {
+			t.Errorf("last used time %s does not match touched time %s", listed.LastUsed, touched.LastUsed)
+		}
+		if _, err := manager.Touch(uuid.NewString()); err == nil {
+			t.Errorf("expected error touching nonexistent snapshot")
+		}
+	})
+
+	t.Run("Snapshot paths should be built from the snapshots directory and ID", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-paths", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		snapshotDir := filepath.Join(paths.Snapshots, snapshot.ID)
+		if dir := manager.SnapshotDirectory(snapshot); dir != snapshotDir {
+			t.Errorf("unexpected snapshot directory %q", dir)
+		}
+		for _, path := range []string{
+			metadataFilePath(paths.Snapshots, snapshot.ID),
+			completeFilePath(paths.Snapshots, snapshot.ID),
+		} {
+			if filepath.Dir(path) != snapshotDir {
+				t.Errorf("%q is not in the snapshot directory", path)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("%q was not written: %s", path, err)
+			}
+		}
+	})
+	t.Run("CreateWithOptions should record the cluster health", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		health := &ClusterHealth{
+			Checked:  time.Now(),
+			Problems: []string{"pod default/web is pending"},
+		}
+		defer func(check func(context.Context) (*ClusterHealth, error)) {
+			checkClusterHealthFunc = check
+		}(checkClusterHealthFunc)
+		checkClusterHealthFunc = func(context.Context) (*ClusterHealth, error) {
+			return health, nil
+		}
+		snapshot, err := manager.CreateWithOptions(context.Background(), "test-snapshot-health", CreateOptions{
+			Description:  "description",
+			CheckCluster: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		listed, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to get snapshot: %s", err)
+		}
+		if listed.Description != "description" {
+			t.Errorf("unexpected description %q", listed.Description)
+		}
+		if listed.ClusterHealth == nil || listed.ClusterHealth.Healthy ||
+			!listed.ClusterHealth.Checked.Equal(health.Checked) ||
+			!slices.Equal(listed.ClusterHealth.Problems, health.Problems) {
+			t.Errorf("unexpected cluster health %+v", listed.ClusterHealth)
+		}
+		if listed.Digest != snapshot.Digest {
+			t.Errorf("listed digest %q does not match created digest %q", listed.Digest, snapshot.Digest)
+		}
+
+		unchecked, err := manager.Create(context.Background(), "test-snapshot-unchecked", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if listed, err := manager.Snapshot(unchecked.Name); err != nil || listed.ClusterHealth != nil {
+			t.Errorf("unexpected cluster health %+v: %v", listed.ClusterHealth, err)
+		}
+	})
+	t.Run("CreateWithOptions should only create snapshots of a healthy cluster if required", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		defer func(check func(context.Context) (*ClusterHealth, error)) {
+			checkClusterHealthFunc = check
+		}(checkClusterHealthFunc)
+		errNoCluster := errors.New("Kubernetes is not running")
+		checkClusterHealthFunc = func(context.Context) (*ClusterHealth, error) {
+			return nil, errNoCluster
+		}
+		snapshot, err := manager.CreateWithOptions(context.Background(), "test-snapshot-no-cluster", CreateOptions{CheckCluster: true})
+		if err != nil {
+			t.Fatalf("failing to check the cluster should only be warned about: %s", err)
+		}
+		if snapshot.ClusterHealth != nil {
+			t.Errorf("unexpected cluster health %+v", snapshot.ClusterHealth)
+		}
+		if _, err := manager.CreateWithOptions(context.Background(), "test-snapshot-required", CreateOptions{RequireHealthy: true}); !errors.Is(err, errNoCluster) {
+			t.Errorf("expected the check error, got %v", err)
+		}
+		checkClusterHealthFunc = func(context.Context) (*ClusterHealth, error) {
+			return &ClusterHealth{Checked: time.Now(), Problems: []string{"node lima-rancher-desktop is not ready"}}, nil
+		}
+		if _, err := manager.CreateWithOptions(context.Background(), "test-snapshot-required", CreateOptions{RequireHealthy: true}); !errors.Is(err, ErrClusterUnhealthy) {
+			t.Errorf("expected ErrClusterUnhealthy, got %v", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 1 {
+			t.Errorf("expected only the first snapshot to be created, got %d snapshots", len(snapshots))
+		}
+	})
+	t.Run("CreateWithOptions should refuse to exceed MaxSnapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		opts := CreateOptions{MaxSnapshots: 2}
+		for _, name := range []string{"test-snapshot-1", "test-snapshot-2"} {
+			if _, err := manager.CreateWithOptions(context.Background(), name, opts); err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+		}
+		if _, err := manager.CreateWithOptions(context.Background(), "test-snapshot-3", opts); !errors.Is(err, ErrSnapshotLimitReached) {
+			t.Errorf("expected ErrSnapshotLimitReached, got %v", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 2 {
+			t.Errorf("expected 2 snapshots, got %d", len(snapshots))
+		}
+		if _, err := manager.CreateWithOptions(context.Background(), "test-snapshot-3", CreateOptions{}); err != nil {
+			t.Errorf("snapshots should be unlimited by default: %s", err)
+		}
+	})
+	t.Run("CreateWithOptions should prune the oldest snapshots to stay within MaxSnapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		var created []Snapshot
+		for _, name := range []string{"test-snapshot-1", "test-snapshot-2", "test-snapshot-3"} {
+			snapshot, err := manager.Create(context.Background(), name, "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+			created = append(created, snapshot)
+		}
+		newest, err := manager.CreateWithOptions(context.Background(), "test-snapshot-4", CreateOptions{MaxSnapshots: 2, PruneOldest: true})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		var ids []string
+		for _, snapshot := range snapshots {
+			ids = append(ids, snapshot.ID)
+		}
+		slices.Sort(ids)
+		expected := []string{created[2].ID, newest.ID}
+		slices.Sort(expected)
+		if !slices.Equal(ids, expected) {
+			t.Errorf("expected snapshots %q to remain, got %q", expected, ids)
+		}
+	})
+	t.Run("CreateWithOptions should prune the least recently used snapshots by LastUsed", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		var created []Snapshot
+		for _, name := range []string{"test-snapshot-touched", "test-snapshot-restored", "test-snapshot-unused"} {
+			snapshot, err := manager.Create(context.Background(), name, "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+			created = append(created, snapshot)
+		}
+		// The two oldest snapshots are used after the newest one is created.
+		if _, err := manager.Touch(created[0].ID); err != nil {
+			t.Fatalf("failed to touch snapshot: %s", err)
+		}
+		if err := manager.Restore(context.Background(), created[1].Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		opts := CreateOptions{MaxSnapshots: 3, PruneOldest: true, PruneBy: PruneByLastUsed}
+		newest, err := manager.CreateWithOptions(context.Background(), "test-snapshot-new", opts)
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		var ids []string
+		for _, snapshot := range snapshots {
+			ids = append(ids, snapshot.ID)
+		}
+		slices.Sort(ids)
+		expected := []string{created[0].ID, created[1].ID, newest.ID}
+		slices.Sort(expected)
+		if !slices.Equal(ids, expected) {
+			t.Errorf("expected snapshots %q to remain, got %q", expected, ids)
+		}
+
+		opts.PruneBy = "name"
+		if _, err := manager.CreateWithOptions(context.Background(), "test-snapshot-invalid", opts); err == nil || !strings.Contains(err.Error(), "invalid prune basis") {
+			t.Errorf("expected an invalid prune basis to be refused, got %v", err)
+		}
+	})
+	t.Run("Protected snapshots should not be deleted", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		var protected Snapshot
+		for _, name := range []string{"test-protected", "test-other"} {
+			snapshot, err := manager.Create(context.Background(), name, "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+			if name == "test-protected" {
+				protected = snapshot
+			}
+		}
+		updated, err := manager.SetProtected(protected.ID, true)
+		if err != nil {
+			t.Fatalf("failed to protect snapshot: %s", err)
+		}
+		if !updated.Protected || updated.Digest != protected.Digest {
+			t.Errorf("expected a protected snapshot with the same digest, got %+v", updated)
+		}
+		if err := manager.Delete(protected.Name); !errors.Is(err, ErrSnapshotProtected) {
+			t.Errorf("expected ErrSnapshotProtected, got %v", err)
+		}
+		matches, err := manager.ListByPrefix("test-")
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if err := manager.DeleteSnapshots(matches); err != nil {
+			t.Fatalf("failed to delete snapshots: %s", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots after delete: %s", err)
+		}
+		if len(snapshots) != 1 || snapshots[0].ID != protected.ID || !snapshots[0].Protected {
+			t.Fatalf("expected only the protected snapshot to remain, got %+v", snapshots)
+		}
+
+		if _, err := manager.SetProtected(protected.ID, false); err != nil {
+			t.Fatalf("failed to unprotect snapshot: %s", err)
+		}
+		if err := manager.Delete(protected.Name); err != nil {
+			t.Errorf("failed to delete unprotected snapshot: %s", err)
+		}
+		var notFound *NotFoundError
+		if _, err := manager.SetProtected(protected.ID, true); !errors.As(err, &notFound) {
+			t.Errorf("expected NotFoundError protecting a deleted snapshot, got %v", err)
+		}
+	})
+	t.Run("CreateWithOptions should never prune protected snapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		var created []Snapshot
+		for _, name := range []string{"test-snapshot-1", "test-snapshot-2", "test-snapshot-3"} {
+			snapshot, err := manager.Create(context.Background(), name, "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+			created = append(created, snapshot)
+		}
+		// Protect the oldest, so that the next oldest is pruned instead.
+		if _, err := manager.SetProtected(created[0].ID, true); err != nil {
+			t.Fatalf("failed to protect snapshot: %s", err)
+		}
+		opts := CreateOptions{MaxSnapshots: 3, PruneOldest: true}
+		newest, err := manager.CreateWithOptions(context.Background(), "test-snapshot-4", opts)
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		snapshots, err := manager.List(true)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		var ids []string
+		for _, snapshot := range snapshots {
+			ids = append(ids, snapshot.ID)
+		}
+		slices.Sort(ids)
+		expected := []string{created[0].ID, created[2].ID, newest.ID}
+		slices.Sort(expected)
+		if !slices.Equal(ids, expected) {
+			t.Errorf("expected snapshots %q to remain, got %q", expected, ids)
+		}
+
+		// With every snapshot protected, there is nothing to prune.
+		for _, snapshot := range []Snapshot{created[2], newest} {
+			if _, err := manager.SetProtected(snapshot.ID, true); err != nil {
+				t.Fatalf("failed to protect snapshot: %s", err)
+			}
+		}
+		if _, err := manager.CreateWithOptions(context.Background(), "test-snapshot-5", opts); !errors.Is(err, ErrSnapshotLimitReached) {
+			t.Errorf("expected ErrSnapshotLimitReached, got %v", err)
+		}
+		if snapshots, err := manager.List(true); err != nil || len(snapshots) != 3 {
+			t.Errorf("expected the 3 protected snapshots to remain, got %d (%v)", len(snapshots), err)
+		}
+	})
+	t.Run("Prefetch should read a snapshot without changing it", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-prefetch", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		modTimes := func() map[string]time.Time {
+			t.Helper()
+			entries, err := os.ReadDir(manager.SnapshotDirectory(snapshot))
+			if err != nil {
+				t.Fatalf("failed to read snapshot directory: %s", err)
+			}
+			result := make(map[string]time.Time, len(entries))
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil {
+					t.Fatalf("failed to stat %q: %s", entry.Name(), err)
+				}
+				result[entry.Name()] = info.ModTime()
+			}
+			return result
+		}
+		before := modTimes()
+		listed, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to find snapshot: %s", err)
+		}
+		if err := manager.Prefetch(context.Background(), snapshot.ID); err != nil {
+			t.Fatalf("failed to prefetch snapshot: %s", err)
+		}
+		if after := modTimes(); !reflect.DeepEqual(before, after) {
+			t.Errorf("prefetching changed the snapshot files: %v, then %v", before, after)
+		}
+		prefetched, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to find snapshot: %s", err)
+		}
+		if !reflect.DeepEqual(prefetched, listed) {
+			t.Errorf("prefetching changed the snapshot from %+v to %+v", listed, prefetched)
+		}
+	})
+
+	t.Run("Prefetch should stop when the context is done", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-prefetch", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := manager.Prefetch(ctx, snapshot.ID); !errors.Is(err, runner.ErrContextDone) {
+			t.Errorf("expected ErrContextDone, got %v", err)
+		}
+	})
+
+	t.Run("Prefetch should return a NotFoundError for unknown snapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		var notFound *NotFoundError
+		if err := manager.Prefetch(context.Background(), uuid.NewString()); !errors.As(err, &notFound) {
+			t.Errorf("expected a NotFoundError, got %v", err)
+		}
+	})
+
+	t.Run("Copy should copy a snapshot to another directory", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		destDir := filepath.Join(t.TempDir(), "backup")
+		if err := manager.Copy(context.Background(), snapshot.ID, destDir, CopyOptions{}); err != nil {
+			t.Fatalf("failed to copy snapshot: %s", err)
+		}
+		srcDir := manager.SnapshotDirectory(snapshot)
+		entries, err := os.ReadDir(srcDir)
+		if err != nil {
+			t.Fatalf("failed to read snapshot directory: %s", err)
+		}
+		for _, entry := range entries {
+			original, err := os.ReadFile(filepath.Join(srcDir, entry.Name()))
+			if err != nil {
+				t.Fatalf("failed to read original %q: %s", entry.Name(), err)
+			}
+			copied, err := os.ReadFile(filepath.Join(destDir, snapshot.ID, entry.Name()))
+			if err != nil {
+				t.Fatalf("failed to read copy of %q: %s", entry.Name(), err)
+			}
+			if !bytes.Equal(original, copied) {
+				t.Errorf("copy of %q differs from the original", entry.Name())
+			}
+		}
+		// The copy can be used as a snapshots directory.
+		backupPaths := *paths
+		backupPaths.Snapshots = destDir
+		backup := newTestManager(&backupPaths)
+		copies, err := backup.List(false)
+		if err != nil || len(copies) != 1 || copies[0].Name != snapshot.Name {
+			t.Errorf("unexpected snapshots at the destination: %+v, %v", copies, err)
+		}
+		if _, err := manager.Snapshot(snapshot.Name); err != nil {
+			t.Errorf("original snapshot is gone: %s", err)
+		}
+
+		if err := manager.Copy(context.Background(), snapshot.ID, destDir, CopyOptions{}); !errors.Is(err, ErrCopyDestinationExists) {
+			t.Errorf("expected ErrCopyDestinationExists, got %v", err)
+		}
+		if err := manager.Copy(context.Background(), snapshot.ID, destDir, CopyOptions{Force: true}); err != nil {
+			t.Errorf("failed to replace copy: %s", err)
+		}
+		if err := manager.Copy(context.Background(), snapshot.ID, paths.Snapshots, CopyOptions{Force: true}); err == nil {
+			t.Errorf("expected an error copying a snapshot onto itself")
+		}
+		var notFound *NotFoundError
+		if err := manager.Copy(context.Background(), uuid.NewString(), destDir, CopyOptions{}); !errors.As(err, &notFound) {
+			t.Errorf("expected NotFoundError, got %v", err)
+		}
+	})
+	t.Run("CreateProfile should read the options of a profile", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if _, err := manager.CreateProfile("nightly"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+			t.Errorf("expected an error for a missing profiles file, got %v", err)
+		}
+		profiles := `{
+			"nightly": {"description": "Nightly snapshot", "checkCluster": true},
+			"strict": {"requireHealthy": true},
+			"typo": {"checkCluser": true}
+		}`
+		if err := os.WriteFile(manager.CreateProfilesPath(), []byte(profiles), 0o644); err != nil {
+			t.Fatalf("failed to write profiles: %s", err)
+		}
+		opts, err := manager.CreateProfile("nightly")
+		if err != nil {
+			t.Fatalf("failed to read profile: %s", err)
+		}
+		if opts != (CreateOptions{Description: "Nightly snapshot", CheckCluster: true}) {
+			t.Errorf("unexpected options %+v", opts)
+		}
+		if _, err := manager.CreateProfile("weekly"); err == nil || !strings.Contains(err.Error(), "nightly, strict, typo") {
+			t.Errorf("expected an error listing the profiles, got %v", err)
+		}
+		if _, err := manager.CreateProfile("typo"); err == nil || !strings.Contains(err.Error(), `unknown field "checkCluser"`) {
+			t.Errorf("expected an error for an unknown option, got %v", err)
+		}
+	})
+	t.Run("RestoreLatest should fail when there are no snapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if _, _, err := manager.RestoreLatest(context.Background(), RestoreOptions{}); !errors.Is(err, ErrNoSnapshots) {
+			t.Errorf("expected ErrNoSnapshots, got %v", err)
+		}
+	})
+	t.Run("RestoreLatest should restore the most recently created snapshot", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		older, err := manager.Create(context.Background(), "test-snapshot-older", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		newer, err := manager.Create(context.Background(), "test-snapshot-newer", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		restored, _, err := manager.RestoreLatest(context.Background(), RestoreOptions{})
+		if err != nil {
+			t.Fatalf("failed to restore latest snapshot: %s", err)
+		}
+		if restored.ID != newer.ID {
+			t.Errorf("restored snapshot %q instead of %q", restored.Name, newer.Name)
+		}
+		for _, snapshot := range []Snapshot{older, newer} {
+			listed, err := manager.Snapshot(snapshot.Name)
+			if err != nil {
+				t.Fatalf("failed to get snapshot: %s", err)
+			}
+			if wasRestored := !listed.LastUsed.IsZero(); wasRestored != (snapshot.ID == newer.ID) {
+				t.Errorf("snapshot %q has unexpected last used time %s", snapshot.Name, listed.LastUsed)
+			}
+		}
+
+		// Snapshots created at the same time are not guessed between.
+		newer.Created = older.Created
+		if err := manager.writeMetadataFile(newer); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+		if _, _, err := manager.RestoreLatest(context.Background(), RestoreOptions{}); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+			t.Errorf("expected an ambiguous latest snapshot, got %v", err)
+		}
+	})
+
+	t.Run("Create and Restore should write operation logs", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-logs", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		logPaths, err := manager.OperationLogs(snapshot)
+		if err != nil {
+			t.Fatalf("failed to get operation logs: %s", err)
+		}
+		if len(logPaths) != 2 {
+			t.Fatalf("unexpected number of operation logs %d (expected 2)", len(logPaths))
+		}
+		for i, operation := range []string{"create", "restore"} {
+			contents := readLog(t, logPaths[i])
+			if !strings.Contains(contents, operation+" snapshot") || !strings.Contains(contents, "done") {
+				t.Errorf("unexpected contents of %s log: %s", operation, contents)
+			}
+		}
+		lastLog, err := manager.LastOperationLog()
+		if err != nil {
+			t.Fatalf("failed to get last operation log: %s", err)
+		}
+		if lastLog != logPaths[1] {
+			t.Errorf("unexpected last operation log %q (expected %q)", lastLog, logPaths[1])
+		}
+		if err := manager.Delete(snapshot.Name); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		if _, err := manager.LastOperationLog(); !errors.Is(err, ErrNoOperationLogs) {
+			t.Errorf("operation logs were not removed with the snapshot: %v", err)
+		}
+	})
+
+	t.Run("Create should keep the operation log when it fails", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		manager.Snapshotter = failingSnapshotter{manager.Snapshotter}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-logs-failed", "")
+		if err == nil {
+			t.Fatalf("unexpectedly created snapshot")
+		}
+		if _, err := os.Stat(manager.SnapshotDirectory(snapshot)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("incomplete snapshot directory was not removed: %v", err)
+		}
+		lastLog, err := manager.LastOperationLog()
+		if err != nil {
+			t.Fatalf("failed to get last operation log: %s", err)
+		}
+		contents := readLog(t, lastLog)
+		for _, expected := range []string{"removing incomplete snapshot directory", "failed: injected failure"} {
+			if !strings.Contains(contents, expected) {
+				t.Errorf("operation log does not contain %q: %s", expected, contents)
+			}
+		}
+	})
+
+	t.Run("Restore should log the error when data is reset", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot-logs-reset", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := os.RemoveAll(filepath.Join(paths.Snapshots, snapshot.ID, "settings.json")); err != nil {
+			t.Fatalf("failed to remove settings.json: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); !errors.Is(err, ErrDataReset) {
+			t.Fatalf("Error is of unexpected type: %q", err)
+		}
+		lastLog, err := manager.LastOperationLog()
+		if err != nil {
+			t.Fatalf("failed to get last operation log: %s", err)
+		}
+		contents := readLog(t, lastLog)
+		for _, expected := range []string{"data was reset", "failed: "} {
+			if !strings.Contains(contents, expected) {
+				t.Errorf("operation log does not contain %q: %s", expected, contents)
+			}
+		}
+	})
+
+	// nextEvent returns the next event of the given type, failing the test
+	// if it doesn't come in time.
+	nextEvent := func(t *testing.T, events <-chan Event, eventType EventType) Event {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					t.Fatalf("events closed waiting for a %s event", eventType)
+				}
+				if event.Type == eventType {
+					return event
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for a %s event", eventType)
+			}
+		}
+	}
+
+	t.Run("Watch should report operations on snapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := manager.Watch(ctx, WatchOptions{Interval: 10 * time.Millisecond})
+
+		snapshot, err := manager.Create(context.Background(), "test-watch", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		event := nextEvent(t, events, EventStarted)
+		if event.ID != snapshot.ID || event.Name != snapshot.Name || event.Operation != "create" {
+			t.Errorf("unexpected started event %+v", event)
+		}
+		event = nextEvent(t, events, EventCreated)
+		if event.ID != snapshot.ID || event.Name != snapshot.Name || event.Snapshot == nil || event.Snapshot.ID != snapshot.ID {
+			t.Errorf("unexpected created event %+v", event)
+		}
+
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		event = nextEvent(t, events, EventStarted)
+		if event.Operation != "restore" || event.Name != snapshot.Name {
+			t.Errorf("unexpected started event %+v", event)
+		}
+		event = nextEvent(t, events, EventProgress)
+		if event.Operation != "restore" || event.Level != "info" || event.Message == "" {
+			t.Errorf("unexpected progress event %+v", event)
+		}
+		event = nextEvent(t, events, EventRestored)
+		if event.ID != snapshot.ID || event.Name != snapshot.Name {
+			t.Errorf("unexpected restored event %+v", event)
+		}
+
+		if err := manager.Delete(snapshot.Name); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		event = nextEvent(t, events, EventDeleted)
+		if event.ID != snapshot.ID || event.Name != snapshot.Name {
+			t.Errorf("unexpected deleted event %+v", event)
+		}
+
+		cancel()
+		for range events {
+		}
+	})
+
+	t.Run("Watch should only drop progress events for a slow receiver", func(t *testing.T) {
+		defer func(size int) { watchBufferSize = size }(watchBufferSize)
+		watchBufferSize = 1
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := manager.Watch(ctx, WatchOptions{Interval: 10 * time.Millisecond})
+
+		// Nothing is received while the operations run.
+		snapshot, err := manager.Create(context.Background(), "test-watch", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		var types []EventType
+		dropped := 0
+		for event := range events {
+			types = append(types, event.Type)
+			dropped += event.Dropped
+			if event.Type == EventRestored {
+				break
+			}
+		}
+		expected := []EventType{EventStarted, EventCreated, EventStarted, EventRestored}
+		if actual := slices.DeleteFunc(slices.Clone(types), func(eventType EventType) bool {
+			return eventType == EventProgress || eventType == EventUpdated
+		}); !slices.Equal(actual, expected) {
+			t.Errorf("expected events %v, got %v", expected, types)
+		}
+		if dropped == 0 {
+			t.Errorf("expected progress events to be dropped, got %v", types)
+		}
+	})
+
+	t.Run("Watch should keep watching while the snapshots are unavailable", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if err := os.MkdirAll(manager.Snapshots, 0o755); err != nil {
+			t.Fatalf("failed to create snapshots directory: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := manager.Watch(ctx, WatchOptions{Interval: 10 * time.Millisecond})
+
+		// Replace the snapshots directory with a file, so that it can't be
+		// read, as when it is on a share that is not mounted.
+		movedPath := manager.Snapshots + ".moved"
+		if err := os.Rename(manager.Snapshots, movedPath); err != nil {
+			t.Fatalf("failed to move snapshots directory: %s", err)
+		}
+		if err := os.WriteFile(manager.Snapshots, nil, 0o644); err != nil {
+			t.Fatalf("failed to replace snapshots directory: %s", err)
+		}
+		event := nextEvent(t, events, EventError)
+		if event.Message == "" {
+			t.Errorf("error event has no message: %+v", event)
+		}
+		if err := os.Remove(manager.Snapshots); err != nil {
+			t.Fatalf("failed to remove file: %s", err)
+		}
+		if err := os.Rename(movedPath, manager.Snapshots); err != nil {
+			t.Fatalf("failed to move snapshots directory back: %s", err)
+		}
+
+		snapshot, err := manager.Create(context.Background(), "test-watch", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for {
+			event := nextEvent(t, events, EventCreated)
+			if event.ID == snapshot.ID {
+				break
+			}
+		}
+	})
+}
+
+func TestNotes(t *testing.T) {
+	t.Run("notes are set, replaced and removed", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "with-notes", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if notes, err := manager.GetNotes(snapshot.ID); err != nil || notes != "" {
+			t.Errorf("expected no notes, got %q, %v", notes, err)
+		}
+		for _, notes := range []string{"# Repro\n\n1. Start the app.\n", "Replaced.\n", ""} {
+			if err := manager.SetNotes(snapshot.ID, notes); err != nil {
+				t.Fatalf("failed to set notes: %s", err)
+			}
+			if got, err := manager.GetNotes(snapshot.ID); err != nil || got != notes {
+				t.Errorf("expected notes %q, got %q, %v", notes, got, err)
+			}
+		}
+		if _, err := os.Stat(notesFilePath(manager.Snapshots, snapshot.ID)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected empty notes to remove the file, got %v", err)
+		}
+		if err := manager.SetNotes(snapshot.ID, strings.Repeat("x", maxNotesSize+1)); err == nil {
+			t.Error("expected an error setting notes that are too large")
+		}
+		var notFound *NotFoundError
+		if err := manager.SetNotes(uuid.NewString(), "notes"); !errors.As(err, &notFound) {
+			t.Errorf("expected NotFoundError, got %v", err)
+		}
+		if _, err := manager.GetNotes(snapshot.Name); !errors.As(err, &notFound) {
+			t.Errorf("expected NotFoundError looking notes up by name, got %v", err)
+		}
+	})
+
+	t.Run("notes travel with the snapshot, but are not in its metadata", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "with-notes", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		const notes = "Created to reproduce the proxy issue.\n"
+		if err := manager.SetNotes(snapshot.ID, notes); err != nil {
+			t.Fatalf("failed to set notes: %s", err)
+		}
+		if updated, err := manager.Snapshot(snapshot.Name); err != nil || updated.Digest != snapshot.Digest {
+			t.Errorf("setting notes changed the metadata: %+v, %v", updated, err)
+		}
+		report, err := manager.Fsck(false)
+		if err != nil || len(report.Problems) != 0 {
+			t.Errorf("unexpected problems with notes: %+v, %v", report.Problems, err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot with notes: %s", err)
+		}
+
+		backupDir := filepath.Join(t.TempDir(), "backup")
+		if err := manager.Copy(context.Background(), snapshot.ID, backupDir, CopyOptions{}); err != nil {
+			t.Fatalf("failed to copy snapshot: %s", err)
+		}
+		movedDir := filepath.Join(t.TempDir(), "moved")
+		if _, err := manager.Migrate(context.Background(), movedDir); err != nil {
+			t.Fatalf("failed to migrate snapshot: %s", err)
+		}
+		for _, dir := range []string{backupDir, movedDir} {
+			otherPaths := *paths
+			otherPaths.Snapshots = dir
+			other := newTestManager(&otherPaths)
+			if got, err := other.GetNotes(snapshot.ID); err != nil || got != notes {
+				t.Errorf("expected the notes in %s, got %q, %v", dir, got, err)
+			}
+		}
+	})
+}
+
+func TestFreeze(t *testing.T) {
+	t.Run("operations that change snapshots fail while frozen", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		snapshot, err := manager.Create(context.Background(), "frozen", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.Freeze(); err != nil {
+			t.Fatalf("failed to freeze snapshots: %s", err)
+		}
+		operations := map[string]func() error{
+			"Create": func() error {
+				_, err := manager.Create(context.Background(), "another", "")
+				return err
+			},
+			"Prune": func() error {
+				_, err := manager.CreateWithOptions(context.Background(), "pruning", CreateOptions{MaxSnapshots: 1, PruneOldest: true})
+				return err
+			},
+			"Delete": func() error {
+				return manager.Delete(snapshot.Name)
+			},
+			"DeleteSnapshots": func() error {
+				return manager.DeleteSnapshots([]Snapshot{snapshot})
+			},
+			"Restore": func() error {
+				return manager.Restore(context.Background(), snapshot.Name, RestoreOptions{})
+			},
+			"RestoreLatest": func() error {
+				_, _, err := manager.RestoreLatest(context.Background(), RestoreOptions{})
+				return err
+			},
+			"Migrate": func() error {
+				_, err := manager.Migrate(context.Background(), t.TempDir())
+				return err
+			},
+			"Fsck with Fix": func() error {
+				_, err := manager.Fsck(true)
+				return err
+			},
+		}
+		for name, operation := range operations {
+			if err := operation(); !errors.Is(err, ErrStoreFrozen) {
+				t.Errorf("%s: expected ErrStoreFrozen, got %v", name, err)
+			}
+		}
+		snapshots, err := manager.List(true)
+		if err != nil || len(snapshots) != 1 || snapshots[0].ID != snapshot.ID {
+			t.Fatalf("expected only the original snapshot, got %+v, %v", snapshots, err)
+		}
+
+		// Reading and copying snapshots still works, and the frozen file
+		// is not reported as a problem.
+		if _, err := manager.Stat(snapshot.Name); err != nil {
+			t.Errorf("failed to stat snapshot: %s", err)
+		}
+		if report, err := manager.Fsck(false); err != nil || len(report.Problems) != 0 {
+			t.Errorf("expected no problems, got %+v, %v", report.Problems, err)
+		}
+		if err := manager.Copy(context.Background(), snapshot.ID, t.TempDir(), CopyOptions{}); err != nil {
+			t.Errorf("failed to copy snapshot: %s", err)
+		}
+		if err := manager.SetNotes(snapshot.ID, "notes"); err != nil {
+			t.Errorf("failed to set notes: %s", err)
+		}
+
+		// The snapshots stay frozen for other managers.
+		other := newTestManager(paths)
+		capabilities, err := other.Capabilities()
+		if err != nil {
+			t.Fatalf("failed to get capabilities: %s", err)
+		}
+		if !capabilities.Frozen || capabilities.FrozenSince.IsZero() {
+			t.Errorf("expected the snapshots to be reported frozen, got %+v", capabilities)
+		}
+		if err := operations["Delete"](); !errors.Is(err, ErrStoreFrozen) {
+			t.Errorf("expected ErrStoreFrozen from another manager, got %v", err)
+		}
+
+		if err := other.Unfreeze(); err != nil {
+			t.Fatalf("failed to unfreeze snapshots: %s", err)
+		}
+		if capabilities, err := manager.Capabilities(); err != nil || capabilities.Frozen {
+			t.Errorf("expected the snapshots to be unfrozen, got %+v, %v", capabilities, err)
+		}
+		for _, name := range []string{"Create", "Restore", "Delete"} {
+			if err := operations[name](); err != nil {
+				t.Errorf("%s: failed once unfrozen: %s", name, err)
+			}
+		}
+	})
+
+	t.Run("freezing and unfreezing again changes nothing", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if err := manager.Unfreeze(); err != nil {
+			t.Errorf("failed to unfreeze snapshots that are not frozen: %s", err)
+		}
+		if err := manager.Freeze(); err != nil {
+			t.Fatalf("failed to freeze snapshots: %s", err)
+		}
+		since, err := manager.FrozenSince()
+		if err != nil || since.IsZero() {
+			t.Fatalf("expected the snapshots to be frozen, got %v, %v", since, err)
+		}
+		if err := manager.Freeze(); err != nil {
+			t.Fatalf("failed to freeze snapshots again: %s", err)
+		}
+		if again, err := manager.FrozenSince(); err != nil || !again.Equal(since) {
+			t.Errorf("expected the snapshots to be frozen since %s, got %s, %v", since, again, err)
+		}
+	})
+
+	t.Run("a frozen file that can't be read still freezes snapshots", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if err := os.MkdirAll(manager.Snapshots, 0o755); err != nil {
+			t.Fatalf("failed to create snapshots directory: %s", err)
+		}
+		if err := os.WriteFile(frozenFilePath(manager.Snapshots), []byte("not json"), 0o644); err != nil {
+			t.Fatalf("failed to write frozen file: %s", err)
+		}
+		if _, err := manager.Create(context.Background(), "snapshot", ""); !errors.Is(err, ErrStoreFrozen) {
+			t.Errorf("expected ErrStoreFrozen, got %v", err)
+		}
+		if _, err := manager.Capabilities(); err == nil {
+			t.Error("expected an error reading the frozen state")
+		}
+		if err := manager.Unfreeze(); err != nil {
+			t.Fatalf("failed to unfreeze snapshots: %s", err)
+		}
+		if _, err := manager.Create(context.Background(), "snapshot", ""); err != nil {
+			t.Errorf("failed to create snapshot once unfrozen: %s", err)
+		}
+	})
+
+	t.Run("read-only snapshots can't be frozen", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		manager.config.ReadOnly = true
+		if err := manager.Freeze(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected ErrReadOnly, got %v", err)
+		}
+	})
+}
+
+func TestMetadataIndex(t *testing.T) {
+	savedNow := listCacheNow
+	defer func() { listCacheNow = savedNow }()
+	// Trust the modification times of the files just written.
+	listCacheNow = func() time.Time { return time.Now().Add(time.Hour) }
+	newIndexedManager := func(t *testing.T) (*Manager, *paths.Paths) {
+		t.Helper()
+		appPaths, _ := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		manager.config.IndexFile = filepath.Join(t.TempDir(), "index", "snapshots.json")
+		return manager, appPaths
+	}
+	// listNames returns the names of the snapshots, sorted, with a star after
+	// those that are protected.
+	listNames := func(t *testing.T, manager *Manager, opts ListOptions) []string {
+		t.Helper()
+		snapshots, err := manager.ListWithOptions(opts)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		names := make([]string, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			if snapshot.Protected {
+				names = append(names, snapshot.Name+"*")
+			} else {
+				names = append(names, snapshot.Name)
+			}
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	t.Run("List reads the index rather than the snapshots", func(t *testing.T) {
+		manager, _ := newIndexedManager(t)
+		var created []Snapshot
+		for _, name := range []string{"complete", "incomplete"} {
+			snapshot, err := manager.Create(context.Background(), name, "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+			created = append(created, snapshot)
+		}
+		if err := os.Remove(completeFilePath(manager.Snapshots, created[1].ID)); err != nil {
+			t.Fatalf("failed to remove %s: %s", completeFileName, err)
+		}
+		manager.invalidateListCache()
+		if names := listNames(t, manager, ListOptions{}); !slices.Equal(names, []string{"complete"}) {
+			t.Fatalf("unexpected snapshots %q", names)
+		}
+		if _, err := os.Stat(manager.config.IndexFile); err != nil {
+			t.Fatalf("the index was not written: %s", err)
+		}
+		// Another manager, with nothing cached, lists from the index alone:
+		// the metadata, which no longer matches it, is not read.
+		for _, snapshot := range created {
+			if err := os.WriteFile(metadataFilePath(manager.Snapshots, snapshot.ID), []byte("not json"), 0o644); err != nil {
+				t.Fatalf("failed to break metadata: %s", err)
+			}
+		}
+		other := newTestManager(manager.Paths)
+		other.config = manager.config
+		if names := listNames(t, other, ListOptions{IncludeIncomplete: true}); !slices.Equal(names, []string{"complete", "incomplete"}) {
+			t.Errorf("unexpected snapshots listed from the index: %q", names)
+		}
+		// The metadata is the source of truth.
+		if err := other.RebuildIndex(); err == nil {
+			t.Error("expected an error rebuilding the index from broken metadata")
+		}
+		if _, err := other.ListWithOptions(ListOptions{ForceRefresh: true}); err == nil {
+			t.Error("expected an error listing with ForceRefresh")
+		}
+	})
+
+	t.Run("List stays consistent with the snapshots", func(t *testing.T) {
+		manager, appPaths := newIndexedManager(t)
+		other := newTestManager(appPaths)
+		other.config = manager.config
+		// A manager without the index sees the snapshots as they are.
+		unindexed := newTestManager(appPaths)
+		check := func(step string) {
+			t.Helper()
+			expected := listNames(t, unindexed, ListOptions{IncludeIncomplete: true})
+			for _, m := range []*Manager{manager, other} {
+				if names := listNames(t, m, ListOptions{IncludeIncomplete: true}); !slices.Equal(names, expected) {
+					t.Errorf("after %s, listed %q from the index, expected %q", step, names, expected)
+				}
+			}
+		}
+		first, err := manager.Create(context.Background(), "first", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		check("creating a snapshot")
+		if _, err := other.Create(context.Background(), "second", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		check("creating a snapshot elsewhere")
+		if _, err := other.SetProtected(first.ID, true); err != nil {
+			t.Fatalf("failed to protect snapshot: %s", err)
+		}
+		check("protecting a snapshot")
+		if err := other.Delete("second"); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		check("deleting a snapshot")
+		// Snapshots added without the index are noticed, as the snapshots
+		// directory changes.
+		if _, err := unindexed.Create(context.Background(), "third", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		check("creating a snapshot without the index")
+	})
+
+	t.Run("RebuildIndex brings a stale index up to date", func(t *testing.T) {
+		manager, appPaths := newIndexedManager(t)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if names := listNames(t, manager, ListOptions{}); !slices.Equal(names, []string{"test-snapshot"}) {
+			t.Fatalf("unexpected snapshots %q", names)
+		}
+		// Changing a snapshot's metadata without the index leaves it stale.
+		unindexed := newTestManager(appPaths)
+		if _, err := unindexed.SetProtected(snapshot.ID, true); err != nil {
+			t.Fatalf("failed to protect snapshot: %s", err)
+		}
+		fresh := newTestManager(appPaths)
+		fresh.config = manager.config
+		if names := listNames(t, fresh, ListOptions{}); !slices.Equal(names, []string{"test-snapshot"}) {
+			t.Fatalf("expected the stale index to be used, got %q", names)
+		}
+		if err := fresh.RebuildIndex(); err != nil {
+			t.Fatalf("failed to rebuild index: %s", err)
+		}
+		if names := listNames(t, fresh, ListOptions{}); !slices.Equal(names, []string{"test-snapshot*"}) {
+			t.Errorf("unexpected snapshots after rebuilding the index: %q", names)
+		}
+		if err := unindexed.RebuildIndex(); err == nil {
+			t.Error("expected an error rebuilding an index that isn't configured")
+		}
+	})
+
+	t.Run("The index of another snapshots directory is not used", func(t *testing.T) {
+		manager, _ := newIndexedManager(t)
+		if _, err := manager.Create(context.Background(), "test-snapshot", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.RebuildIndex(); err != nil {
+			t.Fatalf("failed to rebuild index: %s", err)
+		}
+		elsewhere, _ := newIndexedManager(t)
+		elsewhere.config = manager.config
+		if names := listNames(t, elsewhere, ListOptions{}); len(names) != 0 {
+			t.Errorf("listed snapshots of another directory: %q", names)
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	statuses := func(report MigrateReport) map[string]MigrateStatus {
+		result := map[string]MigrateStatus{}
+		for _, migrated := range report.Snapshots {
+			result[migrated.Name] = migrated.Status
+		}
+		return result
+	}
+
+	t.Run("moves the snapshots, and retries those that failed", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		first, err := manager.Create(context.Background(), "first", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if _, err := manager.SetProtected(first.ID, true); err != nil {
+			t.Fatalf("failed to protect snapshot: %s", err)
+		}
+		second, err := manager.Create(context.Background(), "second", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		// Copy refuses to copy a snapshot directory holding anything but
+		// regular files.
+		unexpected := filepath.Join(manager.SnapshotDirectory(second), "unexpected")
+		if err := os.Mkdir(unexpected, 0o755); err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+		destDir := filepath.Join(t.TempDir(), "moved")
+		report, err := manager.Migrate(context.Background(), destDir)
+		if err != nil {
+			t.Fatalf("failed to migrate snapshots: %s", err)
+		}
+		expected := map[string]MigrateStatus{"first": MigrateMoved, "second": MigrateFailed}
+		if !reflect.DeepEqual(statuses(report), expected) || report.Failed() != 1 {
+			t.Errorf("unexpected migration report: %+v", report)
+		}
+		if _, err := manager.Snapshot("second"); err != nil {
+			t.Errorf("the snapshot that failed to move is gone: %s", err)
+		}
+		if _, err := os.Lstat(snapshotDirPath(destDir, second.ID)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("the snapshot that failed to move was left at the destination: %v", err)
+		}
+
+		if err := os.Remove(unexpected); err != nil {
+			t.Fatalf("failed to remove directory: %s", err)
+		}
+		report, err = manager.Migrate(context.Background(), destDir)
+		if err != nil {
+			t.Fatalf("failed to migrate snapshots again: %s", err)
+		}
+		if !reflect.DeepEqual(statuses(report), map[string]MigrateStatus{"second": MigrateMoved}) {
+			t.Errorf("unexpected report migrating again: %+v", report)
+		}
+		if remaining, err := manager.List(false); err != nil || len(remaining) != 0 {
+			t.Errorf("unexpected snapshots left: %+v, %v", remaining, err)
+		}
+		movedPaths := *paths
+		movedPaths.Snapshots = destDir
+		moved, err := newTestManager(&movedPaths).List(false)
+		if err != nil {
+			t.Fatalf("failed to list moved snapshots: %s", err)
+		}
+		names := map[string]bool{}
+		for _, snapshot := range moved {
+			names[snapshot.Name] = snapshot.Protected
+		}
+		if !reflect.DeepEqual(names, map[string]bool{"first": true, "second": false}) {
+			t.Errorf("unexpected moved snapshots: %+v", moved)
+		}
+	})
+
+	t.Run("checks copies left by an earlier migration", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		intact, err := manager.Create(context.Background(), "intact", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		corrupt, err := manager.Create(context.Background(), "corrupt", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		destDir := filepath.Join(t.TempDir(), "moved")
+		for _, snapshot := range []Snapshot{intact, corrupt} {
+			if err := manager.Copy(context.Background(), snapshot.ID, destDir, CopyOptions{}); err != nil {
+				t.Fatalf("failed to copy snapshot: %s", err)
+			}
+		}
+		corruptCopy := metadataFilePath(destDir, corrupt.ID)
+		if err := os.WriteFile(corruptCopy, []byte("{}"), 0o644); err != nil {
+			t.Fatalf("failed to corrupt copy: %s", err)
+		}
+		report, err := manager.Migrate(context.Background(), destDir)
+		if err != nil {
+			t.Fatalf("failed to migrate snapshots: %s", err)
+		}
+		expected := map[string]MigrateStatus{"intact": MigrateAlreadyCopied, "corrupt": MigrateMoved}
+		if !reflect.DeepEqual(statuses(report), expected) {
+			t.Errorf("unexpected migration report: %+v", report)
+		}
+		if remaining, err := manager.List(false); err != nil || len(remaining) != 0 {
+			t.Errorf("unexpected snapshots left: %+v, %v", remaining, err)
+		}
+		if contents, err := os.ReadFile(corruptCopy); err != nil || string(contents) == "{}" {
+			t.Errorf("the corrupt copy was not replaced: %q, %v", contents, err)
+		}
+	})
+
+	t.Run("refuses to migrate into the snapshots directory", func(t *testing.T) {
+		paths, _ := populateFiles(t, true)
+		manager := newTestManager(paths)
+		if _, err := manager.Create(context.Background(), "test-snapshot", ""); err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if _, err := manager.Migrate(context.Background(), paths.Snapshots); err == nil {
+			t.Errorf("expected an error migrating into the snapshots directory")
+		}
+	})
+}
+
+func TestCaptureLogs(t *testing.T) {
+	// writeLogs writes the logs into the logs directory, the first one
+	// written to last.
+	writeLogs := func(t *testing.T, logsDir string, logs map[string]string, order []string) {
+		t.Helper()
+		if err := os.MkdirAll(logsDir, 0o755); err != nil {
+			t.Fatalf("failed to create logs directory: %s", err)
+		}
+		modified := time.Now()
+		for _, name := range order {
+			path := filepath.Join(logsDir, name)
+			if err := os.WriteFile(path, []byte(logs[name]), 0o644); err != nil {
+				t.Fatalf("failed to write %s: %s", name, err)
+			}
+			modified = modified.Add(-time.Minute)
+			if err := os.Chtimes(path, modified, modified); err != nil {
+				t.Fatalf("failed to set the time of %s: %s", name, err)
+			}
+		}
+	}
+	readCaptured := func(t *testing.T, manager *Manager, snapshot Snapshot) ([]string, map[string]string) {
+		t.Helper()
+		var names []string
+		captured := map[string]string{}
+		err := manager.ReadCapturedLogs(snapshot, func(name string, contents io.Reader) error {
+			data, err := io.ReadAll(contents)
+			names = append(names, name)
+			captured[name] = string(data)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("failed to read captured logs: %s", err)
+		}
+		return names, captured
+	}
+
+	t.Run("captures the end of the logs", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		appPaths.Logs = filepath.Join(appPaths.AppHome, "logs")
+		line := strings.Repeat("x", 1023) + "\n"
+		logs := map[string]string{
+			"background.log": "started\n",
+			"k3s.log":        strings.Repeat(line, maxCapturedLogSize/len(line)+10),
+			"notes.txt":      "not a log",
+		}
+		writeLogs(t, appPaths.Logs, logs, []string{"background.log", "k3s.log", "notes.txt"})
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.CreateWithOptions(context.Background(), "with-logs", CreateOptions{CaptureLogs: true})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		names, captured := readCaptured(t, manager, snapshot)
+		if !slices.Equal(names, []string{"background.log", "k3s.log"}) {
+			t.Errorf("unexpected logs captured: %v", names)
+		}
+		if captured["background.log"] != logs["background.log"] {
+			t.Errorf("unexpected contents of background.log: %q", captured["background.log"])
+		}
+		k3sLog := captured["k3s.log"]
+		if len(k3sLog) > maxCapturedLogSize || !strings.HasSuffix(logs["k3s.log"], k3sLog) || !strings.HasPrefix(k3sLog, line) {
+			t.Errorf("k3s.log was not captured from its end, starting at a line (%d bytes)", len(k3sLog))
+		}
+		expected := &CapturedLogs{
+			Files:     []string{"background.log", "k3s.log"},
+			Size:      int64(len(captured["background.log"]) + len(k3sLog)),
+			Truncated: true,
+		}
+		if !reflect.DeepEqual(snapshot.CapturedLogs, expected) {
+			t.Errorf("unexpected captured logs %+v (expected %+v)", snapshot.CapturedLogs, expected)
+		}
+		stored, err := manager.Snapshot(snapshot.Name)
+		if err != nil {
+			t.Fatalf("failed to get snapshot: %s", err)
+		}
+		if !reflect.DeepEqual(stored.CapturedLogs, expected) {
+			t.Errorf("the captured logs were not recorded in the metadata: %+v", stored.CapturedLogs)
+		}
+	})
+
+	t.Run("doesn't restore the logs", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		appPaths.Logs = filepath.Join(appPaths.AppHome, "logs")
+		writeLogs(t, appPaths.Logs, map[string]string{"background.log": "before\n"}, []string{"background.log"})
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.CreateWithOptions(context.Background(), "with-logs", CreateOptions{CaptureLogs: true})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		logPath := filepath.Join(appPaths.Logs, "background.log")
+		if err := os.WriteFile(logPath, []byte("before\nafter\n"), 0o644); err != nil {
+			t.Fatalf("failed to write log: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		if contents, err := os.ReadFile(logPath); err != nil {
+			t.Fatalf("failed to read log: %s", err)
+		} else if string(contents) != "before\nafter\n" {
+			t.Errorf("the log was restored: %q", contents)
+		}
+		err = manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Components: []string{componentLogs}})
+		if err == nil || !strings.Contains(err.Error(), "never restored") {
+			t.Errorf("expected restoring the logs to be refused, got %v", err)
+		}
+	})
+
+	t.Run("captures nothing unless asked to", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		appPaths.Logs = filepath.Join(appPaths.AppHome, "logs")
+		writeLogs(t, appPaths.Logs, map[string]string{"background.log": "started\n"}, []string{"background.log"})
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.Create(context.Background(), "without-logs", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if snapshot.CapturedLogs != nil {
+			t.Errorf("unexpected captured logs: %+v", snapshot.CapturedLogs)
+		}
+		err = manager.ReadCapturedLogs(snapshot, func(string, io.Reader) error { return nil })
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no captured logs, got %v", err)
+		}
+	})
+}
+
+func TestCreateCleanup(t *testing.T) {
+	errInjected := errors.New("injected failure")
+	// listTree returns the paths of everything under root, except for the
+	// operation logs, which are kept on purpose.
+	listTree := func(t *testing.T, root string) []string {
+		t.Helper()
+		var tree []string
+		err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if entry.IsDir() && entry.Name() == logsDirName {
+				return filepath.SkipDir
+			}
+			tree = append(tree, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to read %q: %s", root, err)
+		}
+		return tree
+	}
+	type failure struct {
+		// The step the failure is injected before, if any.
+		step string
+		// Whether the failure is injected after the files were created.
+		afterFiles bool
+		// Whether the failure is a cancellation, rather than an error.
+		cancel bool
+	}
+	failures := map[string]failure{
+		"validating the name":      {step: "validate"},
+		"writing the metadata":     {step: "metadata"},
+		"copying the files":        {step: "files"},
+		"finishing the snapshot":   {afterFiles: true},
+		"cancelled before copying": {step: "files", cancel: true},
+		"cancelled after copying":  {afterFiles: true, cancel: true},
+	}
+	deduplicate := []bool{false}
+	if deduplicatedSnapshots {
+		deduplicate = append(deduplicate, true)
+	}
+	for name, failure := range failures {
+		for _, dedup := range deduplicate {
+			t.Run(fmt.Sprintf("%s with Deduplicate %t", name, dedup), func(t *testing.T) {
+				appPaths, testFiles := populateFiles(t, true)
+				manager := newTestManager(appPaths)
+				opts := CreateOptions{Deduplicate: dedup}
+				// An existing snapshot shares its objects with the new one,
+				// and they must be kept.
+				if _, err := manager.CreateWithOptions(context.Background(), "existing", opts); err != nil {
+					t.Fatalf("failed to create snapshot: %s", err)
+				}
+				if err := os.WriteFile(testFiles["settings.json"].Path, []byte(`{"test": "changed"}`), 0o644); err != nil {
+					t.Fatalf("failed to modify settings.json: %s", err)
+				}
+				before := listTree(t, appPaths.Snapshots)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				inject := func() error {
+					if failure.cancel {
+						cancel()
+						return nil
+					}
+					return errInjected
+				}
+				manager.createStepHook = func(step string) error {
+					if step == failure.step {
+						return inject()
+					}
+					return nil
+				}
+				if failure.afterFiles {
+					manager.Snapshotter = hookedSnapshotter{Snapshotter: manager.Snapshotter, hook: func() error {
+						if err := inject(); err != nil {
+							return err
+						}
+						return ctx.Err()
+					}}
+				}
+				_, err := manager.CreateWithOptions(ctx, "failed", opts)
+				if failure.cancel && !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected creating the snapshot to be cancelled, got %v", err)
+				} else if !failure.cancel && !errors.Is(err, errInjected) {
+					t.Fatalf("expected the injected failure, got %v", err)
+				}
+				if after := listTree(t, appPaths.Snapshots); !slices.Equal(after, before) {
+					t.Errorf("expected the snapshots directory to be left as it was:\nbefore: %v\nafter:  %v", before, after)
+				}
+				if snapshots, err := manager.List(true); err != nil {
+					t.Fatalf("failed to list snapshots: %s", err)
+				} else if len(snapshots) != 1 {
+					t.Errorf("expected only the existing snapshot, got %+v", snapshots)
+				}
+				if report, err := manager.Fsck(false); err != nil {
+					t.Fatalf("failed to check snapshots: %s", err)
+				} else if len(report.Problems) != 0 {
+					t.Errorf("unexpected problems: %+v", report.Problems)
+				}
+			})
+		}
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	t.Run("should limit the read rate", func(t *testing.T) {
+		const size = 256 << 10
+		start := time.Now()
+		reader := newThrottledReader(context.Background(), strings.NewReader(strings.Repeat("x", size)), 1<<20)
+		if n, err := io.Copy(io.Discard, reader); err != nil || n != size {
+			t.Fatalf("unexpected result reading: %d, %v", n, err)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("reading %d bytes at 1 MiB/s took only %s", size, elapsed)
+		}
+	})
+
+	t.Run("should stop reading when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		reader := newThrottledReader(ctx, strings.NewReader("contents"), 0)
+		if _, err := io.ReadAll(reader); !errors.Is(err, runner.ErrContextDone) {
+			t.Errorf("unexpected error: %v", err)
 		}
 	})
 }
@@ -3,18 +3,26 @@
 package snapshot
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/lock"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
 )
 
-func populateFiles(t *testing.T, includeOverrideYaml bool) (*paths.Paths, map[string]TestFile) {
+func populateFiles(t testing.TB, includeOverrideYaml bool) (*paths.Paths, map[string]TestFile) {
 	baseDir := t.TempDir()
 	appPaths := paths.Paths{
 		AppHome:   baseDir,
@@ -119,7 +127,7 @@ func TestManagerUnix(t *testing.T) {
 					t.Fatalf("failed to modify %s: %s", testFileName, err)
 				}
 			}
-			if err := manager.Restore(context.Background(), snapshot.Name); err != nil {
+			if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
 				t.Fatalf("failed to restore snapshot: %s", err)
 			}
 			for testFileName, testFile := range testFiles {
@@ -149,7 +157,7 @@ func TestManagerUnix(t *testing.T) {
 				t.Fatalf("failed to modify %s: %s", testFileName, err)
 			}
 		}
-		if err := manager.Restore(context.Background(), snapshot.Name); err != nil {
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
 			t.Fatalf("failed to restore snapshot: %s", err)
 		}
 		overrideYamlPath := testFiles["override.yaml"].Path
@@ -192,7 +200,7 @@ func TestManagerUnix(t *testing.T) {
 				t.Fatalf("failed to modify %s: %s", testFileName, err)
 			}
 		}
-		if err := manager.Restore(context.Background(), snapshot.Name); err != nil {
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
 			t.Fatalf("failed to restore snapshot: %s", err)
 		}
 		for testFileName, testFile := range testFiles {
@@ -218,8 +226,1257 @@ func TestManagerUnix(t *testing.T) {
 				t.Fatalf("failed to remove directory: %s", err)
 			}
 		}
-		if err := manager.Restore(context.Background(), snapshot.Name); err != nil {
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
 			t.Fatalf("failed to restore snapshot: %s", err)
 		}
 	})
+
+	t.Run("Restore should be resumable after being interrupted", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		// Make the disk large enough that restoring it at the rate limit
+		// takes much longer than the interruption below.
+		disk := testFiles["disk"]
+		disk.Contents = strings.Repeat("disk contents\n", 600_000)
+		testFiles["disk"] = disk
+		if err := os.WriteFile(disk.Path, []byte(disk.Contents), 0o644); err != nil {
+			t.Fatalf("failed to write disk: %s", err)
+		}
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			if err := os.WriteFile(testFile.Path, []byte(`{"something": "different"}`), 0o644); err != nil {
+				t.Fatalf("failed to modify %s: %s", testFileName, err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		err = manager.Restore(ctx, snapshot.Name, RestoreOptions{RateLimit: 2 << 20})
+		if !errors.Is(err, ErrDataReset) || !errors.Is(err, ErrRestoreIncomplete) {
+			t.Fatalf("unexpected error from interrupted restore: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(appPaths.AppHome, restoreJournalFileName)); err != nil {
+			t.Fatalf("restore journal was not kept: %s", err)
+		}
+		// The components restored before the interruption are kept, and the
+		// one being restored is not swapped into place; the file of it that
+		// was staged is kept for resuming.
+		contents, err := os.ReadFile(testFiles["settings.json"].Path)
+		if err != nil || string(contents) != testFiles["settings.json"].Contents {
+			t.Errorf("settings restored before the interruption were not kept: %v", err)
+		}
+		stagedIso := stagingFilePath(testFiles["iso"].Path)
+		contents, err = os.ReadFile(stagedIso)
+		if err != nil || string(contents) != testFiles["iso"].Contents {
+			t.Errorf("iso staged before the interruption was not kept: %v", err)
+		}
+		for _, name := range []string{"iso", "disk"} {
+			if _, err := os.Stat(testFiles[name].Path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s of the interrupted component was not removed: %v", name, err)
+			}
+		}
+		if _, err := os.Stat(stagingFilePath(disk.Path)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("partially staged disk was not removed: %v", err)
+		}
+		if _, err := manager.Create(context.Background(), "partial", ""); !errors.Is(err, ErrRestoreIncomplete) {
+			t.Errorf("creating a snapshot of a partial restore should fail, got %v", err)
+		}
+
+		// A restored file that changed since must be restored again, while
+		// the ones that are unchanged are not copied again.
+		settings := testFiles["settings.json"]
+		if err := os.WriteFile(settings.Path, []byte(`{"changed": "since"}`), 0o644); err != nil {
+			t.Fatalf("failed to modify settings.json: %s", err)
+		}
+		isoInfo, err := os.Stat(stagedIso)
+		if err != nil {
+			t.Fatalf("failed to stat staged iso: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Resume: true}); err != nil {
+			t.Fatalf("failed to resume restore: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			contents, err := os.ReadFile(testFile.Path)
+			if err != nil {
+				t.Fatalf("failed to read contents of %s: %s", testFileName, err)
+			}
+			if string(contents) != testFile.Contents {
+				t.Errorf("contents of %s appear to have not been restored", testFileName)
+			}
+		}
+		if info, err := os.Stat(testFiles["iso"].Path); err != nil || !info.ModTime().Equal(isoInfo.ModTime()) {
+			t.Errorf("already staged iso was copied again: %v", err)
+		}
+		if _, err := os.Stat(stagedIso); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("staged iso was not moved into place: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(appPaths.AppHome, restoreJournalFileName)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("restore journal was not removed: %v", err)
+		}
+		if _, err := manager.Create(context.Background(), "complete", ""); err != nil {
+			t.Errorf("failed to create snapshot after completing the restore: %s", err)
+		}
+	})
+
+	t.Run("Restore should keep the components restored before one that fails", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			if err := os.WriteFile(testFile.Path, []byte(`{"something": "different"}`), 0o644); err != nil {
+				t.Fatalf("failed to modify %s: %s", testFileName, err)
+			}
+		}
+		// Fail the second of the three components, the Lima configuration.
+		userPath := filepath.Join(manager.SnapshotDirectory(snapshot), "user")
+		userContents, err := os.ReadFile(userPath)
+		if err != nil {
+			t.Fatalf("failed to read user from snapshot: %s", err)
+		}
+		if err := os.Remove(userPath); err != nil {
+			t.Fatalf("failed to remove user from snapshot: %s", err)
+		}
+		err = manager.Restore(context.Background(), snapshot.Name, RestoreOptions{})
+		if !errors.Is(err, ErrDataReset) || !errors.Is(err, ErrRestoreIncomplete) {
+			t.Fatalf("unexpected error from failed restore: %v", err)
+		}
+		var componentErr *ComponentRestoreError
+		if !errors.As(err, &componentErr) {
+			t.Fatalf("error does not name the failed component: %v", err)
+		}
+		if componentErr.Component != componentLimaConfig {
+			t.Errorf("expected %q to fail, got %q", componentLimaConfig, componentErr.Component)
+		}
+		if !slices.Equal(componentErr.Restored, []string{componentSettings}) {
+			t.Errorf("expected only %q to be restored, got %v", componentSettings, componentErr.Restored)
+		}
+		contents, err := os.ReadFile(testFiles["settings.json"].Path)
+		if err != nil || string(contents) != testFiles["settings.json"].Contents {
+			t.Errorf("restored settings were not kept: %v", err)
+		}
+		for _, name := range []string{"override.yaml", "user", "user.pub", "iso", "disk", "lima.yaml"} {
+			if _, err := os.Stat(testFiles[name].Path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s of an unrestored component was not removed: %v", name, err)
+			}
+		}
+
+		if err := os.WriteFile(userPath, userContents, 0o600); err != nil {
+			t.Fatalf("failed to put user back in snapshot: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Resume: true}); err != nil {
+			t.Fatalf("failed to resume restore: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			contents, err := os.ReadFile(testFile.Path)
+			if err != nil {
+				t.Fatalf("failed to read contents of %s: %s", testFileName, err)
+			}
+			if string(contents) != testFile.Contents {
+				t.Errorf("contents of %s appear to have not been restored", testFileName)
+			}
+		}
+	})
+
+	t.Run("Restore with Resume should fail without a matching incomplete restore", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		other, err := manager.Create(context.Background(), "other-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Resume: true}); err == nil || errors.Is(err, ErrDataReset) {
+			t.Errorf("unexpected error resuming without an incomplete restore: %v", err)
+		}
+		if _, err := manager.prepareRestoreJournal(other, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to create restore journal: %s", err)
+		}
+		err = manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Resume: true})
+		if !errors.Is(err, ErrRestoreIncomplete) || errors.Is(err, ErrDataReset) {
+			t.Errorf("unexpected error resuming the restore of another snapshot: %v", err)
+		}
+		contents, err := os.ReadFile(testFiles["settings.json"].Path)
+		if err != nil || string(contents) != testFiles["settings.json"].Contents {
+			t.Errorf("working files should not be touched: %v", err)
+		}
+	})
+
+	t.Run("Restore with Live should reload the settings without stopping the backend", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		var reloaded []string
+		savedReloadSettings := reloadSettings
+		defer func() { reloadSettings = savedReloadSettings }()
+		reloadSettings = func(ctx context.Context, settingsPath string) error {
+			reloaded = append(reloaded, settingsPath)
+			return nil
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		backendLock := &recordingBackendLock{}
+		manager.BackendLocker = backendLock
+		for testFileName, testFile := range testFiles {
+			if err := os.WriteFile(testFile.Path, []byte(`{"something": "different"}`), 0o644); err != nil {
+				t.Fatalf("failed to modify %s: %s", testFileName, err)
+			}
+		}
+		result, err := manager.RestoreWithResult(context.Background(), snapshot.Name, RestoreOptions{
+			Components: []string{componentSettings},
+			Live:       true,
+		})
+		if err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		if backendLock.unlocks != 0 {
+			t.Errorf("the backend was stopped to restore the settings")
+		}
+		if !slices.Equal(reloaded, []string{testFiles["settings.json"].Path}) {
+			t.Errorf("expected the settings to be reloaded once, got %v", reloaded)
+		}
+		expected := RestoreResult{Reloaded: []string{componentSettings}}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("expected result %+v, got %+v", expected, result)
+		}
+		for testFileName, testFile := range testFiles {
+			contents, err := os.ReadFile(testFile.Path)
+			if err != nil {
+				t.Fatalf("failed to read contents of %s: %s", testFileName, err)
+			}
+			if restored := string(contents) == testFile.Contents; restored != (testFileName == "settings.json") {
+				t.Errorf("%s restored: %t", testFileName, restored)
+			}
+		}
+	})
+
+	t.Run("Restore with Live should stop the backend for components that can't be reloaded", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		savedReloadSettings := reloadSettings
+		defer func() { reloadSettings = savedReloadSettings }()
+		reloadSettings = func(ctx context.Context, settingsPath string) error {
+			t.Errorf("the settings were reloaded although the backend was restarted")
+			return nil
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		backendLock := &recordingBackendLock{}
+		manager.BackendLocker = backendLock
+		result, err := manager.RestoreWithResult(context.Background(), snapshot.Name, RestoreOptions{Live: true})
+		if err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		if backendLock.unlocks != 1 {
+			t.Errorf("expected the backend to be restarted once, got %d", backendLock.unlocks)
+		}
+		expected := RestoreResult{
+			Restarted:       []string{componentSettings, componentLimaConfig, componentVM},
+			RestartRequired: []string{componentLimaConfig, componentVM},
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("expected result %+v, got %+v", expected, result)
+		}
+		contents, err := os.ReadFile(testFiles["disk"].Path)
+		if err != nil || string(contents) != testFiles["disk"].Contents {
+			t.Errorf("disk was not restored: %v", err)
+		}
+	})
+
+	t.Run("Restore of some components should leave the others alone when it fails", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := os.Remove(filepath.Join(manager.SnapshotDirectory(snapshot), "settings.json")); err != nil {
+			t.Fatalf("failed to remove settings.json from snapshot: %s", err)
+		}
+		err = manager.Restore(context.Background(), snapshot.Name, RestoreOptions{Components: []string{componentSettings}})
+		if !errors.Is(err, ErrDataReset) {
+			t.Fatalf("unexpected error from failed restore: %v", err)
+		}
+		for testFileName, testFile := range testFiles {
+			if testFileName == "settings.json" {
+				continue
+			}
+			contents, err := os.ReadFile(testFile.Path)
+			if err != nil || string(contents) != testFile.Contents {
+				t.Errorf("%s of a component that wasn't restored was changed: %v", testFileName, err)
+			}
+		}
+	})
+
+	for _, dedup := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Restore with MinimalChanges should only restore the files that differ with Deduplicate %t", dedup), func(t *testing.T) {
+			appPaths, testFiles := populateFiles(t, true)
+			manager := newTestManager(appPaths)
+			snapshot, err := manager.CreateWithOptions(context.Background(), "test-snapshot", CreateOptions{Deduplicate: dedup})
+			if err != nil {
+				t.Fatalf("failed to create snapshot: %s", err)
+			}
+			// A file of the same size, one of another size, and one that
+			// is missing differ from the snapshot.
+			settings := testFiles["settings.json"]
+			if err := os.WriteFile(settings.Path, []byte(strings.ToUpper(settings.Contents)), 0o644); err != nil {
+				t.Fatalf("failed to modify settings.json: %s", err)
+			}
+			if err := os.WriteFile(testFiles["disk"].Path, []byte("a larger disk"), 0o644); err != nil {
+				t.Fatalf("failed to modify disk: %s", err)
+			}
+			if err := os.Remove(testFiles["override.yaml"].Path); err != nil {
+				t.Fatalf("failed to remove override.yaml: %s", err)
+			}
+			reverted := []string{settings.Path, testFiles["disk"].Path, testFiles["override.yaml"].Path}
+			slices.Sort(reverted)
+			before := make(map[string]os.FileInfo)
+			for testFileName, testFile := range testFiles {
+				if info, err := os.Stat(testFile.Path); err == nil {
+					before[testFileName] = info
+				}
+			}
+			result, err := manager.RestoreWithResult(context.Background(), snapshot.Name, RestoreOptions{MinimalChanges: true})
+			if err != nil {
+				t.Fatalf("failed to restore snapshot: %s", err)
+			}
+			if !slices.Equal(result.Reverted, reverted) {
+				t.Errorf("expected %v to be reverted, got %v", reverted, result.Reverted)
+			}
+			for testFileName, testFile := range testFiles {
+				info, err := os.Stat(testFile.Path)
+				if err != nil {
+					t.Fatalf("failed to stat %s: %s", testFileName, err)
+				}
+				contents, err := os.ReadFile(testFile.Path)
+				if err != nil || string(contents) != testFile.Contents {
+					t.Errorf("contents of %s appear to have not been restored: %v", testFileName, err)
+				}
+				// Restored files are renamed into place; the others are
+				// the same files as before.
+				same := before[testFileName] != nil && os.SameFile(before[testFileName], info)
+				if same == slices.Contains(reverted, testFile.Path) {
+					t.Errorf("expected %s to be replaced only if it was reverted", testFileName)
+				}
+			}
+
+			result, err = manager.RestoreWithResult(context.Background(), snapshot.Name, RestoreOptions{MinimalChanges: true})
+			if err != nil {
+				t.Fatalf("failed to restore snapshot again: %s", err)
+			}
+			if len(result.Reverted) != 0 {
+				t.Errorf("expected nothing to be reverted from the restored files, got %v", result.Reverted)
+			}
+		})
+	}
+
+	t.Run("Restore with MinimalChanges should remove files the snapshot doesn't include", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		overrideYaml := testFiles["override.yaml"]
+		if err := os.Remove(overrideYaml.Path); err != nil {
+			t.Fatalf("failed to remove override.yaml: %s", err)
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if err := os.WriteFile(overrideYaml.Path, []byte(overrideYaml.Contents), 0o644); err != nil {
+			t.Fatalf("failed to write override.yaml: %s", err)
+		}
+		result, err := manager.RestoreWithResult(context.Background(), snapshot.Name, RestoreOptions{MinimalChanges: true})
+		if err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		if !slices.Equal(result.Reverted, []string{overrideYaml.Path}) {
+			t.Errorf("expected only override.yaml to be reverted, got %v", result.Reverted)
+		}
+		if _, err := os.Stat(overrideYaml.Path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("override.yaml was not removed: %v", err)
+		}
+	})
+
+	for _, includeOverrideYaml := range []bool{true, false} {
+		t.Run(fmt.Sprintf("EstimateSize with includeOverrideYaml %t", includeOverrideYaml), func(t *testing.T) {
+			appPaths, testFiles := populateFiles(t, includeOverrideYaml)
+			manager := newTestManager(appPaths)
+			var expected int64
+			for _, testFile := range testFiles {
+				expected += int64(len(testFile.Contents))
+			}
+			size, err := manager.EstimateSize(CreateOptions{Deduplicate: true})
+			if err != nil {
+				t.Fatalf("failed to estimate size: %s", err)
+			}
+			if size != expected {
+				t.Errorf("expected an estimate of %d bytes, got %d", expected, size)
+			}
+			snapshots, err := manager.List(true)
+			if err != nil || len(snapshots) != 0 {
+				t.Errorf("estimating created snapshots: %+v, %v", snapshots, err)
+			}
+		})
+	}
+
+	t.Run("EstimateSize should estimate the files it can size", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		if err := os.Remove(testFiles["disk"].Path); err != nil {
+			t.Fatalf("failed to remove disk: %s", err)
+		}
+		var expected int64
+		for testFileName, testFile := range testFiles {
+			if testFileName != "disk" {
+				expected += int64(len(testFile.Contents))
+			}
+		}
+		size, err := manager.EstimateSize(CreateOptions{})
+		if !errors.Is(err, ErrSizeUnknown) || !strings.Contains(err.Error(), "disk") {
+			t.Errorf("unexpected error estimating without the disk: %v", err)
+		}
+		if size != expected {
+			t.Errorf("expected an estimate of %d bytes, got %d", expected, size)
+		}
+	})
+
+	t.Run("Fsck should report and repair problems", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		snapshots := make(map[string]Snapshot)
+		for _, name := range []string{"good", "incomplete", "missing", "corrupt", "mismatch", "dup", "dup2"} {
+			snapshot, err := manager.Create(context.Background(), name, "")
+			if err != nil {
+				t.Fatalf("failed to create snapshot %q: %s", name, err)
+			}
+			snapshots[name] = snapshot
+		}
+		snapshotPath := func(name, file string) string {
+			return filepath.Join(manager.SnapshotDirectory(snapshots[name]), file)
+		}
+		if err := os.Remove(snapshotPath("incomplete", completeFileName)); err != nil {
+			t.Fatalf("failed to remove %s: %s", completeFileName, err)
+		}
+		if err := os.Remove(snapshotPath("missing", "disk")); err != nil {
+			t.Fatalf("failed to remove disk: %s", err)
+		}
+		if err := os.WriteFile(snapshotPath("corrupt", metadataFileName), []byte("{"), 0o644); err != nil {
+			t.Fatalf("failed to corrupt metadata: %s", err)
+		}
+		if err := os.WriteFile(snapshotPath("mismatch", metadataFileName), []byte(`{"id": "not-the-directory", "name": "mismatch"}`), 0o644); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+		duplicate := snapshots["dup2"]
+		duplicate.Name = "DUP"
+		if err := manager.writeMetadataFile(duplicate); err != nil {
+			t.Fatalf("failed to rename snapshot: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(appPaths.Snapshots, "stray.txt"), nil, 0o644); err != nil {
+			t.Fatalf("failed to write stray file: %s", err)
+		}
+
+		kinds := func(report FsckReport) map[FsckProblemKind]string {
+			result := make(map[FsckProblemKind]string)
+			for _, problem := range report.Problems {
+				result[problem.Kind] = problem.Entry
+			}
+			return result
+		}
+		expected := map[FsckProblemKind]string{
+			FsckIncomplete:      snapshots["incomplete"].ID,
+			FsckMissingFiles:    snapshots["missing"].ID,
+			FsckCorruptMetadata: snapshots["corrupt"].ID,
+			FsckIDMismatch:      snapshots["mismatch"].ID,
+			FsckDuplicateName:   snapshots["dup2"].ID,
+			FsckUnexpectedEntry: "stray.txt",
+		}
+
+		report, err := manager.Fsck(false)
+		if err != nil {
+			t.Fatalf("failed to check snapshots: %s", err)
+		}
+		if report.Checked != len(snapshots) {
+			t.Errorf("checked %d snapshots, expected %d", report.Checked, len(snapshots))
+		}
+		if actual := kinds(report); !maps.Equal(actual, expected) {
+			t.Errorf("unexpected problems %+v, expected %+v", report.Problems, expected)
+		}
+		if report.Unrepaired() != len(expected) {
+			t.Errorf("problems were repaired without --fix: %+v", report.Problems)
+		}
+		if _, err := os.Stat(manager.SnapshotDirectory(snapshots["incomplete"])); err != nil {
+			t.Errorf("incomplete snapshot was removed without --fix: %s", err)
+		}
+
+		lockPath := filepath.Join(appPaths.AppHome, "backend.lock")
+		if err := os.WriteFile(lockPath, nil, 0o644); err != nil {
+			t.Fatalf("failed to create lock: %s", err)
+		}
+		if _, err := manager.Fsck(true); err == nil {
+			t.Errorf("repairing should fail while a snapshot operation is in progress")
+		}
+		if err := os.Remove(lockPath); err != nil {
+			t.Fatalf("failed to remove lock: %s", err)
+		}
+
+		report, err = manager.Fsck(true)
+		if err != nil {
+			t.Fatalf("failed to repair snapshots: %s", err)
+		}
+		if actual := kinds(report); !maps.Equal(actual, expected) {
+			t.Errorf("unexpected problems %+v, expected %+v", report.Problems, expected)
+		}
+		if report.Unrepaired() != 1 {
+			t.Errorf("expected only the stray file to be left: %+v", report.Problems)
+		}
+		if _, err := os.Stat(manager.SnapshotDirectory(snapshots["incomplete"])); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("incomplete snapshot was not removed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(appPaths.Snapshots, quarantineDirName, snapshots["missing"].ID)); err != nil {
+			t.Errorf("snapshot missing files was not quarantined: %s", err)
+		}
+		listed, err := manager.List(false)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		names := make(map[string]string)
+		for _, snapshot := range listed {
+			names[snapshot.ID] = snapshot.Name
+		}
+		if expectedNames := map[string]string{
+			snapshots["good"].ID:     "good",
+			snapshots["corrupt"].ID:  "recovered-" + snapshots["corrupt"].ID[:8],
+			snapshots["mismatch"].ID: "mismatch",
+			snapshots["dup"].ID:      "dup",
+			snapshots["dup2"].ID:     "DUP-" + snapshots["dup2"].ID[:8],
+		}; !maps.Equal(names, expectedNames) {
+			t.Errorf("unexpected snapshots after repair: %+v, expected %+v", names, expectedNames)
+		}
+
+		report, err = manager.Fsck(false)
+		if err != nil {
+			t.Fatalf("failed to check snapshots: %s", err)
+		}
+		if actual := kinds(report); !maps.Equal(actual, map[FsckProblemKind]string{FsckUnexpectedEntry: "stray.txt"}) {
+			t.Errorf("problems remain after repair: %+v", report.Problems)
+		}
+	})
+
+	t.Run("Create, List and Delete should work when Snapshots is a symlink", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		target := t.TempDir()
+		if err := os.Symlink(target, appPaths.Snapshots); err != nil {
+			t.Fatalf("failed to create symlink: %s", err)
+		}
+		manager, err := newManager(appPaths, NewSnapshotterImpl(), &lock.MockBackendLock{})
+		if err != nil {
+			t.Fatalf("failed to create manager: %s", err)
+		}
+		resolvedTarget, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			t.Fatalf("failed to resolve %q: %s", target, err)
+		}
+		if manager.Snapshots != resolvedTarget {
+			t.Errorf("manager uses snapshots directory %q, expected %q", manager.Snapshots, resolvedTarget)
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if _, err := os.Stat(filepath.Join(target, snapshot.ID, completeFileName)); err != nil {
+			t.Errorf("snapshot was not created in the symlink target: %s", err)
+		}
+		snapshots, err := manager.List(false)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 1 || snapshots[0].ID != snapshot.ID {
+			t.Fatalf("unexpected snapshots: %+v", snapshots)
+		}
+		if err := manager.Delete(snapshot.Name); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		if _, err := os.Stat(filepath.Join(target, snapshot.ID)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("snapshot directory was not deleted from the symlink target: %s", err)
+		}
+		if info, err := os.Lstat(appPaths.Snapshots); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("the symlink itself should be kept: %v", err)
+		}
+	})
+
+	t.Run("Create should give up on a copy that stalls", func(t *testing.T) {
+		defer func(timeout time.Duration) { copyStallTimeout = timeout }(copyStallTimeout)
+		copyStallTimeout = 200 * time.Millisecond
+		appPaths, testFiles := populateFiles(t, true)
+		// Reading from a FIFO blocks until its writer writes more, as a read
+		// from a network share that is stuck would.
+		userPath := testFiles["user"].Path
+		if err := os.Remove(userPath); err != nil {
+			t.Fatalf("failed to remove %q: %s", userPath, err)
+		}
+		if err := syscall.Mkfifo(userPath, 0o644); err != nil {
+			t.Fatalf("failed to create FIFO %q: %s", userPath, err)
+		}
+		writerDone := make(chan struct{})
+		go func() {
+			defer close(writerDone)
+			fifo, err := os.OpenFile(userPath, os.O_WRONLY, 0)
+			if err != nil {
+				return
+			}
+			defer fifo.Close()
+			_, _ = fifo.WriteString("user ")
+			// The blocked read is interrupted once the copy stalls; Create
+			// must not wait for more to be written.
+			time.Sleep(3 * copyStallTimeout)
+			_, _ = fifo.WriteString("SSH key")
+		}()
+		defer func() { <-writerDone }()
+
+		manager := newTestManager(appPaths)
+		start := time.Now()
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if !errors.Is(err, ErrCopyStalled) {
+			t.Fatalf("expected ErrCopyStalled, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed >= 3*copyStallTimeout {
+			t.Errorf("Create waited %s for the blocked read to return", elapsed)
+		}
+		if _, err := os.Stat(manager.SnapshotDirectory(snapshot)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("the incomplete snapshot directory should be removed: %v", err)
+		}
+	})
+
+	t.Run("Create should follow a symlink to a directory that does not exist yet", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		target := filepath.Join(t.TempDir(), "moved", "snapshots")
+		if err := os.Symlink(target, appPaths.Snapshots); err != nil {
+			t.Fatalf("failed to create symlink: %s", err)
+		}
+		manager, err := newManager(appPaths, NewSnapshotterImpl(), &lock.MockBackendLock{})
+		if err != nil {
+			t.Fatalf("failed to create manager: %s", err)
+		}
+		snapshots, err := manager.List(false)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		if len(snapshots) != 0 {
+			t.Errorf("unexpected snapshots: %+v", snapshots)
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if _, err := os.Stat(filepath.Join(target, snapshot.ID, completeFileName)); err != nil {
+			t.Errorf("snapshot was not created in the symlink target: %s", err)
+		}
+	})
+
+	t.Run("Deduplicated snapshots should store identical files once", func(t *testing.T) {
+		defer func(period time.Duration) {
+			objectGracePeriod = period
+		}(objectGracePeriod)
+		objectGracePeriod = 0
+		appPaths, testFiles := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		objectsDir := filepath.Join(appPaths.Snapshots, objectsDirName)
+		countObjects := func() int {
+			entries, err := os.ReadDir(objectsDir)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("failed to read objects directory: %s", err)
+			}
+			return len(entries)
+		}
+		opts := CreateOptions{Deduplicate: true}
+		first, err := manager.CreateWithOptions(context.Background(), "first", opts)
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if _, err := os.Stat(filepath.Join(manager.SnapshotDirectory(first), "disk")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the snapshot directory to hold no copy of the disk, got %v", err)
+		}
+		if count := countObjects(); count != len(testFiles) {
+			t.Errorf("expected %d objects, got %d", len(testFiles), count)
+		}
+		if err := os.WriteFile(testFiles["disk"].Path, []byte("changed disk contents"), 0o644); err != nil {
+			t.Fatalf("failed to modify disk: %s", err)
+		}
+		second, err := manager.CreateWithOptions(context.Background(), "second", opts)
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		if count := countObjects(); count != len(testFiles)+1 {
+			t.Errorf("expected only the changed disk to be stored again, got %d objects", count)
+		}
+		report, err := manager.Fsck(false)
+		if err != nil {
+			t.Fatalf("failed to check snapshots: %s", err)
+		}
+		if len(report.Problems) != 0 {
+			t.Errorf("unexpected problems: %+v", report.Problems)
+		}
+
+		for testFileName, testFile := range testFiles {
+			if err := os.WriteFile(testFile.Path, []byte(`{"something": "different"}`), 0o644); err != nil {
+				t.Fatalf("failed to modify %s: %s", testFileName, err)
+			}
+		}
+		if err := manager.Restore(context.Background(), first.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			contents, err := os.ReadFile(testFile.Path)
+			if err != nil {
+				t.Fatalf("failed to read contents of %s: %s", testFileName, err)
+			}
+			if string(contents) != testFile.Contents {
+				t.Errorf("contents of %s appear to have not been restored", testFileName)
+			}
+		}
+
+		if err := manager.Delete(first.Name); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		if count := countObjects(); count != len(testFiles) {
+			t.Errorf("expected only the objects of the deleted snapshot to be removed, got %d objects", count)
+		}
+		if err := manager.Delete(second.Name); err != nil {
+			t.Fatalf("failed to delete snapshot: %s", err)
+		}
+		if count := countObjects(); count != 0 {
+			t.Errorf("expected all objects to be removed, got %d objects", count)
+		}
+	})
+
+	t.Run("Fsck should report deduplicated snapshots missing objects", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.CreateWithOptions(context.Background(), "test-snapshot", CreateOptions{Deduplicate: true})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		snapshotDir := manager.SnapshotDirectory(snapshot)
+		manifest, err := readObjectManifest(snapshotDir)
+		if err != nil {
+			t.Fatalf("failed to read manifest: %s", err)
+		}
+		if err := os.Remove(manifest.snapshotFilePath(snapshotDir, "disk")); err != nil {
+			t.Fatalf("failed to remove object: %s", err)
+		}
+		report, err := manager.Fsck(false)
+		if err != nil {
+			t.Fatalf("failed to check snapshots: %s", err)
+		}
+		if len(report.Problems) != 1 || report.Problems[0].Kind != FsckMissingFiles || report.Problems[0].Detail != "missing disk" {
+			t.Errorf("unexpected problems: %+v", report.Problems)
+		}
+	})
+
+	t.Run("Create should refuse to copy the disk of a running VM", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		// The test process stands in for the VM, as it is certainly alive.
+		pidFile := filepath.Join(appPaths.Lima, "0", "qemu.pid")
+		if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0o644); err != nil {
+			t.Fatalf("failed to write PID file: %s", err)
+		}
+		snapshot, err := manager.Create(context.Background(), "test-snapshot", "")
+		if !errors.Is(err, ErrDiskInUse) {
+			t.Fatalf("expected ErrDiskInUse, got %v", err)
+		}
+		if _, err := os.Stat(manager.SnapshotDirectory(snapshot)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no snapshot directory, got %v", err)
+		}
+		if manager.locked {
+			t.Error("expected the backend lock to be released")
+		}
+
+		// A PID file left behind by a VM that was killed doesn't count.
+		cmd := exec.Command("true")
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("failed to run process: %s", err)
+		}
+		if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0o644); err != nil {
+			t.Fatalf("failed to write PID file: %s", err)
+		}
+		if _, err := manager.Create(context.Background(), "test-snapshot", ""); err != nil {
+			t.Fatalf("failed to create snapshot with a stale PID file: %s", err)
+		}
+	})
+
+	t.Run("Copy should copy the objects of deduplicated snapshots, and verify them", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.CreateWithOptions(context.Background(), "test-snapshot", CreateOptions{Deduplicate: true})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		destDir := t.TempDir()
+		if err := manager.Copy(context.Background(), snapshot.ID, destDir, CopyOptions{}); err != nil {
+			t.Fatalf("failed to copy snapshot: %s", err)
+		}
+		backupPaths := *appPaths
+		backupPaths.Snapshots = destDir
+		report, err := newTestManager(&backupPaths).Fsck(false)
+		if err != nil {
+			t.Fatalf("failed to check copied snapshots: %s", err)
+		}
+		if len(report.Problems) != 0 {
+			t.Errorf("unexpected problems with the copy: %+v", report.Problems)
+		}
+
+		// An object that no longer matches its checksum is not copied.
+		snapshotDir := manager.SnapshotDirectory(snapshot)
+		manifest, err := readObjectManifest(snapshotDir)
+		if err != nil {
+			t.Fatalf("failed to read manifest: %s", err)
+		}
+		if err := os.WriteFile(manifest.snapshotFilePath(snapshotDir, "disk"), []byte("corrupted"), 0o644); err != nil {
+			t.Fatalf("failed to corrupt object: %s", err)
+		}
+		otherDir := t.TempDir()
+		if err := manager.Copy(context.Background(), snapshot.ID, otherDir, CopyOptions{}); !errors.Is(err, ErrCopyVerificationFailed) {
+			t.Errorf("expected ErrCopyVerificationFailed, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(otherDir, snapshot.ID)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected nothing to be copied, got %v", err)
+		}
+	})
+
+	t.Run("Snapshots on read-only storage should be listed, restored and copied", func(t *testing.T) {
+		appPaths, testFiles := populateFiles(t, true)
+		snapshot, err := newTestManager(appPaths).CreateWithOptions(context.Background(), "test-snapshot", CreateOptions{Deduplicate: true})
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		// Tests may run as root, which can write to any directory, so the
+		// manager is told as well.
+		setReadOnly(t, appPaths.Snapshots)
+		before := directoryState(t, appPaths.Snapshots)
+		manager := newTestManager(appPaths)
+		manager.config = ManagerConfig{ReadOnly: true, StateDirectory: filepath.Join(t.TempDir(), "state")}
+
+		snapshots, err := manager.List(false)
+		if err != nil || len(snapshots) != 1 || snapshots[0].ID != snapshot.ID {
+			t.Fatalf("unexpected snapshots: %+v, %v", snapshots, err)
+		}
+		if _, err := manager.Stat(snapshot.ID); err != nil {
+			t.Errorf("failed to inspect snapshot: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			if err := os.WriteFile(testFile.Path, []byte(`{"something": "different"}`), 0o644); err != nil {
+				t.Fatalf("failed to modify %s: %s", testFileName, err)
+			}
+		}
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Fatalf("failed to restore snapshot: %s", err)
+		}
+		for testFileName, testFile := range testFiles {
+			contents, err := os.ReadFile(testFile.Path)
+			if err != nil {
+				t.Fatalf("failed to read contents of %s: %s", testFileName, err)
+			}
+			if string(contents) != testFile.Contents {
+				t.Errorf("contents of %s appear to have not been restored", testFileName)
+			}
+		}
+		if logs, err := manager.OperationLogs(snapshot); err != nil || len(logs) != 1 {
+			t.Errorf("expected the restore to be logged in the state directory, got %v, %v", logs, err)
+		}
+		if err := manager.Copy(context.Background(), snapshot.ID, t.TempDir(), CopyOptions{}); err != nil {
+			t.Errorf("failed to copy snapshot: %s", err)
+		}
+		if _, err := manager.Fsck(false); err != nil {
+			t.Errorf("failed to check snapshots: %s", err)
+		}
+
+		// Operations that change the snapshots directory fail before doing
+		// anything.
+		if _, err := manager.Create(context.Background(), "other-snapshot", ""); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected Create to fail with ErrReadOnly, got %v", err)
+		}
+		if err := manager.Delete(snapshot.Name); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected Delete to fail with ErrReadOnly, got %v", err)
+		}
+		if err := manager.DeleteSnapshots(snapshots); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected DeleteSnapshots to fail with ErrReadOnly, got %v", err)
+		}
+		if _, err := manager.SetProtected(snapshot.ID, true); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected SetProtected to fail with ErrReadOnly, got %v", err)
+		}
+		if _, err := manager.Touch(snapshot.ID); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected Touch to fail with ErrReadOnly, got %v", err)
+		}
+		if _, err := manager.Fsck(true); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected Fsck to fail with ErrReadOnly, got %v", err)
+		}
+
+		// Without a state directory, nothing is logged.
+		manager.config.StateDirectory = ""
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
+			t.Errorf("failed to restore snapshot without a state directory: %s", err)
+		}
+		if after := directoryState(t, appPaths.Snapshots); !maps.Equal(before, after) {
+			t.Errorf("the snapshots directory changed from %v to %v", before, after)
+		}
+	})
+}
+
+func TestUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+		return path
+	}
+	missing := filepath.Join(dir, "missing")
+	files := map[string]snapshotFile{
+		"same":           {WorkingPath: write("same", "contents"), SnapshotPath: write("snapshot-same", "contents")},
+		"same size":      {WorkingPath: write("same-size", "contents"), SnapshotPath: write("snapshot-same-size", "CONTENTS")},
+		"other size":     {WorkingPath: write("other-size", "contents"), SnapshotPath: write("snapshot-other-size", "more contents")},
+		"missing both":   {WorkingPath: missing, SnapshotPath: missing, MissingOk: true},
+		"not snapshot":   {WorkingPath: write("not-snapshot", "contents"), SnapshotPath: missing, MissingOk: true},
+		"not working":    {WorkingPath: filepath.Join(dir, "not-working"), SnapshotPath: write("snapshot-not-working", "contents")},
+		"legacy":         {WorkingPath: write("legacy", "contents"), SnapshotPath: missing, LegacySnapshotPath: write("snapshot-legacy", "contents")},
+		"known checksum": {WorkingPath: write("known", "contents"), SnapshotPath: write("snapshot-known", "contents")},
+	}
+	checksums := map[string]string{
+		// The known checksum is used rather than reading the file.
+		files["known checksum"].WorkingPath: "not the checksum",
+	}
+	expected := map[string]bool{
+		"same":           true,
+		"same size":      false,
+		"other size":     false,
+		"missing both":   true,
+		"not snapshot":   false,
+		"not working":    false,
+		"legacy":         true,
+		"known checksum": false,
+	}
+	unchanged, err := unchangedFiles(context.Background(), slices.Collect(maps.Values(files)), checksums, 0)
+	if err != nil {
+		t.Fatalf("failed to compare files: %s", err)
+	}
+	for name, file := range files {
+		if unchanged[file.WorkingPath] != expected[name] {
+			t.Errorf("%s: expected unchanged to be %t", name, expected[name])
+		}
+	}
+
+	t.Run("a missing snapshot file is an error unless it may be missing", func(t *testing.T) {
+		files := []snapshotFile{{WorkingPath: files["same"].WorkingPath, SnapshotPath: missing}}
+		if _, err := unchangedFiles(context.Background(), files, nil, 0); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected a missing file error, got %v", err)
+		}
+	})
+}
+
+func TestVerifyAfterCreate(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		opts := CreateOptions{VerifyAfterCreate: true, Deduplicate: dedup}
+		t.Run(fmt.Sprintf("should report the files checked with Deduplicate %t", dedup), func(t *testing.T) {
+			appPaths, _ := populateFiles(t, false)
+			manager := newTestManager(appPaths)
+			snapshot, err := manager.CreateWithOptions(context.Background(), "verified", opts)
+			if err != nil {
+				t.Fatalf("failed to create snapshot: %s", err)
+			}
+			// override.yaml is missing, and left out.
+			expected := Verification{Files: 6, Checksummed: 6}
+			if snapshot.Verification == nil || *snapshot.Verification != expected {
+				t.Errorf("unexpected verification %+v (expected %+v)", snapshot.Verification, expected)
+			}
+		})
+		t.Run(fmt.Sprintf("should detect a corrupt file with Deduplicate %t", dedup), func(t *testing.T) {
+			appPaths, _ := populateFiles(t, true)
+			manager := newTestManager(appPaths)
+			// Change the copy of the disk once the files are copied, as a
+			// failing disk or a bad copy would, keeping its size.
+			manager.createStepHook = func(step string) error {
+				if step != "verify" {
+					return nil
+				}
+				dirs, err := filepath.Glob(filepath.Join(appPaths.Snapshots, "*-*-*-*-*"))
+				if err != nil || len(dirs) != 1 {
+					return fmt.Errorf("failed to find the snapshot directory: %v %v", dirs, err)
+				}
+				manifest, err := readObjectManifest(dirs[0])
+				if err != nil {
+					return err
+				}
+				path := manifest.snapshotFilePath(dirs[0], "disk")
+				contents, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				contents[0] ^= 0xff
+				if err := os.Chmod(path, 0o600); err != nil {
+					return err
+				}
+				return os.WriteFile(path, contents, 0o600)
+			}
+			snapshot, err := manager.CreateWithOptions(context.Background(), "corrupt", opts)
+			if !errors.Is(err, ErrVerificationFailed) {
+				t.Fatalf("expected verification to fail, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "disk") {
+				t.Errorf("error does not name the corrupt file: %s", err)
+			}
+			if _, err := os.Stat(manager.SnapshotDirectory(snapshot)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("snapshot that failed verification was not removed: %v", err)
+			}
+			if objects, _, err := listObjects(manager.SnapshotDirectory(snapshot)); err != nil {
+				t.Fatalf("failed to list objects: %s", err)
+			} else if len(objects) != 0 {
+				t.Errorf("objects of the snapshot that failed verification were not removed: %v", objects)
+			}
+			if snapshots, err := manager.List(true); err != nil {
+				t.Fatalf("failed to list snapshots: %s", err)
+			} else if len(snapshots) != 0 {
+				t.Errorf("expected no snapshots, got %+v", snapshots)
+			}
+		})
+	}
+}
+
+// setReadOnly makes the files and directories under dir read-only, until the
+// end of the test.
+func setReadOnly(t *testing.T, dir string) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, _ os.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to list %s: %s", dir, err)
+	}
+	// Change the children before their parents, and the reverse afterwards.
+	slices.Reverse(paths)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", path, err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			if err := os.Chmod(path, info.Mode().Perm()&^0o222); err != nil {
+				t.Fatalf("failed to make %s read-only: %s", path, err)
+			}
+		}
+	}
+	t.Cleanup(func() {
+		slices.Reverse(paths)
+		for _, path := range paths {
+			if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink == 0 {
+				_ = os.Chmod(path, info.Mode().Perm()|0o200)
+			}
+		}
+	})
+}
+
+// directoryState returns the size and modification time of each file and
+// directory under dir, by path.
+func directoryState(t *testing.T, dir string) map[string]string {
+	state := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		state[path] = fmt.Sprintf("%d %s", info.Size(), info.ModTime().Format(time.RFC3339Nano))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", dir, err)
+	}
+	return state
+}
+
+// populateBrokenSnapshots creates snapshots with the given names in order,
+// then breaks those named "missing-*" by removing their disk, and corrupts the
+// object holding the disk of those named "corrupt-*", which are deduplicated.
+func populateBrokenSnapshots(t *testing.T, manager *Manager, names []string) map[string]Snapshot {
+	t.Helper()
+	snapshots := make(map[string]Snapshot)
+	for i, name := range names {
+		// Deduplicated snapshots share the objects of identical files.
+		if err := os.WriteFile(filepath.Join(manager.Lima, "0", "disk"), fmt.Appendf(nil, "disk %d", i), 0o644); err != nil {
+			t.Fatalf("failed to write disk: %s", err)
+		}
+		snapshot, err := manager.CreateWithOptions(context.Background(), name, CreateOptions{Deduplicate: strings.HasPrefix(name, "corrupt-")})
+		if err != nil {
+			t.Fatalf("failed to create snapshot %q: %s", name, err)
+		}
+		snapshots[name] = snapshot
+		snapshotDir := manager.SnapshotDirectory(snapshot)
+		switch {
+		case strings.HasPrefix(name, "missing-"):
+			if err := os.Remove(filepath.Join(snapshotDir, "disk")); err != nil {
+				t.Fatalf("failed to remove disk: %s", err)
+			}
+		case strings.HasPrefix(name, "corrupt-"):
+			manifest, err := readObjectManifest(snapshotDir)
+			if err != nil {
+				t.Fatalf("failed to read manifest: %s", err)
+			}
+			if err := os.WriteFile(manifest.snapshotFilePath(snapshotDir, "disk"), []byte("bit rot"), 0o644); err != nil {
+				t.Fatalf("failed to corrupt disk: %s", err)
+			}
+		}
+	}
+	return snapshots
+}
+
+func TestFsckWithOptions(t *testing.T) {
+	appPaths, _ := populateFiles(t, true)
+	manager := newTestManager(appPaths)
+	var names []string
+	for i := range 16 {
+		switch i % 5 {
+		case 1:
+			names = append(names, fmt.Sprintf("missing-%d", i))
+		case 3:
+			names = append(names, fmt.Sprintf("corrupt-%d", i))
+		default:
+			names = append(names, fmt.Sprintf("good-%d", i))
+		}
+	}
+	snapshots := populateBrokenSnapshots(t, manager, names)
+	ids := make(map[string]string)
+	for name, snapshot := range snapshots {
+		ids[snapshot.ID] = name
+	}
+	// The IDs of the broken snapshots, in the order of the directory.
+	var broken []string
+	for _, snapshot := range snapshots {
+		if !strings.HasPrefix(snapshot.Name, "good-") {
+			broken = append(broken, snapshot.ID)
+		}
+	}
+	slices.Sort(broken)
+
+	t.Run("reports are the same whatever the number of workers", func(t *testing.T) {
+		expected, err := manager.FsckWithOptions(context.Background(), FsckOptions{Verify: true, Workers: 1})
+		if err != nil {
+			t.Fatalf("failed to check snapshots: %s", err)
+		}
+		var entries []string
+		for _, problem := range expected.Problems {
+			entries = append(entries, problem.Entry)
+			kind := FsckMissingFiles
+			if strings.HasPrefix(ids[problem.Entry], "corrupt-") {
+				kind = FsckCorruptFiles
+			}
+			if problem.Kind != kind {
+				t.Errorf("expected %s for %s, got %+v", kind, ids[problem.Entry], problem)
+			}
+		}
+		if !slices.Equal(entries, broken) {
+			t.Fatalf("expected problems with %v in order, got %v", broken, entries)
+		}
+		for _, workers := range []int{0, 3, 16, 64} {
+			for range 5 {
+				report, err := manager.FsckWithOptions(context.Background(), FsckOptions{Verify: true, Workers: workers})
+				if err != nil {
+					t.Fatalf("failed to check snapshots with %d workers: %s", workers, err)
+				}
+				if !reflect.DeepEqual(report, expected) {
+					t.Fatalf("with %d workers, got %+v, expected %+v", workers, report, expected)
+				}
+			}
+		}
+	})
+
+	t.Run("corrupt objects are only found when verifying", func(t *testing.T) {
+		report, err := manager.FsckWithOptions(context.Background(), FsckOptions{})
+		if err != nil {
+			t.Fatalf("failed to check snapshots: %s", err)
+		}
+		for _, problem := range report.Problems {
+			if problem.Kind != FsckMissingFiles {
+				t.Errorf("unexpected problem without verifying: %+v", problem)
+			}
+		}
+	})
+
+	t.Run("fail-fast stops at the first problem", func(t *testing.T) {
+		for _, workers := range []int{1, 4, 16} {
+			for range 5 {
+				report, err := manager.FsckWithOptions(context.Background(), FsckOptions{Verify: true, Workers: workers, FailFast: true})
+				if err != nil {
+					t.Fatalf("failed to check snapshots: %s", err)
+				}
+				if len(report.Problems) != 1 || report.Problems[0].Entry != broken[0] {
+					t.Fatalf("with %d workers, expected only the problem with %s, got %+v", workers, broken[0], report.Problems)
+				}
+				// Everything up to the first broken snapshot is checked.
+				all := slices.Sorted(maps.Keys(ids))
+				if expected := slices.Index(all, broken[0]) + 1; report.Checked != expected {
+					t.Errorf("with %d workers, checked %d snapshots, expected %d", workers, report.Checked, expected)
+				}
+			}
+		}
+	})
+
+	t.Run("fix quarantines corrupt snapshots", func(t *testing.T) {
+		report, err := manager.FsckWithOptions(context.Background(), FsckOptions{Fix: true, Verify: true})
+		if err != nil {
+			t.Fatalf("failed to repair snapshots: %s", err)
+		}
+		if report.Unrepaired() != 0 {
+			t.Errorf("problems were left: %+v", report.Problems)
+		}
+		report, err = manager.FsckWithOptions(context.Background(), FsckOptions{Verify: true})
+		if err != nil || len(report.Problems) != 0 {
+			t.Errorf("problems remain after repair: %+v, %v", report.Problems, err)
+		}
+	})
+
+	t.Run("checks stop when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := manager.FsckWithOptions(ctx, FsckOptions{Verify: true}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func BenchmarkFsckVerify(b *testing.B) {
+	appPaths, _ := populateFiles(b, true)
+	manager := newTestManager(appPaths)
+	disk := bytes.Repeat([]byte("disk contents\n"), 8<<20/len("disk contents\n"))
+	if err := os.WriteFile(filepath.Join(appPaths.Lima, "0", "disk"), disk, 0o644); err != nil {
+		b.Fatalf("failed to write disk: %s", err)
+	}
+	for i := range 16 {
+		if _, err := manager.Create(context.Background(), fmt.Sprintf("snapshot-%d", i), ""); err != nil {
+			b.Fatalf("failed to create snapshot: %s", err)
+		}
+	}
+	for _, workers := range []int{1, 2, DefaultFsckWorkers, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(16 * len(disk)))
+			for b.Loop() {
+				if _, err := manager.FsckWithOptions(context.Background(), FsckOptions{Verify: true, Workers: workers}); err != nil {
+					b.Fatalf("failed to check snapshots: %s", err)
+				}
+			}
+		})
+	}
 }
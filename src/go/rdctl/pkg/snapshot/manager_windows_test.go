@@ -12,7 +12,7 @@ import (
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/wsl"
 )
 
-func populateFiles(t *testing.T, _ bool) (*paths.Paths, map[string]TestFile) {
+func populateFiles(t testing.TB, _ bool) (*paths.Paths, map[string]TestFile) {
 	baseDir := t.TempDir()
 	appPaths := paths.Paths{
 		Config:        filepath.Join(baseDir, "config"),
@@ -84,7 +84,7 @@ func TestManagerWindows(t *testing.T) {
 				t.Fatalf("failed to modify %s: %s", testFileName, err)
 			}
 		}
-		if err := manager.Restore(context.Background(), snapshot.Name); err != nil {
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
 			t.Fatalf("failed to restore snapshot: %s", err)
 		}
 		for testFileName, testFile := range testFiles {
@@ -115,7 +115,7 @@ func TestManagerWindows(t *testing.T) {
 				t.Fatalf("failed to remove test directory %q: %s", testDir, err)
 			}
 		}
-		if err := manager.Restore(context.Background(), snapshot.Name); err != nil {
+		if err := manager.Restore(context.Background(), snapshot.Name, RestoreOptions{}); err != nil {
 			t.Fatalf("failed to restore snapshot: %s", err)
 		}
 		for _, testDir := range testDirs {
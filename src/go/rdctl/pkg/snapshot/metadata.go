@@ -0,0 +1,106 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The name of the file, in each snapshot directory, holding its metadata.
+const metadataFileName = "metadata.json"
+
+// metadata is the schema of the metadata file stored in each snapshot. It is
+// separate from Snapshot so that what is stored can change without changing
+// the public type; newMetadata and metadata.snapshot convert between the two.
+// Snapshots written by earlier versions must stay readable, so fields can only
+// be added here, and readers must cope with them being absent.
+type metadata struct {
+	Created           time.Time         `json:"created"`
+	Name              string            `json:"name"`
+	ID                string            `json:"id,omitempty"`
+	Description       string            `json:"description"`
+	SettingsVersion   int               `json:"settingsVersion,omitempty"`
+	ComponentVersions map[string]string `json:"componentVersions,omitempty"`
+	LastUsed          time.Time         `json:"lastUsed,omitzero"`
+	ClusterHealth     *ClusterHealth    `json:"clusterHealth,omitempty"`
+	Host              *HostIdentity     `json:"host,omitempty"`
+	Protected         bool              `json:"protected,omitempty"`
+	CapturedLogs      *CapturedLogs     `json:"capturedLogs,omitempty"`
+}
+
+// newMetadata returns the stored form of a snapshot's metadata.
+func newMetadata(snapshot Snapshot) metadata {
+	return metadata{
+		Created:           snapshot.Created,
+		Name:              snapshot.Name,
+		ID:                snapshot.ID,
+		Description:       snapshot.Description,
+		SettingsVersion:   snapshot.SettingsVersion,
+		ComponentVersions: snapshot.ComponentVersions,
+		LastUsed:          snapshot.LastUsed,
+		ClusterHealth:     snapshot.ClusterHealth,
+		Host:              snapshot.Host,
+		Protected:         snapshot.Protected,
+		CapturedLogs:      snapshot.CapturedLogs,
+	}
+}
+
+// snapshot returns the public form of stored metadata, with times in local
+// time.
+func (m metadata) snapshot() Snapshot {
+	snapshot := Snapshot{
+		Created:           m.Created.Local(),
+		Name:              m.Name,
+		ID:                m.ID,
+		Description:       m.Description,
+		SettingsVersion:   m.SettingsVersion,
+		ComponentVersions: m.ComponentVersions,
+		Host:              m.Host,
+		Protected:         m.Protected,
+		CapturedLogs:      m.CapturedLogs,
+		Digest:            m.digest(),
+	}
+	if !m.LastUsed.IsZero() {
+		snapshot.LastUsed = m.LastUsed.Local()
+	}
+	if m.ClusterHealth != nil {
+		health := *m.ClusterHealth
+		health.Checked = health.Checked.Local()
+		snapshot.ClusterHealth = &health
+	}
+	return snapshot
+}
+
+// digest returns the digest of the metadata, for Snapshot.Digest. The last
+// used time and protection are left out, so that restoring or protecting a
+// snapshot doesn't make a view of it that was listed just before stale.
+func (m metadata) digest() string {
+	m.Created = m.Created.UTC()
+	m.LastUsed = time.Time{}
+	m.Protected = false
+	if m.ClusterHealth != nil {
+		health := *m.ClusterHealth
+		health.Checked = health.Checked.UTC()
+		m.ClusterHealth = &health
+	}
+	// Marshalling a struct of plain values can't fail.
+	contents, _ := json.Marshal(m)
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:])
+}
+
+// readMetadataFile reads the metadata file at the given path.
+func readMetadataFile(metadataPath string) (Snapshot, error) {
+	contents, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read %q: %w", metadataPath, err)
+	}
+	var stored metadata
+	if err := json.Unmarshal(contents, &stored); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to unmarshal contents of %q: %w", metadataPath, err)
+	}
+	return stored.snapshot(), nil
+}
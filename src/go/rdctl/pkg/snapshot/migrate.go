@@ -0,0 +1,211 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/lock"
+)
+
+// MigrateStatus is the outcome of migrating a single snapshot.
+type MigrateStatus string
+
+const (
+	// The snapshot was copied to the destination, and removed from the
+	// snapshots directory.
+	MigrateMoved MigrateStatus = "moved"
+	// The destination already held an intact copy of the snapshot, left by
+	// an earlier migration that didn't get to remove it; it was only removed
+	// from the snapshots directory.
+	MigrateAlreadyCopied MigrateStatus = "already-copied"
+	// The snapshot could not be moved, and is still in the snapshots
+	// directory; migrating again retries it.
+	MigrateFailed MigrateStatus = "failed"
+)
+
+// MigratedSnapshot describes what Migrate did with a single snapshot.
+type MigratedSnapshot struct {
+	ID     string        `json:"id"`
+	Name   string        `json:"name"`
+	Status MigrateStatus `json:"status"`
+	// Why the snapshot could not be moved, if it couldn't.
+	Error string `json:"error,omitempty"`
+	// Whether the snapshot was copied, and its copy verified, but it could
+	// not be removed from the snapshots directory. It is then in both
+	// directories, and migrating again only removes it.
+	Copied bool `json:"copied,omitempty"`
+}
+
+// MigrateReport is the result of Migrate.
+type MigrateReport struct {
+	// The directory the snapshots were moved to.
+	Destination string `json:"destination"`
+	// The snapshots that were migrated, in the order of List.
+	Snapshots []MigratedSnapshot `json:"snapshots"`
+}
+
+// Failed returns the number of snapshots that could not be moved.
+func (report MigrateReport) Failed() int {
+	count := 0
+	for _, migrated := range report.Snapshots {
+		if migrated.Status == MigrateFailed {
+			count++
+		}
+	}
+	return count
+}
+
+// Migrate moves all the complete snapshots into destDir, such as a snapshots
+// directory on another drive. Each snapshot is copied as by Copy, which reads
+// every file back once it is written, and is only removed from the snapshots
+// directory once its copy is verified; a snapshot whose copy fails is left
+// where it is, and the other snapshots are still moved. Protected snapshots
+// are moved too, and stay protected. Incomplete snapshots are left alone.
+//
+// Migrating again after a failure only moves the snapshots that are left: a
+// copy left at the destination by an earlier migration is checked against
+// the original, and replaced if it doesn't match.
+//
+// Migrate only returns an error if nothing could be migrated; the outcome for
+// each snapshot is in the report.
+func (manager *Manager) Migrate(ctx context.Context, destDir string) (MigrateReport, error) {
+	if err := manager.checkWritable(); err != nil {
+		return MigrateReport{}, err
+	}
+	if err := manager.checkNotFrozen(); err != nil {
+		return MigrateReport{}, err
+	}
+	// Snapshots being created or restored can't be moved from under the
+	// operation.
+	if locked, err := lock.IsLocked(manager.Paths); err != nil {
+		return MigrateReport{}, err
+	} else if locked {
+		return MigrateReport{}, errors.New("a snapshot operation is in progress; if there is none, remove the lock with `rdctl snapshot unlock` first")
+	}
+	destDir, err := resolvePath(destDir)
+	if err != nil {
+		return MigrateReport{}, fmt.Errorf("failed to resolve destination %q: %w", destDir, err)
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return MigrateReport{}, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	if same, err := sameDirectory(destDir, manager.Snapshots); err != nil {
+		return MigrateReport{}, err
+	} else if same {
+		return MigrateReport{}, errors.New("the destination is the snapshots directory")
+	}
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return MigrateReport{}, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	report := MigrateReport{Destination: destDir, Snapshots: []MigratedSnapshot{}}
+	for _, snapshot := range snapshots {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Snapshots = append(report.Snapshots, manager.migrateSnapshot(ctx, snapshot, destDir))
+	}
+	return report, nil
+}
+
+// migrateSnapshot moves a single snapshot into destDir.
+func (manager *Manager) migrateSnapshot(ctx context.Context, snapshot Snapshot, destDir string) MigratedSnapshot {
+	migrated := MigratedSnapshot{ID: snapshot.ID, Name: snapshot.Name, Status: MigrateMoved}
+	fail := func(err error) MigratedSnapshot {
+		migrated.Status = MigrateFailed
+		migrated.Error = err.Error()
+		return migrated
+	}
+	srcDir := manager.SnapshotDirectory(snapshot)
+	dest := snapshotDirPath(destDir, snapshot.ID)
+	if _, err := os.Lstat(dest); err == nil {
+		if err := verifyCopy(ctx, srcDir, dest); err == nil {
+			migrated.Status = MigrateAlreadyCopied
+		} else if !errors.Is(err, ErrCopyVerificationFailed) {
+			return fail(err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fail(fmt.Errorf("failed to check destination: %w", err))
+	}
+	if migrated.Status != MigrateAlreadyCopied {
+		// Copy verifies what it copies; a copy that doesn't match is
+		// replaced.
+		if err := manager.Copy(ctx, snapshot.ID, destDir, CopyOptions{Force: true}); err != nil {
+			return fail(err)
+		}
+	}
+	migrated.Copied = true
+	if err := manager.deleteSnapshot(snapshot); err != nil {
+		return fail(fmt.Errorf("failed to remove the original: %w", err))
+	}
+	migrated.Copied = false
+	return migrated
+}
+
+// verifyCopy checks that the snapshot directory destDir, in another snapshots
+// directory, holds the same files as srcDir with the same contents, down to
+// the objects of deduplicated snapshots. It returns an error wrapping
+// ErrCopyVerificationFailed if it doesn't.
+func verifyCopy(ctx context.Context, srcDir, destDir string) error {
+	names := func(dir string) ([]string, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", dir, err)
+		}
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.Name())
+		}
+		return result, nil
+	}
+	srcNames, err := names(srcDir)
+	if err != nil {
+		return err
+	}
+	destNames, err := names(destDir)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(srcNames, destNames) {
+		return fmt.Errorf("%w: %q holds %v, not %v", ErrCopyVerificationFailed, destDir, destNames, srcNames)
+	}
+	for _, name := range srcNames {
+		original, err := checksumFile(ctx, filepath.Join(srcDir, name), 0)
+		if err != nil {
+			return err
+		}
+		if err := verifyChecksum(ctx, filepath.Join(destDir, name), original); err != nil {
+			return err
+		}
+	}
+	manifest, err := readObjectManifest(srcDir)
+	if err != nil {
+		return err
+	}
+	for _, checksum := range slices.Compact(slices.Sorted(maps.Values(manifest))) {
+		if err := verifyChecksum(ctx, objectPath(objectsDirPath(destDir), checksum), checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyChecksum checks that the file at path has the expected checksum. A
+// missing file fails the verification.
+func verifyChecksum(ctx context.Context, path, expected string) error {
+	checksum, err := checksumFile(ctx, path, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %q is missing", ErrCopyVerificationFailed, path)
+	} else if err != nil {
+		return err
+	}
+	if checksum != expected {
+		return fmt.Errorf("%w: %q has checksum %s, not %s", ErrCopyVerificationFailed, path, checksum, expected)
+	}
+	return nil
+}
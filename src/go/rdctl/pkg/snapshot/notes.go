@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The name of the file, in a snapshot directory, holding the notes of the
+// snapshot. Notes are kept out of the metadata, as they can be long, and List
+// has no use for them; they are copied along with the rest of the snapshot,
+// but never restored.
+const notesFileName = "notes.md"
+
+// The largest notes that can be set.
+const maxNotesSize = 1 << 20
+
+// notesFilePath returns the path of the notes of the snapshot with the given
+// ID.
+func notesFilePath(snapshotsDir, id string) string {
+	return filepath.Join(snapshotDirPath(snapshotsDir, id), notesFileName)
+}
+
+// snapshotByID returns the complete snapshot with the given ID, or a
+// *NotFoundError if there is none.
+func (manager *Manager) snapshotByID(id string) (*Snapshot, error) {
+	snapshot, err := manager.Stat(id)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.ID != id {
+		// Stat also matches names.
+		return nil, &NotFoundError{ID: id}
+	}
+	return snapshot, nil
+}
+
+// GetNotes returns the notes of the snapshot with the given ID, or the empty
+// string if it has none.
+func (manager *Manager) GetNotes(id string) (string, error) {
+	if _, err := manager.snapshotByID(id); err != nil {
+		return "", err
+	}
+	contents, err := os.ReadFile(notesFilePath(manager.Snapshots, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read notes: %w", err)
+	}
+	return string(contents), nil
+}
+
+// SetNotes replaces the notes of the snapshot with the given ID, which are
+// free-form text, usually Markdown, of at most 1 MiB. Empty notes remove them.
+func (manager *Manager) SetNotes(id, notes string) error {
+	if err := manager.checkWritable(); err != nil {
+		return err
+	}
+	if len(notes) > maxNotesSize {
+		return fmt.Errorf("notes are %d bytes long, but can be at most %d", len(notes), maxNotesSize)
+	}
+	if _, err := manager.snapshotByID(id); err != nil {
+		return err
+	}
+	path := notesFilePath(manager.Snapshots, id)
+	if notes == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove notes: %w", err)
+		}
+		return nil
+	}
+	if err := replaceFile(path, []byte(notes), 0o644); err != nil {
+		return fmt.Errorf("failed to write notes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Snapshots created with CreateOptions.Deduplicate don't hold copies of their
+// files. Instead, the contents of each file are stored once, in the objects
+// directory under the snapshots directory, named by their SHA-256 checksum;
+// the snapshot directory holds a manifest mapping the names of its files to
+// the objects holding them. Objects are shared by all the snapshots that have
+// files with the same contents, and are only removed once no manifest refers
+// to them.
+
+// The name of the directory, under the snapshots directory, holding the
+// objects of deduplicated snapshots.
+const objectsDirName = "objects"
+
+// The name of the manifest file in the directory of a deduplicated snapshot.
+const objectManifestFileName = "objects.json"
+
+// How long an object that no snapshot refers to is kept. A snapshot being
+// created only refers to an object once it has been stored, so recent objects
+// are kept to avoid removing them in between. This is a variable so that
+// tests can shorten it.
+var objectGracePeriod = 10 * time.Minute
+
+// objectManifest maps the names of the files in a deduplicated snapshot to
+// the checksums of the objects holding their contents.
+type objectManifest map[string]string
+
+// objectsDirPath returns the path of the objects directory shared by the
+// snapshots in the same directory as snapshotDir.
+func objectsDirPath(snapshotDir string) string {
+	return filepath.Join(filepath.Dir(snapshotDir), objectsDirName)
+}
+
+// objectPath returns the path of the object with the given checksum.
+func objectPath(objectsDir, checksum string) string {
+	return filepath.Join(objectsDir, checksum)
+}
+
+// objectManifestPath returns the path of the manifest in a snapshot
+// directory.
+func objectManifestPath(snapshotDir string) string {
+	return filepath.Join(snapshotDir, objectManifestFileName)
+}
+
+// readObjectManifest reads the manifest of the snapshot in snapshotDir. It
+// returns nil, and no error, for snapshots that are not deduplicated.
+func readObjectManifest(snapshotDir string) (objectManifest, error) {
+	path := objectManifestPath(snapshotDir)
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", path, err)
+	}
+	var manifest objectManifest
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal contents of %q: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// write writes the manifest into snapshotDir.
+func (manifest objectManifest) write(snapshotDir string) error {
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to write object manifest: %w", err)
+	}
+	return replaceFile(objectManifestPath(snapshotDir), append(contents, '\n'), 0o644)
+}
+
+// snapshotFilePath returns the path holding the contents of the file with the
+// given name in the snapshot in snapshotDir: the object the manifest refers
+// to, if it lists the file, or the file in the snapshot directory otherwise.
+func (manifest objectManifest) snapshotFilePath(snapshotDir, name string) string {
+	if checksum, ok := manifest[name]; ok {
+		return objectPath(objectsDirPath(snapshotDir), checksum)
+	}
+	return filepath.Join(snapshotDir, name)
+}
+
+// collectObjects removes the objects that no snapshot refers to any more,
+// once they are older than objectGracePeriod. The manifests of incomplete and
+// quarantined snapshots count as references, so that snapshots being created
+// or inspected keep their objects. Nothing is removed if any manifest can't
+// be read, since the objects it refers to are unknown.
+func (manager *Manager) collectObjects() error {
+	objectsDir := filepath.Join(manager.Snapshots, objectsDirName)
+	entries, err := os.ReadDir(objectsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read objects directory: %w", err)
+	}
+	referenced, err := manager.referencedObjects()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-objectGracePeriod)
+	var errs []error
+	for _, entry := range entries {
+		if referenced[entry.Name()] > 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(objectPath(objectsDir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to remove object %q: %w", entry.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// referencedObjects returns the number of snapshots that refer to each object.
+func (manager *Manager) referencedObjects() (map[string]int, error) {
+	referenced := make(map[string]int)
+	for _, dir := range []string{manager.Snapshots, filepath.Join(manager.Snapshots, quarantineDirName)} {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if _, err := uuid.Parse(entry.Name()); err != nil || !entry.IsDir() {
+				continue
+			}
+			manifest, err := readObjectManifest(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, checksum := range manifest {
+				referenced[checksum]++
+			}
+		}
+	}
+	return referenced, nil
+}
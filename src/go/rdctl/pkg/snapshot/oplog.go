@@ -0,0 +1,164 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The name of the directory, under the state directory (the snapshots
+// directory, unless configured otherwise), that holds the logs of snapshot
+// operations. They are kept outside of the snapshot
+// directories so that the log of a failed create survives the cleanup.
+const logsDirName = "logs"
+
+// The number of operation logs to keep; older ones are removed when a new
+// operation starts.
+const maxOperationLogs = 50
+
+// The layout of the timestamp in operation log file names, chosen so that the
+// names sort chronologically.
+const logTimestampLayout = "20060102T150405.000000000Z"
+
+// ErrNoOperationLogs is returned when there are no logs to show.
+var ErrNoOperationLogs = errors.New("no snapshot operation logs found")
+
+// operationLog records the steps, warnings and errors of a single snapshot
+// operation to a file.
+type operationLog struct {
+	*logrus.Logger
+	file *os.File
+}
+
+// LogsDirectory returns the directory containing the logs of snapshot
+// operations, in the state directory; see ManagerConfig.StateDirectory.
+func (manager *Manager) LogsDirectory() string {
+	return filepath.Join(manager.stateDirectory(), logsDirName)
+}
+
+// startOperationLog creates the log for an operation on the given snapshot.
+// Failing to create the log does not fail the operation; the returned log
+// discards its output instead.
+func (manager *Manager) startOperationLog(snapshot Snapshot, operation string) *operationLog {
+	log := &operationLog{Logger: logrus.New()}
+	log.Out = io.Discard
+	log.Formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	log.Level = logrus.DebugLevel
+
+	if manager.config.StateDirectory == "" && manager.readOnly() {
+		// There is nowhere to write the log.
+		return log
+	}
+	logsDir := manager.LogsDirectory()
+	if err := os.MkdirAll(logsDir, 0o755); err != nil {
+		logrus.Warnf("failed to create snapshot logs directory: %s", err)
+		return log
+	}
+	manager.pruneOperationLogs()
+	fileName := fmt.Sprintf("%s_%s_%s.log", time.Now().UTC().Format(logTimestampLayout), snapshot.ID, operation)
+	file, err := os.Create(filepath.Join(logsDir, fileName))
+	if err != nil {
+		logrus.Warnf("failed to create snapshot operation log: %s", err)
+		return log
+	}
+	log.file = file
+	log.Out = file
+	log.Infof("%s snapshot %q (%s)", operation, snapshot.Name, snapshot.ID)
+	return log
+}
+
+// finish records the outcome of the operation and closes the log.
+func (log *operationLog) finish(err error) {
+	if err != nil {
+		log.Errorf("failed: %s", err)
+	} else {
+		log.Info("done")
+	}
+	if log.file != nil {
+		_ = log.file.Close()
+	}
+}
+
+// operationLogs returns the paths of all operation logs, oldest first.
+func (manager *Manager) operationLogs() ([]string, error) {
+	dirEntries, err := os.ReadDir(manager.LogsDirectory())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read snapshot logs directory: %w", err)
+	}
+	var logPaths []string
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".log") {
+			continue
+		}
+		logPaths = append(logPaths, filepath.Join(manager.LogsDirectory(), dirEntry.Name()))
+	}
+	// os.ReadDir sorts by file name, which starts with the timestamp.
+	return logPaths, nil
+}
+
+// OperationLogs returns the paths of the logs of the operations done on the
+// given snapshot, oldest first.
+func (manager *Manager) OperationLogs(snapshot Snapshot) ([]string, error) {
+	logPaths, err := manager.operationLogs()
+	if err != nil {
+		return nil, err
+	}
+	logPaths = slices.DeleteFunc(logPaths, func(logPath string) bool {
+		return !strings.Contains(filepath.Base(logPath), "_"+snapshot.ID+"_")
+	})
+	if len(logPaths) == 0 {
+		return nil, fmt.Errorf("%w for snapshot %q", ErrNoOperationLogs, snapshot.Name)
+	}
+	return logPaths, nil
+}
+
+// LastOperationLog returns the path of the log of the most recent snapshot
+// operation, including operations on snapshots that no longer exist.
+func (manager *Manager) LastOperationLog() (string, error) {
+	logPaths, err := manager.operationLogs()
+	if err != nil {
+		return "", err
+	}
+	if len(logPaths) == 0 {
+		return "", ErrNoOperationLogs
+	}
+	return logPaths[len(logPaths)-1], nil
+}
+
+// pruneOperationLogs removes the oldest operation logs, leaving room for one
+// more.
+func (manager *Manager) pruneOperationLogs() {
+	logPaths, err := manager.operationLogs()
+	if err != nil {
+		logrus.Warnf("failed to prune snapshot operation logs: %s", err)
+		return
+	}
+	for len(logPaths) >= maxOperationLogs {
+		if err := os.Remove(logPaths[0]); err != nil {
+			logrus.Warnf("failed to remove snapshot operation log: %s", err)
+		}
+		logPaths = logPaths[1:]
+	}
+}
+
+// removeOperationLogs removes the logs of the given snapshot.
+func (manager *Manager) removeOperationLogs(snapshot Snapshot) error {
+	logPaths, err := manager.OperationLogs(snapshot)
+	if errors.Is(err, ErrNoOperationLogs) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var errs []error
+	for _, logPath := range logPaths {
+		errs = append(errs, os.Remove(logPath))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Prefetch reads all the files of the complete snapshot with the given ID,
+// without changing anything, so that the OS has them cached when the snapshot
+// is restored; restoring from a snapshot on slow storage is then quicker.
+// Files are read one after the other, with the OS told that they are read
+// sequentially where it supports that. Whether the files stay cached until
+// they are restored is up to the OS.
+func (manager *Manager) Prefetch(ctx context.Context, id string) error {
+	snapshots, err := manager.List(false)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	index := slices.IndexFunc(snapshots, func(snapshot Snapshot) bool {
+		return snapshot.ID == id
+	})
+	if index < 0 {
+		return &NotFoundError{ID: id}
+	}
+	snapshot := snapshots[index]
+	snapshotDir := manager.SnapshotDirectory(snapshot)
+	manifest, err := readObjectManifest(snapshotDir)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+	start := time.Now()
+	var total int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		read, err := prefetchFile(ctx, manifest.snapshotFilePath(snapshotDir, entry.Name()))
+		total += read
+		if err != nil {
+			return err
+		}
+	}
+	elapsed := time.Since(start)
+	logrus.Infof("prefetched %d bytes of snapshot %q in %s (%.1f MiB/s)",
+		total, snapshot.Name, elapsed.Round(time.Millisecond), float64(total)/(1<<20)/max(elapsed.Seconds(), 1e-3))
+	return nil
+}
+
+// prefetchFile reads the file at path, and returns the number of bytes read.
+func prefetchFile(ctx context.Context, path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prefetch %q: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+	if err := adviseSequential(file); err != nil {
+		// Reading works all the same, if less quickly.
+		logrus.Debugf("failed to advise sequential reading of %q: %s", path, err)
+	}
+	read, err := copyData(ctx, io.Discard, file, file)
+	if err != nil {
+		return read, fmt.Errorf("failed to prefetch %q: %w", filepath.Base(path), err)
+	}
+	return read, nil
+}
@@ -0,0 +1,14 @@
+package snapshot
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseSequential makes sure that the OS reads ahead of the reads of the
+// file.
+func adviseSequential(file *os.File) error {
+	_, err := unix.FcntlInt(file.Fd(), unix.F_RDAHEAD, 1)
+	return err
+}
@@ -0,0 +1,13 @@
+package snapshot
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseSequential tells the OS that the file will be read sequentially, so
+// that it reads ahead further.
+func adviseSequential(file *os.File) error {
+	return unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package snapshot
+
+import "os"
+
+// adviseSequential does nothing, as there is no way to advise the OS on how
+// files are read here; Windows reads ahead of sequential reads by itself.
+func adviseSequential(*os.File) error {
+	return nil
+}
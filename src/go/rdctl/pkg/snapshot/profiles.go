@@ -0,0 +1,62 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The name of the file, in the config directory, holding the profiles for
+// creating snapshots. It maps each profile name to the CreateOptions it
+// stands for, in their JSON form:
+//
+//	{
+//	  "nightly": {"description": "Nightly snapshot", "requireHealthy": true}
+//	}
+const createProfilesFileName = "snapshot-profiles.json"
+
+// CreateProfilesPath returns the path of the file holding the profiles for
+// creating snapshots.
+func (manager *Manager) CreateProfilesPath() string {
+	return filepath.Join(manager.Config, createProfilesFileName)
+}
+
+// CreateProfile returns the options stored in the profile with the given
+// name, to pass to CreateWithOptions. Profile names are case-sensitive.
+// Options that are not known are an error, rather than being ignored, so
+// that typos in the file don't go unnoticed.
+func (manager *Manager) CreateProfile(name string) (CreateOptions, error) {
+	path := manager.CreateProfilesPath()
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return CreateOptions{}, fmt.Errorf("unknown snapshot profile %q: %s does not exist", name, path)
+	} else if err != nil {
+		return CreateOptions{}, fmt.Errorf("failed to read snapshot profiles: %w", err)
+	}
+	var profiles map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &profiles); err != nil {
+		return CreateOptions{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(profiles))
+		if len(names) == 0 {
+			return CreateOptions{}, fmt.Errorf("unknown snapshot profile %q: %s has no profiles", name, path)
+		}
+		return CreateOptions{}, fmt.Errorf("unknown snapshot profile %q; the profiles in %s are: %s",
+			name, path, strings.Join(names, ", "))
+	}
+	var opts CreateOptions
+	decoder := json.NewDecoder(bytes.NewReader(profile))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&opts); err != nil {
+		return CreateOptions{}, fmt.Errorf("invalid snapshot profile %q in %s: %w", name, path, err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,34 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is returned by operations that would change the snapshots
+// directory when it is read-only; see ManagerConfig.ReadOnly.
+var ErrReadOnly = errors.New("the snapshots directory is read-only")
+
+// readOnly returns whether the snapshots directory is read-only, either as
+// configured or because it is on storage that can't be written to.
+func (manager *Manager) readOnly() bool {
+	return manager.config.ReadOnly || isReadOnlyDirectory(manager.Snapshots)
+}
+
+// checkWritable returns ErrReadOnly if the snapshots directory is read-only,
+// so that operations changing it fail before doing anything.
+func (manager *Manager) checkWritable() error {
+	if manager.readOnly() {
+		return fmt.Errorf("%w: %s", ErrReadOnly, manager.Snapshots)
+	}
+	return nil
+}
+
+// stateDirectory returns the directory holding the files that operations
+// write besides the snapshots themselves, such as their logs.
+func (manager *Manager) stateDirectory() string {
+	if manager.config.StateDirectory != "" {
+		return manager.config.StateDirectory
+	}
+	return manager.Snapshots
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package snapshot
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// isReadOnlyDirectory returns whether dir exists and is on a read-only
+// filesystem, or can't be written to by this process. It writes nothing, so
+// that watchers of the directory see no changes.
+func isReadOnlyDirectory(dir string) bool {
+	err := unix.Access(dir, unix.W_OK)
+	return errors.Is(err, unix.EROFS) || errors.Is(err, unix.EACCES)
+}
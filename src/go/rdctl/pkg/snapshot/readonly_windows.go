@@ -0,0 +1,8 @@
+package snapshot
+
+// isReadOnlyDirectory returns false: Windows has no way to tell whether a
+// directory can be written to short of writing to it, so read-only snapshots
+// directories must be configured with ManagerConfig.ReadOnly.
+func isReadOnlyDirectory(dir string) bool {
+	return false
+}
@@ -0,0 +1,35 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/client"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/config"
+)
+
+// reloadSettings has the running app apply the settings in settingsPath, as
+// `rdctl set` would; settings it can only apply by restarting the backend
+// restart it. If the app isn't running, there is nothing to do, as it reads
+// the settings when it starts. Tests replace it.
+var reloadSettings = func(ctx context.Context, settingsPath string) error {
+	contents, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read settings: %w", err)
+	}
+	connectionInfo, err := config.GetConnectionInfo(true)
+	if err != nil || connectionInfo == nil {
+		return err
+	}
+	rdClient := client.NewRDClient(connectionInfo)
+	command := client.VersionCommand("", "settings")
+	_, err = client.ProcessRequestForUtility(rdClient.DoRequestWithPayload(ctx, http.MethodPut, command, bytes.NewReader(contents)))
+	if errors.Is(err, client.ErrConnectionRefused) {
+		return nil
+	}
+	return err
+}
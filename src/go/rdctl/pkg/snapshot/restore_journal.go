@@ -0,0 +1,244 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/runner"
+)
+
+// The name of the restore journal, in the application home directory. It is
+// kept outside of the snapshots directory so that a factory reset, which
+// keeps the snapshots, removes it along with the partially restored files.
+const restoreJournalFileName = "snapshot-restore-journal.json"
+
+// ErrRestoreIncomplete is returned when the working files are only partially
+// restored from a snapshot, because an earlier restore was interrupted. The
+// restore can be continued with RestoreOptions.Resume.
+var ErrRestoreIncomplete = errors.New("a snapshot restore is incomplete")
+
+// restoreJournal records the progress of a restore, so that an interrupted
+// restore can be resumed instead of started over. While the journal exists,
+// the working files are in an intermediate state: the components it lists
+// have been restored from the snapshot, and the files of the others are
+// missing, though some of them may be staged to be swapped into place.
+type restoreJournal struct {
+	// The path the journal is saved to.
+	path string
+	// The ID of the snapshot being restored.
+	SnapshotID string `json:"snapshotID"`
+	// The name of the snapshot being restored, for messages.
+	SnapshotName string `json:"snapshotName"`
+	// When the restore was first started.
+	Started time.Time `json:"started"`
+	// The SHA-256 checksums of the files that have been restored so far, by
+	// the path they were restored to: the working path, or the staging path
+	// for the files of a component that is yet to be swapped into place. An
+	// empty checksum means the file was removed because the snapshot does
+	// not include it.
+	Restored map[string]string `json:"restored"`
+	// The components that have been restored so far, in order.
+	Components []string `json:"components,omitempty"`
+}
+
+func (manager *Manager) restoreJournalPath() string {
+	return filepath.Join(manager.AppHome, restoreJournalFileName)
+}
+
+// readRestoreJournal returns the journal of an incomplete restore, or nil if
+// there is none.
+func (manager *Manager) readRestoreJournal() (*restoreJournal, error) {
+	journalPath := manager.restoreJournalPath()
+	contents, err := os.ReadFile(journalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read restore journal: %w", err)
+	}
+	journal := &restoreJournal{path: journalPath}
+	if err := json.Unmarshal(contents, journal); err != nil {
+		return nil, fmt.Errorf("failed to parse restore journal %q: %w", journalPath, err)
+	}
+	if journal.Restored == nil {
+		journal.Restored = make(map[string]string)
+	}
+	return journal, nil
+}
+
+// prepareRestoreJournal returns the journal to record the restore of the
+// snapshot in. A new restore replaces the journal of any earlier incomplete
+// one, as it restores all files again anyway. It returns nil if restores
+// can't be resumed on this platform.
+func (manager *Manager) prepareRestoreJournal(snapshot Snapshot, opts RestoreOptions) (*restoreJournal, error) {
+	if !resumableRestore {
+		if opts.Resume || opts.RateLimit != 0 {
+			return nil, errors.New("resuming and rate limiting restores are not supported on this platform")
+		}
+		return nil, nil
+	}
+	if opts.RateLimit < 0 {
+		return nil, fmt.Errorf("invalid rate limit %d", opts.RateLimit)
+	}
+	if opts.Resume {
+		journal, err := manager.readRestoreJournal()
+		if err != nil {
+			return nil, err
+		}
+		if journal == nil {
+			return nil, errors.New("there is no incomplete restore to resume")
+		}
+		if journal.SnapshotID != snapshot.ID {
+			return nil, fmt.Errorf("%w: cannot resume it with snapshot %q, as it restores snapshot %q",
+				ErrRestoreIncomplete, snapshot.Name, journal.SnapshotName)
+		}
+		return journal, nil
+	}
+	journal := &restoreJournal{
+		path:         manager.restoreJournalPath(),
+		SnapshotID:   snapshot.ID,
+		SnapshotName: snapshot.Name,
+		Started:      time.Now(),
+		Restored:     make(map[string]string),
+	}
+	if err := journal.save(); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// save writes the journal to disk. A temporary file is written first, so
+// that an interruption never leaves a journal that can't be read.
+func (journal *restoreJournal) save() error {
+	if err := os.MkdirAll(filepath.Dir(journal.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create restore journal directory: %w", err)
+	}
+	journalFile, err := os.CreateTemp(filepath.Dir(journal.path), restoreJournalFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create restore journal: %w", err)
+	}
+	defer os.Remove(journalFile.Name())
+	encoder := json.NewEncoder(journalFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(journal); err != nil {
+		_ = journalFile.Close()
+		return fmt.Errorf("failed to write restore journal: %w", err)
+	}
+	if err := journalFile.Sync(); err != nil {
+		_ = journalFile.Close()
+		return fmt.Errorf("failed to write restore journal: %w", err)
+	}
+	if err := journalFile.Close(); err != nil {
+		return fmt.Errorf("failed to write restore journal: %w", err)
+	}
+	if err := os.Rename(journalFile.Name(), journal.path); err != nil {
+		return fmt.Errorf("failed to write restore journal: %w", err)
+	}
+	return nil
+}
+
+// markRestored records that the file at workingPath has been restored, with
+// the given checksum, and saves the journal.
+func (journal *restoreJournal) markRestored(workingPath, checksum string) error {
+	journal.Restored[workingPath] = checksum
+	return journal.save()
+}
+
+// isRestored reports whether the file at workingPath is listed as restored
+// and still has the checksum it was restored with. Files that were changed
+// since, for example by starting Rancher Desktop in between, must be
+// restored again.
+func (journal *restoreJournal) isRestored(ctx context.Context, workingPath string, rateLimit int64) (bool, error) {
+	expected, ok := journal.Restored[workingPath]
+	if !ok {
+		return false, nil
+	}
+	if expected == "" {
+		_, err := os.Lstat(workingPath)
+		return errors.Is(err, os.ErrNotExist), nil
+	}
+	actual, err := checksumFile(ctx, workingPath, rateLimit)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return actual == expected, nil
+}
+
+// remove deletes the journal once the restore is complete.
+func (journal *restoreJournal) remove() error {
+	if err := os.Remove(journal.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove restore journal: %w", err)
+	}
+	return nil
+}
+
+// checksumFile returns the hex encoded SHA-256 checksum of a file, reading it
+// at no more than rateLimit bytes per second.
+func checksumFile(ctx context.Context, path string, rateLimit int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, newThrottledReader(ctx, file, rateLimit)); err != nil {
+		return "", fmt.Errorf("failed to checksum %q: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// throttledReader limits the average rate at which data is read from the
+// underlying reader, and stops reading once its context is done so that long
+// copies can be interrupted.
+type throttledReader struct {
+	ctx    context.Context
+	reader io.Reader
+	// The maximum number of bytes per second; zero for no limit.
+	limit int64
+	start time.Time
+	total int64
+}
+
+func newThrottledReader(ctx context.Context, reader io.Reader, limit int64) *throttledReader {
+	return &throttledReader{
+		ctx:    ctx,
+		reader: reader,
+		limit:  limit,
+		start:  time.Now(),
+	}
+}
+
+func (reader *throttledReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%w: %w", runner.ErrContextDone, err)
+	}
+	// Read at most a second's worth of data at a time, so that the rate
+	// stays even for large buffers.
+	if reader.limit > 0 && int64(len(p)) > reader.limit {
+		p = p[:reader.limit]
+	}
+	n, err := reader.reader.Read(p)
+	reader.total += int64(n)
+	if reader.limit > 0 {
+		due := reader.start.Add(time.Duration(float64(reader.total) / float64(reader.limit) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-reader.ctx.Done():
+				return n, fmt.Errorf("%w: %w", runner.ErrContextDone, reader.ctx.Err())
+			case <-timer.C:
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,291 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
+)
+
+// The self-test creates a snapshot of synthetic files and restores it, in a
+// throwaway directory inside the snapshots directory, so that it runs on the
+// same filesystem as real snapshots. The directory has its own application
+// directories and snapshots directory, and the manager working on it never
+// stops the backend, so the live state is never touched. Entries starting
+// with selfTestDirPrefix are not snapshots, and are skipped by List and Fsck.
+const selfTestDirPrefix = ".selftest-"
+
+// Throwaway directories older than this were left behind by a self-test
+// that did not finish, and are removed by the next one.
+const selfTestStaleAge = time.Hour
+
+// The name of the snapshot the self-test creates.
+const selfTestSnapshotName = "self-test"
+
+// The steps of a self-test, in the order they are run.
+const (
+	// Create the throwaway directory and the synthetic working files.
+	SelfTestPrepare = "prepare"
+	// Create a snapshot of the working files.
+	SelfTestCreate = "create"
+	// Check that the snapshot is complete and has no problems.
+	SelfTestVerify = "verify"
+	// Change the working files, restore the snapshot, and compare the
+	// restored files to the originals.
+	SelfTestRestore = "restore"
+	// Remove the throwaway directory.
+	SelfTestCleanup = "cleanup"
+)
+
+// ErrSelfTestFailed is returned by SelfTest when a step failed.
+var ErrSelfTestFailed = errors.New("snapshot self-test failed")
+
+// SelfTestOptions modifies the behaviour of Manager.SelfTest.
+type SelfTestOptions struct {
+	// Don't restore the snapshot; only create and verify it.
+	SkipRestore bool
+}
+
+// SelfTestStep is the result of one step of a self-test.
+type SelfTestStep struct {
+	Name string `json:"name"`
+	// Whether the step ran and succeeded.
+	Passed bool `json:"passed"`
+	// Whether the step was not run, because it was not asked for or an
+	// earlier step failed.
+	Skipped bool `json:"skipped,omitempty"`
+	// Why the step failed, if it did.
+	Error string `json:"error,omitempty"`
+	// How long the step took.
+	Duration time.Duration `json:"duration"`
+}
+
+// SelfTestReport is the result of SelfTest.
+type SelfTestReport struct {
+	// The throwaway directory the self-test ran in.
+	Directory string `json:"directory"`
+	// The steps, in the order they were run.
+	Steps []SelfTestStep `json:"steps"`
+}
+
+// Passed returns whether no step failed.
+func (report SelfTestReport) Passed() bool {
+	for _, step := range report.Steps {
+		if !step.Passed && !step.Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// noBackendLock is a lock.BackendLocker that does nothing, for managers that
+// don't work on the files of the backend.
+type noBackendLock struct{}
+
+func (noBackendLock) Lock(context.Context, *paths.Paths, string) error {
+	return nil
+}
+
+func (noBackendLock) Unlock(context.Context, *paths.Paths, bool) error {
+	return nil
+}
+
+// selfTest holds the state of a self-test between steps.
+type selfTest struct {
+	// The manager of the throwaway directory.
+	manager *Manager
+	// The contents of the synthetic working files, by path.
+	files map[string][]byte
+}
+
+// SelfTest checks that snapshots can be created and restored on this machine,
+// by creating a snapshot of a few small synthetic files and restoring it, in a
+// throwaway directory inside the snapshots directory, and reports how each
+// step went. Problems with permissions, space, locking or the filesystem of
+// the snapshots directory show up before a real snapshot is relied upon.
+// The backend is not stopped, and neither the working files nor the existing
+// snapshots are touched; the throwaway directory is removed afterwards, so
+// the self-test can be run any number of times. ErrSelfTestFailed is returned
+// if any step failed; once a step fails, the following ones are skipped,
+// except for the cleanup.
+func (manager *Manager) SelfTest(ctx context.Context, opts SelfTestOptions) (SelfTestReport, error) {
+	report := SelfTestReport{Steps: []SelfTestStep{}}
+	test := &selfTest{}
+	var firstErr error
+	run := func(name string, skip bool, step func() error) {
+		if skip {
+			report.Steps = append(report.Steps, SelfTestStep{Name: name, Skipped: true})
+			return
+		}
+		start := time.Now()
+		err := step()
+		// Cleaning up is done whether or not the self-test was cancelled.
+		if err == nil && name != SelfTestCleanup && contextIsDone(ctx) {
+			err = ctx.Err()
+		}
+		result := SelfTestStep{Name: name, Passed: err == nil, Duration: time.Since(start)}
+		if err != nil {
+			result.Error = err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w: step %s: %w", ErrSelfTestFailed, name, err)
+			}
+		}
+		report.Steps = append(report.Steps, result)
+	}
+
+	run(SelfTestPrepare, false, func() error {
+		return test.prepare(manager, &report)
+	})
+	run(SelfTestCreate, firstErr != nil, func() error {
+		return test.create(ctx)
+	})
+	run(SelfTestVerify, firstErr != nil, test.verify)
+	run(SelfTestRestore, firstErr != nil || opts.SkipRestore, func() error {
+		return test.restore(ctx)
+	})
+	// Clean up whatever the other steps did, even if they failed.
+	run(SelfTestCleanup, report.Directory == "", func() error {
+		return test.cleanup(report.Directory)
+	})
+	return report, firstErr
+}
+
+// prepare creates the throwaway directory, recording it in the report, and
+// writes the synthetic working files in it.
+func (test *selfTest) prepare(manager *Manager, report *SelfTestReport) error {
+	if err := manager.checkWritable(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(manager.Snapshots, 0o755); err != nil {
+		return fmt.Errorf("failed to create snapshots directory: %w", err)
+	}
+	removeStaleSelfTests(manager.Snapshots)
+	root, err := os.MkdirTemp(manager.Snapshots, selfTestDirPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create throwaway directory: %w", err)
+	}
+	report.Directory = root
+	appPaths := &paths.Paths{
+		AppHome:       filepath.Join(root, "app"),
+		Config:        filepath.Join(root, "config"),
+		Logs:          filepath.Join(root, "logs"),
+		Cache:         filepath.Join(root, "cache"),
+		Lima:          filepath.Join(root, "lima"),
+		WslDistro:     filepath.Join(root, "distro"),
+		WslDistroData: filepath.Join(root, "distro-data"),
+		Snapshots:     filepath.Join(root, "snapshots"),
+	}
+	if test.manager, err = newManager(appPaths, newSelfTestSnapshotter(appPaths), noBackendLock{}); err != nil {
+		return err
+	}
+	test.files = map[string][]byte{}
+	for _, path := range selfTestFiles(appPaths) {
+		contents := fmt.Appendf(nil, "Rancher Desktop snapshot self-test: %s\n", filepath.Base(path))
+		if filepath.Base(path) == "settings.json" {
+			// Restoring checks the settings version.
+			if contents, err = json.Marshal(map[string]int{"version": currentSettingsVersion}); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", filepath.Base(path), err)
+		}
+		if err := os.WriteFile(path, contents, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+		}
+		test.files[path] = contents
+	}
+	return nil
+}
+
+// create creates the snapshot of the synthetic files.
+func (test *selfTest) create(ctx context.Context) error {
+	_, err := test.manager.Create(ctx, selfTestSnapshotName, "")
+	return err
+}
+
+// verify checks that the snapshot is listed as complete, and that Fsck finds
+// no problems with it.
+func (test *selfTest) verify() error {
+	if _, err := test.manager.Snapshot(selfTestSnapshotName); err != nil {
+		return err
+	}
+	report, err := test.manager.Fsck(false)
+	if err != nil {
+		return err
+	}
+	if report.Checked != 1 {
+		return fmt.Errorf("expected 1 snapshot, found %d", report.Checked)
+	}
+	if len(report.Problems) > 0 {
+		problem := report.Problems[0]
+		return fmt.Errorf("%s: %s", problem.Kind, problem.Detail)
+	}
+	return nil
+}
+
+// restore changes the working files, restores the snapshot, and checks that
+// the files are back as they were.
+func (test *selfTest) restore(ctx context.Context) error {
+	for path := range test.files {
+		if err := os.WriteFile(path, []byte("changed by the snapshot self-test\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to change %s: %w", filepath.Base(path), err)
+		}
+	}
+	if err := test.manager.Restore(ctx, selfTestSnapshotName, RestoreOptions{}); err != nil {
+		return err
+	}
+	var mismatched []string
+	for path, expected := range test.files {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read restored %s: %w", filepath.Base(path), err)
+		}
+		if !bytes.Equal(contents, expected) {
+			mismatched = append(mismatched, filepath.Base(path))
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("restored files differ from the originals: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
+}
+
+// cleanup removes the throwaway directory.
+func (test *selfTest) cleanup(root string) error {
+	var errs []error
+	if test.manager != nil {
+		errs = append(errs, test.manager.Close())
+	}
+	if err := os.RemoveAll(root); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove throwaway directory: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// removeStaleSelfTests removes the throwaway directories of self-tests that did
+// not finish. Recent ones may belong to a self-test that is still running, and
+// are left alone; failing to remove one doesn't fail the self-test.
+func removeStaleSelfTests(snapshotsDir string) {
+	entries, err := os.ReadDir(snapshotsDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), selfTestDirPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < selfTestStaleAge {
+			continue
+		}
+		_ = os.RemoveAll(filepath.Join(snapshotsDir, entry.Name()))
+	}
+}
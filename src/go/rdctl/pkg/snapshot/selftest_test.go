@@ -0,0 +1,183 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTree returns the contents of the regular files under root, by path.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		contents, err := os.ReadFile(path)
+		tree[path] = string(contents)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read %q: %s", root, err)
+	}
+	return tree
+}
+
+// selfTestEntries returns the names of the throwaway directories of
+// self-tests in the snapshots directory.
+func selfTestEntries(t *testing.T, snapshotsDir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(snapshotsDir)
+	if err != nil {
+		t.Fatalf("failed to read snapshots directory: %s", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), selfTestDirPrefix) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestSelfTest(t *testing.T) {
+	stepStates := func(report SelfTestReport) map[string]string {
+		states := map[string]string{}
+		for _, step := range report.Steps {
+			switch {
+			case step.Skipped:
+				states[step.Name] = "skipped"
+			case step.Passed:
+				states[step.Name] = "passed"
+			default:
+				states[step.Name] = "failed: " + step.Error
+			}
+		}
+		return states
+	}
+
+	t.Run("passes without touching the live state, and can be repeated", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		snapshot, err := manager.Create(context.Background(), "existing", "")
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %s", err)
+		}
+		before := readTree(t, filepath.Dir(appPaths.Snapshots))
+		for range 2 {
+			report, err := manager.SelfTest(context.Background(), SelfTestOptions{})
+			if err != nil {
+				t.Fatalf("self-test failed: %s", err)
+			}
+			if !report.Passed() {
+				t.Errorf("expected the report to pass: %+v", report)
+			}
+			for _, name := range []string{SelfTestPrepare, SelfTestCreate, SelfTestVerify, SelfTestRestore, SelfTestCleanup} {
+				if state := stepStates(report)[name]; state != "passed" {
+					t.Errorf("expected step %s to pass, got %q", name, state)
+				}
+			}
+			if _, err := os.Stat(report.Directory); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected throwaway directory %q to be removed: %v", report.Directory, err)
+			}
+		}
+		if after := readTree(t, filepath.Dir(appPaths.Snapshots)); len(after) != len(before) {
+			t.Errorf("expected %d files after the self-test, got %d", len(before), len(after))
+		} else {
+			for path, contents := range before {
+				if after[path] != contents {
+					t.Errorf("file %q changed", path)
+				}
+			}
+		}
+		if names := selfTestEntries(t, appPaths.Snapshots); len(names) != 0 {
+			t.Errorf("throwaway directories left behind: %v", names)
+		}
+		if _, err := manager.Snapshot(snapshot.Name); err != nil {
+			t.Errorf("existing snapshot is gone: %s", err)
+		}
+	})
+
+	t.Run("skips restoring when asked to", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		report, err := newTestManager(appPaths).SelfTest(context.Background(), SelfTestOptions{SkipRestore: true})
+		if err != nil {
+			t.Fatalf("self-test failed: %s", err)
+		}
+		states := stepStates(report)
+		if states[SelfTestRestore] != "skipped" || states[SelfTestCleanup] != "passed" {
+			t.Errorf("unexpected steps: %v", states)
+		}
+	})
+
+	t.Run("fails on a read-only snapshots directory", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		manager := newTestManager(appPaths)
+		manager.config.ReadOnly = true
+		report, err := manager.SelfTest(context.Background(), SelfTestOptions{})
+		if !errors.Is(err, ErrSelfTestFailed) || !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected a read-only failure, got %v", err)
+		}
+		if report.Passed() {
+			t.Errorf("expected the report to fail")
+		}
+		states := stepStates(report)
+		if !strings.HasPrefix(states[SelfTestPrepare], "failed: ") {
+			t.Errorf("expected step %s to fail, got %q", SelfTestPrepare, states[SelfTestPrepare])
+		}
+		for _, name := range []string{SelfTestCreate, SelfTestVerify, SelfTestRestore, SelfTestCleanup} {
+			if states[name] != "skipped" {
+				t.Errorf("expected step %s to be skipped, got %q", name, states[name])
+			}
+		}
+	})
+
+	t.Run("reports a cancelled self-test, and still cleans up", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		report, err := newTestManager(appPaths).SelfTest(ctx, SelfTestOptions{})
+		if !errors.Is(err, ErrSelfTestFailed) || !errors.Is(err, context.Canceled) {
+			t.Errorf("expected a cancelled self-test, got %v", err)
+		}
+		if state := stepStates(report)[SelfTestCleanup]; state != "passed" {
+			t.Errorf("expected the cleanup to pass, got %q", state)
+		}
+		if names := selfTestEntries(t, appPaths.Snapshots); len(names) != 0 {
+			t.Errorf("throwaway directories left behind: %v", names)
+		}
+	})
+
+	t.Run("removes stale throwaway directories", func(t *testing.T) {
+		appPaths, _ := populateFiles(t, true)
+		stale := filepath.Join(appPaths.Snapshots, selfTestDirPrefix+"stale")
+		recent := filepath.Join(appPaths.Snapshots, selfTestDirPrefix+"recent")
+		for _, dir := range []string{stale, recent} {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatalf("failed to create %q: %s", dir, err)
+			}
+		}
+		old := time.Now().Add(-2 * selfTestStaleAge)
+		if err := os.Chtimes(stale, old, old); err != nil {
+			t.Fatalf("failed to set the time of %q: %s", stale, err)
+		}
+		manager := newTestManager(appPaths)
+		if report, err := manager.Fsck(false); err != nil {
+			t.Fatalf("fsck failed: %s", err)
+		} else if len(report.Problems) != 0 {
+			t.Errorf("expected fsck to skip throwaway directories, got %+v", report.Problems)
+		}
+		if _, err := manager.SelfTest(context.Background(), SelfTestOptions{}); err != nil {
+			t.Fatalf("self-test failed: %s", err)
+		}
+		names := selfTestEntries(t, appPaths.Snapshots)
+		if len(names) != 1 || names[0] != filepath.Base(recent) {
+			t.Errorf("expected only %q to be left, got %v", filepath.Base(recent), names)
+		}
+	})
+}
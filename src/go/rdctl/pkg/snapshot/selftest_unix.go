@@ -0,0 +1,24 @@
+//go:build unix
+
+package snapshot
+
+import (
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
+)
+
+// newSelfTestSnapshotter returns the Snapshotter of SelfTest, working on the
+// throwaway directories in appPaths. The files of a snapshot are only found
+// through appPaths, so it is the usual one.
+func newSelfTestSnapshotter(_ *paths.Paths) Snapshotter {
+	return NewSnapshotterImpl()
+}
+
+// selfTestFiles returns the working files SelfTest writes in appPaths: all of
+// those snapshots hold, including the optional ones.
+func selfTestFiles(appPaths *paths.Paths) []string {
+	var files []string
+	for _, file := range (SnapshotterImpl{}).Files(appPaths, "") {
+		files = append(files, file.WorkingPath)
+	}
+	return files
+}
@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
+)
+
+// The name of the disk of a WSL distro, in its working directory.
+const selfTestDiskName = "ext4.vhdx"
+
+// selfTestWSL stands in for wsl.exe in SelfTest: the WSL distros are
+// registered by name for the whole machine, so the real ones can't be
+// exported or imported without touching the live state. Instead, the disk of
+// each distro in the throwaway directory is copied to and from its archive.
+type selfTestWSL struct {
+	distros []wslDistro
+}
+
+// workingDisk returns the disk of the distro with the given name.
+func (wsl selfTestWSL) workingDisk(distroName string) (string, error) {
+	for _, distro := range wsl.distros {
+		if distro.Name == distroName {
+			return filepath.Join(distro.WorkingDirPath, selfTestDiskName), nil
+		}
+	}
+	return "", fmt.Errorf("unknown distro %q", distroName)
+}
+
+func (wsl selfTestWSL) UnregisterDistros(_ context.Context) error {
+	var errs []error
+	for _, distro := range wsl.distros {
+		if err := os.Remove(filepath.Join(distro.WorkingDirPath, selfTestDiskName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (wsl selfTestWSL) ExportDistro(ctx context.Context, distroName, fileName string) error {
+	disk, err := wsl.workingDisk(distroName)
+	if err != nil {
+		return err
+	}
+	return copyFile(ctx, fileName, disk)
+}
+
+func (wsl selfTestWSL) ImportDistro(ctx context.Context, distroName, installLocation, fileName string) error {
+	if _, err := wsl.workingDisk(distroName); err != nil {
+		return err
+	}
+	return copyFile(ctx, filepath.Join(installLocation, selfTestDiskName), fileName)
+}
+
+// newSelfTestSnapshotter returns the Snapshotter of SelfTest, working on the
+// throwaway directories in appPaths; see selfTestWSL.
+func newSelfTestSnapshotter(appPaths *paths.Paths) Snapshotter {
+	return SnapshotterImpl{
+		WSL: selfTestWSL{distros: SnapshotterImpl{}.WSLDistros(appPaths)},
+	}
+}
+
+// selfTestFiles returns the working files SelfTest writes in appPaths: the
+// settings, and a disk for each WSL distro.
+func selfTestFiles(appPaths *paths.Paths) []string {
+	files := []string{filepath.Join(appPaths.Config, "settings.json")}
+	for _, distro := range (SnapshotterImpl{}).WSLDistros(appPaths) {
+		files = append(files, filepath.Join(distro.WorkingDirPath, selfTestDiskName))
+	}
+	return files
+}
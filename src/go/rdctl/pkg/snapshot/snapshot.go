@@ -5,24 +5,74 @@ import (
 	"time"
 )
 
+// Snapshot describes a snapshot. It is the public form of a snapshot's
+// metadata, and is part of the API of this package: its fields, and the JSON
+// form used for `rdctl snapshot list --json`, only change compatibly. Fields
+// may be added, but existing ones are not renamed, removed or given a
+// different meaning. How the metadata is stored in a snapshot is internal
+// (see metadata), and may change without affecting callers.
 type Snapshot struct {
-	Created     time.Time `json:"created"`
-	Name        string    `json:"name"`
-	ID          string    `json:"id,omitempty"`
-	Description string    `json:"description"`
+	// When the snapshot was created, in local time.
+	Created time.Time `json:"created"`
+	// The name of the snapshot, unique among snapshots ignoring case.
+	Name string `json:"name"`
+	// The ID of the snapshot, which names its directory.
+	ID string `json:"id,omitempty"`
+	// The description given when creating the snapshot; may be empty.
+	Description string `json:"description"`
+	// The version of settings.json at the time the snapshot was created;
+	// zero for snapshots created before this was recorded.
+	SettingsVersion int `json:"settingsVersion,omitempty"`
+	// The versions of the components in use when the snapshot was created,
+	// by component name, such as ComponentKubernetes; Restore migrates
+	// components whose version differs from the one in use then.
+	ComponentVersions map[string]string `json:"componentVersions,omitempty"`
+	// The last time the snapshot was restored (or touched), in local time;
+	// zero if it never was.
+	LastUsed time.Time `json:"lastUsed,omitzero"`
+	// The health of the Kubernetes cluster when the snapshot was created;
+	// nil if it wasn't checked.
+	ClusterHealth *ClusterHealth `json:"clusterHealth,omitempty"`
+	// The machine the snapshot was created on; nil for snapshots created
+	// before this was recorded.
+	Host *HostIdentity `json:"host,omitempty"`
+	// Whether the snapshot is protected from being deleted; see
+	// Manager.SetProtected.
+	Protected bool `json:"protected,omitempty"`
+	// The logs captured with the snapshot; nil if none were. They are never
+	// restored; see Manager.ReadCapturedLogs.
+	CapturedLogs *CapturedLogs `json:"capturedLogs,omitempty"`
+	// A digest of the stored metadata, which changes if the snapshot is
+	// replaced or its metadata is edited, but not when it is only restored,
+	// touched or protected. Pass it as RestoreOptions.ExpectedDigest to make sure the
+	// snapshot restored is the one that was listed.
+	Digest string `json:"digest,omitempty"`
+	// The result of verifying the snapshot once it was created, with
+	// CreateOptions.VerifyAfterCreate. It is not stored, so it is only set
+	// on the snapshot returned by Manager.CreateWithOptions.
+	Verification *Verification `json:"verification,omitempty"`
 }
 
 func (s *Snapshot) getTimeString() string {
 	return s.Created.Format(time.RFC3339)
 }
 
+func (s *Snapshot) getLastUsedString() string {
+	if s.LastUsed.IsZero() {
+		return ""
+	}
+	return s.LastUsed.Format(time.RFC3339)
+}
+
 func (s *Snapshot) MarshalJSON() ([]byte, error) {
 	type Alias Snapshot
 	return json.Marshal(&struct {
 		*Alias
-		Created string `json:"created"`
+		Created  string `json:"created"`
+		LastUsed string `json:"lastUsed,omitempty"`
 	}{
-		Alias:   (*Alias)(s),
-		Created: s.getTimeString(),
+		Alias:    (*Alias)(s),
+		Created:  s.getTimeString(),
+		LastUsed: s.getLastUsedString(),
 	})
 }
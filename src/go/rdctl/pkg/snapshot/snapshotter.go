@@ -3,6 +3,7 @@ package snapshot
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
 )
@@ -13,15 +14,49 @@ import (
 type Snapshotter interface {
 	// Does all of the things that can fail when creating a snapshot,
 	// so that the snapshot creation can easily be rolled back upon
-	// a failure.
-	CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string) error
+	// a failure. With opts.Deduplicate, the contents of the files are put in
+	// the shared object store rather than in snapshotDir.
+	CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, opts CreateOptions) error
 	// Like CreateFiles, but for restoring: does all of the things
 	// that can fail when restoring a snapshot so that restoration can
 	// easily be rolled back in the event of a failure. Returns ErrDataReset
-	// when data has been reset due to an error in this process.
-	RestoreFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string) error
+	// when data has been reset due to an error in this process. If journal
+	// is not nil, the files it lists as restored are skipped, and files are
+	// added to it as they are restored, so that an interrupted restore can
+	// be resumed.
+	RestoreFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, opts RestoreOptions, journal *restoreJournal) error
 }
 
+// The component of the settings, which is the same on every platform; see
+// RestorableComponents for the others.
+const componentSettings = "settings"
+
+// The components the running app can reload, so that they can be restored
+// without stopping the backend; see RestoreOptions.Live.
+var reloadableComponents = []string{componentSettings}
+
 // Returned by Snapshotter.RestoreFiles when data has been reset
 // due to an error restoring the files.
 var ErrDataReset = errors.New("data reset")
+
+// ComponentRestoreError is returned by Snapshotter.RestoreFiles, along with
+// ErrDataReset, when a component of the working files could not be restored.
+// Each component, such as the VM or its configuration, is restored as a
+// whole: the components restored before the failure are left restored, and
+// the others are reset.
+type ComponentRestoreError struct {
+	// The component that failed.
+	Component string
+	// The components that were restored, in the order they were restored.
+	Restored []string
+	// Why the component failed.
+	Err error
+}
+
+func (err *ComponentRestoreError) Error() string {
+	return fmt.Sprintf("failed to restore component %q: %s", err.Component, err.Err)
+}
+
+func (err *ComponentRestoreError) Unwrap() error {
+	return err.Err
+}
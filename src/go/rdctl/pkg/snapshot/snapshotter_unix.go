@@ -4,10 +4,19 @@ package snapshot
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/runner"
@@ -29,8 +38,62 @@ type snapshotFile struct {
 	MissingOk bool
 	// The permissions the file should have.
 	FileMode os.FileMode
+	// The component the file is part of; the files of a component are
+	// restored together.
+	Component string
 }
 
+// The components of the working files. A restore swaps the files of each
+// component into place once all of them have been copied, so that a failure
+// never leaves a component partly restored. The settings.json file is
+// componentSettings.
+const (
+	componentLimaConfig = "lima-config"
+	componentVM         = "vm"
+)
+
+// The component holding the data of the VM, which is migrated when restored;
+// see Manager.componentMigrations.
+const dataComponent = componentVM
+
+// RestorableComponents returns the components of a snapshot, as named in
+// RestoreOptions.Components, in the order they are restored.
+func RestorableComponents() []string {
+	return groupComponents(SnapshotterImpl{}.Files(&paths.Paths{}, ""))
+}
+
+// estimateSources returns the files EstimateSize sizes: the working files
+// that are copied into snapshots.
+func estimateSources(appPaths *paths.Paths) []sizedSource {
+	var sources []sizedSource
+	for _, file := range (SnapshotterImpl{}).Files(appPaths, "") {
+		sources = append(sources, sizedSource{Path: file.WorkingPath, MissingOk: file.MissingOk})
+	}
+	return sources
+}
+
+// The prefix of the name of a file staged to be swapped into place by a
+// restore; it is in the same directory as the working file.
+const stagingFilePrefix = ".restoring-"
+
+// Restores can be rate limited, and resumed after an interruption.
+const resumableRestore = true
+
+// Snapshots can keep their files in the shared object store.
+const deduplicatedSnapshots = true
+
+// The working files are plain copies of the files of snapshots, so a restore
+// can leave those that are unchanged alone; see RestoreOptions.MinimalChanges.
+const minimalRestore = true
+
+// The VM disks are cloned, rather than copied, on filesystems that support
+// it; see copyFile.
+const copyOnWriteCopies = true
+
+// The files in which Lima records the processes running an instance: the host
+// agent, which also runs the VM with the vz driver, and QEMU.
+var limaPIDFiles = []string{"ha.pid", "qemu.pid"}
+
 // SnapshotterImpl also works as a *Manager receiver
 type SnapshotterImpl struct {
 }
@@ -47,6 +110,7 @@ func (snapshotter SnapshotterImpl) Files(appPaths *paths.Paths, snapshotDir stri
 			CopyOnWrite:  false,
 			MissingOk:    false,
 			FileMode:     0o644,
+			Component:    componentSettings,
 		},
 		{
 			WorkingPath:  filepath.Join(appPaths.Lima, "_config", "override.yaml"),
@@ -54,6 +118,7 @@ func (snapshotter SnapshotterImpl) Files(appPaths *paths.Paths, snapshotDir stri
 			CopyOnWrite:  false,
 			MissingOk:    true,
 			FileMode:     0o644,
+			Component:    componentLimaConfig,
 		},
 		{
 			WorkingPath:        filepath.Join(appPaths.Lima, "0", "iso"),
@@ -62,6 +127,7 @@ func (snapshotter SnapshotterImpl) Files(appPaths *paths.Paths, snapshotDir stri
 			CopyOnWrite:        true,
 			MissingOk:          false,
 			FileMode:           0o644,
+			Component:          componentVM,
 		},
 		{
 			WorkingPath:        filepath.Join(appPaths.Lima, "0", "disk"),
@@ -70,6 +136,7 @@ func (snapshotter SnapshotterImpl) Files(appPaths *paths.Paths, snapshotDir stri
 			CopyOnWrite:        true,
 			MissingOk:          false,
 			FileMode:           0o644,
+			Component:          componentVM,
 		},
 		{
 			WorkingPath:  filepath.Join(appPaths.Lima, "_config", "user"),
@@ -77,6 +144,7 @@ func (snapshotter SnapshotterImpl) Files(appPaths *paths.Paths, snapshotDir stri
 			CopyOnWrite:  false,
 			MissingOk:    false,
 			FileMode:     0o600,
+			Component:    componentLimaConfig,
 		},
 		{
 			WorkingPath:  filepath.Join(appPaths.Lima, "_config", "user.pub"),
@@ -84,6 +152,7 @@ func (snapshotter SnapshotterImpl) Files(appPaths *paths.Paths, snapshotDir stri
 			CopyOnWrite:  false,
 			MissingOk:    false,
 			FileMode:     0o644,
+			Component:    componentLimaConfig,
 		},
 		{
 			WorkingPath:  filepath.Join(appPaths.Lima, "0", "lima.yaml"),
@@ -91,17 +160,55 @@ func (snapshotter SnapshotterImpl) Files(appPaths *paths.Paths, snapshotDir stri
 			CopyOnWrite:  false,
 			MissingOk:    false,
 			FileMode:     0o644,
+			Component:    componentVM,
 		},
 	}
 	return files
 }
 
-func (snapshotter SnapshotterImpl) CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string) error {
+// requiredSnapshotFiles lists the files a complete snapshot must contain; each
+// entry lists the paths the file may be found at, the current one first.
+func requiredSnapshotFiles(snapshotDir string) [][]string {
+	var required [][]string
+	for _, file := range (SnapshotterImpl{}).Files(&paths.Paths{}, snapshotDir) {
+		if file.MissingOk {
+			continue
+		}
+		candidates := []string{file.SnapshotPath}
+		if file.LegacySnapshotPath != "" {
+			candidates = append(candidates, file.LegacySnapshotPath)
+		}
+		required = append(required, candidates)
+	}
+	return required
+}
+
+// verifiedFiles lists the files verifySnapshot checks: each snapshot file is
+// a copy of its working file.
+func verifiedFiles(appPaths *paths.Paths, snapshotDir string) []verifiedFile {
+	var files []verifiedFile
+	for _, file := range (SnapshotterImpl{}).Files(appPaths, snapshotDir) {
+		files = append(files, verifiedFile{
+			Name:        filepath.Base(file.SnapshotPath),
+			WorkingPath: file.WorkingPath,
+			MissingOk:   file.MissingOk,
+		})
+	}
+	return files
+}
+
+func (snapshotter SnapshotterImpl) CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, opts CreateOptions) error {
 	taskRunner := runner.NewTaskRunner(ctx)
 	files := snapshotter.Files(appPaths, snapshotDir)
+	manifest := objectManifest{}
 	for _, file := range files {
 		taskRunner.Add(func() error {
-			err := copyFile(file.SnapshotPath, file.WorkingPath, file.CopyOnWrite, file.FileMode)
+			var err error
+			if opts.Deduplicate {
+				err = storeObject(ctx, manifest, snapshotDir, file)
+			} else {
+				_, err = copyFile(ctx, file.SnapshotPath, file.WorkingPath, file.CopyOnWrite, file.FileMode, nil)
+			}
 			if errors.Is(err, os.ErrNotExist) && file.MissingOk {
 				return nil
 			} else if err != nil {
@@ -111,52 +218,375 @@ func (snapshotter SnapshotterImpl) CreateFiles(ctx context.Context, appPaths *pa
 		})
 	}
 
-	// Create complete.txt file. This is done last because its presence
-	// signifies a complete and valid snapshot.
 	taskRunner.Add(func() error {
-		completeFilePath := filepath.Join(snapshotDir, completeFileName)
-		if err := os.WriteFile(completeFilePath, []byte(completeFileContents), 0o644); err != nil {
-			return fmt.Errorf("failed to write %q: %w", completeFileName, err)
-		}
-		return nil
+		return writeCompleteFile(snapshotDir)
 	})
 
 	return taskRunner.Wait()
 }
 
-// Restores the files from their location in a snapshot directory
-// to their working location.
-func (snapshotter SnapshotterImpl) RestoreFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string) error {
+// diskInUse returns a description of the process that is running the VM,
+// and so may be writing to its disk, or an empty string if there is none.
+// Lima leaves its PID files behind when it is killed, so they only count if
+// the process is still alive.
+func diskInUse(appPaths *paths.Paths) (string, error) {
+	for _, name := range limaPIDFiles {
+		path := filepath.Join(appPaths.Lima, "0", name)
+		contents, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+		if err != nil || pid <= 0 {
+			continue
+		}
+		// Signal 0 only checks whether the process exists; EPERM means it
+		// exists but belongs to someone else.
+		if err := syscall.Kill(pid, 0); err == nil || errors.Is(err, syscall.EPERM) {
+			return fmt.Sprintf("process %d (from %s)", pid, name), nil
+		}
+	}
+	return "", nil
+}
+
+// storeObject puts the contents of a file in the object store, unless an
+// identical object is already there, and adds it to the manifest of the
+// snapshot. The manifest is written right away, so that the object is
+// referred to before the next one is stored; tasks run one at a time, so the
+// manifest needs no locking.
+func storeObject(ctx context.Context, manifest objectManifest, snapshotDir string, file snapshotFile) error {
+	objectsDir := objectsDirPath(snapshotDir)
+	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create objects directory: %w", err)
+	}
+	tempFile, err := os.CreateTemp(objectsDir, ".incoming-*")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	_ = tempFile.Close()
+	defer os.Remove(tempPath)
+	// Checksum plain copies as they are made, rather than reading the
+	// object again afterwards.
+	hash := sha256.New()
+	cloned, err := copyFile(ctx, tempPath, file.WorkingPath, file.CopyOnWrite, 0o600, func(reader io.Reader) io.Reader {
+		return io.TeeReader(reader, hash)
+	})
+	if err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if cloned {
+		if checksum, err = checksumFile(ctx, tempPath, 0); err != nil {
+			return err
+		}
+	}
+	path := objectPath(objectsDir, checksum)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		if err := renameFile(tempPath, path); err != nil {
+			return fmt.Errorf("failed to store object: %w", err)
+		}
+		if err := syncDir(objectsDir); err != nil {
+			return err
+		}
+	}
+	// Mark the object as used, so that it isn't collected before the
+	// manifest refers to it; clones keep the time of their source.
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return fmt.Errorf("failed to store object: %w", err)
+	}
+	manifest[filepath.Base(file.SnapshotPath)] = checksum
+	return manifest.write(snapshotDir)
+}
+
+// Restores the files from their location in a snapshot directory to their
+// working location, one component at a time: the files of a component are
+// copied to staging files next to their working files, and only once all of
+// them are copied are they renamed into place. If the restore fails, the
+// components restored so far are kept, and the files of the others are
+// removed. With opts.Components, the other components are left as they are,
+// and with opts.MinimalChanges, so are the files that match the snapshot.
+// When there is a journal, the files staged so far are kept too, so that the
+// restore can be resumed.
+func (snapshotter SnapshotterImpl) RestoreFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, opts RestoreOptions, journal *restoreJournal) error {
+	manifest, err := readObjectManifest(snapshotDir)
+	if err != nil {
+		return err
+	}
 	taskRunner := runner.NewTaskRunner(ctx)
 	files := snapshotter.Files(appPaths, snapshotDir)
-	for _, file := range files {
-		taskRunner.Add(func() error {
-			filename := filepath.Base(file.WorkingPath)
-			snapshotPath := file.SnapshotPath
-			// Older snapshots stored the VM disk under Lima's legacy filenames;
-			// fall back to them so those snapshots stay restorable.
-			if file.LegacySnapshotPath != "" {
-				if _, statErr := os.Stat(snapshotPath); errors.Is(statErr, os.ErrNotExist) {
-					snapshotPath = file.LegacySnapshotPath
-				}
+	// The checksums of the files of the snapshot that are known without
+	// reading them, by working path.
+	checksums := make(map[string]string)
+	if manifest != nil {
+		// The files of deduplicated snapshots are restored from their
+		// objects; files the manifest doesn't list are not in the snapshot.
+		for i, file := range files {
+			name := filepath.Base(file.SnapshotPath)
+			files[i].SnapshotPath = manifest.snapshotFilePath(snapshotDir, name)
+			files[i].LegacySnapshotPath = ""
+			if checksum, ok := manifest[name]; ok {
+				checksums[file.WorkingPath] = checksum
 			}
-			err := copyFile(file.WorkingPath, snapshotPath, file.CopyOnWrite, file.FileMode)
-			if errors.Is(err, os.ErrNotExist) && file.MissingOk {
-				if err := os.RemoveAll(file.WorkingPath); err != nil {
-					return fmt.Errorf("failed to remove %q: %w", filename, err)
-				}
-			} else if err != nil {
-				return fmt.Errorf("failed to restore %q: %w", filename, err)
+		}
+	}
+	// Only the files of the components being restored are touched, even if
+	// the restore fails.
+	partial := len(opts.Components) > 0
+	if partial {
+		files = slices.DeleteFunc(files, func(file snapshotFile) bool {
+			return !slices.Contains(opts.Components, file.Component)
+		})
+	}
+	var unchanged map[string]bool
+	if opts.MinimalChanges {
+		unchanged, err = unchangedFiles(ctx, files, checksums, opts.RateLimit)
+		if err != nil {
+			return err
+		}
+	}
+	var restored []string
+	failed := ""
+	for _, component := range groupComponents(files) {
+		taskRunner.Add(func() error {
+			failed = component
+			if err := restoreComponent(ctx, files, component, opts.RateLimit, unchanged, journal); err != nil {
+				return err
 			}
+			failed = ""
+			restored = append(restored, component)
 			return nil
 		})
 	}
 	if err := taskRunner.Wait(); err != nil {
+		if failed != "" {
+			err = &ComponentRestoreError{Component: failed, Restored: restored, Err: err}
+		}
+		// The files to keep: those of the restored components, and those
+		// staged for resuming.
+		keep := make(map[string]string)
+		if journal != nil {
+			restored = journal.Components
+			for path, checksum := range journal.Restored {
+				if strings.HasPrefix(filepath.Base(path), stagingFilePrefix) {
+					keep[path] = checksum
+				}
+			}
+		}
 		for _, file := range files {
+			if slices.Contains(restored, file.Component) {
+				keep[file.WorkingPath] = ""
+				continue
+			}
 			_ = os.Remove(file.WorkingPath)
+			if _, ok := keep[stagingFilePath(file.WorkingPath)]; !ok {
+				_ = os.Remove(stagingFilePath(file.WorkingPath))
+			}
+		}
+		if !partial {
+			removeUnrestored(appPaths.Lima, keep)
+		}
+		if journal == nil {
+			return fmt.Errorf("%w: %w", ErrDataReset, err)
+		}
+		// Files of components that are not restored may be listed from an
+		// earlier attempt; they were removed, so they are restored again.
+		for path := range journal.Restored {
+			if _, ok := keep[path]; !ok {
+				delete(journal.Restored, path)
+			}
+		}
+		if saveErr := journal.save(); saveErr != nil {
+			err = errors.Join(err, saveErr)
 		}
-		_ = os.RemoveAll(appPaths.Lima)
-		return fmt.Errorf("%w: %w", ErrDataReset, err)
+		return fmt.Errorf("%w (%w): %w", ErrDataReset, ErrRestoreIncomplete, err)
 	}
 	return nil
 }
+
+// groupComponents returns the components of the files, in the order of their
+// first files.
+func groupComponents(files []snapshotFile) []string {
+	var components []string
+	for _, file := range files {
+		if !slices.Contains(components, file.Component) {
+			components = append(components, file.Component)
+		}
+	}
+	return components
+}
+
+// stagingFilePath returns the path a working file is staged at while its
+// component is restored.
+func stagingFilePath(workingPath string) string {
+	return filepath.Join(filepath.Dir(workingPath), stagingFilePrefix+filepath.Base(workingPath))
+}
+
+// restoreComponent restores the files of the given component: it stages
+// them all, then renames them into place. The unchanged files, by working
+// path, are left alone, and files the journal lists as restored or staged,
+// and that are unchanged since, are not copied again.
+func restoreComponent(ctx context.Context, files []snapshotFile, component string, rateLimit int64, unchanged map[string]bool, journal *restoreJournal) error {
+	type stagedFile struct {
+		workingPath string
+		stagingPath string
+		checksum    string
+	}
+	var staged []stagedFile
+	for _, file := range files {
+		if file.Component != component || unchanged[file.WorkingPath] {
+			continue
+		}
+		filename := filepath.Base(file.WorkingPath)
+		stagingPath := stagingFilePath(file.WorkingPath)
+		if journal != nil {
+			restored, err := journal.isRestored(ctx, file.WorkingPath, rateLimit)
+			if err != nil {
+				return fmt.Errorf("failed to verify restored %q: %w", filename, err)
+			} else if restored {
+				continue
+			}
+			restored, err = journal.isRestored(ctx, stagingPath, rateLimit)
+			if err != nil {
+				return fmt.Errorf("failed to verify staged %q: %w", filename, err)
+			} else if restored {
+				staged = append(staged, stagedFile{file.WorkingPath, stagingPath, journal.Restored[stagingPath]})
+				continue
+			}
+		}
+		stagingFile := file
+		stagingFile.WorkingPath = stagingPath
+		checksum, err := restoreFile(ctx, stagingFile, rateLimit)
+		if err != nil {
+			return err
+		}
+		if journal != nil {
+			if err := journal.markRestored(stagingPath, checksum); err != nil {
+				return err
+			}
+		}
+		staged = append(staged, stagedFile{file.WorkingPath, stagingPath, checksum})
+	}
+	for _, file := range staged {
+		if file.checksum == "" {
+			// The snapshot does not include the file.
+			if err := os.RemoveAll(file.workingPath); err != nil {
+				return fmt.Errorf("failed to remove %q: %w", filepath.Base(file.workingPath), err)
+			}
+		} else if err := renameFile(file.stagingPath, file.workingPath); err != nil {
+			return fmt.Errorf("failed to move %q into place: %w", filepath.Base(file.workingPath), err)
+		}
+		if journal != nil {
+			delete(journal.Restored, file.stagingPath)
+			journal.Restored[file.workingPath] = file.checksum
+		}
+	}
+	if journal == nil {
+		return nil
+	}
+	if !slices.Contains(journal.Components, component) {
+		journal.Components = append(journal.Components, component)
+	}
+	return journal.save()
+}
+
+// unchangedFiles returns the working paths of the files that already match
+// the snapshot: they have the same contents, or are missing from both. Files
+// of different sizes are known to differ without reading them; for the
+// others, the snapshot files are only read if their checksums, by working
+// path, are not already known.
+func unchangedFiles(ctx context.Context, files []snapshotFile, checksums map[string]string, rateLimit int64) (map[string]bool, error) {
+	unchanged := make(map[string]bool)
+	for _, file := range files {
+		filename := filepath.Base(file.WorkingPath)
+		snapshotPath := file.SnapshotPath
+		snapshotInfo, err := os.Stat(snapshotPath)
+		if errors.Is(err, os.ErrNotExist) && file.LegacySnapshotPath != "" {
+			snapshotPath = file.LegacySnapshotPath
+			snapshotInfo, err = os.Stat(snapshotPath)
+		}
+		missing := errors.Is(err, os.ErrNotExist) && file.MissingOk
+		if err != nil && !missing {
+			return nil, fmt.Errorf("failed to compare %q to the snapshot: %w", filename, err)
+		}
+		workingInfo, err := os.Stat(file.WorkingPath)
+		if errors.Is(err, os.ErrNotExist) {
+			if missing {
+				unchanged[file.WorkingPath] = true
+			}
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to compare %q to the snapshot: %w", filename, err)
+		}
+		if missing || !workingInfo.Mode().IsRegular() || workingInfo.Size() != snapshotInfo.Size() {
+			continue
+		}
+		want, ok := checksums[file.WorkingPath]
+		if !ok {
+			if want, err = checksumFile(ctx, snapshotPath, rateLimit); err != nil {
+				return nil, fmt.Errorf("failed to compare %q to the snapshot: %w", filename, err)
+			}
+		}
+		got, err := checksumFile(ctx, file.WorkingPath, rateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %q to the snapshot: %w", filename, err)
+		}
+		unchanged[file.WorkingPath] = got == want
+	}
+	return unchanged, nil
+}
+
+// restoreFile copies a single file from the snapshot to its working location,
+// and returns the checksum of the restored file; the checksum is empty if the
+// file was removed because the snapshot does not include it.
+func restoreFile(ctx context.Context, file snapshotFile, rateLimit int64) (string, error) {
+	filename := filepath.Base(file.WorkingPath)
+	snapshotPath := file.SnapshotPath
+	// Older snapshots stored the VM disk under Lima's legacy filenames;
+	// fall back to them so those snapshots stay restorable.
+	if file.LegacySnapshotPath != "" {
+		if _, statErr := os.Stat(snapshotPath); errors.Is(statErr, os.ErrNotExist) {
+			snapshotPath = file.LegacySnapshotPath
+		}
+	}
+	// Checksum plain copies as they are made, rather than reading the
+	// file again afterwards.
+	hash := sha256.New()
+	cloned, err := copyFile(ctx, file.WorkingPath, snapshotPath, file.CopyOnWrite, file.FileMode, func(reader io.Reader) io.Reader {
+		return io.TeeReader(newThrottledReader(ctx, reader, rateLimit), hash)
+	})
+	if errors.Is(err, os.ErrNotExist) && file.MissingOk {
+		if err := os.RemoveAll(file.WorkingPath); err != nil {
+			return "", fmt.Errorf("failed to remove %q: %w", filename, err)
+		}
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to restore %q: %w", filename, err)
+	}
+	if cloned {
+		checksum, err := checksumFile(ctx, file.WorkingPath, rateLimit)
+		if err != nil {
+			return "", fmt.Errorf("failed to restore %q: %w", filename, err)
+		}
+		return checksum, nil
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// removeUnrestored removes everything under dir that is not listed in
+// restored, so that the partially restored state contains nothing but files
+// from the snapshot.
+func removeUnrestored(dir string, restored map[string]string) {
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if _, ok := restored[path]; !ok {
+			_ = os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -3,9 +3,9 @@ package snapshot
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/paths"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/rdctl/pkg/runner"
@@ -20,6 +20,47 @@ type wslDistro struct {
 	WorkingDirPath string
 }
 
+// The component of the WSL distros; the other component is the settings,
+// componentSettings.
+const componentWSL = "wsl"
+
+// The component holding the data of the VM, which is migrated when restored;
+// see Manager.componentMigrations.
+const dataComponent = componentWSL
+
+// RestorableComponents returns the components of a snapshot, as named in
+// RestoreOptions.Components, in the order they are restored.
+func RestorableComponents() []string {
+	return []string{componentSettings, componentWSL}
+}
+
+// estimateSources returns the files EstimateSize sizes: the settings, and the
+// disks of the WSL distros. The exports of the distros, which is what
+// snapshots hold, are usually smaller than their disks, which don't shrink
+// as files are removed from them.
+func estimateSources(appPaths *paths.Paths) []sizedSource {
+	sources := []sizedSource{{Path: filepath.Join(appPaths.Config, "settings.json")}}
+	for _, distro := range (SnapshotterImpl{}).WSLDistros(appPaths) {
+		sources = append(sources, sizedSource{Path: filepath.Join(distro.WorkingDirPath, "ext4.vhdx")})
+	}
+	return sources
+}
+
+// Restores import the WSL distros from archives, which can be neither rate
+// limited nor resumed part way.
+const resumableRestore = false
+
+// Snapshots hold WSL exports, which differ each time even if the distro
+// hasn't changed, so there is nothing to gain from deduplicating them.
+const deduplicatedSnapshots = false
+
+// WSL exports are written by wsl.exe, and always copied.
+const copyOnWriteCopies = false
+
+// The exported distros can't be compared to the distros they would be
+// imported as, so restores always import them.
+const minimalRestore = false
+
 // SnapshotterImpl also works as a *Manager receiver
 type SnapshotterImpl struct {
 	wsl.WSL
@@ -42,12 +83,19 @@ func (snapshotter SnapshotterImpl) WSLDistros(appPaths *paths.Paths) []wslDistro
 // that may speed up the process of copying a file, but they appear to require
 // loading DLL's. This approach works fine for copying smaller files, but if
 // we need to copy big files it may be worth the complexity to use the syscall.
-func copyFile(dst, src string) error {
+//
+// The destination is flushed, and checked to have the size of the source, so
+// that failed writes to network shares are caught.
+func copyFile(ctx context.Context, dst, src string) error {
 	srcFd, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer srcFd.Close()
+	srcInfo, err := srcFd.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
 		return fmt.Errorf("failed to create destination parent dir: %w", err)
 	}
@@ -55,11 +103,11 @@ func copyFile(dst, src string) error {
 	if err != nil {
 		return fmt.Errorf("failed to open destination file: %w", err)
 	}
-	defer dstFd.Close()
-	if _, err := io.Copy(dstFd, srcFd); err != nil {
+	if _, err := copyData(ctx, dstFd, srcFd, srcFd, dstFd); err != nil {
+		_ = dstFd.Close()
 		return fmt.Errorf("failed to copy contents of src to dst: %w", err)
 	}
-	return nil
+	return finishFile(dstFd, srcInfo.Size())
 }
 
 func NewSnapshotterImpl() SnapshotterImpl {
@@ -68,7 +116,34 @@ func NewSnapshotterImpl() SnapshotterImpl {
 	}
 }
 
-func (snapshotter SnapshotterImpl) CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string) error {
+// requiredSnapshotFiles lists the files a complete snapshot must contain; each
+// entry lists the paths the file may be found at.
+func requiredSnapshotFiles(snapshotDir string) [][]string {
+	required := [][]string{{filepath.Join(snapshotDir, "settings.json")}}
+	for _, distro := range (SnapshotterImpl{}).WSLDistros(&paths.Paths{}) {
+		required = append(required, []string{filepath.Join(snapshotDir, distro.Name+".tar")})
+	}
+	return required
+}
+
+// diskInUse returns an empty string, as the disks of the WSL distros are
+// never read directly: wsl.exe exports them instead.
+func diskInUse(_ *paths.Paths) (string, error) {
+	return "", nil
+}
+
+// verifiedFiles lists the files verifySnapshot checks. The exported distros
+// can't be compared to the disks they were exported from, so only
+// settings.json is checked by checksum.
+func verifiedFiles(appPaths *paths.Paths, _ string) []verifiedFile {
+	files := []verifiedFile{{Name: "settings.json", WorkingPath: filepath.Join(appPaths.Config, "settings.json")}}
+	for _, distro := range (SnapshotterImpl{}).WSLDistros(appPaths) {
+		files = append(files, verifiedFile{Name: distro.Name + ".tar"})
+	}
+	return files
+}
+
+func (snapshotter SnapshotterImpl) CreateFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, _ CreateOptions) error {
 	taskRunner := runner.NewTaskRunner(ctx)
 
 	// export WSL distros to snapshot directory
@@ -78,7 +153,9 @@ func (snapshotter SnapshotterImpl) CreateFiles(ctx context.Context, appPaths *pa
 			if err := snapshotter.ExportDistro(ctx, distro.Name, snapshotDistroPath); err != nil {
 				return fmt.Errorf("failed to export WSL distro %q: %w", distro.Name, err)
 			}
-			return nil
+			// wsl.exe writes the archive; make sure it reached the disk
+			// (or share) before the snapshot is marked complete.
+			return syncFile(snapshotDistroPath)
 		})
 	}
 
@@ -86,62 +163,67 @@ func (snapshotter SnapshotterImpl) CreateFiles(ctx context.Context, appPaths *pa
 	taskRunner.Add(func() error {
 		workingSettingsPath := filepath.Join(appPaths.Config, "settings.json")
 		snapshotSettingsPath := filepath.Join(snapshotDir, "settings.json")
-		if err := copyFile(snapshotSettingsPath, workingSettingsPath); err != nil {
+		if err := copyFile(ctx, snapshotSettingsPath, workingSettingsPath); err != nil {
 			return fmt.Errorf("failed to copy %q to snapshot directory: %w", workingSettingsPath, err)
 		}
 		return nil
 	})
 
-	// Create complete.txt file. This is done last because its presence
-	// signifies a complete and valid snapshot.
 	taskRunner.Add(func() error {
-		completeFilePath := filepath.Join(snapshotDir, completeFileName)
-		if err := os.WriteFile(completeFilePath, []byte(completeFileContents), 0o644); err != nil {
-			return fmt.Errorf("failed to write %q: %w", completeFileName, err)
-		}
-		return nil
+		return writeCompleteFile(snapshotDir)
 	})
 
 	return taskRunner.Wait()
 }
 
-func (snapshotter SnapshotterImpl) RestoreFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string) error {
+func (snapshotter SnapshotterImpl) RestoreFiles(ctx context.Context, appPaths *paths.Paths, snapshotDir string, opts RestoreOptions, _ *restoreJournal) error {
 	tr := runner.NewTaskRunner(ctx)
+	restoring := func(component string) bool {
+		return len(opts.Components) == 0 || slices.Contains(opts.Components, component)
+	}
 
-	// unregister WSL distros
-	tr.Add(func() error {
-		if err := snapshotter.UnregisterDistros(ctx); err != nil {
-			return fmt.Errorf("failed to unregister WSL distros: %w", err)
-		}
-		return nil
-	})
-
-	// restore WSL distros
-	for _, distro := range snapshotter.WSLDistros(appPaths) {
+	if restoring(componentWSL) {
+		// unregister WSL distros
 		tr.Add(func() error {
-			snapshotDistroPath := filepath.Join(snapshotDir, distro.Name+".tar")
-			if err := os.MkdirAll(distro.WorkingDirPath, 0o755); err != nil {
-				return fmt.Errorf("failed to create install directory for distro %q: %w", distro.Name, err)
-			}
-			if err := snapshotter.ImportDistro(ctx, distro.Name, distro.WorkingDirPath, snapshotDistroPath); err != nil {
-				return fmt.Errorf("failed to import WSL distro %q: %w", distro.Name, err)
+			if err := snapshotter.UnregisterDistros(ctx); err != nil {
+				return fmt.Errorf("failed to unregister WSL distros: %w", err)
 			}
 			return nil
 		})
+
+		// restore WSL distros
+		for _, distro := range snapshotter.WSLDistros(appPaths) {
+			tr.Add(func() error {
+				snapshotDistroPath := filepath.Join(snapshotDir, distro.Name+".tar")
+				if err := os.MkdirAll(distro.WorkingDirPath, 0o755); err != nil {
+					return fmt.Errorf("failed to create install directory for distro %q: %w", distro.Name, err)
+				}
+				if err := snapshotter.ImportDistro(ctx, distro.Name, distro.WorkingDirPath, snapshotDistroPath); err != nil {
+					return fmt.Errorf("failed to import WSL distro %q: %w", distro.Name, err)
+				}
+				return nil
+			})
+		}
 	}
 
 	// copy settings.json back to its working location
 	workingSettingsPath := filepath.Join(appPaths.Config, "settings.json")
 	snapshotSettingsPath := filepath.Join(snapshotDir, "settings.json")
-	tr.Add(func() error {
-		if err := copyFile(workingSettingsPath, snapshotSettingsPath); err != nil {
-			return fmt.Errorf("failed to restore %q: %w", workingSettingsPath, err)
-		}
-		return nil
-	})
+	if restoring(componentSettings) {
+		tr.Add(func() error {
+			if err := copyFile(ctx, workingSettingsPath, snapshotSettingsPath); err != nil {
+				return fmt.Errorf("failed to restore %q: %w", workingSettingsPath, err)
+			}
+			return nil
+		})
+	}
 	if err := tr.Wait(); err != nil {
-		_ = os.Remove(workingSettingsPath)
-		_ = snapshotter.UnregisterDistros(ctx)
+		if restoring(componentSettings) {
+			_ = os.Remove(workingSettingsPath)
+		}
+		if restoring(componentWSL) {
+			_ = snapshotter.UnregisterDistros(ctx)
+		}
 		return fmt.Errorf("%w: %w", ErrDataReset, err)
 	}
 	return nil
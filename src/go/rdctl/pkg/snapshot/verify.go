@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrVerificationFailed is returned by CreateWithOptions when
+// CreateOptions.VerifyAfterCreate is set and the snapshot doesn't match the
+// files it was created from.
+var ErrVerificationFailed = errors.New("snapshot verification failed")
+
+// Verification is the result of verifying a snapshot once it was created;
+// see CreateOptions.VerifyAfterCreate.
+type Verification struct {
+	// The number of files found in the snapshot.
+	Files int `json:"files"`
+	// The number of those whose contents were compared to the working files
+	// by checksum; the others, such as exported WSL distros, can only be
+	// checked to exist.
+	Checksummed int `json:"checksummed"`
+}
+
+// verifiedFile is a file checked by verifySnapshot.
+type verifiedFile struct {
+	// The name of the file in the snapshot directory.
+	Name string
+	// The working file the snapshot file is a copy of; empty if the
+	// snapshot file is not a plain copy, and can't be compared.
+	WorkingPath string
+	// Whether the file is left out of snapshots when the working file is
+	// missing.
+	MissingOk bool
+}
+
+// verifySnapshot checks that the snapshot in snapshotDir has all of its files,
+// and that the copies of the working files have the same contents, which the
+// backend being stopped keeps from changing.  The files of deduplicated
+// snapshots are read from the object store, and must also match the checksum
+// they are stored under.  It returns an error wrapping ErrVerificationFailed
+// for each problem found.
+func (manager *Manager) verifySnapshot(ctx context.Context, snapshotDir string) (Verification, error) {
+	var result Verification
+	manifest, err := readObjectManifest(snapshotDir)
+	if err != nil {
+		return result, err
+	}
+	var problems []error
+	for _, file := range verifiedFiles(manager.Paths, snapshotDir) {
+		path := manifest.snapshotFilePath(snapshotDir, file.Name)
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if file.MissingOk && !exists(file.WorkingPath) {
+				continue
+			}
+			problems = append(problems, fmt.Errorf("%w: %s is missing", ErrVerificationFailed, file.Name))
+			continue
+		} else if err != nil {
+			return result, fmt.Errorf("failed to check %s: %w", file.Name, err)
+		} else if !info.Mode().IsRegular() {
+			problems = append(problems, fmt.Errorf("%w: %s is not a regular file", ErrVerificationFailed, file.Name))
+			continue
+		}
+		result.Files++
+		if file.WorkingPath == "" {
+			continue
+		}
+		want, err := checksumFile(ctx, file.WorkingPath, 0)
+		if err != nil {
+			return result, fmt.Errorf("failed to checksum working copy of %s: %w", file.Name, err)
+		}
+		got, err := checksumFile(ctx, path, 0)
+		if err != nil {
+			return result, fmt.Errorf("failed to checksum %s: %w", file.Name, err)
+		}
+		result.Checksummed++
+		if stored, ok := manifest[file.Name]; ok && stored != want {
+			problems = append(problems, fmt.Errorf("%w: %s is stored as object %s, but its checksum is %s",
+				ErrVerificationFailed, file.Name, stored, want))
+		} else if got != want {
+			problems = append(problems, fmt.Errorf("%w: the checksum of %s is %s, but the working copy's is %s",
+				ErrVerificationFailed, file.Name, got, want))
+		}
+	}
+	return result, errors.Join(problems...)
+}
+
+// exists returns whether there is a file at path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,379 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot operations are done by separate rdctl processes, so there is no
+// process to subscribe to; Watch follows what they leave on disk instead. The
+// snapshots directory shows snapshots being created, changed and deleted, and
+// the operation logs show the steps of operations as they happen. Both are
+// cheap to check, as List only reads what changed.
+
+// EventType is the type of a snapshot Event.
+type EventType string
+
+const (
+	// A snapshot was created, and can be restored from.
+	EventCreated EventType = "created"
+	// A snapshot was deleted.
+	EventDeleted EventType = "deleted"
+	// The metadata of a snapshot changed, for example as it was protected
+	// or restored from.
+	EventUpdated EventType = "updated"
+	// A snapshot was restored from.
+	EventRestored EventType = "restored"
+	// An operation on a snapshot started.
+	EventStarted EventType = "started"
+	// An operation on a snapshot made progress; the message says how.
+	EventProgress EventType = "progress"
+	// An operation on a snapshot failed; the message says why.
+	EventFailed EventType = "failed"
+	// The snapshots could not be watched; Watch keeps trying, and reports
+	// the changes it missed once it can watch them again.
+	EventError EventType = "error"
+)
+
+// Event is a change to the snapshots, as sent by Watch.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// The ID and name of the snapshot; the name is empty if the snapshot
+	// is not known, as for an operation on a snapshot deleted since.
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// The snapshot, for created and updated events.
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+	// The operation ("create" or "restore") the event is part of, if any.
+	Operation string `json:"operation,omitempty"`
+	// The level of the message of a progress event: "info", "warning" or
+	// "error".
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+	// The number of progress events that were dropped before this one,
+	// as they were not received in time.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// WatchOptions modifies the behaviour of Manager.Watch.
+type WatchOptions struct {
+	// How often to check for changes; defaults to watchDefaultInterval.
+	Interval time.Duration
+}
+
+// The default interval between checks for changes.
+const watchDefaultInterval = 500 * time.Millisecond
+
+// The longest interval between attempts to watch the snapshots again, while
+// they are unavailable.
+const watchMaxRetryInterval = 10 * time.Second
+
+// The number of events Watch buffers for a slow receiver; tests replace it.
+var watchBufferSize = 64
+
+// Watch sends the changes to the snapshots on the returned channel, from now
+// until the context is done, when the channel is closed. Should the receiver
+// be slow, progress events are dropped rather than buffered without end; the
+// other events are never dropped, and the changes are checked for again once
+// they have been received.
+func (manager *Manager) Watch(ctx context.Context, opts WatchOptions) <-chan Event {
+	if opts.Interval <= 0 {
+		opts.Interval = watchDefaultInterval
+	}
+	watcher := &snapshotWatcher{
+		manager: manager,
+		events:  make(chan Event, watchBufferSize),
+		logs:    make(map[string]*watchedLog),
+	}
+	// Check once before returning, so that changes made after Watch returns
+	// are reported.
+	err := watcher.check(ctx)
+	go watcher.run(ctx, opts.Interval, err)
+	return watcher.events
+}
+
+// watchedLog is an operation log being followed.
+type watchedLog struct {
+	id        string
+	operation string
+	// The offset of the first line not read yet.
+	offset int64
+}
+
+type snapshotWatcher struct {
+	manager *Manager
+	events  chan Event
+	// The snapshots last seen, by ID; nil until they are first read.
+	snapshots map[string]Snapshot
+	// The names of all snapshot directories, including incomplete ones.
+	names map[string]string
+	// The operation logs being followed, by path.
+	logs map[string]*watchedLog
+	// The number of progress events dropped since an event was sent.
+	dropped int
+	// Whether an error event was sent since the last successful check.
+	failing bool
+}
+
+// run checks for changes at the given interval, until the context is done;
+// err is the result of the previous check.
+func (watcher *snapshotWatcher) run(ctx context.Context, interval time.Duration, err error) {
+	defer close(watcher.events)
+	retryInterval := interval
+	for {
+		wait := interval
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !watcher.failing {
+				watcher.failing = true
+				watcher.send(ctx, Event{Type: EventError, Message: err.Error()})
+			}
+			wait = retryInterval
+			retryInterval = min(retryInterval*2, watchMaxRetryInterval)
+		} else {
+			watcher.failing = false
+			retryInterval = interval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		err = watcher.check(ctx)
+	}
+}
+
+// check sends the events for what changed since the last check.
+func (watcher *snapshotWatcher) check(ctx context.Context) error {
+	all, err := watcher.manager.ListWithOptions(ListOptions{IncludeIncomplete: true})
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(all))
+	for _, snapshot := range all {
+		names[snapshot.ID] = snapshot.Name
+	}
+	complete, err := watcher.manager.List(false)
+	if err != nil {
+		return err
+	}
+	logPaths, err := watcher.manager.operationLogs()
+	if err != nil {
+		return err
+	}
+	watcher.names = names
+	current := make(map[string]Snapshot, len(complete))
+	for _, snapshot := range complete {
+		current[snapshot.ID] = snapshot
+	}
+	first := watcher.snapshots == nil
+	if first {
+		watcher.snapshots = current
+	}
+	if err := watcher.checkLogs(ctx, logPaths, first, current); err != nil {
+		return err
+	}
+	for id, snapshot := range current {
+		previous, ok := watcher.snapshots[id]
+		if !ok {
+			watcher.send(ctx, Event{Type: EventCreated, ID: id, Name: snapshot.Name, Snapshot: &snapshot})
+		} else if !reflect.DeepEqual(previous, snapshot) {
+			watcher.send(ctx, Event{Type: EventUpdated, ID: id, Name: snapshot.Name, Snapshot: &snapshot})
+		}
+	}
+	for id, snapshot := range watcher.snapshots {
+		if _, ok := current[id]; !ok {
+			watcher.send(ctx, Event{Type: EventDeleted, ID: id, Name: snapshot.Name})
+		}
+	}
+	watcher.snapshots = current
+	return ctx.Err()
+}
+
+// checkLogs sends the events for the lines added to the operation logs, given
+// the complete snapshots. On the first check, the logs that already exist are
+// only read from their end.
+func (watcher *snapshotWatcher) checkLogs(ctx context.Context, logPaths []string, first bool, complete map[string]Snapshot) error {
+	present := make(map[string]bool, len(logPaths))
+	for _, logPath := range logPaths {
+		present[logPath] = true
+		log, ok := watcher.logs[logPath]
+		if !ok {
+			id, operation, ok := parseOperationLogName(filepath.Base(logPath))
+			if !ok {
+				continue
+			}
+			log = &watchedLog{id: id, operation: operation}
+			if first {
+				if info, err := os.Stat(logPath); err == nil {
+					log.offset = info.Size()
+				}
+			}
+			watcher.logs[logPath] = log
+		}
+		lines, err := log.readLines(logPath)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			watcher.sendLogLine(ctx, log, line, complete)
+		}
+	}
+	// Logs are removed as newer ones are created.
+	for logPath := range watcher.logs {
+		if !present[logPath] {
+			delete(watcher.logs, logPath)
+		}
+	}
+	return nil
+}
+
+// readLines returns the complete lines added to the log since it was last
+// read; a line still being written is left for the next read.
+func (log *watchedLog) readLines(logPath string) ([]string, error) {
+	file, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot log: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(log.offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot log: %w", err)
+	}
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot log: %w", err)
+	}
+	end := bytes.LastIndexByte(contents, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	log.offset += int64(end + 1)
+	return strings.Split(string(contents[:end]), "\n"), nil
+}
+
+// sendLogLine sends the event for a line of an operation log, given the
+// complete snapshots.
+func (watcher *snapshotWatcher) sendLogLine(ctx context.Context, log *watchedLog, line string, complete map[string]Snapshot) {
+	level, message := parseLogLine(line)
+	if message == "" {
+		return
+	}
+	event := Event{ID: log.id, Name: watcher.names[log.id], Operation: log.operation}
+	switch {
+	case strings.HasPrefix(message, log.operation+" snapshot "):
+		// The first line of every log, which names the snapshot; it may
+		// not have metadata yet.
+		event.Type = EventStarted
+		if event.Name == "" {
+			quoted, err := strconv.QuotedPrefix(strings.TrimPrefix(message, log.operation+" snapshot "))
+			if err == nil {
+				event.Name, _ = strconv.Unquote(quoted)
+				watcher.names[log.id] = event.Name
+			}
+		}
+	case message == "done" && log.operation == "restore":
+		event.Type = EventRestored
+	case message == "done":
+		// Report the snapshot as created here, rather than when checking
+		// the snapshots, to keep the events of successive operations in
+		// order, unless it was reported already.
+		snapshot, ok := complete[log.id]
+		if _, reported := watcher.snapshots[log.id]; reported || !ok {
+			return
+		}
+		watcher.snapshots[log.id] = snapshot
+		event.Type = EventCreated
+		event.Snapshot = &snapshot
+	case level == "error" && strings.HasPrefix(message, "failed: "):
+		event.Type = EventFailed
+		event.Message = strings.TrimPrefix(message, "failed: ")
+	default:
+		event.Type = EventProgress
+		event.Level = level
+		event.Message = message
+		watcher.trySend(event)
+		return
+	}
+	watcher.send(ctx, event)
+}
+
+// send sends an event, waiting for it to be received.
+func (watcher *snapshotWatcher) send(ctx context.Context, event Event) {
+	event.Time = time.Now()
+	event.Dropped = watcher.dropped
+	select {
+	case watcher.events <- event:
+		watcher.dropped = 0
+	case <-ctx.Done():
+	}
+}
+
+// trySend sends an event if there is room for it, and drops it otherwise.
+func (watcher *snapshotWatcher) trySend(event Event) {
+	event.Time = time.Now()
+	event.Dropped = watcher.dropped
+	select {
+	case watcher.events <- event:
+		watcher.dropped = 0
+	default:
+		watcher.dropped++
+	}
+}
+
+// parseOperationLogName returns the snapshot ID and the operation from the
+// name of an operation log, as created by startOperationLog.
+func parseOperationLogName(name string) (id, operation string, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(name, ".log"), "_")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// parseLogLine returns the level and the message of a line of an operation
+// log, as written by logrus' text formatter; the message is empty if the line
+// has none.
+func parseLogLine(line string) (level, message string) {
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				break
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+		switch strings.TrimSpace(key) {
+		case "level":
+			level = value
+		case "msg":
+			message = value
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	if level == "warn" {
+		level = "warning"
+	}
+	return level, message
+}
@@ -0,0 +1,37 @@
+package snapshot
+
+import "testing"
+
+func TestParseLogLine(t *testing.T) {
+	testCases := []struct {
+		line    string
+		level   string
+		message string
+	}{
+		{`time="2026-01-02T03:04:05Z" level=info msg=done`, "info", "done"},
+		{`time="2026-01-02T03:04:05Z" level=info msg="restore snapshot \"my snapshot\" (1234)"`, "info", `restore snapshot "my snapshot" (1234)`},
+		{`time="2026-01-02T03:04:05Z" level=warning msg="data was reset; not restarting the backend"`, "warning", "data was reset; not restarting the backend"},
+		{`time="2026-01-02T03:04:05Z" level=error msg="failed: disk full"`, "error", "failed: disk full"},
+		{`time="2026-01-02T03:04:05Z" level=info`, "info", ""},
+		{`not a log line`, "", ""},
+		{``, "", ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.line, func(t *testing.T) {
+			level, message := parseLogLine(testCase.line)
+			if level != testCase.level || message != testCase.message {
+				t.Errorf("expected %q %q, got %q %q", testCase.level, testCase.message, level, message)
+			}
+		})
+	}
+}
+
+func TestParseOperationLogName(t *testing.T) {
+	id, operation, ok := parseOperationLogName("20260102T030405.000000000Z_0b0e6a8a-41a6-4c1c-9dd4-3bf4cd0e3a8c_restore.log")
+	if !ok || id != "0b0e6a8a-41a6-4c1c-9dd4-3bf4cd0e3a8c" || operation != "restore" {
+		t.Errorf("unexpected result %q %q %v", id, operation, ok)
+	}
+	if _, _, ok := parseOperationLogName("notes.log"); ok {
+		t.Errorf("parsed a log name without an ID")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/startup-profile/model"
@@ -21,18 +22,33 @@ func ParseWindowsGuestAgentLogs(ctx context.Context) ([]*model.Event, error) {
 	}
 
 	var results []*model.Event
+	// Older guest agents logged plain lines, newer ones log in the logrus text
+	// format.
 	matcher := regexp.MustCompile(`^(\d{4}/\d{2}/\d{2}\s+\d{2}:\d{2}:\d{2})\s+\[.*?\]\s+(.*)$`)
+	textMatcher := regexp.MustCompile(`^time="([^"]+)" level=\w+ msg=("(?:[^"\\]|\\.)*"|\S*)`)
 	for scanner.Scan() {
-		matches := matcher.FindStringSubmatch(scanner.Text())
-		if len(matches) != matcher.NumSubexp()+1 {
+		var name string
+		var timestamp time.Time
+		if matches := matcher.FindStringSubmatch(scanner.Text()); len(matches) == matcher.NumSubexp()+1 {
+			timestamp, err = time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing time: %q: %w", scanner.Text(), err)
+			}
+			name = matches[2]
+		} else if matches := textMatcher.FindStringSubmatch(scanner.Text()); len(matches) == textMatcher.NumSubexp()+1 {
+			timestamp, err = time.Parse(time.RFC3339, matches[1])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing time: %q: %w", scanner.Text(), err)
+			}
+			name = matches[2]
+			if unquoted, err := strconv.Unquote(name); err == nil {
+				name = unquoted
+			}
+		} else {
 			continue
 		}
-		timestamp, err := time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing time: %q: %w", scanner.Text(), err)
-		}
 		results = append(results, &model.Event{
-			Name:      matches[2],
+			Name:      name,
 			Category:  "guest-agent",
 			Phase:     model.EventPhaseInstant,
 			TimeStamp: timestamp,